@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Socket Activation Overview:
+--------------------------
+With systemd socket activation, systemd binds the listening sockets
+(ports below 1024 included) and passes them to the server already
+open, so the service itself never needs root or CAP_NET_BIND_SERVICE.
+It also holds the sockets while the service restarts, so connections
+queue in the kernel instead of being refused.
+
+systemd describes the sockets in three environment variables:
+
+	LISTEN_PID      PID the sockets are meant for
+	LISTEN_FDS      How many sockets, as file descriptors from 3 up
+	LISTEN_FDNAMES  Colon-separated names from FileDescriptorName=
+
+The variables are cleared once read so child processes don't try to
+claim the same sockets. Addresses name the sockets they want with
+"systemd:NAME"; a socket without FileDescriptorName= is named after
+its .socket unit, e.g. "systemd:chat.socket".
+*/
+
+// systemdPrefix marks an activated socket name in a listen address
+const systemdPrefix = "systemd:"
+
+// listenFDsStart is the first descriptor systemd passes
+const listenFDsStart = 3
+
+// systemdListeners returns the sockets systemd passed to this process, by name
+// It returns nil when the process was not socket activated
+func systemdListeners() (map[string][]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(key)
+	}
+
+	listeners := make(map[string][]net.Listener)
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		name := "unknown" // What systemd calls sockets it couldn't name
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// FileListener dups the descriptor, so the original is closed after
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %s (fd %d): %w", name, fd, err)
+		}
+		listeners[name] = append(listeners[name], l)
+	}
+	return listeners, nil
+}
+
+// activatedNames lists the names of activated sockets for error messages
+func activatedNames(listeners map[string][]net.Listener) []string {
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
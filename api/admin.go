@@ -0,0 +1,272 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chat-app/archive"
+	"chat-app/cluster"
+	"chat-app/keyring"
+	"chat-app/metering"
+	"chat-app/metrics"
+	"chat-app/permission"
+	"chat-app/storage"
+	"chat-app/tenant"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Admin API Overview:
+------------------
+Operator-only REST endpoints under /api/admin.
+Every request must carry the configured admin token:
+
+	Authorization: Bearer <CHAT_ADMIN_TOKEN>
+
+When no token is configured the admin API is disabled entirely
+rather than left open.
+*/
+
+// defaultTopRooms is how many rooms /rooms/top returns without ?limit
+const defaultTopRooms = 10
+
+// Page sizes for /connections
+const (
+	defaultConnectionPage = 100
+	maxConnectionPage     = 1000
+)
+
+// AdminDeps is everything the admin endpoints read from
+type AdminDeps struct {
+	Hub         *websockets.LocalHub
+	Store       storage.Store
+	Archives    *archive.Archiver      // Nil when archival is not configured
+	Cluster     *cluster.Cluster       // Nil when clustering is disabled
+	Meter       *metering.Meter        // Nil when metering is disabled
+	Tenants     *tenant.Registry       // Nil when tenants are disabled
+	Permissions *permission.Authorizer // Told when room permissions change
+	Keys        *keyring.Keyring       // Nil when encryption at rest is off
+	Exports     *ExportLinks           // Signs history export links
+	Token       func() string          // Bearer token, read per request so it can be rotated; empty disables the API
+}
+
+// RegisterAdmin mounts the admin endpoints on the router
+func RegisterAdmin(r gin.IRouter, deps AdminDeps) {
+	admin := r.Group("/api/admin", RequireAdminToken(deps.Token))
+	admin.GET("/rooms", listRooms(deps.Hub, deps.Store, true))
+	admin.GET("/rooms/top", topRooms(deps.Hub))
+	admin.GET("/rooms/:room", roomSnapshot(deps.Hub))
+	admin.GET("/connections", listConnections(deps.Hub))
+	admin.GET("/connections/:id", connection(deps.Hub))
+	admin.GET("/geo", geoBreakdown(deps.Hub))
+	admin.GET("/anomalies", anomalies(deps.Hub))
+	admin.DELETE("/anomalies/:subject/:key", liftAnomaly(deps.Hub))
+	admin.GET("/rooms/:room/settings", getRoomSettings(deps.Store))
+	admin.PUT("/rooms/:room/settings", putRoomSettings(deps.Store, deps.Permissions))
+	admin.PUT("/rooms/:room/archived", archiveRoom(deps.Hub, adminArchiver))
+	admin.DELETE("/rooms/:room/archived", unarchiveRoom(deps.Hub, adminArchiver))
+	admin.GET("/rooms/:room/archives", listArchives(deps.Archives))
+	admin.GET("/rooms/:room/archives/:name", getArchive(deps.Archives))
+	admin.GET("/rooms/:room/events", listEvents(deps.Store))
+	admin.GET("/rooms/:room/replay", replayRoom(deps.Store))
+	admin.GET("/announcements", listAnnouncements(deps.Store))
+	admin.POST("/announcements", createAnnouncement(deps.Store))
+	admin.POST("/tags/:tag/announce", announceTagged(deps.Hub))
+	admin.PUT("/users/:username/age-confirmed", setAgeConfirmed(deps.Store, true))
+	admin.DELETE("/users/:username/age-confirmed", setAgeConfirmed(deps.Store, false))
+	admin.GET("/announcements/:id", getAnnouncement(deps.Store))
+	admin.PUT("/announcements/:id", updateAnnouncement(deps.Store))
+	admin.DELETE("/announcements/:id", deleteAnnouncement(deps.Store))
+	admin.GET("/stickers", listStickerPacks(deps.Store))
+	admin.POST("/stickers", createStickerPack(deps.Store))
+	admin.GET("/stickers/:pack", getStickerPack(deps.Store))
+	admin.PUT("/stickers/:pack", updateStickerPack(deps.Store))
+	admin.DELETE("/stickers/:pack", deleteStickerPack(deps.Store))
+	admin.PUT("/stickers/:pack/:sticker", putSticker(deps.Store))
+	admin.DELETE("/stickers/:pack/:sticker", deleteSticker(deps.Store))
+	admin.GET("/dashboard", dashboard(deps.Hub))
+	admin.GET("/cluster", clusterView(deps.Cluster))
+	admin.POST("/drain", drain(deps.Hub, deps.Cluster))
+	admin.DELETE("/drain", resume(deps.Hub))
+	admin.POST("/rebalance", rebalance(deps.Hub, deps.Cluster))
+	admin.GET("/usage", usageReport(deps.Meter))
+	admin.GET("/tenants", listTenants(deps.Tenants, deps.Store))
+	admin.POST("/tenants", createTenant(deps.Tenants, deps.Store))
+	admin.GET("/tenants/:id", getTenant(deps.Tenants, deps.Store))
+	admin.PUT("/tenants/:id", updateTenant(deps.Tenants, deps.Store))
+	admin.POST("/tenants/:id/key", rotateTenantKey(deps.Tenants, deps.Store))
+	admin.DELETE("/tenants/:id", deleteTenant(deps.Tenants, deps.Store))
+	admin.POST("/rooms/:room/key", rotateRoomKey(deps.Keys))
+	admin.POST("/keys/rewrap", rewrapKeys(deps.Keys))
+	admin.POST("/rooms/:room/export", createExportLink(deps.Exports))
+	admin.GET("/backup", backup(deps.Store))
+	admin.POST("/restore", restore(deps.Store))
+}
+
+// RequireAdminToken rejects requests without the admin bearer token
+func RequireAdminToken(token func() string) gin.HandlerFunc {
+	return requireToken(token, "admin", "CHAT_ADMIN_TOKEN")
+}
+
+// requireToken rejects requests without token as their bearer token
+// An empty token disables the API, naming env as the setting to fix
+func requireToken(token func() string, api, env string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		want := token()
+		if want == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": api + " API disabled: " + env + " not set"})
+			return
+		}
+
+		got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid " + api + " token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// topRooms lists the busiest rooms
+// GET /api/admin/rooms/top?limit=10&by=rate|users|dropped
+func topRooms(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultTopRooms
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+				return
+			}
+			limit = n
+		}
+
+		by := c.DefaultQuery("by", websockets.SortByRate)
+		switch by {
+		case websockets.SortByRate, websockets.SortByUsers, websockets.SortByDropped:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "by must be one of rate, users, dropped"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"rooms": hub.TopRooms(limit, by)})
+	}
+}
+
+// roomSnapshot lists the users currently in a room
+// GET /api/admin/rooms/:room
+func roomSnapshot(hub websockets.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, hub.RoomSnapshot(c.Param("room")))
+	}
+}
+
+// listConnections lists connections with their metadata
+// GET /api/admin/connections?limit=100
+func listConnections(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := queryUint(c, "limit", defaultConnectionPage)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"connections": hub.Connections(int(min(limit, maxConnectionPage)))})
+	}
+}
+
+// connection finds a connected client by the ID in its logs and error frames
+// GET /api/admin/connections/:id
+func connection(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info, ok := hub.Connection(c.Param("id"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such connection on this node"})
+			return
+		}
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+// geoBreakdown shows where this node's connections come from
+// Countries are empty unless CHAT_GEOIP_DB is set
+// GET /api/admin/geo
+func geoBreakdown(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"countries": hub.GeoCounts()})
+	}
+}
+
+// anomalies lists flagged IPs and users and recent flags
+// GET /api/admin/anomalies
+func anomalies(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, hub.Anomalies())
+	}
+}
+
+// liftAnomaly ends a flag early; subject is "ip" or "user"
+// DELETE /api/admin/anomalies/:subject/:key
+func liftAnomaly(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject := c.Param("subject")
+		if subject != websockets.SubjectIP && subject != websockets.SubjectUser {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "subject must be ip or user"})
+			return
+		}
+		if !hub.LiftAnomaly(subject, c.Param("key")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no flag on " + subject + " " + c.Param("key")})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// dashboard returns current counts plus per-minute activity for the last hour
+// GET /api/admin/dashboard
+func dashboard(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients, rooms := hub.Counts()
+		buckets := metrics.Recent.Snapshot()
+
+		var totals metrics.Bucket
+		for _, b := range buckets {
+			totals.Connects += b.Connects
+			totals.Disconnects += b.Disconnects
+			totals.Messages += b.Messages
+			totals.Errors += b.Errors
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"current": gin.H{
+				"connections": clients,
+				"rooms":       rooms,
+			},
+			"last_hour": gin.H{
+				"connects":    totals.Connects,
+				"disconnects": totals.Disconnects,
+				"messages":    totals.Messages,
+				"errors":      totals.Errors,
+			},
+			"bucket_seconds": 60,
+			"buckets":        buckets,
+		})
+	}
+}
+
+// clusterView lists the nodes this node knows about and their health
+// GET /api/admin/cluster
+func clusterView(c *cluster.Cluster) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if c == nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "clustering is not enabled"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"node":  c.Name(),
+			"nodes": c.Members(),
+		})
+	}
+}
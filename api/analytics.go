@@ -0,0 +1,103 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"chat-app/metering"
+	"chat-app/permission"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Analytics API Overview:
+----------------------
+Holders of a room's analytics capability (moderators, unless the
+room's permissions grant it) read how engaged the room has been over
+a rolling window (see metering/analytics.go):
+
+	GET /api/rooms/:room/analytics?username=alice&window=168h&tz=Europe/Berlin
+	 -> 200 {"room": "lobby", "messages": 1200, "messages_per_hour": 7.14,
+	         "unique_chatters": 48, "peak_connections": 37, "peak_at": "...",
+	         "busiest_hours": [{"hour": 14, "messages": 310}, ...], "hours": [...]}
+
+window defaults to a week and may be up to maxAnalyticsWindow, which
+records older than the metering retention won't fill anyway. tz is
+the IANA zone busiest_hours are given in, UTC by default. The API
+returns 404 when metering is not enabled.
+*/
+
+const (
+	defaultAnalyticsWindow = 7 * 24 * time.Hour
+	maxAnalyticsWindow     = 90 * 24 * time.Hour
+)
+
+// AnalyticsDeps is everything the analytics endpoint needs
+type AnalyticsDeps struct {
+	Meter      *metering.Meter        // Nil when metering is disabled
+	Authorizer *permission.Authorizer // Checks the analytics capability
+	Auth       websockets.AuthFunc    // Optional; the same hook that guards the WebSocket
+}
+
+// RegisterAnalytics mounts room analytics
+func RegisterAnalytics(r gin.IRouter, deps AnalyticsDeps) {
+	r.GET("/api/rooms/:room/analytics", roomAnalytics(deps))
+}
+
+// roomAnalytics reports a room's activity over a rolling window
+// GET /api/rooms/:room/analytics
+func roomAnalytics(deps AnalyticsDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if deps.Meter == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "metering is not enabled"})
+			return
+		}
+
+		// Step 1: Who is asking
+		room, username := c.Param("room"), c.Query("username")
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+			return
+		}
+		if deps.Auth != nil {
+			verified, err := deps.Auth(c, room, username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			username = verified
+		}
+		if !authorize(c, deps.Authorizer, room, username, storage.CapAnalytics) {
+			return
+		}
+
+		// Step 2: The window and the zone hours of the day are in
+		window := defaultAnalyticsWindow
+		if v := c.Query("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > maxAnalyticsWindow {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a positive duration of at most 2160h, such as 24h"})
+				return
+			}
+			window = d
+		}
+		loc, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tz must be an IANA time zone, such as Europe/Berlin"})
+			return
+		}
+
+		to := time.Now()
+		analytics, err := deps.Meter.RoomAnalytics(c.Request.Context(), room, to.Add(-window), to, loc)
+		if err != nil {
+			log.Printf("Analytics for %s failed: %v", room, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load analytics"})
+			return
+		}
+		c.JSON(http.StatusOK, analytics)
+	}
+}
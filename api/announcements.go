@@ -0,0 +1,173 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/schedule"
+	"chat-app/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Announcements API Overview:
+--------------------------
+Recurring announcements, mounted under the admin API:
+
+	GET    /api/admin/announcements
+	POST   /api/admin/announcements
+	       {"rooms": ["team"], "schedule": "0 9 * * mon-fri",
+	        "timezone": "Europe/Berlin", "content": "Standup in 5 minutes!"}
+	       ("tags": ["incident"] instead of, or with, rooms: every room tagged so)
+	GET    /api/admin/announcements/:id
+	PUT    /api/admin/announcements/:id   (same body as POST)
+	DELETE /api/admin/announcements/:id
+
+Responses include next_run, when the announcement fires next. The
+scheduler in the schedule package delivers them.
+*/
+
+// announcementRequest is the body accepted by POST and PUT
+type announcementRequest struct {
+	Rooms     []string `json:"rooms"`
+	Tags      []string `json:"tags"`
+	Schedule  string   `json:"schedule"`
+	Timezone  string   `json:"timezone"`
+	From      string   `json:"from"`
+	Content   string   `json:"content"`
+	CreatedBy string   `json:"created_by"`
+}
+
+// announcementView is an announcement with its next run
+type announcementView struct {
+	storage.Announcement
+	NextRun *time.Time `json:"next_run,omitempty"` // Absent if it never fires again
+}
+
+// listAnnouncements lists every announcement, oldest first
+// GET /api/admin/announcements
+func listAnnouncements(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		posts, err := store.Announcements(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load announcements"})
+			return
+		}
+		now := time.Now()
+		views := make([]announcementView, 0, len(posts))
+		for _, a := range posts {
+			views = append(views, viewAnnouncement(a, now))
+		}
+		c.JSON(http.StatusOK, gin.H{"announcements": views})
+	}
+}
+
+// getAnnouncement returns one announcement
+// GET /api/admin/announcements/:id
+func getAnnouncement(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a, err := store.GetAnnouncement(c.Request.Context(), c.Param("id"))
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "announcement not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load announcement"})
+			return
+		}
+		c.JSON(http.StatusOK, viewAnnouncement(a, time.Now()))
+	}
+}
+
+// createAnnouncement adds an announcement
+// POST /api/admin/announcements
+func createAnnouncement(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now().UTC()
+		a := storage.Announcement{ID: newAnnouncementID(), CreatedAt: now}
+		saveAnnouncement(c, store, a, now, http.StatusCreated)
+	}
+}
+
+// updateAnnouncement replaces an announcement, restarting its schedule
+// PUT /api/admin/announcements/:id
+func updateAnnouncement(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a, err := store.GetAnnouncement(c.Request.Context(), c.Param("id"))
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "announcement not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load announcement"})
+			return
+		}
+		saveAnnouncement(c, store, a, time.Now().UTC(), http.StatusOK)
+	}
+}
+
+// saveAnnouncement fills a from the request body, validates and stores it
+func saveAnnouncement(c *gin.Context, store storage.Store, a storage.Announcement, now time.Time, status int) {
+	var req announcementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+		return
+	}
+
+	a.Rooms = req.Rooms
+	a.Tags = req.Tags
+	a.Schedule = req.Schedule
+	a.Timezone = req.Timezone
+	a.From = req.From
+	a.Content = req.Content
+	a.CreatedBy = req.CreatedBy
+	a.UpdatedAt = now
+	if err := a.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := schedule.Parse(a.Schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := store.SaveAnnouncement(c.Request.Context(), a); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save announcement"})
+		return
+	}
+	c.JSON(status, viewAnnouncement(a, now))
+}
+
+// deleteAnnouncement stops an announcement
+// DELETE /api/admin/announcements/:id
+func deleteAnnouncement(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := store.DeleteAnnouncement(c.Request.Context(), c.Param("id"))
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "announcement not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete announcement"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// viewAnnouncement adds when a next fires after now
+func viewAnnouncement(a storage.Announcement, now time.Time) announcementView {
+	view := announcementView{Announcement: a}
+	if next, err := schedule.NextRun(a, now); err == nil && !next.IsZero() {
+		view.NextRun = &next
+	}
+	return view
+}
+
+// newAnnouncementID returns a random 16-character hex identifier
+func newAnnouncementID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
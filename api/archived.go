@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Archived Rooms API Overview:
+---------------------------
+Rooms can be made read-only (see websockets/archived.go) by
+moderators, naming themselves, or by admins:
+
+	PUT    /api/mod/rooms/:room/archived?moderator=sam
+	DELETE /api/mod/rooms/:room/archived?moderator=sam
+	PUT    /api/admin/rooms/:room/archived
+	DELETE /api/admin/rooms/:room/archived
+
+PUT archives the room and DELETE unarchives it; both are idempotent
+and answer with the room's settings:
+
+	{"room": "launch-2023", ..., "archived": {"at": "2024-06-10T09:00:00Z", "by": "sam"}}
+*/
+
+// adminArchiver is who admin API changes are recorded as
+const adminArchiver = "admin"
+
+// archiveRoom makes a room read-only, recorded as done by the
+// ?moderator parameter or, with none, by by
+// PUT /api/mod/rooms/:room/archived
+// PUT /api/admin/rooms/:room/archived
+func archiveRoom(hub *websockets.LocalHub, by string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings, err := hub.ArchiveRoom(c.Request.Context(), c.Param("room"), c.DefaultQuery("moderator", by))
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "room could not be archived"})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// unarchiveRoom lets a room be posted to again
+// DELETE /api/mod/rooms/:room/archived
+// DELETE /api/admin/rooms/:room/archived
+func unarchiveRoom(hub *websockets.LocalHub, by string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings, err := hub.UnarchiveRoom(c.Request.Context(), c.Param("room"), c.DefaultQuery("moderator", by))
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "room could not be unarchived"})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
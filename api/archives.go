@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"chat-app/archive"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Archives API Overview:
+---------------------
+Read access to a room's cold-storage history for compliance requests:
+
+	GET /api/admin/rooms/:room/archives         List archive objects
+	GET /api/admin/rooms/:room/archives/:name   Download one (gzip JSONL)
+
+Both return 404 when archival is not configured.
+*/
+
+// listArchives lists a room's archived history
+// GET /api/admin/rooms/:room/archives
+func listArchives(archives *archive.Archiver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if archives == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "archival is not configured"})
+			return
+		}
+		objects, err := archives.List(c.Request.Context(), c.Param("room"))
+		if err != nil {
+			log.Printf("Failed to list archives for room %s: %v", c.Param("room"), err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to list archives"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"archives": objects})
+	}
+}
+
+// getArchive streams one archive object
+// GET /api/admin/rooms/:room/archives/:name
+func getArchive(archives *archive.Archiver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if archives == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "archival is not configured"})
+			return
+		}
+		room, name := c.Param("room"), c.Param("name")
+		r, err := archives.Open(c.Request.Context(), room, name)
+		if errors.Is(err, archive.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "archive not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open archive %s for room %s: %v", name, room, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to read archive"})
+			return
+		}
+		defer r.Close()
+
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		c.Status(http.StatusOK)
+		if _, err := io.Copy(c.Writer, r); err != nil {
+			log.Printf("Failed to stream archive %s for room %s: %v", name, room, err)
+		}
+	}
+}
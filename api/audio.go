@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"chat-app/audio"
+	"chat-app/permission"
+	"chat-app/quota"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Audio API Overview:
+------------------
+Voice notes are uploaded over HTTP, then posted over the WebSocket:
+
+	POST /api/rooms/:room/audio?username=alice
+	     body: the recording, e.g. MediaRecorder's audio/webm blob
+	  -> 201 {"id": "...", "url": "/audio/...", "content_type": "audio/webm",
+	          "codec": "opus", "duration_ms": 4210, "size": 16923}
+
+	{"type": "audio", "audio": {"id": "..."}}
+
+The upload is identified by its bytes, not its Content-Type, and is
+refused when it is too big (413), too long or corrupt (400), in a
+codec browsers can't play and no ffmpeg is configured to transcode it
+(415), over the user's daily quota (429), or from a user without the
+room's upload capability (403). Clips are served at their URL with
+range support for seeking; the ID is unguessable, so anyone holding a
+URL can play it, as with sticker images.
+*/
+
+// AudioDeps is everything the voice note endpoints need
+type AudioDeps struct {
+	Store       storage.Store
+	Processor   audio.Processor
+	MaxBytes    int64                  // Largest upload accepted
+	Auth        websockets.AuthFunc    // Optional; the same hook that guards the WebSocket
+	Quotas      *quota.Tracker         // Optional; counts uploads against daily quotas
+	Permissions *permission.Authorizer // Optional; checks the upload capability
+}
+
+// RegisterAudio mounts voice note upload and playback
+func RegisterAudio(r gin.IRouter, deps AudioDeps) {
+	r.POST("/api/rooms/:room/audio", uploadAudio(deps))
+	r.GET("/audio/:id", serveAudio(deps.Store))
+}
+
+// uploadAudio checks and stores a voice note for the user to post
+// POST /api/rooms/:room/audio
+func uploadAudio(deps AudioDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room, username := c.Param("room"), c.Query("username")
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+			return
+		}
+		if deps.Auth != nil {
+			verified, err := deps.Auth(c, room, username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			username = verified
+		}
+		if !authorize(c, deps.Permissions, room, username, storage.CapUpload) {
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, deps.MaxBytes))
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("voice notes are limited to %d bytes", deps.MaxBytes)})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read upload"})
+			return
+		}
+
+		info, data, err := deps.Processor.Process(c.Request.Context(), data)
+		switch {
+		case errors.Is(err, audio.ErrUnsupported):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, audio.ErrCorrupt), errors.Is(err, audio.ErrTooLong):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			log.Printf("Audio upload to %s failed: %v", room, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process audio"})
+			return
+		}
+
+		// Counted once processed, at the size actually stored
+		if !takeUploadQuota(c, deps.Quotas, username, int64(len(data))) {
+			return
+		}
+
+		clip := storage.AudioClip{
+			ID:          newAudioID(),
+			Room:        room,
+			Username:    username,
+			ContentType: info.ContentType,
+			Codec:       info.Codec,
+			DurationMs:  info.Duration.Milliseconds(),
+			Size:        len(data),
+			CreatedAt:   time.Now().UTC(),
+			Data:        data,
+		}
+		if err := deps.Store.SaveAudio(c.Request.Context(), clip); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save audio"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"id":           clip.ID,
+			"url":          websockets.AudioURL(clip.ID),
+			"content_type": clip.ContentType,
+			"codec":        clip.Codec,
+			"duration_ms":  clip.DurationMs,
+			"size":         clip.Size,
+		})
+	}
+}
+
+// serveAudio plays back a voice note, honoring Range requests
+// GET /audio/:id
+func serveAudio(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clip, err := store.GetAudio(c.Request.Context(), c.Param("id"))
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "voice note not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load voice note"})
+			return
+		}
+
+		// A clip never changes once uploaded
+		c.Header("Content-Type", clip.ContentType)
+		c.Header("Cache-Control", "public, max-age=86400, immutable")
+		c.Header("X-Content-Type-Options", "nosniff")
+		http.ServeContent(c.Writer, c.Request, "", clip.CreatedAt, bytes.NewReader(clip.Data))
+	}
+}
+
+// newAudioID returns a random 32-character hex identifier; it is the
+// only thing guarding a clip's URL, so it is longer than most IDs
+func newAudioID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/avatar"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Avatars API Overview:
+--------------------
+Users upload a picture of any shape; the server crops and scales it
+(see the avatar package) and points their profile at the result:
+
+	PUT /api/users/alice/avatar
+	    body: a PNG, JPEG or GIF up to maxAvatarUpload
+	 -> 200 {"id": "5d41...", "url": "/api/avatars/5d41...", "sizes": [32, 64, 128, 256]}
+	DELETE /api/users/alice/avatar -> 204
+	GET /api/users/alice
+	 -> 200 {"username": "alice", "first_seen": "...",
+	         "avatar": {"id": "5d41...", "url": "/api/avatars/5d41..."}}
+
+Images are served by ID with ?size= picking the smallest rendition at
+least that big (the largest by default). An ID's images never change,
+so they are cached for a year and revalidated by ETag.
+*/
+
+// maxAvatarUpload bounds avatar uploads, in bytes; they are scaled down anyway
+const maxAvatarUpload = 5 << 20
+
+// AvatarDeps is everything the avatar endpoints need
+type AvatarDeps struct {
+	Store storage.Store
+	Hub   *websockets.LocalHub // Shows new avatars to the rooms their users are in
+	Auth  websockets.AuthFunc  // Optional; called with an empty room
+}
+
+// RegisterAvatars mounts avatar upload, profiles and avatar images
+func RegisterAvatars(r gin.IRouter, deps AvatarDeps) {
+	r.PUT("/api/users/:username/avatar", uploadAvatar(deps))
+	r.DELETE("/api/users/:username/avatar", deleteAvatar(deps))
+	r.GET("/api/users/:username", getProfile(deps.Store))
+	r.GET("/api/avatars/:id", serveAvatar(deps.Store))
+}
+
+// avatarUser returns the username an avatar request may change, after
+// the auth hook has its say
+func avatarUser(c *gin.Context, deps AvatarDeps) (string, bool) {
+	username := c.Param("username")
+	if deps.Auth != nil {
+		verified, err := deps.Auth(c, "", username)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return "", false
+		}
+		if verified != username {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you can only change your own avatar"})
+			return "", false
+		}
+	}
+	return username, true
+}
+
+// uploadAvatar processes an image and makes it the user's avatar
+// PUT /api/users/:username/avatar
+func uploadAvatar(deps AvatarDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, ok := avatarUser(c, deps)
+		if !ok {
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarUpload))
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("avatars are limited to %d MiB", maxAvatarUpload>>20)})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read upload"})
+			return
+		}
+
+		a, err := avatar.Process(data)
+		switch {
+		case errors.Is(err, avatar.ErrUnsupported):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, avatar.ErrCorrupt), errors.Is(err, avatar.ErrTooLarge):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			log.Printf("Avatar upload for %s failed: %v", username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process avatar"})
+			return
+		}
+
+		// The images go first, so a profile never points at missing ones
+		now := time.Now().UTC()
+		images := make([]storage.AvatarImage, 0, len(avatar.Sizes))
+		for _, size := range avatar.Sizes {
+			images = append(images, storage.AvatarImage{ID: a.ID, Size: size, Data: a.Images[size], CreatedAt: now})
+		}
+		if err := deps.Store.SaveAvatar(c.Request.Context(), images); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save avatar"})
+			return
+		}
+		if err := deps.Store.SetAvatar(c.Request.Context(), username, a.ID, now); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save avatar"})
+			return
+		}
+		deps.Hub.SetAvatar(username, a.ID)
+
+		c.JSON(http.StatusOK, gin.H{"id": a.ID, "url": websockets.AvatarURL(a.ID), "sizes": avatar.Sizes})
+	}
+}
+
+// deleteAvatar removes the user's avatar
+// DELETE /api/users/:username/avatar
+func deleteAvatar(deps AvatarDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, ok := avatarUser(c, deps)
+		if !ok {
+			return
+		}
+		if err := deps.Store.SetAvatar(c.Request.Context(), username, "", time.Now().UTC()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove avatar"})
+			return
+		}
+		deps.Hub.SetAvatar(username, "")
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getProfile describes a user
+// GET /api/users/:username
+func getProfile(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := store.GetUser(c.Request.Context(), c.Param("username"))
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user"})
+			return
+		}
+
+		profile := gin.H{"username": user.Username, "first_seen": user.FirstSeen}
+		if user.Avatar != "" {
+			profile["avatar"] = gin.H{"id": user.Avatar, "url": websockets.AvatarURL(user.Avatar)}
+		}
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// serveAvatar sends one rendition of an avatar
+// GET /api/avatars/:id?size=64
+func serveAvatar(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		size := 0
+		if s := c.Query("size"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "size must be a positive number of pixels"})
+				return
+			}
+			size = n
+		}
+		size = avatar.Fit(size)
+
+		img, err := store.GetAvatar(c.Request.Context(), c.Param("id"), size)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load avatar"})
+			return
+		}
+
+		// Content addressed, so an ID and size always mean the same bytes
+		c.Header("Content-Type", "image/png")
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("ETag", fmt.Sprintf(`"%s-%d"`, img.ID, img.Size))
+		c.Header("X-Content-Type-Options", "nosniff")
+		http.ServeContent(c.Writer, c.Request, "", img.CreatedAt, bytes.NewReader(img.Data))
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"chat-app/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Backup API Overview:
+-------------------
+Used by the `chat-app backup` and `chat-app restore` subcommands:
+
+	GET  /api/admin/backup    Download a snapshot of the store
+	POST /api/admin/restore   Load a snapshot into an empty store
+
+Restore refuses (409) once the server has any data, so it can only
+seed a fresh instance and never silently merges two histories.
+*/
+
+// maxRestoreSize bounds the uploaded snapshot
+const maxRestoreSize = 1 << 30
+
+// backup streams a compressed snapshot of the store
+// GET /api/admin/backup
+func backup(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		snap, err := store.Snapshot(c.Request.Context())
+		if err != nil {
+			log.Printf("Backup failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to snapshot store"})
+			return
+		}
+
+		name := "chat-backup-" + snap.CreatedAt.Format("20060102T150405Z") + ".json.gz"
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		c.Status(http.StatusOK)
+		if err := storage.WriteSnapshot(c.Writer, snap); err != nil {
+			log.Printf("Backup failed while streaming: %v", err)
+		}
+	}
+}
+
+// restore loads an uploaded snapshot into an empty store
+// POST /api/admin/restore
+func restore(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		snap, err := storage.ReadSnapshot(http.MaxBytesReader(c.Writer, c.Request.Body, maxRestoreSize))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = store.Restore(c.Request.Context(), snap)
+		if errors.Is(err, storage.ErrNotEmpty) {
+			c.JSON(http.StatusConflict, gin.H{"error": "restore requires a fresh instance with no data"})
+			return
+		}
+		if err != nil {
+			log.Printf("Restore failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore snapshot"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"restored": gin.H{
+				"rooms":    len(snap.Rooms),
+				"members":  len(snap.Members),
+				"messages": len(snap.Messages),
+				"offline":  len(snap.Offline),
+			},
+			"snapshot_created_at": snap.CreatedAt.Format(time.RFC3339),
+		})
+	}
+}
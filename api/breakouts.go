@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/permission"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Breakouts API Overview:
+----------------------
+Holders of a room's breakout capability (members, unless the room's
+permissions say otherwise) open and close its breakout rooms (see
+websockets/breakouts.go); anyone can list them:
+
+	POST   /api/rooms/:room/breakouts?username=alice  {"name": "warroom", "ttl": "30m"}
+	 -> 201 {"room": "incident-123/warroom", "parent": "incident-123", "created_by": "alice",
+	         "created_at": "...", "expires_at": "..."}
+	GET    /api/rooms/:room/breakouts
+	 -> 200 {"room": "incident-123", "breakouts": [...]}
+	DELETE /api/rooms/:room/breakouts/:name?username=alice
+
+ttl defaults to an hour and may be up to 24h. Members join at
+/ws/:room/:name. Closed breakouts stay listed with their summaries
+until opened again.
+*/
+
+// BreakoutDeps is everything the breakout endpoints need
+type BreakoutDeps struct {
+	Hub        *websockets.LocalHub
+	Authorizer *permission.Authorizer // Checks the breakout capability
+	Auth       websockets.AuthFunc    // Optional; the same hook that guards the WebSocket
+}
+
+// RegisterBreakouts mounts the breakout endpoints
+func RegisterBreakouts(r gin.IRouter, deps BreakoutDeps) {
+	r.GET("/api/rooms/:room/breakouts", listBreakouts(deps))
+	r.POST("/api/rooms/:room/breakouts", openBreakout(deps))
+	r.DELETE("/api/rooms/:room/breakouts/:name", closeBreakout(deps))
+}
+
+// breakoutHost returns the ?username making the request, after checking
+// they hold the breakout capability, or writes the error response and
+// returns false
+func breakoutHost(c *gin.Context, deps BreakoutDeps) (string, bool) {
+	room, username := c.Param("room"), c.Query("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return "", false
+	}
+	if deps.Auth != nil {
+		verified, err := deps.Auth(c, room, username)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return "", false
+		}
+		username = verified
+	}
+	if !authorize(c, deps.Authorizer, room, username, storage.CapBreakout) {
+		return "", false
+	}
+	return username, true
+}
+
+// listBreakouts reports a room's breakouts, open and closed
+// GET /api/rooms/:room/breakouts
+func listBreakouts(deps BreakoutDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room := c.Param("room")
+		breakouts, err := deps.Hub.Breakouts(c.Request.Context(), room)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load breakouts"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"room": room, "breakouts": breakouts})
+	}
+}
+
+// openBreakout opens a breakout room under a room
+// POST /api/rooms/:room/breakouts
+func openBreakout(deps BreakoutDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, ok := breakoutHost(c, deps)
+		if !ok {
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+			TTL  string `json:"ttl"` // Duration, e.g. "30m"; empty for an hour
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+			return
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a positive duration, like 30m"})
+				return
+			}
+		}
+
+		b, err := deps.Hub.OpenBreakout(c.Request.Context(), c.Param("room"), req.Name, host, ttl)
+		switch {
+		case errors.Is(err, websockets.ErrInvalidBreakout):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, websockets.ErrBreakoutOpen), errors.Is(err, websockets.ErrTooManyBreakouts):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open breakout"})
+		default:
+			c.JSON(http.StatusCreated, b)
+		}
+	}
+}
+
+// closeBreakout closes one of a room's breakouts before its time
+// DELETE /api/rooms/:room/breakouts/:name
+func closeBreakout(deps BreakoutDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, ok := breakoutHost(c, deps)
+		if !ok {
+			return
+		}
+		err := deps.Hub.CloseBreakout(c.Request.Context(), c.Param("room"), c.Param("name"), host)
+		switch {
+		case errors.Is(err, websockets.ErrNoBreakout):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to close breakout"})
+		default:
+			c.Status(http.StatusNoContent)
+		}
+	}
+}
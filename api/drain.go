@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"chat-app/cluster"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Drain API Overview:
+------------------
+Moves connections off this node without a thundering herd, mounted
+under the admin API:
+
+	POST   /api/admin/drain      {"target": "https://chat-2.example.com", "spread": "30s"}
+	DELETE /api/admin/drain
+	POST   /api/admin/rebalance  {"count": 500, "room": "lobby", "spread": "10s"}
+
+Drain stops accepting connections and tells every client to reconnect;
+DELETE undoes the first part. Rebalance moves count connections
+(optionally from one room) and leaves the node open.
+
+Clients get a reconnect frame with a random delay within spread (see
+websockets/reconnect.go). Without a target, clustered nodes send each
+client to the least loaded other node; a single node sends no URL and
+clients come back through the load balancer.
+*/
+
+const (
+	defaultDrainSpread     = 30 * time.Second
+	defaultRebalanceSpread = 10 * time.Second
+	maxReconnectSpread     = 10 * time.Minute
+)
+
+// reconnectRequest is the body accepted by drain and rebalance
+type reconnectRequest struct {
+	Target string `json:"target"` // Server base URL to send clients to
+	Spread string `json:"spread"` // Duration, e.g. "30s"
+	Room   string `json:"room"`   // Rebalance only
+	Count  int    `json:"count"`  // Rebalance only
+}
+
+// drain redirects every client and refuses new ones
+// POST /api/admin/drain
+func drain(hub *websockets.LocalHub, node *cluster.Cluster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, spread, ok := bindReconnect(c, defaultDrainSpread)
+		if !ok {
+			return
+		}
+		n := hub.Drain(reconnectTargets(node, req.Target), spread)
+		c.JSON(http.StatusOK, gin.H{"draining": true, "redirected": n})
+	}
+}
+
+// resume accepts new connections again
+// DELETE /api/admin/drain
+func resume(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hub.Resume()
+		c.JSON(http.StatusOK, gin.H{"draining": false})
+	}
+}
+
+// rebalance redirects some clients and stays open
+// POST /api/admin/rebalance
+func rebalance(hub *websockets.LocalHub, node *cluster.Cluster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, spread, ok := bindReconnect(c, defaultRebalanceSpread)
+		if !ok {
+			return
+		}
+		if req.Count <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "count must be a positive integer"})
+			return
+		}
+		n := hub.Redirect(websockets.Redirect{
+			Room:   req.Room,
+			Limit:  req.Count,
+			Target: reconnectTargets(node, req.Target),
+			Spread: spread,
+			Reason: websockets.ReconnectRebalance,
+		})
+		c.JSON(http.StatusOK, gin.H{"redirected": n})
+	}
+}
+
+// bindReconnect parses the request body, writing a 400 if it is invalid
+// An empty body is allowed and means all defaults
+func bindReconnect(c *gin.Context, defaultSpread time.Duration) (reconnectRequest, time.Duration, bool) {
+	var req reconnectRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return req, 0, false
+		}
+	}
+
+	spread := defaultSpread
+	if req.Spread != "" {
+		d, err := time.ParseDuration(req.Spread)
+		if err != nil || d < 0 || d > maxReconnectSpread {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "spread must be a duration between 0s and " + maxReconnectSpread.String()})
+			return req, 0, false
+		}
+		spread = d
+	}
+	return req, spread, true
+}
+
+// reconnectTargets picks where redirected clients should go
+// It returns nil when there is nowhere better to suggest
+func reconnectTargets(node *cluster.Cluster, target string) func(room string) string {
+	if target != "" {
+		return func(string) string { return target }
+	}
+	if node == nil {
+		return nil
+	}
+
+	// Spread clients over the other nodes, counting each one sent
+	load := make(map[string]int)
+	addrs := make(map[string]string)
+	for _, m := range node.AliveMembers() {
+		if !m.Local && m.Meta.HTTPAddr != "" {
+			load[m.Name] = m.Meta.Connections
+			addrs[m.Name] = m.Meta.HTTPAddr
+		}
+	}
+	if len(load) == 0 {
+		return nil
+	}
+	return func(string) string {
+		best := ""
+		for name, n := range load {
+			if best == "" || n < load[best] || (n == load[best] && name < best) {
+				best = name
+			}
+		}
+		load[best]++
+		return addrs[best]
+	}
+}
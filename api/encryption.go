@@ -0,0 +1,61 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"chat-app/keyring"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Encryption API Overview:
+-----------------------
+Key rotation for encryption at rest (see the keyring package):
+
+	POST /api/admin/rooms/:room/key   Start a new version of a room's data key
+	POST /api/admin/keys/rewrap       Re-wrap every data key with the current master key
+
+Rotating a room key leaves what was already stored under the old
+version, which stays readable. Rewrap is run after replacing the
+master key; once it reports success the old master key can be
+dropped from CHAT_ENCRYPTION_OLD_KEYS.
+*/
+
+// rotateRoomKey starts a new version of a room's data key
+// POST /api/admin/rooms/:room/key
+func rotateRoomKey(keys *keyring.Keyring) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keys == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "encryption at rest is not configured"})
+			return
+		}
+		room := c.Param("room")
+		version, err := keys.RotateRoom(c.Request.Context(), room)
+		if err != nil {
+			log.Printf("Rotating the key of room %s failed: %v", room, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate room key"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"room": room, "version": version})
+	}
+}
+
+// rewrapKeys re-wraps every data key with the current master key
+// POST /api/admin/keys/rewrap
+func rewrapKeys(keys *keyring.Keyring) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keys == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "encryption at rest is not configured"})
+			return
+		}
+		n, err := keys.Rewrap(c.Request.Context())
+		if err != nil {
+			log.Printf("Rewrapping room keys failed after %d: %v", n, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rewrap room keys", "rewrapped": n})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"rewrapped": n})
+	}
+}
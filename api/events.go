@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"chat-app/eventlog"
+	"chat-app/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Event Log API Overview:
+----------------------
+Debugging access to a room's append-only event log:
+
+	GET /api/admin/rooms/:room/events?after=0&limit=100&conn=ID
+	    Raw events with offset > after, oldest first; conn keeps only
+	    one connection's joins, messages and leave
+	GET /api/admin/rooms/:room/replay?until=0&history=50
+	    Presence and recent history rebuilt from the log, as of
+	    offset until (0 = now)
+*/
+
+const (
+	defaultEventPage = 100
+	maxEventPage     = 1000
+	defaultHistory   = 50
+
+	// Events examined per request when filtering by connection
+	maxEventScan = 100 * maxEventPage
+)
+
+// listEvents pages through a room's event log
+// GET /api/admin/rooms/:room/events
+func listEvents(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		after, ok := queryUint(c, "after", 0)
+		if !ok {
+			return
+		}
+		limit, ok := queryUint(c, "limit", defaultEventPage)
+		if !ok {
+			return
+		}
+
+		limit = min(limit, maxEventPage)
+
+		conn := c.Query("conn")
+		if conn == "" {
+			events, err := store.Events(c.Request.Context(), c.Param("room"), after, int(limit))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read events"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"events": events})
+			return
+		}
+
+		// Scan forward for the connection's events; next resumes the scan
+		matched := []storage.Event{}
+		next := after
+		for scanned := 0; len(matched) < int(limit) && scanned < maxEventScan; {
+			page, err := store.Events(c.Request.Context(), c.Param("room"), next, maxEventPage)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read events"})
+				return
+			}
+			for _, ev := range page {
+				next = ev.Offset
+				if ev.Data["conn"] == conn {
+					matched = append(matched, ev)
+					if len(matched) == int(limit) {
+						break
+					}
+				}
+			}
+			scanned += len(page)
+			if len(page) < maxEventPage {
+				break
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"events": matched, "next": next})
+	}
+}
+
+// replayRoom rebuilds room views from the event log
+// GET /api/admin/rooms/:room/replay
+func replayRoom(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		until, ok := queryUint(c, "until", 0)
+		if !ok {
+			return
+		}
+		historySize, ok := queryUint(c, "history", defaultHistory)
+		if !ok {
+			return
+		}
+
+		history := eventlog.NewHistory(int(min(historySize, maxEventPage)))
+		presence := eventlog.NewPresence()
+		offset, err := eventlog.Replay(c.Request.Context(), store, c.Param("room"), until, history, presence)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to replay events"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"room":     c.Param("room"),
+			"offset":   offset,
+			"presence": presence.Users(),
+			"history":  history.Messages,
+		})
+	}
+}
+
+// queryUint parses an optional non-negative integer query parameter,
+// replying 400 and returning false if it is malformed
+func queryUint(c *gin.Context, name string, fallback uint64) (uint64, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return fallback, true
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a non-negative integer"})
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/eventlog"
+	"chat-app/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+History Export Overview:
+-----------------------
+External tools (audit, compliance, e-discovery) can be handed a link
+to one room's transcript without being given the admin token:
+
+	POST /api/admin/rooms/:room/export?ttl=1h
+	 -> 201 {"id": "4f1d...", "url": "/api/rooms/lobby/export?id=4f1d...&expires=...&sig=...",
+	         "expires_at": "..."}
+	GET  /api/rooms/lobby/export?id=...&expires=...&sig=...&format=json|csv
+	 -> the room's whole history, oldest first
+
+The link is signed with HMAC-SHA256 over the room, the link ID and the
+expiry, so it can't be moved to another room or kept alive longer.
+Nothing is stored for it: it stops working when it expires or when the
+signing secret changes. Every fetch is logged with the link ID.
+
+The transcript is the History projection over the whole event log:
+deleted, recalled and purged messages are left out, and messages
+hidden by moderation are included and marked hidden. In CSV, a cell
+starting with =, +, - or @ is prefixed with ' so spreadsheets don't
+run it as a formula.
+*/
+
+// Lifetimes of export links
+const (
+	defaultExportTTL = 15 * time.Minute
+	maxExportTTL     = 7 * 24 * time.Hour
+)
+
+// ExportLinks signs and checks history export links
+type ExportLinks struct {
+	secret []byte
+}
+
+// NewExportLinks returns links signed with secret; with none, a random
+// one is used, so links only work on this process until it restarts
+func NewExportLinks(secret string) *ExportLinks {
+	if secret != "" {
+		return &ExportLinks{secret: []byte(secret)}
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Printf("Failed to generate the export link secret: %v", err)
+	}
+	return &ExportLinks{secret: key}
+}
+
+// sign returns the signature of a link to room's export
+func (l *ExportLinks) sign(room, id string, expires int64) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(room + "\n" + id + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// URL returns a link to room's export valid until expires, and its ID
+func (l *ExportLinks) URL(room string, expires time.Time) (string, string) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate export link ID: %v", err)
+	}
+	id := hex.EncodeToString(b)
+	q := url.Values{
+		"id":      {id},
+		"expires": {strconv.FormatInt(expires.Unix(), 10)},
+		"sig":     {l.sign(room, id, expires.Unix())},
+	}
+	return "/api/rooms/" + url.PathEscape(room) + "/export?" + q.Encode(), id
+}
+
+// verify reports whether a link to room's export is genuine and unexpired
+func (l *ExportLinks) verify(room, id, expires, sig string, now time.Time) bool {
+	ts, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || id == "" || now.After(time.Unix(ts, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(l.sign(room, id, ts)), []byte(sig))
+}
+
+// RegisterExports mounts the signed export endpoint; it takes no other
+// credentials, so it belongs outside any group requiring them
+func RegisterExports(r gin.IRouter, store storage.Store, links *ExportLinks) {
+	r.GET("/api/rooms/:room/export", exportHistory(store, links))
+}
+
+// createExportLink issues a link to a room's export
+// POST /api/admin/rooms/:room/export?ttl=1h
+func createExportLink(links *ExportLinks) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if links == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "history exports are not configured"})
+			return
+		}
+		ttl := defaultExportTTL
+		if v := c.Query("ttl"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > maxExportTTL {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a duration between 0 and 168h"})
+				return
+			}
+			ttl = d
+		}
+		room := c.Param("room")
+		expires := time.Now().Add(ttl).Truncate(time.Second)
+		link, id := links.URL(room, expires)
+		c.Header("Cache-Control", "no-store")
+		log.Printf("History export link %s issued for room %s, valid until %s", id, room, expires.UTC().Format(time.RFC3339))
+		c.JSON(http.StatusCreated, gin.H{"id": id, "url": link, "expires_at": expires})
+	}
+}
+
+// exportHistory serves a room's transcript to the holder of a signed link
+// GET /api/rooms/:room/export?id=...&expires=...&sig=...&format=json|csv
+func exportHistory(store storage.Store, links *ExportLinks) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room := c.Param("room")
+		if links == nil || !links.verify(room, c.Query("id"), c.Query("expires"), c.Query("sig"), time.Now()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired export link"})
+			return
+		}
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "csv" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+			return
+		}
+
+		// Nothing falls out of an unlimited view, so one replay drops every
+		// removed message itself; no Removals pass is needed
+		history := eventlog.NewHistory(math.MaxInt)
+		offset, err := eventlog.Replay(c.Request.Context(), store, room, 0, history)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load history"})
+			return
+		}
+		log.Printf("History export link %s fetched room %s (%d messages) from %s", c.Query("id"), room, len(history.Messages), c.ClientIP())
+
+		c.Header("Cache-Control", "no-store")
+		now := time.Now()
+		if format == "json" {
+			c.JSON(http.StatusOK, gin.H{"room": room, "exported_at": now, "offset": offset, "messages": history.Messages})
+			return
+		}
+		name := room + "-" + now.UTC().Format("20060102T150405") + ".csv"
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="`+url.PathEscape(name)+`"`)
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "username", "content", "created_at", "hidden"})
+		for _, m := range history.Messages {
+			w.Write([]string{csvCell(m.ID), csvCell(m.Username), csvCell(m.Content), m.CreatedAt.UTC().Format(time.RFC3339Nano), strconv.FormatBool(m.Hidden)})
+		}
+		w.Flush()
+	}
+}
+
+// csvCell keeps spreadsheets from reading s as a formula
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+
+	"chat-app/permission"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+History API Overview:
+--------------------
+Clients load a room's recent messages before (or after) joining it,
+and page further back as the user scrolls:
+
+	GET /rooms/:room/history?username=alice&limit=50
+	 -> 200 {"room": "lobby", "messages": [{"id": "...", "room": "lobby",
+	         "username": "bob", "type": "chat", "content": "hi", "seq": 7,
+	         "created_at": "..."}], "next": 7}
+	GET /rooms/:room/history?username=alice&before=7
+	    The page before that one; next is 0 once there is nothing older
+
+The same endpoint is served under /api. Messages come oldest first,
+straight from the stored messages (every room message is stored
+before it is broadcast), a page per indexed query on the room's seq.
+Deleted, recalled and purged messages are gone from storage, and
+hidden ones are left out until a moderator approves them. The auth
+hook, bans, archived rooms, invites (an invite token may be passed as
+invite) and the NSFW age check apply as they do to joining, so history
+is never readable by someone who couldn't join the room to see it.
+*/
+
+const (
+	defaultHistoryPage = 50
+	maxHistoryPage     = 200
+)
+
+// HistoryDeps is everything the history endpoint needs
+type HistoryDeps struct {
+	Store      storage.Store
+	Hub        *websockets.LocalHub   // Checks bans, archived and invite-only rooms
+	Authorizer *permission.Authorizer // Optional; lets invite holders into invite-only rooms
+	Joins      *websockets.JoinGate   // Optional; keeps minors out of NSFW rooms
+	Auth       websockets.AuthFunc    // Optional; the same hook that guards the WebSocket
+}
+
+// RegisterHistory mounts room history
+func RegisterHistory(r gin.IRouter, deps HistoryDeps) {
+	r.GET("/rooms/:room/history", roomHistory(deps))
+	r.GET("/api/rooms/:room/history", roomHistory(deps))
+}
+
+// roomHistory pages back through a room's messages
+// GET /rooms/:room/history
+func roomHistory(deps HistoryDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room, username := c.Param("room"), c.Query("username")
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+			return
+		}
+		if deps.Auth != nil {
+			verified, err := deps.Auth(c, room, username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			username = verified
+		}
+		if _, banned := deps.Hub.Banned(room, username); banned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you are banned from this room"})
+			return
+		}
+		if _, archived := deps.Hub.Archived(room); archived && !deps.Hub.Member(room, username) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this room is archived"})
+			return
+		}
+		owner := deps.Authorizer != nil && deps.Authorizer.Authorize(c.Request.Context(), room, username, storage.CapInvite) == nil
+		if !owner && !deps.Hub.Invited(room, username, c.Query("invite")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this room is invite-only", "code": "not_invited"}) // As the WebSocket answers
+			return
+		}
+		if deps.Joins != nil && deps.Joins.AgeGated(room, username) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this room is marked NSFW; only accounts whose age is confirmed may read it"})
+			return
+		}
+
+		limit, ok := queryUint(c, "limit", defaultHistoryPage)
+		if !ok {
+			return
+		}
+		if limit == 0 || limit > maxHistoryPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+			return
+		}
+		before, ok := queryUint(c, "before", 0)
+		if !ok {
+			return
+		}
+		// One past the page tells whether there is more to load
+		page, err := deps.Store.MessagePage(c.Request.Context(), room, before, int(limit)+1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load history"})
+			return
+		}
+		next := uint64(0)
+		if len(page) > int(limit) {
+			page = page[:limit]
+			next = page[len(page)-1].Seq
+		}
+		messages := make([]storage.Message, len(page))
+		for i, msg := range page {
+			messages[len(page)-1-i] = msg // Oldest first
+		}
+
+		c.JSON(http.StatusOK, gin.H{"room": room, "messages": messages, "next": next})
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyServer serves history for one room, set up with settings
+func historyServer(t *testing.T, settings storage.RoomSettings) (*gin.Engine, storage.Store) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store := storage.NewMemory()
+	if err := store.SaveRoomSettings(ctx, settings); err != nil {
+		t.Fatal(err)
+	}
+	msg := storage.Message{ID: "m1", Room: settings.Room, Username: "bob", Type: "chat", Content: "hi", Seq: 1, CreatedAt: time.Now()}
+	if err := store.SaveMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	RegisterHistory(r, HistoryDeps{
+		Store: store,
+		Hub:   websockets.NewHub(websockets.WithStore(store)),
+		Joins: websockets.NewJoinGate(store, nil),
+	})
+	return r, store
+}
+
+// getHistory returns the status of username's request for room's history
+func getHistory(r *gin.Engine, room, username string) int {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/rooms/"+room+"/history?username="+username, nil))
+	return w.Code
+}
+
+func TestHistoryInviteOnly(t *testing.T) {
+	settings := storage.DefaultRoomSettings("vip")
+	settings.Invites = storage.InvitePolicy{Only: true, Users: []string{"alice"}}
+	r, _ := historyServer(t, settings)
+
+	if got := getHistory(r, "vip", "mallory"); got != http.StatusForbidden {
+		t.Errorf("uninvited read = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := getHistory(r, "vip", "alice"); got != http.StatusOK {
+		t.Errorf("invited read = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestHistoryNSFW(t *testing.T) {
+	settings := storage.DefaultRoomSettings("late")
+	settings.Metadata = storage.RoomMetadata{NSFW: true}
+	r, store := historyServer(t, settings)
+
+	if got := getHistory(r, "late", "alice"); got != http.StatusForbidden {
+		t.Errorf("unconfirmed read = %d, want %d", got, http.StatusForbidden)
+	}
+	if err := store.SetAgeConfirmed(context.Background(), "alice", true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := getHistory(r, "late", "alice"); got != http.StatusOK {
+		t.Errorf("confirmed read = %d, want %d", got, http.StatusOK)
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/permission"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Invites API Overview:
+--------------------
+Owners of a room, the users holding its invite capability (see
+storage.Permissions), decide who may join it while it is invite-only
+(see websockets/invites.go). Each request names the owner making it:
+
+	GET    /api/rooms/:room/invites?username=alice
+	PUT    /api/rooms/:room/invites/only?username=alice
+	DELETE /api/rooms/:room/invites/only?username=alice
+	PUT    /api/rooms/:room/invites/users/:invitee?username=alice
+	DELETE /api/rooms/:room/invites/users/:invitee?username=alice
+	POST   /api/rooms/:room/invites/tokens?username=alice  {"for": "24h"}
+	DELETE /api/rooms/:room/invites/tokens/:token?username=alice
+
+PUT and DELETE on only make the room invite-only and open it again.
+Every change answers with the room's invites:
+
+	{"only": true, "users": ["bob"], "tokens": [{"token": "9f2c...", "by": "alice", "expires": "..."}]}
+
+except POST, which answers 201 with the new token; without for it
+lasts until revoked. Users who aren't owners get 403; with an auth
+hook, owners can only act as themselves.
+*/
+
+// InviteDeps is everything the invite endpoints need
+type InviteDeps struct {
+	Hub        *websockets.LocalHub
+	Authorizer *permission.Authorizer // Checks the invite capability
+	Auth       websockets.AuthFunc    // Optional; the same hook that guards the WebSocket
+}
+
+// RegisterInvites mounts the invite endpoints
+func RegisterInvites(r gin.IRouter, deps InviteDeps) {
+	r.GET("/api/rooms/:room/invites", getInvites(deps))
+	r.PUT("/api/rooms/:room/invites/only", setInviteOnly(deps, true))
+	r.DELETE("/api/rooms/:room/invites/only", setInviteOnly(deps, false))
+	r.PUT("/api/rooms/:room/invites/users/:invitee", inviteUser(deps))
+	r.DELETE("/api/rooms/:room/invites/users/:invitee", uninviteUser(deps))
+	r.POST("/api/rooms/:room/invites/tokens", createInvite(deps))
+	r.DELETE("/api/rooms/:room/invites/tokens/:token", revokeInvite(deps))
+}
+
+// inviteOwner returns the ?username making the request, after checking
+// they own the room, or writes the error response and returns false
+func inviteOwner(c *gin.Context, deps InviteDeps) (string, bool) {
+	room, username := c.Param("room"), c.Query("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return "", false
+	}
+	if deps.Auth != nil {
+		verified, err := deps.Auth(c, room, username)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return "", false
+		}
+		username = verified
+	}
+	if !authorize(c, deps.Authorizer, room, username, storage.CapInvite) {
+		return "", false
+	}
+	return username, true
+}
+
+// respondInvites answers with the room's invites after a change
+func respondInvites(c *gin.Context, policy storage.InvitePolicy, err error) {
+	switch {
+	case errors.Is(err, websockets.ErrInvalidInvites):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such invite token"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save invites"})
+	default:
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, policy)
+	}
+}
+
+// getInvites reports a room's invite list and tokens
+// GET /api/rooms/:room/invites
+func getInvites(deps InviteDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := inviteOwner(c, deps); !ok {
+			return
+		}
+		policy, err := deps.Hub.Invites(c.Request.Context(), c.Param("room"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load invites"})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, policy)
+	}
+}
+
+// setInviteOnly makes a room invite-only, or opens it again
+// PUT    /api/rooms/:room/invites/only
+// DELETE /api/rooms/:room/invites/only
+func setInviteOnly(deps InviteDeps, only bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := inviteOwner(c, deps); !ok {
+			return
+		}
+		policy, err := deps.Hub.SetInviteOnly(c.Request.Context(), c.Param("room"), only)
+		respondInvites(c, policy, err)
+	}
+}
+
+// inviteUser puts a user on a room's invite list
+// PUT /api/rooms/:room/invites/users/:invitee
+func inviteUser(deps InviteDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := inviteOwner(c, deps); !ok {
+			return
+		}
+		policy, err := deps.Hub.Invite(c.Request.Context(), c.Param("room"), c.Param("invitee"))
+		respondInvites(c, policy, err)
+	}
+}
+
+// uninviteUser takes a user off a room's invite list
+// DELETE /api/rooms/:room/invites/users/:invitee
+func uninviteUser(deps InviteDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := inviteOwner(c, deps); !ok {
+			return
+		}
+		policy, err := deps.Hub.Uninvite(c.Request.Context(), c.Param("room"), c.Param("invitee"))
+		respondInvites(c, policy, err)
+	}
+}
+
+// createInvite makes an invite token for a room
+// POST /api/rooms/:room/invites/tokens
+func createInvite(deps InviteDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		owner, ok := inviteOwner(c, deps)
+		if !ok {
+			return
+		}
+		var req struct {
+			For string `json:"for"` // Duration, e.g. "24h"; empty until revoked
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+				return
+			}
+		}
+		var ttl time.Duration
+		if req.For != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.For); err != nil || ttl <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "for must be a positive duration, like 24h"})
+				return
+			}
+		}
+
+		token, policy, err := deps.Hub.CreateInvite(c.Request.Context(), c.Param("room"), owner, ttl)
+		if err != nil {
+			respondInvites(c, policy, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusCreated, token)
+	}
+}
+
+// revokeInvite drops one of a room's invite tokens
+// DELETE /api/rooms/:room/invites/tokens/:token
+func revokeInvite(deps InviteDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := inviteOwner(c, deps); !ok {
+			return
+		}
+		policy, err := deps.Hub.RevokeInvite(c.Request.Context(), c.Param("room"), c.Param("token"))
+		respondInvites(c, policy, err)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Room Metadata API Overview:
+--------------------------
+A room's language and content flags (see storage/metadata.go) are read
+and set next to its tags, by holders of the tag capability:
+
+	GET /api/rooms/:room/metadata
+	 -> 200 {"room": "anime", "metadata": {"language": "ja", "spoilers": true}}
+	PUT /api/rooms/:room/metadata?username=alice   {"language": "ja", "nsfw": false, "spoilers": true}
+
+The rooms list shows them and filters on them (see tags.go). Whether
+a user's age is confirmed, which NSFW rooms require, is up to the
+operator, e.g. from their sign-up flow, under the admin API:
+
+	PUT    /api/admin/users/:username/age-confirmed
+	DELETE /api/admin/users/:username/age-confirmed
+*/
+
+// getRoomMetadata reports a room's language and content flags
+// GET /api/rooms/:room/metadata
+func getRoomMetadata(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room := c.Param("room")
+		settings, err := store.GetRoomSettings(c.Request.Context(), room)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room settings"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"room": room, "metadata": settings.Metadata})
+	}
+}
+
+// putRoomMetadata replaces a room's language and content flags
+// PUT /api/rooms/:room/metadata?username=alice
+func putRoomMetadata(deps TagDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room, username := c.Param("room"), c.Query("username")
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+			return
+		}
+		if deps.Auth != nil {
+			verified, err := deps.Auth(c, room, username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			username = verified
+		}
+		if !authorize(c, deps.Authorizer, room, username, storage.CapTag) {
+			return
+		}
+
+		var req storage.RoomMetadata
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return
+		}
+		meta, err := deps.Hub.SetRoomMetadata(c.Request.Context(), room, req)
+		switch {
+		case errors.Is(err, websockets.ErrInvalidMetadata):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save room metadata"})
+		default:
+			c.JSON(http.StatusOK, gin.H{"room": room, "metadata": meta})
+		}
+	}
+}
+
+// setAgeConfirmed records whether a user's age is confirmed
+// PUT    /api/admin/users/:username/age-confirmed
+// DELETE /api/admin/users/:username/age-confirmed
+func setAgeConfirmed(store storage.Store, confirmed bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		if err := store.SetAgeConfirmed(c.Request.Context(), username, confirmed, time.Now().UTC()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save user"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"username": username, "age_confirmed": confirmed})
+	}
+}
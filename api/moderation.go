@@ -0,0 +1,245 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Moderation API Overview:
+-----------------------
+REST endpoints for moderators working the review queue (see
+websockets/review.go) and watching rooms, under /api/mod. Every request must carry the
+moderator token:
+
+	Authorization: Bearer <CHAT_MODERATOR_TOKEN>
+
+	GET    /api/mod/queue?limit=100
+	POST   /api/mod/queue/:id              {"decision": "ban", "moderator": "sam", "ban_for": "24h"}
+	GET    /api/mod/rooms/:room/bans
+	DELETE /api/mod/rooms/:room/bans/:username?moderator=sam
+	GET    /api/mod/rooms/:room/alerts
+	PUT    /api/mod/rooms/:room/alerts/:moderator  {"keywords": ["giveaway"], "patterns": ["discord\\.gg/\\w+"]}
+	DELETE /api/mod/rooms/:room/alerts/:moderator
+	PUT    /api/mod/rooms/:room/archived?moderator=sam
+	DELETE /api/mod/rooms/:room/archived?moderator=sam
+	POST   /api/mod/rooms/:room/purge           {"last": 200, "moderator": "sam"}
+
+decision is approve, delete or ban; a ban without ban_for is
+permanent. A ban with "cascade": "delete" or "hide" also takes the
+author's messages from the last lookback (CHAT_BAN_CASCADE_LOOKBACK
+unless given, see websockets/cascade.go). Moderators listed in CHAT_MODERATORS also get the queue
+live over their WebSocket as mod_queue frames. Alerts send the named
+moderator a keyword_alert frame for each matching message (see
+websockets/alerts.go). Archived rooms are read-only (see archived.go).
+Purges remove many messages at once (see purge.go).
+*/
+
+// Page sizes for /queue
+const (
+	defaultReviewPage = 100
+	maxReviewPage     = 1000
+)
+
+// ModerationDeps is everything the moderation endpoints read from
+type ModerationDeps struct {
+	Hub   *websockets.LocalHub
+	Store storage.Store
+	Token func() string // Bearer token, read per request so it can be rotated; empty disables the API
+
+	BanLookback time.Duration // How far back a ban's cascade reaches when the request doesn't say
+}
+
+// RegisterModeration mounts the moderator endpoints on the router
+func RegisterModeration(r gin.IRouter, deps ModerationDeps) {
+	mod := r.Group("/api/mod", requireToken(deps.Token, "moderation", "CHAT_MODERATOR_TOKEN"))
+	mod.GET("/queue", reviewQueue(deps.Hub))
+	mod.POST("/queue/:id", resolveReview(deps.Hub, deps.BanLookback))
+	mod.GET("/rooms/:room/bans", listBans(deps.Hub))
+	mod.DELETE("/rooms/:room/bans/:username", unban(deps.Hub))
+	mod.GET("/rooms/:room/alerts", listKeywordAlerts(deps.Store))
+	mod.PUT("/rooms/:room/alerts/:moderator", putKeywordAlert(deps.Store))
+	mod.DELETE("/rooms/:room/alerts/:moderator", deleteKeywordAlert(deps.Store))
+	mod.PUT("/rooms/:room/archived", archiveRoom(deps.Hub, ""))
+	mod.DELETE("/rooms/:room/archived", unarchiveRoom(deps.Hub, ""))
+	mod.POST("/rooms/:room/purge", purgeMessages(deps.Hub))
+}
+
+// reviewQueue lists messages awaiting a moderator, oldest first
+// GET /api/mod/queue?limit=100
+func reviewQueue(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultReviewPage
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxReviewPage {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxReviewPage)})
+				return
+			}
+			limit = n
+		}
+
+		items, err := hub.ReviewQueue(limit)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "review queue unavailable"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": items})
+	}
+}
+
+// resolveReview approves, deletes or bans over a queued message; a ban
+// may cascade to the author's messages within lookback
+// POST /api/mod/queue/:id
+func resolveReview(hub *websockets.LocalHub, lookback time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Decision  string `json:"decision"`
+			Moderator string `json:"moderator"`
+			BanFor    string `json:"ban_for"`  // Duration, e.g. "24h"; empty is permanent
+			Cascade   string `json:"cascade"`  // delete or hide the author's messages on a ban
+			Lookback  string `json:"lookback"` // Duration the cascade reaches back; empty for the default
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+			return
+		}
+		res := websockets.Resolution{Decision: req.Decision, Moderator: req.Moderator}
+		switch req.Decision {
+		case websockets.DecisionApprove, websockets.DecisionDelete, websockets.DecisionBan:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "decision must be one of approve, delete, ban"})
+			return
+		}
+		if req.BanFor != "" {
+			d, err := time.ParseDuration(req.BanFor)
+			if err != nil || d <= 0 || req.Decision != websockets.DecisionBan {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ban_for must be a positive duration on a ban"})
+				return
+			}
+			res.BanFor = d
+		}
+		if req.Cascade != "" {
+			if (req.Cascade != websockets.CascadeDelete && req.Cascade != websockets.CascadeHide) || req.Decision != websockets.DecisionBan {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cascade must be delete or hide, on a ban"})
+				return
+			}
+			res.Cascade, res.Lookback = req.Cascade, lookback
+		}
+		if req.Lookback != "" {
+			d, err := time.ParseDuration(req.Lookback)
+			if err != nil || d <= 0 || req.Cascade == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "lookback must be a positive duration on a cascading ban"})
+				return
+			}
+			res.Lookback = d
+		}
+
+		item, err := hub.Resolve(c.Request.Context(), c.Param("id"), res)
+		switch {
+		case errors.Is(err, websockets.ErrNotQueued):
+			c.JSON(http.StatusNotFound, gin.H{"error": "message is not awaiting review"})
+		case err != nil:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ban could not be saved: " + err.Error()})
+		default:
+			c.JSON(http.StatusOK, gin.H{"decision": req.Decision, "item": item})
+		}
+	}
+}
+
+// listBans lists a room's bans, including expired ones not yet lifted
+// GET /api/mod/rooms/:room/bans
+func listBans(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bans, err := hub.Bans(c.Param("room"))
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "bans unavailable"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"room": c.Param("room"), "bans": bans})
+	}
+}
+
+// unban lets a user back into a room
+// DELETE /api/mod/rooms/:room/bans/:username?moderator=sam
+func unban(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := hub.Unban(c.Param("room"), c.Param("username"), c.Query("moderator"))
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": c.Param("username") + " is not banned from " + c.Param("room")})
+		case err != nil:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ban could not be lifted"})
+		default:
+			c.Status(http.StatusNoContent)
+		}
+	}
+}
+
+// listKeywordAlerts lists the moderators watching a room and what for
+// GET /api/mod/rooms/:room/alerts
+func listKeywordAlerts(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		alerts, err := store.KeywordAlerts(c.Request.Context(), c.Param("room"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load keyword alerts"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"room": c.Param("room"), "alerts": alerts})
+	}
+}
+
+// putKeywordAlert replaces a moderator's watch list for a room
+// PUT /api/mod/rooms/:room/alerts/:moderator
+func putKeywordAlert(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Keywords []string `json:"keywords"`
+			Patterns []string `json:"patterns"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return
+		}
+
+		alert := storage.KeywordAlert{
+			Room:      c.Param("room"),
+			Moderator: c.Param("moderator"),
+			Keywords:  req.Keywords,
+			Patterns:  req.Patterns,
+			UpdatedAt: time.Now().UTC(),
+		}
+		if err := alert.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := store.SaveKeywordAlert(c.Request.Context(), alert); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save keyword alert"})
+			return
+		}
+		c.JSON(http.StatusOK, alert)
+	}
+}
+
+// deleteKeywordAlert stops a moderator watching a room
+// DELETE /api/mod/rooms/:room/alerts/:moderator
+func deleteKeywordAlert(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := store.DeleteKeywordAlert(c.Request.Context(), c.Param("room"), c.Param("moderator"))
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": c.Param("moderator") + " is not watching " + c.Param("room")})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete keyword alert"})
+		default:
+			c.Status(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/notify"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Notification Preferences API Overview:
+-------------------------------------
+Users choose which messages reach them as push or email notifications
+while they're away (see storage.NotificationPrefs and the notify
+package), per room:
+
+	GET /api/users/alice/notifications
+	 -> 200 {"username": "alice", "default": "mentions", "rooms": {"lobby": "all"}}
+	PUT /api/users/alice/notifications  {"rooms": {"random": "never"}}
+	 -> 200 the preferences as saved
+	PUT    /api/users/alice/notifications/mutes/random  {"for": "8h"}
+	DELETE /api/users/alice/notifications/mutes/random
+
+A PUT changes only the levels it gives; a room set to "" falls back to
+the default. A mute without for lasts until it is deleted; muted
+rooms send no notifications, whatever their level. A dnd window,
+like {"dnd": {"start": "22:00", "end": "07:00", "timezone": "..."}},
+holds notifications for a summary afterwards. Connections can do
+the same with set_preferences, mute and unmute frames. With an auth
+hook, users only reach their own.
+*/
+
+// NotificationDeps is everything the preferences endpoints need
+type NotificationDeps struct {
+	Store storage.Store
+	Auth  websockets.AuthFunc // Optional; called with an empty room
+}
+
+// RegisterNotifications mounts the notification preference endpoints
+func RegisterNotifications(r gin.IRouter, deps NotificationDeps) {
+	r.GET("/api/users/:username/notifications", getNotificationPrefs(deps))
+	r.PUT("/api/users/:username/notifications", putNotificationPrefs(deps))
+	r.PUT("/api/users/:username/notifications/mutes/:room", muteRoom(deps))
+	r.DELETE("/api/users/:username/notifications/mutes/:room", unmuteRoom(deps))
+}
+
+// getNotificationPrefs reports a user's notification preferences
+// GET /api/users/:username/notifications
+func getNotificationPrefs(deps NotificationDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, ok := ownUser(c, deps.Auth, "notification preferences")
+		if !ok {
+			return
+		}
+		prefs, err := notify.Preferences(c.Request.Context(), deps.Store, username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load preferences"})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, prefs)
+	}
+}
+
+// putNotificationPrefs changes some of a user's notification levels
+// PUT /api/users/:username/notifications
+func putNotificationPrefs(deps NotificationDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, ok := ownUser(c, deps.Auth, "notification preferences")
+		if !ok {
+			return
+		}
+		var change storage.NotificationPrefs
+		if err := c.ShouldBindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+			return
+		}
+
+		prefs, err := notify.UpdatePreferences(c.Request.Context(), deps.Store, username, change)
+		switch {
+		case errors.Is(err, notify.ErrInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save preferences"})
+		default:
+			c.JSON(http.StatusOK, prefs)
+		}
+	}
+}
+
+// muteRoom silences a room's notifications for a while, or until unmuted
+// PUT /api/users/:username/notifications/mutes/:room
+func muteRoom(deps NotificationDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, ok := ownUser(c, deps.Auth, "notification preferences")
+		if !ok {
+			return
+		}
+		var req struct {
+			For string `json:"for"` // Duration, e.g. "8h"; empty until unmuted
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+				return
+			}
+		}
+		var d time.Duration
+		if req.For != "" {
+			var err error
+			if d, err = time.ParseDuration(req.For); err != nil || d <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "for must be a positive duration, like 8h"})
+				return
+			}
+		}
+
+		prefs, err := notify.Mute(c.Request.Context(), deps.Store, username, c.Param("room"), d)
+		switch {
+		case errors.Is(err, notify.ErrInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save preferences"})
+		default:
+			c.JSON(http.StatusOK, prefs)
+		}
+	}
+}
+
+// unmuteRoom lets a room notify the user again
+// DELETE /api/users/:username/notifications/mutes/:room
+func unmuteRoom(deps NotificationDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, ok := ownUser(c, deps.Auth, "notification preferences")
+		if !ok {
+			return
+		}
+		prefs, err := notify.Unmute(c.Request.Context(), deps.Store, username, c.Param("room"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save preferences"})
+			return
+		}
+		c.JSON(http.StatusOK, prefs)
+	}
+}
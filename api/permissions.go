@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"chat-app/permission"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Permissions API Overview:
+------------------------
+Clients can ask what a user may do in a room (see the permission
+package), e.g. to hide a pin button:
+
+	GET /api/rooms/lobby/permissions?username=alice
+	 -> 200 {"room": "lobby", "username": "alice", "roles": ["member", "host"],
+	         "capabilities": ["pin", "upload", "mention_everyone", "command", "post"]}
+
+Roles and grants are set with the room's settings, under the admin
+API. Uploads by users without the upload capability are refused with
+403 before the file is taken.
+*/
+
+// PermissionDeps is everything the permissions endpoint needs
+type PermissionDeps struct {
+	Authorizer *permission.Authorizer
+	Auth       websockets.AuthFunc // Optional; the same hook that guards the WebSocket
+}
+
+// RegisterPermissions mounts the permissions report
+func RegisterPermissions(r gin.IRouter, deps PermissionDeps) {
+	r.GET("/api/rooms/:room/permissions", getPermissions(deps))
+}
+
+// getPermissions reports a user's roles and capabilities in a room
+// GET /api/rooms/:room/permissions
+func getPermissions(deps PermissionDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room, username := c.Param("room"), c.Query("username")
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+			return
+		}
+		if deps.Auth != nil {
+			verified, err := deps.Auth(c, room, username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			username = verified
+		}
+
+		grant, err := deps.Authorizer.Grant(c.Request.Context(), room, username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load permissions"})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, grant)
+	}
+}
+
+// authorize checks username holds capability in room, answering 403
+// (or 503 when it can't be checked) and returning false when not
+// A nil authorizer allows everything
+func authorize(c *gin.Context, a *permission.Authorizer, room, username, capability string) bool {
+	err := a.Authorize(c.Request.Context(), room, username, capability)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, permission.ErrDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Permissions for %s in %s failed: %v", username, room, err)
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "permissions could not be checked, retry shortly"})
+	}
+	return false
+}
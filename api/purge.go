@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Purge API Overview:
+------------------
+Moderators remove many of a room's messages in one request (see
+websockets/purge.go), either its last N, optionally only one user's:
+
+	POST /api/mod/rooms/:room/purge  {"last": 200, "moderator": "sam"}
+	POST /api/mod/rooms/:room/purge  {"last": 50, "username": "eve", "moderator": "sam"}
+
+or everything a user sent, or anyone sent, in a time range; until
+defaults to now:
+
+	POST /api/mod/rooms/:room/purge
+	     {"username": "eve", "since": "2024-06-10T09:00:00Z", "until": "2024-06-10T09:05:00Z",
+	      "moderator": "sam"}
+	  -> 200 {"room": "lobby", "purged": 120,
+	          "purge": {"username": "eve", "since": "...", "until": "..."}}
+
+A request must give last, username or since, so a whole room is never
+purged by accident. Nothing matching is not an error; purged is 0.
+*/
+
+// purgeRequest is the body accepted by POST
+type purgeRequest struct {
+	Last      int       `json:"last"`
+	Username  string    `json:"username"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	Moderator string    `json:"moderator"`
+}
+
+// purgeView is a purge as applied; since is left out when it is open
+type purgeView struct {
+	Username string     `json:"username,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+	Until    time.Time  `json:"until"`
+}
+
+// purgeMessages removes a room's last messages, or a user's or time range's
+// POST /api/mod/rooms/:room/purge
+func purgeMessages(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req purgeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return
+		}
+		if req.Last < 0 || req.Last > websockets.MaxPurgeLast {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "last must be between 1 and " + strconv.Itoa(websockets.MaxPurgeLast)})
+			return
+		}
+		if req.Last == 0 && req.Username == "" && req.Since.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "give last, username or since to choose the messages"})
+			return
+		}
+		if !req.Until.IsZero() && req.Until.Before(req.Since) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must not be before since"})
+			return
+		}
+
+		room := c.Param("room")
+		p := storage.Purge{Username: req.Username, Since: req.Since, Until: req.Until}
+		p, count, err := hub.PurgeMessages(c.Request.Context(), room, req.Moderator, p, req.Last)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "messages could not be purged"})
+			return
+		}
+		view := purgeView{Username: p.Username, Until: p.Until}
+		if !p.Since.IsZero() {
+			view.Since = &p.Since
+		}
+		c.JSON(http.StatusOK, gin.H{"room": room, "purged": count, "purge": view})
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/metrics"
+	"chat-app/quota"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Quotas API Overview:
+-------------------
+Users can see how much of their daily quota (see the quota package)
+is left, e.g. to warn before they run out:
+
+	GET /api/users/alice/quota
+	 -> 200 {"day": "2024-06-10", "used": {"messages": 120, "uploads": 2, "bytes": 498113},
+	         "limits": {"messages": 500, "uploads": 0, "bytes": 10485760},
+	         "remaining": {"messages": 380, "bytes": 9987647},
+	         "resets_at": "2024-06-11T00:00:00Z"}
+
+With an auth hook, users only see their own. Uploads over quota are
+refused with 429, Retry-After set to when the quota resets, and the
+quota in the body:
+
+	{"error": "daily uploads quota of 20 reached, ...", "quota": {...}}
+*/
+
+// QuotaDeps is everything the quota endpoint needs
+type QuotaDeps struct {
+	Tracker *quota.Tracker
+	Auth    websockets.AuthFunc // Optional; called with an empty room
+}
+
+// RegisterQuotas mounts the quota report
+func RegisterQuotas(r gin.IRouter, deps QuotaDeps) {
+	r.GET("/api/users/:username/quota", getQuota(deps))
+}
+
+// getQuota reports a user's quota for the day
+// GET /api/users/:username/quota
+func getQuota(deps QuotaDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		if deps.Auth != nil {
+			verified, err := deps.Auth(c, "", username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			if verified != username {
+				c.JSON(http.StatusForbidden, gin.H{"error": "you can only see your own quota"})
+				return
+			}
+		}
+
+		status, err := deps.Tracker.Status(c.Request.Context(), username, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load quota"})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// takeUploadQuota counts an upload of size bytes against username's
+// quota, answering 429 and returning false when it doesn't fit
+// A nil tracker, or a failing store, lets every upload through
+func takeUploadQuota(c *gin.Context, t *quota.Tracker, username string, size int64) bool {
+	if !t.Enabled() {
+		return true
+	}
+	now := time.Now()
+	status, err := t.Take(c.Request.Context(), username, storage.Usage{Uploads: 1, Bytes: size}, now)
+	var exceeded *quota.ExceededError
+	switch {
+	case errors.As(err, &exceeded):
+		metrics.QuotaRejections.WithLabelValues(exceeded.Counter).Inc()
+		retry := max(int(exceeded.ResetsAt.Sub(now).Seconds()), 1)
+		c.Header("Retry-After", strconv.Itoa(retry))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": exceeded.Error(), "quota": status})
+		return false
+	case err != nil:
+		log.Printf("Quota for %s failed: %v", username, err)
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/permission"
+	"chat-app/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Room Settings API Overview:
+--------------------------
+Per-room configuration, mounted under the admin API:
+
+	GET /api/admin/rooms/:room/settings
+	PUT /api/admin/rooms/:room/settings
+	    {"retention": {"policy": "days", "days": 30},
+	     "links": {"deny": ["evil.example"], "action": "defang"},
+	     "joins": {"min_account_age": "24h", "per_minute": 30, "action": "queue"},
+	     "onboarding": {"steps": [{"type": "rules", "content": "Be kind"}]},
+	     "emoji": {"party": "🥳🎉"},
+	     "permissions": {"roles": {"alice": ["host"]}, "grants": {"host": ["pin", "mention_everyone", "invite"]}},
+	     "invites": {"only": true, "users": ["alice"]},
+	     "federation": {"servers": ["b.example"]},
+	     "tags": ["incident"],
+	     "metadata": {"language": "en", "nsfw": false, "spoilers": true}}
+
+Rooms that were never configured report the defaults. Whether a room
+is archived is not a setting PUT can change; see archived.go. Invites,
+tags and metadata are left as they are unless given, since room owners
+manage them too (see invites.go, tags.go and metadata.go).
+*/
+
+// roomSettingsRequest is the body accepted by PUT
+type roomSettingsRequest struct {
+	Retention   storage.Retention        `json:"retention"`
+	Links       storage.LinkPolicy       `json:"links"`
+	Joins       storage.JoinPolicy       `json:"joins"`
+	Onboarding  storage.Onboarding       `json:"onboarding"`
+	Emoji       storage.CustomEmoji      `json:"emoji"`
+	Permissions storage.Permissions      `json:"permissions"`
+	Invites     *storage.InvitePolicy    `json:"invites"` // Nil keeps the room's
+	Federation  storage.FederationPolicy `json:"federation"`
+	Tags        *[]string                `json:"tags"`     // Nil keeps the room's
+	Metadata    *storage.RoomMetadata    `json:"metadata"` // Nil keeps the room's
+}
+
+// getRoomSettings returns a room's settings, or the defaults
+// GET /api/admin/rooms/:room/settings
+func getRoomSettings(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room := c.Param("room")
+		settings, err := store.GetRoomSettings(c.Request.Context(), room)
+		if errors.Is(err, storage.ErrNotFound) {
+			settings = storage.DefaultRoomSettings(room)
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room settings"})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// putRoomSettings replaces a room's settings
+// New permissions apply on this node at once, elsewhere within the cache TTL
+// PUT /api/admin/rooms/:room/settings
+func putRoomSettings(store storage.Store, perms *permission.Authorizer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req roomSettingsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return
+		}
+		if err := req.Retention.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.Links.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.Joins.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.Onboarding.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.Emoji.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.Permissions.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.Federation.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Invites != nil {
+			if err := req.Invites.Validate(); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		var tags []string
+		if req.Tags != nil {
+			var err error
+			if tags, err = storage.NormalizeTags(*req.Tags); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if req.Metadata != nil {
+			meta, err := req.Metadata.Normalize()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			req.Metadata = &meta
+		}
+
+		// Archiving, invites, tags and metadata have their own endpoints, so keep whatever they set
+		room := c.Param("room")
+		current, err := store.GetRoomSettings(c.Request.Context(), room)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room settings"})
+			return
+		}
+
+		settings := storage.RoomSettings{
+			Room:        room,
+			Retention:   req.Retention,
+			Links:       req.Links,
+			Joins:       req.Joins,
+			Onboarding:  req.Onboarding,
+			Emoji:       req.Emoji,
+			Permissions: req.Permissions,
+			Invites:     current.Invites,
+			Federation:  req.Federation,
+			Tags:        current.Tags,
+			Metadata:    current.Metadata,
+			Archived:    current.Archived,
+			UpdatedAt:   time.Now().UTC(),
+		}
+		if req.Invites != nil {
+			settings.Invites = *req.Invites
+		}
+		if req.Tags != nil {
+			settings.Tags = tags
+		}
+		if req.Metadata != nil {
+			settings.Metadata = *req.Metadata
+		}
+		if err := store.SaveRoomSettings(c.Request.Context(), settings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save room settings"})
+			return
+		}
+		perms.Forget(room)
+		c.JSON(http.StatusOK, settings)
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // Registers GIF for DecodeConfig
+	_ "image/jpeg" // Registers JPEG for DecodeConfig
+	_ "image/png"  // Registers PNG for DecodeConfig
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"chat-app/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Stickers API Overview:
+---------------------
+Sticker packs, mounted under the admin API:
+
+	GET    /api/admin/stickers
+	POST   /api/admin/stickers
+	       {"id": "cats", "name": "Cats", "rooms": ["lobby"]}
+	GET    /api/admin/stickers/:pack
+	PUT    /api/admin/stickers/:pack       {"name": "Cats", "rooms": []}
+	DELETE /api/admin/stickers/:pack
+	PUT    /api/admin/stickers/:pack/:sticker
+	       multipart form: image=<file>, alt=👋
+	DELETE /api/admin/stickers/:pack/:sticker
+
+Images must be PNG, GIF, JPEG or WebP, at most 512 KiB and, apart
+from WebP, 1024 pixels either way. The type is read from the image
+itself, not the upload's headers. Images are served publicly by
+RegisterStickers at the URLs clients are given in sticker frames.
+*/
+
+// stickerImageTypes are the image types accepted for stickers
+var stickerImageTypes = []string{"image/png", "image/gif", "image/jpeg", "image/webp"}
+
+// stickerPackRequest is the body accepted by POST and PUT on a pack
+type stickerPackRequest struct {
+	ID        string   `json:"id"` // POST only; PUT uses the path
+	Name      string   `json:"name"`
+	Rooms     []string `json:"rooms"`
+	CreatedBy string   `json:"created_by"`
+}
+
+// RegisterStickers serves sticker images
+// GET /stickers/:pack/:sticker
+func RegisterStickers(r gin.IRouter, store storage.Store) {
+	r.GET("/stickers/:pack/:sticker", func(c *gin.Context) {
+		img, err := store.GetStickerImage(c.Request.Context(), c.Param("pack"), c.Param("sticker"))
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sticker not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load sticker"})
+			return
+		}
+
+		// Images can be replaced under the same URL, so cache briefly and revalidate
+		sum := sha256.Sum256(img.Data)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.Header("X-Content-Type-Options", "nosniff")
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Data(http.StatusOK, img.ContentType, img.Data)
+	})
+}
+
+// listStickerPacks lists every pack, oldest first
+// GET /api/admin/stickers
+func listStickerPacks(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		packs, err := store.StickerPacks(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load sticker packs"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"packs": packs})
+	}
+}
+
+// getStickerPack returns one pack
+// GET /api/admin/stickers/:pack
+func getStickerPack(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := loadStickerPack(c, store)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// createStickerPack adds an empty pack
+// POST /api/admin/stickers
+func createStickerPack(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req stickerPackRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return
+		}
+		now := time.Now().UTC()
+		p := storage.StickerPack{
+			ID:        req.ID,
+			Name:      req.Name,
+			Rooms:     req.Rooms,
+			Stickers:  []storage.Sticker{},
+			CreatedBy: req.CreatedBy,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := p.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		packs, err := store.StickerPacks(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load sticker packs"})
+			return
+		}
+		if slices.ContainsFunc(packs, func(existing storage.StickerPack) bool { return existing.ID == p.ID }) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a pack with that id already exists"})
+			return
+		}
+		if len(packs) >= storage.MaxStickerPacks {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d sticker packs", storage.MaxStickerPacks)})
+			return
+		}
+
+		if err := store.SaveStickerPack(c.Request.Context(), p); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save sticker pack"})
+			return
+		}
+		c.JSON(http.StatusCreated, p)
+	}
+}
+
+// updateStickerPack renames a pack or changes its rooms
+// PUT /api/admin/stickers/:pack
+func updateStickerPack(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req stickerPackRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return
+		}
+		p, ok := loadStickerPack(c, store)
+		if !ok {
+			return
+		}
+		p.Name = req.Name
+		p.Rooms = req.Rooms
+		p.UpdatedAt = time.Now().UTC()
+		if err := p.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := store.SaveStickerPack(c.Request.Context(), p); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save sticker pack"})
+			return
+		}
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// deleteStickerPack removes a pack and its images
+// DELETE /api/admin/stickers/:pack
+func deleteStickerPack(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := store.DeleteStickerPack(c.Request.Context(), c.Param("pack"))
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sticker pack not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete sticker pack"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// putSticker uploads a sticker, replacing any with the same ID
+// PUT /api/admin/stickers/:pack/:sticker
+func putSticker(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Leave room for the form's other parts around the image
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, storage.MaxStickerImageSize+64<<10)
+		file, err := c.FormFile("image")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "send the image as the multipart form field \"image\", at most 512 KiB"})
+			return
+		}
+		sticker := storage.Sticker{ID: c.Param("sticker"), Alt: c.PostForm("alt")}
+		if err := sticker.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read image"})
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(f, storage.MaxStickerImageSize+1))
+		f.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read image"})
+			return
+		}
+		contentType, err := checkStickerImage(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		p, ok := loadStickerPack(c, store)
+		if !ok {
+			return
+		}
+		now := time.Now().UTC()
+		sticker.ContentType = contentType
+		sticker.Size = len(data)
+		sticker.UpdatedAt = now
+		i := slices.IndexFunc(p.Stickers, func(s storage.Sticker) bool { return s.ID == sticker.ID })
+		if i >= 0 {
+			p.Stickers[i] = sticker
+		} else if len(p.Stickers) >= storage.MaxStickersPerPack {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d stickers per pack", storage.MaxStickersPerPack)})
+			return
+		} else {
+			p.Stickers = append(p.Stickers, sticker)
+		}
+		p.UpdatedAt = now
+
+		// The image goes first, so a listed sticker always has one
+		img := storage.StickerImage{Pack: p.ID, Sticker: sticker.ID, ContentType: contentType, Data: data}
+		if err := store.SaveStickerImage(c.Request.Context(), img); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save sticker image"})
+			return
+		}
+		if err := store.SaveStickerPack(c.Request.Context(), p); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save sticker pack"})
+			return
+		}
+		c.JSON(http.StatusOK, sticker)
+	}
+}
+
+// deleteSticker removes a sticker and its image
+// DELETE /api/admin/stickers/:pack/:sticker
+func deleteSticker(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := loadStickerPack(c, store)
+		if !ok {
+			return
+		}
+		id := c.Param("sticker")
+		i := slices.IndexFunc(p.Stickers, func(s storage.Sticker) bool { return s.ID == id })
+		if i < 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sticker not found"})
+			return
+		}
+		p.Stickers = slices.Delete(p.Stickers, i, i+1)
+		p.UpdatedAt = time.Now().UTC()
+
+		// The pack goes first, so nobody is offered a sticker without an image
+		if err := store.SaveStickerPack(c.Request.Context(), p); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save sticker pack"})
+			return
+		}
+		if err := store.DeleteStickerImage(c.Request.Context(), p.ID, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete sticker image"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// loadStickerPack loads the pack named in the path, replying if it can't
+func loadStickerPack(c *gin.Context, store storage.Store) (storage.StickerPack, bool) {
+	p, err := store.GetStickerPack(c.Request.Context(), c.Param("pack"))
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sticker pack not found"})
+		return p, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load sticker pack"})
+		return p, false
+	}
+	return p, true
+}
+
+// checkStickerImage returns the type of an uploaded image, or why it can't be a sticker
+func checkStickerImage(data []byte) (string, error) {
+	if len(data) > storage.MaxStickerImageSize {
+		return "", fmt.Errorf("images must be at most %d KiB", storage.MaxStickerImageSize>>10)
+	}
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(stickerImageTypes, contentType) {
+		return "", errors.New("images must be PNG, GIF, JPEG or WebP")
+	}
+	if contentType == "image/webp" {
+		return contentType, nil // The standard library can't decode WebP
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", errors.New("image is corrupt")
+	}
+	if cfg.Width > storage.MaxStickerDimension || cfg.Height > storage.MaxStickerDimension {
+		return "", fmt.Errorf("images must be at most %dx%d pixels", storage.MaxStickerDimension, storage.MaxStickerDimension)
+	}
+	return contentType, nil
+}
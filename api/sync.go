@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Sync API Overview:
+-----------------
+A device loads the user's synced state (see websockets/sync.go) when
+it starts, and may set keys without a WebSocket:
+
+	GET    /api/users/alice/sync
+	 -> 200 {"username": "alice", "entries": [{"key": "draft:lobby", "value": "...", "updated_at": "..."}]}
+	PUT    /api/users/alice/sync/draft:lobby  {"value": "half a thought"}
+	DELETE /api/users/alice/sync/draft:lobby
+
+Changes are pushed to the user's connections as sync frames. With an
+auth hook, users only reach their own state. A new key past
+storage.MaxSyncKeys is refused with 409.
+*/
+
+// SyncDeps is everything the sync endpoints need
+type SyncDeps struct {
+	Hub  *websockets.LocalHub
+	Auth websockets.AuthFunc // Optional; called with an empty room
+}
+
+// RegisterSync mounts the synced state endpoints
+func RegisterSync(r gin.IRouter, deps SyncDeps) {
+	r.GET("/api/users/:username/sync", getSync(deps))
+	r.PUT("/api/users/:username/sync/:key", putSync(deps, false))
+	r.DELETE("/api/users/:username/sync/:key", putSync(deps, true))
+}
+
+// getSync lists a user's synced state
+// GET /api/users/:username/sync
+func getSync(deps SyncDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, ok := ownUser(c, deps.Auth, "sync state")
+		if !ok {
+			return
+		}
+		entries, err := deps.Hub.SyncState(c.Request.Context(), username)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "sync state unavailable"})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"username": username, "entries": entries})
+	}
+}
+
+// putSync sets one key, or deletes it
+// PUT /api/users/:username/sync/:key
+// DELETE /api/users/:username/sync/:key
+func putSync(deps SyncDeps, remove bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, ok := ownUser(c, deps.Auth, "sync state")
+		if !ok {
+			return
+		}
+		s := websockets.Sync{Key: c.Param("key")}
+		if !remove {
+			var req struct {
+				Value string `json:"value"`
+			}
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+				return
+			}
+			s.Value = req.Value
+		}
+		if err := (storage.SyncEntry{Key: s.Key, Value: s.Value}).Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err := deps.Hub.PutSync(c.Request.Context(), username, s)
+		switch {
+		case errors.Is(err, storage.ErrSyncFull):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "sync could not be saved"})
+		case remove || s.Value == "":
+			c.Status(http.StatusNoContent)
+		default:
+			c.JSON(http.StatusOK, gin.H{"username": username, "key": s.Key, "value": s.Value})
+		}
+	}
+}
+
+// ownUser returns the user whose state the request names, answering
+// 401 or 403 and returning false when the caller may not reach it;
+// what names the state in the error
+func ownUser(c *gin.Context, auth websockets.AuthFunc, what string) (string, bool) {
+	username := c.Param("username")
+	if auth == nil {
+		return username, true
+	}
+	verified, err := auth(c, "", username)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if verified != username {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you can only reach your own " + what})
+		return "", false
+	}
+	return username, true
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"chat-app/permission"
+	"chat-app/storage"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Rooms and Tags API Overview:
+---------------------------
+Rooms can be tagged (see storage/tags.go) and found by their tags:
+
+	GET /api/rooms?tag=incident&tag=payments&q=db&language=en&nsfw=false&limit=50
+	 -> 200 {"rooms": [{"room": "db-outage", "tags": ["incident", "payments"], "language": "en", "active_users": 4}]}
+	GET /api/rooms/:room/tags
+	 -> 200 {"room": "db-outage", "tags": ["incident", "payments"]}
+	PUT /api/rooms/:room/tags?username=alice   {"tags": ["incident", "payments"]}
+
+The list holds every room that has settings or someone in it. Each tag
+given narrows it to rooms with that tag, and q to rooms whose name or
+tags contain it. language narrows it to rooms in that language or a
+variant of it, and nsfw=true or nsfw=false to rooms that are or aren't
+marked NSFW (see metadata.go). Busiest rooms come first. Invite-only rooms are left
+out of the public list, and breakout rooms out of both (see breakouts.go). Setting tags takes the tag capability (see
+permissions.go); with an auth hook, owners can only act as themselves.
+
+Under the admin API, the same list includes every room, and a tag
+picks the rooms an operation applies to:
+
+	GET  /api/admin/rooms?tag=incident
+	POST /api/admin/tags/:tag/announce   {"content": "Status page updated", "from": "ops"}
+	 -> 200 {"tag": "incident", "rooms": ["db-outage", "payments"]}
+
+Scheduled announcements take tags as well as rooms (see announcements.go).
+*/
+
+// Page sizes for the rooms list
+const (
+	defaultRoomsPage = 50
+	maxRoomsPage     = 500
+)
+
+// TagDeps is everything the rooms list and tag endpoints need
+type TagDeps struct {
+	Hub        *websockets.LocalHub
+	Store      storage.Store
+	Authorizer *permission.Authorizer // Checks the tag capability
+	Auth       websockets.AuthFunc    // Optional; the same hook that guards the WebSocket
+}
+
+// RegisterTags mounts the rooms list, room tags and metadata
+func RegisterTags(r gin.IRouter, deps TagDeps) {
+	r.GET("/api/rooms", listRooms(deps.Hub, deps.Store, false))
+	r.GET("/api/rooms/:room/tags", getRoomTags(deps.Store))
+	r.PUT("/api/rooms/:room/tags", putRoomTags(deps))
+	r.GET("/api/rooms/:room/metadata", getRoomMetadata(deps.Store))
+	r.PUT("/api/rooms/:room/metadata", putRoomMetadata(deps))
+}
+
+// roomListing is one room in the rooms list
+type roomListing struct {
+	Room        string   `json:"room"`
+	Tags        []string `json:"tags"`
+	Language    string   `json:"language,omitempty"`
+	NSFW        bool     `json:"nsfw,omitempty"`
+	Spoilers    bool     `json:"spoilers,omitempty"`
+	Broadcast   bool     `json:"broadcast,omitempty"` // Read-only for most, see storage.Permissions
+	ActiveUsers int      `json:"active_users"`
+	InviteOnly  bool     `json:"invite_only,omitempty"` // Only in the admin list
+	Archived    bool     `json:"archived,omitempty"`
+}
+
+// listRooms lists rooms, filtered by tag and name
+// GET /api/rooms?tag=...&q=...&language=...&nsfw=...&limit=50
+// GET /api/admin/rooms?tag=...&q=...&language=...&nsfw=...&limit=50 (all, with private)
+func listRooms(hub *websockets.LocalHub, store storage.Store, all bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := queryUint(c, "limit", defaultRoomsPage)
+		if !ok {
+			return
+		}
+		if limit == 0 || limit > maxRoomsPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		tags, err := storage.NormalizeTags(c.QueryArray("tag"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+		language := c.Query("language")
+		var nsfw *bool
+		if v := c.Query("nsfw"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "nsfw must be true or false"})
+				return
+			}
+			nsfw = &b
+		}
+
+		configured, err := store.ListRoomSettings(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load rooms"})
+			return
+		}
+		settings := make(map[string]storage.RoomSettings, len(configured))
+		for _, s := range configured {
+			settings[s.Room] = s
+		}
+		active := make(map[string]int)
+		for _, st := range hub.TopRooms(0, websockets.SortByUsers) {
+			active[st.Room] = st.ActiveUsers
+			if _, ok := settings[st.Room]; !ok {
+				settings[st.Room] = storage.DefaultRoomSettings(st.Room)
+			}
+		}
+
+		rooms := []roomListing{}
+		for room, s := range settings {
+			if storage.IsBreakout(room) || !s.Tagged(tags...) || (s.Invites.Only && !all) || !matchesRoom(s, q) ||
+				!s.Metadata.Speaks(language) || (nsfw != nil && s.Metadata.NSFW != *nsfw) {
+				continue
+			}
+			listing := roomListing{
+				Room:        room,
+				Tags:        s.Tags,
+				Language:    s.Metadata.Language,
+				NSFW:        s.Metadata.NSFW,
+				Spoilers:    s.Metadata.Spoilers,
+				Broadcast:   s.Permissions.Broadcast,
+				ActiveUsers: active[room],
+				Archived:    s.Archived != nil,
+			}
+			if listing.Tags == nil {
+				listing.Tags = []string{}
+			}
+			if all {
+				listing.InviteOnly = s.Invites.Only
+			}
+			rooms = append(rooms, listing)
+		}
+		sort.Slice(rooms, func(i, j int) bool {
+			if rooms[i].ActiveUsers != rooms[j].ActiveUsers {
+				return rooms[i].ActiveUsers > rooms[j].ActiveUsers
+			}
+			return rooms[i].Room < rooms[j].Room
+		})
+		if len(rooms) > int(limit) {
+			rooms = rooms[:limit]
+		}
+		c.JSON(http.StatusOK, gin.H{"rooms": rooms})
+	}
+}
+
+// matchesRoom reports whether the room's name or one of its tags contains q
+func matchesRoom(s storage.RoomSettings, q string) bool {
+	if q == "" || strings.Contains(strings.ToLower(s.Room), q) {
+		return true
+	}
+	return slices.ContainsFunc(s.Tags, func(tag string) bool { return strings.Contains(tag, q) })
+}
+
+// getRoomTags reports a room's tags
+// GET /api/rooms/:room/tags
+func getRoomTags(store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room := c.Param("room")
+		settings, err := store.GetRoomSettings(c.Request.Context(), room)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room settings"})
+			return
+		}
+		tags := settings.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{"room": room, "tags": tags})
+	}
+}
+
+// putRoomTags replaces a room's tags
+// PUT /api/rooms/:room/tags?username=alice
+func putRoomTags(deps TagDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room, username := c.Param("room"), c.Query("username")
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+			return
+		}
+		if deps.Auth != nil {
+			verified, err := deps.Auth(c, room, username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			username = verified
+		}
+		if !authorize(c, deps.Authorizer, room, username, storage.CapTag) {
+			return
+		}
+
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return
+		}
+		tags, err := deps.Hub.SetRoomTags(c.Request.Context(), room, req.Tags)
+		switch {
+		case errors.Is(err, websockets.ErrInvalidTags):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save tags"})
+		default:
+			c.JSON(http.StatusOK, gin.H{"room": room, "tags": tags})
+		}
+	}
+}
+
+// announceTagged posts an announcement to every room with a tag
+// POST /api/admin/tags/:tag/announce
+func announceTagged(hub *websockets.LocalHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tag := c.Param("tag")
+		if !storage.ValidTag(tag) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tag"})
+			return
+		}
+		var req struct {
+			Content string `json:"content"`
+			From    string `json:"from"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+			return
+		}
+		if strings.TrimSpace(req.Content) == "" || len(req.Content) > storage.MaxAnnouncementContent {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content is required, at most 2000 bytes"})
+			return
+		}
+		rooms, err := hub.AnnounceTagged(c.Request.Context(), tag, req.From, req.Content)
+		if err != nil {
+			log.Printf("Announcing to rooms tagged %s failed: %v", tag, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load rooms"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tag": tag, "rooms": rooms})
+	}
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"chat-app/metrics"
+	"chat-app/storage"
+	"chat-app/tenant"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Tenants API Overview:
+--------------------
+Operators issue each tenant an API key and set its limits, under the
+admin API:
+
+	GET    /api/admin/tenants
+	POST   /api/admin/tenants
+	       {"name": "acme", "limits": {"max_connections": 500, "max_rooms": 20,
+	                                   "message_rate": 50, "message_burst": 100}}
+	       -> 201 {"id": "...", "name": "acme", ..., "api_key": "ck_4f1d..."}
+	GET    /api/admin/tenants/:id
+	PUT    /api/admin/tenants/:id          (same body as POST)
+	POST   /api/admin/tenants/:id/key      issue a new key; the old one stops working
+	DELETE /api/admin/tenants/:id
+
+The key is only ever in the response that issues it. Responses also
+carry usage, what the tenant has open on the node that answered. The
+API returns 404 when tenants are not enabled.
+
+With tenants enabled, RequireTenantKey guards the public REST API as
+WithTenants guards the WebSocket: requests need a key, in the
+X-API-Key header or the api_key parameter, or are refused with 401.
+Media downloads stay open, since browsers fetch them from <img> and
+<audio> tags that can't send headers, and their URLs are only found
+in messages.
+*/
+
+// keylessRoutes are the media downloads RequireTenantKey lets through
+var keylessRoutes = map[string]bool{
+	"/audio/:id":               true,
+	"/attachments/:id":         true,
+	"/stickers/:pack/:sticker": true,
+	"/api/avatars/:id":         true,
+}
+
+// RequireTenantKey rejects requests without a tenant's API key
+func RequireTenantKey(r *tenant.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keylessRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		key := tenant.KeyFromRequest(c.Request)
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "an API key is required, in the " + tenant.KeyHeader + " header or the " + tenant.KeyParam + " parameter"})
+			return
+		}
+		t, err := r.Authenticate(c.Request.Context(), key, time.Now())
+		switch {
+		case errors.Is(err, tenant.ErrUnknownKey):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			log.Printf("Tenant lookup failed: %v", err)
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "API key could not be checked, retry shortly"})
+			return
+		}
+		metrics.TenantRequests.WithLabelValues(t.Name).Inc()
+		c.Next()
+	}
+}
+
+// tenantRequest is the body accepted by POST and PUT
+type tenantRequest struct {
+	Name   string               `json:"name"`
+	Limits storage.TenantLimits `json:"limits"`
+}
+
+// tenantView is a tenant as the API shows it, never with its key hash
+type tenantView struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	Limits    storage.TenantLimits `json:"limits"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	Usage     tenant.Usage         `json:"usage"`             // On this node
+	APIKey    string               `json:"api_key,omitempty"` // Only when issued
+}
+
+// listTenants lists every tenant, oldest first
+// GET /api/admin/tenants
+func listTenants(reg *tenant.Registry, store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tenantsEnabled(c, reg) {
+			return
+		}
+		tenants, err := store.Tenants(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load tenants"})
+			return
+		}
+		views := make([]tenantView, 0, len(tenants))
+		for _, t := range tenants {
+			views = append(views, viewTenant(reg, t, ""))
+		}
+		c.JSON(http.StatusOK, gin.H{"tenants": views})
+	}
+}
+
+// getTenant returns one tenant
+// GET /api/admin/tenants/:id
+func getTenant(reg *tenant.Registry, store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tenantsEnabled(c, reg) {
+			return
+		}
+		t, ok := loadTenant(c, store)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, viewTenant(reg, t, ""))
+	}
+}
+
+// createTenant adds a tenant and issues its first key
+// POST /api/admin/tenants
+func createTenant(reg *tenant.Registry, store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tenantsEnabled(c, reg) {
+			return
+		}
+		now := time.Now().UTC()
+		key, hash := tenant.NewKey()
+		t := storage.Tenant{ID: newTenantID(), KeyHash: hash, CreatedAt: now}
+		saveTenant(c, reg, store, t, now, key, http.StatusCreated)
+	}
+}
+
+// updateTenant renames a tenant or changes its limits
+// PUT /api/admin/tenants/:id
+func updateTenant(reg *tenant.Registry, store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tenantsEnabled(c, reg) {
+			return
+		}
+		t, ok := loadTenant(c, store)
+		if !ok {
+			return
+		}
+		saveTenant(c, reg, store, t, time.Now().UTC(), "", http.StatusOK)
+	}
+}
+
+// saveTenant fills t from the request body, validates and stores it
+// A non-empty key is included in the response
+func saveTenant(c *gin.Context, reg *tenant.Registry, store storage.Store, t storage.Tenant, now time.Time, key string, status int) {
+	var req tenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+		return
+	}
+	t.Name = req.Name
+	t.Limits = req.Limits
+	t.UpdatedAt = now
+	if err := t.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Names label the tenant metrics, so they must be unique
+	tenants, err := store.Tenants(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load tenants"})
+		return
+	}
+	for _, other := range tenants {
+		if other.Name == t.Name && other.ID != t.ID {
+			c.JSON(http.StatusConflict, gin.H{"error": "a tenant named " + t.Name + " already exists"})
+			return
+		}
+	}
+
+	if err := store.SaveTenant(c.Request.Context(), t); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save tenant"})
+		return
+	}
+	reg.Forget(t)
+	c.JSON(status, viewTenant(reg, t, key))
+}
+
+// rotateTenantKey issues a tenant a new key, revoking the old one
+// Connections already open stay open
+// POST /api/admin/tenants/:id/key
+func rotateTenantKey(reg *tenant.Registry, store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tenantsEnabled(c, reg) {
+			return
+		}
+		t, ok := loadTenant(c, store)
+		if !ok {
+			return
+		}
+		key, hash := tenant.NewKey()
+		t.KeyHash = hash
+		t.UpdatedAt = time.Now().UTC()
+		if err := store.SaveTenant(c.Request.Context(), t); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save tenant"})
+			return
+		}
+		reg.Forget(t)
+		c.JSON(http.StatusOK, viewTenant(reg, t, key))
+	}
+}
+
+// deleteTenant removes a tenant, revoking its key
+// Connections already open stay open
+// DELETE /api/admin/tenants/:id
+func deleteTenant(reg *tenant.Registry, store storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tenantsEnabled(c, reg) {
+			return
+		}
+		t, ok := loadTenant(c, store)
+		if !ok {
+			return
+		}
+		if err := store.DeleteTenant(c.Request.Context(), t.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete tenant"})
+			return
+		}
+		reg.Forget(t)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// tenantsEnabled answers 404 and returns false without a registry
+func tenantsEnabled(c *gin.Context, reg *tenant.Registry) bool {
+	if reg == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenants are not enabled"})
+		return false
+	}
+	return true
+}
+
+// loadTenant loads the tenant named by the :id parameter, answering
+// 404 or 500 and returning false when it can't
+func loadTenant(c *gin.Context, store storage.Store) (storage.Tenant, bool) {
+	t, err := store.GetTenant(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return t, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load tenant"})
+		return t, false
+	}
+	return t, true
+}
+
+// viewTenant describes t with its usage on this node and, when just
+// issued, its key
+func viewTenant(reg *tenant.Registry, t storage.Tenant, key string) tenantView {
+	return tenantView{
+		ID:        t.ID,
+		Name:      t.Name,
+		Limits:    t.Limits,
+		CreatedAt: t.CreatedAt,
+		UpdatedAt: t.UpdatedAt,
+		Usage:     reg.Usage(t.ID),
+		APIKey:    key,
+	}
+}
+
+// newTenantID returns a random 16-character hex identifier
+func newTenantID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
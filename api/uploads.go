@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"chat-app/permission"
+	"chat-app/quota"
+	"chat-app/storage"
+	"chat-app/uploads"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Uploads API Overview:
+--------------------
+Attachments go straight from the client to S3; the server only hands
+out presigned URLs (see the uploads package):
+
+	POST /api/rooms/:room/uploads?username=alice
+	     {"name": "report.pdf", "size": 482113, "content_type": "application/pdf"}
+	  -> 201 {"id": "...", "method": "PUT", "url": "https://...",
+	          "headers": {"Content-Type": "application/pdf", "Content-Length": "482113"},
+	          "expires_at": "..."}
+
+Files that are too big get a 413, types not accepted a 415, users
+whose daily quota is used up a 429, and users whose roles in the room
+don't grant upload (see permissions.go) a 403. Once the PUT succeeds
+the attachment is posted over the WebSocket:
+
+	{"type": "attachment", "attachment": {"id": "..."}}
+
+Posted attachments are downloaded from their URL, which redirects to a
+short-lived presigned S3 URL. As with voice notes, the ID is
+unguessable, so anyone holding the link can download the file.
+*/
+
+// UploadsDeps is everything the attachment endpoints need
+type UploadsDeps struct {
+	Service     *uploads.Service
+	Auth        websockets.AuthFunc    // Optional; the same hook that guards the WebSocket
+	Quotas      *quota.Tracker         // Optional; counts uploads against daily quotas
+	Permissions *permission.Authorizer // Optional; checks the upload capability
+}
+
+// RegisterUploads mounts attachment upload URLs and downloads
+func RegisterUploads(r gin.IRouter, deps UploadsDeps) {
+	r.POST("/api/rooms/:room/uploads", startUpload(deps))
+	r.GET("/attachments/:id", downloadAttachment(deps.Service))
+}
+
+// startUpload issues a presigned URL for the user to upload a file to
+// POST /api/rooms/:room/uploads
+func startUpload(deps UploadsDeps) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		room, username := c.Param("room"), c.Query("username")
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+			return
+		}
+		if deps.Auth != nil {
+			verified, err := deps.Auth(c, room, username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			username = verified
+		}
+		if !authorize(c, deps.Permissions, room, username, storage.CapUpload) {
+			return
+		}
+
+		var req uploads.Request
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+
+		ticket, err := deps.Service.Start(c.Request.Context(), room, username, req)
+		switch {
+		case errors.Is(err, uploads.ErrTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, uploads.ErrType):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, uploads.ErrInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			log.Printf("Upload URL for %s failed: %v", room, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start upload"})
+			return
+		}
+
+		// Counted once the request is known to be acceptable; an upload
+		// refused here is never posted, so it is swept like any other
+		if !takeUploadQuota(c, deps.Quotas, username, req.Size) {
+			return
+		}
+		c.JSON(http.StatusCreated, ticket)
+	}
+}
+
+// downloadAttachment redirects to a short-lived URL for a posted attachment
+// GET /attachments/:id
+func downloadAttachment(service *uploads.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		url, err := service.DownloadURL(c.Request.Context(), c.Param("id"))
+		if errors.Is(err, uploads.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return
+		} else if err != nil {
+			log.Printf("Attachment download URL failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load attachment"})
+			return
+		}
+
+		// The URL expires, so it mustn't outlive it in a cache
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, url)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/metering"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Usage API Overview:
+------------------
+Accounting exports of what rooms and users consumed (see the metering
+package), as JSON or as CSV for spreadsheets and billing systems:
+
+	GET /api/admin/usage?window=24h&by=room,user
+	GET /api/admin/usage?from=2024-06-01T00:00:00Z&to=2024-07-01T00:00:00Z&by=user&format=csv
+
+	room,username,messages,bytes,connection_minutes
+	lobby,alice,120,25011,540.5
+
+window is a rolling window ending now (default 24h); from and to pick
+a fixed one instead, to defaulting to now. by lists the dimensions to
+break usage down by, any of room, user and hour (default room,user).
+The API returns 404 when metering is not enabled.
+*/
+
+// defaultUsageWindow is the rolling window reported without ?window or ?from
+const defaultUsageWindow = 24 * time.Hour
+
+// usageReport exports usage in a window
+// GET /api/admin/usage?window=24h&by=room,user&format=json|csv
+func usageReport(meter *metering.Meter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if meter == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "metering is not enabled"})
+			return
+		}
+
+		// Step 1: The window, rolling or fixed
+		to := time.Now()
+		if v := c.Query("to"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC 3339 time"})
+				return
+			}
+			to = t
+		}
+		from := to.Add(-defaultUsageWindow)
+		if v := c.Query("from"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC 3339 time"})
+				return
+			}
+			from = t
+		} else if v := c.Query("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a positive duration such as 24h"})
+				return
+			}
+			from = to.Add(-d)
+		}
+		if !from.Before(to) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+			return
+		}
+
+		// Step 2: The breakdown
+		by, err := metering.ParseDimensions(c.DefaultQuery("by", metering.ByRoom+","+metering.ByUser))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "csv" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+			return
+		}
+
+		report, err := meter.Report(c.Request.Context(), from, to, by)
+		if err != nil {
+			log.Printf("Usage report failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage"})
+			return
+		}
+		if format == "json" {
+			c.JSON(http.StatusOK, report)
+			return
+		}
+
+		// Step 3: CSV, one column per dimension, then the counters
+		name := "usage-" + report.From.Format("20060102T15") + "-" + report.To.Format("20060102T15") + ".csv"
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		w := csv.NewWriter(c.Writer)
+		header := make([]string, 0, len(by)+3)
+		for _, d := range by {
+			if d == metering.ByUser {
+				d = "username"
+			}
+			header = append(header, d)
+		}
+		w.Write(append(header, "messages", "bytes", "connection_minutes"))
+		for _, line := range report.Lines {
+			row := make([]string, 0, len(header)+3)
+			for _, d := range by {
+				switch d {
+				case metering.ByRoom:
+					row = append(row, line.Room)
+				case metering.ByUser:
+					row = append(row, line.Username)
+				case metering.ByHour:
+					row = append(row, line.Hour.Format(time.RFC3339))
+				}
+			}
+			w.Write(append(row,
+				strconv.FormatInt(line.Messages, 10),
+				strconv.FormatInt(line.Bytes, 10),
+				strconv.FormatFloat(line.ConnectionMinutes, 'f', -1, 64),
+			))
+		}
+		w.Flush()
+	}
+}
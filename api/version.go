@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+
+	"chat-app/buildinfo"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Version API Overview:
+--------------------
+A public endpoint clients and deploy tooling use to check what they
+are talking to before connecting:
+
+	GET /api/version
+	{"version": "v1.4.0", "commit": "9f2c...", "go_version": "go1.22.1",
+	 "protocol": 2, "protocols": [1, 2], "features": ["clustering", "sharding"]}
+
+protocol is the version announced in the hello frame (see
+websockets/protocol.go); protocols lists every version the server
+can be configured to serve. features names the optional subsystems enabled on this
+node, so it contains no secrets or addresses.
+*/
+
+// versionResponse is the body of GET /api/version
+type versionResponse struct {
+	buildinfo.Info
+	Protocol  int      `json:"protocol"`
+	Protocols []int    `json:"protocols"`
+	Features  []string `json:"features"`
+}
+
+// RegisterVersion mounts GET /api/version
+// protocol is the hub's, and features names the optional subsystems
+// enabled on this node
+func RegisterVersion(r gin.IRouter, protocol int, features []string) {
+	if features == nil {
+		features = []string{}
+	}
+	body := versionResponse{
+		Info:      buildinfo.Get(),
+		Protocol:  protocol,
+		Protocols: websockets.SupportedProtocols,
+		Features:  features,
+	}
+	r.GET("/api/version", func(c *gin.Context) {
+		c.JSON(http.StatusOK, body)
+	})
+}
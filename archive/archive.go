@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"chat-app/storage"
+)
+
+/*
+Archive Overview:
+----------------
+Cold storage for history that has aged out of a room's retention
+window. The retention pruner hands expired messages to the Archiver,
+which writes them as one gzip-compressed JSONL object per batch:
+
+	rooms/<room>/<first message time>_<first seq>-<last seq>.jsonl.gz
+
+Each line is a storage.Message. Objects go to any S3-compatible
+service (AWS S3, MinIO, R2, ...) through the ObjectStore interface,
+and can be listed and downloaded per room through the admin API
+to answer compliance requests.
+*/
+
+// ErrNotFound is returned when an archive object doesn't exist
+var ErrNotFound = errors.New("archive: not found")
+
+// Object describes one stored archive
+type Object struct {
+	Name         string    `json:"name"` // Object name within its room
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ObjectStore is the minimal blob storage the archiver needs
+type ObjectStore interface {
+	// Put writes data under key, replacing any existing object
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens an object, returning ErrNotFound if missing
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List returns every object whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// Archiver writes expired messages to an ObjectStore
+// It implements storage.Archiver
+type Archiver struct {
+	objects ObjectStore
+}
+
+var _ storage.Archiver = (*Archiver)(nil)
+
+// New creates an archiver backed by objects
+func New(objects ObjectStore) *Archiver {
+	return &Archiver{objects: objects}
+}
+
+// Archive writes msgs (oldest first) as a single compressed object
+func (a *Archiver) Archive(ctx context.Context, room string, msgs []storage.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, msg := range msgs {
+		if err := enc.Encode(msg); err != nil {
+			return fmt.Errorf("encode message %s: %w", msg.ID, err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+
+	first, last := msgs[0], msgs[len(msgs)-1]
+	name := fmt.Sprintf("%s_%d-%d.jsonl.gz",
+		first.CreatedAt.UTC().Format("20060102T150405Z"), first.Seq, last.Seq)
+	return a.objects.Put(ctx, roomPrefix(room)+name, buf.Bytes(), "application/gzip")
+}
+
+// List returns a room's archives, oldest first
+func (a *Archiver) List(ctx context.Context, room string) ([]Object, error) {
+	prefix := roomPrefix(room)
+	objects, err := a.objects.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range objects {
+		objects[i].Name = strings.TrimPrefix(objects[i].Name, prefix)
+	}
+	return objects, nil
+}
+
+// Open returns the compressed contents of one of a room's archives
+func (a *Archiver) Open(ctx context.Context, room, name string) (io.ReadCloser, error) {
+	// Names never contain a slash; refuse anything that could escape the room
+	if name == "" || strings.Contains(name, "/") {
+		return nil, ErrNotFound
+	}
+	return a.objects.Get(ctx, roomPrefix(room)+name)
+}
+
+// roomPrefix is the key prefix holding a room's archives
+func roomPrefix(room string) string {
+	return "rooms/" + url.PathEscape(room) + "/"
+}
@@ -0,0 +1,134 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+Audio Overview:
+--------------
+Voice notes are checked before they are stored. Probe reads the
+container and codec of an upload from its bytes, whatever the client
+claims, and works out its duration:
+
+	Ogg    Opus, Vorbis, FLAC, Speex
+	WebM   Opus, Vorbis (what browsers' MediaRecorder produces)
+	MP4    AAC (m4a, Safari's MediaRecorder)
+	MP3
+	WAV    PCM
+
+Opus (in Ogg or WebM), AAC and MP3 play in browsers and are kept as
+they are. Anything else is transcoded to Ogg Opus when a Processor has
+an ffmpeg binary, and rejected otherwise, so clients never receive a
+voice note they can't play inline.
+*/
+
+// Errors returned by Probe and Process
+var (
+	ErrUnsupported = errors.New("unsupported audio format")
+	ErrCorrupt     = errors.New("audio file is corrupt or truncated")
+	ErrTooLong     = errors.New("audio is too long")
+)
+
+// Info describes an audio file
+type Info struct {
+	Format      string        // ogg, webm, mp4, mp3 or wav
+	Codec       string        // e.g. opus, aac, mp3, pcm; empty when unknown
+	ContentType string        // MIME type to serve it as
+	Duration    time.Duration // Zero when it can't be read without decoding
+	Video       bool          // The container also carries video
+}
+
+// Playable reports whether browsers can play the file inline as it is
+func (i Info) Playable() bool {
+	if i.Video || i.Duration <= 0 {
+		return false
+	}
+	switch i.Codec {
+	case "opus":
+		return i.Format == "ogg" || i.Format == "webm"
+	case "aac":
+		return i.Format == "mp4"
+	case "mp3":
+		return i.Format == "mp3"
+	}
+	return false
+}
+
+// Probe identifies data's container and codec and reads its duration
+func Probe(data []byte) (Info, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return probeOgg(data)
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return probeWebM(data)
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		return probeMP4(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return probeWAV(data)
+	case bytes.HasPrefix(data, []byte("ID3")), len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		return probeMP3(data)
+	}
+	return Info{}, ErrUnsupported
+}
+
+// Processor validates uploads, transcoding what browsers can't play
+type Processor struct {
+	MaxDuration time.Duration // Longest voice note accepted; 0 means no limit
+	FFmpeg      string        // ffmpeg binary; empty rejects what would need transcoding
+}
+
+// Process checks an upload and returns what to store, transcoded if needed
+func (p Processor) Process(ctx context.Context, data []byte) (Info, []byte, error) {
+	info, err := Probe(data)
+	if errors.Is(err, ErrCorrupt) {
+		return Info{}, nil, err
+	}
+	if err == nil && info.Playable() {
+		return info, data, p.checkDuration(info)
+	}
+	// Don't spend a transcode on something that will be refused anyway
+	if err == nil && info.Duration > 0 {
+		if err := p.checkDuration(info); err != nil {
+			return Info{}, nil, err
+		}
+	}
+
+	if p.FFmpeg == "" {
+		return Info{}, nil, unsupported(info, err)
+	}
+	out, err := transcode(ctx, p.FFmpeg, data)
+	if err != nil {
+		return Info{}, nil, err
+	}
+	info, err = Probe(out)
+	if err != nil || !info.Playable() {
+		return Info{}, nil, fmt.Errorf("transcoded audio is unusable: %w", ErrCorrupt)
+	}
+	return info, out, p.checkDuration(info)
+}
+
+// checkDuration enforces MaxDuration
+func (p Processor) checkDuration(info Info) error {
+	if p.MaxDuration > 0 && info.Duration > p.MaxDuration {
+		return fmt.Errorf("%w: %s, the limit is %s", ErrTooLong, info.Duration.Round(100*time.Millisecond), p.MaxDuration)
+	}
+	return nil
+}
+
+// unsupported explains why a file that needs transcoding was refused
+func unsupported(info Info, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case info.Video:
+		return fmt.Errorf("%w: %s with video", ErrUnsupported, info.Format)
+	case info.Codec == "":
+		return fmt.Errorf("%w: unknown codec in %s", ErrUnsupported, info.Format)
+	}
+	return fmt.Errorf("%w: %s in %s", ErrUnsupported, info.Codec, info.Format)
+}
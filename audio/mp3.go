@@ -0,0 +1,80 @@
+package audio
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Layer III bitrates in kbit/s by bitrate index
+var (
+	mpeg1Bitrates = [15]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mpeg2Bitrates = [15]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+)
+
+// Sample rates by version bits (MPEG 2.5, reserved, MPEG 2, MPEG 1) and index
+var mpegSampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// probeMP3 adds up the frames after any ID3v2 tag
+// Every frame is read, so variable bitrate files get an exact duration
+func probeMP3(data []byte) (Info, error) {
+	info := Info{Format: "mp3", ContentType: "audio/mpeg"}
+	off := 0
+	if len(data) >= 10 && string(data[:3]) == "ID3" {
+		// The tag size is four 7-bit bytes, excluding the header and any footer
+		size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+		off = 10 + size
+		if data[5]&0x10 != 0 {
+			off += 10
+		}
+	}
+
+	var samples, rate int
+	frames := 0
+	for off+4 <= len(data) {
+		length, n, sr, ok := mp3Frame(binary.BigEndian.Uint32(data[off:]))
+		if !ok {
+			break // An ID3v1 tag or trailing junk ends the stream
+		}
+		if off+length > len(data) && frames > 0 {
+			break // A cut-off last frame
+		}
+		samples += n
+		rate = sr
+		frames++
+		off += length
+	}
+	if frames == 0 {
+		return Info{}, ErrCorrupt
+	}
+	info.Codec = "mp3"
+	info.Duration = time.Duration(samples) * time.Second / time.Duration(rate)
+	return info, nil
+}
+
+// mp3Frame decodes a Layer III frame header into the frame's length in
+// bytes, samples and sample rate
+func mp3Frame(h uint32) (length, samples, rate int, ok bool) {
+	if h>>21 != 0x7FF {
+		return 0, 0, 0, false
+	}
+	version := h >> 19 & 3
+	layer := h >> 17 & 3
+	bitrateIndex := h >> 12 & 0xF
+	rateIndex := h >> 10 & 3
+	padding := int(h >> 9 & 1)
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return 0, 0, 0, false
+	}
+	rate = mpegSampleRates[version][rateIndex]
+	if version == 3 {
+		bitrate := mpeg1Bitrates[bitrateIndex] * 1000
+		return 144*bitrate/rate + padding, 1152, rate, true
+	}
+	bitrate := mpeg2Bitrates[bitrateIndex] * 1000
+	return 72*bitrate/rate + padding, 576, rate, true
+}
@@ -0,0 +1,115 @@
+package audio
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// mp4Probe collects what probeMP4 finds while walking the boxes
+type mp4Probe struct {
+	info      Info
+	timescale uint64
+	duration  uint64
+	handler   string // Handler type of the track being read, e.g. soun or vide
+}
+
+// probeMP4 reads the duration from the movie header and the codec from
+// the first audio track's sample description
+func probeMP4(data []byte) (Info, error) {
+	p := &mp4Probe{info: Info{Format: "mp4", ContentType: "audio/mp4"}}
+	if err := p.walk(data); err != nil {
+		return Info{}, err
+	}
+	if p.timescale > 0 {
+		p.info.Duration = time.Duration(p.duration * uint64(time.Second) / p.timescale)
+	}
+	return p.info, nil
+}
+
+// walk reads the boxes in b
+func (p *mp4Probe) walk(b []byte) error {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		kind := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0: // To the end of the file
+			size = uint64(len(b))
+		case 1: // 64-bit size follows the type
+			if len(b) < 16 {
+				return ErrCorrupt
+			}
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			// Media data is often last; a truncated mdat still has a usable header
+			if kind == "mdat" {
+				return nil
+			}
+			return ErrCorrupt
+		}
+		body := b[header:size]
+
+		switch kind {
+		case "moov", "mdia", "minf", "stbl":
+			if err := p.walk(body); err != nil {
+				return err
+			}
+		case "trak":
+			p.handler = ""
+			if err := p.walk(body); err != nil {
+				return err
+			}
+		case "mvhd":
+			p.movieHeader(body)
+		case "hdlr":
+			// Version and flags, pre-defined, then the handler type
+			if len(body) >= 12 {
+				p.handler = string(body[8:12])
+				if p.handler == "vide" {
+					p.info.Video = true
+				}
+			}
+		case "stsd":
+			// Version and flags, entry count, then the first entry's size and format
+			if p.handler == "soun" && p.info.Codec == "" && len(body) >= 16 {
+				p.info.Codec = sampleCodec(string(body[12:16]))
+			}
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// movieHeader reads the timescale and duration from an mvhd box
+func (p *mp4Probe) movieHeader(b []byte) {
+	if len(b) < 20 {
+		return
+	}
+	if b[0] == 1 { // 64-bit times
+		if len(b) >= 32 {
+			p.timescale = uint64(binary.BigEndian.Uint32(b[20:]))
+			p.duration = binary.BigEndian.Uint64(b[24:])
+		}
+		return
+	}
+	p.timescale = uint64(binary.BigEndian.Uint32(b[12:]))
+	p.duration = uint64(binary.BigEndian.Uint32(b[16:]))
+}
+
+// sampleCodec names an audio sample entry format
+func sampleCodec(format string) string {
+	switch format {
+	case "mp4a":
+		return "aac"
+	case "Opus":
+		return "opus"
+	case "alac":
+		return "alac"
+	case "fLaC":
+		return "flac"
+	case "ac-3":
+		return "ac3"
+	}
+	return format
+}
@@ -0,0 +1,66 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// probeOgg reads the first stream's codec from its header packet and
+// its duration from the granule position of its last page
+func probeOgg(data []byte) (Info, error) {
+	info := Info{Format: "ogg", ContentType: "audio/ogg"}
+	var serial uint32
+	var rate, preSkip uint64
+	last := int64(-1)
+	first := true
+	for off := 0; off < len(data); {
+		// Page header: "OggS", version, flags, granule (8), serial (4), sequence (4), CRC (4), segment count
+		if len(data)-off < 27 || string(data[off:off+4]) != "OggS" {
+			return Info{}, ErrCorrupt
+		}
+		segments := int(data[off+26])
+		body := off + 27 + segments
+		if body > len(data) {
+			return Info{}, ErrCorrupt
+		}
+		size := 0
+		for _, n := range data[off+27 : body] {
+			size += int(n)
+		}
+		if body+size > len(data) {
+			return Info{}, ErrCorrupt
+		}
+		granule := int64(binary.LittleEndian.Uint64(data[off+6:]))
+		pageSerial := binary.LittleEndian.Uint32(data[off+14:])
+
+		if first {
+			first = false
+			serial = pageSerial
+			packet := data[body : body+size]
+			switch {
+			case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 12:
+				// Opus granules always count 48 kHz samples
+				info.Codec, rate = "opus", 48000
+				preSkip = uint64(binary.LittleEndian.Uint16(packet[10:]))
+			case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+				info.Codec = "vorbis"
+				rate = uint64(binary.LittleEndian.Uint32(packet[12:]))
+			case bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+				info.Codec = "flac"
+			case bytes.HasPrefix(packet, []byte("Speex   ")):
+				info.Codec = "speex"
+			}
+		}
+		// -1 marks a page on which no packet ends
+		if pageSerial == serial && granule >= 0 {
+			last = granule
+		}
+		off = body + size
+	}
+
+	if rate > 0 && last >= 0 && uint64(last) > preSkip {
+		info.Duration = time.Duration((uint64(last) - preSkip) * uint64(time.Second) / rate)
+	}
+	return info, nil
+}
@@ -0,0 +1,42 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// transcodeTimeout bounds one ffmpeg run
+const transcodeTimeout = 30 * time.Second
+
+// transcode converts data to mono Ogg Opus at a voice bitrate with ffmpeg
+func transcode(ctx context.Context, ffmpeg string, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, transcodeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-vn", "-ac", "1", "-c:a", "libopus", "-b:a", "32k",
+		"-f", "ogg", "pipe:1")
+	cmd.Stdin = bytes.NewReader(data)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// A missing binary or a stuck run is the server's problem, not the file's
+		if cmd.ProcessState == nil || ctx.Err() != nil {
+			return nil, fmt.Errorf("running ffmpeg: %w", err)
+		}
+		// ffmpeg explains itself on stderr; keep the first line
+		reason, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n")
+		if reason == "" {
+			reason = err.Error()
+		}
+		return nil, fmt.Errorf("%w: transcoding failed: %s", ErrUnsupported, reason)
+	}
+	return out.Bytes(), nil
+}
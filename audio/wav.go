@@ -0,0 +1,55 @@
+package audio
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// WAVE format tags
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xFFFE
+)
+
+// probeWAV reads the format chunk and works the duration out from the data size
+func probeWAV(data []byte) (Info, error) {
+	info := Info{Format: "wav", ContentType: "audio/wav"}
+	var byteRate uint64
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := uint64(binary.LittleEndian.Uint32(data[off+4:]))
+		body := data[off+8:]
+		// Streamed files may not know the data size; use what arrived
+		if size > uint64(len(body)) {
+			if id != "data" {
+				return Info{}, ErrCorrupt
+			}
+			size = uint64(len(body))
+		}
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return Info{}, ErrCorrupt
+			}
+			switch binary.LittleEndian.Uint16(body) {
+			case wavPCM, wavExtensible:
+				info.Codec = "pcm"
+			case wavFloat:
+				info.Codec = "float"
+			default:
+				info.Codec = "adpcm"
+			}
+			byteRate = uint64(binary.LittleEndian.Uint32(body[8:]))
+		case "data":
+			if byteRate == 0 {
+				return Info{}, ErrCorrupt
+			}
+			info.Duration = time.Duration(size * uint64(time.Second) / byteRate)
+			return info, nil
+		}
+		off += 8 + int(size) + int(size&1) // Chunks are padded to even sizes
+	}
+	return Info{}, ErrCorrupt
+}
@@ -0,0 +1,171 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+)
+
+// Matroska element IDs read by probeWebM
+const (
+	idEBML          = 0x1A45DFA3
+	idDocType       = 0x4282
+	idSegment       = 0x18538067
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idCodecID       = 0x86
+	idCluster       = 0x1F43B675
+	idTimecode      = 0xE7
+	idSimpleBlock   = 0xA3
+	idBlockGroup    = 0xA0
+	idBlock         = 0xA1
+)
+
+// webmProbe collects what probeWebM finds while walking the elements
+type webmProbe struct {
+	info     Info
+	docType  string
+	scale    uint64  // Nanoseconds per timecode tick
+	duration float64 // From the Info element, in ticks; 0 when absent
+	cluster  int64   // Timecode of the current cluster
+	latest   int64   // Latest block timecode seen; -1 before any
+}
+
+// probeWebM reads the codec from the track list and the duration from the
+// Info element, or from the last block when the recorder left it out, as
+// MediaRecorder does
+func probeWebM(data []byte) (Info, error) {
+	p := &webmProbe{info: Info{Format: "webm", ContentType: "audio/webm"}, scale: 1000000, latest: -1}
+	if err := p.walk(data, 0); err != nil {
+		return Info{}, err
+	}
+	if p.docType != "webm" && p.docType != "matroska" {
+		return Info{}, ErrUnsupported
+	}
+	switch {
+	case p.duration > 0:
+		p.info.Duration = time.Duration(p.duration * float64(p.scale))
+	case p.latest > 0:
+		p.info.Duration = time.Duration(uint64(p.latest) * p.scale)
+	}
+	return p.info, nil
+}
+
+// maxWebMDepth bounds nesting; clusters of unknown size nest one per cluster
+const maxWebMDepth = 1000
+
+// walk reads the elements in b
+func (p *webmProbe) walk(b []byte, depth int) error {
+	if depth > maxWebMDepth {
+		return ErrCorrupt
+	}
+	for len(b) > 0 {
+		id, n := readVint(b, true)
+		if n == 0 {
+			return ErrCorrupt
+		}
+		size, m := readVint(b[n:], false)
+		if m == 0 {
+			return ErrCorrupt
+		}
+		b = b[n+m:]
+		// Unknown sizes (all ones) run to the end of the parent; a
+		// truncated last element is read as far as it goes
+		unknown := size == 1<<(7*m)-1
+		body := b
+		if !unknown && size <= uint64(len(b)) {
+			body = b[:size]
+		}
+
+		switch id {
+		case idEBML, idSegment, idInfo, idTracks, idTrackEntry, idCluster, idBlockGroup:
+			if err := p.walk(body, depth+1); err != nil {
+				return err
+			}
+		case idDocType:
+			p.docType = string(body)
+		case idTimecodeScale:
+			p.scale = readUint(body)
+		case idDuration:
+			p.duration = readFloat(body)
+		case idCodecID:
+			p.codec(string(body))
+		case idTimecode:
+			p.cluster = int64(readUint(body))
+		case idSimpleBlock, idBlock:
+			// Track number, then the timecode relative to the cluster
+			if _, k := readVint(body, false); k > 0 && len(body) >= k+2 {
+				t := p.cluster + int64(int16(binary.BigEndian.Uint16(body[k:])))
+				p.latest = max(p.latest, t)
+			}
+		}
+		if unknown || size > uint64(len(b)) {
+			return nil
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// codec notes a track's codec; the first audio track decides
+func (p *webmProbe) codec(id string) {
+	switch {
+	case strings.HasPrefix(id, "V_"):
+		p.info.Video = true
+	case p.info.Codec != "":
+	case id == "A_OPUS":
+		p.info.Codec = "opus"
+	case id == "A_VORBIS":
+		p.info.Codec = "vorbis"
+	case strings.HasPrefix(id, "A_"):
+		p.info.Codec = strings.ToLower(strings.TrimPrefix(id, "A_"))
+	}
+}
+
+// readVint decodes an EBML variable-length integer, returning its value
+// and length, or a zero length if b doesn't hold one; IDs keep their
+// length marker, sizes don't
+func readVint(b []byte, marker bool) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 || len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0])
+	if !marker {
+		v &= uint64(0xFF >> n)
+	}
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+// readUint decodes a big-endian unsigned integer element
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b[:min(len(b), 8)] {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// readFloat decodes a 4 or 8 byte float element
+func readFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
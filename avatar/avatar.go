@@ -0,0 +1,145 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"  // Registers GIF for Decode
+	_ "image/jpeg" // Registers JPEG for Decode
+	"image/png"
+)
+
+/*
+Avatar Overview:
+---------------
+Avatars are normalized before they are stored, so clients never have
+to crop or scale them and never receive a 4000-pixel photo for a
+32-pixel circle. Process decodes an upload (PNG, JPEG or GIF, whatever
+the client claims; the first frame of an animated GIF), crops it to
+the largest centered square and scales that to each of Sizes:
+
+	photo.jpg 3024x4032 -> crop 3024x3024 at (0, 504)
+	                    -> 32, 64, 128 and 256 pixel PNGs
+
+The renditions are content addressed: an avatar's ID is a hash of its
+largest rendition, so uploading the same picture twice yields the
+same ID, and an ID's images never change and can be cached forever.
+*/
+
+// Sizes are the square renditions of every avatar, in pixels, smallest first
+var Sizes = []int{32, 64, 128, 256}
+
+// MaxDimension bounds uploads either way, in pixels, so a small file
+// can't decode into an enormous image
+const MaxDimension = 4096
+
+// Errors returned by Process
+var (
+	ErrUnsupported = errors.New("avatars must be PNG, JPEG or GIF images")
+	ErrCorrupt     = errors.New("image is corrupt or truncated")
+	ErrTooLarge    = fmt.Errorf("images must be at most %dx%d pixels", MaxDimension, MaxDimension)
+)
+
+// Avatar is a processed upload
+type Avatar struct {
+	ID     string         // Hex hash of the largest rendition
+	Images map[int][]byte // Size -> PNG
+}
+
+// Process decodes, crops and scales an uploaded image
+func Process(data []byte) (Avatar, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return Avatar{}, ErrUnsupported
+	} else if err != nil {
+		return Avatar{}, ErrCorrupt
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return Avatar{}, ErrTooLarge
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return Avatar{}, ErrCorrupt
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Avatar{}, ErrCorrupt
+	}
+
+	square := cropSquare(img)
+	a := Avatar{Images: make(map[int][]byte, len(Sizes))}
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scale(square, size)); err != nil {
+			return Avatar{}, fmt.Errorf("encode %dpx avatar: %w", size, err)
+		}
+		a.Images[size] = buf.Bytes()
+	}
+	sum := sha256.Sum256(a.Images[Sizes[len(Sizes)-1]])
+	a.ID = hex.EncodeToString(sum[:16])
+	return a, nil
+}
+
+// Fit returns the smallest rendition at least size pixels across, or
+// the largest when none is; 0 asks for the largest
+func Fit(size int) int {
+	for _, s := range Sizes {
+		if size > 0 && s >= size {
+			return s
+		}
+	}
+	return Sizes[len(Sizes)-1]
+}
+
+// cropSquare copies the largest centered square of img into an RGBA image
+func cropSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+	return square
+}
+
+// scale resizes a square image to size x size
+// Each output pixel averages the source pixels it covers, which keeps
+// downscaled photos smooth; upscaling repeats pixels
+func scale(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, side, size)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, side, size)
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			// RGBA is premultiplied, so plain averages blend edges correctly
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// span is the source range output pixel i of size covers, never empty
+func span(i, side, size int) (int, int) {
+	start := i * side / size
+	end := (i + 1) * side / size
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
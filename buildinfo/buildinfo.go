@@ -0,0 +1,61 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+/*
+Build Info Overview:
+-------------------
+Identifies the running binary in /api/version, the hello frame
+and logs. Release builds stamp the version at link time:
+
+	go build -ldflags "-X chat-app/buildinfo.Version=v1.4.0 -X chat-app/buildinfo.Commit=$(git rev-parse HEAD)" .
+
+Unstamped builds fall back to what the Go toolchain recorded: the
+module version for `go install`, and the VCS revision for builds
+from a git checkout.
+*/
+
+// Set with -ldflags -X; see the overview
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// Info describes the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Modified  bool   `json:"modified,omitempty"` // Built from a checkout with uncommitted changes
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info, resolving fallbacks on first use
+var Get = sync.OnceValue(func() Info {
+	info := Info{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+})
@@ -0,0 +1,144 @@
+package chaos
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"chat-app/storage"
+)
+
+/*
+Fault Injection Overview:
+------------------------
+Reconnects, resume tokens and acked delivery only earn their keep
+when the network misbehaves, which it rarely does on a developer's
+machine. An Injector makes it misbehave on purpose:
+
+- Latency: each frame written to a client waits a random time up to
+  this long first
+- DropRate: this fraction of frames, each way, silently vanishes
+  (pings and pongs aside, so connections aren't timed out for it)
+- StoreDelay: each storage write waits a random time up to this long,
+  as a slow database would make it
+- Disconnect: connections are cut without a close frame after a
+  random lifetime averaging this long, as a flaky network would cut
+  them
+
+It is meant for test and staging servers only; config refuses it in
+release mode. Every fault is off at its zero value, and New returns
+nil when they all are. A nil *Injector injects nothing.
+*/
+
+// Faults is what an Injector injects
+type Faults struct {
+	Latency    time.Duration // Up to this much added before each outgoing frame
+	DropRate   float64       // Fraction of frames dropped, from 0 to 1
+	StoreDelay time.Duration // Up to this much added to each storage write
+	Disconnect time.Duration // Mean connection lifetime before it is cut; 0 never
+}
+
+// Enabled reports whether any fault is set
+func (f Faults) Enabled() bool {
+	return f.Latency > 0 || f.DropRate > 0 || f.StoreDelay > 0 || f.Disconnect > 0
+}
+
+// Injector decides when faults happen
+type Injector struct {
+	faults Faults
+}
+
+// New returns an injector for f, or nil when f injects nothing
+func New(f Faults) *Injector {
+	if !f.Enabled() {
+		return nil
+	}
+	return &Injector{faults: f}
+}
+
+// Faults returns what i injects
+func (i *Injector) Faults() Faults {
+	if i == nil {
+		return Faults{}
+	}
+	return i.faults
+}
+
+// Delay sleeps for the latency of one outgoing frame
+func (i *Injector) Delay() {
+	if i == nil {
+		return
+	}
+	sleepUpTo(i.faults.Latency)
+}
+
+// Drop reports whether the next frame should be dropped
+func (i *Injector) Drop() bool {
+	return i != nil && i.faults.DropRate > 0 && rand.Float64() < i.faults.DropRate
+}
+
+// Lifetime returns how long a new connection may live before it is
+// cut, exponentially distributed around Disconnect; 0 means forever
+func (i *Injector) Lifetime() time.Duration {
+	if i == nil || i.faults.Disconnect <= 0 {
+		return 0
+	}
+	return max(time.Duration(rand.ExpFloat64()*float64(i.faults.Disconnect)), time.Millisecond)
+}
+
+// sleepUpTo sleeps a uniformly random time below d
+func sleepUpTo(d time.Duration) {
+	if d > 0 {
+		time.Sleep(rand.N(d))
+	}
+}
+
+// Store returns s with its writes delayed, or s itself when i doesn't
+// delay writes
+func (i *Injector) Store(s storage.Store) storage.Store {
+	if i == nil || i.faults.StoreDelay <= 0 {
+		return s
+	}
+	return &slowStore{Store: s, delay: i.faults.StoreDelay}
+}
+
+// slowStore delays the writes the hub and event log make on every message
+type slowStore struct {
+	storage.Store
+	delay time.Duration
+}
+
+func (s *slowStore) SaveMessage(ctx context.Context, msg storage.Message) error {
+	sleepUpTo(s.delay)
+	return s.Store.SaveMessage(ctx, msg)
+}
+
+func (s *slowStore) DeleteMessage(ctx context.Context, id string) error {
+	sleepUpTo(s.delay)
+	return s.Store.DeleteMessage(ctx, id)
+}
+
+func (s *slowStore) AddMember(ctx context.Context, room, username string) error {
+	sleepUpTo(s.delay)
+	return s.Store.AddMember(ctx, room, username)
+}
+
+func (s *slowStore) QueueOffline(ctx context.Context, username, room, messageID string) error {
+	sleepUpTo(s.delay)
+	return s.Store.QueueOffline(ctx, username, room, messageID)
+}
+
+func (s *slowStore) QueueDirect(ctx context.Context, dm storage.DirectMessage) error {
+	sleepUpTo(s.delay)
+	return s.Store.QueueDirect(ctx, dm)
+}
+
+func (s *slowStore) AppendEvent(ctx context.Context, ev storage.Event) (storage.Event, error) {
+	sleepUpTo(s.delay)
+	return s.Store.AppendEvent(ctx, ev)
+}
+
+func (s *slowStore) SaveRoomSettings(ctx context.Context, settings storage.RoomSettings) error {
+	sleepUpTo(s.delay)
+	return s.Store.SaveRoomSettings(ctx, settings)
+}
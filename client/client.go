@@ -0,0 +1,437 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+/*
+Client Library Overview:
+-----------------------
+A small Go client for the chat server, used by the built-in
+tools (load tester, terminal client) and by anyone scripting
+against a deployment.
+
+Usage:
+	conn, err := client.Dial(ctx, "ws://localhost:8080", "room1", "alice")
+	conn.Send("hello")
+	msg, err := conn.Receive()
+	page, err := client.History(ctx, "ws://localhost:8080", "room1", "alice", 0, 50)
+
+Receive must be called from a single goroutine; Send is safe
+to call concurrently.
+
+The library also builds for GOOS=js GOARCH=wasm, for Go frontends
+in the browser: the connection is then the browser's WebSocket
+(see transport_js.go) and History goes through fetch, so a web
+client speaks the protocol with the same code as the tools and
+tests.
+*/
+
+// Message mirrors the server's wire format
+type Message struct {
+	Type           string `json:"type"`
+	ID             string `json:"id,omitempty"`
+	Content        string `json:"content"`
+	Room           string `json:"room"`
+	Username       string `json:"username"`
+	Seq            uint64 `json:"seq,omitempty"`
+	Code           string `json:"code,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	QoS            string `json:"qos,omitempty"`
+	Redelivered    bool   `json:"redelivered,omitempty"`
+
+	// Set on "reconnect" frames: the server base URL to Dial (empty
+	// means the same one) and how long to wait before doing so. URL is
+	// also the link on "onboarding" frames with Code "link"
+	URL          string `json:"url,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+
+	// Set on the "hello" frame that opens every connection: the
+	// server's protocol version and build
+	Protocol int    `json:"protocol,omitempty"`
+	Server   string `json:"server,omitempty"`
+
+	// The optional features the server offers, on "hello" frames, and
+	// those this connection accepted, on "hello_ack" frames; see Hello
+	Features []string `json:"features,omitempty"`
+
+	// This connection's ID, on "hello" and "error" frames; quote it
+	// when reporting problems
+	ConnID string `json:"conn_id,omitempty"`
+
+	// The room's members on "online_users" frames; see Presence
+	Users []Member `json:"users,omitempty"`
+
+	// Username -> device type and avatar image URL on "online_users"
+	// frames from protocol 1 servers, which list members in Content
+	Devices map[string]string `json:"devices,omitempty"`
+	Avatars map[string]string `json:"avatars,omitempty"`
+
+	// The member's device type, avatar URL and status on
+	// "presence_join" frames, as in Member
+	Device string `json:"device,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+	Status string `json:"status,omitempty"`
+
+	// The server's clock in Unix milliseconds: when a message was
+	// posted, or when a "hello", "heartbeat" or "time" frame was sent;
+	// see Clock. ClientTime is this machine's, echoed on "time" frames
+	ServerTime int64 `json:"server_time,omitempty"`
+	ClientTime int64 `json:"client_time,omitempty"`
+
+	// How long this connection may go without answering "heartbeat"
+	// frames before it shows as away; Receive answers them
+	TTLMs int64 `json:"ttl_ms,omitempty"`
+
+	// This connection's round-trip time in milliseconds on "rtt"
+	// frames, sent after each ping when the server reports them
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+
+	// Toxicity score on "moderation" frames, whose Code says whether
+	// the message with this ID was hidden, deleted or restored
+	Score float64 `json:"score,omitempty"`
+
+	// The review queue entry on "mod_queue" frames, sent to moderators;
+	// Code says whether it was added, updated or resolved
+	Review json.RawMessage `json:"review,omitempty"`
+
+	// The matching message and the room's preceding messages on
+	// "keyword_alert" frames, whose Content is the keyword that matched
+	Alert *Alert `json:"alert,omitempty"`
+
+	// Options on "onboarding" frames with Code "role_picker"
+	Choices []string `json:"choices,omitempty"`
+
+	// Content as styled runs on "chat" and "announcement" frames that
+	// use Markdown; absent when Content should be shown as it is
+	Formatted []Segment `json:"formatted,omitempty"`
+
+	// The sticker on "sticker" frames, whose Content is its alt text
+	Sticker *Sticker `json:"sticker,omitempty"`
+
+	// The room's sticker packs on "sticker_packs" frames, sent on join
+	Packs []StickerPack `json:"packs,omitempty"`
+
+	// The voice note on "audio" frames, whose Content is a text fallback
+	Audio *Audio `json:"audio,omitempty"`
+
+	// The file on "attachment" frames, whose Content is its name
+	Attachment *Attachment `json:"attachment,omitempty"`
+
+	// The message replied to on "chat" frames that are replies, as the
+	// server quoted it when the reply was posted
+	Quote *Quote `json:"quote,omitempty"`
+
+	// The file on "transfer_*" frames, the peer's WebRTC signal on
+	// "transfer_signal" frames, and the ICE servers to connect with on
+	// "transfer_sent", "transfer_offer" and "transfer_accept" frames
+	Transfer   *Transfer       `json:"transfer,omitempty"`
+	Signal     json.RawMessage `json:"signal,omitempty"`
+	ICEServers []ICEServer     `json:"ice_servers,omitempty"`
+}
+
+// Segment is a run of message text with its styles; render it as text
+type Segment struct {
+	Text   string `json:"text"`
+	Bold   bool   `json:"bold,omitempty"`
+	Italic bool   `json:"italic,omitempty"`
+	Code   bool   `json:"code,omitempty"`
+	Link   string `json:"link,omitempty"` // http, https or mailto
+}
+
+// Quote is the start of a message a reply quotes
+type Quote struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Excerpt   string `json:"excerpt"`
+	Truncated bool   `json:"truncated,omitempty"` // The message went on past the excerpt
+}
+
+// Sticker names a sticker; URL is relative to the server
+type Sticker struct {
+	Pack string `json:"pack"`
+	ID   string `json:"id"`
+	Alt  string `json:"alt,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// StickerPack is a pack of stickers offered in the room
+type StickerPack struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Stickers []Sticker `json:"stickers"`
+}
+
+// Audio describes a voice note; URL is relative to the server
+type Audio struct {
+	ID          string `json:"id"`
+	URL         string `json:"url,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	DurationMs  int64  `json:"duration_ms,omitempty"`
+	Size        int    `json:"size,omitempty"`
+}
+
+// Attachment describes a file uploaded to S3; URL is relative to the
+// server and redirects to a short-lived download link
+type Attachment struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// Transfer describes a file offered peer to peer
+type Transfer struct {
+	ID   string `json:"id,omitempty"`
+	To   string `json:"to,omitempty"`
+	Name string `json:"name,omitempty"`
+	Size int64  `json:"size,omitempty"`
+	MIME string `json:"mime,omitempty"`
+}
+
+// ICEServer is a WebRTC ICE server, shaped like RTCIceServer
+type ICEServer struct {
+	URLs []string `json:"urls"`
+}
+
+// Alert is the payload of a "keyword_alert" frame
+type Alert struct {
+	Message Message   `json:"message"`
+	Context []Message `json:"context"`
+}
+
+// ProtocolVersion is the newest server protocol this library understands
+const ProtocolVersion = 2
+
+// Conn is a connection to a single chat room
+type Conn struct {
+	Room     string
+	Username string
+
+	ws      transport
+	writeMu sync.Mutex // gorilla allows only one concurrent writer
+}
+
+// transport is a WebSocket carrying text frames: gorilla's natively,
+// the browser's under js/wasm
+type transport interface {
+	WriteText(data []byte) error
+	ReadText() ([]byte, error)
+	Close() error // Sends a close frame, then closes
+}
+
+// Dial connects to a room on the server at baseURL (e.g. ws://localhost:8080)
+func Dial(ctx context.Context, baseURL, room, username string) (*Conn, error) {
+	u, err := RoomURL(baseURL, room, username)
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := dialTransport(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", u, err)
+	}
+
+	return &Conn{Room: room, Username: username, ws: ws}, nil
+}
+
+// RoomURL builds the WebSocket URL for joining room as username
+func RoomURL(baseURL, room, username string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return "", fmt.Errorf("parse server URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	u.Path += "/ws/" + url.PathEscape(room)
+	u.RawQuery = url.Values{"username": {username}}.Encode()
+	return u.String(), nil
+}
+
+// Send posts a chat message to the room
+func (c *Conn) Send(text string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteText([]byte(text))
+}
+
+// SendIdempotent posts a chat message the server will accept at most once
+// per key; retrying with the same key after a reconnect returns the
+// original ack instead of a duplicate message
+func (c *Conn) SendIdempotent(text, key string) error {
+	return c.SendJSON(map[string]string{
+		"type":            "chat",
+		"content":         text,
+		"idempotency_key": key,
+	})
+}
+
+// SendQoS posts a chat message with a delivery guarantee
+// ("at_least_once" or "durable"); recipients must Ack it
+func (c *Conn) SendQoS(text, qos string) error {
+	return c.SendJSON(map[string]string{
+		"type":    "chat",
+		"content": text,
+		"qos":     qos,
+	})
+}
+
+// SendReply posts a chat message quoting the room's message with ID id
+func (c *Conn) SendReply(id, text string) error {
+	return c.SendJSON(map[string]string{
+		"type":     "reply",
+		"reply_to": id,
+		"content":  text,
+	})
+}
+
+// SendSticker posts a sticker from one of the room's packs
+func (c *Conn) SendSticker(pack, id string) error {
+	return c.SendJSON(map[string]any{
+		"type":    "sticker",
+		"sticker": Sticker{Pack: pack, ID: id},
+	})
+}
+
+// SendAudio posts a voice note uploaded to POST /api/rooms/:room/audio
+// by this user, using the id the upload returned
+func (c *Conn) SendAudio(id string) error {
+	return c.SendJSON(map[string]any{
+		"type":  "audio",
+		"audio": Audio{ID: id},
+	})
+}
+
+// SendAttachment posts a file this user uploaded with a URL from
+// POST /api/rooms/:room/uploads, once the upload has finished
+func (c *Conn) SendAttachment(id string) error {
+	return c.SendJSON(map[string]any{
+		"type":       "attachment",
+		"attachment": Attachment{ID: id},
+	})
+}
+
+// OfferTransfer offers a file to another member of the room; the
+// transfer's ID comes back in a "transfer_sent" frame
+func (c *Conn) OfferTransfer(to, name string, size int64, mime string) error {
+	return c.SendJSON(map[string]any{
+		"type":     "transfer_offer",
+		"transfer": Transfer{To: to, Name: name, Size: size, MIME: mime},
+	})
+}
+
+// AnswerTransfer accepts or declines a "transfer_offer"
+func (c *Conn) AnswerTransfer(id string, accept bool) error {
+	msgType := "transfer_decline"
+	if accept {
+		msgType = "transfer_accept"
+	}
+	return c.SendJSON(map[string]string{"type": msgType, "id": id})
+}
+
+// CancelTransfer withdraws an offer or abandons an accepted transfer
+func (c *Conn) CancelTransfer(id string) error {
+	return c.SendJSON(map[string]string{"type": "transfer_cancel", "id": id})
+}
+
+// Signal passes a WebRTC session description or ICE candidate, which
+// must encode as a JSON object, to the other side of an accepted transfer
+func (c *Conn) Signal(id string, signal any) error {
+	return c.SendJSON(map[string]any{
+		"type":   "transfer_signal",
+		"id":     id,
+		"signal": signal,
+	})
+}
+
+// Ack confirms receipt of a message that carried a qos,
+// stopping the server from re-sending it
+func (c *Conn) Ack(id string) error {
+	return c.SendJSON(map[string]string{
+		"type": "ack",
+		"id":   id,
+	})
+}
+
+// Report flags a message in the room for moderators
+func (c *Conn) Report(id, reason string) error {
+	return c.SendJSON(map[string]string{
+		"type":    "report",
+		"id":      id,
+		"content": reason,
+	})
+}
+
+// Pin pins a message in the room, or unpins it when pinned is false
+// The room is told with a "pin" or "unpin" frame
+func (c *Conn) Pin(id string, pinned bool) error {
+	msgType := "pin"
+	if !pinned {
+		msgType = "unpin"
+	}
+	return c.SendJSON(map[string]string{"type": msgType, "id": id})
+}
+
+// Recall takes back a message this user sent, within the server's
+// recall window; the room is told with a "recall" frame
+func (c *Conn) Recall(id string) error {
+	return c.SendJSON(map[string]string{"type": "recall", "id": id})
+}
+
+// Hello tells the server which optional features this connection
+// handles; it answers with a "hello_ack" frame, and from then on sends
+// no frames for the others
+func (c *Conn) Hello(features ...string) error {
+	if features == nil {
+		features = []string{}
+	}
+	return c.SendJSON(map[string]any{"type": "hello", "features": features})
+}
+
+// SendJSON writes an arbitrary JSON frame
+func (c *Conn) SendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteText(data)
+}
+
+// Receive blocks until the next message from the server arrives
+// Heartbeats are answered before they are returned, so a connection
+// stays online for as long as something is receiving on it
+func (c *Conn) Receive() (Message, error) {
+	var msg Message
+	data, err := c.ws.ReadText()
+	if err != nil {
+		return msg, err
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, fmt.Errorf("decode message: %w", err)
+	}
+	if msg.Type == "heartbeat" {
+		if err := c.SendJSON(map[string]string{"type": "heartbeat"}); err != nil {
+			return msg, err
+		}
+	}
+	return msg, nil
+}
+
+// Close sends a close frame and closes the connection
+func (c *Conn) Close() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.Close()
+}
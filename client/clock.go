@@ -0,0 +1,77 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock estimates how far the server's clock is ahead of this one, to
+// show server times (Message.ServerTime, HistoryEntry.CreatedAt) on the
+// local clock. Feed it every frame with Apply and call Conn.SyncClock
+// after connecting; the zero value assumes the clocks agree. It is safe
+// for concurrent use
+type Clock struct {
+	mu     sync.Mutex
+	offset time.Duration // Server clock minus local clock
+	rtt    time.Duration // Round trip of the exact sample offset came from
+	exact  bool          // offset came from a time frame, not a one-way guess
+}
+
+// Apply updates the estimate from a frame received at received, reporting
+// whether the frame carried the server's clock. A "time" frame answering
+// SyncClock gives an estimate within half its round trip, and the one
+// with the shortest round trip is kept. "hello" and "heartbeat" frames
+// give a rough one, used until there is a better
+func (c *Clock) Apply(msg Message, received time.Time) bool {
+	if msg.ServerTime == 0 {
+		return false
+	}
+	server := time.UnixMilli(msg.ServerTime)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch msg.Type {
+	case "time":
+		sent := time.UnixMilli(msg.ClientTime)
+		rtt := received.Sub(sent)
+		if msg.ClientTime == 0 || rtt < 0 || (c.exact && rtt >= c.rtt) {
+			return true
+		}
+		c.offset = server.Sub(sent.Add(rtt / 2))
+		c.rtt, c.exact = rtt, true
+	case "hello", "heartbeat":
+		if !c.exact {
+			c.offset = server.Sub(received)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// Offset is how far the server's clock is ahead of this one
+func (c *Clock) Offset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset
+}
+
+// Local converts a time on the server's clock to this one
+func (c *Clock) Local(server time.Time) time.Time {
+	return server.Add(-c.Offset())
+}
+
+// MessageTime is when msg was posted, on this clock; for frames without
+// a server time, such as those from older servers, it is now
+func (c *Clock) MessageTime(msg Message) time.Time {
+	if msg.ServerTime == 0 {
+		return time.Now()
+	}
+	return c.Local(time.UnixMilli(msg.ServerTime))
+}
+
+// SyncClock asks the server for its clock; pass the "time" frame it
+// answers with to Clock.Apply
+func (c *Conn) SyncClock() error {
+	return c.SendJSON(map[string]any{"type": "time", "client_time": time.Now().UnixMilli()})
+}
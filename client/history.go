@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryEntry is a message from a room's history
+type HistoryEntry struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	Seq       uint64    `json:"seq"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HistoryPage is a page of a room's history, oldest first
+type HistoryPage struct {
+	Messages []HistoryEntry `json:"messages"`
+	Next     uint64         `json:"next"` // before for the older page; 0 when there is none
+}
+
+// History loads up to limit of room's messages numbered before seq
+// before, or the newest with before 0, as username
+func History(ctx context.Context, baseURL, room, username string, before uint64, limit int) (HistoryPage, error) {
+	u, err := HTTPURL(baseURL)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	query := url.Values{"username": {username}, "limit": {strconv.Itoa(limit)}}
+	if before > 0 {
+		query.Set("before", strconv.FormatUint(before, 10))
+	}
+	u += "/api/rooms/" + url.PathEscape(room) + "/history?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return HistoryPage{}, fmt.Errorf("load history: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return HistoryPage{}, fmt.Errorf("load history: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var page HistoryPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return HistoryPage{}, fmt.Errorf("decode history: %w", err)
+	}
+	return page, nil
+}
+
+// HTTPURL turns a server base URL given for Dial (ws:// or wss://) into
+// the matching http:// or https:// one, for the REST API and the
+// server-relative URLs in messages
+func HTTPURL(baseURL string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return "", fmt.Errorf("parse server URL: %w", err)
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	return u.String(), nil
+}
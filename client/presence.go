@@ -0,0 +1,69 @@
+package client
+
+import (
+	"slices"
+	"strings"
+)
+
+// Member is someone in a room, as presence frames describe them
+type Member struct {
+	Username string `json:"username"`
+	Device   string `json:"device,omitempty"` // Empty unless the server shares device types
+	Avatar   string `json:"avatar,omitempty"` // Image URL relative to the server; empty without one
+	Status   string `json:"status,omitempty"` // "away" after missed heartbeats; empty while online
+}
+
+// Presence follows a room's members through its presence frames: an
+// "online_users" snapshot on joining, then "presence_join" and
+// "presence_leave" deltas. Protocol 1 servers send a full
+// "online_users" on every change instead, listing members in Content,
+// which works the same. The zero value is empty and ready to use; it is
+// not safe for concurrent use
+type Presence struct {
+	members map[string]Member
+}
+
+// Apply updates p from msg, reporting whether msg was a presence frame
+func (p *Presence) Apply(msg Message) bool {
+	switch msg.Type {
+	case "online_users":
+		p.members = make(map[string]Member)
+		for _, m := range msg.Users {
+			p.members[m.Username] = m
+		}
+		if msg.Users != nil {
+			break
+		}
+		for _, user := range strings.Split(msg.Content, ",") {
+			if user != "" {
+				p.members[user] = Member{Username: user, Device: msg.Devices[user], Avatar: msg.Avatars[user]}
+			}
+		}
+	case "presence_join":
+		if p.members == nil {
+			p.members = make(map[string]Member)
+		}
+		p.members[msg.Username] = Member{Username: msg.Username, Device: msg.Device, Avatar: msg.Avatar, Status: msg.Status}
+	case "presence_leave":
+		delete(p.members, msg.Username)
+	default:
+		return false
+	}
+	return true
+}
+
+// Users lists the members' usernames, sorted
+func (p *Presence) Users() []string {
+	users := make([]string, 0, len(p.members))
+	for user := range p.members {
+		users = append(users, user)
+	}
+	slices.Sort(users)
+	return users
+}
+
+// Member looks up a member by username
+func (p *Presence) Member(username string) (Member, bool) {
+	m, ok := p.members[username]
+	return m, ok
+}
@@ -0,0 +1,37 @@
+//go:build !js
+
+package client
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+)
+
+// gorillaTransport is a WebSocket over the network, for everything but the browser
+type gorillaTransport struct {
+	ws *websocket.Conn
+}
+
+func dialTransport(ctx context.Context, u string) (transport, error) {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return gorillaTransport{ws: ws}, nil
+}
+
+func (t gorillaTransport) WriteText(data []byte) error {
+	return t.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+func (t gorillaTransport) ReadText() ([]byte, error) {
+	_, data, err := t.ws.ReadMessage()
+	return data, err
+}
+
+func (t gorillaTransport) Close() error {
+	t.ws.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return t.ws.Close()
+}
@@ -0,0 +1,185 @@
+//go:build js && wasm
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+)
+
+/*
+Browser Transport Overview:
+--------------------------
+Under js/wasm there are no sockets, so Conn runs over the browser's
+own WebSocket object through syscall/js. Its events arrive as
+callbacks on the JavaScript event loop, which must never block
+(a blocked callback stalls the whole page, and anything else waiting
+on the loop, like fetch, deadlocks). So the callbacks only queue
+frames and record the close; ReadText waits on the queue from its
+own goroutine.
+
+Ping and pong are answered by the browser, as gorilla does natively.
+*/
+
+// jsTransport is the browser's WebSocket
+type jsTransport struct {
+	ws    js.Value
+	funcs []js.Func // Released once the socket closes
+
+	mu     sync.Mutex
+	queue  [][]byte      // Frames received and not yet read
+	err    error         // Why the socket closed; nil while open
+	signal chan struct{} // Nudges ReadText when queue or err change
+}
+
+// WebSocket.readyState values
+const (
+	jsConnecting = 0
+	jsOpen       = 1
+)
+
+func dialTransport(ctx context.Context, u string) (transport, error) {
+	ctor := js.Global().Get("WebSocket")
+	if ctor.IsUndefined() {
+		return nil, errors.New("this JavaScript environment has no WebSocket")
+	}
+
+	var ws js.Value
+	if err := catch(func() { ws = ctor.New(u) }); err != nil {
+		return nil, err // A malformed URL throws
+	}
+	ws.Set("binaryType", "arraybuffer")
+	t := &jsTransport{ws: ws, signal: make(chan struct{}, 1)}
+
+	opened := make(chan error, 1)
+	t.on("open", func(js.Value) {
+		opened <- nil
+	})
+	t.on("message", func(ev js.Value) {
+		t.push(frameBytes(ev.Get("data")))
+	})
+	fail := func(err error) {
+		select {
+		case opened <- err: // Failed before it opened: the dial failed
+		default:
+		}
+		t.fail(err)
+	}
+	// Browsers follow an error with a close event, but not every runtime does
+	t.on("error", func(js.Value) {
+		fail(errors.New("websocket error")) // The event carries no details
+	})
+	t.on("close", func(ev js.Value) {
+		fail(fmt.Errorf("websocket closed: %d %s", ev.Get("code").Int(), ev.Get("reason").String()))
+		for _, fn := range t.funcs {
+			fn.Release() // Allowed while fn runs; the socket fires no more events
+		}
+	})
+
+	select {
+	case err := <-opened:
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case <-ctx.Done():
+		ws.Call("close")
+		return nil, ctx.Err()
+	}
+}
+
+// on adds an event listener; f runs on the event loop, so it must not block
+func (t *jsTransport) on(event string, f func(ev js.Value)) {
+	fn := js.FuncOf(func(this js.Value, args []js.Value) any {
+		f(args[0])
+		return nil
+	})
+	t.funcs = append(t.funcs, fn)
+	t.ws.Call("addEventListener", event, fn)
+}
+
+// push queues a received frame for ReadText
+func (t *jsTransport) push(data []byte) {
+	t.mu.Lock()
+	t.queue = append(t.queue, data)
+	t.mu.Unlock()
+	t.nudge()
+}
+
+// fail records why the socket stopped working, keeping the first reason
+func (t *jsTransport) fail(err error) {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.mu.Unlock()
+	t.nudge()
+}
+
+func (t *jsTransport) nudge() {
+	select {
+	case t.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (t *jsTransport) ReadText() ([]byte, error) {
+	for {
+		t.mu.Lock()
+		if len(t.queue) > 0 {
+			data := t.queue[0]
+			t.queue = t.queue[1:]
+			t.mu.Unlock()
+			return data, nil
+		}
+		err := t.err
+		t.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		<-t.signal
+	}
+}
+
+func (t *jsTransport) WriteText(data []byte) error {
+	if state := t.ws.Get("readyState").Int(); state != jsOpen {
+		return fmt.Errorf("websocket is not open (readyState %d)", state)
+	}
+	return catch(func() { t.ws.Call("send", string(data)) })
+}
+
+func (t *jsTransport) Close() error {
+	if state := t.ws.Get("readyState").Int(); state == jsConnecting || state == jsOpen {
+		t.ws.Call("close", 1000)
+	}
+	return nil
+}
+
+// frameBytes copies a message event's data, text or binary, into Go
+func frameBytes(data js.Value) []byte {
+	if data.Type() == js.TypeString {
+		return []byte(data.String())
+	}
+	array := js.Global().Get("Uint8Array").New(data)
+	buf := make([]byte, array.Get("length").Int())
+	js.CopyBytesToGo(buf, array)
+	return buf
+}
+
+// catch runs f, returning a JavaScript exception it throws as an error
+func catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if jsErr, ok := r.(js.Error); ok {
+				err = jsErr
+				return
+			}
+			panic(r)
+		}
+	}()
+	f()
+	return nil
+}
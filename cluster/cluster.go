@@ -0,0 +1,417 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"chat-app/config"
+	"chat-app/metrics"
+
+	"github.com/hashicorp/memberlist"
+)
+
+/*
+Cluster Overview:
+----------------
+Nodes find each other with memberlist, a SWIM-style gossip protocol:
+every node probes a few random peers each round, failures are
+confirmed indirectly through other peers, and membership changes
+spread epidemically. There is no leader and no central registry;
+a new node only needs the address of any existing one to join.
+
+Each node gossips a small JSON metadata blob (NodeMeta) with its
+HTTP address and current load. The metadata is refreshed every
+healthInterval so the cluster view shows live numbers for every
+node.
+
+Nodes that leave or fail stay in the view for forgetAfter so
+operators can see what happened before they disappear.
+
+Alive nodes that advertise an HTTP address form a consistent-hash
+ring (ring.go) that assigns every room an owner, and exchange room
+traffic over peer links (link.go).
+*/
+
+const (
+	// How often this node re-advertises its health
+	healthInterval = 5 * time.Second
+
+	// How long a departed node stays in the view
+	forgetAfter = 10 * time.Minute
+
+	// How long gossip is given to announce a graceful leave
+	leaveTimeout = 5 * time.Second
+)
+
+// Node states reported in the cluster view
+const (
+	StateAlive   = "alive"
+	StateSuspect = "suspect"
+	StateDead    = "dead"
+	StateLeft    = "left"
+)
+
+// NodeMeta is what each node gossips about itself
+type NodeMeta struct {
+	HTTPAddr    string    `json:"http_addr"` // Where clients and peers reach its HTTP API
+	StartedAt   time.Time `json:"started_at"`
+	Connections int       `json:"connections"`
+	Rooms       int       `json:"rooms"`
+}
+
+// Member is one node in the cluster view
+type Member struct {
+	Name      string    `json:"name"`
+	Addr      string    `json:"addr"` // Gossip address
+	State     string    `json:"state"`
+	Local     bool      `json:"local"`
+	Meta      NodeMeta  `json:"meta"`
+	UpdatedAt time.Time `json:"updated_at"` // Last state or metadata change seen
+}
+
+// errNoHTTPAddr is returned when dialling a node that advertises no HTTP address
+var errNoHTTPAddr = errors.New("node advertises no HTTP address")
+
+// StatsFunc reports this node's current load for its metadata
+type StatsFunc func() (connections, rooms int)
+
+// Cluster is this node's membership in the gossip cluster
+type Cluster struct {
+	ml     *memberlist.Memberlist
+	name   string
+	secret string // Authenticates peer links
+	stats  StatsFunc
+	stop   chan struct{}
+
+	mu        sync.RWMutex
+	meta      NodeMeta
+	members   map[string]Member               // Everyone seen recently, by node name
+	ring      *Ring                           // Room owners among alive nodes
+	listeners []func()                        // Called after any membership change
+	onFrame   func(from string, frame []byte) // Receives frames from peer links
+
+	linksMu sync.Mutex
+	links   map[string]*peerLink // Outgoing links by node name
+}
+
+// Start binds the gossip listener and joins any reachable seed nodes
+// stats may be nil and set later with SetStats
+// Failing to reach the seeds is not fatal: the node runs as a
+// cluster of one and others can join it later
+func Start(cfg config.ClusterConfig, httpAddr string, stats StatsFunc) (*Cluster, error) {
+	c := &Cluster{
+		secret:  cfg.Secret,
+		stats:   stats,
+		stop:    make(chan struct{}),
+		meta:    NodeMeta{HTTPAddr: httpAddr, StartedAt: time.Now().UTC()},
+		members: make(map[string]Member),
+		ring:    NewRing(nil),
+		links:   make(map[string]*peerLink),
+	}
+
+	mlConfig, err := memberlistConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	mlConfig.Delegate = (*delegate)(c)
+	mlConfig.Events = (*events)(c)
+	c.name = mlConfig.Name
+
+	c.ml, err = memberlist.Create(mlConfig)
+	if err != nil {
+		return nil, fmt.Errorf("start gossip: %w", err)
+	}
+
+	if len(cfg.Join) > 0 {
+		n, err := c.ml.Join(cfg.Join)
+		if err != nil {
+			log.Printf("Cluster: could not reach seeds %v: %v", cfg.Join, err)
+		} else {
+			log.Printf("Cluster: joined via %d of %d seeds", n, len(cfg.Join))
+		}
+	}
+
+	go c.refreshHealth()
+	return c, nil
+}
+
+// memberlistConfig translates our settings into memberlist's
+func memberlistConfig(cfg config.ClusterConfig) (*memberlist.Config, error) {
+	mlConfig := memberlist.DefaultLANConfig()
+	mlConfig.LogOutput = io.Discard // Membership changes are logged by events
+
+	mlConfig.Name = cfg.NodeName
+	if mlConfig.Name == "" {
+		hostname, _ := os.Hostname()
+		mlConfig.Name = hostname
+	}
+
+	host, port, err := splitHostPort(cfg.BindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster bind address: %w", err)
+	}
+	mlConfig.BindAddr, mlConfig.BindPort = host, port
+	mlConfig.AdvertisePort = port
+	if cfg.AdvertiseAddr != "" {
+		host, port, err := splitHostPort(cfg.AdvertiseAddr)
+		if err != nil {
+			return nil, fmt.Errorf("cluster advertise address: %w", err)
+		}
+		mlConfig.AdvertiseAddr, mlConfig.AdvertisePort = host, port
+	}
+
+	if cfg.Secret != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("cluster secret must be base64: %w", err)
+		}
+		mlConfig.SecretKey = key // memberlist validates the 16/24/32 byte length
+	}
+	return mlConfig, nil
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, port, nil
+}
+
+// Name is this node's name in the cluster
+func (c *Cluster) Name() string {
+	return c.name
+}
+
+// Members returns every node seen recently, this node first then by name
+func (c *Cluster) Members() []Member {
+	// Only memberlist knows who is currently suspected of failing
+	suspect := make(map[string]bool)
+	for _, n := range c.ml.Members() {
+		if n.State == memberlist.StateSuspect {
+			suspect[n.Name] = true
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	members := make([]Member, 0, len(c.members))
+	for _, m := range c.members {
+		if suspect[m.Name] {
+			m.State = StateSuspect
+		}
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Local != members[j].Local {
+			return members[i].Local
+		}
+		return members[i].Name < members[j].Name
+	})
+	return members
+}
+
+// AliveMembers returns the nodes currently believed healthy, sorted by name
+func (c *Cluster) AliveMembers() []Member {
+	var alive []Member
+	for _, m := range c.Members() {
+		if m.State == StateAlive {
+			alive = append(alive, m)
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool { return alive[i].Name < alive[j].Name })
+	return alive
+}
+
+// SetStats replaces the function reporting this node's load
+func (c *Cluster) SetStats(stats StatsFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = stats
+}
+
+// Alive reports whether node is currently believed healthy
+func (c *Cluster) Alive(node string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.members[node].State == StateAlive
+}
+
+// Owner returns the node that owns room, or "" if no node can
+func (c *Cluster) Owner(room string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Owner(room)
+}
+
+// httpAddr is node's advertised HTTP address, if known
+func (c *Cluster) httpAddr(node string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.members[node].Meta.HTTPAddr
+}
+
+// OnChange registers fn to run after any membership change
+// fn runs on the gossip goroutine and must not block
+func (c *Cluster) OnChange(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// Leave announces a graceful departure and stops gossiping
+func (c *Cluster) Leave() error {
+	close(c.stop)
+	c.linksMu.Lock()
+	for node, link := range c.links {
+		close(link.stop)
+		delete(c.links, node)
+	}
+	c.linksMu.Unlock()
+	if err := c.ml.Leave(leaveTimeout); err != nil {
+		log.Printf("Cluster: leave announcement failed: %v", err)
+	}
+	return c.ml.Shutdown()
+}
+
+// refreshHealth re-advertises this node's load until Leave
+func (c *Cluster) refreshHealth() {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.mu.RLock()
+			stats := c.stats
+			c.mu.RUnlock()
+			if stats != nil {
+				conns, rooms := stats()
+				c.mu.Lock()
+				c.meta.Connections, c.meta.Rooms = conns, rooms
+				c.mu.Unlock()
+			}
+			// Pushes the new NodeMeta to peers
+			if err := c.ml.UpdateNode(healthInterval); err != nil {
+				log.Printf("Cluster: health update failed: %v", err)
+			}
+			c.forgetDeparted(time.Now())
+		}
+	}
+}
+
+// forgetDeparted drops dead and departed nodes after forgetAfter
+func (c *Cluster) forgetDeparted(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, m := range c.members {
+		if (m.State == StateDead || m.State == StateLeft) && now.Sub(m.UpdatedAt) > forgetAfter {
+			delete(c.members, name)
+		}
+	}
+}
+
+// record updates the view from a memberlist node and notifies listeners
+func (c *Cluster) record(n *memberlist.Node, state string) {
+	var meta NodeMeta
+	if len(n.Meta) > 0 {
+		if err := json.Unmarshal(n.Meta, &meta); err != nil {
+			log.Printf("Cluster: bad metadata from %s: %v", n.Name, err)
+		}
+	}
+
+	c.mu.Lock()
+	prev, known := c.members[n.Name]
+	c.members[n.Name] = Member{
+		Name:      n.Name,
+		Addr:      n.Address(),
+		State:     state,
+		Local:     n.Name == c.name,
+		Meta:      meta,
+		UpdatedAt: time.Now(),
+	}
+	alive := 0
+	var owners []string
+	for _, m := range c.members {
+		if m.State == StateAlive {
+			alive++
+			// Nodes nobody can reach over HTTP can't own rooms
+			if m.Meta.HTTPAddr != "" {
+				owners = append(owners, m.Name)
+			}
+		}
+	}
+	changed := !known || prev.State != state || prev.Meta.HTTPAddr != meta.HTTPAddr
+	if changed {
+		c.ring = NewRing(owners)
+	}
+	listeners := append([]func(){}, c.listeners...)
+	c.mu.Unlock()
+
+	metrics.ClusterMembers.Set(float64(alive))
+	if state != StateAlive {
+		c.closeLink(n.Name)
+	}
+	if changed {
+		log.Printf("Cluster: node %s (%s) is %s", n.Name, n.Address(), state)
+		for _, fn := range listeners {
+			fn()
+		}
+	}
+}
+
+// delegate provides this node's metadata to memberlist
+type delegate Cluster
+
+func (d *delegate) NodeMeta(limit int) []byte {
+	c := (*Cluster)(d)
+	c.mu.RLock()
+	data, _ := json.Marshal(c.meta)
+	c.mu.RUnlock()
+	if len(data) > limit {
+		log.Printf("Cluster: node metadata exceeds %d bytes, not sending it", limit)
+		return nil
+	}
+	return data
+}
+
+func (d *delegate) NotifyMsg([]byte)                           {}
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d *delegate) LocalState(join bool) []byte                { return nil }
+func (d *delegate) MergeRemoteState(buf []byte, join bool)     {}
+
+// events keeps the cluster view in step with memberlist
+type events Cluster
+
+func (e *events) NotifyJoin(n *memberlist.Node) {
+	(*Cluster)(e).record(n, StateAlive)
+}
+
+func (e *events) NotifyLeave(n *memberlist.Node) {
+	// memberlist reports graceful leaves and failures the same way;
+	// a node that left on purpose has marked itself as left
+	state := StateDead
+	if n.State == memberlist.StateLeft {
+		state = StateLeft
+	}
+	(*Cluster)(e).record(n, state)
+}
+
+func (e *events) NotifyUpdate(n *memberlist.Node) {
+	(*Cluster)(e).record(n, StateAlive)
+}
@@ -0,0 +1,220 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chat-app/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+/*
+Links Overview:
+--------------
+Nodes exchange room traffic over WebSocket links. A node dials each
+peer it has something to send to at LinkPath on the peer's advertised
+HTTP address and keeps the connection open. Links are one-way: a node
+writes only to links it dialled and reads only from links it
+accepted, so two nodes talking both ways use two connections.
+
+Frames are opaque here; the hub encodes them (websockets/relay.go).
+
+Send never blocks. Frames wait in a bounded queue per peer and are
+dropped (and counted) if the peer stays unreachable long enough to
+fill it, so callers must tolerate loss as on any network hop.
+
+Links authenticate with the cluster secret as a bearer token. Without
+a secret anyone who can reach the HTTP port can inject room traffic,
+so set CHAT_CLUSTER_SECRET whenever that port is exposed.
+*/
+
+// LinkPath is where nodes accept links from their peers
+const LinkPath = "/internal/cluster/link"
+
+const (
+	// Frames queued per peer before new ones are dropped
+	linkQueueSize = 4096
+
+	// Pause between attempts to reach an unreachable peer
+	linkRedialDelay = time.Second
+
+	// Bound on writing one frame to a peer
+	linkWriteTimeout = 10 * time.Second
+
+	// Largest frame accepted from a peer
+	linkMaxFrame = 1 << 20
+)
+
+// Header naming the node on the dialling end of a link
+const nodeHeader = "X-Chat-Node"
+
+// peerLink is the outgoing link to one peer
+type peerLink struct {
+	node  string
+	queue chan []byte
+	stop  chan struct{}
+}
+
+// Send queues frame for node without blocking
+func (c *Cluster) Send(node string, frame []byte) {
+	link := c.link(node)
+	if link == nil {
+		metrics.ClusterFrames.WithLabelValues("dropped").Inc()
+		return
+	}
+	select {
+	case link.queue <- frame:
+	default:
+		metrics.ClusterFrames.WithLabelValues("dropped").Inc()
+	}
+}
+
+// OnFrame sets the handler for frames arriving from peers
+// fn runs on the link's read goroutine; blocking it pauses that link
+func (c *Cluster) OnFrame(fn func(from string, frame []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onFrame = fn
+}
+
+// link returns the outgoing link to node, dialling it if needed
+// It returns nil for nodes that aren't alive
+func (c *Cluster) link(node string) *peerLink {
+	if !c.Alive(node) || node == c.name {
+		return nil
+	}
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	if link, ok := c.links[node]; ok {
+		return link
+	}
+	link := &peerLink{
+		node:  node,
+		queue: make(chan []byte, linkQueueSize),
+		stop:  make(chan struct{}),
+	}
+	c.links[node] = link
+	go c.runLink(link)
+	return link
+}
+
+// closeLink stops the outgoing link to a departed node
+func (c *Cluster) closeLink(node string) {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	if link, ok := c.links[node]; ok {
+		close(link.stop)
+		delete(c.links, node)
+	}
+}
+
+// runLink keeps a link connected and writes queued frames to it
+// Frames being written when a connection fails are lost
+func (c *Cluster) runLink(link *peerLink) {
+	for {
+		conn, err := c.dial(link.node)
+		if err != nil {
+			log.Printf("Cluster: link to %s failed: %v", link.node, err)
+			select {
+			case <-link.stop:
+				return
+			case <-time.After(linkRedialDelay):
+				continue
+			}
+		}
+
+		if done := c.writeLink(conn, link); done {
+			return
+		}
+	}
+}
+
+// writeLink drains the queue onto conn; it reports true once the link is stopped
+func (c *Cluster) writeLink(conn *websocket.Conn, link *peerLink) bool {
+	defer conn.Close()
+	for {
+		select {
+		case <-link.stop:
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "node left"))
+			return true
+		case frame := <-link.queue:
+			conn.SetWriteDeadline(time.Now().Add(linkWriteTimeout))
+			if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+				log.Printf("Cluster: link to %s broke: %v", link.node, err)
+				metrics.ClusterFrames.WithLabelValues("dropped").Inc()
+				return false
+			}
+			metrics.ClusterFrames.WithLabelValues("sent").Inc()
+		}
+	}
+}
+
+// dial opens a link to node at its advertised HTTP address
+func (c *Cluster) dial(node string) (*websocket.Conn, error) {
+	addr := c.httpAddr(node)
+	if addr == "" {
+		return nil, errNoHTTPAddr
+	}
+	url := "ws" + strings.TrimPrefix(strings.TrimSuffix(addr, "/"), "http") + LinkPath
+
+	header := http.Header{}
+	header.Set(nodeHeader, c.name)
+	if c.secret != "" {
+		header.Set("Authorization", "Bearer "+c.secret)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	return conn, err
+}
+
+// LinkHandler accepts links from peers and passes their frames to OnFrame
+func (c *Cluster) LinkHandler() gin.HandlerFunc {
+	upgrader := websocket.Upgrader{}
+	return func(ctx *gin.Context) {
+		// Step 1: Check the peer knows the cluster secret
+		if c.secret != "" {
+			token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(c.secret)) != 1 {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid cluster secret"})
+				return
+			}
+		}
+		from := ctx.GetHeader(nodeHeader)
+		if from == "" {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing " + nodeHeader + " header"})
+			return
+		}
+
+		// Step 2: Upgrade and read frames until the peer hangs up
+		conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+		if err != nil {
+			log.Printf("Cluster: failed to accept link from %s: %v", from, err)
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(linkMaxFrame)
+
+		for {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					log.Printf("Cluster: link from %s closed: %v", from, err)
+				}
+				return
+			}
+			metrics.ClusterFrames.WithLabelValues("received").Inc()
+
+			c.mu.RLock()
+			handler := c.onFrame
+			c.mu.RUnlock()
+			if handler != nil {
+				handler(from, frame)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+/*
+Ring Overview:
+-------------
+Rooms are assigned to nodes by consistent hashing. Each node is placed
+on a hash ring at ringReplicas points ("virtual nodes"), and a room
+belongs to the node owning the first point at or after the room's own
+hash. When a node joins or leaves only the rooms next to its points
+move, roughly 1/N of them; every other room keeps its owner.
+
+Every node builds its ring from its own membership view, so owners
+are agreed without any coordination once gossip has converged. While
+a change is still spreading two nodes may briefly disagree; the hub
+copes with that (see websockets/relay.go).
+*/
+
+// ringReplicas is how many points each node gets on the ring
+// More points spread rooms more evenly between nodes
+const ringReplicas = 128
+
+// Ring maps keys to nodes by consistent hashing
+// A Ring is immutable; rebuild it when membership changes
+type Ring struct {
+	points []uint64          // Sorted hashes of every virtual node
+	owners map[uint64]string // Node owning each point
+	nodes  []string
+}
+
+// NewRing places nodes on a new ring
+func NewRing(nodes []string) *Ring {
+	r := &Ring{
+		owners: make(map[uint64]string, len(nodes)*ringReplicas),
+		nodes:  append([]string(nil), nodes...),
+	}
+	sort.Strings(r.nodes)
+	for _, node := range r.nodes {
+		for i := 0; i < ringReplicas; i++ {
+			point := hashKey(node + "#" + strconv.Itoa(i))
+			// On the (unlikely) collision the smaller name wins on every node
+			if _, taken := r.owners[point]; taken {
+				continue
+			}
+			r.owners[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the node responsible for key, or "" for an empty ring
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0 // Wrap around
+	}
+	return r.owners[r.points[i]]
+}
+
+// Nodes returns the nodes on the ring, sorted by name
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+// hashKey hashes s onto the ring
+// FNV-1a is fast but clusters similar strings, so the result is mixed
+// with the splitmix64 finalizer to spread "node#1", "node#2" evenly
+func hashKey(s string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	h := f.Sum64()
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
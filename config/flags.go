@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+Flags Overview:
+--------------
+Parse layers a config file and command-line flags over the
+environment, so one binary can be deployed anywhere with either.
+Later sources win:
+
+	defaults < --config file < environment < flags
+
+	--config FILE       File of KEY=VALUE lines using the variable names above
+	--addr ADDRS        Same as CHAT_ADDR
+	--mode MODE         Same as CHAT_MODE
+	--log-level LEVEL   Same as CHAT_LOG_LEVEL
+
+Config file lines look like a shell env file; blank lines and lines
+starting with # are skipped, and values may be quoted:
+
+	# /etc/chat-app/chat.env
+	CHAT_ADDR=:8080
+	CHAT_ADMIN_ADDR=127.0.0.1:9090
+	CHAT_ADMIN_TOKEN="s3cret"
+
+The file is read on every start, so edits take effect on the next
+restart or upgrade.
+*/
+
+// Accepted values of Mode and LogLevel
+var (
+	modes           = []string{"debug", "release", "test"}
+	logLevels       = []string{"debug", "info", "warn"}
+	htmlModes       = []string{"strip", "escape", "keep"}
+	duplicateLogins = []string{"allow", "replace", "reject"}
+	idleActions     = []string{"away", "disconnect"}
+	statsdFormats   = []string{"datadog", "statsd"}
+)
+
+// Parse builds a Config from the server's command-line arguments
+// Invalid flags exit the process like any flag.ExitOnError set
+func Parse(args []string) (Config, error) {
+	// Step 1: Read the flags
+	fs := flag.NewFlagSet("chat-app", flag.ExitOnError)
+	file := fs.String("config", "", "file of KEY=VALUE settings, overridden by the environment")
+	addr := fs.String("addr", "", "comma-separated listen addresses (CHAT_ADDR)")
+	mode := fs.String("mode", "", "gin mode: "+strings.Join(modes, ", ")+" (CHAT_MODE)")
+	logLevel := fs.String("log-level", "", "log level: "+strings.Join(logLevels, ", ")+" (CHAT_LOG_LEVEL)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chat-app [flags]")
+		fmt.Fprintln(fs.Output(), "       chat-app loadtest|bench|backup|restore|migrate|replay [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	// Step 2: Layer the file, the environment and the flags
+	var src source
+	if *file != "" {
+		var err error
+		if src, err = readFile(*file); err != nil {
+			return Config{}, err
+		}
+	}
+	cfg := src.load()
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addrs = splitList(*addr)
+		case "mode":
+			cfg.Mode = *mode
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		}
+	})
+
+	// Step 3: Reject values that would otherwise fail later, or silently
+	if len(cfg.Addrs) == 0 {
+		return Config{}, fmt.Errorf("no listen address given")
+	}
+	if cfg.Mode != "" && !slices.Contains(modes, cfg.Mode) {
+		return Config{}, fmt.Errorf("unknown mode %q (want one of %s)", cfg.Mode, strings.Join(modes, ", "))
+	}
+	if !slices.Contains(logLevels, cfg.LogLevel) {
+		return Config{}, fmt.Errorf("unknown log level %q (want one of %s)", cfg.LogLevel, strings.Join(logLevels, ", "))
+	}
+	if !slices.Contains(htmlModes, cfg.Content.HTML) {
+		return Config{}, fmt.Errorf("unknown CHAT_SANITIZE_HTML %q (want one of %s)", cfg.Content.HTML, strings.Join(htmlModes, ", "))
+	}
+	if !slices.Contains(duplicateLogins, cfg.DuplicateLogin) {
+		return Config{}, fmt.Errorf("unknown CHAT_DUPLICATE_LOGINS %q (want one of %s)", cfg.DuplicateLogin, strings.Join(duplicateLogins, ", "))
+	}
+	if !slices.Contains(idleActions, cfg.Presence.IdleAction) {
+		return Config{}, fmt.Errorf("unknown CHAT_IDLE_ACTION %q (want one of %s)", cfg.Presence.IdleAction, strings.Join(idleActions, ", "))
+	}
+	if !slices.Contains(statsdFormats, cfg.Metrics.StatsD.Format) {
+		return Config{}, fmt.Errorf("unknown CHAT_STATSD_FORMAT %q (want one of %s)", cfg.Metrics.StatsD.Format, strings.Join(statsdFormats, ", "))
+	}
+	if cfg.LogFile.MaxSizeMB < 0 || cfg.LogFile.MaxBackups < 0 {
+		return Config{}, fmt.Errorf("CHAT_LOG_MAX_SIZE and CHAT_LOG_MAX_BACKUPS must not be negative")
+	}
+	if cfg.Encryption.Key != "" && cfg.Encryption.VaultKey != "" {
+		return Config{}, fmt.Errorf("set CHAT_ENCRYPTION_KEY or CHAT_ENCRYPTION_VAULT_KEY, not both")
+	}
+	if cfg.Encryption.VaultKey != "" && cfg.Vault.Addr == "" {
+		return Config{}, fmt.Errorf("CHAT_ENCRYPTION_VAULT_KEY needs CHAT_VAULT_ADDR")
+	}
+	if cfg.Chaos.DropRate < 0 || cfg.Chaos.DropRate > 1 {
+		return Config{}, fmt.Errorf("CHAT_CHAOS_DROP_RATE must be between 0 and 1")
+	}
+	if cfg.Chaos.Enabled() && cfg.Mode == "release" {
+		return Config{}, fmt.Errorf("CHAT_CHAOS_* settings inject faults and are refused in release mode")
+	}
+	if cfg.Audio.MaxBytes <= 0 || cfg.Audio.MaxDuration <= 0 {
+		return Config{}, fmt.Errorf("CHAT_AUDIO_MAX_BYTES and CHAT_AUDIO_MAX_DURATION must be positive")
+	}
+	for _, url := range cfg.Transfers.STUNServers {
+		if !strings.HasPrefix(url, "stun:") && !strings.HasPrefix(url, "stuns:") {
+			return Config{}, fmt.Errorf("CHAT_STUN_SERVERS entry %q is not a stun: URL", url)
+		}
+	}
+	if cfg.Static.Dir != "" {
+		if info, err := os.Stat(cfg.Static.Dir); err != nil || !info.IsDir() {
+			return Config{}, fmt.Errorf("CHAT_STATIC_DIR %q is not a readable directory", cfg.Static.Dir)
+		}
+	}
+	if cfg.Uploads.MaxBytes <= 0 {
+		return Config{}, fmt.Errorf("CHAT_UPLOADS_MAX_BYTES must be positive")
+	}
+	if cfg.Uploads.URLTTL > 7*24*time.Hour {
+		// The longest S3 allows for a presigned URL
+		return Config{}, fmt.Errorf("CHAT_UPLOADS_URL_TTL must be at most 168h")
+	}
+	if cfg.Quota.Messages < 0 || cfg.Quota.Uploads < 0 || cfg.Quota.Bytes < 0 {
+		return Config{}, fmt.Errorf("CHAT_QUOTA_MESSAGES, CHAT_QUOTA_UPLOADS and CHAT_QUOTA_BYTES must not be negative")
+	}
+	if cfg.Tenants.CacheTTL <= 0 {
+		return Config{}, fmt.Errorf("CHAT_TENANT_CACHE_TTL must be positive")
+	}
+	for _, t := range cfg.Uploads.Types {
+		if major, minor, ok := strings.Cut(t, "/"); !ok || major == "" || minor == "" || major == "*" {
+			return Config{}, fmt.Errorf("CHAT_UPLOADS_TYPES entry %q is not a media type like image/png or image/*", t)
+		}
+	}
+	return cfg, nil
+}
+
+// readFile parses a config file of KEY=VALUE lines
+func readFile(path string) (source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(source)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		values[key] = unquote(strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// unquote strips one pair of matching quotes
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-app/client"
+)
+
+/*
+Terminal Client Overview:
+------------------------
+`chat-app connect` joins a room from a shell, to smoke-test a
+deployment or just to chat:
+
+	chat-app connect ws://localhost:8080/ws/lobby --user alice
+
+Each line typed is sent as a chat message, and everything the room
+sends is printed as it arrives. Lines starting with / are commands
+(/help lists them). The session acks messages sent with a delivery
+guarantee and follows "reconnect" frames when a server drains.
+
+Input doesn't have to be a terminal. Piped lines are sent as they are
+read, and the session leaves --linger after the input ends, so a
+deploy check is one line:
+
+	echo "deploy check" | chat-app connect wss://chat.example.com/ws/ops --user ci
+
+It exits non-zero when it can't connect or the server drops it.
+
+With --tui it opens a full-screen client instead, see tui.go.
+*/
+
+// connectDialTimeout bounds each attempt to open the WebSocket
+const connectDialTimeout = 10 * time.Second
+
+// connectMaxFrame mirrors the server's frame limit; larger frames get
+// the connection closed, so they are refused here instead
+const connectMaxFrame = 512
+
+func runConnect(args []string) {
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	user := fs.String("user", "", "username to join as (default the URL's username, then $USER)")
+	linger := fs.Duration("linger", time.Second, "how long to keep printing after input ends")
+	tui := fs.Bool("tui", false, "full-screen client with a room list, presence and scrollback")
+	rooms := fs.String("rooms", "", "more rooms to open with --tui, comma-separated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: chat-app connect ws://host/ws/room [--user name] [--tui [--rooms a,b]]")
+		fs.PrintDefaults()
+	}
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	base, room, query, err := splitRoomURL(positional[0])
+	if err != nil {
+		log.Fatal("connect: ", err)
+	}
+	if *user == "" {
+		*user = query.Get("username")
+	}
+	if *user == "" {
+		*user = os.Getenv("USER")
+	}
+	if *user == "" {
+		log.Fatal("connect: --user is required")
+	}
+
+	if *tui {
+		if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+			log.Fatal("connect: --tui needs a terminal")
+		}
+		open := []string{room}
+		for _, r := range strings.Split(*rooms, ",") {
+			if r = strings.TrimSpace(r); r != "" && !slices.Contains(open, r) {
+				open = append(open, r)
+			}
+		}
+		if err := runTUI(base, *user, open); err != nil {
+			log.Fatal("connect: ", err)
+		}
+		return
+	}
+
+	s := &terminalSession{
+		base:     base,
+		room:     room,
+		username: *user,
+		out:      os.Stdout,
+		tty:      isTerminal(os.Stdin) && isTerminal(os.Stdout),
+	}
+	if err := s.run(os.Stdin, *linger); err != nil {
+		s.printf("! %v", err)
+		os.Exit(1)
+	}
+}
+
+// parseInterspersed parses flags that may come after positional
+// arguments, which flag.Parse alone stops at, and returns the positionals
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// splitRoomURL splits ws://host/prefix/ws/room?username=... into the
+// server base URL the client library dials, the room and the query
+func splitRoomURL(raw string) (base, room string, query url.Values, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("parse URL: %w", err)
+	}
+	switch u.Scheme {
+	case "ws", "wss", "http", "https":
+	default:
+		return "", "", nil, fmt.Errorf("%q is not a ws:// or wss:// URL", raw)
+	}
+	i := strings.LastIndex(u.Path, "/ws/")
+	if i < 0 || u.Path[i+len("/ws/"):] == "" {
+		return "", "", nil, fmt.Errorf("%q has no room; expected ws://host/ws/room", raw)
+	}
+	room = u.Path[i+len("/ws/"):]
+	query = u.Query()
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = u.Path[:i], "", "", ""
+	return u.String(), room, query, nil
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalSession is one `connect` run; the receive loop owns the
+// connection, and everything else reaches it through current
+type terminalSession struct {
+	base     string
+	room     string
+	username string
+	out      io.Writer
+	tty      bool // Draw a prompt and styles; off when piped
+
+	outMu sync.Mutex // Keeps lines from the two loops whole
+	mu    sync.Mutex
+	conn  *client.Conn
+	users client.Presence
+	clock client.Clock
+	seen  bool          // Printed the first online_users of this connection
+	quit  chan struct{} // Closed when the user leaves
+
+	joined   chan struct{} // Closed once the room's members are known
+	joinOnce sync.Once
+}
+
+func (s *terminalSession) run(in io.Reader, linger time.Duration) error {
+	// Step 1: Connect
+	conn, err := s.dial(s.base)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.joined = make(chan struct{})
+	s.quit = make(chan struct{})
+
+	// Step 2: Print what arrives until the server closes the connection
+	done := make(chan error, 1)
+	go func() { done <- s.receive() }()
+
+	// Step 3: Once in the room, send what is typed until /quit or the
+	// end of input
+	select {
+	case err := <-done:
+		return err
+	case <-s.joined:
+	}
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	s.prompt()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case line, ok := <-lines:
+			if !ok {
+				// Give replies to the last lines time to arrive
+				select {
+				case err := <-done:
+					return err
+				case <-time.After(linger):
+				}
+				return s.leave(done)
+			}
+			if s.handleLine(line) {
+				return s.leave(done)
+			}
+			s.prompt()
+		}
+	}
+}
+
+// dial opens a connection to the room on the server at base
+func (s *terminalSession) dial(base string) (*client.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectDialTimeout)
+	defer cancel()
+	conn, err := client.Dial(ctx, base, s.room, s.username)
+	if err != nil {
+		return nil, err
+	}
+	conn.SyncClock() // A failure here surfaces in receive
+	return conn, nil
+}
+
+// current returns the live connection
+func (s *terminalSession) current() *client.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+// leave closes the connection and waits for the receive loop to see it
+func (s *terminalSession) leave(done <-chan error) error {
+	close(s.quit)
+	s.current().Close()
+	<-done
+	return nil
+}
+
+// leaving reports whether the user has left
+func (s *terminalSession) leaving() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// receive prints frames until the connection ends, following
+// "reconnect" frames to a new connection on the way
+func (s *terminalSession) receive() error {
+	conn := s.current()
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			if s.leaving() {
+				return nil
+			}
+			return fmt.Errorf("connection lost: %w", err)
+		}
+		s.clock.Apply(msg, time.Now())
+
+		if msg.QoS != "" && msg.ID != "" {
+			conn.Ack(msg.ID)
+		}
+		if msg.Type == "reconnect" {
+			next, err := s.reconnect(msg)
+			if err != nil || next == nil {
+				return err // nil when the user left meanwhile
+			}
+			conn = next
+			continue
+		}
+		s.show(msg)
+	}
+}
+
+// reconnect moves to the server a "reconnect" frame names
+func (s *terminalSession) reconnect(msg client.Message) (*client.Conn, error) {
+	base := s.base
+	if msg.URL != "" {
+		base = msg.URL
+	}
+	delay := time.Duration(msg.RetryAfterMs) * time.Millisecond
+	s.printf("* server asked us to reconnect; reconnecting in %s", delay)
+	select {
+	case <-time.After(delay):
+	case <-s.quit:
+		return nil, nil
+	}
+
+	next, err := s.dial(base)
+	if err != nil {
+		return nil, fmt.Errorf("reconnect: %w", err)
+	}
+	// leave closes whichever connection is current, so check under the lock
+	s.mu.Lock()
+	if s.leaving() {
+		s.mu.Unlock()
+		next.Close()
+		return nil, nil
+	}
+	old := s.conn
+	s.conn, s.base, s.seen = next, base, false
+	s.mu.Unlock()
+	old.Close()
+	return next, nil
+}
+
+// handleLine sends a line typed by the user or runs its command,
+// reporting whether the session should end
+func (s *terminalSession) handleLine(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return false
+	}
+	if !strings.HasPrefix(line, "/") || strings.HasPrefix(line, "//") {
+		s.send(map[string]string{"type": "chat", "content": strings.TrimPrefix(line, "/")})
+		return false
+	}
+
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "/quit", "/exit":
+		return true
+	case "/help":
+		s.printf("%s", connectHelp)
+	case "/who":
+		s.mu.Lock()
+		users := strings.Join(s.memberNames(), ", ")
+		s.mu.Unlock()
+		s.printf("* online: %s", users)
+	case "/sticker":
+		pack, id, ok := strings.Cut(arg, "/")
+		if !ok || pack == "" || id == "" {
+			s.printf("! usage: /sticker pack/id")
+			break
+		}
+		s.send(map[string]any{"type": "sticker", "sticker": client.Sticker{Pack: pack, ID: id}})
+	case "/raw":
+		if !json.Valid([]byte(arg)) {
+			s.printf("! /raw needs a JSON frame, e.g. /raw {\"type\":\"chat\",\"content\":\"hi\"}")
+			break
+		}
+		s.send(json.RawMessage(arg))
+	default:
+		s.printf("! unknown command %s; /help lists them", cmd)
+	}
+	return false
+}
+
+const connectHelp = `Commands:
+  /who                 list who is online
+  /sticker pack/id     send a sticker
+  /raw {json}          send a frame as it is
+  /quit                leave (also Ctrl-D)
+Start a message with // to send a line beginning with /.`
+
+// send writes a frame, refusing ones the server would disconnect for
+func (s *terminalSession) send(frame any) {
+	data, err := encodeFrame(frame)
+	if err == nil {
+		err = s.current().SendJSON(data)
+	}
+	if err != nil {
+		s.printf("! %v", err)
+	}
+}
+
+// encodeFrame encodes a frame, failing if it is over the server's limit
+func encodeFrame(frame any) (json.RawMessage, error) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > connectMaxFrame {
+		return nil, fmt.Errorf("message too long (%d bytes encoded, the limit is %d)", len(data), connectMaxFrame)
+	}
+	return data, nil
+}
+
+// show prints a frame from the server; types with nothing to say are skipped
+func (s *terminalSession) show(msg client.Message) {
+	s.mu.Lock()
+	base := s.base
+	s.mu.Unlock()
+	if body, ok := messageBody(msg, base, s.tty); ok {
+		if msg.Type == "announcement" {
+			s.printf("** %s", body)
+		} else {
+			s.printf("%s %s: %s", stamp(s.clock.MessageTime(msg)), msg.Username, body)
+		}
+		return
+	}
+
+	switch msg.Type {
+	case "hello":
+		s.printf("* connected to #%s as %s (server %s, connection %s)", s.room, msg.Username, msg.Server, msg.ConnID)
+		s.mu.Lock()
+		s.username = msg.Username // The server may have renamed us
+		s.mu.Unlock()
+	case "online_users":
+		s.mu.Lock()
+		s.users.Apply(msg)
+		users := s.memberNames()
+		first := !s.seen
+		s.seen = true
+		s.mu.Unlock()
+		if first {
+			s.printf("* online: %s", strings.Join(users, ", "))
+		}
+		s.joinOnce.Do(func() { close(s.joined) })
+	case "presence_join", "presence_leave":
+		s.mu.Lock()
+		s.users.Apply(msg)
+		s.mu.Unlock()
+	default:
+		if notice := messageNotice(msg); notice != "" {
+			s.printf("%s", notice)
+		}
+	}
+}
+
+// memberNames lists the room's members, marking those away; callers hold mu
+func (s *terminalSession) memberNames() []string {
+	users := s.users.Users()
+	for i, user := range users {
+		if m, _ := s.users.Member(user); m.Status != "" {
+			users[i] += " (" + m.Status + ")"
+		}
+	}
+	return users
+}
+
+// messageBody renders what a member posted: chat text, with what a reply
+// quotes, a sticker, a voice note or a file, and announcements; ok is
+// false for other frames.
+// Server-relative URLs are made absolute against base, and styles adds
+// terminal styles for Markdown
+func messageBody(msg client.Message, base string, styles bool) (body string, ok bool) {
+	switch msg.Type {
+	case "chat", "announcement":
+		if msg.Quote != nil {
+			return fmt.Sprintf("[re %s: %s] %s", msg.Quote.Username, msg.Quote.Excerpt, styledText(msg, styles)), true
+		}
+		return styledText(msg, styles), true
+	case "sticker":
+		if msg.Sticker != nil {
+			return fmt.Sprintf("[sticker %s] %s", msg.Content, serverLink(base, msg.Sticker.URL)), true
+		}
+	case "audio":
+		if msg.Audio != nil {
+			return fmt.Sprintf("[voice note %s] %s",
+				time.Duration(msg.Audio.DurationMs)*time.Millisecond, serverLink(base, msg.Audio.URL)), true
+		}
+	case "attachment":
+		if msg.Attachment != nil {
+			return fmt.Sprintf("[file %s, %d bytes] %s",
+				msg.Attachment.Name, msg.Attachment.Size, serverLink(base, msg.Attachment.URL)), true
+		}
+	}
+	return "", false
+}
+
+// messageNotice renders a frame about the room rather than from a
+// member, such as a join or an error; empty for frames not worth showing
+func messageNotice(msg client.Message) string {
+	switch msg.Type {
+	case "user_joined", "user_left":
+		return "* " + msg.Content
+	case "onboarding":
+		if len(msg.Choices) > 0 {
+			return fmt.Sprintf("* %s (%s)", msg.Content, strings.Join(msg.Choices, ", "))
+		}
+		return fmt.Sprintf("* %s %s", msg.Content, msg.URL)
+	case "moderation":
+		return fmt.Sprintf("* a message was %s by moderation", msg.Code)
+	case "transfer_offer":
+		if msg.Transfer != nil {
+			return fmt.Sprintf("* %s offered %s (%d bytes); transfers need a browser", msg.Username, msg.Transfer.Name, msg.Transfer.Size)
+		}
+	case "error":
+		return fmt.Sprintf("! %s (%s)", msg.Content, msg.Code)
+	}
+	return ""
+}
+
+// styledText renders a message's Markdown with terminal styles, or its
+// text as it is without styles
+func styledText(msg client.Message, styles bool) string {
+	if !styles || msg.Formatted == nil {
+		return msg.Content
+	}
+	var b strings.Builder
+	for _, seg := range msg.Formatted {
+		style := ""
+		if seg.Bold {
+			style += "\033[1m"
+		}
+		if seg.Italic {
+			style += "\033[3m"
+		}
+		if seg.Code {
+			style += "\033[36m"
+		}
+		if seg.Link != "" {
+			style += "\033[4m"
+		}
+		if style == "" {
+			b.WriteString(seg.Text)
+			continue
+		}
+		b.WriteString(style + seg.Text + "\033[0m")
+		if seg.Link != "" && seg.Link != seg.Text {
+			b.WriteString(" <" + seg.Link + ">")
+		}
+	}
+	return b.String()
+}
+
+// serverLink turns a server-relative URL into one a browser can open
+func serverLink(base, path string) string {
+	if path == "" || strings.Contains(path, "://") {
+		return path
+	}
+	u, err := client.HTTPURL(base)
+	if err != nil {
+		return path
+	}
+	return u + path
+}
+
+// printf prints a line, redrawing the prompt below it on a terminal
+func (s *terminalSession) printf(format string, args ...any) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	line := fmt.Sprintf(format, args...)
+	if s.tty {
+		// Clear the prompt, print the line, and draw the prompt again
+		fmt.Fprintf(s.out, "\r\033[K%s\n> ", line)
+		return
+	}
+	fmt.Fprintln(s.out, line)
+}
+
+// prompt draws the input prompt on a terminal
+func (s *terminalSession) prompt() {
+	if !s.tty {
+		return
+	}
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	fmt.Fprint(s.out, "\r\033[K> ")
+}
+
+// stamp is the time shown beside messages posted at t
+func stamp(t time.Time) string {
+	return t.Local().Format("15:04")
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // Registers the "pgx" database/sql driver
+)
+
+/*
+Database Overview:
+-----------------
+PostgreSQL access for the persistent storage backend. This package
+owns the connection setup and the schema; the SQL for every schema
+change lives in migrations/ and is embedded into the binary, so a
+deployment never needs the source tree to upgrade its database.
+
+See migrate.go for how migrations are tracked and applied.
+*/
+
+// connectTimeout bounds the initial connection check
+const connectTimeout = 10 * time.Second
+
+// Open connects to PostgreSQL and verifies the connection
+func Open(ctx context.Context, url string) (*sql.DB, error) {
+	conn, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+	return conn, nil
+}
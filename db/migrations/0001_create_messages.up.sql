@@ -0,0 +1,13 @@
+CREATE TABLE messages (
+    id         TEXT PRIMARY KEY,
+    room       TEXT        NOT NULL,
+    username   TEXT        NOT NULL,
+    type       TEXT        NOT NULL,
+    content    TEXT        NOT NULL,
+    seq        BIGINT      NOT NULL,
+    qos        TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX messages_room_seq_idx ON messages (room, seq);
+CREATE INDEX messages_room_created_at_idx ON messages (room, created_at);
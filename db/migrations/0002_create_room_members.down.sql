@@ -0,0 +1,2 @@
+DROP TABLE offline_queue;
+DROP TABLE room_members;
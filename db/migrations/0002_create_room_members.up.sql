@@ -0,0 +1,15 @@
+CREATE TABLE room_members (
+    room      TEXT        NOT NULL,
+    username  TEXT        NOT NULL,
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (room, username)
+);
+
+CREATE TABLE offline_queue (
+    id         BIGSERIAL PRIMARY KEY,
+    username   TEXT NOT NULL,
+    room       TEXT NOT NULL,
+    message_id TEXT NOT NULL REFERENCES messages (id) ON DELETE CASCADE
+);
+
+CREATE INDEX offline_queue_user_room_idx ON offline_queue (username, room, id);
@@ -0,0 +1,7 @@
+CREATE TABLE room_settings (
+    room               TEXT PRIMARY KEY,
+    retention_policy   TEXT        NOT NULL DEFAULT 'forever',
+    retention_days     INTEGER     NOT NULL DEFAULT 0,
+    retention_messages INTEGER     NOT NULL DEFAULT 0,
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,14 @@
+CREATE TABLE room_events (
+    room       TEXT        NOT NULL,
+    "offset"   BIGINT      NOT NULL,
+    type       TEXT        NOT NULL,
+    username   TEXT        NOT NULL DEFAULT '',
+    message_id TEXT        NOT NULL DEFAULT '',
+    seq        BIGINT      NOT NULL DEFAULT 0,
+    content    TEXT        NOT NULL DEFAULT '',
+    data       JSONB,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (room, "offset")
+);
+
+CREATE INDEX room_events_room_created_at_idx ON room_events (room, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE room_bans;
+DROP TABLE review_queue;
@@ -0,0 +1,26 @@
+CREATE TABLE review_queue (
+    id         TEXT PRIMARY KEY,
+    room       TEXT        NOT NULL,
+    message_id TEXT        NOT NULL DEFAULT '',
+    username   TEXT        NOT NULL,
+    content    TEXT        NOT NULL DEFAULT '',
+    sources    TEXT[]      NOT NULL,
+    reasons    TEXT[]      NOT NULL DEFAULT '{}',
+    reporters  TEXT[]      NOT NULL DEFAULT '{}',
+    score      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    hidden     BOOLEAN     NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX review_queue_created_at_idx ON review_queue (created_at);
+
+CREATE TABLE room_bans (
+    room       TEXT        NOT NULL,
+    username   TEXT        NOT NULL,
+    reason     TEXT        NOT NULL DEFAULT '',
+    banned_by  TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    until      TIMESTAMPTZ,
+    PRIMARY KEY (room, username)
+);
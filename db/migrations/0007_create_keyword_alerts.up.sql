@@ -0,0 +1,8 @@
+CREATE TABLE keyword_alerts (
+    room       TEXT        NOT NULL,
+    moderator  TEXT        NOT NULL,
+    keywords   TEXT[]      NOT NULL DEFAULT '{}',
+    patterns   TEXT[]      NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (room, moderator)
+);
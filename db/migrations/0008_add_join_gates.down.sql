@@ -0,0 +1,2 @@
+DROP TABLE users;
+ALTER TABLE room_settings DROP COLUMN joins;
@@ -0,0 +1,6 @@
+ALTER TABLE room_settings ADD COLUMN joins JSONB;
+
+CREATE TABLE users (
+    username   TEXT PRIMARY KEY,
+    first_seen TIMESTAMPTZ NOT NULL
+);
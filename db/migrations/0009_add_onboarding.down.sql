@@ -0,0 +1,2 @@
+DROP TABLE room_onboarded;
+ALTER TABLE room_settings DROP COLUMN onboarding;
@@ -0,0 +1,8 @@
+ALTER TABLE room_settings ADD COLUMN onboarding JSONB;
+
+CREATE TABLE room_onboarded (
+    room         TEXT        NOT NULL,
+    username     TEXT        NOT NULL,
+    onboarded_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (room, username)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE announcements (
+    id         TEXT PRIMARY KEY,
+    rooms      TEXT[]      NOT NULL,
+    schedule   TEXT        NOT NULL,
+    timezone   TEXT        NOT NULL DEFAULT '',
+    sender     TEXT        NOT NULL DEFAULT '',
+    content    TEXT        NOT NULL,
+    created_by TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    last_run   TIMESTAMPTZ
+);
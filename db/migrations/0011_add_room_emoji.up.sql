@@ -0,0 +1 @@
+ALTER TABLE room_settings ADD COLUMN emoji JSONB;
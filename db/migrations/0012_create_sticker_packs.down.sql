@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN sticker;
+DROP TABLE sticker_images;
+DROP TABLE sticker_packs;
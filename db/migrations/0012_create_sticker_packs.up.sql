@@ -0,0 +1,19 @@
+CREATE TABLE sticker_packs (
+    id         TEXT PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    rooms      TEXT[]      NOT NULL DEFAULT '{}',
+    stickers   JSONB       NOT NULL DEFAULT '[]',
+    created_by TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE sticker_images (
+    pack         TEXT  NOT NULL REFERENCES sticker_packs (id) ON DELETE CASCADE,
+    sticker      TEXT  NOT NULL,
+    content_type TEXT  NOT NULL,
+    data         BYTEA NOT NULL,
+    PRIMARY KEY (pack, sticker)
+);
+
+ALTER TABLE messages ADD COLUMN sticker JSONB;
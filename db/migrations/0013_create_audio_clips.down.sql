@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN audio;
+DROP TABLE audio_clips;
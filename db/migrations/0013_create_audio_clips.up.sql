@@ -0,0 +1,16 @@
+CREATE TABLE audio_clips (
+    id           TEXT PRIMARY KEY,
+    room         TEXT        NOT NULL,
+    username     TEXT        NOT NULL,
+    content_type TEXT        NOT NULL,
+    codec        TEXT        NOT NULL,
+    duration_ms  BIGINT      NOT NULL,
+    size         INTEGER     NOT NULL,
+    posted       BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMPTZ NOT NULL,
+    data         BYTEA       NOT NULL
+);
+
+CREATE INDEX audio_clips_room_created_at_idx ON audio_clips (room, created_at);
+
+ALTER TABLE messages ADD COLUMN audio TEXT;
@@ -0,0 +1,15 @@
+CREATE TABLE uploads (
+    id           TEXT PRIMARY KEY,
+    room         TEXT        NOT NULL,
+    username     TEXT        NOT NULL,
+    name         TEXT        NOT NULL,
+    content_type TEXT        NOT NULL,
+    size         BIGINT      NOT NULL,
+    key          TEXT        NOT NULL,
+    posted       BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX uploads_unposted_created_at_idx ON uploads (created_at) WHERE NOT posted;
+
+ALTER TABLE messages ADD COLUMN attachment TEXT;
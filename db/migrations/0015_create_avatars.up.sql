@@ -0,0 +1,9 @@
+CREATE TABLE avatar_images (
+    id         TEXT        NOT NULL,
+    size       INTEGER     NOT NULL,
+    data       BYTEA       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (id, size)
+);
+
+ALTER TABLE users ADD COLUMN avatar TEXT;
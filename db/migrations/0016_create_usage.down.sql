@@ -0,0 +1 @@
+DROP TABLE daily_usage;
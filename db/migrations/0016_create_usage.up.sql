@@ -0,0 +1,10 @@
+CREATE TABLE daily_usage (
+    username TEXT   NOT NULL,
+    day      DATE   NOT NULL,
+    messages BIGINT NOT NULL DEFAULT 0,
+    uploads  BIGINT NOT NULL DEFAULT 0,
+    bytes    BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, day)
+);
+
+CREATE INDEX daily_usage_day_idx ON daily_usage (day);
@@ -0,0 +1 @@
+DROP TABLE meter_records;
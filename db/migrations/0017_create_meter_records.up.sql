@@ -0,0 +1,11 @@
+CREATE TABLE meter_records (
+    room               TEXT        NOT NULL,
+    username           TEXT        NOT NULL,
+    hour               TIMESTAMPTZ NOT NULL,
+    messages           BIGINT      NOT NULL DEFAULT 0,
+    bytes              BIGINT      NOT NULL DEFAULT 0,
+    connection_seconds BIGINT      NOT NULL DEFAULT 0,
+    PRIMARY KEY (room, username, hour)
+);
+
+CREATE INDEX meter_records_hour_idx ON meter_records (hour);
@@ -0,0 +1,11 @@
+CREATE TABLE tenants (
+    id              TEXT             PRIMARY KEY,
+    name            TEXT             NOT NULL,
+    key_hash        TEXT             NOT NULL UNIQUE,
+    max_connections INTEGER          NOT NULL DEFAULT 0,
+    max_rooms       INTEGER          NOT NULL DEFAULT 0,
+    message_rate    DOUBLE PRECISION NOT NULL DEFAULT 0,
+    message_burst   INTEGER          NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ      NOT NULL,
+    updated_at      TIMESTAMPTZ      NOT NULL
+);
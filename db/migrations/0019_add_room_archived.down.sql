@@ -0,0 +1,2 @@
+ALTER TABLE room_settings DROP COLUMN archived_by;
+ALTER TABLE room_settings DROP COLUMN archived_at;
@@ -0,0 +1,2 @@
+ALTER TABLE room_settings ADD COLUMN archived_at TIMESTAMPTZ;
+ALTER TABLE room_settings ADD COLUMN archived_by TEXT;
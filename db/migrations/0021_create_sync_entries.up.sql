@@ -0,0 +1,7 @@
+CREATE TABLE sync_entries (
+    username   TEXT        NOT NULL,
+    key        TEXT        NOT NULL,
+    value      TEXT        NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (username, key)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE notification_prefs (
+    username      TEXT        PRIMARY KEY,
+    default_level TEXT        NOT NULL DEFAULT 'mentions',
+    rooms         JSONB       NOT NULL DEFAULT '{}',
+    updated_at    TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE notification_prefs DROP COLUMN dnd;
+ALTER TABLE notification_prefs DROP COLUMN muted;
@@ -0,0 +1,2 @@
+ALTER TABLE notification_prefs ADD COLUMN muted JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE notification_prefs ADD COLUMN dnd JSONB;
@@ -0,0 +1 @@
+ALTER TABLE notification_prefs DROP COLUMN highlights;
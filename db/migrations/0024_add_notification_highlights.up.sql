@@ -0,0 +1 @@
+ALTER TABLE notification_prefs ADD COLUMN highlights JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1 @@
+DROP TABLE direct_queue;
@@ -0,0 +1,10 @@
+CREATE TABLE direct_queue (
+    id         TEXT        PRIMARY KEY,
+    recipient  TEXT        NOT NULL,
+    sender     TEXT        NOT NULL,
+    origin     TEXT        NOT NULL DEFAULT '',
+    content    TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX direct_queue_recipient_idx ON direct_queue (recipient, created_at);
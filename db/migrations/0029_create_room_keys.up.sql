@@ -0,0 +1,8 @@
+CREATE TABLE room_keys (
+    room       TEXT        NOT NULL,
+    version    INTEGER     NOT NULL,
+    master_key TEXT        NOT NULL,
+    wrapped    BYTEA       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (room, version)
+);
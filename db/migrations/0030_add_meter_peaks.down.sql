@@ -0,0 +1 @@
+ALTER TABLE meter_records DROP COLUMN peak_connections;
@@ -0,0 +1 @@
+ALTER TABLE meter_records ADD COLUMN peak_connections BIGINT NOT NULL DEFAULT 0;
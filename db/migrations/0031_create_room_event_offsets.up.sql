@@ -0,0 +1,7 @@
+CREATE TABLE room_event_offsets (
+    room        TEXT   PRIMARY KEY,
+    last_offset BIGINT NOT NULL
+);
+
+INSERT INTO room_event_offsets (room, last_offset)
+SELECT room, MAX("offset") FROM room_events GROUP BY room;
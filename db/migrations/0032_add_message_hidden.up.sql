@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT false;
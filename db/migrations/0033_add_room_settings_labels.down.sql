@@ -0,0 +1,3 @@
+ALTER TABLE room_settings DROP COLUMN breakout;
+ALTER TABLE room_settings DROP COLUMN metadata;
+ALTER TABLE room_settings DROP COLUMN tags;
@@ -0,0 +1,3 @@
+ALTER TABLE room_settings ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE room_settings ADD COLUMN metadata JSONB;
+ALTER TABLE room_settings ADD COLUMN breakout JSONB;
@@ -0,0 +1 @@
+ALTER TABLE announcements DROP COLUMN tags;
@@ -0,0 +1 @@
+ALTER TABLE announcements ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1 @@
+DROP INDEX meter_records_room_hour_idx;
@@ -0,0 +1 @@
+CREATE INDEX meter_records_room_hour_idx ON meter_records (room, hour);
@@ -0,0 +1,83 @@
+package emoji
+
+import (
+	"regexp"
+	"strings"
+)
+
+/*
+Emoji Overview:
+--------------
+Expand replaces :shortcode: names with the emoji they stand for, so
+stored history and bridged platforms see the same text whatever the
+sending client understood:
+
+	"ship it :rocket: :+1:"  ->  "ship it 🚀 👍"
+
+The built-in names follow GitHub and Slack (see shortcodes.go). A
+room can add its own names, or override built-in ones, with a custom
+map (see storage.RoomSettings). Unknown names and anything inside
+`code` are left as typed.
+*/
+
+// namePattern is what may appear between the colons
+var namePattern = regexp.MustCompile(`^[a-z0-9_+\-]{1,32}$`)
+
+// shortcodePattern finds candidate shortcodes in text
+var shortcodePattern = regexp.MustCompile(`:[a-z0-9_+\-]{1,32}:`)
+
+// ValidName reports whether name can be used as a shortcode
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Lookup returns the built-in emoji for name
+func Lookup(name string) (string, bool) {
+	e, ok := shortcodes[name]
+	return e, ok
+}
+
+// Expand replaces the shortcodes in text, preferring custom names to built-in ones
+func Expand(text string, custom map[string]string) string {
+	if !strings.Contains(text, ":") {
+		return text
+	}
+
+	// Split on backticks; odd pieces are inside code spans
+	pieces := strings.Split(text, "`")
+	for i := 0; i < len(pieces); i += 2 {
+		pieces[i] = expandPiece(pieces[i], custom)
+	}
+	return strings.Join(pieces, "`")
+}
+
+// expandPiece replaces the shortcodes in text outside code
+func expandPiece(text string, custom map[string]string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(text); {
+		loc := shortcodePattern.FindStringIndex(text[i:])
+		if loc == nil {
+			break
+		}
+		start, end := i+loc[0], i+loc[1]
+		name := text[start+1 : end-1]
+		e, ok := custom[name]
+		if !ok {
+			e, ok = shortcodes[name]
+		}
+		if !ok {
+			// The closing colon may open the next shortcode, as in "12:30:smile:"
+			i = end - 1
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(e)
+		last, i = end, end
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
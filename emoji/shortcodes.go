@@ -0,0 +1,467 @@
+package emoji
+
+// shortcodes are the built-in names, following the GitHub and Slack
+// conventions; several names may share one emoji
+var shortcodes = map[string]string{
+	// Smileys
+	"smile":                        "😄",
+	"smiley":                       "😃",
+	"grinning":                     "😀",
+	"grin":                         "😁",
+	"laughing":                     "😆",
+	"satisfied":                    "😆",
+	"sweat_smile":                  "😅",
+	"joy":                          "😂",
+	"rofl":                         "🤣",
+	"relaxed":                      "☺️",
+	"blush":                        "😊",
+	"innocent":                     "😇",
+	"slightly_smiling_face":        "🙂",
+	"upside_down_face":             "🙃",
+	"wink":                         "😉",
+	"relieved":                     "😌",
+	"heart_eyes":                   "😍",
+	"smiling_face_with_3_hearts":   "🥰",
+	"kissing_heart":                "😘",
+	"kissing":                      "😗",
+	"yum":                          "😋",
+	"stuck_out_tongue":             "😛",
+	"stuck_out_tongue_winking_eye": "😜",
+	"zany_face":                    "🤪",
+	"money_mouth_face":             "🤑",
+	"hugs":                         "🤗",
+	"hugging_face":                 "🤗",
+	"hand_over_mouth":              "🤭",
+	"shushing_face":                "🤫",
+	"thinking":                     "🤔",
+	"thinking_face":                "🤔",
+	"zipper_mouth_face":            "🤐",
+	"raised_eyebrow":               "🤨",
+	"neutral_face":                 "😐",
+	"expressionless":               "😑",
+	"no_mouth":                     "😶",
+	"smirk":                        "😏",
+	"unamused":                     "😒",
+	"roll_eyes":                    "🙄",
+	"grimacing":                    "😬",
+	"lying_face":                   "🤥",
+	"pensive":                      "😔",
+	"sleepy":                       "😪",
+	"drooling_face":                "🤤",
+	"sleeping":                     "😴",
+	"mask":                         "😷",
+	"face_with_thermometer":        "🤒",
+	"nauseated_face":               "🤢",
+	"vomiting_face":                "🤮",
+	"sneezing_face":                "🤧",
+	"hot_face":                     "🥵",
+	"cold_face":                    "🥶",
+	"woozy_face":                   "🥴",
+	"dizzy_face":                   "😵",
+	"exploding_head":               "🤯",
+	"cowboy_hat_face":              "🤠",
+	"partying_face":                "🥳",
+	"sunglasses":                   "😎",
+	"nerd_face":                    "🤓",
+	"monocle_face":                 "🧐",
+	"confused":                     "😕",
+	"worried":                      "😟",
+	"slightly_frowning_face":       "🙁",
+	"frowning_face":                "☹️",
+	"open_mouth":                   "😮",
+	"hushed":                       "😯",
+	"astonished":                   "😲",
+	"flushed":                      "😳",
+	"pleading_face":                "🥺",
+	"frowning":                     "😦",
+	"anguished":                    "😧",
+	"fearful":                      "😨",
+	"cold_sweat":                   "😰",
+	"disappointed_relieved":        "😥",
+	"cry":                          "😢",
+	"sob":                          "😭",
+	"scream":                       "😱",
+	"confounded":                   "😖",
+	"persevere":                    "😣",
+	"disappointed":                 "😞",
+	"sweat":                        "😓",
+	"weary":                        "😩",
+	"tired_face":                   "😫",
+	"yawning_face":                 "🥱",
+	"triumph":                      "😤",
+	"rage":                         "😡",
+	"pout":                         "😡",
+	"angry":                        "😠",
+	"cursing_face":                 "🤬",
+	"smiling_imp":                  "😈",
+	"imp":                          "👿",
+	"skull":                        "💀",
+	"poop":                         "💩",
+	"hankey":                       "💩",
+	"clown_face":                   "🤡",
+	"ghost":                        "👻",
+	"alien":                        "👽",
+	"robot":                        "🤖",
+	"see_no_evil":                  "🙈",
+	"hear_no_evil":                 "🙉",
+	"speak_no_evil":                "🙊",
+
+	// Hearts and symbols
+	"heart":                   "❤️",
+	"orange_heart":            "🧡",
+	"yellow_heart":            "💛",
+	"green_heart":             "💚",
+	"blue_heart":              "💙",
+	"purple_heart":            "💜",
+	"black_heart":             "🖤",
+	"white_heart":             "🤍",
+	"broken_heart":            "💔",
+	"two_hearts":              "💕",
+	"sparkling_heart":         "💖",
+	"heartpulse":              "💗",
+	"heartbeat":               "💓",
+	"revolving_hearts":        "💞",
+	"cupid":                   "💘",
+	"100":                     "💯",
+	"anger":                   "💢",
+	"boom":                    "💥",
+	"collision":               "💥",
+	"dizzy":                   "💫",
+	"sweat_drops":             "💦",
+	"zzz":                     "💤",
+	"speech_balloon":          "💬",
+	"thought_balloon":         "💭",
+	"fire":                    "🔥",
+	"sparkles":                "✨",
+	"star":                    "⭐",
+	"star2":                   "🌟",
+	"zap":                     "⚡",
+	"warning":                 "⚠️",
+	"no_entry":                "⛔",
+	"x":                       "❌",
+	"heavy_check_mark":        "✔️",
+	"white_check_mark":        "✅",
+	"ballot_box_with_check":   "☑️",
+	"question":                "❓",
+	"grey_question":           "❔",
+	"exclamation":             "❗",
+	"bangbang":                "‼️",
+	"heavy_plus_sign":         "➕",
+	"heavy_minus_sign":        "➖",
+	"arrow_up":                "⬆️",
+	"arrow_down":              "⬇️",
+	"arrow_left":              "⬅️",
+	"arrow_right":             "➡️",
+	"recycle":                 "♻️",
+	"copyright":               "©️",
+	"registered":              "®️",
+	"tm":                      "™️",
+	"information_source":      "ℹ️",
+	"new":                     "🆕",
+	"ok":                      "🆗",
+	"red_circle":              "🔴",
+	"green_circle":            "🟢",
+	"large_blue_circle":       "🔵",
+	"checkered_flag":          "🏁",
+	"triangular_flag_on_post": "🚩",
+
+	// People and gestures
+	"wave":             "👋",
+	"raised_hand":      "✋",
+	"hand":             "✋",
+	"ok_hand":          "👌",
+	"pinched_fingers":  "🤌",
+	"v":                "✌️",
+	"crossed_fingers":  "🤞",
+	"love_you_gesture": "🤟",
+	"metal":            "🤘",
+	"call_me_hand":     "🤙",
+	"point_left":       "👈",
+	"point_right":      "👉",
+	"point_up":         "☝️",
+	"point_up_2":       "👆",
+	"point_down":       "👇",
+	"middle_finger":    "🖕",
+	"+1":               "👍",
+	"thumbsup":         "👍",
+	"-1":               "👎",
+	"thumbsdown":       "👎",
+	"fist":             "✊",
+	"facepunch":        "👊",
+	"punch":            "👊",
+	"clap":             "👏",
+	"raised_hands":     "🙌",
+	"open_hands":       "👐",
+	"handshake":        "🤝",
+	"pray":             "🙏",
+	"writing_hand":     "✍️",
+	"muscle":           "💪",
+	"eyes":             "👀",
+	"eye":              "👁️",
+	"brain":            "🧠",
+	"facepalm":         "🤦",
+	"shrug":            "🤷",
+	"man_shrugging":    "🤷‍♂️",
+	"woman_shrugging":  "🤷‍♀️",
+	"raising_hand":     "🙋",
+	"bow":              "🙇",
+	"dancer":           "💃",
+	"man_dancing":      "🕺",
+	"runner":           "🏃",
+	"running":          "🏃",
+	"walking":          "🚶",
+	"technologist":     "🧑‍💻",
+	"ninja":            "🥷",
+	"superhero":        "🦸",
+	"detective":        "🕵️",
+	"santa":            "🎅",
+	"baby":             "👶",
+	"family":           "👪",
+
+	// Animals and nature
+	"dog":              "🐶",
+	"cat":              "🐱",
+	"mouse":            "🐭",
+	"rabbit":           "🐰",
+	"fox_face":         "🦊",
+	"bear":             "🐻",
+	"panda_face":       "🐼",
+	"koala":            "🐨",
+	"tiger":            "🐯",
+	"lion":             "🦁",
+	"cow":              "🐮",
+	"pig":              "🐷",
+	"frog":             "🐸",
+	"monkey_face":      "🐵",
+	"chicken":          "🐔",
+	"penguin":          "🐧",
+	"bird":             "🐦",
+	"eagle":            "🦅",
+	"owl":              "🦉",
+	"bat":              "🦇",
+	"wolf":             "🐺",
+	"horse":            "🐴",
+	"unicorn":          "🦄",
+	"bee":              "🐝",
+	"honeybee":         "🐝",
+	"bug":              "🐛",
+	"butterfly":        "🦋",
+	"snail":            "🐌",
+	"turtle":           "🐢",
+	"snake":            "🐍",
+	"dragon":           "🐉",
+	"t-rex":            "🦖",
+	"octopus":          "🐙",
+	"crab":             "🦀",
+	"fish":             "🐟",
+	"tropical_fish":    "🐠",
+	"dolphin":          "🐬",
+	"whale":            "🐳",
+	"shark":            "🦈",
+	"parrot":           "🦜",
+	"sloth":            "🦥",
+	"rose":             "🌹",
+	"sunflower":        "🌻",
+	"tulip":            "🌷",
+	"cherry_blossom":   "🌸",
+	"seedling":         "🌱",
+	"evergreen_tree":   "🌲",
+	"deciduous_tree":   "🌳",
+	"palm_tree":        "🌴",
+	"cactus":           "🌵",
+	"four_leaf_clover": "🍀",
+	"maple_leaf":       "🍁",
+	"mushroom":         "🍄",
+	"earth_africa":     "🌍",
+	"earth_americas":   "🌎",
+	"earth_asia":       "🌏",
+	"full_moon":        "🌕",
+	"new_moon":         "🌑",
+	"crescent_moon":    "🌙",
+	"sunny":            "☀️",
+	"cloud":            "☁️",
+	"partly_sunny":     "⛅",
+	"rainbow":          "🌈",
+	"umbrella":         "☔",
+	"snowflake":        "❄️",
+	"snowman":          "⛄",
+	"droplet":          "💧",
+	"ocean":            "🌊",
+	"volcano":          "🌋",
+
+	// Food and drink
+	"apple":            "🍎",
+	"green_apple":      "🍏",
+	"banana":           "🍌",
+	"grapes":           "🍇",
+	"watermelon":       "🍉",
+	"strawberry":       "🍓",
+	"cherries":         "🍒",
+	"peach":            "🍑",
+	"pineapple":        "🍍",
+	"lemon":            "🍋",
+	"avocado":          "🥑",
+	"eggplant":         "🍆",
+	"hot_pepper":       "🌶️",
+	"corn":             "🌽",
+	"carrot":           "🥕",
+	"bread":            "🍞",
+	"cheese":           "🧀",
+	"egg":              "🥚",
+	"bacon":            "🥓",
+	"hamburger":        "🍔",
+	"fries":            "🍟",
+	"pizza":            "🍕",
+	"hotdog":           "🌭",
+	"taco":             "🌮",
+	"burrito":          "🌯",
+	"sushi":            "🍣",
+	"ramen":            "🍜",
+	"spaghetti":        "🍝",
+	"popcorn":          "🍿",
+	"doughnut":         "🍩",
+	"cookie":           "🍪",
+	"cake":             "🍰",
+	"birthday":         "🎂",
+	"chocolate_bar":    "🍫",
+	"candy":            "🍬",
+	"icecream":         "🍦",
+	"coffee":           "☕",
+	"tea":              "🍵",
+	"beer":             "🍺",
+	"beers":            "🍻",
+	"wine_glass":       "🍷",
+	"cocktail":         "🍸",
+	"tropical_drink":   "🍹",
+	"champagne":        "🍾",
+	"clinking_glasses": "🥂",
+	"milk_glass":       "🥛",
+
+	// Activities and objects
+	"tada":                       "🎉",
+	"confetti_ball":              "🎊",
+	"balloon":                    "🎈",
+	"gift":                       "🎁",
+	"trophy":                     "🏆",
+	"medal_sports":               "🏅",
+	"1st_place_medal":            "🥇",
+	"2nd_place_medal":            "🥈",
+	"3rd_place_medal":            "🥉",
+	"soccer":                     "⚽",
+	"basketball":                 "🏀",
+	"football":                   "🏈",
+	"baseball":                   "⚾",
+	"tennis":                     "🎾",
+	"video_game":                 "🎮",
+	"game_die":                   "🎲",
+	"dart":                       "🎯",
+	"jigsaw":                     "🧩",
+	"chess_pawn":                 "♟️",
+	"art":                        "🎨",
+	"musical_note":               "🎵",
+	"notes":                      "🎶",
+	"microphone":                 "🎤",
+	"headphones":                 "🎧",
+	"guitar":                     "🎸",
+	"movie_camera":               "🎥",
+	"camera":                     "📷",
+	"tv":                         "📺",
+	"computer":                   "💻",
+	"desktop_computer":           "🖥️",
+	"keyboard":                   "⌨️",
+	"iphone":                     "📱",
+	"phone":                      "☎️",
+	"telephone":                  "☎️",
+	"battery":                    "🔋",
+	"electric_plug":              "🔌",
+	"bulb":                       "💡",
+	"flashlight":                 "🔦",
+	"wrench":                     "🔧",
+	"hammer":                     "🔨",
+	"hammer_and_wrench":          "🛠️",
+	"gear":                       "⚙️",
+	"nut_and_bolt":               "🔩",
+	"link":                       "🔗",
+	"lock":                       "🔒",
+	"unlock":                     "🔓",
+	"key":                        "🔑",
+	"mag":                        "🔍",
+	"bell":                       "🔔",
+	"no_bell":                    "🔕",
+	"loudspeaker":                "📢",
+	"mega":                       "📣",
+	"email":                      "📧",
+	"envelope":                   "✉️",
+	"inbox_tray":                 "📥",
+	"outbox_tray":                "📤",
+	"package":                    "📦",
+	"memo":                       "📝",
+	"pencil":                     "📝",
+	"pencil2":                    "✏️",
+	"pushpin":                    "📌",
+	"paperclip":                  "📎",
+	"scissors":                   "✂️",
+	"calendar":                   "📆",
+	"date":                       "📅",
+	"clipboard":                  "📋",
+	"chart_with_upwards_trend":   "📈",
+	"chart_with_downwards_trend": "📉",
+	"bar_chart":                  "📊",
+	"books":                      "📚",
+	"book":                       "📖",
+	"bookmark":                   "🔖",
+	"moneybag":                   "💰",
+	"dollar":                     "💵",
+	"credit_card":                "💳",
+	"gem":                        "💎",
+	"hourglass":                  "⌛",
+	"stopwatch":                  "⏱️",
+	"alarm_clock":                "⏰",
+	"watch":                      "⌚",
+	"rocket":                     "🚀",
+	"airplane":                   "✈️",
+	"car":                        "🚗",
+	"red_car":                    "🚗",
+	"bike":                       "🚲",
+	"train":                      "🚆",
+	"ship":                       "🚢",
+	"construction":               "🚧",
+	"rotating_light":             "🚨",
+	"house":                      "🏠",
+	"office":                     "🏢",
+	"hospital":                   "🏥",
+	"tent":                       "⛺",
+	"crown":                      "👑",
+	"tophat":                     "🎩",
+	"eyeglasses":                 "👓",
+	"dark_sunglasses":            "🕶️",
+	"shirt":                      "👕",
+	"lipstick":                   "💄",
+	"ring":                       "💍",
+	"pill":                       "💊",
+	"syringe":                    "💉",
+	"dna":                        "🧬",
+	"test_tube":                  "🧪",
+	"microscope":                 "🔬",
+	"telescope":                  "🔭",
+	"satellite":                  "📡",
+	"bomb":                       "💣",
+	"shield":                     "🛡️",
+	"crossed_swords":             "⚔️",
+	"magic_wand":                 "🪄",
+	"crystal_ball":               "🔮",
+	"ghost_emoji":                "👻",
+	"jack_o_lantern":             "🎃",
+	"christmas_tree":             "🎄",
+	"fireworks":                  "🎆",
+	"flag_white":                 "🏳️",
+	"rainbow_flag":               "🏳️‍🌈",
+	"pirate_flag":                "🏴‍☠️",
+	"wastebasket":                "🗑️",
+	"bathtub":                    "🛁",
+	"toilet":                     "🚽",
+	"coffin":                     "⚰️",
+	"money_with_wings":           "💸",
+	"ship_it":                    "🚀",
+	"shipit":                     "🚀",
+}
@@ -0,0 +1,128 @@
+package errreport
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"chat-app/config"
+	"chat-app/metrics"
+
+	"github.com/getsentry/sentry-go"
+)
+
+/*
+Error Reporting Overview:
+------------------------
+Optional Sentry integration for failures that would otherwise
+only show up as a log line:
+1. Panics in connection goroutines and the hub
+2. Failed WebSocket upgrades
+3. Storage errors
+
+Every report carries the connection context (room, user and
+connection ID) so an issue can be tied back to a session.
+Without a DSN all functions still log but send nothing.
+*/
+
+// flushTimeout bounds how long shutdown waits for queued events
+const flushTimeout = 2 * time.Second
+
+// Context identifies the connection an error belongs to
+type Context struct {
+	Room     string
+	Username string
+	ConnID   string
+}
+
+// enabled is set once Sentry has been initialized with a DSN
+var enabled bool
+
+// Setup initializes the Sentry client when a DSN is configured
+// The returned function flushes buffered events and should be deferred
+func Setup(cfg config.Config) (func(), error) {
+	if cfg.ErrorReporting.DSN == "" {
+		return func() {}, nil
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         cfg.ErrorReporting.DSN,
+		Environment: cfg.ErrorReporting.Environment,
+		ServerName:  cfg.ServiceName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	enabled = true
+
+	return func() { sentry.Flush(flushTimeout) }, nil
+}
+
+// Enabled reports whether events are being sent to Sentry
+func Enabled() bool {
+	return enabled
+}
+
+// CaptureError reports err along with its connection context
+func CaptureError(err error, ctx Context) {
+	if err == nil {
+		return
+	}
+	metrics.Recent.Inc(metrics.EventError)
+	if !enabled {
+		return
+	}
+	sentry.WithScope(func(scope *sentry.Scope) {
+		applyContext(scope, ctx)
+		sentry.CaptureException(err)
+	})
+}
+
+// Recover must be deferred directly; it reports a panic and swallows it
+// Use this in per-connection goroutines so one bad client can't crash the server
+func Recover(ctx Context) {
+	if r := recover(); r != nil {
+		capturePanic(r, ctx)
+	}
+}
+
+// Repanic must be deferred directly; it reports a panic, flushes, and re-panics
+// Use this where continuing would leave the server in a broken state
+func Repanic(ctx Context) {
+	if r := recover(); r != nil {
+		capturePanic(r, ctx)
+		if enabled {
+			sentry.Flush(flushTimeout)
+		}
+		panic(r)
+	}
+}
+
+func capturePanic(r interface{}, ctx Context) {
+	log.Printf("panic recovered (room=%s user=%s conn=%s): %v", ctx.Room, ctx.Username, ctx.ConnID, r)
+	metrics.Recent.Inc(metrics.EventError)
+	if !enabled {
+		return
+	}
+	sentry.WithScope(func(scope *sentry.Scope) {
+		applyContext(scope, ctx)
+		scope.SetLevel(sentry.LevelFatal)
+		if err, ok := r.(error); ok {
+			sentry.CaptureException(err)
+		} else {
+			sentry.CaptureException(fmt.Errorf("panic: %v", r))
+		}
+	})
+}
+
+func applyContext(scope *sentry.Scope, ctx Context) {
+	if ctx.Room != "" {
+		scope.SetTag("room", ctx.Room)
+	}
+	if ctx.ConnID != "" {
+		scope.SetTag("conn_id", ctx.ConnID)
+	}
+	if ctx.Username != "" {
+		scope.SetUser(sentry.User{Username: ctx.Username})
+	}
+}
@@ -0,0 +1,82 @@
+package eventlog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/metrics"
+	"chat-app/storage"
+)
+
+/*
+Event Log Overview:
+------------------
+Every room keeps an append-only stream of what happened in it:
+messages, joins and leaves, and later topic changes, pins and
+moderation actions. The hub hands events to a Recorder, which
+appends them to storage from its own goroutine so a slow backend
+never stalls message delivery.
+
+Views such as history or presence are projections: they are
+rebuilt by replaying the stream in order (see projection.go).
+That makes it possible to answer "what did the room look like at
+offset N" when debugging, and to add new views later without
+changing what is recorded.
+*/
+
+// Recorder appends events to storage in the order they were recorded
+type Recorder struct {
+	store  storage.Store
+	events chan storage.Event
+}
+
+const (
+	// DefaultBuffer is a reasonable recorder buffer for NewRecorder
+	DefaultBuffer = 10000
+
+	// appendTimeout bounds a single append
+	appendTimeout = 5 * time.Second
+)
+
+// NewRecorder creates a recorder holding up to buffer unwritten events
+func NewRecorder(store storage.Store, buffer int) *Recorder {
+	return &Recorder{store: store, events: make(chan storage.Event, buffer)}
+}
+
+// Record queues ev for appending; it never blocks
+// If the buffer is full the event is dropped and counted, because
+// stalling the hub would hurt every room to protect one log entry
+func (r *Recorder) Record(ev storage.Event) {
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	select {
+	case r.events <- ev:
+	default:
+		metrics.EventLogDropped.Inc()
+	}
+}
+
+// Run appends queued events until ctx is cancelled
+func (r *Recorder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-r.events:
+			r.append(ev)
+		}
+	}
+}
+
+func (r *Recorder) append(ev storage.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), appendTimeout)
+	defer cancel()
+	if _, err := r.store.AppendEvent(ctx, ev); err != nil {
+		log.Printf("Event log append failed (room=%s type=%s): %v", ev.Room, ev.Type, err)
+		errreport.CaptureError(fmt.Errorf("append event: %w", err), errreport.Context{Room: ev.Room, Username: ev.Username})
+	}
+}
@@ -0,0 +1,184 @@
+package eventlog
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"chat-app/storage"
+)
+
+// replayPageSize is how many events Replay reads per storage call
+const replayPageSize = 500
+
+// Projection builds a view of a room from its events
+type Projection interface {
+	Apply(ev storage.Event)
+}
+
+// Replay feeds a room's events with offset <= until to every projection
+// in order; until 0 replays the whole log. It returns the last offset applied.
+func Replay(ctx context.Context, store storage.Store, room string, until uint64, projections ...Projection) (uint64, error) {
+	var last uint64
+	for {
+		page, err := store.Events(ctx, room, last, replayPageSize)
+		if err != nil {
+			return last, err
+		}
+		for _, ev := range page {
+			if until != 0 && ev.Offset > until {
+				return last, nil
+			}
+			for _, p := range projections {
+				p.Apply(ev)
+			}
+			last = ev.Offset
+		}
+		if len(page) < replayPageSize {
+			return last, nil
+		}
+	}
+}
+
+// HistoryEntry is one message in the History view
+type HistoryEntry struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	Seq       uint64    `json:"seq,omitempty"`
+	Offset    uint64    `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	Hidden    bool      `json:"hidden,omitempty"` // Hidden by moderation, pending review or with a ban
+
+	Quote *storage.Quote `json:"quote,omitempty"` // The message replied to, as it read then
+}
+
+// History projects the most recent messages of a room
+// Messages deleted or purged by moderation are dropped and hidden ones marked
+type History struct {
+	limit    int
+	removals *Removals // Known up front, see Excluding
+	Messages []HistoryEntry
+}
+
+// NewHistory keeps the newest limit messages
+func NewHistory(limit int) *History {
+	return &History{limit: limit, Messages: []HistoryEntry{}}
+}
+
+// Excluding leaves out the messages in r from the start, so removals
+// recorded later don't leave the view short of its limit
+func (h *History) Excluding(r *Removals) *History {
+	h.removals = r
+	return h
+}
+
+// Apply implements Projection
+func (h *History) Apply(ev storage.Event) {
+	if p, ok := ParsePurge(ev); ok {
+		h.purge(p)
+	}
+	if p, ok := ParseHidden(ev); ok {
+		h.hide(p)
+	}
+	if ev.Type == storage.EventModeration || ev.Type == storage.EventRecall {
+		h.moderate(ev)
+		return
+	}
+	if ev.Type != storage.EventMessage || (h.removals != nil && h.removals.Removed(ev)) {
+		return
+	}
+	h.Messages = append(h.Messages, HistoryEntry{
+		ID:        ev.MessageID,
+		Username:  ev.Username,
+		Content:   ev.Content,
+		Seq:       ev.Seq,
+		Offset:    ev.Offset,
+		CreatedAt: ev.CreatedAt,
+		Quote:     storage.QuoteOf(ev),
+	})
+	if len(h.Messages) > h.limit {
+		h.Messages = h.Messages[len(h.Messages)-h.limit:]
+	}
+}
+
+// moderate applies a moderation decision, or its sender's recall, to a
+// message still in view
+func (h *History) moderate(ev storage.Event) {
+	if ev.MessageID == "" {
+		return
+	}
+	for i, m := range h.Messages {
+		if m.ID != ev.MessageID {
+			continue
+		}
+		if ev.Type == storage.EventRecall {
+			h.Messages = append(h.Messages[:i], h.Messages[i+1:]...)
+			return
+		}
+		switch ev.Data["action"] {
+		case "hide":
+			h.Messages[i].Hidden = true
+		case "restore", "approve":
+			h.Messages[i].Hidden = false
+		case "delete", "ban":
+			h.Messages = append(h.Messages[:i], h.Messages[i+1:]...)
+		}
+		return
+	}
+}
+
+// purge drops the messages in view that p selects
+func (h *History) purge(p storage.Purge) {
+	kept := h.Messages[:0]
+	for _, m := range h.Messages {
+		if !p.Matches(m.Username, m.CreatedAt) {
+			kept = append(kept, m)
+		}
+	}
+	h.Messages = kept
+}
+
+// hide marks the messages in view that p selects hidden
+func (h *History) hide(p storage.Purge) {
+	for i, m := range h.Messages {
+		if p.Matches(m.Username, m.CreatedAt) {
+			h.Messages[i].Hidden = true
+		}
+	}
+}
+
+// Presence projects who is in a room
+// A user with several connections stays present until the last one leaves
+type Presence struct {
+	connections map[string]int
+}
+
+// NewPresence starts with an empty room
+func NewPresence() *Presence {
+	return &Presence{connections: make(map[string]int)}
+}
+
+// Apply implements Projection
+func (p *Presence) Apply(ev storage.Event) {
+	switch ev.Type {
+	case storage.EventJoin:
+		p.connections[ev.Username]++
+	case storage.EventLeave:
+		if p.connections[ev.Username] <= 1 {
+			delete(p.connections, ev.Username)
+		} else {
+			p.connections[ev.Username]--
+		}
+	}
+}
+
+// Users lists present users alphabetically
+func (p *Presence) Users() []string {
+	users := make([]string, 0, len(p.connections))
+	for u := range p.connections {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users
+}
@@ -0,0 +1,150 @@
+package eventlog
+
+import (
+	"context"
+	"time"
+
+	"chat-app/storage"
+)
+
+// ActionPurge is the action of moderation events recording a purge
+const ActionPurge = "purge"
+
+// Cascades a ban's moderation event may carry in data.cascade, along
+// with the purge's range: the banned user's messages in it were
+// deleted, or hidden from everyone but the log
+const (
+	CascadeDelete = "delete"
+	CascadeHide   = "hide"
+)
+
+// PurgeData is the data of a purge's moderation event; the event's
+// username is the purge's
+func PurgeData(p storage.Purge) map[string]string {
+	data := map[string]string{"until": p.Until.Format(time.RFC3339Nano)}
+	if !p.Since.IsZero() {
+		data["since"] = p.Since.Format(time.RFC3339Nano)
+	}
+	return data
+}
+
+// ParsePurge returns the purge ev records, if it records one: a purge,
+// or a ban cascading to delete the user's messages
+func ParsePurge(ev storage.Event) (storage.Purge, bool) {
+	if ev.Type != storage.EventModeration || (ev.Data["action"] != ActionPurge && ev.Data["cascade"] != CascadeDelete) {
+		return storage.Purge{}, false
+	}
+	return parseRange(ev)
+}
+
+// ParseHidden returns the messages a ban's moderation event hid, if it
+// cascaded to hiding them
+func ParseHidden(ev storage.Event) (storage.Purge, bool) {
+	if ev.Type != storage.EventModeration || ev.Data["cascade"] != CascadeHide {
+		return storage.Purge{}, false
+	}
+	return parseRange(ev)
+}
+
+// parseRange reads the purge PurgeData wrote to ev
+func parseRange(ev storage.Event) (storage.Purge, bool) {
+	until, err := time.Parse(time.RFC3339Nano, ev.Data["until"])
+	if err != nil {
+		return storage.Purge{}, false
+	}
+	p := storage.Purge{Username: ev.Username, Until: until}
+	if since, ok := ev.Data["since"]; ok {
+		if p.Since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			return storage.Purge{}, false
+		}
+	}
+	return p, true
+}
+
+// Removals collects what was removed from a room, messages deleted or
+// recalled one by one and purges, so a second replay can leave them
+// out from the start (see History.Excluding)
+type Removals struct {
+	deleted map[string]bool
+	purges  []purgeMark
+}
+
+// purgeMark is a purge and where it was recorded; it only covers
+// messages recorded before it
+type purgeMark struct {
+	purge  storage.Purge
+	offset uint64
+}
+
+// NewRemovals starts with nothing removed
+func NewRemovals() *Removals {
+	return &Removals{deleted: make(map[string]bool)}
+}
+
+// Apply implements Projection
+func (r *Removals) Apply(ev storage.Event) {
+	if p, ok := ParsePurge(ev); ok {
+		r.purges = append(r.purges, purgeMark{purge: p, offset: ev.Offset})
+	}
+	if ev.Type == storage.EventRecall {
+		r.deleted[ev.MessageID] = true
+	}
+	if ev.Type == storage.EventModeration && ev.MessageID != "" {
+		switch ev.Data["action"] {
+		case "delete", "ban":
+			r.deleted[ev.MessageID] = true
+		}
+	}
+}
+
+// Removed reports whether the message event ev was deleted or purged
+func (r *Removals) Removed(ev storage.Event) bool {
+	if r.deleted[ev.MessageID] {
+		return true
+	}
+	for _, m := range r.purges {
+		if ev.Offset < m.offset && m.purge.Matches(ev.Username, ev.CreatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matching returns the newest limit messages still in room's log that
+// p selects, oldest first; limit 0 returns them all
+func Matching(ctx context.Context, store storage.Store, room string, p storage.Purge, limit int) ([]HistoryEntry, error) {
+	removals := NewRemovals()
+	if _, err := Replay(ctx, store, room, 0, removals); err != nil {
+		return nil, err
+	}
+	m := &matching{purge: p, removals: removals, limit: limit}
+	if _, err := Replay(ctx, store, room, 0, m); err != nil {
+		return nil, err
+	}
+	return m.entries, nil
+}
+
+// matching keeps the messages a purge would select
+type matching struct {
+	purge    storage.Purge
+	removals *Removals
+	limit    int
+	entries  []HistoryEntry
+}
+
+// Apply implements Projection
+func (m *matching) Apply(ev storage.Event) {
+	if ev.Type != storage.EventMessage || !m.purge.Matches(ev.Username, ev.CreatedAt) || m.removals.Removed(ev) {
+		return
+	}
+	m.entries = append(m.entries, HistoryEntry{
+		ID:        ev.MessageID,
+		Username:  ev.Username,
+		Seq:       ev.Seq,
+		Offset:    ev.Offset,
+		CreatedAt: ev.CreatedAt,
+	})
+	if m.limit > 0 && len(m.entries) > m.limit {
+		m.entries = m.entries[len(m.entries)-m.limit:]
+	}
+}
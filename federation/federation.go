@@ -0,0 +1,312 @@
+package federation
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-app/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Federation Overview:
+-------------------
+Separate chat-app deployments can share rooms. Each server has a
+federation name (CHAT_FEDERATION_NAME), and knows each peer by name,
+base URL and a secret shared with that peer alone. Servers post
+batches of frames to each other at FramesPath:
+
+	POST /federation/v1/frames
+	X-Chat-Federation-Origin:    a.example
+	X-Chat-Federation-Timestamp: 1718010000
+	X-Chat-Federation-Nonce:     9f86d081884c7d659a2feaa0c55ad015
+	X-Chat-Federation-Signature: <hex HMAC-SHA256 of origin, timestamp, nonce and body>
+
+	[{...}, {...}]
+
+The signature is made with the secret shared with the receiver, so
+only that peer could have sent the request. Requests with a timestamp
+more than maxSkew from the receiver's clock are refused, and the
+receiver remembers each nonce it accepted until then and refuses it
+again, so a captured request can't be replayed to the node that took
+it. Nonces are remembered in memory by each node: behind a load
+balancer, a request replayed to another node within maxSkew is still
+accepted there.
+
+Frames are opaque here; the hub encodes them (websockets/federation.go).
+Links to peers are in link.go.
+*/
+
+// FramesPath is where servers accept frames from their peers
+const FramesPath = "/federation/v1/frames"
+
+// Headers on requests between servers
+const (
+	originHeader    = "X-Chat-Federation-Origin"
+	timestampHeader = "X-Chat-Federation-Timestamp"
+	nonceHeader     = "X-Chat-Federation-Nonce"
+	signatureHeader = "X-Chat-Federation-Signature"
+)
+
+const (
+	// Largest clock difference accepted between peers
+	maxSkew = 5 * time.Minute
+
+	// Largest request body accepted from a peer
+	maxBody = 4 << 20
+
+	// Longest nonce accepted from a peer
+	maxNonce = 64
+)
+
+// Peer is another server rooms can be shared with
+type Peer struct {
+	Name   string // Its CHAT_FEDERATION_NAME
+	URL    string // Base URL, e.g. https://chat.b.example
+	Secret string // Shared with that peer alone
+}
+
+// Federation links this server to its peers; safe for concurrent use
+type Federation struct {
+	name  string
+	peers map[string]*peerLink
+
+	mu      sync.RWMutex
+	onFrame func(from string, frame []byte)
+	onUp    func(peer string)
+
+	seenMu    sync.Mutex
+	seen      map[string]time.Time // Origin and nonce -> when its timestamp gets too old to pass
+	lastSweep time.Time
+}
+
+// New returns a federation for the server called name; call Run to
+// start sending
+func New(name string, peers []Peer) *Federation {
+	f := &Federation{name: name, peers: make(map[string]*peerLink), seen: make(map[string]time.Time)}
+	for _, p := range peers {
+		f.peers[p.Name] = newPeerLink(p)
+	}
+	return f
+}
+
+// ParsePeers reads peers from their settings: peers is a list like
+// "b.example=https://chat.b.example,c.example=https://c.example", and
+// secrets one like "b.example=s3cret,c.example=0th3r"
+// Every peer needs a secret
+func ParsePeers(peers, secrets string) ([]Peer, error) {
+	keys, err := parsePairs(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	urls, err := parsePairs(peers)
+	if err != nil {
+		return nil, fmt.Errorf("peers: %w", err)
+	}
+
+	var list []Peer
+	for _, name := range sortedKeys(urls) {
+		url := strings.TrimSuffix(urls[name], "/")
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("peer %s: URL must start with http:// or https://", name)
+		}
+		if keys[name] == "" {
+			return nil, fmt.Errorf("peer %s has no secret", name)
+		}
+		list = append(list, Peer{Name: name, URL: url, Secret: keys[name]})
+	}
+	for name := range keys {
+		if _, ok := urls[name]; !ok {
+			return nil, fmt.Errorf("secret for unknown peer %s", name)
+		}
+	}
+	return list, nil
+}
+
+// parsePairs splits "name=value,..." on the first = of each item, so
+// values may contain =
+func parsePairs(list string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("%q is not name=value", item)
+		}
+		if _, dup := pairs[name]; dup {
+			return nil, fmt.Errorf("%s is listed twice", name)
+		}
+		pairs[name] = value
+	}
+	return pairs, nil
+}
+
+// Name is this server's federation name
+func (f *Federation) Name() string {
+	return f.name
+}
+
+// Known reports whether server is one of the configured peers
+func (f *Federation) Known(server string) bool {
+	_, ok := f.peers[server]
+	return ok
+}
+
+// Peers lists the configured peers' names, sorted
+func (f *Federation) Peers() []string {
+	return sortedKeys(f.peers)
+}
+
+// OnFrame sets the handler for frames arriving from peers
+// fn runs on the request's goroutine, once per frame in order
+func (f *Federation) OnFrame(fn func(from string, frame []byte)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onFrame = fn
+}
+
+// OnLinkUp sets the handler for a link to a peer coming up, at start or
+// after an outage
+// fn runs on a goroutine of its own
+func (f *Federation) OnLinkUp(fn func(peer string)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onUp = fn
+}
+
+// linkUp calls the OnLinkUp handler, if any
+func (f *Federation) linkUp(peer string) {
+	f.mu.RLock()
+	fn := f.onUp
+	f.mu.RUnlock()
+	if fn != nil {
+		go fn(peer)
+	}
+}
+
+// Sign is the signature on a request from origin at timestamp ts with
+// nonce, made with the secret origin shares with the receiver
+func Sign(secret, origin string, ts int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(origin + "\n" + strconv.FormatInt(ts, 10) + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newNonce returns a random nonce for a request
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// verify checks a request's origin, timestamp, nonce and signature,
+// returning the origin
+func (f *Federation) verify(r *http.Request, body []byte, now time.Time) (string, error) {
+	origin := r.Header.Get(originHeader)
+	link, ok := f.peers[origin]
+	if !ok {
+		return "", fmt.Errorf("unknown origin %q", origin)
+	}
+	ts, err := strconv.ParseInt(r.Header.Get(timestampHeader), 10, 64)
+	if err != nil {
+		return "", errors.New("missing or invalid timestamp")
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > maxSkew || skew < -maxSkew {
+		return "", errors.New("timestamp too far from this server's clock")
+	}
+	nonce := r.Header.Get(nonceHeader)
+	if nonce == "" || len(nonce) > maxNonce {
+		return "", errors.New("missing or invalid nonce")
+	}
+	want := Sign(link.peer.Secret, origin, ts, nonce, body)
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(signatureHeader))) {
+		return "", errors.New("invalid signature")
+	}
+	if !f.firstSeen(origin, nonce, time.Unix(ts, 0).Add(maxSkew), now) {
+		return "", errors.New("request already received")
+	}
+	return origin, nil
+}
+
+// firstSeen records origin's nonce until expires, reporting whether it
+// is new; nonces whose requests can no longer pass are forgotten
+func (f *Federation) firstSeen(origin, nonce string, expires, now time.Time) bool {
+	f.seenMu.Lock()
+	defer f.seenMu.Unlock()
+	if now.Sub(f.lastSweep) > time.Minute {
+		for key, until := range f.seen {
+			if now.After(until) {
+				delete(f.seen, key)
+			}
+		}
+		f.lastSweep = now
+	}
+	key := origin + "\x00" + nonce
+	if _, ok := f.seen[key]; ok {
+		return false
+	}
+	f.seen[key] = expires
+	return true
+}
+
+// Handler accepts frames from peers and passes them to OnFrame
+func (f *Federation) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Step 1: Check the request comes from a peer, recently
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+		if err != nil || len(body) > maxBody {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "body too large"})
+			return
+		}
+		origin, err := f.verify(c.Request, body, time.Now())
+		if err != nil {
+			metrics.FederationFrames.WithLabelValues("refused").Inc()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Step 2: Hand each frame to the hub
+		var frames []json.RawMessage
+		if err := json.Unmarshal(body, &frames); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON array of frames"})
+			return
+		}
+		f.mu.RLock()
+		handler := f.onFrame
+		f.mu.RUnlock()
+		for _, frame := range frames {
+			metrics.FederationFrames.WithLabelValues("received").Inc()
+			if handler != nil {
+				handler(origin, frame)
+			}
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// sortedKeys lists a map's keys in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,176 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/metrics"
+)
+
+/*
+Links Overview:
+--------------
+Send never blocks. Frames for a peer wait in a bounded queue, and one
+goroutine per peer posts them in batches of up to maxBatch. While a
+peer is unreachable, or answers with a 5xx or 429, the batch is
+retried with backoff doubling from minRetryDelay to maxRetryDelay;
+once the queue fills, new frames are dropped and counted. A peer
+refusing a batch with a 4xx won't accept it on retry either, so it is
+dropped and logged: usually the two servers' secrets or clocks
+disagree.
+
+A link starts down, and goes down whenever a post fails for a reason
+a retry might fix. While it is down and nothing is queued, the peer
+is probed with an empty batch at the same backoff. The first post
+that succeeds brings it up and calls the OnLinkUp handler, so the hub
+can catch up on what the outage cost (see websockets/backfill.go).
+*/
+
+const (
+	// Frames queued per peer before new ones are dropped
+	queueSize = 4096
+
+	// Most frames posted in one request
+	maxBatch = 100
+
+	// Backoff between attempts to reach an unreachable peer
+	minRetryDelay = time.Second
+	maxRetryDelay = 30 * time.Second
+
+	// Bound on one request to a peer
+	requestTimeout = 10 * time.Second
+
+	// Most of an error response kept for the log
+	maxErrorBody = 512
+)
+
+// peerLink is the outgoing queue to one peer
+type peerLink struct {
+	peer  Peer
+	queue chan []byte
+}
+
+// newPeerLink returns an idle link to p
+func newPeerLink(p Peer) *peerLink {
+	return &peerLink{peer: p, queue: make(chan []byte, queueSize)}
+}
+
+// Send queues frame for server without blocking
+func (f *Federation) Send(server string, frame []byte) {
+	link, ok := f.peers[server]
+	if !ok {
+		metrics.FederationFrames.WithLabelValues("dropped").Inc()
+		return
+	}
+	select {
+	case link.queue <- frame:
+	default:
+		metrics.FederationFrames.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run posts queued frames to peers until ctx is done
+func (f *Federation) Run(ctx context.Context) {
+	for _, link := range f.peers {
+		go f.runLink(ctx, link)
+	}
+	<-ctx.Done()
+}
+
+// runLink posts one peer's frames in batches, retrying failed batches,
+// and reports the link coming up
+func (f *Federation) runLink(ctx context.Context, link *peerLink) {
+	client := &http.Client{Timeout: requestTimeout}
+	up := false
+	delay := minRetryDelay
+	for {
+		// Step 1: Wait for a frame, then take whatever else is queued;
+		// while the link is down, don't wait, so an empty batch probes it
+		batch := []json.RawMessage{}
+		if up {
+			select {
+			case <-ctx.Done():
+				return
+			case frame := <-link.queue:
+				batch = append(batch, frame)
+			}
+		}
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case frame := <-link.queue:
+				batch = append(batch, frame)
+			default:
+				break fill
+			}
+		}
+
+		// Step 2: Post it until the peer takes it, or refuses it for good
+		for {
+			retry, err := f.post(ctx, client, link.peer, batch)
+			if err == nil {
+				metrics.FederationFrames.WithLabelValues("sent").Add(float64(len(batch)))
+				if !up {
+					up = true
+					f.linkUp(link.peer.Name)
+				}
+				delay = minRetryDelay
+				break
+			}
+			if up || len(batch) > 0 {
+				log.Printf("Federation: sending to %s failed: %v", link.peer.Name, err)
+			}
+			if !retry {
+				metrics.FederationFrames.WithLabelValues("dropped").Add(float64(len(batch)))
+				break
+			}
+			up = false
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxRetryDelay)
+			if len(batch) == 0 {
+				break // Probe again with whatever was queued meanwhile
+			}
+		}
+	}
+}
+
+// post sends one signed batch to peer; retry reports whether a failure
+// might succeed later
+func (f *Federation) post(ctx context.Context, client *http.Client, peer Peer, batch []json.RawMessage) (retry bool, err error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer.URL+FramesPath, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	ts, nonce := time.Now().Unix(), newNonce()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(originHeader, f.name)
+	req.Header.Set(timestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, Sign(peer.Secret, f.name, ts, nonce, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return false, nil
+}
@@ -0,0 +1,67 @@
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+/*
+GeoIP Overview:
+--------------
+Tags connections with where they come from, using a MaxMind
+database (GeoLite2 or GeoIP2, City or Country edition):
+
+	CHAT_GEOIP_DB=/var/lib/GeoIP/GeoLite2-City.mmdb
+
+Locations use ISO codes: Country is ISO 3166-1 ("US") and Region is
+the ISO 3166-2 code of the first subdivision ("US-CA"). Country
+databases have no subdivisions, so Region stays empty. Private,
+loopback and unknown addresses resolve to an empty Location.
+
+The database is memory-mapped and safe for concurrent lookups. It is
+read once at startup; restart (or upgrade) to pick up a new edition.
+*/
+
+// Location is where an IP address is registered
+type Location struct {
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+}
+
+// Resolver looks up IP addresses in a MaxMind database
+type Resolver struct {
+	db *geoip2.Reader
+}
+
+// Open loads the database at path
+func Open(path string) (*Resolver, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{db: db}, nil
+}
+
+// Lookup resolves ip, which may be in any form net.ParseIP accepts
+func (r *Resolver) Lookup(ip string) Location {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.IsLoopback() || addr.IsPrivate() {
+		return Location{}
+	}
+	// City lookups also work on Country editions
+	record, err := r.db.City(addr)
+	if err != nil {
+		return Location{}
+	}
+	loc := Location{Country: record.Country.IsoCode}
+	if len(record.Subdivisions) > 0 && loc.Country != "" && record.Subdivisions[0].IsoCode != "" {
+		loc.Region = loc.Country + "-" + record.Subdivisions[0].IsoCode
+	}
+	return loc
+}
+
+// Close releases the database
+func (r *Resolver) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,83 @@
+package geoip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/ratelimit"
+)
+
+/*
+Connection Limits Overview:
+--------------------------
+Caps how fast new connections are accepted per region, so a burst
+from one place (a botnet, a reconnect storm after a regional outage)
+can't crowd out everyone else:
+
+	CHAT_GEOIP_CONN_RATE="US-CA=50,CN=20,*=200"
+
+Each entry is new connections per second for a region (ISO 3166-2),
+a country (ISO 3166-1) or "*" for every other country, each with its
+own bucket. A connection is checked against the most specific entry
+that matches it; connections with no entry, including unresolved
+addresses when "*" isn't set, are not limited. Bursts of up to one
+second's worth are allowed.
+*/
+
+// Limits rate-limits new connections by location
+type Limits struct {
+	rates   map[string]float64 // Key -> connections per second
+	buckets *ratelimit.Keyed   // By key, or "*:" + country for the wildcard
+}
+
+// ParseLimits parses a comma-separated list of KEY=RATE entries
+// It returns nil, meaning no limits, for an empty spec
+func ParseLimits(spec string) (*Limits, error) {
+	rates := make(map[string]float64)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid connection rate %q (want KEY=PER_SECOND)", entry)
+		}
+		rates[strings.ToUpper(strings.TrimSpace(key))] = rate
+	}
+	if len(rates) == 0 {
+		return nil, nil
+	}
+	return &Limits{rates: rates, buckets: ratelimit.NewKeyed()}, nil
+}
+
+// Allow takes a token for a new connection from loc
+func (l *Limits) Allow(loc Location, now time.Time) bool {
+	key, rate, ok := l.match(loc)
+	if !ok {
+		return true
+	}
+	_, ok = l.buckets.Reserve(key, rate, rate, now, 0)
+	return ok
+}
+
+// match finds the most specific rate for loc and the bucket it draws from
+func (l *Limits) match(loc Location) (string, float64, bool) {
+	if loc.Region != "" {
+		if rate, ok := l.rates[loc.Region]; ok {
+			return loc.Region, rate, true
+		}
+	}
+	if loc.Country != "" {
+		if rate, ok := l.rates[loc.Country]; ok {
+			return loc.Country, rate, true
+		}
+		if rate, ok := l.rates["*"]; ok {
+			return "*:" + loc.Country, rate, true
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,263 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // Registers the SHA-256 hash
+	_ "crypto/sha512" // Registers the SHA-384 and SHA-512 hashes
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+JWT Auth Overview:
+-----------------
+A Validator checks JSON Web Tokens issued by the operator's sign-in
+service, so connections can prove who they are instead of naming
+themselves:
+
+	GET /ws/lobby?access_token=eyJhbGciOiJIUzI1NiIs...
+
+A token is accepted when:
+1. Its signature checks out: HS256/384/512 with the shared secret, or
+   RS256/384/512 and ES256/384 with the configured public key. Other
+   algorithms, "none" among them, are refused.
+2. It carries an exp in the future and any nbf has passed, give or
+   take Leeway for clock drift between servers.
+3. Its iss and aud match, when an issuer or audience is configured.
+4. Its username claim ("sub" unless configured) is a non-empty string.
+
+The secret is read for every token, so one rotated in a secret store
+(see the secrets package) applies without a restart.
+*/
+
+// DefaultLeeway is the clock drift allowed when none is configured
+const DefaultLeeway = 30 * time.Second
+
+// ErrInvalidToken is wrapped by every reason a token is refused
+var ErrInvalidToken = errors.New("invalid token")
+
+// Config says which tokens a Validator accepts
+type Config struct {
+	Secret        func() string    // HMAC key, read per token; nil or empty refuses HS* tokens
+	PublicKey     crypto.PublicKey // *rsa.PublicKey or *ecdsa.PublicKey; nil refuses RS* and ES* tokens
+	Issuer        string           // Required iss; empty accepts any
+	Audience      string           // Required among aud; empty accepts any
+	UsernameClaim string           // Claim naming the user; empty means "sub"
+	Leeway        time.Duration    // Clock drift allowed; 0 means DefaultLeeway
+}
+
+// Identity is who a valid token says its bearer is
+type Identity struct {
+	Username  string         `json:"username"`
+	Subject   string         `json:"subject,omitempty"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	Claims    map[string]any `json:"-"` // Every claim, for embedders' own checks
+}
+
+// Validator checks tokens against a Config; it is safe for concurrent use
+type Validator struct {
+	cfg Config
+	now func() time.Time
+}
+
+// New returns a Validator for cfg, or an error if it has no key to check with
+func New(cfg Config) (*Validator, error) {
+	if cfg.Secret == nil && cfg.PublicKey == nil {
+		return nil, errors.New("jwtauth: a secret or a public key is required")
+	}
+	switch cfg.PublicKey.(type) {
+	case nil, *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("jwtauth: unsupported public key type %T", cfg.PublicKey)
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "sub"
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = DefaultLeeway
+	}
+	return &Validator{cfg: cfg, now: time.Now}, nil
+}
+
+// LoadPublicKey reads a PEM-encoded RSA or ECDSA public key, or a
+// certificate carrying one
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwtauth: %s holds no PEM data", path)
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+// header is the part of a token's header that matters here
+type header struct {
+	Alg string `json:"alg"`
+}
+
+// Validate returns the identity token vouches for, or an error wrapping
+// ErrInvalidToken saying why it can't be used
+func (v *Validator) Validate(ctx context.Context, token string) (Identity, error) {
+	// Step 1: Split and decode
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var h header
+	if err := decodePart(parts[0], &h); err != nil {
+		return Identity{}, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	// Step 2: Check the signature before trusting any claim
+	if err := v.verify(h.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return Identity{}, err
+	}
+	claims := map[string]any{}
+	if err := decodePart(parts[1], &claims); err != nil {
+		return Identity{}, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	// Step 3: Check the claims
+	now := v.now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if now.After(exp.Add(v.cfg.Leeway)) {
+		return Identity{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.cfg.Leeway).Before(nbf) {
+		return Identity{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if v.cfg.Issuer != "" && claims["iss"] != v.cfg.Issuer {
+		return Identity{}, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return Identity{}, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	username, _ := claims[v.cfg.UsernameClaim].(string)
+	if username == "" {
+		return Identity{}, fmt.Errorf("%w: no %s claim", ErrInvalidToken, v.cfg.UsernameClaim)
+	}
+	subject, _ := claims["sub"].(string)
+	return Identity{Username: username, Subject: subject, ExpiresAt: exp, Claims: claims}, nil
+}
+
+// algorithms are the hashes of the signing algorithms accepted
+var algorithms = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384,
+}
+
+// verify checks sig over signed with the key alg calls for; an algorithm
+// without a configured key of its kind is refused, so an HS256 token
+// can't be signed with the public key
+func (v *Validator) verify(alg, signed string, sig []byte) error {
+	h, ok := algorithms[alg]
+	if !ok {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+	badSignature := fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	switch key := v.cfg.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") {
+			if rsa.VerifyPKCS1v15(key, h, digest(h, signed), sig) != nil {
+				return badSignature
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		// ES256 is signed on P-256 and ES384 on P-384: the hash and curve sizes match
+		size := h.Size()
+		if strings.HasPrefix(alg, "ES") && key.Curve.Params().BitSize == size*8 {
+			if len(sig) != 2*size {
+				return badSignature
+			}
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if !ecdsa.Verify(key, digest(h, signed), r, s) {
+				return badSignature
+			}
+			return nil
+		}
+	}
+	if strings.HasPrefix(alg, "HS") && v.cfg.Secret != nil {
+		if secret := v.cfg.Secret(); secret != "" {
+			mac := hmac.New(h.New, []byte(secret))
+			mac.Write([]byte(signed))
+			if !hmac.Equal(mac.Sum(nil), sig) {
+				return badSignature
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s tokens are not accepted", ErrInvalidToken, alg)
+}
+
+// digest hashes s with h
+func digest(h crypto.Hash, s string) []byte {
+	sum := h.New()
+	sum.Write([]byte(s))
+	return sum.Sum(nil)
+}
+
+// decodePart decodes one base64url JSON part of a token into v
+func decodePart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// numericDate reads a claim holding seconds since the epoch
+func numericDate(claim any) (time.Time, bool) {
+	secs, ok := claim.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(secs), 0), true
+}
+
+// hasAudience reports whether an aud claim, one string or a list of
+// them, names audience
+func hasAudience(claim any, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		return slices.Contains(aud, any(audience))
+	}
+	return false
+}
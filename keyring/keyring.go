@@ -0,0 +1,338 @@
+package keyring
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-app/storage"
+)
+
+/*
+Encryption at Rest Overview:
+---------------------------
+A Keyring encrypts stored message content so a stolen copy of the
+store, or a backup of it, doesn't expose what was said. Each room has
+its own data key, made the first time something is written there:
+32 random bytes for AES-256-GCM. Data keys are kept in the store
+(see storage/roomkeys.go) only wrapped by a master key, which lives
+in the server's config or in a KMS and never in the store.
+
+Encrypted content is stored as
+
+	enc:v<version>:<base64 of nonce and ciphertext>
+
+where version is the room key version that encrypted it. The room's
+name is authenticated with the content, so ciphertext moved to
+another room won't decrypt. Content stored before encryption was
+turned on has no prefix and is read as it is.
+
+Two kinds of rotation:
+
+- RotateRoom makes a new version of a room's data key; new content
+  uses it, and older versions are kept to read older content
+- Rewrap re-wraps every data key under the current master key, after
+  the master key is replaced; the old one is needed only until then
+
+Unwrapped data keys are cached in memory, so a KMS is asked once per
+key and not on every message. Loading and making keys hold only their
+room's lock, so a slow store or KMS call for one room doesn't hold up
+the others.
+*/
+
+// Wrapper protects data keys with a master key
+type Wrapper interface {
+	// ID names the master key, so a stored key says which one wrapped it
+	ID() string
+	// Wrap encrypts a data key
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	// Unwrap decrypts a data key Wrap returned
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// ErrUnknownMasterKey is returned for a data key wrapped by a master key the keyring doesn't have
+var ErrUnknownMasterKey = errors.New("keyring: data key wrapped by an unknown master key")
+
+// prefix starts encrypted content
+const prefix = "enc:v"
+
+// dataKeySize is the size of data keys; 32 bytes selects AES-256
+const dataKeySize = 32
+
+// Keyring encrypts and decrypts content with rooms' data keys
+type Keyring struct {
+	store   storage.Store
+	master  Wrapper            // Wraps new keys
+	wrapped map[string]Wrapper // Every master key known, by ID, master included
+
+	mu    sync.Mutex
+	rooms map[string]*roomEntry // By room
+}
+
+// roomEntry holds a room's unwrapped keys once loaded
+// mu is held while they are loaded or replaced, so each room's keys
+// are loaded once however many callers want them
+type roomEntry struct {
+	mu   sync.Mutex
+	keys *roomKeys // Nil until loaded
+}
+
+// roomKeys is a room's unwrapped data keys; never changed once an
+// entry holds it, so callers may keep using it unlocked
+type roomKeys struct {
+	current int                 // Newest version
+	aeads   map[int]cipher.AEAD // By version
+}
+
+// New returns a keyring keeping data keys in store, wrapping them with
+// master; old master keys are only used to unwrap keys Rewrap hasn't
+// moved to master yet
+func New(store storage.Store, master Wrapper, old ...Wrapper) *Keyring {
+	k := &Keyring{
+		store:   store,
+		master:  master,
+		wrapped: map[string]Wrapper{master.ID(): master},
+		rooms:   make(map[string]*roomEntry),
+	}
+	for _, w := range old {
+		k.wrapped[w.ID()] = w
+	}
+	return k
+}
+
+// Encrypt encrypts content for room with its current data key,
+// making the room's first key if it has none
+func (k *Keyring) Encrypt(ctx context.Context, room, content string) (string, error) {
+	if content == "" {
+		return "", nil
+	}
+	keys, err := k.room(ctx, room, true)
+	if err != nil {
+		return "", err
+	}
+	aead := keys.aeads[keys.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(content)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(content), []byte(room))
+	return prefix + strconv.Itoa(keys.current) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt; content that isn't encrypted is returned as it is
+func (k *Keyring) Decrypt(ctx context.Context, room, content string) (string, error) {
+	version, sealed, ok := parse(content)
+	if !ok {
+		return content, nil
+	}
+	keys, err := k.room(ctx, room, false)
+	if err != nil {
+		return "", err
+	}
+	aead, ok := keys.aeads[version]
+	if !ok {
+		// Another process may have rotated the key since it was loaded
+		k.forget(room)
+		if keys, err = k.room(ctx, room, false); err != nil {
+			return "", err
+		}
+		if aead, ok = keys.aeads[version]; !ok {
+			return "", fmt.Errorf("keyring: room %s has no key version %d", room, version)
+		}
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("keyring: content in room %s is truncated", room)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(room))
+	if err != nil {
+		return "", fmt.Errorf("keyring: content in room %s failed to decrypt: %w", room, err)
+	}
+	return string(plain), nil
+}
+
+// parse splits encrypted content into its key version and sealed bytes
+func parse(content string) (int, []byte, bool) {
+	rest, ok := strings.CutPrefix(content, prefix)
+	if !ok {
+		return 0, nil, false
+	}
+	v, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, nil, false
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		return 0, nil, false
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, false
+	}
+	return version, sealed, true
+}
+
+// RotateRoom makes a new version of room's data key for new content,
+// returning the version
+func (k *Keyring) RotateRoom(ctx context.Context, room string) (int, error) {
+	e := k.entry(room)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	keys, err := k.load(ctx, room)
+	if err != nil {
+		return 0, err
+	}
+	if err := k.create(ctx, room, keys); err != nil {
+		return 0, err
+	}
+	e.keys = keys
+	return keys.current, nil
+}
+
+// Rewrap re-wraps every data key not wrapped by the current master key,
+// returning how many were
+func (k *Keyring) Rewrap(ctx context.Context) (int, error) {
+	stored, err := k.store.AllRoomKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, rk := range stored {
+		if rk.MasterKey == k.master.ID() {
+			continue
+		}
+		key, err := k.unwrap(ctx, rk)
+		if err != nil {
+			return n, fmt.Errorf("room %s key v%d: %w", rk.Room, rk.Version, err)
+		}
+		if rk.Wrapped, err = k.master.Wrap(ctx, key); err != nil {
+			return n, err
+		}
+		rk.MasterKey = k.master.ID()
+		if err := k.store.SaveRoomKey(ctx, rk); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// room returns room's unwrapped keys, making its first when create is
+// set and it has none
+func (k *Keyring) room(ctx context.Context, room string, create bool) (*roomKeys, error) {
+	e := k.entry(room)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.keys != nil {
+		return e.keys, nil
+	}
+	keys, err := k.load(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+	if keys.current == 0 {
+		if !create {
+			return nil, fmt.Errorf("keyring: room %s has no data key", room)
+		}
+		if err := k.create(ctx, room, keys); err != nil {
+			return nil, err
+		}
+	}
+	e.keys = keys
+	return keys, nil
+}
+
+// entry returns room's entry, adding an empty one if it has none
+func (k *Keyring) entry(room string) *roomEntry {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	e, ok := k.rooms[room]
+	if !ok {
+		e = &roomEntry{}
+		k.rooms[room] = e
+	}
+	return e
+}
+
+// forget drops room's cached keys so the next use reloads them
+func (k *Keyring) forget(room string) {
+	e := k.entry(room)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys = nil
+}
+
+// load unwraps every stored version of room's key; the room's lock must be held
+func (k *Keyring) load(ctx context.Context, room string) (*roomKeys, error) {
+	stored, err := k.store.RoomKeys(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+	keys := &roomKeys{aeads: make(map[int]cipher.AEAD, len(stored))}
+	for _, rk := range stored {
+		key, err := k.unwrap(ctx, rk)
+		if err != nil {
+			return nil, fmt.Errorf("room %s key v%d: %w", room, rk.Version, err)
+		}
+		if keys.aeads[rk.Version], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+		keys.current = max(keys.current, rk.Version)
+	}
+	return keys, nil
+}
+
+// create makes and stores the next version of room's key; the room's
+// lock must be held
+func (k *Keyring) create(ctx context.Context, room string, keys *roomKeys) error {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, err := k.master.Wrap(ctx, key)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	rk := storage.RoomKey{
+		Room:      room,
+		Version:   keys.current + 1,
+		MasterKey: k.master.ID(),
+		Wrapped:   wrapped,
+		CreatedAt: time.Now(),
+	}
+	if err := k.store.SaveRoomKey(ctx, rk); err != nil {
+		return err
+	}
+	keys.aeads[rk.Version] = aead
+	keys.current = rk.Version
+	return nil
+}
+
+// unwrap decrypts a stored key with the master key that wrapped it
+func (k *Keyring) unwrap(ctx context.Context, rk storage.RoomKey) ([]byte, error) {
+	w, ok := k.wrapped[rk.MasterKey]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownMasterKey, rk.MasterKey)
+	}
+	return w.Unwrap(ctx, rk.Wrapped)
+}
+
+// newAEAD returns AES-GCM with key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,139 @@
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MasterKey wraps data keys with a key from the server's config
+type MasterKey struct {
+	id  string
+	key []byte
+}
+
+// NewMasterKey decodes a base64 256-bit master key
+func NewMasterKey(encoded string) (*MasterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not base64: %w", err)
+	}
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("master key is %d bytes, want %d", len(key), dataKeySize)
+	}
+	sum := sha256.Sum256(key)
+	return &MasterKey{id: "local:" + hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+// ID implements Wrapper; it is derived from the key, not the key itself
+func (m *MasterKey) ID() string {
+	return m.id
+}
+
+// Wrap implements Wrapper
+func (m *MasterKey) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	aead, err := newAEAD(m.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// Unwrap implements Wrapper
+func (m *MasterKey) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(m.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is truncated")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+}
+
+// Vault wraps data keys with a key held by HashiCorp Vault's transit
+// engine, so the master key never leaves Vault
+type Vault struct {
+	addr   string // e.g. https://vault.internal:8200
+	token  string
+	key    string // Transit key name
+	client *http.Client
+}
+
+// vaultTimeout bounds one request to Vault
+const vaultTimeout = 10 * time.Second
+
+// NewVault returns a Wrapper using the transit key named key
+func NewVault(addr, token, key string) *Vault {
+	return &Vault{addr: strings.TrimRight(addr, "/"), token: token, key: key, client: &http.Client{Timeout: vaultTimeout}}
+}
+
+// ID implements Wrapper; Vault versions the key itself, so rotating it
+// there needs no Rewrap here
+func (v *Vault) ID() string {
+	return "vault:" + v.key
+}
+
+// Wrap implements Wrapper
+func (v *Vault) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}
+	if err := v.post(ctx, "encrypt", body, &resp); err != nil {
+		return nil, err
+	}
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+// Unwrap implements Wrapper
+func (v *Vault) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := v.post(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+// post calls a transit endpoint for the key
+func (v *Vault) post(ctx context.Context, op string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.addr+"/v1/transit/"+op+"/"+v.key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vault %s: %s: %s", op, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
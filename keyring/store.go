@@ -0,0 +1,198 @@
+package keyring
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"chat-app/storage"
+)
+
+// Store returns s with message content encrypted on the way in and
+// decrypted on the way out: messages and the quotes replies keep, the
+// event log, the review queue and direct messages queued for offline
+// users (keyed by recipient).
+// Snapshots pass through as stored, so backups stay encrypted and
+// carry the wrapped keys needed to read them.
+func (k *Keyring) Store(s storage.Store) storage.Store {
+	return &encryptedStore{Store: s, keys: k}
+}
+
+// encryptedStore encrypts the content fields of a Store
+type encryptedStore struct {
+	storage.Store
+	keys *Keyring
+}
+
+// directRoom is the key a user's queued direct messages are encrypted with
+func directRoom(username string) string {
+	return "@" + username
+}
+
+func (s *encryptedStore) SaveMessage(ctx context.Context, msg storage.Message) error {
+	var err error
+	if msg.Content, err = s.keys.Encrypt(ctx, msg.Room, msg.Content); err != nil {
+		return err
+	}
+	if msg.Quote, err = s.quote(ctx, msg.Room, msg.Quote, s.keys.Encrypt); err != nil {
+		return err
+	}
+	return s.Store.SaveMessage(ctx, msg)
+}
+
+func (s *encryptedStore) GetMessage(ctx context.Context, id string) (storage.Message, error) {
+	msg, err := s.Store.GetMessage(ctx, id)
+	if err != nil {
+		return msg, err
+	}
+	if msg.Content, err = s.keys.Decrypt(ctx, msg.Room, msg.Content); err != nil {
+		return msg, err
+	}
+	msg.Quote, err = s.quote(ctx, msg.Room, msg.Quote, s.keys.Decrypt)
+	return msg, err
+}
+
+// quote returns a copy of a reply's quote with its excerpt passed through
+// crypt, which is Encrypt or Decrypt
+func (s *encryptedStore) quote(ctx context.Context, room string, q *storage.Quote,
+	crypt func(ctx context.Context, room, text string) (string, error)) (*storage.Quote, error) {
+	if q == nil {
+		return nil, nil
+	}
+	out := *q
+	var err error
+	out.Excerpt, err = crypt(ctx, room, q.Excerpt)
+	return &out, err
+}
+
+// eventQuote passes the quote excerpt in a message event's data through
+// crypt, copying the data so the caller's map is left as it was
+func (s *encryptedStore) eventQuote(ctx context.Context, ev *storage.Event,
+	crypt func(ctx context.Context, room, text string) (string, error)) error {
+	excerpt, ok := ev.Data[storage.QuoteExcerptKey]
+	if !ok {
+		return nil
+	}
+	var err error
+	ev.Data = maps.Clone(ev.Data)
+	ev.Data[storage.QuoteExcerptKey], err = crypt(ctx, ev.Room, excerpt)
+	return err
+}
+
+func (s *encryptedStore) TakeOffline(ctx context.Context, username, room string) ([]storage.Message, error) {
+	msgs, err := s.Store.TakeOffline(ctx, username, room)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptMessages(ctx, msgs)
+}
+
+func (s *encryptedStore) MessagesBefore(ctx context.Context, room string, t time.Time) ([]storage.Message, error) {
+	msgs, err := s.Store.MessagesBefore(ctx, room, t)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptMessages(ctx, msgs)
+}
+
+func (s *encryptedStore) MessagePage(ctx context.Context, room string, before uint64, limit int) ([]storage.Message, error) {
+	msgs, err := s.Store.MessagePage(ctx, room, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptMessages(ctx, msgs)
+}
+
+func (s *encryptedStore) decryptMessages(ctx context.Context, msgs []storage.Message) ([]storage.Message, error) {
+	for i := range msgs {
+		var err error
+		if msgs[i].Content, err = s.keys.Decrypt(ctx, msgs[i].Room, msgs[i].Content); err != nil {
+			return nil, err
+		}
+		if msgs[i].Quote, err = s.quote(ctx, msgs[i].Room, msgs[i].Quote, s.keys.Decrypt); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+func (s *encryptedStore) QueueDirect(ctx context.Context, dm storage.DirectMessage) error {
+	var err error
+	if dm.Content, err = s.keys.Encrypt(ctx, directRoom(dm.To), dm.Content); err != nil {
+		return err
+	}
+	return s.Store.QueueDirect(ctx, dm)
+}
+
+func (s *encryptedStore) TakeDirect(ctx context.Context, username string) ([]storage.DirectMessage, error) {
+	dms, err := s.Store.TakeDirect(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dms {
+		if dms[i].Content, err = s.keys.Decrypt(ctx, directRoom(dms[i].To), dms[i].Content); err != nil {
+			return nil, err
+		}
+	}
+	return dms, nil
+}
+
+func (s *encryptedStore) AppendEvent(ctx context.Context, ev storage.Event) (storage.Event, error) {
+	content, data := ev.Content, ev.Data
+	var err error
+	if ev.Content, err = s.keys.Encrypt(ctx, ev.Room, ev.Content); err != nil {
+		return ev, err
+	}
+	if err := s.eventQuote(ctx, &ev, s.keys.Encrypt); err != nil {
+		return ev, err
+	}
+	ev, err = s.Store.AppendEvent(ctx, ev)
+	ev.Content, ev.Data = content, data
+	return ev, err
+}
+
+func (s *encryptedStore) Events(ctx context.Context, room string, after uint64, limit int) ([]storage.Event, error) {
+	events, err := s.Store.Events(ctx, room, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if events[i].Content, err = s.keys.Decrypt(ctx, events[i].Room, events[i].Content); err != nil {
+			return nil, err
+		}
+		if err := s.eventQuote(ctx, &events[i], s.keys.Decrypt); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (s *encryptedStore) SaveReviewItem(ctx context.Context, item storage.ReviewItem) error {
+	var err error
+	if item.Content, err = s.keys.Encrypt(ctx, item.Room, item.Content); err != nil {
+		return err
+	}
+	return s.Store.SaveReviewItem(ctx, item)
+}
+
+func (s *encryptedStore) GetReviewItem(ctx context.Context, id string) (storage.ReviewItem, error) {
+	item, err := s.Store.GetReviewItem(ctx, id)
+	if err != nil {
+		return item, err
+	}
+	item.Content, err = s.keys.Decrypt(ctx, item.Room, item.Content)
+	return item, err
+}
+
+func (s *encryptedStore) ReviewItems(ctx context.Context, limit int) ([]storage.ReviewItem, error) {
+	items, err := s.Store.ReviewItems(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		if items[i].Content, err = s.keys.Decrypt(ctx, items[i].Room, items[i].Content); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
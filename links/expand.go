@@ -0,0 +1,144 @@
+package links
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+/*
+Shortener Expansion Overview:
+----------------------------
+A short link hides where it goes, so a deny list alone can't catch
+bit.ly/xyz pointing at a denied domain. With expansion turned on for
+a room, links on a known shortener are resolved by asking the
+shortener for its redirect (a HEAD request, following at most
+maxHops redirects) without fetching the destination itself.
+
+Only hosts in the shorteners list are ever contacted, so a message
+can't make the server request arbitrary URLs. Results, including
+failures, are cached for cacheTTL. A link that can't be expanded is
+judged by the shortener's host alone.
+*/
+
+// Limits on resolving one short link
+const (
+	maxHops       = 3
+	expandTimeout = 3 * time.Second
+	cacheTTL      = time.Hour
+	maxCached     = 10000
+)
+
+// shorteners are the URL shortening services Expand will contact
+var shorteners = map[string]bool{
+	"bit.ly":      true,
+	"bitly.com":   true,
+	"buff.ly":     true,
+	"cutt.ly":     true,
+	"goo.gl":      true,
+	"is.gd":       true,
+	"ow.ly":       true,
+	"rebrand.ly":  true,
+	"shorturl.at": true,
+	"t.co":        true,
+	"t.ly":        true,
+	"tiny.cc":     true,
+	"tinyurl.com": true,
+}
+
+// IsShortener reports whether host is a known URL shortener
+func IsShortener(host string) bool {
+	return shorteners[host]
+}
+
+// expansion is a cached result
+type expansion struct {
+	dest    string // Empty when it couldn't be expanded
+	expires time.Time
+}
+
+// Expander resolves short links; safe for concurrent use
+type Expander struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]expansion
+}
+
+// NewExpander returns an Expander with its own HTTP client
+func NewExpander() *Expander {
+	return &Expander{
+		client: &http.Client{
+			Timeout: expandTimeout,
+			// Each hop is inspected here rather than followed blindly
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cache: make(map[string]expansion),
+	}
+}
+
+// Expand returns where a short link leads
+// It reports false if raw isn't on a known shortener or can't be resolved
+func (e *Expander) Expand(raw string) (string, bool) {
+	if !IsShortener(hostOf(raw)) {
+		return "", false
+	}
+	now := time.Now()
+	e.mu.Lock()
+	cached, ok := e.cache[raw]
+	e.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.dest, cached.dest != ""
+	}
+
+	dest := e.resolve(raw)
+	e.mu.Lock()
+	if len(e.cache) >= maxCached {
+		clear(e.cache) // Crude, but bounded; hot links are quickly re-resolved
+	}
+	e.cache[raw] = expansion{dest: dest, expires: now.Add(cacheTTL)}
+	e.mu.Unlock()
+	return dest, dest != ""
+}
+
+// resolve follows redirects while they stay on shorteners
+// It returns the first URL off a shortener, or "" on failure
+func (e *Expander) resolve(raw string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), expandTimeout)
+	defer cancel()
+
+	current := raw
+	if hostOf(current) != "" && !hasScheme(current) {
+		current = "https://" + current
+	}
+	for hop := 0; hop < maxHops; hop++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, current, nil)
+		if err != nil {
+			return ""
+		}
+		resp, err := e.client.Do(req)
+		if err != nil {
+			return ""
+		}
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			return "" // Not a redirect: nothing to learn
+		}
+		current = location.String()
+		if !IsShortener(hostOf(current)) {
+			return current
+		}
+	}
+	return ""
+}
+
+// hasScheme reports whether raw starts with a URL scheme
+func hasScheme(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != ""
+}
@@ -0,0 +1,158 @@
+package links
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"chat-app/storage"
+)
+
+/*
+Links Overview:
+--------------
+Finds the links in a chat message and judges them against a room's
+storage.LinkPolicy:
+
+1. Extract: anything starting with http://, https:// or www.
+2. Judge: a link violates the policy if its host is on the deny list,
+   or an allow list is set and the host isn't on it. A domain covers
+   its subdomains, so "example.com" matches "cdn.example.com"
+3. Act: under "block" the message is rejected; under "defang" each
+   offending link is rewritten so chat clients won't linkify it
+
+Shortened links are judged by the shortener's own host unless an
+Expander is given, in which case they are judged by where they lead
+(see expand.go).
+*/
+
+// linkPattern matches URLs with a scheme and bare www. links
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// trailingPunct is stripped from matches; it usually ends the sentence, not the URL
+const trailingPunct = ".,;:!?)]}'\""
+
+// Link is one link found in a message
+type Link struct {
+	Raw   string // As written
+	Host  string // Lower-cased host, without port
+	start int    // Byte offsets in the message
+	end   int
+}
+
+// Find returns the links in text, in order
+func Find(text string) []Link {
+	var found []Link
+	for _, loc := range linkPattern.FindAllStringIndex(text, -1) {
+		raw := strings.TrimRight(text[loc[0]:loc[1]], trailingPunct)
+		host := hostOf(raw)
+		if host == "" {
+			continue
+		}
+		found = append(found, Link{Raw: raw, Host: host, start: loc[0], end: loc[0] + len(raw)})
+	}
+	return found
+}
+
+// hostOf parses the host out of a raw link
+func hostOf(raw string) string {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// matches reports whether host is domain or one of its subdomains
+func matches(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// matchesAny reports whether host falls under any of domains
+func matchesAny(host string, domains []string) bool {
+	for _, domain := range domains {
+		if matches(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether policy permits links to host
+func Allowed(policy storage.LinkPolicy, host string) bool {
+	if matchesAny(host, policy.Deny) {
+		return false
+	}
+	return len(policy.Allow) == 0 || matchesAny(host, policy.Allow)
+}
+
+// Defang rewrites a link so it is readable but not clickable
+// e.g. https://evil.example/x becomes hxxps://evil[.]example/x
+func Defang(raw string) string {
+	host := hostOf(raw)
+	if strings.HasPrefix(strings.ToLower(raw), "http") {
+		raw = "hxxp" + raw[len("http"):]
+	}
+	if i := strings.Index(strings.ToLower(raw), host); host != "" && i >= 0 {
+		raw = raw[:i] + strings.ReplaceAll(raw[i:i+len(host)], ".", "[.]") + raw[i+len(host):]
+	}
+	return raw
+}
+
+// Violation is a link that broke a room's policy
+type Violation struct {
+	Link        string // As written
+	Host        string // The host that was judged
+	Destination string // Where a shortened link leads, if it was expanded
+}
+
+// Result is the outcome of applying a policy to a message
+type Result struct {
+	Content    string      // The message, with offending links defanged if allowed through
+	Blocked    bool        // The message must not be sent
+	Violations []Violation // Every offending link
+}
+
+// Apply judges every link in text against policy
+// expand may be nil; otherwise it resolves shortened links
+func Apply(policy storage.LinkPolicy, text string, expand *Expander) Result {
+	result := Result{Content: text}
+	if !policy.Enabled() {
+		return result
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, link := range Find(text) {
+		v := Violation{Link: link.Raw, Host: link.Host}
+		allowed := Allowed(policy, link.Host)
+		if expand != nil && policy.ExpandShorteners && IsShortener(link.Host) {
+			// Judge a short link by its destination; a shortener that is
+			// itself denied stays denied
+			if dest, ok := expand.Expand(link.Raw); ok {
+				v.Destination = dest
+				allowed = allowed && Allowed(policy, hostOf(dest))
+			}
+		}
+		if allowed {
+			continue
+		}
+		result.Violations = append(result.Violations, v)
+		b.WriteString(text[last:link.start])
+		b.WriteString(Defang(link.Raw))
+		last = link.end
+	}
+	if len(result.Violations) == 0 {
+		return result
+	}
+	if policy.Action == storage.LinkDefang {
+		b.WriteString(text[last:])
+		result.Content = b.String()
+		return result
+	}
+	result.Blocked = true
+	return result
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chat-app/client"
+)
+
+/*
+Load Test Overview:
+------------------
+`chat-app loadtest` spins up synthetic clients against a running
+server and reports how well it keeps up:
+
+	chat-app loadtest --target ws://localhost:8080 --clients 5000 --rooms 50 --rate 10
+
+Each client joins one of the rooms and sends --rate messages per
+second stamped with the send time. Every client measures the
+delivery latency of the stamped messages it receives, and the
+report compares deliveries against what the room sizes predict
+to count drops. Clients also check the room sequence numbers on
+everything they receive, so any reordering under concurrent
+publishers shows up in the report.
+*/
+
+// loadTestPrefix marks messages generated by the load tester
+const loadTestPrefix = "lt|"
+
+// samplesPerClient caps latency samples kept per client (reservoir sampled)
+const samplesPerClient = 1000
+
+type loadTestOptions struct {
+	target      string
+	clients     int
+	rooms       int
+	rate        float64
+	size        int
+	duration    time.Duration
+	drain       time.Duration
+	dialWorkers int
+}
+
+// loadClient tracks one synthetic connection
+type loadClient struct {
+	conn     *client.Conn
+	room     int
+	received int64
+	seen     int64 // stamped messages observed, for reservoir sampling
+	samples  []time.Duration
+	max      time.Duration
+	lastSeq  uint64
+	reorders int64 // Messages whose Seq was not above the previous one
+	gaps     int64 // Sequence numbers skipped
+}
+
+// loadReport summarizes a load test run
+type loadReport struct {
+	connected  int
+	failed     int
+	sent       int64
+	expected   int64
+	received   int64
+	elapsed    time.Duration
+	latencies  []time.Duration
+	maxLatency time.Duration
+	reorders   int64
+	gaps       int64
+}
+
+func runLoadTest(args []string) {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	opts := loadTestOptions{}
+	fs.StringVar(&opts.target, "target", "ws://localhost:8080", "server base URL")
+	fs.IntVar(&opts.clients, "clients", 100, "number of synthetic clients")
+	fs.IntVar(&opts.rooms, "rooms", 10, "number of rooms to spread clients across")
+	fs.Float64Var(&opts.rate, "rate", 1, "messages per second sent by each client")
+	fs.IntVar(&opts.size, "size", 64, "approximate message size in bytes")
+	fs.DurationVar(&opts.duration, "duration", 30*time.Second, "how long to send messages")
+	fs.DurationVar(&opts.drain, "drain", 3*time.Second, "time to wait for in-flight messages after sending stops")
+	fs.IntVar(&opts.dialWorkers, "dial-concurrency", 100, "parallel connection attempts during ramp-up")
+	fs.Parse(args)
+
+	if opts.clients <= 0 || opts.rooms <= 0 {
+		log.Fatal("loadtest: --clients and --rooms must be positive")
+	}
+
+	report := loadTest(context.Background(), opts)
+	report.print(os.Stdout, opts)
+}
+
+func loadTest(ctx context.Context, opts loadTestOptions) loadReport {
+	var report loadReport
+
+	// Step 1: Connect all clients, a bounded number at a time
+	log.Printf("Connecting %d clients across %d rooms...", opts.clients, opts.rooms)
+	clients := make([]*loadClient, opts.clients)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.dialWorkers)
+	for i := range clients {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			room := i % opts.rooms
+			conn, err := client.Dial(ctx, opts.target,
+				fmt.Sprintf("loadtest-%d", room), fmt.Sprintf("lt-user-%d", i))
+			if err != nil {
+				return
+			}
+			clients[i] = &loadClient{conn: conn, room: room}
+		}(i)
+	}
+	wg.Wait()
+
+	roomSizes := make([]int64, opts.rooms)
+	var live []*loadClient
+	for _, lc := range clients {
+		if lc == nil {
+			report.failed++
+			continue
+		}
+		live = append(live, lc)
+		roomSizes[lc.room]++
+	}
+	report.connected = len(live)
+	log.Printf("Connected %d clients (%d failed)", report.connected, report.failed)
+
+	// Step 2: Start receivers
+	var recvWG sync.WaitGroup
+	for _, lc := range live {
+		recvWG.Add(1)
+		go func(lc *loadClient) {
+			defer recvWG.Done()
+			lc.receive()
+		}(lc)
+	}
+
+	// Step 3: Send at the requested rate until the duration elapses
+	sentPerRoom := make([]int64, opts.rooms)
+	padding := strings.Repeat("x", max(0, opts.size-len(loadTestPrefix)-20))
+	start := time.Now()
+	if opts.rate > 0 {
+		sendCtx, cancel := context.WithTimeout(ctx, opts.duration)
+		var sendWG sync.WaitGroup
+		interval := time.Duration(float64(time.Second) / opts.rate)
+		for _, lc := range live {
+			sendWG.Add(1)
+			go func(lc *loadClient) {
+				defer sendWG.Done()
+				// Spread first sends so clients don't fire in lockstep
+				time.Sleep(time.Duration(rand.Int63n(int64(interval))))
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-sendCtx.Done():
+						return
+					case <-ticker.C:
+						stamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+						if err := lc.conn.Send(loadTestPrefix + stamp + "|" + padding); err != nil {
+							return
+						}
+						atomic.AddInt64(&sentPerRoom[lc.room], 1)
+					}
+				}
+			}(lc)
+		}
+		sendWG.Wait()
+		cancel()
+	}
+	report.elapsed = time.Since(start)
+
+	// Step 4: Let in-flight messages land, then disconnect
+	time.Sleep(opts.drain)
+	for _, lc := range live {
+		lc.conn.Close()
+	}
+	recvWG.Wait()
+
+	// Step 5: Aggregate results
+	for room, sent := range sentPerRoom {
+		report.sent += sent
+		// Every member of the room, including the sender, should get each message
+		report.expected += sent * roomSizes[room]
+	}
+	for _, lc := range live {
+		report.received += lc.received
+		report.reorders += lc.reorders
+		report.gaps += lc.gaps
+		report.latencies = append(report.latencies, lc.samples...)
+		if lc.max > report.maxLatency {
+			report.maxLatency = lc.max
+		}
+	}
+	sort.Slice(report.latencies, func(i, j int) bool { return report.latencies[i] < report.latencies[j] })
+
+	return report
+}
+
+// receive reads until the connection closes, recording stamped deliveries
+func (lc *loadClient) receive() {
+	for {
+		msg, err := lc.conn.Receive()
+		if err != nil {
+			return
+		}
+		if !msg.Redelivered {
+			lc.checkOrder(msg.Seq)
+		}
+		if msg.Type != "chat" || !strings.HasPrefix(msg.Content, loadTestPrefix) {
+			continue
+		}
+
+		fields := strings.SplitN(strings.TrimPrefix(msg.Content, loadTestPrefix), "|", 2)
+		nanos, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		latency := time.Since(time.Unix(0, nanos))
+
+		lc.received++
+		lc.seen++
+		if latency > lc.max {
+			lc.max = latency
+		}
+		if len(lc.samples) < samplesPerClient {
+			lc.samples = append(lc.samples, latency)
+		} else if j := rand.Int63n(lc.seen); j < samplesPerClient {
+			lc.samples[j] = latency
+		}
+	}
+}
+
+// checkOrder verifies room sequence numbers only ever increase by one
+func (lc *loadClient) checkOrder(seq uint64) {
+	switch {
+	case seq == 0:
+		return // Control frames are not sequenced
+	case lc.lastSeq == 0:
+		// First message; the room may have history before we joined
+	case seq <= lc.lastSeq:
+		lc.reorders++
+		return
+	case seq > lc.lastSeq+1:
+		lc.gaps += int64(seq - lc.lastSeq - 1)
+	}
+	lc.lastSeq = seq
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func (r loadReport) print(w io.Writer, opts loadTestOptions) {
+	drops := r.expected - r.received
+	dropPct := 0.0
+	if r.expected > 0 {
+		dropPct = float64(drops) / float64(r.expected) * 100
+	}
+
+	fmt.Fprintln(w, "Load test report")
+	fmt.Fprintln(w, "----------------")
+	fmt.Fprintf(w, "Target:        %s\n", opts.target)
+	fmt.Fprintf(w, "Clients:       %d connected, %d failed\n", r.connected, r.failed)
+	fmt.Fprintf(w, "Rooms:         %d\n", opts.rooms)
+	fmt.Fprintf(w, "Duration:      %v\n", r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Sent:          %d (%.1f msg/s)\n", r.sent, float64(r.sent)/r.elapsed.Seconds())
+	fmt.Fprintf(w, "Deliveries:    %d of %d expected (%.1f/s)\n", r.received, r.expected, float64(r.received)/r.elapsed.Seconds())
+	fmt.Fprintf(w, "Dropped:       %d (%.2f%%)\n", drops, dropPct)
+	fmt.Fprintf(w, "Ordering:      %d out of order, %d sequence gaps\n", r.reorders, r.gaps)
+	fmt.Fprintln(w, "Latency:")
+	fmt.Fprintf(w, "  p50:         %v\n", percentile(r.latencies, 0.50))
+	fmt.Fprintf(w, "  p90:         %v\n", percentile(r.latencies, 0.90))
+	fmt.Fprintf(w, "  p99:         %v\n", percentile(r.latencies, 0.99))
+	fmt.Fprintf(w, "  max:         %v\n", r.maxLatency)
+}
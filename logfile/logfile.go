@@ -0,0 +1,276 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Log File Overview:
+-----------------
+Writer appends to a log file and rotates it itself, for hosts with no
+log shipper or logrotate to do it:
+
+- When a write would take the file past MaxSize, or the current
+  Every period has ended (periods start at multiples of Every since
+  the zero time, so 24h rotates at midnight UTC)
+- The file is renamed with the time it was rotated, e.g.
+  chat-2026-03-01T00-00-00.000.log, and a new one started
+- Rotated files beyond MaxBackups, or older than MaxAge, are removed,
+  and the rest gzipped when Compress is set; this happens off the
+  writing path, so logging never waits on it
+
+A file left from a previous run is appended to, unless it belongs to
+a period that has already ended. If rotating fails (a full disk, say)
+the writer keeps appending to the current file rather than lose the
+line, and reports the error on stderr.
+*/
+
+// Layout of the rotation time in rotated file names
+const backupTime = "2006-01-02T15-04-05.000"
+
+// Options controls when a Writer rotates and what it keeps
+type Options struct {
+	MaxSize    int64         // Bytes before rotating; 0 disables size rotation
+	Every      time.Duration // Rotation period; 0 disables time rotation
+	MaxBackups int           // Rotated files kept; 0 keeps all
+	MaxAge     time.Duration // Rotated files older than this are removed; 0 keeps all
+	Compress   bool          // Gzip rotated files
+}
+
+// Writer is an io.WriteCloser appending to a rotating log file
+type Writer struct {
+	path string
+	opts Options
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	period time.Time // Start of the period the file belongs to
+
+	mill chan struct{} // Wakes the goroutine cleaning up rotated files
+	done chan struct{}
+}
+
+// Open opens the log file at path, creating it and its directory if needed
+func Open(path string, opts Options) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, opts: opts, mill: make(chan struct{}, 1), done: make(chan struct{})}
+	if err := w.open(time.Now()); err != nil {
+		return nil, err
+	}
+	go w.runMill()
+	w.mill <- struct{}{} // Apply retention to files left by earlier runs
+	return w, nil
+}
+
+// Write appends p, rotating first if it is due
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	now := time.Now()
+	due := w.opts.MaxSize > 0 && w.size+int64(len(p)) > w.opts.MaxSize
+	if w.opts.Every > 0 && now.Truncate(w.opts.Every).After(w.period) {
+		due = true
+	}
+	if due && w.size == 0 {
+		w.period = now.Truncate(max(w.opts.Every, 1)) // Nothing to rotate yet
+	} else if due {
+		if err := w.rotate(now); err != nil {
+			fmt.Fprintf(os.Stderr, "Log file: rotating %s failed: %v\n", w.path, err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the file; rotated files are left as they are
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	close(w.done)
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open opens or creates the file at w.path, first rotating one left
+// over from an earlier period
+// Caller must hold w.mu, or own w before it is shared
+func (w *Writer) open(now time.Time) error {
+	if info, err := os.Stat(w.path); err == nil && w.opts.Every > 0 && info.Size() > 0 &&
+		info.ModTime().Truncate(w.opts.Every).Before(now.Truncate(w.opts.Every)) {
+		if err := os.Rename(w.path, w.backupName(now)); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	if w.opts.Every > 0 {
+		w.period = now.Truncate(w.opts.Every)
+	}
+	return nil
+}
+
+// rotate renames the current file aside and starts a new one
+// Caller must hold w.mu
+func (w *Writer) rotate(now time.Time) error {
+	// Step 1: Move the full file aside; if that fails, keep using it
+	// Either way, the next try is a period or MaxSize bytes away
+	w.period, w.size = now.Truncate(max(w.opts.Every, 1)), 0
+	if err := os.Rename(w.path, w.backupName(now)); err != nil {
+		return err
+	}
+
+	// Step 2: Start the new file
+	old := w.file
+	if err := w.open(now); err != nil {
+		w.file = old
+		return err
+	}
+	old.Close()
+
+	// Step 3: Let the mill tidy up the rotated files
+	select {
+	case w.mill <- struct{}{}:
+	default: // Already due to run
+	}
+	return nil
+}
+
+// backupName is the name the file is rotated to at t
+func (w *Writer) backupName(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	return strings.TrimSuffix(w.path, ext) + "-" + t.UTC().Format(backupTime) + ext
+}
+
+// runMill removes and compresses rotated files whenever woken
+func (w *Writer) runMill() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.mill:
+			if err := w.tidy(time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Log file: cleaning up rotated logs failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// backup is a rotated file found on disk
+type backup struct {
+	path    string
+	rotated time.Time
+}
+
+// tidy applies MaxBackups, MaxAge and Compress to the rotated files
+func (w *Writer) tidy(now time.Time) error {
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+
+	// Newest first, so what's past MaxBackups is the oldest
+	slices.SortFunc(backups, func(a, b backup) int { return b.rotated.Compare(a.rotated) })
+	var firstErr error
+	for i, b := range backups {
+		expired := w.opts.MaxAge > 0 && now.Sub(b.rotated) > w.opts.MaxAge
+		if (w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups) || expired {
+			if err := os.Remove(b.path); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if w.opts.Compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := compress(b.path); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// backups lists the rotated files next to the log file
+func (w *Writer) backups() ([]backup, error) {
+	dir := filepath.Dir(w.path)
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(filepath.Base(w.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		rotated, err := time.Parse(backupTime, stamp)
+		if err != nil {
+			continue // Not one of ours
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), rotated: rotated})
+	}
+	return backups, nil
+}
+
+// compress gzips path to path.gz and removes path
+func compress(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(path + ".gz") // Keep the uncompressed file instead
+		}
+	}()
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -1,28 +1,656 @@
 package main
 
 import (
+	"chat-app/api"
+	"chat-app/archive"
+	"chat-app/audio"
+	"chat-app/buildinfo"
+	"chat-app/chaos"
+	"chat-app/cluster"
+	"chat-app/config"
+	"chat-app/db"
+	"chat-app/errreport"
+	"chat-app/eventlog"
+	"chat-app/federation"
+	"chat-app/geoip"
+	"chat-app/jwtauth"
+	"chat-app/keyring"
+	"chat-app/logfile"
+	"chat-app/metering"
+	"chat-app/metrics"
+	"chat-app/moderation"
+	"chat-app/notify"
+	"chat-app/permission"
+	"chat-app/quota"
+	"chat-app/recording"
+	"chat-app/sanitize"
+	"chat-app/schedule"
+	"chat-app/secrets"
+	"chat-app/storage"
+	"chat-app/tenant"
+	"chat-app/tracing"
+	"chat-app/uploads"
+	"chat-app/web"
 	"chat-app/websockets"
+	"context"
+	"database/sql"
+	"io"
 	"log"
+	"os"
 
+	sentrygin "github.com/getsentry/sentry-go/gin"
 	"github.com/gin-gonic/gin"
 )
 
-
 func main() {
-	// Initialize router and hub
-	r := gin.Default()
-	hub := websockets.NewHub()
+	// Subcommands run tools instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "loadtest":
+			runLoadTest(os.Args[2:])
+			return
+		case "backup":
+			runBackup(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "connect":
+			runConnect(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		}
+	}
+
+	serve(os.Args[1:])
+}
+
+// serve runs the chat server until it fails
+func serve(args []string) {
+	cfg, err := config.Parse(args)
+	if err != nil {
+		log.Fatal("Configuration error: ", err)
+	}
+	if cfg.LogLevel == "debug" {
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+	}
+
+	// Write logs to a file the server rotates when one is configured
+	if cfg.LogFile.Path != "" {
+		logs, err := logfile.Open(cfg.LogFile.Path, logfile.Options{
+			MaxSize:    int64(cfg.LogFile.MaxSizeMB) << 20,
+			Every:      cfg.LogFile.Rotate,
+			MaxBackups: cfg.LogFile.MaxBackups,
+			MaxAge:     cfg.LogFile.MaxAge,
+			Compress:   cfg.LogFile.Compress,
+		})
+		if err != nil {
+			log.Fatal("Log file setup failed: ", err)
+		}
+		defer logs.Close()
+		var stdout, stderr io.Writer = logs, logs
+		if cfg.LogFile.Stdout {
+			stdout, stderr = io.MultiWriter(os.Stdout, logs), io.MultiWriter(os.Stderr, logs)
+		}
+		log.SetOutput(stderr)
+		gin.DefaultWriter, gin.DefaultErrorWriter = stdout, stderr
+	}
+
+	// Fetch secret settings kept in Vault or AWS Secrets Manager, and
+	// again as they are rotated
+	refresher, live, err := resolveSecrets(context.Background(), &cfg)
+	if err != nil {
+		log.Fatal("Secrets setup failed: ", err)
+	}
+	if cfg.Secrets.Refresh > 0 && refresher.Watching() {
+		go refresher.Run(context.Background(), cfg.Secrets.Refresh)
+	}
+
+	if cfg.Mode != "" {
+		gin.SetMode(cfg.Mode)
+	}
+	build := buildinfo.Get()
+	protocol := websockets.ProtocolVersion
+	if cfg.Presence.Legacy {
+		protocol = websockets.LegacyProtocol
+	}
+	log.Printf("chat-app %s (commit %s, protocol %d)", build.Version, build.Commit, protocol)
+
+	// Tracing is a no-op unless an OTLP endpoint is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		log.Fatal("Tracing setup failed:", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Error reporting is a no-op unless a Sentry DSN is configured
+	flushErrors, err := errreport.Setup(cfg)
+	if err != nil {
+		log.Fatal("Error reporting setup failed:", err)
+	}
+	defer flushErrors()
+
+	metrics.SetMaxRoomLabels(cfg.Metrics.MaxRoomLabels)
+
+	// Push metrics to a StatsD or Datadog agent too when one is configured
+	if cfg.Metrics.StatsD.Addr != "" {
+		pusher, err := metrics.NewPusher(cfg.Metrics.StatsD)
+		if err != nil {
+			log.Fatal("StatsD setup failed: ", err)
+		}
+		go pusher.Run(context.Background())
+	}
+
+	// Bring the database schema up to date before anything uses it
+	var database *sql.DB
+	if cfg.Database.URL != "" {
+		database, err = db.Open(context.Background(), cfg.Database.URL)
+		if err != nil {
+			log.Fatal("Database setup failed:", err)
+		}
+		if cfg.Database.MigrateOnStart {
+			if err := migrateUp(context.Background(), database); err != nil {
+				log.Fatal("Database migration failed:", err)
+			}
+		}
+	}
+
+	// When replacing an older process, take its sockets and let it drain
+	// before touching anything it still owns (hub state file, gossip port)
+	inherited, err := inheritedListeners()
+	if err != nil {
+		log.Fatal("Upgrade failed:", err)
+	}
+	if inherited != nil {
+		if err := awaitHandoff(inherited); err != nil {
+			log.Fatal("Upgrade failed:", err)
+		}
+		log.Println("Upgrade: took over from the old process")
+	}
+
+	// Initialize routers and hub
+	// The admin surface gets its own router when it has its own listeners
+	requestLog := cfg.LogLevel != "warn"
+	r := newRouter(requestLog)
+	admin := r
+	if len(cfg.AdminAddrs) > 0 {
+		admin = newRouter(requestLog)
+	}
+	var store storage.Store = storage.NewMemory()
+
+	// Keep everything stored in PostgreSQL when there is a database, so it
+	// outlives the process and every node shares it; the store closes it
+	if database != nil {
+		store = storage.NewPostgres(database)
+	}
+	defer store.Close()
+
+	// Encrypt stored message content when a master key is configured
+	var keys *keyring.Keyring
+	if cfg.Encryption.Key != "" || cfg.Encryption.VaultKey != "" {
+		var master keyring.Wrapper
+		if cfg.Encryption.VaultKey != "" {
+			master = keyring.NewVault(cfg.Vault.Addr, cfg.Vault.Token, cfg.Encryption.VaultKey)
+		} else if master, err = keyring.NewMasterKey(cfg.Encryption.Key); err != nil {
+			log.Fatal("Encryption setup failed: CHAT_ENCRYPTION_KEY: ", err)
+		}
+		var old []keyring.Wrapper
+		for _, encoded := range cfg.Encryption.OldKeys {
+			w, err := keyring.NewMasterKey(encoded)
+			if err != nil {
+				log.Fatal("Encryption setup failed: CHAT_ENCRYPTION_OLD_KEYS: ", err)
+			}
+			old = append(old, w)
+		}
+		keys = keyring.New(store, master, old...)
+		store = keys.Store(store)
+	}
+
+	// Inject faults for resilience testing when any are configured
+	faults := chaos.New(chaos.Faults{
+		Latency:    cfg.Chaos.Latency,
+		DropRate:   cfg.Chaos.DropRate,
+		StoreDelay: cfg.Chaos.StoreDelay,
+		Disconnect: cfg.Chaos.Disconnect,
+	})
+	if faults != nil {
+		log.Printf("Chaos: injecting faults %+v, don't use this server for real traffic", faults.Faults())
+	}
+
+	events := eventlog.NewRecorder(faults.Store(store), eventlog.DefaultBuffer)
+	go events.Run(context.Background())
+	hubOpts := []websockets.HubOption{websockets.WithStore(faults.Store(store)), websockets.WithEventLog(events)}
+	if cfg.Storage.StateFile != "" {
+		hubOpts = append(hubOpts, websockets.WithStateFile(cfg.Storage.StateFile, cfg.Storage.StateInterval))
+	}
+	if cfg.Presence.Devices {
+		hubOpts = append(hubOpts, websockets.WithPresenceDevices())
+	}
+	if cfg.Presence.Legacy {
+		hubOpts = append(hubOpts, websockets.WithLegacyPresence())
+	}
+	if cfg.Presence.HeartbeatInterval > 0 {
+		hubOpts = append(hubOpts, websockets.WithHeartbeat(cfg.Presence.HeartbeatInterval, cfg.Presence.HeartbeatTTL))
+	}
+	if cfg.Connect.MaxAge > 0 {
+		hubOpts = append(hubOpts, websockets.WithMaxConnectionAge(cfg.Connect.MaxAge, cfg.Connect.MaxAgeSpread))
+	}
+	if cfg.Presence.IdleTimeout > 0 {
+		hubOpts = append(hubOpts, websockets.WithIdleTimeout(cfg.Presence.IdleTimeout, cfg.Presence.IdleAction))
+	}
+	if cfg.Broadcast.Rate > 0 {
+		hubOpts = append(hubOpts, websockets.WithThroughputGuard(cfg.Broadcast.Rate))
+	}
+	if len(cfg.Transfers.STUNServers) > 0 {
+		hubOpts = append(hubOpts, websockets.WithICEServers(cfg.Transfers.STUNServers))
+	}
+	anomalies := websockets.NewAnomalyDetector(websockets.AnomalyConfig{
+		Window:   cfg.Anomaly.Window,
+		Connects: cfg.Anomaly.Connects,
+		Rooms:    cfg.Anomaly.Rooms,
+		Errors:   cfg.Anomaly.Errors,
+		Cooldown: cfg.Anomaly.Cooldown,
+		Reauth:   cfg.Anomaly.Reauth,
+	})
+	if anomalies != nil {
+		hubOpts = append(hubOpts, websockets.WithAnomalyDetector(anomalies))
+	}
+
+	if len(cfg.Moderation.Moderators) > 0 {
+		hubOpts = append(hubOpts, websockets.WithModerators(cfg.Moderation.Moderators...))
+	}
+	if cfg.RecallWindow > 0 {
+		hubOpts = append(hubOpts, websockets.WithRecallWindow(cfg.RecallWindow))
+	}
+	hubOpts = append(hubOpts, websockets.WithDuplicateLogins(cfg.DuplicateLogin))
+
+	// Score messages for toxicity when a moderation API is configured
+	var moderator *moderation.Moderator
+	if cfg.Moderation.Provider != "" {
+		scorer, err := moderation.NewScorer(cfg.Moderation.Provider, cfg.Moderation.APIKey, cfg.Moderation.Endpoint)
+		if err != nil {
+			log.Fatal("Moderation setup failed: ", err)
+		}
+		moderator = moderation.New(scorer, moderation.Thresholds{
+			Flag:   cfg.Moderation.Flag,
+			Hide:   cfg.Moderation.Hide,
+			Delete: cfg.Moderation.Delete,
+		}, cfg.Moderation.Workers, cfg.Moderation.Queue)
+		hubOpts = append(hubOpts, websockets.WithModeration(moderator))
+	}
+
+	// Notify members away from a room when a gateway is configured
+	var notifier *notify.Dispatcher
+	if cfg.Notify.Webhook != "" {
+		notifier = notify.New(store, &notify.Webhook{URL: cfg.Notify.Webhook}, cfg.Notify.Workers, cfg.Notify.Queue)
+		hubOpts = append(hubOpts, websockets.WithNotifier(notifier))
+	}
+
+	// Discover other nodes when clustering is configured
+	// The hub needs the cluster to shard rooms, so its load is wired in after
+	var node *cluster.Cluster
+	if cfg.Cluster.BindAddr != "" {
+		node, err = cluster.Start(cfg.Cluster, cfg.Cluster.HTTPAddr, nil)
+		if err != nil {
+			log.Fatal("Cluster setup failed:", err)
+		}
+		defer node.Leave()
+
+		// Peers reach each other's rooms over HTTP, so sharding needs an address
+		if cfg.Cluster.HTTPAddr != "" {
+			hubOpts = append(hubOpts, websockets.WithPeers(node))
+			r.GET(cluster.LinkPath, node.LinkHandler())
+		} else {
+			log.Println("Cluster: CHAT_CLUSTER_HTTP_ADDR is not set, rooms won't be shared with other nodes")
+		}
+	}
+
+	// Share rooms with other servers when federation is configured
+	if cfg.Federation.Name != "" {
+		peers, err := federation.ParsePeers(cfg.Federation.Peers, cfg.Federation.Secrets)
+		if err != nil {
+			log.Fatal("Federation setup failed: ", err)
+		}
+		fed := federation.New(cfg.Federation.Name, peers)
+		go fed.Run(context.Background())
+		hubOpts = append(hubOpts, websockets.WithFederation(fed, sanitize.Sanitizer{HTML: cfg.Content.HTML}))
+		r.POST(federation.FramesPath, fed.Handler())
+	}
+
+	// Count usage per room and user for accounting exports
+	var meter *metering.Meter
+	if cfg.Metering.Enabled {
+		meter = metering.New(store, cfg.Metering.Retention)
+		go meter.Run(context.Background(), cfg.Metering.Interval)
+		hubOpts = append(hubOpts, websockets.WithMeter(meter))
+	}
+
+	// Record each WebSocket session in its own access log for audits
+	if cfg.AccessLog != "" {
+		var out io.Writer = os.Stdout
+		if cfg.AccessLog != "-" {
+			file, err := logfile.Open(cfg.AccessLog, logfile.Options{
+				MaxSize:    int64(cfg.LogFile.MaxSizeMB) << 20,
+				Every:      cfg.LogFile.Rotate,
+				MaxBackups: cfg.LogFile.MaxBackups,
+				MaxAge:     cfg.LogFile.MaxAge,
+				Compress:   cfg.LogFile.Compress,
+			})
+			if err != nil {
+				log.Fatal("Access log setup failed: ", err)
+			}
+			defer file.Close()
+			out = file
+		}
+		access := websockets.NewAccessLog(out)
+		go access.Run(context.Background())
+		hubOpts = append(hubOpts, websockets.WithAccessLog(access))
+	}
+
+	hub := websockets.NewHub(hubOpts...)
 	go hub.Run()
+	if moderator != nil {
+		go moderator.Run(context.Background(), hub.ApplyVerdict)
+	}
+	if notifier != nil {
+		go notifier.Run(context.Background())
+	}
+	if node != nil {
+		node.SetStats(hub.Counts)
+	}
+
+	// Archive expired history to S3 when a bucket is configured
+	var archives *archive.Archiver
+	var prunerOpts []storage.PrunerOption
+	if cfg.Archive.Bucket != "" {
+		objects, err := archive.NewS3(context.Background(), cfg.Archive, secrets.S3Credentials(live.s3AccessKey, live.s3SecretKey))
+		if err != nil {
+			log.Fatal("Archive setup failed:", err)
+		}
+		archives = archive.New(objects)
+		prunerOpts = append(prunerOpts, storage.WithArchiver(archives))
+	}
+
+	// Enforce per-room history retention in the background
+	go storage.NewPruner(store, cfg.Storage.PruneInterval, prunerOpts...).Run(context.Background())
+
+	// Strip or escape HTML and drop invisible characters from message content
+	clean := sanitize.Sanitizer{HTML: cfg.Content.HTML}
+
+	// Expand :shortcodes: to emoji, with each room's custom ones (see room settings)
+	emojis := websockets.NewEmojiExpander(store)
+
+	// Post scheduled announcements as they come due, expanded and cleaned like chat
+	go schedule.NewScheduler(store, func(room, from, content string) {
+		hub.Announce(room, from, clean.Clean(emojis.Expand(room, content)))
+	}).Run(context.Background())
+
+	// Pace reconnect storms so they can't swamp the hub
+	wsOpts := []websockets.Option{websockets.WithConnectThrottle(websockets.NewConnectThrottle(websockets.ThrottleConfig{
+		Rate:      cfg.Connect.Rate,
+		Burst:     cfg.Connect.Burst,
+		Queue:     cfg.Connect.Queue,
+		QueueWait: cfg.Connect.QueueWait,
+		IPRate:    cfg.Connect.IPRate,
+		IPBurst:   cfg.Connect.IPBurst,
+	}))}
+
+	wsOpts = append(wsOpts, websockets.WithSanitizer(clean), websockets.WithEmoji(emojis))
+	if cfg.Presence.RTTReports {
+		wsOpts = append(wsOpts, websockets.WithRTTReports())
+	}
+	if faults != nil {
+		wsOpts = append(wsOpts, websockets.WithChaos(faults))
+	}
+
+	// Record connections frame by frame for `chat-app replay` when asked to
+	if recorder := recording.New(cfg.Record.Dir, cfg.Record.Users, recording.RedactFields(cfg.Record.Redact...)); recorder != nil {
+		log.Printf("Recording: writing connections to %s", cfg.Record.Dir)
+		wsOpts = append(wsOpts, websockets.WithRecorder(recorder))
+	}
+
+	// Rooms can restrict the links posted in them (see room settings)
+	wsOpts = append(wsOpts, websockets.WithLinkFilter(websockets.NewLinkFilter(store, events)))
+
+	// Rooms can gate joins by account age and rate (see room settings)
+	joins := websockets.NewJoinGate(store, nil)
+	wsOpts = append(wsOpts, websockets.WithJoinGate(joins))
+
+	// Tag connections with their location when a GeoIP database is configured
+	if cfg.GeoIP.Database != "" {
+		resolver, err := geoip.Open(cfg.GeoIP.Database)
+		if err != nil {
+			log.Fatal("GeoIP setup failed: ", err)
+		}
+		defer resolver.Close()
+		limits, err := geoip.ParseLimits(cfg.GeoIP.ConnRate)
+		if err != nil {
+			log.Fatal("GeoIP setup failed: ", err)
+		}
+		wsOpts = append(wsOpts, websockets.WithGeoIP(resolver), websockets.WithConnectionLimits(limits))
+	} else if cfg.GeoIP.ConnRate != "" {
+		log.Println("GeoIP: CHAT_GEOIP_CONN_RATE is ignored without CHAT_GEOIP_DB")
+	}
+
+	// Hold users to daily message and upload quotas when any are set
+	quotas := quota.New(store, storage.Usage{
+		Messages: cfg.Quota.Messages,
+		Uploads:  cfg.Quota.Uploads,
+		Bytes:    cfg.Quota.Bytes,
+	})
+	if quotas.Enabled() {
+		go quotas.Run(context.Background(), cfg.Storage.PruneInterval)
+		wsOpts = append(wsOpts, websockets.WithQuotas(quotas))
+	}
+
+	// Pins, uploads, @everyone and slash commands are granted by role, room by room
+	perms := permission.New(store, cfg.Moderation.Moderators...)
+	wsOpts = append(wsOpts, websockets.WithPermissions(perms))
+
+	// Hosted deployments make every client present its tenant's API key
+	var tenants *tenant.Registry
+	if cfg.Tenants.Enabled {
+		tenants = tenant.New(store, cfg.Tenants.CacheTTL)
+		wsOpts = append(wsOpts, websockets.WithTenants(tenants))
+		if cfg.AdminToken == "" {
+			log.Println("Tenants: CHAT_ADMIN_TOKEN is not set, so no API keys can be issued")
+		}
+	}
+
+	// Let clients upload attachments straight to S3 when a bucket is configured
+	var attachments *uploads.Service
+	if cfg.Uploads.Bucket != "" {
+		conn := cfg.Archive
+		conn.Bucket = cfg.Uploads.Bucket
+		objects, err := uploads.NewS3(context.Background(), conn, secrets.S3Credentials(live.s3AccessKey, live.s3SecretKey))
+		if err != nil {
+			log.Fatal("Uploads setup failed: ", err)
+		}
+		attachments = uploads.New(objects, store, uploads.Options{
+			MaxBytes: cfg.Uploads.MaxBytes,
+			Types:    cfg.Uploads.Types,
+			URLTTL:   cfg.Uploads.URLTTL,
+		})
+		go attachments.Run(context.Background(), cfg.Storage.PruneInterval)
+		wsOpts = append(wsOpts, websockets.WithUploads(attachments))
+	}
+
+	// Users prove who they are with access tokens when a key is configured
+	var restAuth websockets.AuthFunc
+	if cfg.JWT.Enabled() {
+		jwtCfg := jwtauth.Config{
+			Secret:        live.jwtSecret.Get,
+			Issuer:        cfg.JWT.Issuer,
+			Audience:      cfg.JWT.Audience,
+			UsernameClaim: cfg.JWT.UsernameClaim,
+		}
+		if cfg.JWT.PublicKey != "" {
+			key, err := jwtauth.LoadPublicKey(cfg.JWT.PublicKey)
+			if err != nil {
+				log.Fatal("JWT setup failed: ", err)
+			}
+			jwtCfg.PublicKey = key
+		}
+		validator, err := jwtauth.New(jwtCfg)
+		if err != nil {
+			log.Fatal("JWT setup failed: ", err)
+		}
+		wsOpts = append(wsOpts, websockets.WithTokenAuth(validator))
+		restAuth = websockets.TokenAuth(validator)
+	}
 
 	// Set up routes
-	r.GET("/ws/:room", websockets.HandleWebSocket(hub))
-	r.GET("/health", func(c *gin.Context) {
+	ws := websockets.HandleWebSocket(hub, wsOpts...)
+	r.GET("/ws/:room", ws)
+	r.GET("/ws/:room/:breakout", ws)
+	health := func(c *gin.Context) {
+		// Load balancers stop routing to a draining node
+		if hub.Draining() {
+			c.JSON(503, gin.H{"status": "draining"})
+			return
+		}
 		c.JSON(200, gin.H{"status": "ok"})
+	}
+	r.GET("/health", health)
+	switch {
+	case cfg.Static.Dir != "":
+		// A production frontend, served on the same port as the API
+		web.Serve(r, os.DirFS(cfg.Static.Dir), web.Options{Fallback: cfg.Static.SPA, MaxAge: cfg.Static.MaxAge})
+	case cfg.WebClient:
+		// A browser client for trying the server out, see the web package
+		web.Register(r)
+	}
+	// The public REST API takes the same API keys as the WebSocket
+	var public gin.IRouter = r
+	if tenants != nil {
+		public = r.Group("", api.RequireTenantKey(tenants))
+	}
+	api.RegisterStickers(public, store)
+	api.RegisterAudio(public, api.AudioDeps{
+		Store:       store,
+		Processor:   audio.Processor{MaxDuration: cfg.Audio.MaxDuration, FFmpeg: cfg.Audio.FFmpeg},
+		MaxBytes:    cfg.Audio.MaxBytes,
+		Quotas:      quotas,
+		Permissions: perms,
+		Auth:        restAuth,
 	})
+	if attachments != nil {
+		api.RegisterUploads(public, api.UploadsDeps{Service: attachments, Quotas: quotas, Permissions: perms, Auth: restAuth})
+	}
+	api.RegisterQuotas(public, api.QuotaDeps{Tracker: quotas, Auth: restAuth})
+	api.RegisterSync(public, api.SyncDeps{Hub: hub, Auth: restAuth})
+	api.RegisterNotifications(public, api.NotificationDeps{Store: store, Auth: restAuth})
+	api.RegisterPermissions(public, api.PermissionDeps{Authorizer: perms, Auth: restAuth})
+	api.RegisterInvites(public, api.InviteDeps{Hub: hub, Authorizer: perms, Auth: restAuth})
+	api.RegisterBreakouts(public, api.BreakoutDeps{Hub: hub, Authorizer: perms, Auth: restAuth})
+	api.RegisterAnalytics(public, api.AnalyticsDeps{Meter: meter, Authorizer: perms, Auth: restAuth})
+	api.RegisterTags(public, api.TagDeps{Hub: hub, Store: store, Authorizer: perms, Auth: restAuth})
+	api.RegisterAvatars(public, api.AvatarDeps{Store: store, Hub: hub, Auth: restAuth})
+	api.RegisterHistory(public, api.HistoryDeps{Store: store, Hub: hub, Authorizer: perms, Joins: joins, Auth: restAuth})
+	exports := api.NewExportLinks(cfg.ExportSecret)
+	api.RegisterExports(r, store, exports)
+	api.RegisterVersion(r, hub.Protocol(), enabledFeatures(cfg, node != nil && cfg.Cluster.HTTPAddr != ""))
+	admin.GET("/metrics", metrics.Handler())
+	api.RegisterAdmin(admin, api.AdminDeps{
+		Hub:         hub,
+		Store:       store,
+		Archives:    archives,
+		Cluster:     node,
+		Meter:       meter,
+		Tenants:     tenants,
+		Permissions: perms,
+		Keys:        keys,
+		Exports:     exports,
+		Token:       live.adminToken.Get,
+	})
+	api.RegisterModeration(r, api.ModerationDeps{
+		Hub:         hub,
+		Store:       store,
+		Token:       live.moderatorToken.Get,
+		BanLookback: cfg.Moderation.BanLookback,
+	})
+
+	surfaces := []surface{{name: "public", addrs: cfg.Addrs, handler: r}}
+	if admin != r {
+		admin.GET("/health", health)
+		registerPprof(admin)
+		surfaces = append(surfaces, surface{name: "admin", addrs: cfg.AdminAddrs, handler: admin})
+	}
+
+	// Start server, on sockets from systemd if it passed any
+	activated, err := systemdListeners()
+	if err != nil {
+		log.Fatal("Socket activation failed:", err)
+	}
+	bound, err := bindAll(surfaces, &binder{socketMode: cfg.SocketMode, activated: activated, inherited: inherited})
+	if err != nil {
+		log.Fatal("Server failed:", err)
+	}
 
-	// Start server
-	log.Println("Server starting on :8080")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("Server failed to start:", err)
+	// SIGUSR2 hands the sockets to a new binary (see upgrade.go)
+	upgrades := newUpgrader(bound, hub, cfg.UpgradeSpread)
+	go upgrades.watch()
+
+	if err := serveAll(bound); err != nil {
+		log.Fatal("Server failed:", err)
+	}
+	// Listeners were handed over; finish draining before exiting
+	<-upgrades.done
+	log.Println("Upgrade: handed over, exiting")
+}
+
+// enabledFeatures names the optional subsystems turned on by cfg
+func enabledFeatures(cfg config.Config, sharding bool) []string {
+	features := []string{}
+	add := func(on bool, name string) {
+		if on {
+			features = append(features, name)
+		}
+	}
+	add(cfg.Tracing.Endpoint != "", "tracing")
+	add(cfg.ErrorReporting.DSN != "", "error_reporting")
+	add(cfg.Database.URL != "", "database")
+	add(cfg.Storage.StateFile != "", "hub_state")
+	add(cfg.Cluster.BindAddr != "", "clustering")
+	add(sharding, "sharding")
+	add(cfg.Federation.Name != "", "federation")
+	add(cfg.Archive.Bucket != "", "archive")
+	add(cfg.GeoIP.Database != "", "geoip")
+	add(cfg.Broadcast.Rate > 0, "throughput_guard")
+	add(cfg.Presence.Legacy, "legacy_presence")
+	add(cfg.Presence.HeartbeatInterval > 0, "heartbeat")
+	add(cfg.Moderation.Provider != "", "moderation")
+	add(cfg.Anomaly.Connects > 0 || cfg.Anomaly.Rooms > 0 || cfg.Anomaly.Errors > 0, "anomaly_detection")
+	add(cfg.Moderation.Token != "", "moderation_api")
+	add(cfg.Audio.FFmpeg != "", "audio_transcoding")
+	add(cfg.Uploads.Bucket != "", "uploads")
+	add(cfg.Metering.Enabled, "metering")
+	add(cfg.Tenants.Enabled, "tenants")
+	add(cfg.Notify.Webhook != "", "notifications")
+	add(cfg.Quota.Messages > 0 || cfg.Quota.Uploads > 0 || cfg.Quota.Bytes > 0, "quotas")
+	add(cfg.WebClient && cfg.Static.Dir == "", "web_client")
+	add(cfg.Static.Dir != "", "static_files")
+	add(cfg.AdminToken != "", "admin_api")
+	add(cfg.JWT.Enabled(), "jwt_auth")
+	return features
+}
+
+// newRouter creates a gin engine with the standard middleware
+// requestLog logs every request, as gin.Default does
+func newRouter(requestLog bool) *gin.Engine {
+	r := gin.New()
+	if requestLog {
+		r.Use(gin.Logger())
+	}
+	r.Use(gin.Recovery())
+	if errreport.Enabled() {
+		// Report handler panics, then let gin's recovery send the 500
+		r.Use(sentrygin.New(sentrygin.Options{Repanic: true}))
 	}
-}
\ No newline at end of file
+	return r
+}
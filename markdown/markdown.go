@@ -0,0 +1,269 @@
+package markdown
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Markdown Overview:
+-----------------
+Chat messages may use a small, safe subset of Markdown:
+
+	**bold** or __bold__
+	*italic* or _italic_
+	`code`
+	[label](https://example.com)
+
+Format turns a message into a flat list of segments, each a run of
+text with its styles, so every client renders the same thing without
+parsing Markdown (or HTML) itself:
+
+	"**Hi** see [the docs](https://example.com)"
+	[{"text": "Hi", "bold": true}, {"text": " see "},
+	 {"text": "the docs", "link": "https://example.com"}]
+
+Anything else is plain text. Segments never carry markup, so clients
+should render them as text. Links must be http, https or mailto;
+others (javascript:, data:, links defanged by the room's link policy)
+stay as their literal source. Images become their alt text, and a
+backslash escapes punctuation.
+*/
+
+// Segment is a run of text with one set of styles
+type Segment struct {
+	Text   string `json:"text"`
+	Bold   bool   `json:"bold,omitempty"`
+	Italic bool   `json:"italic,omitempty"`
+	Code   bool   `json:"code,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// safeSchemes are the link schemes clients may follow
+var safeSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// Format parses text into segments, or returns nil when there is nothing
+// to format and clients can show text as it is
+func Format(text string) []Segment {
+	var p parser
+	p.inline(text, Segment{})
+	if len(p.out) == 0 || (len(p.out) == 1 && p.out[0] == Segment{Text: text}) {
+		return nil
+	}
+	return p.out
+}
+
+// parser collects segments, merging neighbours with the same styles
+type parser struct {
+	out []Segment
+}
+
+// emit appends text in style st
+func (p *parser) emit(text string, st Segment) {
+	if text == "" {
+		return
+	}
+	if n := len(p.out); n > 0 {
+		last := &p.out[n-1]
+		if last.Bold == st.Bold && last.Italic == st.Italic && last.Code == st.Code && last.Link == st.Link {
+			last.Text += text
+			return
+		}
+	}
+	st.Text = text
+	p.out = append(p.out, st)
+}
+
+// inline parses s, whose text is in style st
+func (p *parser) inline(s string, st Segment) {
+	var text strings.Builder
+	flush := func() {
+		p.emit(text.String(), st)
+		text.Reset()
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			text.WriteByte(s[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			// Code spans end at the same number of backticks; nothing inside is parsed
+			n := runLength(s[i:], '`')
+			if end := strings.Index(s[i+n:], s[i:i+n]); end > 0 {
+				flush()
+				code := st
+				code.Code = true
+				p.emit(trimCode(s[i+n:i+n+end]), code)
+				i += n + end + n
+				continue
+			}
+			text.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == '!' && strings.HasPrefix(s[i+1:], "["):
+			if label, _, n, ok := linkAt(s[i+1:]); ok {
+				text.WriteString(label) // Images aren't fetched; show the alt text
+				i += 1 + n
+				continue
+			}
+
+		case c == '[' && st.Link == "":
+			if label, target, n, ok := linkAt(s[i:]); ok && safeLink(target) {
+				flush()
+				linked := st
+				linked.Link = target
+				p.inline(label, linked)
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_':
+			n := min(runLength(s[i:], c), 3)
+			if inner, width, ok := emphasisAt(s, i, n, 0); ok {
+				flush()
+				styled := st
+				styled.Bold = st.Bold || n >= 2
+				styled.Italic = st.Italic || n != 2
+				p.inline(inner, styled)
+				i += width
+				continue
+			}
+			text.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+		text.WriteByte(c)
+		i++
+	}
+	flush()
+}
+
+// maxNesting bounds how deep emphasisAt looks into nested emphasis
+const maxNesting = 4
+
+// emphasisAt finds the text emphasised by the n delimiters at s[i], and
+// how many bytes the whole span takes
+func emphasisAt(s string, i, n, depth int) (string, int, bool) {
+	c := s[i]
+	rest := s[i+n:]
+
+	// Openers must be followed by text; _ can't open inside a word (snake_case)
+	if rest == "" || isSpace(rest) {
+		return "", 0, false
+	}
+	if c == '_' && i > 0 && isWordEnd(s[:i]) {
+		return "", 0, false
+	}
+
+	for j := 1; j < len(rest); {
+		if rest[j] != c {
+			j++
+			continue
+		}
+		r := runLength(rest[j:], c)
+
+		// Closers must follow text, and for _ not run into a word
+		closes := !isSpaceBefore(rest[:j]) && (c != '_' || j+r == len(rest) || !isWordStart(rest[j+r:]))
+		if closes && r >= n {
+			end := j + r - n // The closer is the end of the run, as in ***both* bold**
+			return rest[:end], n + end + n, true
+		}
+
+		// Step over emphasis nested inside this one
+		if r != n && depth < maxNesting {
+			if _, width, ok := emphasisAt(rest, j, min(r, 3), depth+1); ok {
+				j += width
+				continue
+			}
+		}
+		j += r
+	}
+	return "", 0, false
+}
+
+// linkAt parses [label](target) at the start of s, returning its length
+func linkAt(s string) (label, target string, n int, ok bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if !strings.HasPrefix(s[i+1:], "(") {
+				return "", "", 0, false
+			}
+			end := strings.IndexByte(s[i+2:], ')')
+			if end < 0 {
+				return "", "", 0, false
+			}
+			target = strings.TrimSpace(s[i+2 : i+2+end])
+			if target == "" || strings.ContainsAny(target, " \t\n") {
+				return "", "", 0, false
+			}
+			return s[1:i], target, i + 3 + end, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// safeLink reports whether clients may turn target into a link
+func safeLink(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || !safeSchemes[strings.ToLower(u.Scheme)] {
+		return false
+	}
+	return u.Scheme == "mailto" || u.Host != ""
+}
+
+// trimCode drops one space of padding on each side, which lets code start or end with a backtick
+func trimCode(code string) string {
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+		return code[1 : len(code)-1]
+	}
+	return code
+}
+
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+func isPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("`*_[]()!#\\~>+-|", c) >= 0
+}
+
+func isSpace(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func isSpaceBefore(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func isWordEnd(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isWordStart(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
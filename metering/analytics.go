@@ -0,0 +1,154 @@
+package metering
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"chat-app/storage"
+)
+
+/*
+Room Analytics Overview:
+-----------------------
+Community managers want to know how engaged a room is, not what it
+costs, so RoomAnalytics reads the same hourly records as usage
+reports and answers for one room over a window:
+
+	RoomAnalytics(ctx, "lobby", from, to, time.UTC)
+	-> {"room": "lobby", "from": "...", "to": "...", "messages": 1200,
+	    "messages_per_hour": 7.14, "unique_chatters": 48,
+	    "peak_connections": 37, "peak_at": "2024-06-10T14:00:00Z",
+	    "busiest_hours": [{"hour": 14, "messages": 310}, ...],
+	    "hours": [{"hour": "...", "messages": 12, "chatters": 5, "peak_connections": 9}, ...]}
+
+hours has every hour of the window, quiet ones included, so it can
+be charted as is. busiest_hours ranks hours of the day, in the
+location asked for, by the messages sent in them across the window.
+A chatter is a user who posted at least once. Peaks are the most
+connections open at once on any one node (in a cluster, a room's
+members spread over nodes are not added up).
+*/
+
+// maxBusiestHours is how many hours of the day are ranked
+const maxBusiestHours = 5
+
+// RoomHour is one hour of a room's activity
+type RoomHour struct {
+	Hour            time.Time `json:"hour"`
+	Messages        int64     `json:"messages"`
+	Chatters        int       `json:"chatters"`
+	PeakConnections int64     `json:"peak_connections"`
+}
+
+// HourOfDay is the messages sent at one hour of the day over a window
+type HourOfDay struct {
+	Hour     int   `json:"hour"` // 0 to 23, in the report's location
+	Messages int64 `json:"messages"`
+}
+
+// RoomAnalytics is a room's engagement during a window
+type RoomAnalytics struct {
+	Room            string      `json:"room"`
+	From            time.Time   `json:"from"`
+	To              time.Time   `json:"to"`
+	Location        string      `json:"location"` // Of busiest_hours
+	Messages        int64       `json:"messages"`
+	MessagesPerHour float64     `json:"messages_per_hour"`
+	UniqueChatters  int         `json:"unique_chatters"`
+	PeakConnections int64       `json:"peak_connections"`
+	PeakAt          *time.Time  `json:"peak_at,omitempty"` // Hour of the first peak; nil if nobody connected
+	BusiestHours    []HourOfDay `json:"busiest_hours"`     // Busiest first, up to maxBusiestHours with messages
+	Hours           []RoomHour  `json:"hours"`             // Oldest first
+}
+
+// RoomAnalytics reports room's activity in [from, to), widened to whole
+// hours, ranking hours of the day in loc. Only the room's records are
+// read; what this node has counted for the room but not yet stored is
+// added to them, and left for the next flush
+func (m *Meter) RoomAnalytics(ctx context.Context, room string, from, to time.Time, loc *time.Location) (RoomAnalytics, error) {
+	from = Hour(from)
+	if to.After(Hour(to)) {
+		to = Hour(to).Add(time.Hour)
+	}
+	stored, err := m.store.RoomMeterRecords(ctx, room, from, to)
+	if err != nil {
+		return RoomAnalytics{}, err
+	}
+	records := mergeRecords(stored, m.pendingRoom(room, from, to, time.Now()))
+
+	// Step 1: One entry per hour of the window
+	a := RoomAnalytics{Room: room, From: from, To: to, Location: loc.String(), BusiestHours: []HourOfDay{}}
+	index := make(map[time.Time]int)
+	for hour := from; hour.Before(to); hour = hour.Add(time.Hour) {
+		index[hour] = len(a.Hours)
+		a.Hours = append(a.Hours, RoomHour{Hour: hour})
+	}
+
+	// Step 2: Add up the room's records
+	chatters := make(map[string]bool)
+	for _, r := range records {
+		i, ok := index[r.Hour.UTC()]
+		if !ok {
+			continue
+		}
+		h := &a.Hours[i]
+		h.PeakConnections = max(h.PeakConnections, r.PeakConnections)
+		if r.Username == "" || r.Messages == 0 {
+			continue
+		}
+		h.Messages += r.Messages
+		h.Chatters++
+		chatters[r.Username] = true
+	}
+
+	// Step 3: Totals, the peak and the busiest hours of the day
+	var byHour [24]int64
+	for i, h := range a.Hours {
+		a.Messages += h.Messages
+		if h.PeakConnections > a.PeakConnections {
+			a.PeakConnections = h.PeakConnections
+			a.PeakAt = &a.Hours[i].Hour
+		}
+		byHour[h.Hour.In(loc).Hour()] += h.Messages
+	}
+	a.UniqueChatters = len(chatters)
+	if len(a.Hours) > 0 {
+		a.MessagesPerHour = float64(a.Messages) / float64(len(a.Hours))
+	}
+	for hour, messages := range byHour {
+		if messages > 0 {
+			a.BusiestHours = append(a.BusiestHours, HourOfDay{Hour: hour, Messages: messages})
+		}
+	}
+	slices.SortStableFunc(a.BusiestHours, func(x, y HourOfDay) int {
+		return cmp.Compare(y.Messages, x.Messages)
+	})
+	if len(a.BusiestHours) > maxBusiestHours {
+		a.BusiestHours = a.BusiestHours[:maxBusiestHours]
+	}
+	return a, nil
+}
+
+// mergeRecords adds pending counts to the stored records of the same
+// room, user and hour, so each appears once
+func mergeRecords(stored, pending []storage.MeterRecord) []storage.MeterRecord {
+	index := make(map[recordKey]int, len(stored))
+	for i, r := range stored {
+		index[recordKey{r.Room, r.Username, Hour(r.Hour)}] = i
+	}
+	for _, r := range pending {
+		i, ok := index[recordKey{r.Room, r.Username, Hour(r.Hour)}]
+		if !ok {
+			stored = append(stored, r)
+			continue
+		}
+		s := &stored[i]
+		s.Messages += r.Messages
+		s.Bytes += r.Bytes
+		s.ConnectionSeconds += r.ConnectionSeconds
+		s.PeakConnections = max(s.PeakConnections, r.PeakConnections)
+	}
+	return stored
+}
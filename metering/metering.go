@@ -0,0 +1,363 @@
+package metering
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Metering Overview:
+-----------------
+Hosted deployments bill or report on what rooms and users consume.
+The hub tells a Meter about every message posted and every connection
+opened and closed, and the Meter counts, per room, user and hour:
+
+	messages            messages posted to the room
+	bytes               their size as sent to the room
+	connection_seconds  time connected to the room
+
+It also keeps the most connections each room had open at once in
+each hour, on a record of the room's own, for room analytics (see
+analytics.go). Counting happens in memory and never blocks the hub. Run adds the
+counts to the store every flush interval (see storage.MeterRecord),
+crediting open connections with the time since the last flush so long
+sessions show up hour by hour, and drops records older than the
+retention. Messages are counted by the node that owns the room and
+connections by the node holding them, so nodes sharing a store add up
+to the whole cluster.
+
+Reports sum the records in a window over any of the dimensions room,
+user and hour:
+
+	Report(ctx, from, to, []string{"room"})
+	-> {"from": "...", "to": "...", "by": ["room"],
+	    "lines": [{"room": "lobby", "messages": 1200, "bytes": 250311,
+	               "connection_minutes": 5400}]}
+
+Hours are the smallest unit, so windows are widened to whole hours.
+*/
+
+// Report dimensions
+const (
+	ByRoom = "room"
+	ByUser = "user"
+	ByHour = "hour"
+)
+
+// Meter counts usage for accounting; safe for concurrent use
+// A nil Meter counts nothing
+type Meter struct {
+	store     storage.Store
+	retention time.Duration
+
+	mu      sync.Mutex
+	pending map[recordKey]*storage.MeterRecord // Counted but not yet stored
+	open    map[string]*openConn               // Connections by ID
+	rooms   map[string]int64                   // Open connections per room
+}
+
+// recordKey identifies a room, user and hour
+type recordKey struct {
+	room     string
+	username string
+	hour     time.Time
+}
+
+// openConn is a connection whose time is still being counted
+type openConn struct {
+	room     string
+	username string
+	since    time.Time // Counted up to here
+}
+
+// New counts usage into store, keeping records for retention
+func New(store storage.Store, retention time.Duration) *Meter {
+	return &Meter{
+		store:     store,
+		retention: retention,
+		pending:   make(map[recordKey]*storage.MeterRecord),
+		open:      make(map[string]*openConn),
+		rooms:     make(map[string]int64),
+	}
+}
+
+// Connected starts counting a connection's time
+func (m *Meter) Connected(id, room, username string, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.open[id] = &openConn{room: room, username: username, since: now}
+	m.rooms[room]++
+	m.peakLocked(room, now)
+}
+
+// Disconnected counts the rest of a connection's time
+func (m *Meter) Disconnected(id string, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if conn, ok := m.open[id]; ok {
+		m.creditLocked(conn, now)
+		delete(m.open, id)
+		if m.rooms[conn.room]--; m.rooms[conn.room] <= 0 {
+			delete(m.rooms, conn.room)
+		}
+	}
+}
+
+// Message counts a message of size bytes posted to room by username
+func (m *Meter) Message(room, username string, size int, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.recordLocked(room, username, now)
+	r.Messages++
+	r.Bytes += int64(size)
+}
+
+// creditLocked counts a connection's time up to now, split at hour
+// boundaries so each hour gets its share
+func (m *Meter) creditLocked(conn *openConn, now time.Time) {
+	for {
+		end := Hour(conn.since).Add(time.Hour)
+		if end.After(now) {
+			end = now
+		}
+		// Whole seconds are counted; the rest carries over in since
+		if secs := int64(end.Sub(conn.since) / time.Second); secs > 0 {
+			m.recordLocked(conn.room, conn.username, conn.since).ConnectionSeconds += secs
+			conn.since = conn.since.Add(time.Duration(secs) * time.Second)
+		}
+		if end.Equal(now) {
+			return
+		}
+		conn.since = end
+	}
+}
+
+// peakLocked raises room's peak for the hour of at to its open connections
+func (m *Meter) peakLocked(room string, at time.Time) {
+	r := m.recordLocked(room, "", at)
+	r.PeakConnections = max(r.PeakConnections, m.rooms[room])
+}
+
+// recordLocked returns the pending record for room, username and the hour of at
+func (m *Meter) recordLocked(room, username string, at time.Time) *storage.MeterRecord {
+	k := recordKey{room, username, Hour(at)}
+	r, ok := m.pending[k]
+	if !ok {
+		r = &storage.MeterRecord{Room: room, Username: username, Hour: k.hour}
+		m.pending[k] = r
+	}
+	return r
+}
+
+// Flush credits open connections up to now and stores everything counted
+// Counts that fail to store are kept for the next flush
+func (m *Meter) Flush(ctx context.Context, now time.Time) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	for _, conn := range m.open {
+		m.creditLocked(conn, now)
+	}
+	// Rooms nobody joins or leaves in an hour still had their connections open
+	for room := range m.rooms {
+		m.peakLocked(room, now)
+	}
+	batch := make([]storage.MeterRecord, 0, len(m.pending))
+	for _, r := range m.pending {
+		batch = append(batch, *r)
+	}
+	m.pending = make(map[recordKey]*storage.MeterRecord)
+	m.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := m.store.AddMeterRecords(ctx, batch); err != nil {
+		m.mu.Lock()
+		for _, r := range batch {
+			pending := m.recordLocked(r.Room, r.Username, r.Hour)
+			pending.Messages += r.Messages
+			pending.Bytes += r.Bytes
+			pending.ConnectionSeconds += r.ConnectionSeconds
+			pending.PeakConnections = max(pending.PeakConnections, r.PeakConnections)
+		}
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// pendingRoom credits open connections up to now and returns copies of
+// what is counted for room in [from, to) but not yet stored
+func (m *Meter) pendingRoom(room string, from, to, now time.Time) []storage.MeterRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, conn := range m.open {
+		if conn.room == room {
+			m.creditLocked(conn, now)
+		}
+	}
+	if _, ok := m.rooms[room]; ok {
+		m.peakLocked(room, now)
+	}
+	var records []storage.MeterRecord
+	for k, r := range m.pending {
+		if k.room == room && !k.hour.Before(from) && k.hour.Before(to) {
+			records = append(records, *r)
+		}
+	}
+	return records
+}
+
+// Run flushes every interval, pruning expired records, until ctx is cancelled
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := m.Flush(ctx, now); err != nil {
+				log.Printf("Metering flush failed: %v", err)
+				errreport.CaptureError(fmt.Errorf("metering flush: %w", err), errreport.Context{})
+			}
+			if m.retention > 0 {
+				if _, err := m.store.DeleteMeterRecordsBefore(ctx, Hour(now.Add(-m.retention))); err != nil {
+					log.Printf("Metering prune failed: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Line is the usage of one group in a report
+type Line struct {
+	Room              string     `json:"room,omitempty"`
+	Username          string     `json:"username,omitempty"`
+	Hour              *time.Time `json:"hour,omitempty"`
+	Messages          int64      `json:"messages"`
+	Bytes             int64      `json:"bytes"`
+	ConnectionMinutes float64    `json:"connection_minutes"`
+}
+
+// Report is usage in a window, summed over the By dimensions
+type Report struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	By    []string  `json:"by"`
+	Lines []Line    `json:"lines"`
+}
+
+// ParseDimensions checks a comma-separated list of report dimensions
+func ParseDimensions(list string) ([]string, error) {
+	var by []string
+	for _, d := range strings.Split(list, ",") {
+		switch d = strings.TrimSpace(d); d {
+		case ByRoom, ByUser, ByHour:
+			if !slices.Contains(by, d) {
+				by = append(by, d)
+			}
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown dimension %q (want room, user or hour)", d)
+		}
+	}
+	return by, nil
+}
+
+// Report sums the usage in [from, to), widened to whole hours, by the
+// given dimensions; with none, it is one line for everything. What this
+// node has counted but not yet stored is flushed first
+func (m *Meter) Report(ctx context.Context, from, to time.Time, by []string) (Report, error) {
+	from = Hour(from)
+	if to.After(Hour(to)) {
+		to = Hour(to).Add(time.Hour)
+	}
+	if err := m.Flush(ctx, time.Now()); err != nil {
+		return Report{}, err
+	}
+	records, err := m.store.MeterRecords(ctx, from, to)
+	if err != nil {
+		return Report{}, err
+	}
+
+	if by == nil {
+		by = []string{}
+	}
+	report := Report{From: from, To: to, By: by, Lines: []Line{}}
+	index := make(map[recordKey]int)
+	seconds := make(map[recordKey]int64)
+	for _, r := range records {
+		// Rooms' own records only hold peaks, which usage doesn't report
+		if r.Messages == 0 && r.Bytes == 0 && r.ConnectionSeconds == 0 {
+			continue
+		}
+		var k recordKey
+		if slices.Contains(by, ByRoom) {
+			k.room = r.Room
+		}
+		if slices.Contains(by, ByUser) {
+			k.username = r.Username
+		}
+		if slices.Contains(by, ByHour) {
+			k.hour = r.Hour
+		}
+		i, ok := index[k]
+		if !ok {
+			line := Line{Room: k.room, Username: k.username}
+			if !k.hour.IsZero() {
+				hour := k.hour
+				line.Hour = &hour
+			}
+			i = len(report.Lines)
+			index[k] = i
+			report.Lines = append(report.Lines, line)
+		}
+		report.Lines[i].Messages += r.Messages
+		report.Lines[i].Bytes += r.Bytes
+		seconds[k] += r.ConnectionSeconds
+	}
+	for k, i := range index {
+		report.Lines[i].ConnectionMinutes = float64(seconds[k]) / 60
+	}
+	slices.SortFunc(report.Lines, compareLines)
+	return report, nil
+}
+
+// compareLines orders lines by hour, room, then user
+func compareLines(a, b Line) int {
+	if a.Hour != nil && b.Hour != nil {
+		if c := a.Hour.Compare(*b.Hour); c != 0 {
+			return c
+		}
+	}
+	if c := strings.Compare(a.Room, b.Room); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Username, b.Username)
+}
+
+// Hour is the start of the UTC hour containing t
+func Hour(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour)
+}
@@ -0,0 +1,329 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+Metrics Overview:
+----------------
+Prometheus metrics for the chat server, served at /metrics.
+
+Room names come from users, so labeling every series by room would
+let anyone create unbounded time series just by joining random rooms.
+RoomLabel hands out at most maxRoomLabels distinct labels; rooms
+beyond that share the "_other" label until a slot frees up.
+*/
+
+// OtherRoom is the shared label for rooms over the cardinality limit
+const OtherRoom = "_other"
+
+var (
+	// RoomMessages counts chat messages accepted per room
+	RoomMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_room_messages_total",
+		Help: "Chat messages broadcast, by room.",
+	}, []string{"room"})
+
+	// RoomDroppedSends counts deliveries dropped because a client buffer was full
+	RoomDroppedSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_room_dropped_sends_total",
+		Help: "Messages dropped due to full client send buffers, by room.",
+	}, []string{"room"})
+
+	// RoomActiveUsers tracks connected clients per room
+	RoomActiveUsers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chat_room_active_users",
+		Help: "Currently connected clients, by room.",
+	}, []string{"room"})
+
+	// FanoutDuration measures hub receipt to enqueue on the last recipient
+	// Labeled by room size class rather than room to keep cardinality fixed
+	FanoutDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_broadcast_fanout_seconds",
+		Help:    "Time from hub receipt to enqueue on the last recipient, by room size.",
+		Buckets: []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	}, []string{"room_size"})
+
+	// ConnectionsOpened counts clients that joined the hub
+	ConnectionsOpened = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_connections_opened_total",
+		Help: "WebSocket connections registered with the hub.",
+	})
+
+	// ConnectionsClosed counts disconnects by reason
+	ConnectionsClosed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_connections_closed_total",
+		Help: "WebSocket connections removed from the hub, by reason.",
+	}, []string{"reason"})
+
+	// ActiveConnections tracks currently registered clients
+	ActiveConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_active_connections",
+		Help: "WebSocket connections currently registered with the hub.",
+	})
+
+	// ConnectionDuration records how long connections stay open
+	ConnectionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_connection_duration_seconds",
+		Help:    "Lifetime of WebSocket connections.",
+		Buckets: []float64{1, 5, 30, 60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600, 24 * 3600},
+	})
+
+	// ClientRTT is the round-trip time of protocol pings, per measurement
+	ClientRTT = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_client_rtt_seconds",
+		Help:    "Round-trip time from ping to pong on WebSocket connections.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	})
+
+	// ActiveRooms tracks the number of rooms with at least one client
+	ActiveRooms = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_active_rooms",
+		Help: "Rooms with at least one connected client.",
+	})
+
+	// EventLogDropped counts room events lost because the recorder fell behind
+	EventLogDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_event_log_dropped_total",
+		Help: "Room events dropped because the event log recorder's buffer was full.",
+	})
+
+	// AccessLogDropped counts session records lost because the access log fell behind
+	AccessLogDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_access_log_dropped_total",
+		Help: "WebSocket session records dropped because the access log's buffer was full.",
+	})
+
+	// ClusterMembers tracks nodes this node currently sees as alive, itself included
+	ClusterMembers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_cluster_members",
+		Help: "Cluster nodes currently seen as alive, including this one.",
+	})
+
+	// ClusterFrames counts room traffic exchanged with other nodes
+	// result is "sent", "received" or "dropped"
+	ClusterFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_cluster_frames_total",
+		Help: "Frames exchanged with other cluster nodes over peer links, by result.",
+	}, []string{"result"})
+
+	// FederationFrames counts room traffic exchanged with federated servers
+	// result is "sent", "received", "dropped" (queue full, undeliverable or
+	// malformed), "refused" (bad signature), "denied" (room not shared with
+	// the sender, or a broadcast room it may not post in), "duplicate" or
+	// "backfilled" (queued to fill a gap)
+	FederationFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_federation_frames_total",
+		Help: "Frames exchanged with federated servers, by result.",
+	}, []string{"result"})
+
+	// DirectMessages counts direct messages handled here
+	// result is "delivered" (to a connection here), "queued" (recipient
+	// offline), "sent" (to another server) or "failed"
+	DirectMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_direct_messages_total",
+		Help: "Direct messages handled, by result.",
+	}, []string{"result"})
+
+	// GeoConnections counts WebSocket upgrade attempts by client country
+	// country is an ISO 3166-1 code, or "unknown"; result is "accepted"
+	// or "rate_limited"
+	GeoConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_geo_connections_total",
+		Help: "Connection attempts by client country (needs CHAT_GEOIP_DB) and result.",
+	}, []string{"country", "result"})
+
+	// ConnectThrottled counts WebSocket upgrades refused by the connection throttle
+	// reason is "ip", "global" or "queue_full"
+	ConnectThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_connect_throttled_total",
+		Help: "Connection attempts refused with 429 by the connection throttle, by reason.",
+	}, []string{"reason"})
+
+	// ConnectQueue tracks upgrade requests waiting for a global connection slot
+	ConnectQueue = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_connect_queue_waiting",
+		Help: "Connection attempts currently queued by the connection throttle.",
+	})
+
+	// BroadcastShed counts room broadcasts dropped by the throughput guard
+	// type is the message type, e.g. "chat" or "online_users"
+	BroadcastShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_broadcast_shed_total",
+		Help: "Room broadcasts dropped under load by the throughput guard, by message type.",
+	}, []string{"type"})
+
+	// Anomalies counts clients flagged by the anomaly detector
+	// signal is "churn", "room_hopping" or "errors"; subject is "ip" or "user";
+	// action is "cooldown" or "reauth"
+	Anomalies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_anomalies_total",
+		Help: "IPs and users flagged for unusual connection behavior, by signal, subject and action.",
+	}, []string{"signal", "subject", "action"})
+
+	// JoinsGated counts joins held up by room join policies
+	// outcome is "age_unconfirmed", "too_new", "rejected" or "queued"
+	JoinsGated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_joins_gated_total",
+		Help: "Joins refused or queued by room join policies, by outcome.",
+	}, []string{"outcome"})
+
+	// QuotaRejections counts messages and uploads refused by daily quotas
+	// counter is "messages", "uploads" or "bytes"
+	QuotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_quota_rejections_total",
+		Help: "Messages and uploads refused because a user's daily quota ran out, by counter.",
+	}, []string{"counter"})
+
+	// TenantConnections tracks each tenant's open WebSocket connections on this node
+	TenantConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chat_tenant_connections",
+		Help: "Open WebSocket connections on this node, by tenant.",
+	}, []string{"tenant"})
+
+	// TenantRooms tracks the rooms each tenant has connections open to on this node
+	TenantRooms = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chat_tenant_rooms",
+		Help: "Rooms with a connection open on this node, by tenant.",
+	}, []string{"tenant"})
+
+	// TenantMessages counts messages posted by each tenant's clients
+	TenantMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_tenant_messages_total",
+		Help: "Messages posted over WebSocket connections, by tenant.",
+	}, []string{"tenant"})
+
+	// TenantRequests counts REST requests made with each tenant's key
+	TenantRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_tenant_requests_total",
+		Help: "REST API requests, by tenant.",
+	}, []string{"tenant"})
+
+	// TenantRejections counts connections and messages refused by tenant limits
+	// limit is "connections", "rooms" or "message_rate"
+	TenantRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_tenant_rejections_total",
+		Help: "Connections and messages refused because a tenant reached a limit, by tenant and limit.",
+	}, []string{"tenant", "limit"})
+
+	// PermissionDenials counts actions refused because no role granted them
+	// capability is "pin", "upload", "mention_everyone", "command", "invite",
+	// "tag" or "post"
+	PermissionDenials = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_permission_denials_total",
+		Help: "Actions refused because none of the user's roles in the room grants the capability, by capability.",
+	}, []string{"capability"})
+
+	// AnnouncementRuns counts scheduled announcement runs
+	// outcome is "sent", "skipped" (missed while the server was down) or "failed"
+	AnnouncementRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_announcement_runs_total",
+		Help: "Scheduled announcement runs, by outcome.",
+	}, []string{"outcome"})
+
+	// LinkViolations counts links that broke a room's link policy
+	// action is "block" or "defang"
+	LinkViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_link_violations_total",
+		Help: "Links in chat messages that broke their room's link policy, by action taken.",
+	}, []string{"action"})
+
+	// ModerationVerdicts counts messages scored by the moderation API
+	// action is "none", "flag", "hide" or "delete"
+	ModerationVerdicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_moderation_verdicts_total",
+		Help: "Messages scored for toxicity, by resulting action.",
+	}, []string{"action"})
+
+	// ModerationFailures counts messages that went unscored
+	// reason is "queue_full" or "error"
+	ModerationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_moderation_failures_total",
+		Help: "Messages left unscored because the queue was full or the API failed.",
+	}, []string{"reason"})
+
+	// ModerationLatency tracks calls to the moderation API
+	ModerationLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_moderation_latency_seconds",
+		Help:    "Time taken by the moderation API to score a message.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5},
+	})
+
+	// Notifications counts push and email notifications considered for
+	// members away from a room
+	// outcome is "sent" (summaries too), "muted" (their preferences said
+	// no), "held" (for a do-not-disturb summary), "failed" or "dropped"
+	// (the queue was full)
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_notifications_total",
+		Help: "Notifications considered for members away from a room, by outcome.",
+	}, []string{"outcome"})
+)
+
+// RoomSizeClass buckets a recipient count into a fixed label value
+func RoomSizeClass(n int) string {
+	switch {
+	case n <= 10:
+		return "0-10"
+	case n <= 100:
+		return "11-100"
+	case n <= 1000:
+		return "101-1000"
+	default:
+		return "1000+"
+	}
+}
+
+// labeler bounds the set of room names used as label values
+var labeler = struct {
+	sync.Mutex
+	max   int
+	rooms map[string]bool
+}{max: 100, rooms: make(map[string]bool)}
+
+// SetMaxRoomLabels changes how many rooms get their own label
+func SetMaxRoomLabels(n int) {
+	labeler.Lock()
+	defer labeler.Unlock()
+	labeler.max = n
+}
+
+// RoomLabel returns the label value to use for room
+func RoomLabel(room string) string {
+	labeler.Lock()
+	defer labeler.Unlock()
+
+	if labeler.rooms[room] {
+		return room
+	}
+	if len(labeler.rooms) >= labeler.max {
+		return OtherRoom
+	}
+	labeler.rooms[room] = true
+	return room
+}
+
+// ForgetRoom drops a closed room's series and frees its label slot
+func ForgetRoom(room string) {
+	labeler.Lock()
+	defer labeler.Unlock()
+
+	if !labeler.rooms[room] {
+		return
+	}
+	delete(labeler.rooms, room)
+	RoomMessages.DeleteLabelValues(room)
+	RoomDroppedSends.DeleteLabelValues(room)
+	RoomActiveUsers.DeleteLabelValues(room)
+}
+
+// Handler serves the Prometheus exposition format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+StatsD Overview:
+---------------
+For monitoring stacks that are push-based, Pusher sends every metric
+served at /metrics to a StatsD or Datadog agent over UDP as well, on
+a fixed interval:
+
+- Counters go as counts of what was added since the last push, and
+  are skipped when nothing was
+- Gauges go as gauges, every push
+- Histograms and summaries go as two counts, NAME.count and NAME.sum,
+  so averages can still be graphed; their buckets aren't pushed
+
+Names lose Prometheus' "chat_" and take the configured prefix, so
+chat_room_messages_total becomes chat.room_messages_total. In the
+datadog format labels and the configured tags go as DogStatsD tags;
+plain StatsD has no tags, so label values are appended to the name
+in label order (chat.room_messages_total.lobby) and the configured
+tags are left out.
+
+UDP is fire and forget: a push that can't be sent is lost, and a
+failing agent is logged when it starts and stops failing.
+*/
+
+// Largest datagram sent, small enough to avoid fragmentation on most links
+const maxPacket = 1432
+
+// Pusher sends the registered metrics to a StatsD or Datadog agent
+type Pusher struct {
+	conn     net.Conn
+	gatherer prometheus.Gatherer
+	datadog  bool
+	prefix   string
+	tags     []string
+	interval time.Duration
+
+	counts  map[string]float64 // Counter values at the last push, by line
+	failing bool               // The last write failed
+}
+
+// NewPusher returns a Pusher for the agent at cfg.Addr
+func NewPusher(cfg config.StatsDConfig) (*Pusher, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, len(cfg.Tags))
+	for i, tag := range cfg.Tags {
+		tags[i] = sanitizeTag(tag)
+	}
+	return &Pusher{
+		conn:     conn,
+		gatherer: prometheus.DefaultGatherer,
+		datadog:  cfg.Format == "datadog",
+		prefix:   cfg.Prefix,
+		tags:     tags,
+		interval: cfg.Interval,
+		counts:   make(map[string]float64),
+	}, nil
+}
+
+// Run pushes metrics every interval until ctx is done
+func (p *Pusher) Run(ctx context.Context) {
+	defer p.conn.Close()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.push()
+		}
+	}
+}
+
+// push sends one round of metrics
+func (p *Pusher) push() {
+	// A failing collector still leaves the others' metrics
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		log.Printf("StatsD: gathering metrics: %v", err)
+	}
+
+	var packet bytes.Buffer
+	counts := make(map[string]float64, len(p.counts))
+	add := func(line string) {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacket {
+			p.send(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	line := func(name string, labels []*dto.LabelPair, value float64, kind string) {
+		name, tags := p.series(name, labels)
+		add(name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind + tags)
+	}
+	count := func(name string, labels []*dto.LabelPair, total float64) {
+		series, tags := p.series(name, labels)
+		key := series + tags
+		counts[key] = total
+		delta := total - p.counts[key]
+		if delta < 0 {
+			delta = total // Reset since the last push
+		}
+		if delta != 0 {
+			line(name, labels, delta, "c")
+		}
+	}
+
+	for _, family := range families {
+		name := p.prefix + sanitizeName(strings.TrimPrefix(family.GetName(), "chat_"))
+		for _, m := range family.GetMetric() {
+			labels := m.GetLabel()
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				count(name, labels, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				line(name, labels, m.GetGauge().GetValue(), "g")
+			case dto.MetricType_UNTYPED:
+				line(name, labels, m.GetUntyped().GetValue(), "g")
+			case dto.MetricType_HISTOGRAM:
+				count(name+".count", labels, float64(m.GetHistogram().GetSampleCount()))
+				count(name+".sum", labels, m.GetHistogram().GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				count(name+".count", labels, float64(m.GetSummary().GetSampleCount()))
+				count(name+".sum", labels, m.GetSummary().GetSampleSum())
+			}
+		}
+	}
+	if packet.Len() > 0 {
+		p.send(packet.Bytes())
+	}
+	p.counts = counts
+}
+
+// series is the name a metric is pushed as, and its tags if any
+func (p *Pusher) series(name string, labels []*dto.LabelPair) (string, string) {
+	if !p.datadog {
+		for _, label := range labels {
+			name += "." + sanitizeName(label.GetValue())
+		}
+		return name, ""
+	}
+	tags := append([]string(nil), p.tags...)
+	for _, label := range labels {
+		tags = append(tags, sanitizeTag(label.GetName()+":"+label.GetValue()))
+	}
+	if len(tags) == 0 {
+		return name, ""
+	}
+	return name, "|#" + strings.Join(tags, ",")
+}
+
+// send writes one datagram, logging when the agent starts or stops
+// refusing them
+func (p *Pusher) send(packet []byte) {
+	_, err := p.conn.Write(packet)
+	if err != nil && !p.failing {
+		log.Printf("StatsD: pushing metrics failed: %v", err)
+	} else if err == nil && p.failing {
+		log.Println("StatsD: pushing metrics again")
+	}
+	p.failing = err != nil
+}
+
+// sanitizeName replaces the characters StatsD uses as separators in a
+// metric name, and the dots and spaces that would split a label value
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '.', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// sanitizeTag replaces the characters DogStatsD uses as separators in
+// a tag
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', '@', '#', ',', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
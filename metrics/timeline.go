@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Timeline Overview:
+-----------------
+A small in-process ring buffer of per-minute activity covering
+the last hour. It powers the admin dashboard endpoint so a
+deployment gets useful numbers without running Prometheus.
+
+Counters (connects, messages, ...) are summed per bucket;
+gauges (active connections/rooms) keep the peak seen in a bucket.
+*/
+
+// Timeline bucket layout: one-minute buckets covering an hour
+const (
+	timelineBucket = time.Minute
+	timelineSize   = 60
+)
+
+// Event is a counter tracked by the timeline
+type Event int
+
+const (
+	EventConnect Event = iota
+	EventDisconnect
+	EventMessage
+	EventError
+)
+
+// Bucket holds activity for one minute
+type Bucket struct {
+	Start           time.Time `json:"start"`
+	Connects        int       `json:"connects"`
+	Disconnects     int       `json:"disconnects"`
+	Messages        int       `json:"messages"`
+	Errors          int       `json:"errors"`
+	PeakConnections int       `json:"peak_connections"`
+	PeakRooms       int       `json:"peak_rooms"`
+}
+
+// Timeline records recent activity in fixed-size time buckets
+type Timeline struct {
+	mu      sync.Mutex
+	buckets [timelineSize]Bucket
+}
+
+// Recent is the process-wide activity timeline
+var Recent = &Timeline{}
+
+// bucket returns the bucket for now, resetting it if it has gone stale
+// Caller must hold t.mu
+func (t *Timeline) bucket(now time.Time) *Bucket {
+	start := now.Truncate(timelineBucket)
+	b := &t.buckets[start.Unix()/int64(timelineBucket/time.Second)%timelineSize]
+	if !b.Start.Equal(start) {
+		*b = Bucket{Start: start}
+	}
+	return b
+}
+
+// Inc counts one occurrence of ev in the current bucket
+func (t *Timeline) Inc(ev Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.bucket(time.Now())
+	switch ev {
+	case EventConnect:
+		b.Connects++
+	case EventDisconnect:
+		b.Disconnects++
+	case EventMessage:
+		b.Messages++
+	case EventError:
+		b.Errors++
+	}
+}
+
+// Observe records current connection and room counts
+func (t *Timeline) Observe(connections, rooms int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.bucket(time.Now())
+	if connections > b.PeakConnections {
+		b.PeakConnections = connections
+	}
+	if rooms > b.PeakRooms {
+		b.PeakRooms = rooms
+	}
+}
+
+// Snapshot returns the last hour oldest first, with idle minutes as empty buckets
+func (t *Timeline) Snapshot() []Bucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().Truncate(timelineBucket)
+	out := make([]Bucket, 0, timelineSize)
+	for i := timelineSize - 1; i >= 0; i-- {
+		start := now.Add(-time.Duration(i) * timelineBucket)
+		b := t.buckets[start.Unix()/int64(timelineBucket/time.Second)%timelineSize]
+		if !b.Start.Equal(start) {
+			b = Bucket{Start: start}
+		}
+		out = append(out, b)
+	}
+	return out
+}
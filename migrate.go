@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"chat-app/db"
+)
+
+/*
+Migrate Overview:
+----------------
+`chat-app migrate` manages the database schema by hand; the server
+also applies pending migrations on startup unless
+CHAT_MIGRATE_ON_START=false.
+
+	chat-app migrate status      Show applied and pending migrations
+	chat-app migrate up          Apply all pending migrations
+	chat-app migrate down [N]    Revert the last N migrations (default 1)
+	chat-app migrate to VERSION  Move up or down to exactly VERSION
+*/
+
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	url := fs.String("database", os.Getenv("CHAT_DATABASE_URL"), "PostgreSQL connection URL")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chat-app migrate [--database URL] status|up|down [N]|to VERSION")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *url == "" {
+		log.Fatal("migrate: --database or CHAT_DATABASE_URL is required")
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	database, err := db.Open(ctx, *url)
+	if err != nil {
+		log.Fatal("migrate: ", err)
+	}
+	defer database.Close()
+	migrator, err := db.NewMigrator(database)
+	if err != nil {
+		log.Fatal("migrate: ", err)
+	}
+
+	switch cmd := fs.Arg(0); cmd {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal("migrate: ", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if !s.AppliedAt.IsZero() {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, state)
+		}
+	case "up":
+		applied, err := migrator.Up(ctx)
+		reportMigration("Applied", applied, err)
+	case "down":
+		steps := 1
+		if fs.NArg() > 1 {
+			steps, err = strconv.Atoi(fs.Arg(1))
+			if err != nil || steps <= 0 {
+				log.Fatal("migrate: down takes a positive number of steps")
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		reportMigration("Reverted", reverted, err)
+	case "to":
+		if fs.NArg() < 2 {
+			log.Fatal("migrate: to needs a VERSION")
+		}
+		version, err := strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			log.Fatal("migrate: VERSION must be a number")
+		}
+		changed, err := migrator.To(ctx, version)
+		reportMigration("Migrated", changed, err)
+	default:
+		log.Fatalf("migrate: unknown command %q", cmd)
+	}
+}
+
+// migrateUp applies pending migrations during server startup
+func migrateUp(ctx context.Context, database *sql.DB) error {
+	migrator, err := db.NewMigrator(database)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		log.Printf("Applied database migrations %v", applied)
+	}
+	return nil
+}
+
+func reportMigration(verb string, versions []int, err error) {
+	if len(versions) > 0 {
+		fmt.Printf("%s migrations %v\n", verb, versions)
+	} else if err == nil {
+		fmt.Println("Nothing to do")
+	}
+	if err != nil {
+		log.Fatal("migrate: ", err)
+	}
+}
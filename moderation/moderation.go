@@ -0,0 +1,177 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"chat-app/metrics"
+)
+
+/*
+Moderation Overview:
+-------------------
+Optional toxicity scoring of chat messages by an external API
+(Perspective or OpenAI moderation, see perspective.go and openai.go).
+
+Scoring never delays delivery: messages are broadcast first and
+submitted to a Moderator afterwards. A fixed pool of workers scores
+them and hands each verdict back to the caller, which acts on it:
+
+	score >= Delete   delete   removed from storage and from clients
+	score >= Hide     hide     hidden from clients pending moderator review
+	score >= Flag     flag     queued for moderators, still visible
+
+Scores run from 0 (benign) to 1 (certainly toxic). A zero threshold
+turns that action off. When the queue is full, or the API fails,
+the message is left alone: moderation is best effort and must never
+take chat down with it.
+*/
+
+// Actions a verdict can carry
+const (
+	ActionNone   = "none"
+	ActionFlag   = "flag"
+	ActionHide   = "hide"
+	ActionDelete = "delete"
+)
+
+// Providers accepted by NewScorer
+const (
+	ProviderPerspective = "perspective"
+	ProviderOpenAI      = "openai"
+)
+
+// NewScorer returns the Scorer for a provider name
+// endpoint overrides the provider's default URL when set
+func NewScorer(provider, key, endpoint string) (Scorer, error) {
+	switch provider {
+	case ProviderPerspective:
+		return NewPerspective(key, endpoint), nil
+	case ProviderOpenAI:
+		return NewOpenAI(key, endpoint), nil
+	}
+	return nil, fmt.Errorf("unknown moderation provider %q (want %s or %s)", provider, ProviderPerspective, ProviderOpenAI)
+}
+
+// scoreTimeout bounds one call to the moderation API
+const scoreTimeout = 5 * time.Second
+
+// Score is a moderation API's opinion of a text
+type Score struct {
+	Value      float64            `json:"value"`                // Highest category score, 0-1
+	Categories map[string]float64 `json:"categories,omitempty"` // Per category, as the API names them
+}
+
+// Scorer rates text; implementations must be safe for concurrent use
+type Scorer interface {
+	Score(ctx context.Context, text string) (Score, error)
+}
+
+// Thresholds map a score to an action; zero disables an action
+type Thresholds struct {
+	Flag   float64
+	Hide   float64
+	Delete float64
+}
+
+// Decide returns the strongest action whose threshold value reaches
+func (t Thresholds) Decide(value float64) string {
+	switch {
+	case t.Delete > 0 && value >= t.Delete:
+		return ActionDelete
+	case t.Hide > 0 && value >= t.Hide:
+		return ActionHide
+	case t.Flag > 0 && value >= t.Flag:
+		return ActionFlag
+	}
+	return ActionNone
+}
+
+// Job is a message waiting to be scored
+type Job struct {
+	Room      string
+	MessageID string
+	Username  string
+	Content   string
+}
+
+// Verdict is a scored message and what to do about it
+type Verdict struct {
+	Job
+	Score  Score
+	Action string
+}
+
+// Moderator scores messages in the background
+type Moderator struct {
+	scorer     Scorer
+	thresholds Thresholds
+	workers    int
+	jobs       chan Job
+}
+
+// New returns a Moderator with workers scoring from a queue of queue jobs
+func New(scorer Scorer, thresholds Thresholds, workers, queue int) *Moderator {
+	return &Moderator{
+		scorer:     scorer,
+		thresholds: thresholds,
+		workers:    max(workers, 1),
+		jobs:       make(chan Job, max(queue, 1)),
+	}
+}
+
+// Submit queues a message for scoring without blocking
+// It reports false, dropping the job, when the queue is full
+func (m *Moderator) Submit(job Job) bool {
+	select {
+	case m.jobs <- job:
+		return true
+	default:
+		metrics.ModerationFailures.WithLabelValues("queue_full").Inc()
+		return false
+	}
+}
+
+// Run scores queued messages until ctx is done, passing every verdict
+// that calls for an action to apply
+// apply is called from several goroutines at once
+func (m *Moderator) Run(ctx context.Context, apply func(Verdict)) {
+	for i := 0; i < m.workers; i++ {
+		go m.work(ctx, apply)
+	}
+	<-ctx.Done()
+}
+
+func (m *Moderator) work(ctx context.Context, apply func(Verdict)) {
+	for {
+		select {
+		case job := <-m.jobs:
+			m.score(ctx, job, apply)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// score rates one job and applies the verdict
+func (m *Moderator) score(ctx context.Context, job Job, apply func(Verdict)) {
+	ctx, cancel := context.WithTimeout(ctx, scoreTimeout)
+	defer cancel()
+
+	start := time.Now()
+	score, err := m.scorer.Score(ctx, job.Content)
+	metrics.ModerationLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ModerationFailures.WithLabelValues("error").Inc()
+		log.Printf("Moderation: scoring message %s in %s failed: %v", job.MessageID, job.Room, err)
+		return
+	}
+
+	verdict := Verdict{Job: job, Score: score, Action: m.thresholds.Decide(score.Value)}
+	metrics.ModerationVerdicts.WithLabelValues(verdict.Action).Inc()
+	if verdict.Action != ActionNone {
+		apply(verdict)
+	}
+}
@@ -0,0 +1,57 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// DefaultOpenAIEndpoint is OpenAI's moderation endpoint
+const DefaultOpenAIEndpoint = "https://api.openai.com/v1/moderations"
+
+// openAIModel is the moderation model requested
+const openAIModel = "omni-moderation-latest"
+
+// OpenAI scores text with the OpenAI moderation API
+type OpenAI struct {
+	endpoint string
+	key      string
+	client   *http.Client
+}
+
+// NewOpenAI returns an OpenAI scorer; endpoint may be empty for the default
+func NewOpenAI(key, endpoint string) *OpenAI {
+	if endpoint == "" {
+		endpoint = DefaultOpenAIEndpoint
+	}
+	return &OpenAI{endpoint: endpoint, key: key, client: &http.Client{}}
+}
+
+type openAIRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+type openAIResponse struct {
+	Results []struct {
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+}
+
+// Score implements Scorer
+func (o *OpenAI) Score(ctx context.Context, text string) (Score, error) {
+	header := http.Header{"Authorization": {"Bearer " + o.key}}
+	var resp openAIResponse
+	if err := postJSON(ctx, o.client, o.endpoint, header, openAIRequest{Model: openAIModel, Input: text}, &resp); err != nil {
+		return Score{}, err
+	}
+	if len(resp.Results) == 0 {
+		return Score{}, errors.New("openai: no results in response")
+	}
+
+	score := Score{Categories: resp.Results[0].CategoryScores}
+	for _, v := range score.Categories {
+		score.Value = max(score.Value, v)
+	}
+	return score, nil
+}
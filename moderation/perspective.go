@@ -0,0 +1,71 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// DefaultPerspectiveEndpoint is Google's Perspective comment analyzer
+const DefaultPerspectiveEndpoint = "https://commentanalyzer.googleapis.com/v1alpha1/comments:analyze"
+
+// perspectiveAttributes are the attributes requested for every message
+var perspectiveAttributes = []string{"TOXICITY", "SEVERE_TOXICITY", "IDENTITY_ATTACK", "INSULT", "PROFANITY", "THREAT"}
+
+// Perspective scores text with the Perspective API
+type Perspective struct {
+	endpoint string
+	key      string
+	client   *http.Client
+}
+
+// NewPerspective returns a Perspective scorer; endpoint may be empty for the default
+func NewPerspective(key, endpoint string) *Perspective {
+	if endpoint == "" {
+		endpoint = DefaultPerspectiveEndpoint
+	}
+	return &Perspective{endpoint: endpoint, key: key, client: &http.Client{}}
+}
+
+type perspectiveRequest struct {
+	Comment struct {
+		Text string `json:"text"`
+	} `json:"comment"`
+	RequestedAttributes map[string]struct{} `json:"requestedAttributes"`
+	DoNotStore          bool                `json:"doNotStore"`
+}
+
+type perspectiveResponse struct {
+	AttributeScores map[string]struct {
+		SummaryScore struct {
+			Value float64 `json:"value"`
+		} `json:"summaryScore"`
+	} `json:"attributeScores"`
+}
+
+// Score implements Scorer
+func (p *Perspective) Score(ctx context.Context, text string) (Score, error) {
+	var req perspectiveRequest
+	req.Comment.Text = text
+	req.DoNotStore = true // Chat messages aren't Google's to keep
+	req.RequestedAttributes = make(map[string]struct{}, len(perspectiveAttributes))
+	for _, attr := range perspectiveAttributes {
+		req.RequestedAttributes[attr] = struct{}{}
+	}
+
+	var resp perspectiveResponse
+	if err := postJSON(ctx, p.client, p.endpoint+"?key="+url.QueryEscape(p.key), nil, req, &resp); err != nil {
+		return Score{}, err
+	}
+	if len(resp.AttributeScores) == 0 {
+		return Score{}, errors.New("perspective: no attribute scores in response")
+	}
+
+	score := Score{Categories: make(map[string]float64, len(resp.AttributeScores))}
+	for attr, s := range resp.AttributeScores {
+		score.Categories[attr] = s.SummaryScore.Value
+		score.Value = max(score.Value, s.SummaryScore.Value)
+	}
+	return score, nil
+}
@@ -0,0 +1,370 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-app/metrics"
+	"chat-app/storage"
+)
+
+/*
+Notify Overview:
+---------------
+Members away from a room hear about its chat messages through a push
+or email gateway (a Sender, see webhook.go). Like moderation, this
+never delays delivery: the hub broadcasts a message first and
+submits it here, with who was online at the time. A pool of workers
+then, for each room member who was away:
+
+1. Loads their notification preferences (see storage.NotificationPrefs)
+2. Skips them if they muted the room, and otherwise unless the room's
+   level is all, or it is mentions and the message names them with
+   @username or @everyone, or holds one of their highlight words
+3. Holds it if they're in their do-not-disturb window, and otherwise
+   hands the gateway a Notification
+
+Held notifications are checked every minute; once a user's window
+ends they get one Notification with a Summary of what they missed
+instead. Holding happens in memory, so a restart forgets them.
+
+When the queue is full, or the gateway fails, the notification is
+dropped: they're a courtesy, and the message itself waits in history.
+*/
+
+// ErrInvalid is returned, wrapped, for preferences that can't be saved
+var ErrInvalid = errors.New("invalid preferences")
+
+// sendTimeout bounds one call to the gateway
+const sendTimeout = 5 * time.Second
+
+// maxPreview bounds the content carried in a notification, in runes
+const maxPreview = 200
+
+// flushEvery is how often held notifications are checked for users
+// whose do-not-disturb window ended
+const flushEvery = time.Minute
+
+// maxLatest bounds the notifications a summary carries in full
+const maxLatest = 10
+
+// Notification tells one user about one message
+type Notification struct {
+	Username  string    `json:"username"` // Who to notify
+	Room      string    `json:"room"`
+	From      string    `json:"from"`
+	MessageID string    `json:"message_id"`
+	Preview   string    `json:"preview"` // The message's start
+	Mention   bool      `json:"mention"` // Whether it names them, or a highlight word
+	SentAt    time.Time `json:"sent_at"`
+	Summary   *Summary  `json:"summary,omitempty"` // Only on summaries, which leave the rest empty
+}
+
+// Summary stands for the notifications held while a user was in
+// do-not-disturb
+type Summary struct {
+	Since    time.Time      `json:"since"` // When the first was held
+	Count    int            `json:"count"`
+	Mentions int            `json:"mentions"`
+	Rooms    map[string]int `json:"rooms"`  // How many per room
+	Latest   []Notification `json:"latest"` // The last few, oldest first
+}
+
+// Sender delivers notifications; implementations must be safe for concurrent use
+type Sender interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// Job is a broadcast message waiting to be notified
+type Job struct {
+	Room      string
+	From      string
+	MessageID string
+	Content   string
+	Online    map[string]bool // Members connected when it was sent
+	SentAt    time.Time
+}
+
+// Dispatcher notifies away members of messages in the background
+type Dispatcher struct {
+	store   storage.Store
+	sender  Sender
+	workers int
+	jobs    chan Job
+
+	mu   sync.Mutex
+	held map[string]*Summary // By username
+}
+
+// New returns a Dispatcher with workers sending from a queue of queue jobs
+func New(store storage.Store, sender Sender, workers, queue int) *Dispatcher {
+	return &Dispatcher{
+		store:   store,
+		sender:  sender,
+		workers: max(workers, 1),
+		jobs:    make(chan Job, max(queue, 1)),
+		held:    make(map[string]*Summary),
+	}
+}
+
+// Submit queues a message without blocking
+// It reports false, dropping the job, when the queue is full
+func (d *Dispatcher) Submit(job Job) bool {
+	select {
+	case d.jobs <- job:
+		return true
+	default:
+		metrics.Notifications.WithLabelValues("dropped").Inc()
+		return false
+	}
+}
+
+// Run sends queued notifications, and summaries of held ones, until
+// ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	for i := 0; i < d.workers; i++ {
+		go d.work(ctx)
+	}
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.flush(ctx, now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case job := <-d.jobs:
+			d.dispatch(ctx, job)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dispatch notifies the room's away members who want to hear of job
+func (d *Dispatcher) dispatch(ctx context.Context, job Job) {
+	members, err := d.store.Members(ctx, job.Room)
+	if err != nil {
+		log.Printf("Notify: loading members of %s failed: %v", job.Room, err)
+		return
+	}
+	for _, member := range members {
+		if member == job.From || job.Online[member] {
+			continue
+		}
+		prefs, err := Preferences(ctx, d.store, member)
+		if err != nil {
+			log.Printf("Notify: loading preferences of %s failed: %v", member, err)
+			continue
+		}
+		mention := Mentions(job.Content, member) || Highlighted(job.Content, prefs.Highlights) != ""
+		if prefs.MutedIn(job.Room, job.SentAt) || !Wants(prefs.Level(job.Room), mention) {
+			metrics.Notifications.WithLabelValues("muted").Inc()
+			continue
+		}
+		n := Notification{
+			Username:  member,
+			Room:      job.Room,
+			From:      job.From,
+			MessageID: job.MessageID,
+			Preview:   preview(job.Content),
+			Mention:   mention,
+			SentAt:    job.SentAt,
+		}
+		if prefs.Quiet(time.Now()) {
+			d.hold(n)
+			continue
+		}
+		d.send(ctx, n)
+	}
+}
+
+// hold keeps n for its user's summary
+func (d *Dispatcher) hold(n Notification) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sum, ok := d.held[n.Username]
+	if !ok {
+		sum = &Summary{Since: n.SentAt, Rooms: make(map[string]int)}
+		d.held[n.Username] = sum
+	}
+	sum.Count++
+	sum.Rooms[n.Room]++
+	if n.Mention {
+		sum.Mentions++
+	}
+	if len(sum.Latest) == maxLatest {
+		sum.Latest = append(sum.Latest[:0], sum.Latest[1:]...)
+	}
+	sum.Latest = append(sum.Latest, n)
+	metrics.Notifications.WithLabelValues("held").Inc()
+}
+
+// flush sends a summary to each user holding notifications whose
+// do-not-disturb window is over at now
+func (d *Dispatcher) flush(ctx context.Context, now time.Time) {
+	d.mu.Lock()
+	users := make([]string, 0, len(d.held))
+	for username := range d.held {
+		users = append(users, username)
+	}
+	d.mu.Unlock()
+
+	for _, username := range users {
+		prefs, err := Preferences(ctx, d.store, username)
+		if err != nil {
+			log.Printf("Notify: loading preferences of %s failed: %v", username, err)
+			continue
+		}
+		if prefs.Quiet(now) {
+			continue
+		}
+		d.mu.Lock()
+		sum := d.held[username]
+		delete(d.held, username)
+		d.mu.Unlock()
+		if sum != nil {
+			d.send(ctx, Notification{Username: username, SentAt: now, Summary: sum})
+		}
+	}
+}
+
+// send hands one notification to the gateway
+func (d *Dispatcher) send(ctx context.Context, n Notification) {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	if err := d.sender.Send(ctx, n); err != nil {
+		metrics.Notifications.WithLabelValues("failed").Inc()
+		log.Printf("Notify: notifying %s failed: %v", n.Username, err)
+		return
+	}
+	metrics.Notifications.WithLabelValues("sent").Inc()
+}
+
+// Preferences loads username's notification preferences, the defaults
+// if they never set any
+func Preferences(ctx context.Context, store storage.Store, username string) (storage.NotificationPrefs, error) {
+	prefs, err := store.GetNotificationPrefs(ctx, username)
+	if errors.Is(err, storage.ErrNotFound) {
+		return storage.NotificationPrefs{Username: username, Default: storage.DefaultNotify, Rooms: map[string]string{}}, nil
+	}
+	return prefs, err
+}
+
+// UpdatePreferences merges change into username's preferences and
+// saves them, returning the result
+func UpdatePreferences(ctx context.Context, store storage.Store, username string, change storage.NotificationPrefs) (storage.NotificationPrefs, error) {
+	if err := change.Validate(); err != nil {
+		return storage.NotificationPrefs{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	prefs, err := Preferences(ctx, store, username)
+	if err != nil {
+		return prefs, err
+	}
+	now := time.Now()
+	prefs = prefs.Merge(change).Unmute("", now) // Mutes that ran out go
+	if err := prefs.Validate(); err != nil {
+		return storage.NotificationPrefs{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	prefs.UpdatedAt = now
+	return prefs, store.SaveNotificationPrefs(ctx, prefs)
+}
+
+// Mute silences room for username for d, or until unmuted when d is 0
+func Mute(ctx context.Context, store storage.Store, username, room string, d time.Duration) (storage.NotificationPrefs, error) {
+	var m storage.Mute
+	if d > 0 {
+		until := time.Now().Add(d)
+		m.Until = &until
+	}
+	return UpdatePreferences(ctx, store, username, storage.NotificationPrefs{Muted: map[string]storage.Mute{room: m}})
+}
+
+// Unmute lets room notify username again
+func Unmute(ctx context.Context, store storage.Store, username, room string) (storage.NotificationPrefs, error) {
+	prefs, err := Preferences(ctx, store, username)
+	if err != nil {
+		return prefs, err
+	}
+	prefs = prefs.Unmute(room, time.Now())
+	prefs.UpdatedAt = time.Now()
+	return prefs, store.SaveNotificationPrefs(ctx, prefs)
+}
+
+// Wants reports whether a member at level hears of a message, given
+// whether it mentions them
+func Wants(level string, mention bool) bool {
+	switch level {
+	case storage.NotifyAll:
+		return true
+	case storage.NotifyMentions:
+		return mention
+	}
+	return false
+}
+
+// Mentions reports whether content names username with @username or
+// @everyone, as a word of its own
+func Mentions(content, username string) bool {
+	return mentions(content, "@everyone") || (username != "" && mentions(content, "@"+username))
+}
+
+// Highlighted returns the first of words content holds as a word of
+// its own, ignoring case, or "" if none
+func Highlighted(content string, words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	content = strings.ToLower(content)
+	for _, word := range words {
+		if word != "" && mentions(content, strings.ToLower(word)) {
+			return word
+		}
+	}
+	return ""
+}
+
+// mentions reports whether content holds tag, not inside a longer word
+func mentions(content, tag string) bool {
+	for i := 0; ; {
+		j := strings.Index(content[i:], tag)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(tag)
+		before, _ := utf8.DecodeLastRuneInString(content[:start])
+		after, _ := utf8.DecodeRuneInString(content[end:])
+		if (start == 0 || !isWordRune(before)) && (end == len(content) || !isWordRune(after)) {
+			return true
+		}
+		i = end
+	}
+}
+
+// isWordRune reports whether r continues a word or username
+func isWordRune(r rune) bool {
+	return r == '_' || r == '-' || r == '@' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// preview is the start of content, at most maxPreview runes
+func preview(content string) string {
+	if utf8.RuneCountInString(content) <= maxPreview {
+		return content
+	}
+	runes := []rune(content)
+	return string(runes[:maxPreview]) + "…"
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxErrorBody is how much of a failed response is kept for the error
+const maxErrorBody = 512
+
+// Webhook posts each notification as JSON to a push or email gateway,
+// which knows how to reach the user:
+//
+//	POST <url>  {"username": "bob", "room": "lobby", "from": "alice", "preview": "...", ...}
+//
+// Any 2xx response counts as delivered
+type Webhook struct {
+	URL    string
+	Client *http.Client // Optional; http.DefaultClient when nil
+}
+
+// Send implements Sender
+func (w *Webhook) Send(ctx context.Context, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("notification gateway returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
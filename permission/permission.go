@@ -0,0 +1,181 @@
+package permission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"chat-app/metrics"
+	"chat-app/storage"
+)
+
+/*
+Permission Overview:
+-------------------
+The Authorizer is the one place that decides whether a user may do
+something gated by a capability (see storage.Permissions):
+
+	pin               the pin and unpin frames
+	upload            POST /api/rooms/:room/uploads and /audio
+	mention_everyone  chat messages mentioning @everyone
+	command           chat messages starting with /
+	invite            invite frames and /api/rooms/:room/invites
+	post              chat, reply, sticker, audio and attachment frames,
+	                  in broadcast rooms only
+	breakout          opening and closing /api/rooms/:room/breakouts
+	analytics         GET /api/rooms/:room/analytics
+
+A user's roles in a room are member, moderator if named in
+CHAT_MODERATORS, and whatever the room's permissions assign them; the
+user may do what any of those roles is granted. Rooms' permissions
+are cached for cacheTTL, which is how long a change made on another
+node takes to apply here.
+
+A nil Authorizer allows everything.
+*/
+
+// ErrDenied is returned when none of a user's roles grants a capability
+var ErrDenied = errors.New("permission denied")
+
+// cacheTTL is how long a room's permissions are reused before reloading
+const cacheTTL = 10 * time.Second
+
+// DeniedError names the capability that was refused; it matches ErrDenied
+type DeniedError struct {
+	Room       string
+	Capability string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("you don't have the %s permission in %s", e.Capability, e.Room)
+}
+
+func (e *DeniedError) Is(target error) bool {
+	return target == ErrDenied
+}
+
+// Grant is what a user may do in a room
+type Grant struct {
+	Room         string   `json:"room"`
+	Username     string   `json:"username"`
+	Roles        []string `json:"roles"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Authorizer checks capabilities against rooms' permissions; safe for
+// concurrent use
+type Authorizer struct {
+	store      storage.Store
+	moderators map[string]bool
+
+	mu    sync.Mutex
+	rooms map[string]cachedRoom
+}
+
+// cachedRoom is a room's permissions, good until expires
+type cachedRoom struct {
+	perms   storage.Permissions
+	expires time.Time
+}
+
+// New returns an authorizer reading permissions from store, in which
+// moderators hold the moderator role everywhere
+func New(store storage.Store, moderators ...string) *Authorizer {
+	a := &Authorizer{
+		store:      store,
+		moderators: make(map[string]bool),
+		rooms:      make(map[string]cachedRoom),
+	}
+	for _, name := range moderators {
+		a.moderators[name] = true
+	}
+	return a
+}
+
+// Authorize returns nil when username may use capability in room
+// It fails with a DeniedError, or the store's error when the room's
+// permissions can't be loaded
+func (a *Authorizer) Authorize(ctx context.Context, room, username, capability string) error {
+	if a == nil {
+		return nil
+	}
+	perms, err := a.permissions(ctx, room)
+	if err != nil {
+		return err
+	}
+	if perms.Allows(a.roles(perms, username), capability) {
+		return nil
+	}
+	metrics.PermissionDenials.WithLabelValues(capability).Inc()
+	return &DeniedError{Room: room, Capability: capability}
+}
+
+// Grant reports username's roles and capabilities in room
+func (a *Authorizer) Grant(ctx context.Context, room, username string) (Grant, error) {
+	g := Grant{Room: room, Username: username, Roles: []string{}, Capabilities: []string{}}
+	if a == nil {
+		g.Roles = []string{storage.RoleMember}
+		g.Capabilities = slices.Clone(storage.Capabilities)
+		return g, nil
+	}
+	perms, err := a.permissions(ctx, room)
+	if err != nil {
+		return g, err
+	}
+	g.Roles = a.roles(perms, username)
+	for _, c := range storage.Capabilities {
+		if perms.Allows(g.Roles, c) {
+			g.Capabilities = append(g.Capabilities, c)
+		}
+	}
+	return g, nil
+}
+
+// Forget drops room's cached permissions, so a change applies here at once
+func (a *Authorizer) Forget(room string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	delete(a.rooms, room)
+	a.mu.Unlock()
+}
+
+// roles lists the roles username holds under perms, built-in ones first
+func (a *Authorizer) roles(perms storage.Permissions, username string) []string {
+	roles := []string{storage.RoleMember}
+	if a.moderators[username] {
+		roles = append(roles, storage.RoleModerator)
+	}
+	return append(roles, perms.RolesOf(username)...)
+}
+
+// permissions returns room's permissions, from the cache while fresh
+func (a *Authorizer) permissions(ctx context.Context, room string) (storage.Permissions, error) {
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.rooms[room]
+	a.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.perms, nil
+	}
+
+	settings, err := a.store.GetRoomSettings(ctx, room)
+	if errors.Is(err, storage.ErrNotFound) {
+		settings = storage.DefaultRoomSettings(room)
+	} else if err != nil {
+		return storage.Permissions{}, err
+	}
+	a.mu.Lock()
+	for r, c := range a.rooms {
+		if now.After(c.expires) {
+			delete(a.rooms, r)
+		}
+	}
+	a.rooms[room] = cachedRoom{perms: settings.Permissions, expires: now.Add(cacheTTL)}
+	a.mu.Unlock()
+	return settings.Permissions, nil
+}
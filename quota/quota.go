@@ -0,0 +1,179 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"chat-app/storage"
+)
+
+/*
+Quota Overview:
+--------------
+Rate limits stop bursts; quotas put a ceiling on a whole day, for
+public deployments where one account could otherwise post or upload
+without end. Each user gets, per UTC day:
+
+	messages  chat, sticker, voice note and attachment messages posted
+	uploads   voice notes and attachments uploaded
+	bytes     message content plus upload sizes
+
+A zero limit is unlimited. Usage is counted in the store (see
+storage.AddUsage), so nodes sharing one enforce a single quota.
+Something that would take a counter past its limit is refused whole
+and not counted, so a big upload refused late in the day leaves the
+rest of the quota for smaller ones.
+
+A user's quota is reported as:
+
+	{"day": "2024-06-10", "used": {"messages": 120, "uploads": 2, "bytes": 498113},
+	 "limits": {"messages": 500, "uploads": 0, "bytes": 10485760},
+	 "remaining": {"messages": 380, "bytes": 9987647},
+	 "resets_at": "2024-06-11T00:00:00Z"}
+
+remaining lists only the limited counters. Counters are kept for a
+day after they reset, then removed by Run.
+*/
+
+// ErrExceeded is returned when a user's daily quota is used up
+var ErrExceeded = errors.New("daily quota exceeded")
+
+// Counter names, used in errors, remaining and metric labels
+const (
+	Messages = "messages"
+	Uploads  = "uploads"
+	Bytes    = "bytes"
+)
+
+// Status is a user's quota for the day
+type Status struct {
+	Day       string           `json:"day"`
+	Used      storage.Usage    `json:"used"`
+	Limits    storage.Usage    `json:"limits"`
+	Remaining map[string]int64 `json:"remaining"` // Limited counters only
+	ResetsAt  time.Time        `json:"resets_at"`
+}
+
+// ExceededError names the counter that ran out; it matches ErrExceeded
+type ExceededError struct {
+	Counter  string
+	Limit    int64
+	ResetsAt time.Time
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("daily %s quota of %d reached, it resets at %s", e.Counter, e.Limit, e.ResetsAt.Format("15:04 MST"))
+}
+
+func (e *ExceededError) Is(target error) bool {
+	return target == ErrExceeded
+}
+
+// Tracker enforces daily quotas; safe for concurrent use
+type Tracker struct {
+	store  storage.Store
+	limits storage.Usage
+}
+
+// New counts usage in store against limits, whose zero fields are unlimited
+func New(store storage.Store, limits storage.Usage) *Tracker {
+	return &Tracker{store: store, limits: limits}
+}
+
+// Enabled reports whether any counter is limited
+func (t *Tracker) Enabled() bool {
+	return t != nil && t.limits != storage.Usage{}
+}
+
+// Take counts use against username's quota for the day
+// It returns *ExceededError, changing nothing, when use doesn't fit
+func (t *Tracker) Take(ctx context.Context, username string, use storage.Usage, now time.Time) (Status, error) {
+	day := Day(now)
+	used, ok, err := t.store.AddUsage(ctx, username, day, use, t.limits)
+	if err != nil {
+		return Status{}, err
+	}
+	status := t.status(day, used)
+	if !ok {
+		return status, t.exceeded(used.Add(use), status.ResetsAt)
+	}
+	return status, nil
+}
+
+// Status reports username's quota for the day
+func (t *Tracker) Status(ctx context.Context, username string, now time.Time) (Status, error) {
+	day := Day(now)
+	used, err := t.store.GetUsage(ctx, username, day)
+	if err != nil {
+		return Status{}, err
+	}
+	return t.status(day, used), nil
+}
+
+// status describes used against the limits
+func (t *Tracker) status(day time.Time, used storage.Usage) Status {
+	s := Status{
+		Day:       day.Format(time.DateOnly),
+		Used:      used,
+		Limits:    t.limits,
+		Remaining: make(map[string]int64),
+		ResetsAt:  day.AddDate(0, 0, 1),
+	}
+	for _, c := range t.counters(used) {
+		if c.limit > 0 {
+			s.Remaining[c.name] = max(c.limit-c.used, 0)
+		}
+	}
+	return s
+}
+
+// exceeded names the first counter over its limit in total
+func (t *Tracker) exceeded(total storage.Usage, resets time.Time) error {
+	for _, c := range t.counters(total) {
+		if c.limit > 0 && c.used > c.limit {
+			return &ExceededError{Counter: c.name, Limit: c.limit, ResetsAt: resets}
+		}
+	}
+	return &ExceededError{ResetsAt: resets} // The store disagreed; still refuse
+}
+
+// counter is one counter's usage and limit
+type counter struct {
+	name        string
+	used, limit int64
+}
+
+// counters pairs each counter in used with its limit
+func (t *Tracker) counters(used storage.Usage) []counter {
+	return []counter{
+		{Messages, used.Messages, t.limits.Messages},
+		{Uploads, used.Uploads, t.limits.Uploads},
+		{Bytes, used.Bytes, t.limits.Bytes},
+	}
+}
+
+// Run removes counters from before yesterday on every tick until ctx is cancelled
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := t.store.DeleteUsageBefore(ctx, Day(now).AddDate(0, 0, -1)); err != nil {
+				log.Printf("Quota cleanup failed: %v", err)
+			}
+		}
+	}
+}
+
+// Day is the start of the UTC day containing now, which quotas count by
+func Day(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
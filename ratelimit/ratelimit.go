@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Rate Limit Overview:
+-------------------
+Token buckets shared by the connection throttles and the broadcast
+guard (websockets) and the per-region limits (geoip).
+
+A bucket holds up to burst tokens and refills at rate per second.
+Reserve takes one token, or books the next one if it will arrive
+within maxWait: the caller sleeps for the returned delay and then
+proceeds, which makes the bucket a FIFO queue. When even that is too
+long, Reserve takes nothing and returns how long until a token would
+be free, suitable for a Retry-After header.
+
+Take never waits. Its floor lets callers rank traffic on one bucket:
+low-priority work passes a high floor and is refused while the
+bucket is half drained, leaving the rest for work that passes 0.
+*/
+
+// Bucket is a token bucket; it is not safe for concurrent use
+type Bucket struct {
+	rate   float64 // Tokens added per second
+	burst  float64 // Capacity
+	tokens float64 // Negative while tokens are booked ahead
+	last   time.Time
+}
+
+// NewBucket returns a full bucket
+func NewBucket(rate, burst float64, now time.Time) *Bucket {
+	burst = max(burst, 1)
+	return &Bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// Reserve takes a token now or books one within maxWait
+// It returns the delay before the token is usable and whether it was
+// taken; when it wasn't, the delay is how long until one would be free
+func (b *Bucket) Reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// Take removes one token if at least floor tokens remain afterwards
+// A floor above zero keeps headroom for more important work
+func (b *Bucket) Take(now time.Time, floor float64) bool {
+	b.refill(now)
+	if b.tokens-1 < floor {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Burst is the bucket's capacity
+func (b *Bucket) Burst() float64 {
+	return b.burst
+}
+
+// refill adds the tokens accrued since the last call
+func (b *Bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// full reports whether the bucket would be full at now, i.e. forgettable
+func (b *Bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// Limiter is a Bucket safe for concurrent use
+type Limiter struct {
+	mu     sync.Mutex
+	bucket *Bucket
+}
+
+// NewLimiter returns a limiter allowing rate per second with bursts of burst
+func NewLimiter(rate, burst float64) *Limiter {
+	return &Limiter{bucket: NewBucket(rate, burst, time.Now())}
+}
+
+// Reserve is Bucket.Reserve under the limiter's lock
+func (l *Limiter) Reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bucket.Reserve(now, maxWait)
+}
+
+// sweepInterval is how often Keyed forgets idle buckets
+const sweepInterval = time.Minute
+
+// Keyed holds one bucket per key (an IP, a region), safe for concurrent use
+// Buckets that have refilled are dropped, so idle keys cost nothing
+type Keyed struct {
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+// NewKeyed returns an empty set of buckets
+func NewKeyed() *Keyed {
+	return &Keyed{buckets: make(map[string]*Bucket), lastSweep: time.Now()}
+}
+
+// Reserve takes a token from key's bucket, creating it with rate and burst
+func (k *Keyed) Reserve(key string, rate, burst float64, now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if now.Sub(k.lastSweep) >= sweepInterval {
+		for key, b := range k.buckets {
+			if b.full(now) {
+				delete(k.buckets, key)
+			}
+		}
+		k.lastSweep = now
+	}
+
+	b, ok := k.buckets[key]
+	if !ok {
+		b = NewBucket(rate, burst, now)
+		k.buckets[key] = b
+	}
+	return b.Reserve(now, maxWait)
+}
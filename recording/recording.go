@@ -0,0 +1,254 @@
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+Session Recording Overview:
+--------------------------
+To reproduce a bug a user ran into, the server can record their
+connections frame by frame and the frames can be played back later.
+A Recorder writes one file per recorded connection, <conn ID>.jsonl
+in its directory: a header line, then a line per frame with the
+milliseconds since the connection opened and its direction:
+
+	{"conn": "c1f0...", "room": "lobby", "username": "alice", "started": "..."}
+	{"at_ms": 3, "dir": "out", "data": "{\"type\":\"presence\",...}"}
+	{"at_ms": 1520, "dir": "in", "data": "{\"type\":\"chat\",\"content\":\"hi\"}"}
+
+Only text frames are recorded, not pings or pongs. Recording is
+opt-in: nothing is recorded unless a directory is configured, and
+when users are listed only their connections are.
+
+Frames carry what users wrote, so a Redactor can rewrite each one
+before it reaches the disk; RedactFields blanks the named JSON
+fields wherever they appear. Load reads a recording back, and Replay
+sends its inbound frames again, at their original pace or faster
+(see `chat-app replay` and wstest's Server.Replay).
+*/
+
+// Direction says which way a frame travelled
+type Direction string
+
+const (
+	In  Direction = "in"  // From the client to the server
+	Out Direction = "out" // From the server to the client
+)
+
+// Header opens a recording
+type Header struct {
+	Conn     string    `json:"conn"`
+	Room     string    `json:"room"`
+	Username string    `json:"username"`
+	Started  time.Time `json:"started"`
+}
+
+// Frame is one recorded frame
+type Frame struct {
+	AtMs int64     `json:"at_ms"`
+	Dir  Direction `json:"dir"`
+	Data string    `json:"data"`
+}
+
+// At returns when the frame was recorded, from the start of the connection
+func (f Frame) At() time.Duration {
+	return time.Duration(f.AtMs) * time.Millisecond
+}
+
+// Redactor rewrites a frame before it is recorded
+type Redactor func(dir Direction, data []byte) []byte
+
+// redacted replaces the values RedactFields removes
+const redacted = "[redacted]"
+
+// RedactFields blanks the named fields of JSON frames, at any depth
+// A frame that isn't JSON is plain chat text, and is blanked whole
+// when "content" is one of the fields
+func RedactFields(fields ...string) Redactor {
+	if len(fields) == 0 {
+		return nil
+	}
+	names := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		names[f] = true
+	}
+	return func(_ Direction, data []byte) []byte {
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			if names["content"] {
+				return []byte(redacted)
+			}
+			return data
+		}
+		out, err := json.Marshal(redactValue(v, names))
+		if err != nil {
+			return data
+		}
+		return out
+	}
+}
+
+// redactValue blanks the named fields of v's objects
+func redactValue(v any, names map[string]bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, field := range v {
+			if names[k] {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(field, names)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item, names)
+		}
+	}
+	return v
+}
+
+// Recorder decides which connections are recorded and writes their files
+type Recorder struct {
+	dir    string
+	users  map[string]bool // Empty records everyone
+	redact Redactor        // nil records frames as they are
+}
+
+// New returns a recorder writing to dir, recording only users when
+// any are given, or nil when dir is empty
+func New(dir string, users []string, redact Redactor) *Recorder {
+	if dir == "" {
+		return nil
+	}
+	r := &Recorder{dir: dir, users: make(map[string]bool, len(users)), redact: redact}
+	for _, u := range users {
+		r.users[u] = true
+	}
+	return r
+}
+
+// Records reports whether username's connections are recorded
+func (r *Recorder) Records(username string) bool {
+	return r != nil && (len(r.users) == 0 || r.users[username])
+}
+
+// Start creates the recording for a connection
+func (r *Recorder) Start(h Header) (*Session, error) {
+	if err := os.MkdirAll(r.dir, 0o750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(r.dir, filepath.Base(h.Conn)+".jsonl"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{file: f, w: bufio.NewWriter(f), started: h.Started, redact: r.redact}
+	s.enc = json.NewEncoder(s.w)
+	if err := s.enc.Encode(h); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Session is the recording of one connection; its methods are safe for
+// concurrent use, as the connection reads and writes from two goroutines
+type Session struct {
+	started time.Time
+	redact  Redactor
+
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+	err  error // First write error; recording stops there
+	done bool  // Closed
+}
+
+// Record appends a frame, flushing it so a crash loses nothing
+func (s *Session) Record(dir Direction, data []byte) {
+	if s.redact != nil {
+		data = s.redact(dir, data)
+	}
+	frame := Frame{AtMs: time.Since(s.started).Milliseconds(), Dir: dir, Data: string(data)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.err != nil {
+		return
+	}
+	if err := s.enc.Encode(frame); err != nil {
+		s.err = err
+		return
+	}
+	s.err = s.w.Flush()
+}
+
+// Close ends the recording, returning the first error writing it
+// Closing it again does nothing
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil
+	}
+	s.done = true
+	return errors.Join(s.err, s.file.Close())
+}
+
+// Load reads a recording
+func Load(path string) (Header, []Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	defer f.Close()
+
+	var h Header
+	dec := json.NewDecoder(f)
+	if err := dec.Decode(&h); err != nil {
+		return Header{}, nil, fmt.Errorf("read header: %w", err)
+	}
+	var frames []Frame
+	for dec.More() {
+		var frame Frame
+		if err := dec.Decode(&frame); err != nil {
+			return Header{}, nil, fmt.Errorf("read frame %d: %w", len(frames)+1, err)
+		}
+		frames = append(frames, frame)
+	}
+	return h, frames, nil
+}
+
+// Replay sends the inbound frames in order, keeping their original
+// spacing divided by speed; speed 0 sends them as fast as possible
+func Replay(ctx context.Context, frames []Frame, speed float64, send func(data []byte) error) error {
+	start := time.Now()
+	for _, frame := range frames {
+		if frame.Dir != In {
+			continue
+		}
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(frame.At()) / speed))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(due)):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := send([]byte(frame.Data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"chat-app/client"
+	"chat-app/recording"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Replay Overview:
+---------------
+`chat-app replay` plays back a session recording (see the recording
+package) to reproduce what a user ran into:
+
+	chat-app replay --speed 10 recordings/c1f0....jsonl
+
+It joins the recorded room as the recorded user and sends the frames
+the client sent, at their original pace times --speed (0 sends them
+all at once), printing every frame sent and received. Without
+--target it runs against a fresh hub in the same process, so a bug
+can be reproduced under a debugger; with it, against a running
+server. Use --room and --username to replay somewhere else.
+*/
+
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "", "server base URL (default: an in-process hub)")
+	speed := fs.Float64("speed", 1, "replay speed relative to the recording; 0 sends frames as fast as possible")
+	wait := fs.Duration("wait", 2*time.Second, "how long to keep receiving after the last frame")
+	room := fs.String("room", "", "room to replay in (default: the recorded room)")
+	username := fs.String("username", "", "user to replay as (default: the recorded user)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chat-app replay [flags] recording.jsonl")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *speed < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	h, frames, err := recording.Load(fs.Arg(0))
+	if err != nil {
+		log.Fatal("replay: ", err)
+	}
+	if *room != "" {
+		h.Room = *room
+	}
+	if *username != "" {
+		h.Username = *username
+	}
+
+	// Without a target, replay against a hub of our own
+	if *target == "" {
+		gin.SetMode(gin.TestMode)
+		hub := websockets.NewHub()
+		go hub.Run()
+		r := gin.New()
+		r.GET("/ws/:room", websockets.HandleWebSocket(hub))
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+		*target = srv.URL
+	}
+
+	conn, err := client.Dial(context.Background(), *target, h.Room, h.Username)
+	if err != nil {
+		log.Fatal("replay: ", err)
+	}
+	defer conn.Close()
+	log.Printf("Replaying conn=%s as %s in %s against %s", h.Conn, h.Username, h.Room, *target)
+
+	var count atomic.Int64
+	received := make(chan struct{}, 1)
+	go func() {
+		for {
+			msg, err := conn.Receive()
+			if err != nil {
+				return
+			}
+			data, _ := json.Marshal(msg)
+			fmt.Printf("<- %s\n", data)
+			count.Add(1)
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	sent := 0
+	err = recording.Replay(context.Background(), frames, *speed, func(data []byte) error {
+		fmt.Printf("-> %s\n", data)
+		sent++
+		return conn.Send(string(data))
+	})
+	if err != nil {
+		log.Fatal("replay: ", err)
+	}
+
+	// Keep printing until the server has been quiet for --wait
+	for quiet := false; !quiet; {
+		select {
+		case <-received:
+		case <-time.After(*wait):
+			quiet = true
+		}
+	}
+
+	recorded := 0
+	for _, f := range frames {
+		if f.Dir == recording.Out {
+			recorded++
+		}
+	}
+	log.Printf("Replay: sent %d frames and received %d; the recorded client received %d", sent, count.Load(), recorded)
+}
@@ -0,0 +1,145 @@
+package sanitize
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Sanitize Overview:
+-----------------
+Clean makes user text safe to show, even in web clients that put it
+straight into the page:
+
+1. Invalid UTF-8 becomes U+FFFD
+2. Control characters other than newline and tab are dropped
+3. Invisible characters used for abuse are dropped: zero-width spaces,
+   bidi overrides (which can make text read backwards), soft hyphens,
+   filler characters and tag characters outside flag emoji. Zero-width
+   joiners, which emoji and some scripts need, are kept only between
+   visible characters, one at a time
+4. Stacks of combining marks ("Zalgo" text) are cut to MaxCombining
+5. HTML is handled by mode:
+
+	strip   script and style blocks, comments and tags are removed,
+	        until removing one can't form another, and any < or >
+	        left is escaped (default)
+	escape  <, >, &, ' and " become entities, for clients that render
+	        messages as HTML
+	keep    left alone, for clients that only ever render text
+
+Characters are cleaned before HTML, so a zero-width space can't hide
+a tag from the HTML step by splitting "<script>".
+*/
+
+// HTML handling modes
+const (
+	HTMLStrip  = "strip"
+	HTMLEscape = "escape"
+	HTMLKeep   = "keep"
+)
+
+// MaxCombining is how many combining marks one character may carry
+const MaxCombining = 4
+
+// Sanitizer cleans user-supplied text; the zero value strips HTML
+type Sanitizer struct {
+	HTML string // HTMLStrip, HTMLEscape or HTMLKeep; empty means HTMLStrip
+}
+
+var (
+	// blockPattern matches elements whose content must go with them
+	blockPattern = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>|<!--.*?-->`)
+	tagPattern   = regexp.MustCompile(`<[a-zA-Z/!?][^<>]*>`)
+
+	// bracketEscaper escapes what is left once tags are gone
+	bracketEscaper = strings.NewReplacer("<", "&lt;", ">", "&gt;")
+)
+
+// Clean returns text with control characters, invisible characters and
+// HTML dealt with
+func (s Sanitizer) Clean(text string) string {
+	text = cleanRunes(strings.ToValidUTF8(text, "\ufffd"))
+	switch s.HTML {
+	case HTMLKeep:
+		return text
+	case HTMLEscape:
+		return html.EscapeString(text)
+	}
+
+	// Removing "<b>" from "<scr<b>ipt>" makes a new tag, so repeat
+	// until nothing changes
+	for {
+		stripped := tagPattern.ReplaceAllString(blockPattern.ReplaceAllString(text, ""), "")
+		if stripped == text {
+			break
+		}
+		text = stripped
+	}
+	// A leftover "<img src=x onerror=..." would be closed by whatever
+	// markup a client wraps it in, so escape rather than drop: dropping
+	// characters can join what is left into a tag
+	return bracketEscaper.Replace(text)
+}
+
+// cleanRunes drops control and invisible characters and caps combining marks
+func cleanRunes(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	var prev rune // The last rune written
+	marks := 0    // Combining marks on prev
+	for i, r := range text {
+		switch {
+		case r == '\n' || r == '\t':
+		case unicode.IsControl(r), dropped(r):
+			continue
+		case r == '\u200c' || r == '\u200d':
+			// Joiners only join: something visible each side, never two in a row
+			next, _ := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):])
+			if !visible(prev) || !visible(next) {
+				continue
+			}
+		case r >= 0xE0020 && r <= 0xE007F:
+			// Tag characters only spell out subdivision flags, after the black flag
+			if prev != '\U0001F3F4' && !(prev >= 0xE0020 && prev <= 0xE007F) {
+				continue
+			}
+		case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r):
+			if marks++; marks > MaxCombining {
+				continue
+			}
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteRune(r)
+		prev = r
+		marks = 0
+	}
+	return b.String()
+}
+
+// dropped reports whether r is an invisible character with no place in chat
+func dropped(r rune) bool {
+	switch {
+	case r == '\u00ad', // Soft hyphen
+		r == '\u034f',                  // Combining grapheme joiner
+		r == '\u180e',                  // Mongolian vowel separator
+		r == '\u200b',                  // Zero-width space
+		r >= '\u202a' && r <= '\u202e', // Bidi embeddings and overrides
+		r >= '\u2060' && r <= '\u2064', // Word joiner, invisible operators
+		r >= '\u2066' && r <= '\u206f', // Bidi isolates, deprecated format characters
+		r == '\ufeff',                  // Byte order mark
+		r == '\u115f' || r == '\u1160' || r == '\u3164' || r == '\uffa0', // Hangul fillers
+		r == '\U000E0001': // Language tag
+		return true
+	}
+	return false
+}
+
+// visible reports whether r shows as something, for joiner placement
+func visible(r rune) bool {
+	return r != 0 && r != utf8.RuneError && !unicode.IsSpace(r) && r != '\u200c' && r != '\u200d'
+}
@@ -0,0 +1,25 @@
+package sanitize
+
+import "testing"
+
+func TestCleanStrip(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello <b>world</b>", "hello world"},
+		{"a < b", "a &lt; b"},
+		{"<script>alert(1)</script>hi", "hi"},
+		// Dropping the first < used to leave a live tag opener
+		{"<<img src=x onerror=alert(1)", "&lt;&lt;img src=x onerror=alert(1)"},
+		{"<img src=x onerror=alert(1)", "&lt;img src=x onerror=alert(1)"},
+		// Removing one tag must not leave another behind
+		{"<scr<b>ipt>alert(1)</script>", "alert(1)"},
+		{"<<b>img src=x onerror=alert(1)>", ""},
+		{"<<b>img src=x onerror=alert(1)", "&lt;img src=x onerror=alert(1)"},
+	}
+	for _, tt := range tests {
+		if got := (Sanitizer{}).Clean(tt.in); got != tt.want {
+			t.Errorf("Clean(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/metrics"
+	"chat-app/storage"
+)
+
+/*
+Scheduler Overview:
+------------------
+The Scheduler posts stored announcements (see storage.Announcement)
+when their cron schedule comes due. Every CheckInterval it:
+
+1. Loads every announcement from the store
+2. Works out each one's next run after it last ran, or after it was
+   created or last edited
+3. For runs that are due, records the run and then delivers the
+   content to each room, and to each room with one of its tags
+
+A run is recorded before it is delivered, so a failing store can
+cause a missed announcement but never a repeated one. Recording it
+only succeeds if nobody else has since the last run, so of several
+nodes sharing a database, one posts each run. Runs missed by
+more than MissedRunGrace, e.g. while the server was down, are skipped
+rather than posted late.
+*/
+
+// CheckInterval is how often the scheduler looks for due announcements
+const CheckInterval = 15 * time.Second
+
+// MissedRunGrace is how late a run may still be delivered
+const MissedRunGrace = 5 * time.Minute
+
+// Run outcomes, used as metric labels
+const (
+	runSent    = "sent"
+	runSkipped = "skipped"
+	runFailed  = "failed"
+)
+
+// DeliverFunc posts an announcement's content to one room
+type DeliverFunc func(room, from, content string)
+
+// Scheduler delivers announcements as they come due
+type Scheduler struct {
+	store   storage.Store
+	deliver DeliverFunc
+}
+
+// NewScheduler creates a scheduler that posts with deliver
+func NewScheduler(store storage.Store, deliver DeliverFunc) *Scheduler {
+	return &Scheduler{store: store, deliver: deliver}
+}
+
+// NextRun returns when a is next due after t, or the zero time if never
+func NextRun(a storage.Announcement, t time.Time) (time.Time, error) {
+	sched, err := Parse(a.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := a.Location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.Next(t.In(loc)), nil
+}
+
+// Run checks for due announcements on every tick until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.Tick(ctx, now); err != nil {
+				log.Printf("Announcements failed: %v", err)
+				errreport.CaptureError(fmt.Errorf("announcements: %w", err), errreport.Context{})
+			}
+		}
+	}
+}
+
+// Tick delivers every announcement due at now
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) error {
+	posts, err := s.store.Announcements(ctx)
+	if err != nil {
+		return err
+	}
+
+	// One broken announcement shouldn't hold up the others
+	var errs []error
+	for _, a := range posts {
+		if err := s.runIfDue(ctx, a, now); err != nil {
+			metrics.AnnouncementRuns.WithLabelValues(runFailed).Inc()
+			errs = append(errs, fmt.Errorf("announcement %s: %w", a.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runIfDue delivers a if a run has come due since it last ran
+func (s *Scheduler) runIfDue(ctx context.Context, a storage.Announcement, now time.Time) error {
+	// Editing an announcement restarts its schedule from the edit
+	since := a.UpdatedAt
+	if a.LastRun.After(since) {
+		since = a.LastRun
+	}
+	next, err := NextRun(a, since)
+	if err != nil {
+		return err
+	}
+	if next.IsZero() || next.After(now) {
+		return nil
+	}
+
+	rooms, err := a.Targets(ctx, s.store)
+	if err != nil {
+		return err
+	}
+	if err := s.store.MarkAnnouncementRun(ctx, a.ID, a.LastRun, now); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil // Deleted since we listed it, or another node ran it
+		}
+		return err
+	}
+	if now.Sub(next) > MissedRunGrace {
+		log.Printf("Announcement %s: skipped the run due at %s", a.ID, next.Format(time.RFC3339))
+		metrics.AnnouncementRuns.WithLabelValues(runSkipped).Inc()
+		return nil
+	}
+
+	for _, room := range rooms {
+		s.deliver(room, a.From, a.Content)
+	}
+	metrics.AnnouncementRuns.WithLabelValues(runSent).Inc()
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"chat-app/config"
+	"chat-app/secrets"
+)
+
+// liveSecrets are the secret settings read on every use, so a rotated
+// secret applies without a restart
+type liveSecrets struct {
+	adminToken     *secrets.Value
+	moderatorToken *secrets.Value
+	jwtSecret      *secrets.Value
+	s3AccessKey    *secrets.Value
+	s3SecretKey    *secrets.Value
+}
+
+// setting is a config field by its key
+type setting struct {
+	name  string
+	field *string
+}
+
+// resolveSecrets replaces secret settings that refer to Vault or AWS
+// Secrets Manager with the secrets' values, returning the manager that
+// keeps them current (see secrets/secrets.go)
+func resolveSecrets(ctx context.Context, cfg *config.Config) (*secrets.Manager, liveSecrets, error) {
+	providers := make(map[string]secrets.Provider)
+	if cfg.Vault.Addr != "" {
+		providers[secrets.SchemeVault] = secrets.NewVault(cfg.Vault.Addr, cfg.Vault.Token)
+	}
+	if cfg.Secrets.AWSRegion != "" {
+		providers[secrets.SchemeAWS] = secrets.NewAWS(cfg.Secrets.AWSRegion)
+	}
+	m := secrets.NewManager(providers)
+
+	// Settings read per use follow rotations
+	var live liveSecrets
+	for _, s := range []struct {
+		name  string
+		field *string
+		value **secrets.Value
+	}{
+		{"CHAT_ADMIN_TOKEN", &cfg.AdminToken, &live.adminToken},
+		{"CHAT_MODERATOR_TOKEN", &cfg.Moderation.Token, &live.moderatorToken},
+		{"CHAT_JWT_SECRET", &cfg.JWT.Secret, &live.jwtSecret},
+		{"CHAT_ARCHIVE_ACCESS_KEY", &cfg.Archive.AccessKey, &live.s3AccessKey},
+		{"CHAT_ARCHIVE_SECRET_KEY", &cfg.Archive.SecretKey, &live.s3SecretKey},
+	} {
+		v, err := m.Value(ctx, s.name, *s.field)
+		if err != nil {
+			return nil, liveSecrets{}, err
+		}
+		*s.field, *s.value = v.Get(), v
+	}
+
+	// The rest are only read at startup
+	restart := []setting{
+		{"CHAT_DATABASE_URL", &cfg.Database.URL},
+		{"CHAT_SENTRY_DSN", &cfg.ErrorReporting.DSN},
+		{"CHAT_MODERATION_API_KEY", &cfg.Moderation.APIKey},
+		{"CHAT_NOTIFY_WEBHOOK", &cfg.Notify.Webhook},
+		{"CHAT_EXPORT_SECRET", &cfg.ExportSecret},
+		{"CHAT_CLUSTER_SECRET", &cfg.Cluster.Secret},
+		{"CHAT_FEDERATION_SECRETS", &cfg.Federation.Secrets},
+		{"CHAT_ENCRYPTION_KEY", &cfg.Encryption.Key},
+	}
+	for i := range cfg.Encryption.OldKeys {
+		restart = append(restart, setting{"CHAT_ENCRYPTION_OLD_KEYS", &cfg.Encryption.OldKeys[i]})
+	}
+	for _, s := range restart {
+		name := s.name
+		value, err := m.Watch(ctx, name, *s.field, func(string) {
+			log.Printf("Secrets: %s was rotated; restart the server to apply it", name)
+		})
+		if err != nil {
+			return nil, liveSecrets{}, err
+		}
+		*s.field = value
+	}
+	return m, live, nil
+}
@@ -0,0 +1,149 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// AWS reads secrets from AWS Secrets Manager; a reference's path is the
+// secret's name or ARN. A SecretString holding a JSON object is read as
+// fields, anything else as plain text.
+//
+// Credentials come from the usual places: AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, the shared credentials file, or the instance
+// or task role.
+type AWS struct {
+	region   string
+	endpoint string
+	creds    *credentials.Credentials
+	client   *http.Client
+}
+
+var _ Provider = (*AWS)(nil)
+
+// awsTimeout bounds one request to Secrets Manager
+const awsTimeout = 10 * time.Second
+
+// NewAWS returns a Provider reading from Secrets Manager in region
+func NewAWS(region string) *AWS {
+	return &AWS{
+		region:   region,
+		endpoint: "https://secretsmanager." + region + ".amazonaws.com/",
+		creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Timeout: awsTimeout}},
+		}),
+		client: &http.Client{Timeout: awsTimeout},
+	}
+}
+
+// Fetch implements Provider
+func (a *AWS) Fetch(ctx context.Context, path string) (map[string]string, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": path})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if err := a.sign(req, body, time.Now()); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("secrets manager: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("secrets manager: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("secrets manager: %w", err)
+	}
+	var data map[string]any
+	if err := json.Unmarshal([]byte(out.SecretString), &data); err != nil {
+		return map[string]string{"": out.SecretString}, nil
+	}
+	return fields(data), nil
+}
+
+// sign adds an AWS Signature Version 4 to req, whose body is body
+func (a *AWS) sign(req *http.Request, body []byte, now time.Time) error {
+	creds, err := a.creds.Get()
+	if err != nil {
+		return fmt.Errorf("secrets manager: no AWS credentials: %w", err)
+	}
+	const service = "secretsmanager"
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	signed := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if creds.SessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	slices.Sort(signed)
+	var headers strings.Builder
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		headers.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonical := strings.Join([]string{
+		req.Method, "/", "", headers.String(), signedHeaders, hashHex(body),
+	}, "\n")
+	scope := date + "/" + a.region + "/" + service + "/aws4_request"
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hashHex([]byte(canonical))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+	return nil
+}
+
+// hashHex returns data's SHA-256 in hex
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns data's HMAC-SHA256 with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
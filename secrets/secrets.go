@@ -0,0 +1,235 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Secrets Overview:
+----------------
+Sensitive settings (database URL, tokens, S3 keys and the like) don't
+have to sit in plaintext in the environment or config file. Any of
+them may instead name a secret held by HashiCorp Vault or AWS Secrets
+Manager:
+
+	vault:<path>#<field>    A field of a Vault KV secret, e.g. vault:secret/data/chat#admin_token
+	awssm:<id>#<field>      A field of a JSON secret in AWS Secrets Manager
+	awssm:<id>              A plain-text secret in AWS Secrets Manager
+
+The Manager resolves references at startup, fetching each secret once
+however many settings read it. Run then fetches them again
+periodically, so a secret rotated in the store reaches the server
+without a restart: settings read per use (a Value) pick up the new
+value at once, and those only read at startup are logged as needing a
+restart. A failed refresh keeps the last value.
+*/
+
+// Provider fetches secrets from a secret store
+type Provider interface {
+	// Fetch returns the fields of the secret at path; a secret that is
+	// plain text rather than fields is returned under ""
+	Fetch(ctx context.Context, path string) (map[string]string, error)
+}
+
+// Ref names a secret field held by a Provider
+type Ref struct {
+	Provider string // Scheme the provider is registered under, e.g. vault
+	Path     string
+	Field    string // Empty for a plain-text secret
+}
+
+// String formats the reference as it is written in config
+func (r Ref) String() string {
+	if r.Field == "" {
+		return r.Provider + ":" + r.Path
+	}
+	return r.Provider + ":" + r.Path + "#" + r.Field
+}
+
+// fetchTimeout bounds resolving the references from one setting
+const fetchTimeout = 30 * time.Second
+
+// Value is a setting that may be rotated while the server runs; it is
+// safe for concurrent use
+type Value struct {
+	v atomic.Value
+}
+
+// NewValue returns a Value holding s
+func NewValue(s string) *Value {
+	v := &Value{}
+	v.Set(s)
+	return v
+}
+
+// Get returns the current value
+func (v *Value) Get() string {
+	s, _ := v.v.Load().(string)
+	return s
+}
+
+// Set replaces the value
+func (v *Value) Set(s string) {
+	v.v.Store(s)
+}
+
+// Manager resolves settings referring to secrets and keeps them current
+type Manager struct {
+	providers map[string]Provider // By scheme
+
+	mu      sync.Mutex
+	cache   map[Ref]map[string]string // Fetched secrets, by Ref without Field
+	watches []*watch
+}
+
+// watch is a setting refreshed by Run
+type watch struct {
+	name   string // Setting, e.g. CHAT_ADMIN_TOKEN
+	ref    Ref
+	value  string
+	update func(string)
+}
+
+// NewManager returns a manager using providers, keyed by scheme;
+// references to a scheme without one fail to resolve
+func NewManager(providers map[string]Provider) *Manager {
+	return &Manager{providers: providers, cache: make(map[Ref]map[string]string)}
+}
+
+// Schemes references start with
+const (
+	SchemeVault = "vault"
+	SchemeAWS   = "awssm"
+)
+
+// ParseRef reports whether setting refers to a secret, and which
+func ParseRef(setting string) (Ref, bool) {
+	scheme, rest, ok := strings.Cut(setting, ":")
+	if !ok || rest == "" || (scheme != SchemeVault && scheme != SchemeAWS) {
+		return Ref{}, false
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	return Ref{Provider: scheme, Path: path, Field: field}, true
+}
+
+// Resolve returns setting, or the secret it refers to; name is the
+// setting's key, for errors and logs
+func (m *Manager) Resolve(ctx context.Context, name, setting string) (string, error) {
+	ref, ok := ParseRef(setting)
+	if !ok {
+		return setting, nil
+	}
+	return m.lookup(ctx, name, ref)
+}
+
+// Watch is Resolve that also calls update with the secret's new value
+// whenever a refresh finds it rotated; a setting that isn't a reference
+// is never updated
+func (m *Manager) Watch(ctx context.Context, name, setting string, update func(string)) (string, error) {
+	ref, ok := ParseRef(setting)
+	if !ok {
+		return setting, nil
+	}
+	value, err := m.lookup(ctx, name, ref)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	m.watches = append(m.watches, &watch{name: name, ref: ref, value: value, update: update})
+	m.mu.Unlock()
+	return value, nil
+}
+
+// Value is Watch keeping the result in a Value
+func (m *Manager) Value(ctx context.Context, name, setting string) (*Value, error) {
+	v := &Value{}
+	value, err := m.Watch(ctx, name, setting, v.Set)
+	if err != nil {
+		return nil, err
+	}
+	v.Set(value)
+	return v, nil
+}
+
+// Watching reports whether any resolved setting is refreshed by Run
+func (m *Manager) Watching() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.watches) > 0
+}
+
+// lookup fetches ref's secret, or uses the copy fetched earlier, and
+// returns its field
+func (m *Manager) lookup(ctx context.Context, name string, ref Ref) (string, error) {
+	provider, ok := m.providers[ref.Provider]
+	if !ok {
+		return "", fmt.Errorf("%s: %s secrets are not configured", name, ref.Provider)
+	}
+	secret := Ref{Provider: ref.Provider, Path: ref.Path}
+
+	m.mu.Lock()
+	fields, cached := m.cache[secret]
+	m.mu.Unlock()
+	if !cached {
+		ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		defer cancel()
+		var err error
+		if fields, err = provider.Fetch(ctx, ref.Path); err != nil {
+			return "", fmt.Errorf("%s: fetch %s: %w", name, secret, err)
+		}
+		m.mu.Lock()
+		m.cache[secret] = fields
+		m.mu.Unlock()
+	}
+
+	value, ok := fields[ref.Field]
+	if !ok {
+		if ref.Field == "" {
+			return "", fmt.Errorf("%s: %s has fields, so the reference needs #<field>", name, secret)
+		}
+		return "", fmt.Errorf("%s: %s has no field %q", name, secret, ref.Field)
+	}
+	return value, nil
+}
+
+// Refresh fetches every watched secret again, updating the settings
+// whose value changed
+func (m *Manager) Refresh(ctx context.Context) {
+	m.mu.Lock()
+	clear(m.cache)
+	watches := append([]*watch(nil), m.watches...)
+	m.mu.Unlock()
+
+	for _, w := range watches {
+		value, err := m.lookup(ctx, w.name, w.ref)
+		if err != nil {
+			log.Printf("Secrets: refresh failed, keeping the current value: %v", err)
+			continue
+		}
+		if value == w.value {
+			continue
+		}
+		w.value = value
+		w.update(value)
+	}
+}
+
+// Run refreshes the watched secrets every interval until ctx is done
+func (m *Manager) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Refresh(ctx)
+		}
+	}
+}
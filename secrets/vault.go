@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault reads secrets from HashiCorp Vault's KV engine, version 1 or 2;
+// a reference's path is the API path under /v1, e.g. secret/data/chat
+// for the secret chat in a KV v2 engine mounted at secret
+type Vault struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+var _ Provider = (*Vault)(nil)
+
+// vaultTimeout bounds one request to Vault
+const vaultTimeout = 10 * time.Second
+
+// NewVault returns a Provider reading from the Vault at addr
+func NewVault(addr, token string) *Vault {
+	return &Vault{addr: strings.TrimRight(addr, "/"), token: token, client: &http.Client{Timeout: vaultTimeout}}
+}
+
+// Fetch implements Provider
+func (v *Vault) Fetch(ctx context.Context, path string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("vault: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	data := body.Data
+	// KV v2 nests the secret under data, next to its metadata
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	return fields(data), nil
+}
+
+// fields converts a secret's JSON fields to strings; values that
+// aren't strings keep their JSON form
+func fields(data map[string]any) map[string]string {
+	out := make(map[string]string, len(data))
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			out[k] = s
+			continue
+		}
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		out[k] = string(encoded)
+	}
+	return out
+}
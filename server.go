@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Listeners Overview:
+------------------
+The server can listen on several addresses at once, grouped by the
+surface they expose:
+
+	public  WebSocket endpoint, health check, peer links (CHAT_ADDR)
+	admin   Admin API, metrics and pprof (CHAT_ADMIN_ADDR)
+
+Without CHAT_ADMIN_ADDR the admin API and metrics are served on the
+public addresses as before, and pprof is not served at all: profiles
+expose internals that should only ever be reachable on an internal
+interface.
+
+Addresses are TCP host:port pairs, or Unix sockets written as
+"unix:/run/chat/chat.sock" for a reverse proxy on the same host. A
+stale socket file left by a crashed process is replaced; any other
+file at that path is an error. Sockets are created with mode
+CHAT_SOCKET_MODE (default 0660) so only the owner and group, e.g. the
+proxy's, can connect.
+
+Under systemd socket activation, "systemd:NAME" serves the sockets
+passed in with FileDescriptorName=NAME (see activation.go).
+
+Every address is bound before any starts serving, so a port clash
+stops the process at startup instead of leaving it half up.
+*/
+
+// unixPrefix marks a Unix socket path in a listen address
+const unixPrefix = "unix:"
+
+// surface is one router served on a set of addresses
+type surface struct {
+	name    string
+	addrs   []string
+	handler http.Handler
+}
+
+// boundListener is one listening socket and the router it serves
+type boundListener struct {
+	surface  string
+	addr     string // Configured address it was bound for
+	listener net.Listener
+	handler  http.Handler
+}
+
+// bindAll binds every surface's addresses up front
+func bindAll(surfaces []surface, b *binder) ([]boundListener, error) {
+	var bound []boundListener
+	for _, s := range surfaces {
+		for _, addr := range s.addrs {
+			ls, err := b.listen(addr)
+			if err != nil {
+				for _, bl := range bound {
+					bl.listener.Close()
+				}
+				return nil, fmt.Errorf("%s listener: %w", s.name, err)
+			}
+			for _, l := range ls {
+				bound = append(bound, boundListener{surface: s.name, addr: addr, listener: l, handler: s.handler})
+			}
+		}
+	}
+	b.closeUnused()
+	return bound, nil
+}
+
+// serveAll serves every listener until one fails
+// It returns nil once all of them have been closed on purpose, e.g.
+// after handing them to an upgraded process
+func serveAll(bound []boundListener) error {
+	errs := make(chan error, len(bound))
+	for _, bl := range bound {
+		log.Printf("Serving %s API on %s", bl.surface, bl.listener.Addr())
+		go func(bl boundListener) {
+			err := http.Serve(bl.listener, bl.handler)
+			if errors.Is(err, net.ErrClosed) {
+				errs <- nil
+				return
+			}
+			errs <- fmt.Errorf("%s listener %s: %w", bl.surface, bl.listener.Addr(), err)
+		}(bl)
+	}
+	for range bound {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// binder turns listen addresses into listeners
+type binder struct {
+	socketMode fs.FileMode
+	activated  map[string][]net.Listener // Sockets passed in by systemd, by name
+	inherited  map[string][]net.Listener // Sockets passed by the process we upgraded, by address
+}
+
+// listen binds a TCP address or a "unix:" socket path, or claims the
+// sockets systemd passed in under a "systemd:" name
+// Sockets inherited from an upgraded process are reused as they are
+func (b *binder) listen(addr string) ([]net.Listener, error) {
+	if ls, ok := b.inherited[addr]; ok {
+		delete(b.inherited, addr)
+		return ls, nil
+	}
+	if name, ok := strings.CutPrefix(addr, systemdPrefix); ok {
+		ls, ok := b.activated[name]
+		if !ok {
+			return nil, fmt.Errorf("no socket named %q was passed by systemd (have %v)", name, activatedNames(b.activated))
+		}
+		delete(b.activated, name)
+		return ls, nil
+	}
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		l, err := listenUnix(path, b.socketMode)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{l}, nil
+}
+
+// closeUnused closes passed-in sockets no address asked for
+func (b *binder) closeUnused() {
+	for addr, ls := range b.inherited {
+		log.Printf("Closing inherited socket %s: no longer configured", addr)
+		for _, l := range ls {
+			l.Close()
+		}
+		delete(b.inherited, addr)
+	}
+	for name, ls := range b.activated {
+		log.Printf("Closing systemd socket %q: not named in CHAT_ADDR or CHAT_ADMIN_ADDR", name)
+		for _, l := range ls {
+			l.Close()
+		}
+		delete(b.activated, name)
+	}
+}
+
+// listenUnix binds a Unix socket at path
+func listenUnix(path string, socketMode fs.FileMode) (net.Listener, error) {
+	// Step 1: Clear a socket left behind by a previous process
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// A socket someone still answers on isn't stale
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// Step 2: Bind and restrict who may connect
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("set socket mode: %w", err)
+	}
+	return l, nil
+}
+
+// registerPprof serves the runtime profiles under /debug/pprof
+func registerPprof(r gin.IRouter) {
+	handler := func(c *gin.Context) {
+		switch strings.TrimPrefix(c.Param("name"), "/") {
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			// The index, and named profiles such as heap or goroutine
+			pprof.Index(c.Writer, c.Request)
+		}
+	}
+	r.GET("/debug/pprof/*name", handler)
+	r.POST("/debug/pprof/*name", handler)
+}
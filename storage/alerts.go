@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+Keyword Alert Overview:
+----------------------
+A moderator can watch a room for keywords or regular expressions:
+
+	{"keywords": ["giveaway", "free nitro"], "patterns": ["(?i)discord\\.gg/\\w+"]}
+
+keywords  Matched as whole words, ignoring case
+patterns  Go regular expressions (RE2 syntax), matched as written
+
+Each moderator has at most one subscription per room. The hub sends
+a keyword_alert to the moderator when a message matches.
+*/
+
+// Limits on one subscription, so matching stays cheap
+const (
+	MaxAlertTerms      = 50
+	MaxAlertPatternLen = 200
+)
+
+// KeywordAlert is one moderator's watch list for one room
+type KeywordAlert struct {
+	Room      string    `json:"room"`
+	Moderator string    `json:"moderator"`
+	Keywords  []string  `json:"keywords,omitempty"`
+	Patterns  []string  `json:"patterns,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate trims the keywords and checks that every pattern compiles
+func (a *KeywordAlert) Validate() error {
+	if len(a.Keywords)+len(a.Patterns) == 0 {
+		return errors.New("set at least one keyword or pattern")
+	}
+	if len(a.Keywords)+len(a.Patterns) > MaxAlertTerms {
+		return fmt.Errorf("at most %d keywords and patterns", MaxAlertTerms)
+	}
+	for i, kw := range a.Keywords {
+		if a.Keywords[i] = strings.TrimSpace(kw); a.Keywords[i] == "" {
+			return errors.New("keywords must not be blank")
+		}
+	}
+	for _, p := range a.Patterns {
+		if len(p) > MaxAlertPatternLen {
+			return fmt.Errorf("patterns must be at most %d characters", MaxAlertPatternLen)
+		}
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
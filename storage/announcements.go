@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+Announcement Overview:
+---------------------
+Admins can post a message to one or more rooms on a recurring
+schedule, e.g. a standup reminder every weekday morning:
+
+	{"rooms": ["team"], "schedule": "0 9 * * mon-fri",
+	 "timezone": "Europe/Berlin", "content": "Standup in 5 minutes!"}
+
+tags may stand in for rooms, or add to them: the announcement goes to
+every room with any of them, looked up at each run (see tags.go).
+schedule is a five-field cron expression (see the schedule package),
+evaluated in timezone (an IANA name; UTC when empty). LastRun records
+the last time it fired so restarts neither repeat nor lose a run.
+*/
+
+// Limits on one announcement
+const (
+	MaxAnnouncementRooms   = 50
+	MaxAnnouncementContent = 2000
+)
+
+// Announcement is a recurring message to some rooms
+type Announcement struct {
+	ID        string    `json:"id"`
+	Rooms     []string  `json:"rooms"`
+	Tags      []string  `json:"tags,omitempty"`     // Rooms with any of these tags too
+	Schedule  string    `json:"schedule"`           // Cron expression
+	Timezone  string    `json:"timezone,omitempty"` // IANA name; UTC when empty
+	From      string    `json:"from,omitempty"`     // Sender name; defaults to "system"
+	Content   string    `json:"content"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	LastRun   time.Time `json:"last_run,omitempty"` // Zero until it first fires
+}
+
+// Location returns the time zone the schedule is evaluated in
+func (a Announcement) Location() (*time.Location, error) {
+	if a.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(a.Timezone)
+}
+
+// Validate checks everything but the schedule, which the caller parses
+func (a *Announcement) Validate() error {
+	if len(a.Rooms) == 0 && len(a.Tags) == 0 {
+		return errors.New("set at least one room or tag")
+	}
+	if len(a.Rooms) > MaxAnnouncementRooms {
+		return fmt.Errorf("at most %d rooms", MaxAnnouncementRooms)
+	}
+	for i, room := range a.Rooms {
+		if a.Rooms[i] = strings.TrimSpace(room); a.Rooms[i] == "" {
+			return errors.New("rooms must not be blank")
+		}
+	}
+	tags, err := NormalizeTags(a.Tags)
+	if err != nil {
+		return err
+	}
+	a.Tags = tags
+	if strings.TrimSpace(a.Content) == "" {
+		return errors.New("content is required")
+	}
+	if len(a.Content) > MaxAnnouncementContent {
+		return fmt.Errorf("content must be at most %d bytes", MaxAnnouncementContent)
+	}
+	if _, err := a.Location(); err != nil {
+		return fmt.Errorf("unknown timezone %q", a.Timezone)
+	}
+	return nil
+}
+
+// Targets lists the rooms a run of a goes to: its rooms, then those
+// tagged with any of its tags
+func (a Announcement) Targets(ctx context.Context, s Store) ([]string, error) {
+	rooms := slices.Clone(a.Rooms)
+	for _, tag := range a.Tags {
+		tagged, err := RoomsTagged(ctx, s, tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, room := range tagged {
+			if !slices.Contains(rooms, room) {
+				rooms = append(rooms, room)
+			}
+		}
+	}
+	return rooms, nil
+}
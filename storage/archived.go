@@ -0,0 +1,24 @@
+package storage
+
+import "time"
+
+/*
+Archived Room Overview:
+----------------------
+Moderators and admins can archive a room they are done with. It
+becomes read-only: members can still join and read its history, but
+nothing new can be posted. The room's settings record who archived it
+and when:
+
+	{"room": "launch-2023", ..., "archived": {"at": "2024-06-10T09:00:00Z", "by": "sam"}}
+
+Unarchiving removes the field. Not to be confused with history
+archives, which move expired messages to object storage (see
+chat-app/archive).
+*/
+
+// Archival records that a room was archived
+type Archival struct {
+	At time.Time `json:"at"`
+	By string    `json:"by,omitempty"` // The moderator, or "admin"
+}
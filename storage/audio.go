@@ -0,0 +1,37 @@
+package storage
+
+import "time"
+
+/*
+Audio Overview:
+--------------
+Voice notes are uploaded before they are posted. An upload is checked
+(see the audio package) and stored as an AudioClip bound to the room
+and user it was uploaded for; posting an "audio" message then refers
+to it by ID and marks it posted:
+
+	{"id": "a1b2", "room": "lobby", "username": "alice",
+	 "content_type": "audio/ogg", "codec": "opus", "duration_ms": 4210,
+	 "size": 16923, "posted": true}
+
+Clips never posted are dropped once they are AudioUploadTTL old.
+Posted clips follow their room's "days" and "none" retention policies
+like its event log does.
+*/
+
+// AudioUploadTTL is how long an upload waits to be posted before it is dropped
+const AudioUploadTTL = time.Hour
+
+// AudioClip is an uploaded voice note
+type AudioClip struct {
+	ID          string    `json:"id"`
+	Room        string    `json:"room"`
+	Username    string    `json:"username"`
+	ContentType string    `json:"content_type"`
+	Codec       string    `json:"codec"`
+	DurationMs  int64     `json:"duration_ms"`
+	Size        int       `json:"size"`
+	Posted      bool      `json:"posted"`
+	CreatedAt   time.Time `json:"created_at"`
+	Data        []byte    `json:"data"`
+}
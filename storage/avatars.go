@@ -0,0 +1,26 @@
+package storage
+
+import "time"
+
+/*
+Avatar Overview:
+---------------
+Avatars are processed on upload (see the avatar package) into a set of
+square PNG renditions that share a content-addressed ID. Each
+rendition is an AvatarImage; a user's profile (User.Avatar) refers to
+the ID:
+
+	{"username": "alice", "first_seen": "...", "avatar": "5d41402abc4b2a76..."}
+
+Because an ID names its images forever, setting a new avatar never
+changes old images, it only points the user elsewhere. Users who
+upload the same picture share its images.
+*/
+
+// AvatarImage is one rendition of an avatar
+type AvatarImage struct {
+	ID        string    `json:"id"`
+	Size      int       `json:"size"` // Pixels across
+	Data      []byte    `json:"data"` // PNG
+	CreatedAt time.Time `json:"created_at"`
+}
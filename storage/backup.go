@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+/*
+Backup Overview:
+---------------
+A Snapshot is a backend-independent copy of everything in a Store.
+It is written as a single gzip-compressed JSON document so a backup
+taken from one backend can be restored into another:
+
+	{"version": 1, "created_at": "...", "rooms": [...], "members": [...],
+	 "messages": [...], "offline": [...], "events": [...],
+	 "reviews": [...], "bans": [...]}
+
+Readers reject versions newer than they understand.
+*/
+
+// SnapshotVersion is the snapshot format written by this build
+const SnapshotVersion = 1
+
+// Snapshot is a full, portable copy of a store
+type Snapshot struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Rooms     []RoomSettings `json:"rooms"`
+	Members   []Membership   `json:"members"`
+	Messages  []Message      `json:"messages"`
+	Offline   []OfflineQueue `json:"offline"`
+	Events    []Event        `json:"events"`
+	Reviews   []ReviewItem   `json:"reviews,omitempty"` // Absent from older backups
+	Bans      []Ban          `json:"bans,omitempty"`
+	Alerts    []KeywordAlert `json:"keyword_alerts,omitempty"`
+	Users     []User         `json:"users,omitempty"`
+	Onboarded []Onboarded    `json:"onboarded,omitempty"`
+
+	Announcements []Announcement      `json:"announcements,omitempty"`
+	StickerPacks  []StickerPack       `json:"sticker_packs,omitempty"`
+	StickerImages []StickerImage      `json:"sticker_images,omitempty"`
+	Audio         []AudioClip         `json:"audio,omitempty"`
+	Uploads       []Upload            `json:"uploads,omitempty"` // Records only; the files stay in object storage
+	Avatars       []AvatarImage       `json:"avatars,omitempty"`
+	Usage         []DailyUsage        `json:"usage,omitempty"` // Quota counters
+	Metering      []MeterRecord       `json:"metering,omitempty"`
+	Tenants       []Tenant            `json:"tenants,omitempty"` // With key hashes, so keys keep working
+	Sync          []SyncEntry         `json:"sync,omitempty"`
+	Notifications []NotificationPrefs `json:"notification_preferences,omitempty"`
+	Direct        []DirectMessage     `json:"direct,omitempty"`    // Queued for offline users
+	RoomKeys      []RoomKey           `json:"room_keys,omitempty"` // Wrapped, so encrypted content stays readable
+}
+
+// Membership records that a user has joined a room
+type Membership struct {
+	Room     string `json:"room"`
+	Username string `json:"username"`
+}
+
+// OfflineQueue holds the durable messages waiting for one user in one room
+type OfflineQueue struct {
+	Username   string   `json:"username"`
+	Room       string   `json:"room"`
+	MessageIDs []string `json:"message_ids"` // Oldest first
+}
+
+func newSnapshot() Snapshot {
+	return Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Rooms:     []RoomSettings{},
+		Members:   []Membership{},
+		Messages:  []Message{},
+		Offline:   []OfflineQueue{},
+		Events:    []Event{},
+	}
+}
+
+// sort orders every section so identical stores produce identical backups
+func (s *Snapshot) sort() {
+	sort.Slice(s.Rooms, func(i, j int) bool { return s.Rooms[i].Room < s.Rooms[j].Room })
+	sort.Slice(s.Members, func(i, j int) bool {
+		a, b := s.Members[i], s.Members[j]
+		return a.Room < b.Room || (a.Room == b.Room && a.Username < b.Username)
+	})
+	sort.Slice(s.Messages, func(i, j int) bool {
+		a, b := s.Messages[i], s.Messages[j]
+		return a.Room < b.Room || (a.Room == b.Room && a.Seq < b.Seq)
+	})
+	sort.Slice(s.Offline, func(i, j int) bool {
+		a, b := s.Offline[i], s.Offline[j]
+		return a.Room < b.Room || (a.Room == b.Room && a.Username < b.Username)
+	})
+	sort.Slice(s.Events, func(i, j int) bool {
+		a, b := s.Events[i], s.Events[j]
+		return a.Room < b.Room || (a.Room == b.Room && a.Offset < b.Offset)
+	})
+	sortReviewItems(s.Reviews)
+	sort.Slice(s.Bans, func(i, j int) bool {
+		a, b := s.Bans[i], s.Bans[j]
+		return a.Room < b.Room || (a.Room == b.Room && a.Username < b.Username)
+	})
+	sort.Slice(s.Alerts, func(i, j int) bool {
+		a, b := s.Alerts[i], s.Alerts[j]
+		return a.Room < b.Room || (a.Room == b.Room && a.Moderator < b.Moderator)
+	})
+	sort.Slice(s.Users, func(i, j int) bool { return s.Users[i].Username < s.Users[j].Username })
+	sort.Slice(s.Onboarded, func(i, j int) bool {
+		a, b := s.Onboarded[i], s.Onboarded[j]
+		return a.Room < b.Room || (a.Room == b.Room && a.Username < b.Username)
+	})
+	sortAnnouncements(s.Announcements)
+	sortStickerPacks(s.StickerPacks)
+	sort.Slice(s.StickerImages, func(i, j int) bool {
+		a, b := s.StickerImages[i], s.StickerImages[j]
+		return a.Pack < b.Pack || (a.Pack == b.Pack && a.Sticker < b.Sticker)
+	})
+	sort.Slice(s.Audio, func(i, j int) bool {
+		a, b := s.Audio[i], s.Audio[j]
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID)
+	})
+	sort.Slice(s.Uploads, func(i, j int) bool {
+		a, b := s.Uploads[i], s.Uploads[j]
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID)
+	})
+	sort.Slice(s.Avatars, func(i, j int) bool {
+		a, b := s.Avatars[i], s.Avatars[j]
+		return a.ID < b.ID || (a.ID == b.ID && a.Size < b.Size)
+	})
+	sortMeterRecords(s.Metering)
+	sortTenants(s.Tenants)
+	sort.Slice(s.Sync, func(i, j int) bool {
+		a, b := s.Sync[i], s.Sync[j]
+		return a.Username < b.Username || (a.Username == b.Username && a.Key < b.Key)
+	})
+	sort.Slice(s.Notifications, func(i, j int) bool { return s.Notifications[i].Username < s.Notifications[j].Username })
+	sortRoomKeys(s.RoomKeys)
+	sort.SliceStable(s.Direct, func(i, j int) bool { return s.Direct[i].To < s.Direct[j].To }) // Each queue stays oldest first
+	sort.Slice(s.Usage, func(i, j int) bool {
+		a, b := s.Usage[i], s.Usage[j]
+		return a.Day.Before(b.Day) || (a.Day.Equal(b.Day) && a.Username < b.Username)
+	})
+}
+
+// sortMeterRecords orders records by hour, then room and user
+func sortMeterRecords(records []MeterRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		return a.Room < b.Room || (a.Room == b.Room && a.Username < b.Username)
+	})
+}
+
+// sortTenants orders tenants oldest first
+func sortTenants(tenants []Tenant) {
+	sort.Slice(tenants, func(i, j int) bool {
+		a, b := tenants[i], tenants[j]
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID)
+	})
+}
+
+// sortStickerPacks orders packs oldest first
+func sortStickerPacks(packs []StickerPack) {
+	sort.Slice(packs, func(i, j int) bool {
+		a, b := packs[i], packs[j]
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID)
+	})
+}
+
+// sortAnnouncements orders announcements oldest first
+func sortAnnouncements(posts []Announcement) {
+	sort.Slice(posts, func(i, j int) bool {
+		a, b := posts[i], posts[j]
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID)
+	})
+}
+
+// sortReviewItems orders a review queue oldest first
+func sortReviewItems(items []ReviewItem) {
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID)
+	})
+}
+
+// WriteSnapshot encodes snap as compressed JSON
+func WriteSnapshot(w io.Writer, snap Snapshot) error {
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(snap); err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	return gz.Close()
+}
+
+// ReadSnapshot decodes a snapshot written by WriteSnapshot
+func ReadSnapshot(r io.Reader) (Snapshot, error) {
+	var snap Snapshot
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return snap, fmt.Errorf("open snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	if err := json.NewDecoder(gz).Decode(&snap); err != nil {
+		return snap, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return snap, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return snap, nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+Breakout Room Overview:
+----------------------
+A breakout is a short-lived room under another, its parent, named
+parent/name:
+
+	incident-123/warroom
+
+Its room settings record where it belongs and how long it may last,
+and once it has closed, a summary of what went on in it:
+
+	{"room": "incident-123/warroom", ...,
+	 "breakout": {"room": "incident-123/warroom", "parent": "incident-123", "created_by": "alice",
+	              "created_at": "...", "expires_at": "...",
+	              "closed": {"reason": "empty", "closed_at": "...", "messages": 37,
+	                         "participants": ["alice", "bob"]}}}
+
+The summary keeps counts and names only; what was said goes to the
+parent room's history, where it is encrypted like any other message
+(see websockets/breakouts.go). A closed breakout can be opened again
+under the same name.
+*/
+
+// BreakoutSeparator joins a parent room's name and a breakout's
+const BreakoutSeparator = "/"
+
+// Limits on breakouts
+const (
+	MaxBreakouts       = 20 // Open at once under one parent
+	DefaultBreakoutTTL = time.Hour
+	MaxBreakoutTTL     = 24 * time.Hour
+	MinBreakoutTTL     = time.Minute
+)
+
+// Why a breakout closed
+const (
+	BreakoutEmpty   = "empty"   // The last participant left
+	BreakoutExpired = "expired" // Its time ran out
+	BreakoutClosed  = "closed"  // Someone closed it
+)
+
+// breakoutNamePattern is what may follow the separator
+var breakoutNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,31}$`)
+
+// Breakout marks a room as a temporary breakout of another
+type Breakout struct {
+	Room      string           `json:"room"`
+	Parent    string           `json:"parent"`
+	CreatedBy string           `json:"created_by"`
+	CreatedAt time.Time        `json:"created_at"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Closed    *BreakoutSummary `json:"closed,omitempty"` // Set once it has closed
+}
+
+// BreakoutSummary is what a closed breakout leaves behind
+type BreakoutSummary struct {
+	Reason       string    `json:"reason"`              // BreakoutEmpty, BreakoutExpired or BreakoutClosed
+	ClosedBy     string    `json:"closed_by,omitempty"` // Who closed it, for BreakoutClosed
+	ClosedAt     time.Time `json:"closed_at"`
+	Messages     int       `json:"messages"`
+	Participants []string  `json:"participants"` // In the order they joined
+}
+
+// Open reports whether users may still join the breakout at now
+func (b *Breakout) Open(now time.Time) bool {
+	return b != nil && b.Closed == nil && now.Before(b.ExpiresAt)
+}
+
+// BreakoutRoom returns the name of parent's breakout called name, or an
+// error if name can't be one
+func BreakoutRoom(parent, name string) (string, error) {
+	if IsBreakout(parent) {
+		return "", fmt.Errorf("breakouts can't have breakouts of their own")
+	}
+	if !breakoutNamePattern.MatchString(name) {
+		return "", fmt.Errorf("breakout name %q must be 1 to 32 letters, digits, - or _", name)
+	}
+	return parent + BreakoutSeparator + name, nil
+}
+
+// IsBreakout reports whether room is named as a breakout
+func IsBreakout(room string) bool {
+	return strings.Contains(room, BreakoutSeparator)
+}
+
+// BreakoutParent returns the room a breakout belongs to by its name
+func BreakoutParent(room string) string {
+	parent, _, _ := strings.Cut(room, BreakoutSeparator)
+	return parent
+}
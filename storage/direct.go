@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+/*
+Direct Message Overview:
+-----------------------
+A direct message for a user who isn't connected waits in their queue
+until they next join any room. Messages from other servers keep the
+server they came from, so it can be told once they are delivered:
+
+	{"id": "9f2c...", "from": "alice@b.example", "to": "bob", "origin": "b.example",
+	 "content": "hi", "created_at": "..."}
+
+A user holds at most MaxQueuedDirect messages; past that new ones are
+refused with ErrDirectFull rather than dropping older ones unseen.
+*/
+
+// MaxQueuedDirect is the most direct messages waiting for one user
+const MaxQueuedDirect = 1000
+
+// ErrDirectFull is returned when queueing for a user who has MaxQueuedDirect
+var ErrDirectFull = errors.New("storage: too many queued direct messages")
+
+// DirectMessage is a direct message waiting for its recipient
+type DirectMessage struct {
+	ID        string    `json:"id"`
+	From      string    `json:"from"`             // As shown to the recipient; user@server when remote
+	To        string    `json:"to"`               // A local username
+	Origin    string    `json:"origin,omitempty"` // Server it came from; empty when sent here
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
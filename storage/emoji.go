@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-app/emoji"
+)
+
+/*
+Custom Emoji Overview:
+---------------------
+Each room can add its own :shortcodes: to the built-in ones, or
+override them, by mapping names to the text they expand to:
+
+	{"party": "🥳🎉", "shipit": "🐿️", "ok": "👌"}
+
+Names are 1-32 of a-z, 0-9, _, + and -. The chat-app/emoji package
+expands them as messages are read.
+*/
+
+// MaxCustomEmoji bounds a room's custom shortcodes
+const MaxCustomEmoji = 200
+
+// MaxCustomEmojiLength bounds what one shortcode expands to, in bytes
+const MaxCustomEmojiLength = 64
+
+// CustomEmoji maps a room's shortcode names to their expansions
+type CustomEmoji map[string]string
+
+// Validate checks the names and expansions
+func (e CustomEmoji) Validate() error {
+	if len(e) > MaxCustomEmoji {
+		return fmt.Errorf("at most %d custom emoji", MaxCustomEmoji)
+	}
+	for name, value := range e {
+		if !emoji.ValidName(name) {
+			return fmt.Errorf("invalid emoji name %q: use 1-32 of a-z, 0-9, _, + and -", name)
+		}
+		if strings.TrimSpace(value) == "" || len(value) > MaxCustomEmojiLength || !utf8.ValidString(value) {
+			return fmt.Errorf("emoji %q must expand to 1-%d bytes of text", name, MaxCustomEmojiLength)
+		}
+		// A colon could chain into another shortcode; control characters have no place in chat
+		if strings.ContainsRune(value, ':') || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("emoji %q may not contain colons or control characters", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package storage
+
+import "time"
+
+// Room event types recorded in the event log
+const (
+	EventMessage    = "message"
+	EventJoin       = "join"
+	EventLeave      = "leave"
+	EventTopic      = "topic"
+	EventPin        = "pin"
+	EventModeration = "moderation"
+	EventRecall     = "recall" // A sender took their message back
+)
+
+// Event is one entry in a room's append-only event log
+// Offsets are assigned by the store and increase by one per room
+type Event struct {
+	Room      string            `json:"room"`
+	Offset    uint64            `json:"offset"`
+	Type      string            `json:"type"`
+	Username  string            `json:"username,omitempty"`
+	MessageID string            `json:"message_id,omitempty"`
+	Seq       uint64            `json:"seq,omitempty"`
+	Content   string            `json:"content,omitempty"`
+	Data      map[string]string `json:"data,omitempty"` // Type-specific details
+	CreatedAt time.Time         `json:"created_at"`
+}
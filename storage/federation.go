@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+)
+
+/*
+Federation Policy Overview:
+--------------------------
+A room is shared with other chat servers (see the federation package)
+only if its settings name them:
+
+	{"servers": ["b.example", "c.example"]}
+
+Both sides must list each other: a server only sends a room's traffic
+to the servers it lists, and only accepts it from them. Names are the
+peers' CHAT_FEDERATION_NAME; servers that aren't configured as peers
+are ignored.
+*/
+
+// Limits on a room's federation policy
+const (
+	MaxFederatedServers = 32
+	MaxServerNameLen    = 253
+)
+
+// FederationPolicy lists the servers a room is shared with
+type FederationPolicy struct {
+	Servers []string `json:"servers,omitempty"`
+}
+
+// Allows reports whether the room is shared with server
+func (p FederationPolicy) Allows(server string) bool {
+	return slices.Contains(p.Servers, server)
+}
+
+// Validate checks the server names
+func (p FederationPolicy) Validate() error {
+	if len(p.Servers) > MaxFederatedServers {
+		return fmt.Errorf("a room can be shared with at most %d servers", MaxFederatedServers)
+	}
+	for _, server := range p.Servers {
+		if server == "" || len(server) > MaxServerNameLen {
+			return fmt.Errorf("server names must be 1-%d characters", MaxServerNameLen)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+/*
+Invite Overview:
+---------------
+A room can be made invite-only, so only users its owners let in may
+join:
+
+	{"only": true, "users": ["alice", "bob"],
+	 "tokens": [{"token": "9f2c...", "by": "alice", "expires": "2024-06-11T09:00:00Z"}]}
+
+only    Whether the room is invite-only; the list and tokens are kept
+        when it's turned off, for next time
+users   Usernames that may join
+tokens  Invite tokens; whoever joins with one is added to users, so
+        they can come back after it expires or is revoked. Tokens
+        without expires last until revoked
+
+Owners are the users holding the invite capability in the room (see
+Permissions), plus moderators; they can always join.
+*/
+
+// Limits on a room's invites
+const (
+	MaxInvitedUsers  = 1000
+	MaxInviteTokens  = 100
+	MaxInviteNameLen = 64
+)
+
+// InvitePolicy is who may join an invite-only room
+type InvitePolicy struct {
+	Only   bool          `json:"only,omitempty"`
+	Users  []string      `json:"users,omitempty"`
+	Tokens []InviteToken `json:"tokens,omitempty"`
+}
+
+// InviteToken lets whoever holds it join an invite-only room
+type InviteToken struct {
+	Token   string     `json:"token"`
+	By      string     `json:"by"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Invited reports whether username is on the list
+func (p InvitePolicy) Invited(username string) bool {
+	return slices.Contains(p.Users, username)
+}
+
+// Valid reports whether token is one of the room's, unexpired at now
+func (p InvitePolicy) Valid(token string, now time.Time) bool {
+	if token == "" {
+		return false
+	}
+	for _, t := range p.Tokens {
+		if t.Token == token {
+			return t.Expires == nil || now.Before(*t.Expires)
+		}
+	}
+	return false
+}
+
+// Add puts username on the list, reporting false if it already was
+func (p *InvitePolicy) Add(username string) bool {
+	if p.Invited(username) {
+		return false
+	}
+	p.Users = append(p.Users, username)
+	return true
+}
+
+// Remove takes username off the list, reporting false if it wasn't on it
+func (p *InvitePolicy) Remove(username string) bool {
+	i := slices.Index(p.Users, username)
+	if i < 0 {
+		return false
+	}
+	p.Users = slices.Delete(p.Users, i, i+1)
+	return true
+}
+
+// Revoke drops a token, reporting false if there was no such token
+func (p *InvitePolicy) Revoke(token string) bool {
+	i := slices.IndexFunc(p.Tokens, func(t InviteToken) bool { return t.Token == token })
+	if i < 0 {
+		return false
+	}
+	p.Tokens = slices.Delete(p.Tokens, i, i+1)
+	return true
+}
+
+// Prune drops tokens expired at now
+func (p *InvitePolicy) Prune(now time.Time) {
+	p.Tokens = slices.DeleteFunc(p.Tokens, func(t InviteToken) bool {
+		return t.Expires != nil && !now.Before(*t.Expires)
+	})
+}
+
+// Validate checks the list and tokens are within bounds
+func (p InvitePolicy) Validate() error {
+	if len(p.Users) > MaxInvitedUsers {
+		return fmt.Errorf("at most %d users may be invited", MaxInvitedUsers)
+	}
+	for _, username := range p.Users {
+		if username == "" || len(username) > MaxInviteNameLen {
+			return fmt.Errorf("invited usernames must be 1-%d characters", MaxInviteNameLen)
+		}
+	}
+	if len(p.Tokens) > MaxInviteTokens {
+		return fmt.Errorf("at most %d invite tokens", MaxInviteTokens)
+	}
+	for _, t := range p.Tokens {
+		if t.Token == "" {
+			return errors.New("invite tokens can't be empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+Join Policy Overview:
+--------------------
+Each room can gate who joins it and how fast, e.g. during a raid:
+
+	{"min_account_age": "24h", "per_minute": 30, "action": "queue", "max_wait": "30s"}
+
+min_account_age  Users must have been known to the server this long
+per_minute       Joins accepted per minute; bursts of that many pass at once
+action           What happens to joins over the rate: reject (default)
+                 refuses them with a retry-after hint; queue holds them
+                 until their turn, if it comes within max_wait
+max_wait         Longest a queued join waits; defaults to DefaultJoinWait
+
+An account's age is counted from the first time the server saw the
+username (see Store.FirstSeen) unless the embedder supplies creation
+times.
+*/
+
+// Join policy actions
+const (
+	JoinReject = "reject"
+	JoinQueue  = "queue"
+)
+
+// DefaultJoinWait is how long queued joins wait when max_wait is unset
+const DefaultJoinWait = 30 * time.Second
+
+// JoinPolicy is a room's account age gate and join rate limit
+type JoinPolicy struct {
+	MinAccountAge string `json:"min_account_age,omitempty"` // Duration, e.g. "24h"
+	PerMinute     int    `json:"per_minute,omitempty"`
+	Action        string `json:"action,omitempty"`   // JoinReject or JoinQueue; empty means reject
+	MaxWait       string `json:"max_wait,omitempty"` // Duration, for JoinQueue
+}
+
+// Enabled reports whether the policy restricts anything
+func (p JoinPolicy) Enabled() bool {
+	return p.MinAge() > 0 || p.PerMinute > 0
+}
+
+// MinAge is the minimum account age; 0 when unset
+func (p JoinPolicy) MinAge() time.Duration {
+	d, _ := time.ParseDuration(p.MinAccountAge)
+	return d
+}
+
+// Wait is how long a join may queue; 0 unless the action is JoinQueue
+func (p JoinPolicy) Wait() time.Duration {
+	if p.Action != JoinQueue {
+		return 0
+	}
+	if d, err := time.ParseDuration(p.MaxWait); err == nil && d > 0 {
+		return d
+	}
+	return DefaultJoinWait
+}
+
+// Validate checks the action, durations and rate
+func (p JoinPolicy) Validate() error {
+	switch p.Action {
+	case "", JoinReject, JoinQueue:
+	default:
+		return fmt.Errorf("unknown join action %q", p.Action)
+	}
+	for name, v := range map[string]string{"min_account_age": p.MinAccountAge, "max_wait": p.MaxWait} {
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			return fmt.Errorf("%s must be a duration such as 24h", name)
+		}
+	}
+	if p.PerMinute < 0 {
+		return errors.New("per_minute must not be negative")
+	}
+	return nil
+}
+
+// User is what the server remembers about a username
+type User struct {
+	Username  string    `json:"username"`
+	FirstSeen time.Time `json:"first_seen"`
+	Avatar    string    `json:"avatar,omitempty"` // ID of the user's avatar, see avatars.go
+
+	// When the user was confirmed to be an adult, for NSFW rooms (see metadata.go)
+	AgeConfirmed *time.Time `json:"age_confirmed,omitempty"`
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+Link Policy Overview:
+--------------------
+Each room can restrict the links posted in it:
+
+	{"deny": ["evil.example"], "action": "defang", "expand_shorteners": true}
+
+deny      Domains whose links violate the policy; subdomains match too
+allow     When set, links to any other domain violate the policy
+action    block (default) rejects the message; defang rewrites the
+          offending links so they can't be clicked, e.g.
+          hxxps://evil[.]example/path
+expand_shorteners
+          Resolve links from known URL shorteners (bit.ly, t.co, ...)
+          on the server and judge them by where they lead
+
+The chat-app/links package applies policies; violations are recorded
+in the room event log as moderation events.
+*/
+
+// Link policy actions
+const (
+	LinkBlock  = "block"
+	LinkDefang = "defang"
+)
+
+// LinkPolicy is a room's link allow/deny list
+type LinkPolicy struct {
+	Deny             []string `json:"deny,omitempty"`
+	Allow            []string `json:"allow,omitempty"`
+	Action           string   `json:"action,omitempty"` // LinkBlock or LinkDefang; empty means block
+	ExpandShorteners bool     `json:"expand_shorteners,omitempty"`
+}
+
+// Enabled reports whether the policy restricts anything
+func (p LinkPolicy) Enabled() bool {
+	return len(p.Deny) > 0 || len(p.Allow) > 0
+}
+
+// Validate checks the action and normalizes the domains to lower case
+func (p *LinkPolicy) Validate() error {
+	switch p.Action {
+	case "", LinkBlock, LinkDefang:
+	default:
+		return fmt.Errorf("unknown link action %q", p.Action)
+	}
+	for _, list := range [][]string{p.Deny, p.Allow} {
+		for i, domain := range list {
+			domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+			if domain == "" || strings.ContainsAny(domain, "/: ") {
+				return errors.New("link domains must be bare host names, e.g. example.com")
+			}
+			list[i] = domain
+		}
+	}
+	return nil
+}
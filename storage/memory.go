@@ -0,0 +1,1253 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Store; all data is lost on restart
+type Memory struct {
+	mu       sync.RWMutex
+	messages map[string]Message         // By message ID
+	members  map[string]map[string]bool // Room -> usernames
+	offline  map[offlineKey][]string    // Queued message IDs, oldest first
+	settings map[string]RoomSettings    // By room
+	events   map[string][]Event         // Room -> event log, oldest first
+	offsets  map[string]uint64          // Last event offset issued per room
+	reviews  map[string]ReviewItem      // Review queue by entry ID
+	bans     map[roomUserKey]Ban
+	alerts   map[alertKey]KeywordAlert
+	users    map[string]User           // By username
+	welcomed map[roomUserKey]time.Time // When each user was onboarded in each room
+	posts    map[string]Announcement   // Scheduled announcements by ID
+	packs    map[string]StickerPack    // Sticker packs by ID
+	images   map[stickerKey]StickerImage
+	audio    map[string]AudioClip // Voice notes by ID
+	uploads  map[string]Upload    // Attachment uploads by ID
+	avatars  map[avatarKey]AvatarImage
+	usage    map[usageKey]Usage // Daily quota counters
+	meter    map[meterKey]MeterRecord
+	tenants  map[string]Tenant               // By tenant ID
+	synced   map[string]map[string]SyncEntry // Username -> key -> entry
+	notify   map[string]NotificationPrefs    // By username
+	direct   map[string][]DirectMessage      // Recipient -> queue, oldest first
+	keys     map[roomKeyID]RoomKey           // Wrapped room data keys
+}
+
+type stickerKey struct {
+	pack    string
+	sticker string
+}
+
+type avatarKey struct {
+	id   string
+	size int
+}
+
+type alertKey struct {
+	room      string
+	moderator string
+}
+
+type roomUserKey struct {
+	room     string
+	username string
+}
+
+type offlineKey struct {
+	username string
+	room     string
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		messages: make(map[string]Message),
+		members:  make(map[string]map[string]bool),
+		offline:  make(map[offlineKey][]string),
+		settings: make(map[string]RoomSettings),
+		events:   make(map[string][]Event),
+		offsets:  make(map[string]uint64),
+		reviews:  make(map[string]ReviewItem),
+		bans:     make(map[roomUserKey]Ban),
+		alerts:   make(map[alertKey]KeywordAlert),
+		users:    make(map[string]User),
+		welcomed: make(map[roomUserKey]time.Time),
+		posts:    make(map[string]Announcement),
+		packs:    make(map[string]StickerPack),
+		images:   make(map[stickerKey]StickerImage),
+		audio:    make(map[string]AudioClip),
+		uploads:  make(map[string]Upload),
+		avatars:  make(map[avatarKey]AvatarImage),
+		usage:    make(map[usageKey]Usage),
+		meter:    make(map[meterKey]MeterRecord),
+		tenants:  make(map[string]Tenant),
+		synced:   make(map[string]map[string]SyncEntry),
+		notify:   make(map[string]NotificationPrefs),
+		direct:   make(map[string][]DirectMessage),
+		keys:     make(map[roomKeyID]RoomKey),
+	}
+}
+
+// SaveMessage implements Store
+func (m *Memory) SaveMessage(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[msg.ID] = msg
+	return nil
+}
+
+// GetMessage implements Store
+func (m *Memory) GetMessage(ctx context.Context, id string) (Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msg, ok := m.messages[id]
+	if !ok {
+		return Message{}, ErrNotFound
+	}
+	return msg, nil
+}
+
+// DeleteMessage implements Store
+// Offline queues may still name it; TakeOffline skips missing messages
+func (m *Memory) DeleteMessage(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.messages[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.messages, id)
+	return nil
+}
+
+// LastSeq implements Store
+func (m *Memory) LastSeq(ctx context.Context, room string) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var last uint64
+	for _, msg := range m.messages {
+		if msg.Room == room && msg.Seq > last {
+			last = msg.Seq
+		}
+	}
+	// Messages that weren't persisted still took a seq in the event log
+	for _, ev := range m.events[room] {
+		if ev.Seq > last {
+			last = ev.Seq
+		}
+	}
+	return last, nil
+}
+
+// MessagePage implements Store
+func (m *Memory) MessagePage(ctx context.Context, room string, before uint64, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var msgs []Message
+	for _, msg := range m.messages {
+		if msg.Room == room && msg.Seq > 0 && !msg.Hidden && (before == 0 || msg.Seq < before) {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Seq > msgs[j].Seq })
+	return msgs[:min(limit, len(msgs))], nil
+}
+
+// SetMessageHidden implements Store
+func (m *Memory) SetMessageHidden(ctx context.Context, id string, hidden bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.messages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	msg.Hidden = hidden
+	m.messages[id] = msg
+	return nil
+}
+
+// HideMessages implements Store
+func (m *Memory) HideMessages(ctx context.Context, room string, p Purge) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hidden := 0
+	for id, msg := range m.messages {
+		if msg.Room == room && !msg.Hidden && p.Matches(msg.Username, msg.CreatedAt) {
+			msg.Hidden = true
+			m.messages[id] = msg
+			hidden++
+		}
+	}
+	return hidden, nil
+}
+
+// AddMember implements Store
+func (m *Memory) AddMember(ctx context.Context, room, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.members[room] == nil {
+		m.members[room] = make(map[string]bool)
+	}
+	m.members[room][username] = true
+	return nil
+}
+
+// Members implements Store
+func (m *Memory) Members(ctx context.Context, room string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := make([]string, 0, len(m.members[room]))
+	for u := range m.members[room] {
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// QueueOffline implements Store
+func (m *Memory) QueueOffline(ctx context.Context, username, room, messageID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := offlineKey{username: username, room: room}
+	m.offline[k] = append(m.offline[k], messageID)
+	return nil
+}
+
+// TakeOffline implements Store
+func (m *Memory) TakeOffline(ctx context.Context, username, room string) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := offlineKey{username: username, room: room}
+	ids := m.offline[k]
+	delete(m.offline, k)
+
+	msgs := make([]Message, 0, len(ids))
+	for _, id := range ids {
+		if msg, ok := m.messages[id]; ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// QueueDirect implements Store
+func (m *Memory) QueueDirect(ctx context.Context, dm DirectMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.direct[dm.To]) >= MaxQueuedDirect {
+		return ErrDirectFull
+	}
+	m.direct[dm.To] = append(m.direct[dm.To], dm)
+	return nil
+}
+
+// TakeDirect implements Store
+func (m *Memory) TakeDirect(ctx context.Context, username string) ([]DirectMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queued := m.direct[username]
+	delete(m.direct, username)
+	return queued, nil
+}
+
+// AppendEvent implements Store
+func (m *Memory) AppendEvent(ctx context.Context, ev Event) (Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.offsets[ev.Room]++
+	ev.Offset = m.offsets[ev.Room]
+	m.events[ev.Room] = append(m.events[ev.Room], ev)
+	return ev, nil
+}
+
+// Events implements Store
+func (m *Memory) Events(ctx context.Context, room string, after uint64, limit int) ([]Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	log := m.events[room]
+	// Offsets are increasing, so binary search for the first one past after
+	i := sort.Search(len(log), func(i int) bool { return log[i].Offset > after })
+	end := min(len(log), i+limit)
+	return append([]Event(nil), log[i:end]...), nil
+}
+
+// DeleteEventsBefore implements Store
+func (m *Memory) DeleteEventsBefore(ctx context.Context, room string, t time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	log := m.events[room]
+	// Events are appended in time order, so expired ones form a prefix
+	i := sort.Search(len(log), func(i int) bool { return !log[i].CreatedAt.Before(t) })
+	m.events[room] = append([]Event(nil), log[i:]...)
+	return i, nil
+}
+
+// GetRoomSettings implements Store
+func (m *Memory) GetRoomSettings(ctx context.Context, room string) (RoomSettings, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	settings, ok := m.settings[room]
+	if !ok {
+		return RoomSettings{}, ErrNotFound
+	}
+	return settings, nil
+}
+
+// SaveRoomSettings implements Store
+func (m *Memory) SaveRoomSettings(ctx context.Context, settings RoomSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settings[settings.Room] = settings
+	return nil
+}
+
+// ListRoomSettings implements Store
+func (m *Memory) ListRoomSettings(ctx context.Context) ([]RoomSettings, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := make([]RoomSettings, 0, len(m.settings))
+	for _, settings := range m.settings {
+		all = append(all, settings)
+	}
+	return all, nil
+}
+
+// SaveReviewItem implements Store
+func (m *Memory) SaveReviewItem(ctx context.Context, item ReviewItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reviews[item.ID] = item
+	return nil
+}
+
+// GetReviewItem implements Store
+func (m *Memory) GetReviewItem(ctx context.Context, id string) (ReviewItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	item, ok := m.reviews[id]
+	if !ok {
+		return ReviewItem{}, ErrNotFound
+	}
+	return item, nil
+}
+
+// ReviewItems implements Store
+func (m *Memory) ReviewItems(ctx context.Context, limit int) ([]ReviewItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	items := make([]ReviewItem, 0, len(m.reviews))
+	for _, item := range m.reviews {
+		items = append(items, item)
+	}
+	sortReviewItems(items)
+	return items[:min(limit, len(items))], nil
+}
+
+// DeleteReviewItem implements Store
+func (m *Memory) DeleteReviewItem(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.reviews[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.reviews, id)
+	return nil
+}
+
+// SaveBan implements Store
+func (m *Memory) SaveBan(ctx context.Context, ban Ban) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bans[roomUserKey{ban.Room, ban.Username}] = ban
+	return nil
+}
+
+// GetBan implements Store
+func (m *Memory) GetBan(ctx context.Context, room, username string) (Ban, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ban, ok := m.bans[roomUserKey{room, username}]
+	if !ok {
+		return Ban{}, ErrNotFound
+	}
+	return ban, nil
+}
+
+// Bans implements Store
+func (m *Memory) Bans(ctx context.Context, room string) ([]Ban, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bans := []Ban{}
+	for k, ban := range m.bans {
+		if k.room == room {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Username < bans[j].Username })
+	return bans, nil
+}
+
+// DeleteBan implements Store
+func (m *Memory) DeleteBan(ctx context.Context, room, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := roomUserKey{room, username}
+	if _, ok := m.bans[k]; !ok {
+		return ErrNotFound
+	}
+	delete(m.bans, k)
+	return nil
+}
+
+// SaveKeywordAlert implements Store
+func (m *Memory) SaveKeywordAlert(ctx context.Context, alert KeywordAlert) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alerts[alertKey{alert.Room, alert.Moderator}] = alert
+	return nil
+}
+
+// KeywordAlerts implements Store
+func (m *Memory) KeywordAlerts(ctx context.Context, room string) ([]KeywordAlert, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	alerts := []KeywordAlert{}
+	for k, alert := range m.alerts {
+		if k.room == room {
+			alerts = append(alerts, alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Moderator < alerts[j].Moderator })
+	return alerts, nil
+}
+
+// DeleteKeywordAlert implements Store
+func (m *Memory) DeleteKeywordAlert(ctx context.Context, room, moderator string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := alertKey{room, moderator}
+	if _, ok := m.alerts[k]; !ok {
+		return ErrNotFound
+	}
+	delete(m.alerts, k)
+	return nil
+}
+
+// FirstSeen implements Store
+func (m *Memory) FirstSeen(ctx context.Context, username string, now time.Time) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		user = User{Username: username, FirstSeen: now}
+		m.users[username] = user
+	}
+	return user.FirstSeen, nil
+}
+
+// GetUser implements Store
+func (m *Memory) GetUser(ctx context.Context, username string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[username]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+// SetAvatar implements Store
+func (m *Memory) SetAvatar(ctx context.Context, username, id string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		user = User{Username: username, FirstSeen: now}
+	}
+	user.Avatar = id
+	m.users[username] = user
+	return nil
+}
+
+// SetAgeConfirmed implements Store
+func (m *Memory) SetAgeConfirmed(ctx context.Context, username string, confirmed bool, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		user = User{Username: username, FirstSeen: now}
+	}
+	user.AgeConfirmed = nil
+	if confirmed {
+		user.AgeConfirmed = &now
+	}
+	m.users[username] = user
+	return nil
+}
+
+// MarkOnboarded implements Store
+func (m *Memory) MarkOnboarded(ctx context.Context, room, username string, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := roomUserKey{room, username}
+	if _, ok := m.welcomed[k]; ok {
+		return false, nil
+	}
+	m.welcomed[k] = now
+	return true, nil
+}
+
+// SaveAnnouncement implements Store
+func (m *Memory) SaveAnnouncement(ctx context.Context, a Announcement) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.posts[a.ID] = a
+	return nil
+}
+
+// GetAnnouncement implements Store
+func (m *Memory) GetAnnouncement(ctx context.Context, id string) (Announcement, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.posts[id]
+	if !ok {
+		return Announcement{}, ErrNotFound
+	}
+	return a, nil
+}
+
+// Announcements implements Store
+func (m *Memory) Announcements(ctx context.Context) ([]Announcement, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	posts := make([]Announcement, 0, len(m.posts))
+	for _, a := range m.posts {
+		posts = append(posts, a)
+	}
+	sortAnnouncements(posts)
+	return posts, nil
+}
+
+// MarkAnnouncementRun implements Store
+func (m *Memory) MarkAnnouncementRun(ctx context.Context, id string, last, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.posts[id]
+	if !ok || !a.LastRun.Equal(last) {
+		return ErrNotFound
+	}
+	a.LastRun = at
+	m.posts[id] = a
+	return nil
+}
+
+// DeleteAnnouncement implements Store
+func (m *Memory) DeleteAnnouncement(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.posts[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.posts, id)
+	return nil
+}
+
+// SaveStickerPack implements Store
+func (m *Memory) SaveStickerPack(ctx context.Context, p StickerPack) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.packs[p.ID] = p
+	return nil
+}
+
+// GetStickerPack implements Store
+func (m *Memory) GetStickerPack(ctx context.Context, id string) (StickerPack, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.packs[id]
+	if !ok {
+		return StickerPack{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// StickerPacks implements Store
+func (m *Memory) StickerPacks(ctx context.Context) ([]StickerPack, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	packs := make([]StickerPack, 0, len(m.packs))
+	for _, p := range m.packs {
+		packs = append(packs, p)
+	}
+	sortStickerPacks(packs)
+	return packs, nil
+}
+
+// DeleteStickerPack implements Store
+func (m *Memory) DeleteStickerPack(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.packs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.packs, id)
+	for k := range m.images {
+		if k.pack == id {
+			delete(m.images, k)
+		}
+	}
+	return nil
+}
+
+// SaveStickerImage implements Store
+func (m *Memory) SaveStickerImage(ctx context.Context, img StickerImage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.images[stickerKey{img.Pack, img.Sticker}] = img
+	return nil
+}
+
+// GetStickerImage implements Store
+func (m *Memory) GetStickerImage(ctx context.Context, pack, sticker string) (StickerImage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	img, ok := m.images[stickerKey{pack, sticker}]
+	if !ok {
+		return StickerImage{}, ErrNotFound
+	}
+	return img, nil
+}
+
+// DeleteStickerImage implements Store
+func (m *Memory) DeleteStickerImage(ctx context.Context, pack, sticker string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.images, stickerKey{pack, sticker})
+	return nil
+}
+
+// SaveAudio implements Store
+func (m *Memory) SaveAudio(ctx context.Context, clip AudioClip) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audio[clip.ID] = clip
+	return nil
+}
+
+// GetAudio implements Store
+func (m *Memory) GetAudio(ctx context.Context, id string) (AudioClip, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	clip, ok := m.audio[id]
+	if !ok {
+		return AudioClip{}, ErrNotFound
+	}
+	return clip, nil
+}
+
+// MarkAudioPosted implements Store
+func (m *Memory) MarkAudioPosted(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clip, ok := m.audio[id]
+	if !ok {
+		return ErrNotFound
+	}
+	clip.Posted = true
+	m.audio[id] = clip
+	return nil
+}
+
+// DeleteAudioBefore implements Store
+func (m *Memory) DeleteAudioBefore(ctx context.Context, room string, t time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for id, clip := range m.audio {
+		if clip.Posted && clip.Room == room && clip.CreatedAt.Before(t) {
+			delete(m.audio, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteUnpostedAudio implements Store
+func (m *Memory) DeleteUnpostedAudio(ctx context.Context, t time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for id, clip := range m.audio {
+		if !clip.Posted && clip.CreatedAt.Before(t) {
+			delete(m.audio, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// SaveUpload implements Store
+func (m *Memory) SaveUpload(ctx context.Context, u Upload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[u.ID] = u
+	return nil
+}
+
+// GetUpload implements Store
+func (m *Memory) GetUpload(ctx context.Context, id string) (Upload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.uploads[id]
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	return u, nil
+}
+
+// MarkUploadPosted implements Store
+func (m *Memory) MarkUploadPosted(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uploads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Posted = true
+	m.uploads[id] = u
+	return nil
+}
+
+// UnpostedUploads implements Store
+func (m *Memory) UnpostedUploads(ctx context.Context, t time.Time) ([]Upload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var stale []Upload
+	for _, u := range m.uploads {
+		if !u.Posted && u.CreatedAt.Before(t) {
+			stale = append(stale, u)
+		}
+	}
+	return stale, nil
+}
+
+// DeleteUpload implements Store
+func (m *Memory) DeleteUpload(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, id)
+	return nil
+}
+
+// SaveAvatar implements Store
+func (m *Memory) SaveAvatar(ctx context.Context, images []AvatarImage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, img := range images {
+		key := avatarKey{img.ID, img.Size}
+		if _, ok := m.avatars[key]; !ok {
+			m.avatars[key] = img
+		}
+	}
+	return nil
+}
+
+// GetAvatar implements Store
+func (m *Memory) GetAvatar(ctx context.Context, id string, size int) (AvatarImage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	img, ok := m.avatars[avatarKey{id, size}]
+	if !ok {
+		return AvatarImage{}, ErrNotFound
+	}
+	return img, nil
+}
+
+// AddUsage implements Store
+func (m *Memory) AddUsage(ctx context.Context, username string, day time.Time, delta, limits Usage) (Usage, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := usageKey{username, day.UTC()}
+	total := m.usage[k].Add(delta)
+	if !total.Fits(limits) {
+		return m.usage[k], false, nil
+	}
+	m.usage[k] = total
+	return total, true, nil
+}
+
+// GetUsage implements Store
+func (m *Memory) GetUsage(ctx context.Context, username string, day time.Time) (Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.usage[usageKey{username, day.UTC()}], nil
+}
+
+// DeleteUsageBefore implements Store
+func (m *Memory) DeleteUsageBefore(ctx context.Context, day time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for k := range m.usage {
+		if k.day.Before(day) {
+			delete(m.usage, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// AddMeterRecords implements Store
+func (m *Memory) AddMeterRecords(ctx context.Context, records []MeterRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range records {
+		r.Hour = r.Hour.UTC()
+		k := meterKey{r.Room, r.Username, r.Hour}
+		stored, ok := m.meter[k]
+		if ok {
+			r.Messages += stored.Messages
+			r.Bytes += stored.Bytes
+			r.ConnectionSeconds += stored.ConnectionSeconds
+			r.PeakConnections = max(r.PeakConnections, stored.PeakConnections)
+		}
+		m.meter[k] = r
+	}
+	return nil
+}
+
+// MeterRecords implements Store
+func (m *Memory) MeterRecords(ctx context.Context, from, to time.Time) ([]MeterRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var records []MeterRecord
+	for _, r := range m.meter {
+		if !r.Hour.Before(from) && r.Hour.Before(to) {
+			records = append(records, r)
+		}
+	}
+	sortMeterRecords(records)
+	return records, nil
+}
+
+// RoomMeterRecords implements Store
+func (m *Memory) RoomMeterRecords(ctx context.Context, room string, from, to time.Time) ([]MeterRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var records []MeterRecord
+	for _, r := range m.meter {
+		if r.Room == room && !r.Hour.Before(from) && r.Hour.Before(to) {
+			records = append(records, r)
+		}
+	}
+	sortMeterRecords(records)
+	return records, nil
+}
+
+// DeleteMeterRecordsBefore implements Store
+func (m *Memory) DeleteMeterRecordsBefore(ctx context.Context, t time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for k := range m.meter {
+		if k.hour.Before(t) {
+			delete(m.meter, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// SaveTenant implements Store
+func (m *Memory) SaveTenant(ctx context.Context, t Tenant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tenants[t.ID] = t
+	return nil
+}
+
+// GetTenant implements Store
+func (m *Memory) GetTenant(ctx context.Context, id string) (Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.tenants[id]
+	if !ok {
+		return Tenant{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// TenantByKey implements Store
+func (m *Memory) TenantByKey(ctx context.Context, keyHash string) (Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, t := range m.tenants {
+		if t.KeyHash == keyHash {
+			return t, nil
+		}
+	}
+	return Tenant{}, ErrNotFound
+}
+
+// Tenants implements Store
+func (m *Memory) Tenants(ctx context.Context) ([]Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tenants := make([]Tenant, 0, len(m.tenants))
+	for _, t := range m.tenants {
+		tenants = append(tenants, t)
+	}
+	sortTenants(tenants)
+	return tenants, nil
+}
+
+// DeleteTenant implements Store
+func (m *Memory) DeleteTenant(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.tenants, id)
+	return nil
+}
+
+// GetNotificationPrefs implements Store
+func (m *Memory) GetNotificationPrefs(ctx context.Context, username string) (NotificationPrefs, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.notify[username]
+	if !ok {
+		return NotificationPrefs{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// SaveNotificationPrefs implements Store
+func (m *Memory) SaveNotificationPrefs(ctx context.Context, p NotificationPrefs) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notify[p.Username] = p
+	return nil
+}
+
+// SaveRoomKey implements Store
+func (m *Memory) SaveRoomKey(ctx context.Context, k RoomKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[roomKeyID{k.Room, k.Version}] = k
+	return nil
+}
+
+// RoomKeys implements Store
+func (m *Memory) RoomKeys(ctx context.Context, room string) ([]RoomKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var keys []RoomKey
+	for id, k := range m.keys {
+		if id.room == room {
+			keys = append(keys, k)
+		}
+	}
+	sortRoomKeys(keys)
+	return keys, nil
+}
+
+// AllRoomKeys implements Store
+func (m *Memory) AllRoomKeys(ctx context.Context) ([]RoomKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]RoomKey, 0, len(m.keys))
+	for _, k := range m.keys {
+		keys = append(keys, k)
+	}
+	sortRoomKeys(keys)
+	return keys, nil
+}
+
+// SyncEntries implements Store
+func (m *Memory) SyncEntries(ctx context.Context, username string) ([]SyncEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make([]SyncEntry, 0, len(m.synced[username]))
+	for _, e := range m.synced[username] {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// SaveSyncEntry implements Store
+func (m *Memory) SaveSyncEntry(ctx context.Context, e SyncEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.synced[e.Username]
+	if entries == nil {
+		entries = make(map[string]SyncEntry)
+		m.synced[e.Username] = entries
+	}
+	if _, ok := entries[e.Key]; !ok && len(entries) >= MaxSyncKeys {
+		return ErrSyncFull
+	}
+	entries[e.Key] = e
+	return nil
+}
+
+// DeleteSyncEntry implements Store
+func (m *Memory) DeleteSyncEntry(ctx context.Context, username, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.synced[username][key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.synced[username], key)
+	if len(m.synced[username]) == 0 {
+		delete(m.synced, username)
+	}
+	return nil
+}
+
+// MessagesBefore implements Store
+func (m *Memory) MessagesBefore(ctx context.Context, room string, t time.Time) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var msgs []Message
+	for _, msg := range m.messages {
+		if msg.Room == room && msg.CreatedAt.Before(t) {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Seq < msgs[j].Seq })
+	return msgs, nil
+}
+
+// DeleteMessagesBefore implements Store
+func (m *Memory) DeleteMessagesBefore(ctx context.Context, room string, t time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for id, msg := range m.messages {
+		if msg.Room == room && msg.CreatedAt.Before(t) {
+			delete(m.messages, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// TrimMessages implements Store
+func (m *Memory) TrimMessages(ctx context.Context, room string, keep int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var msgs []Message
+	for _, msg := range m.messages {
+		if msg.Room == room {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) <= keep {
+		return 0, nil
+	}
+
+	// Newest first, then drop everything past keep
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Seq > msgs[j].Seq })
+	for _, msg := range msgs[keep:] {
+		delete(m.messages, msg.ID)
+	}
+	return len(msgs) - keep, nil
+}
+
+// PurgeMessages implements Store
+func (m *Memory) PurgeMessages(ctx context.Context, room string, p Purge) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for id, msg := range m.messages {
+		if msg.Room == room && p.Matches(msg.Username, msg.CreatedAt) {
+			delete(m.messages, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Snapshot implements Store
+func (m *Memory) Snapshot(ctx context.Context) (Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snap := newSnapshot()
+	for _, settings := range m.settings {
+		snap.Rooms = append(snap.Rooms, settings)
+	}
+	for room, users := range m.members {
+		for user := range users {
+			snap.Members = append(snap.Members, Membership{Room: room, Username: user})
+		}
+	}
+	for _, msg := range m.messages {
+		snap.Messages = append(snap.Messages, msg)
+	}
+	for k, ids := range m.offline {
+		snap.Offline = append(snap.Offline, OfflineQueue{Username: k.username, Room: k.room, MessageIDs: ids})
+	}
+	for _, log := range m.events {
+		snap.Events = append(snap.Events, log...)
+	}
+	for _, item := range m.reviews {
+		snap.Reviews = append(snap.Reviews, item)
+	}
+	for _, ban := range m.bans {
+		snap.Bans = append(snap.Bans, ban)
+	}
+	for _, alert := range m.alerts {
+		snap.Alerts = append(snap.Alerts, alert)
+	}
+	for _, user := range m.users {
+		snap.Users = append(snap.Users, user)
+	}
+	for k, at := range m.welcomed {
+		snap.Onboarded = append(snap.Onboarded, Onboarded{Room: k.room, Username: k.username, At: at})
+	}
+	for _, a := range m.posts {
+		snap.Announcements = append(snap.Announcements, a)
+	}
+	for _, p := range m.packs {
+		snap.StickerPacks = append(snap.StickerPacks, p)
+	}
+	for _, img := range m.images {
+		snap.StickerImages = append(snap.StickerImages, img)
+	}
+	for _, clip := range m.audio {
+		snap.Audio = append(snap.Audio, clip)
+	}
+	for _, u := range m.uploads {
+		snap.Uploads = append(snap.Uploads, u)
+	}
+	for _, img := range m.avatars {
+		snap.Avatars = append(snap.Avatars, img)
+	}
+	for _, r := range m.meter {
+		snap.Metering = append(snap.Metering, r)
+	}
+	for k, u := range m.usage {
+		snap.Usage = append(snap.Usage, DailyUsage{Username: k.username, Day: k.day, Usage: u})
+	}
+	for _, t := range m.tenants {
+		snap.Tenants = append(snap.Tenants, t)
+	}
+	for _, entries := range m.synced {
+		for _, e := range entries {
+			snap.Sync = append(snap.Sync, e)
+		}
+	}
+	for _, p := range m.notify {
+		snap.Notifications = append(snap.Notifications, p)
+	}
+	for _, queue := range m.direct {
+		snap.Direct = append(snap.Direct, queue...)
+	}
+	for _, k := range m.keys {
+		snap.RoomKeys = append(snap.RoomKeys, k)
+	}
+	snap.sort()
+	return snap, nil
+}
+
+// Restore implements Store
+func (m *Memory) Restore(ctx context.Context, snap Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) > 0 || len(m.members) > 0 || len(m.offline) > 0 || len(m.settings) > 0 || len(m.events) > 0 ||
+		len(m.reviews) > 0 || len(m.bans) > 0 || len(m.alerts) > 0 || len(m.users) > 0 || len(m.welcomed) > 0 || len(m.posts) > 0 ||
+		len(m.packs) > 0 || len(m.images) > 0 || len(m.audio) > 0 ||
+		len(m.uploads) > 0 || len(m.avatars) > 0 || len(m.usage) > 0 || len(m.meter) > 0 || len(m.tenants) > 0 || len(m.synced) > 0 || len(m.notify) > 0 ||
+		len(m.direct) > 0 || len(m.keys) > 0 {
+		return ErrNotEmpty
+	}
+
+	for _, settings := range snap.Rooms {
+		m.settings[settings.Room] = settings
+	}
+	for _, mem := range snap.Members {
+		if m.members[mem.Room] == nil {
+			m.members[mem.Room] = make(map[string]bool)
+		}
+		m.members[mem.Room][mem.Username] = true
+	}
+	for _, msg := range snap.Messages {
+		m.messages[msg.ID] = msg
+	}
+	for _, q := range snap.Offline {
+		k := offlineKey{username: q.Username, room: q.Room}
+		m.offline[k] = append(m.offline[k], q.MessageIDs...)
+	}
+	for _, ev := range snap.Events {
+		m.events[ev.Room] = append(m.events[ev.Room], ev)
+		m.offsets[ev.Room] = max(m.offsets[ev.Room], ev.Offset)
+	}
+	for _, item := range snap.Reviews {
+		m.reviews[item.ID] = item
+	}
+	for _, ban := range snap.Bans {
+		m.bans[roomUserKey{ban.Room, ban.Username}] = ban
+	}
+	for _, alert := range snap.Alerts {
+		m.alerts[alertKey{alert.Room, alert.Moderator}] = alert
+	}
+	for _, user := range snap.Users {
+		m.users[user.Username] = user
+	}
+	for _, o := range snap.Onboarded {
+		m.welcomed[roomUserKey{o.Room, o.Username}] = o.At
+	}
+	for _, a := range snap.Announcements {
+		m.posts[a.ID] = a
+	}
+	for _, p := range snap.StickerPacks {
+		m.packs[p.ID] = p
+	}
+	for _, img := range snap.StickerImages {
+		m.images[stickerKey{img.Pack, img.Sticker}] = img
+	}
+	for _, clip := range snap.Audio {
+		m.audio[clip.ID] = clip
+	}
+	for _, u := range snap.Uploads {
+		m.uploads[u.ID] = u
+	}
+	for _, img := range snap.Avatars {
+		m.avatars[avatarKey{img.ID, img.Size}] = img
+	}
+	for _, r := range snap.Metering {
+		m.meter[meterKey{r.Room, r.Username, r.Hour.UTC()}] = r
+	}
+	for _, u := range snap.Usage {
+		m.usage[usageKey{u.Username, u.Day.UTC()}] = u.Usage
+	}
+	for _, t := range snap.Tenants {
+		m.tenants[t.ID] = t
+	}
+	for _, e := range snap.Sync {
+		if m.synced[e.Username] == nil {
+			m.synced[e.Username] = make(map[string]SyncEntry)
+		}
+		m.synced[e.Username][e.Key] = e
+	}
+	for _, p := range snap.Notifications {
+		m.notify[p.Username] = p
+	}
+	for _, dm := range snap.Direct {
+		m.direct[dm.To] = append(m.direct[dm.To], dm)
+	}
+	for _, k := range snap.RoomKeys {
+		m.keys[roomKeyID{k.Room, k.Version}] = k
+	}
+	return nil
+}
+
+// Close implements Store
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+Room Metadata Overview:
+----------------------
+Rooms can say what they are about, for discovery and for filters:
+
+	{"metadata": {"language": "pt-BR", "nsfw": true, "spoilers": true}}
+
+language  The room's primary language, a BCP 47 tag like "en" or "pt-BR"
+nsfw      Content not safe for work: only age-confirmed accounts may join
+spoilers  Talk may give away plots; discovery shows it, clients may warn
+
+Metadata is set next to the room's tags (see tags.go): by owners
+holding the tag capability, or by admins with the rest of the
+settings. The rooms list shows it and filters on it, and the join
+gate keeps users whose age isn't confirmed (see User.AgeConfirmed)
+out of NSFW rooms.
+*/
+
+// languagePattern is a primary language subtag and any further subtags
+var languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// RoomMetadata describes a room's language and content
+type RoomMetadata struct {
+	Language string `json:"language,omitempty"`
+	NSFW     bool   `json:"nsfw,omitempty"`
+	Spoilers bool   `json:"spoilers,omitempty"`
+}
+
+// Normalize returns m with its language tag in canonical case, e.g. "pt-BR",
+// or an error if it isn't one
+func (m RoomMetadata) Normalize() (RoomMetadata, error) {
+	if m.Language == "" {
+		return m, nil
+	}
+	if len(m.Language) > 35 || !languagePattern.MatchString(m.Language) {
+		return m, fmt.Errorf("language %q must be a BCP 47 tag such as en or pt-BR", m.Language)
+	}
+	parts := strings.Split(m.Language, "-")
+	for i, part := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(part)
+		case len(part) == 2:
+			parts[i] = strings.ToUpper(part) // Region
+		case len(part) == 4:
+			parts[i] = strings.ToUpper(part[:1]) + strings.ToLower(part[1:]) // Script
+		default:
+			parts[i] = strings.ToLower(part)
+		}
+	}
+	m.Language = strings.Join(parts, "-")
+	return m, nil
+}
+
+// Speaks reports whether the room's language is language or a variant
+// of it: "en" matches rooms in "en" and "en-GB", but "en-GB" only the latter
+func (m RoomMetadata) Speaks(language string) bool {
+	if language == "" {
+		return true
+	}
+	return strings.EqualFold(m.Language, language) ||
+		len(m.Language) > len(language) && strings.EqualFold(m.Language[:len(language)+1], language+"-")
+}
@@ -0,0 +1,42 @@
+package storage
+
+import "time"
+
+/*
+Metering Overview:
+-----------------
+The metering package counts what each user does in each room, for
+billing and usage reports, and adds it here an hour at a time:
+
+	{"room": "lobby", "username": "alice", "hour": "2024-06-10T14:00:00Z",
+	 "messages": 42, "bytes": 8190, "connection_seconds": 3600}
+
+AddMeterRecords adds to the counters already stored for the same
+room, user and hour, so every node sharing a store can flush its own
+share. Records are kept until DeleteMeterRecordsBefore removes them.
+
+Each room also gets a record without a username holding the most
+connections it had at once during the hour, for room analytics:
+
+	{"room": "lobby", "username": "", "hour": "2024-06-10T14:00:00Z", "peak_connections": 37}
+
+Peaks are kept at the largest stored, not added.
+*/
+
+// MeterRecord is one user's usage of one room during one hour
+type MeterRecord struct {
+	Room              string    `json:"room"`
+	Username          string    `json:"username"`
+	Hour              time.Time `json:"hour"` // Start of the hour, UTC
+	Messages          int64     `json:"messages"`
+	Bytes             int64     `json:"bytes"`
+	ConnectionSeconds int64     `json:"connection_seconds"`
+	PeakConnections   int64     `json:"peak_connections,omitempty"` // On the room's record, without a username
+}
+
+// meterKey identifies a record's counters
+type meterKey struct {
+	room     string
+	username string
+	hour     time.Time
+}
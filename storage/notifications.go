@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Notification Preferences Overview:
+---------------------------------
+Each user chooses, per room, which messages reach them as push or
+email notifications while they're away (see the notify package):
+
+	all        every message
+	mentions   messages naming them with @username, or @everyone
+	never      nothing
+
+	{"default": "mentions", "rooms": {"lobby": "all", "random": "never"}}
+
+Rooms without a level of their own use default, and users who never
+chose use DefaultNotify.
+
+A user can also mute a room for a while, or until they unmute it,
+without changing its level or leaving it; muted rooms send no
+notifications at all:
+
+	{"muted": {"random": {"until": "2024-06-10T17:00:00Z"}, "ops": {}}}
+
+And a user can set a daily do-not-disturb window, in their own time
+zone, during which the notify package holds their notifications and
+sends a summary once it ends. An empty dnd clears it:
+
+	{"dnd": {"start": "22:00", "end": "07:00", "timezone": "Europe/Berlin"}}
+
+Highlights are words that catch the user's attention like their own
+name, IRC style: a message containing one counts as a mention, and
+the hub sends a highlight frame to the user's connections. Setting
+highlights replaces the whole list:
+
+	{"highlights": ["deploy", "postgres"]}
+*/
+
+// Notification levels
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions"
+	NotifyNever    = "never"
+)
+
+// DefaultNotify is the level of users who never chose one
+const DefaultNotify = NotifyMentions
+
+// MaxNotifyRooms bounds the rooms one user sets a level for, or mutes
+const MaxNotifyRooms = 500
+
+// Limits on one user's highlight words, so matching stays cheap
+const (
+	MaxHighlights   = 50
+	MaxHighlightLen = 64
+)
+
+// NotificationPrefs is one user's notification levels
+type NotificationPrefs struct {
+	Username   string            `json:"username"`
+	Default    string            `json:"default"`
+	Rooms      map[string]string `json:"rooms,omitempty"`
+	Muted      map[string]Mute   `json:"muted,omitempty"`
+	DND        *DND              `json:"dnd,omitempty"`
+	Highlights []string          `json:"highlights,omitempty"`
+	UpdatedAt  time.Time         `json:"updated_at,omitempty"`
+}
+
+// Mute silences a room's notifications
+type Mute struct {
+	Until *time.Time `json:"until,omitempty"` // nil until unmuted
+}
+
+// DND is a daily do-not-disturb window; it may cross midnight
+type DND struct {
+	Start    string `json:"start"`              // "22:00"
+	End      string `json:"end"`                // "07:00"
+	Timezone string `json:"timezone,omitempty"` // IANA name; UTC when empty
+}
+
+// Location returns the time zone the window is in
+func (d DND) Location() (*time.Location, error) {
+	if d.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(d.Timezone)
+}
+
+// Quiet reports whether now falls inside the window
+func (d DND) Quiet(now time.Time) bool {
+	start, err1 := parseClock(d.Start)
+	end, err2 := parseClock(d.End)
+	loc, err3 := d.Location()
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// Validate checks both ends are HH:MM, differ, and the time zone is known
+func (d DND) Validate() error {
+	start, err := parseClock(d.Start)
+	if err != nil {
+		return fmt.Errorf("dnd start: %w", err)
+	}
+	end, err := parseClock(d.End)
+	if err != nil {
+		return fmt.Errorf("dnd end: %w", err)
+	}
+	if start == end {
+		return errors.New("dnd start and end must differ")
+	}
+	if _, err := d.Location(); err != nil {
+		return fmt.Errorf("unknown timezone %q", d.Timezone)
+	}
+	return nil
+}
+
+// parseClock returns the minute of the day a "15:04" time names
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time like 22:00", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Quiet reports whether p's do-not-disturb window covers now
+func (p NotificationPrefs) Quiet(now time.Time) bool {
+	return p.DND != nil && p.DND.Quiet(now)
+}
+
+// MutedIn reports whether p mutes room at now
+func (p NotificationPrefs) MutedIn(room string, now time.Time) bool {
+	m, ok := p.Muted[room]
+	return ok && (m.Until == nil || now.Before(*m.Until))
+}
+
+// Unmute drops room's mute and any that have run out by now
+func (p NotificationPrefs) Unmute(room string, now time.Time) NotificationPrefs {
+	muted := make(map[string]Mute, len(p.Muted))
+	for r, m := range p.Muted {
+		if r != room && (m.Until == nil || now.Before(*m.Until)) {
+			muted[r] = m
+		}
+	}
+	p.Muted = muted
+	return p
+}
+
+// Level returns the notification level p sets for room
+func (p NotificationPrefs) Level(room string) string {
+	if level, ok := p.Rooms[room]; ok {
+		return level
+	}
+	if p.Default != "" {
+		return p.Default
+	}
+	return DefaultNotify
+}
+
+// Merge applies a change to p: a non-empty default replaces p's, each
+// room's level replaces p's, an empty one dropping back to the
+// default, each mute is added, a dnd replaces p's, an empty one
+// clearing it, and highlights replace p's when given, even empty
+func (p NotificationPrefs) Merge(change NotificationPrefs) NotificationPrefs {
+	if change.Default != "" {
+		p.Default = change.Default
+	}
+	rooms := make(map[string]string, len(p.Rooms)+len(change.Rooms))
+	for room, level := range p.Rooms {
+		rooms[room] = level
+	}
+	for room, level := range change.Rooms {
+		if level == "" {
+			delete(rooms, room)
+			continue
+		}
+		rooms[room] = level
+	}
+	p.Rooms = rooms
+	if len(change.Muted) > 0 {
+		muted := make(map[string]Mute, len(p.Muted)+len(change.Muted))
+		for room, m := range p.Muted {
+			muted[room] = m
+		}
+		for room, m := range change.Muted {
+			muted[room] = m
+		}
+		p.Muted = muted
+	}
+	if change.DND != nil {
+		p.DND = change.DND
+		if *change.DND == (DND{}) {
+			p.DND = nil
+		}
+	}
+	if change.Highlights != nil {
+		p.Highlights = change.Highlights
+	}
+	return p
+}
+
+// Validate checks every level is known, the dnd window unless it is
+// empty, and trims the highlights
+func (p NotificationPrefs) Validate() error {
+	if len(p.Highlights) > MaxHighlights {
+		return fmt.Errorf("at most %d highlights", MaxHighlights)
+	}
+	for i, word := range p.Highlights {
+		if p.Highlights[i] = strings.TrimSpace(word); p.Highlights[i] == "" {
+			return errors.New("highlights must not be blank")
+		}
+		if len(p.Highlights[i]) > MaxHighlightLen {
+			return fmt.Errorf("highlights must be at most %d bytes", MaxHighlightLen)
+		}
+	}
+	if p.DND != nil && *p.DND != (DND{}) {
+		if err := p.DND.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Default != "" && !validNotifyLevel(p.Default) {
+		return fmt.Errorf("default must be one of %s, %s, %s", NotifyAll, NotifyMentions, NotifyNever)
+	}
+	if len(p.Rooms) > MaxNotifyRooms || len(p.Muted) > MaxNotifyRooms {
+		return fmt.Errorf("at most %d rooms", MaxNotifyRooms)
+	}
+	for room := range p.Muted {
+		if room == "" {
+			return errors.New("room names must not be empty")
+		}
+	}
+	for room, level := range p.Rooms {
+		if room == "" {
+			return errors.New("room names must not be empty")
+		}
+		if level != "" && !validNotifyLevel(level) {
+			return fmt.Errorf("level for %s must be one of %s, %s, %s", room, NotifyAll, NotifyMentions, NotifyNever)
+		}
+	}
+	return nil
+}
+
+// validNotifyLevel reports whether level is a known level
+func validNotifyLevel(level string) bool {
+	return level == NotifyAll || level == NotifyMentions || level == NotifyNever
+}
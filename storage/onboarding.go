@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+/*
+Onboarding Overview:
+-------------------
+Each room can greet first-time joiners with a short sequence of
+private messages:
+
+	{"from": "welcome-bot", "steps": [
+	    {"type": "rules", "content": "Be kind. No spam."},
+	    {"type": "link", "content": "Read the FAQ", "url": "https://example.com/faq"},
+	    {"type": "role_picker", "content": "What brings you here?", "choices": ["player", "modder"]}
+	]}
+
+Step types are text, rules, link (needs url) and role_picker (needs
+choices). {username} and {room} in content are filled in. A user is
+onboarded once per room, the first time they join after the flow is
+set up; later joins are quiet.
+*/
+
+// Onboarding step types
+const (
+	OnboardText       = "text"
+	OnboardRules      = "rules"
+	OnboardLink       = "link"
+	OnboardRolePicker = "role_picker"
+)
+
+// MaxOnboardingSteps bounds a flow so joining stays quick
+const MaxOnboardingSteps = 10
+
+// Onboarding is a room's welcome flow
+type Onboarding struct {
+	From  string           `json:"from,omitempty"` // Sender name on the messages; defaults to "system"
+	Steps []OnboardingStep `json:"steps,omitempty"`
+}
+
+// OnboardingStep is one welcome message
+type OnboardingStep struct {
+	Type    string   `json:"type"`
+	Content string   `json:"content"`
+	URL     string   `json:"url,omitempty"`     // For OnboardLink
+	Choices []string `json:"choices,omitempty"` // For OnboardRolePicker
+}
+
+// Enabled reports whether the room greets newcomers
+func (o Onboarding) Enabled() bool {
+	return len(o.Steps) > 0
+}
+
+// Validate checks every step has what its type needs
+func (o Onboarding) Validate() error {
+	if len(o.Steps) > MaxOnboardingSteps {
+		return fmt.Errorf("at most %d onboarding steps", MaxOnboardingSteps)
+	}
+	for i, step := range o.Steps {
+		if step.Content == "" {
+			return fmt.Errorf("onboarding step %d has no content", i+1)
+		}
+		switch step.Type {
+		case OnboardText, OnboardRules:
+		case OnboardLink:
+			if u, err := url.Parse(step.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("onboarding step %d needs an http(s) url", i+1)
+			}
+		case OnboardRolePicker:
+			if len(step.Choices) == 0 {
+				return fmt.Errorf("onboarding step %d needs choices", i+1)
+			}
+		case "":
+			return errors.New("every onboarding step needs a type")
+		default:
+			return fmt.Errorf("unknown onboarding step type %q", step.Type)
+		}
+	}
+	return nil
+}
+
+// Onboarded records that a user was shown a room's welcome flow
+type Onboarded struct {
+	Room     string    `json:"room"`
+	Username string    `json:"username"`
+	At       time.Time `json:"at"`
+}
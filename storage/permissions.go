@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+/*
+Permissions Overview:
+--------------------
+Some things members can do are capabilities, granted to roles room by
+room:
+
+	pin               pin and unpin messages
+	upload            upload attachments and voice notes
+	mention_everyone  post messages that mention @everyone
+	command           post slash commands, messages starting with /
+	invite            own an invite-only room: change who is invited and
+	                  make invite tokens (see InvitePolicy)
+	tag               label the room with tags and set its language and
+	                  content flags (see tags.go and metadata.go)
+	post              post in a broadcast room; everyone may post elsewhere
+	breakout          open and close the room's breakout rooms (see breakouts.go)
+	analytics         read the room's activity analytics (see the metering package)
+
+A room's permissions assign users roles and grant roles capabilities:
+
+	{"roles": {"alice": ["host"], "bob": ["host", "dj"]},
+	 "grants": {"member": ["upload"], "host": ["pin", "upload", "mention_everyone", "command", "invite"],
+	            "dj": ["command"]}}
+
+A broadcast room, such as a status or release-notes channel, is read
+only for everyone without the post capability, typically its owners
+and bots:
+
+	{"broadcast": true, "roles": {"alice": ["host"], "release-bot": ["bot"]},
+	 "grants": {"host": ["post", "pin"], "bot": ["post"], "member": ["upload"]}}
+
+Every user holds the member role; moderators (CHAT_MODERATORS) hold
+the moderator role, which has every capability in every room and
+can't be assigned or granted. Rooms without grants use DefaultGrants.
+The permission package checks them.
+*/
+
+// Capabilities
+const (
+	CapPin             = "pin"
+	CapUpload          = "upload"
+	CapMentionEveryone = "mention_everyone"
+	CapCommand         = "command"
+	CapInvite          = "invite"
+	CapTag             = "tag"
+	CapPost            = "post"
+	CapBreakout        = "breakout"
+	CapAnalytics       = "analytics"
+)
+
+// Capabilities lists every capability, in the order they are reported
+var Capabilities = []string{CapPin, CapUpload, CapMentionEveryone, CapCommand, CapInvite, CapTag, CapPost, CapBreakout, CapAnalytics}
+
+// Built-in roles
+const (
+	RoleMember    = "member"    // Everyone
+	RoleModerator = "moderator" // CHAT_MODERATORS, with every capability
+)
+
+// Limits on a room's permissions
+const (
+	MaxRoles       = 50 // Distinct custom roles
+	MaxRoleHolders = 1000
+	MaxRoleName    = 32
+)
+
+// DefaultGrants are used in rooms whose permissions set no grants,
+// and keep members doing what they could before there were permissions,
+// as well as opening breakout rooms
+var DefaultGrants = map[string][]string{
+	RoleMember: {CapUpload, CapCommand, CapBreakout},
+}
+
+// Permissions assigns a room's users roles and grants roles capabilities
+type Permissions struct {
+	Roles     map[string][]string `json:"roles,omitempty"`     // Username -> custom roles
+	Grants    map[string][]string `json:"grants,omitempty"`    // Role -> capabilities
+	Broadcast bool                `json:"broadcast,omitempty"` // Only holders of post may post
+}
+
+// RolesOf lists username's custom roles in the room
+func (p Permissions) RolesOf(username string) []string {
+	return p.Roles[username]
+}
+
+// Allows reports whether any of roles is granted capability
+func (p Permissions) Allows(roles []string, capability string) bool {
+	if capability == CapPost && !p.Broadcast {
+		return true
+	}
+	grants := p.Grants
+	if grants == nil {
+		grants = DefaultGrants
+	}
+	for _, role := range roles {
+		if role == RoleModerator || slices.Contains(grants[role], capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// MayPost reports whether username may post, going by the roles the
+// room assigns them; moderators are the permission package's to add
+func (p Permissions) MayPost(username string) bool {
+	return p.Allows(append([]string{RoleMember}, p.RolesOf(username)...), CapPost)
+}
+
+// Validate checks role names, holders and capabilities
+func (p Permissions) Validate() error {
+	roles := make(map[string]bool)
+	if len(p.Roles) > MaxRoleHolders {
+		return fmt.Errorf("at most %d users may hold roles", MaxRoleHolders)
+	}
+	for username, held := range p.Roles {
+		if username == "" {
+			return errors.New("roles must be assigned to a username")
+		}
+		for _, role := range held {
+			if err := validRole(role); err != nil {
+				return err
+			}
+			if role == RoleMember {
+				return fmt.Errorf("every user holds the %s role; it can't be assigned", RoleMember)
+			}
+			roles[role] = true
+		}
+	}
+	for role, caps := range p.Grants {
+		if err := validRole(role); err != nil {
+			return err
+		}
+		if role != RoleMember {
+			roles[role] = true
+		}
+		for _, c := range caps {
+			if !slices.Contains(Capabilities, c) {
+				return fmt.Errorf("unknown capability %q", c)
+			}
+		}
+	}
+	if len(roles) > MaxRoles {
+		return fmt.Errorf("at most %d roles", MaxRoles)
+	}
+	return nil
+}
+
+// validRole checks a role name, which can't be the moderator role
+func validRole(role string) error {
+	if role == "" || len(role) > MaxRoleName {
+		return fmt.Errorf("role names must be 1-%d characters", MaxRoleName)
+	}
+	if role == RoleModerator {
+		return fmt.Errorf("the %s role has every capability; it can't be assigned or granted", RoleModerator)
+	}
+	return nil
+}
@@ -0,0 +1,1895 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+PostgreSQL Overview:
+-------------------
+Postgres keeps everything the Store holds in PostgreSQL, so it
+survives restarts and is shared by every node using the database:
+
+1. Messages, which the history API pages through, and the offline
+   queues holding them for members who were away
+2. Room memberships and settings
+3. The event log, which admin replay rebuilds rooms from (see the
+   eventlog package)
+4. The moderation review queue, room bans and moderators' keyword
+   alerts
+5. Users: when each was first seen, their avatar and age confirmation,
+   and which rooms have onboarded them
+6. Scheduled announcements, whose runs every node sees, so one
+   claims each (MarkAnnouncementRun)
+7. Sticker packs and their images, voice notes, upload records (the
+   files themselves are in object storage) and avatar images
+8. Each user's daily quota usage, which AddUsage checks against the
+   limits in the same statement that adds to it
+9. Hourly usage metering records, which every node adds its counts to
+10. Tenants, whose key and limit changes other nodes load once their
+    cached copies expire (see the tenant package)
+11. Each user's synced drafts and state, shared by their devices
+12. Notification preferences, with mutes, do-not-disturb windows and
+    highlight keywords
+13. Direct messages waiting for users who weren't connected
+14. Room data keys, without which encrypted content stored here
+    couldn't be read after a restart
+
+Every Store method is answered from the database, so nothing it is
+given is lost on restart:
+
+	store := storage.NewPostgres(database)
+
+The store owns database from then on; Close closes it. The schema is the db package's migrations, which must be applied
+first. Offsets in the event log come from a counter row per room
+(room_event_offsets), bumped in the same statement that appends the
+event, so appends to one room from several nodes queue on that row
+instead of colliding.
+*/
+
+// Postgres is a Store keeping its data in PostgreSQL
+type Postgres struct {
+	db *sql.DB
+}
+
+// A method added to Store must be added here too, not left to memory
+var _ Store = (*Postgres)(nil)
+
+// NewPostgres returns a store keeping its data in db, which it owns
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Close implements Store, closing the database
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+// messageColumns are selected by scanMessage, in its order
+const messageColumns = `id, room, username, type, content, seq, qos, created_at, sticker, audio, attachment, quote, hidden`
+
+// eventColumns are selected by scanEvent, in its order
+const eventColumns = `room, "offset", type, username, message_id, seq, content, data, created_at`
+
+// settingsColumns are written by insertSettings, in scanSettings' order
+const settingsColumns = `room, retention_policy, retention_days, retention_messages, links, joins, onboarding,
+	emoji, permissions, invites, federation, tags, metadata, archived_at, archived_by, breakout, updated_at`
+
+// selectSettings reads settingsColumns for scanSettings, tags as JSON
+const selectSettings = `room, retention_policy, retention_days, retention_messages, links, joins, onboarding,
+	emoji, permissions, invites, federation, to_json(tags), metadata, archived_at, archived_by, breakout, updated_at`
+
+// reviewColumns are written by insertReviewItem, in scanReviewItem's order
+const reviewColumns = `id, room, message_id, username, content, sources, reasons, reporters, score, hidden,
+	created_at, updated_at`
+
+// selectReview reads reviewColumns for scanReviewItem, arrays as JSON
+const selectReview = `id, room, message_id, username, content, to_json(sources), to_json(reasons), to_json(reporters),
+	score, hidden, created_at, updated_at`
+
+// banColumns are selected by scanBan, in its order
+const banColumns = `room, username, reason, banned_by, created_at, until`
+
+// selectAlert reads an alert for scanAlert, arrays as JSON
+const selectAlert = `room, moderator, to_json(keywords), to_json(patterns), updated_at`
+
+// userColumns are selected by scanUser, in its order
+const userColumns = `username, first_seen, avatar, age_confirmed`
+
+// announcementColumns are written by insertAnnouncement, in
+// scanAnnouncement's order
+const announcementColumns = `id, rooms, tags, schedule, timezone, sender, content, created_by, created_at,
+	updated_at, last_run`
+
+// selectAnnouncement reads announcementColumns for scanAnnouncement,
+// arrays as JSON
+const selectAnnouncement = `id, to_json(rooms), to_json(tags), schedule, timezone, sender, content, created_by,
+	created_at, updated_at, last_run`
+
+// selectPack reads a sticker pack for scanPack, rooms as JSON
+const selectPack = `id, name, to_json(rooms), stickers, created_by, created_at, updated_at`
+
+// audioColumns are selected by scanAudio, in its order
+const audioColumns = `id, room, username, content_type, codec, duration_ms, size, posted, created_at, data`
+
+// uploadColumns are selected by scanUpload, in its order
+const uploadColumns = `id, room, username, name, content_type, size, key, posted, created_at`
+
+// meterColumns are selected by scanMeterRecord, in its order
+const meterColumns = `room, username, hour, messages, bytes, connection_seconds, peak_connections`
+
+// tenantColumns are selected by scanTenant, in its order
+const tenantColumns = `id, name, key_hash, max_connections, max_rooms, message_rate, message_burst, created_at, updated_at`
+
+// notifyColumns are selected by scanNotificationPrefs, in its order
+const notifyColumns = `username, default_level, rooms, muted, dnd, highlights, updated_at`
+
+// directColumns are selected by scanDirect, in its order
+const directColumns = `id, sender, recipient, origin, content, created_at`
+
+// pgTables are the tables Postgres keeps; Restore needs them empty
+var pgTables = []string{
+	"messages", "room_members", "offline_queue", "room_settings", "room_events", "review_queue", "room_bans",
+	"keyword_alerts", "users", "room_onboarded", "announcements", "sticker_packs", "sticker_images",
+	"audio_clips", "uploads", "avatar_images", "daily_usage", "meter_records",
+	"tenants", "sync_entries", "notification_prefs", "direct_queue", "room_keys",
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// queryAll runs a query and scans every row it returns
+func queryAll[T any](ctx context.Context, db *sql.DB, scan func(scanner) (T, error), query string, args ...any) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := []T{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, v)
+	}
+	return all, rows.Err()
+}
+
+func scanMessage(row scanner) (Message, error) {
+	var (
+		msg               Message
+		sticker, quote    []byte
+		audio, attachment sql.NullString
+	)
+	err := row.Scan(&msg.ID, &msg.Room, &msg.Username, &msg.Type, &msg.Content, &msg.Seq, &msg.QoS,
+		&msg.CreatedAt, &sticker, &audio, &attachment, &quote, &msg.Hidden)
+	if err != nil {
+		return Message{}, err
+	}
+	msg.Audio, msg.Attachment = audio.String, attachment.String
+	if err := unmarshalColumn(sticker, &msg.Sticker); err != nil {
+		return Message{}, fmt.Errorf("message %s sticker: %w", msg.ID, err)
+	}
+	if err := unmarshalColumn(quote, &msg.Quote); err != nil {
+		return Message{}, fmt.Errorf("message %s quote: %w", msg.ID, err)
+	}
+	return msg, nil
+}
+
+func scanEvent(row scanner) (Event, error) {
+	var (
+		ev   Event
+		data []byte
+	)
+	err := row.Scan(&ev.Room, &ev.Offset, &ev.Type, &ev.Username, &ev.MessageID, &ev.Seq, &ev.Content, &data, &ev.CreatedAt)
+	if err != nil {
+		return Event{}, err
+	}
+	if err := unmarshalColumn(data, &ev.Data); err != nil {
+		return Event{}, fmt.Errorf("event %s/%d data: %w", ev.Room, ev.Offset, err)
+	}
+	return ev, nil
+}
+
+func scanSettings(row scanner) (RoomSettings, error) {
+	var (
+		s                                             RoomSettings
+		links, joins, onboarding, emoji, permissions  []byte
+		invites, federation, tags, metadata, breakout []byte
+		archivedAt                                    sql.NullTime
+		archivedBy                                    sql.NullString
+	)
+	err := row.Scan(&s.Room, &s.Retention.Policy, &s.Retention.Days, &s.Retention.Messages, &links, &joins, &onboarding,
+		&emoji, &permissions, &invites, &federation, &tags, &metadata, &archivedAt, &archivedBy, &breakout, &s.UpdatedAt)
+	if err != nil {
+		return RoomSettings{}, err
+	}
+	if archivedAt.Valid {
+		s.Archived = &Archival{At: archivedAt.Time, By: archivedBy.String}
+	}
+	columns := []struct {
+		data []byte
+		v    any
+	}{
+		{links, &s.Links}, {joins, &s.Joins}, {onboarding, &s.Onboarding}, {emoji, &s.Emoji},
+		{permissions, &s.Permissions}, {invites, &s.Invites}, {federation, &s.Federation},
+		{tags, &s.Tags}, {metadata, &s.Metadata}, {breakout, &s.Breakout},
+	}
+	for _, c := range columns {
+		if err := unmarshalColumn(c.data, c.v); err != nil {
+			return RoomSettings{}, fmt.Errorf("room %s settings: %w", s.Room, err)
+		}
+	}
+	if len(s.Tags) == 0 {
+		s.Tags = nil // As Memory keeps a room that was never tagged
+	}
+	return s, nil
+}
+
+func scanReviewItem(row scanner) (ReviewItem, error) {
+	var (
+		item                        ReviewItem
+		sources, reasons, reporters []byte
+	)
+	err := row.Scan(&item.ID, &item.Room, &item.MessageID, &item.Username, &item.Content, &sources, &reasons, &reporters,
+		&item.Score, &item.Hidden, &item.CreatedAt, &item.UpdatedAt)
+	if err != nil {
+		return ReviewItem{}, err
+	}
+	for _, c := range []struct {
+		data []byte
+		v    *[]string
+	}{{sources, &item.Sources}, {reasons, &item.Reasons}, {reporters, &item.Reporters}} {
+		if err := unmarshalColumn(c.data, c.v); err != nil {
+			return ReviewItem{}, fmt.Errorf("review item %s: %w", item.ID, err)
+		}
+	}
+	if len(item.Reasons) == 0 {
+		item.Reasons = nil
+	}
+	if len(item.Reporters) == 0 {
+		item.Reporters = nil
+	}
+	return item, nil
+}
+
+func scanBan(row scanner) (Ban, error) {
+	var (
+		ban   Ban
+		until sql.NullTime
+	)
+	err := row.Scan(&ban.Room, &ban.Username, &ban.Reason, &ban.By, &ban.CreatedAt, &until)
+	ban.Until = until.Time
+	return ban, err
+}
+
+func scanAlert(row scanner) (KeywordAlert, error) {
+	var (
+		alert              KeywordAlert
+		keywords, patterns []byte
+	)
+	if err := row.Scan(&alert.Room, &alert.Moderator, &keywords, &patterns, &alert.UpdatedAt); err != nil {
+		return KeywordAlert{}, err
+	}
+	if err := unmarshalColumn(keywords, &alert.Keywords); err != nil {
+		return KeywordAlert{}, fmt.Errorf("keyword alert %s/%s: %w", alert.Room, alert.Moderator, err)
+	}
+	if err := unmarshalColumn(patterns, &alert.Patterns); err != nil {
+		return KeywordAlert{}, fmt.Errorf("keyword alert %s/%s: %w", alert.Room, alert.Moderator, err)
+	}
+	if len(alert.Keywords) == 0 {
+		alert.Keywords = nil
+	}
+	if len(alert.Patterns) == 0 {
+		alert.Patterns = nil
+	}
+	return alert, nil
+}
+
+func scanUser(row scanner) (User, error) {
+	var (
+		user         User
+		avatar       sql.NullString
+		ageConfirmed sql.NullTime
+	)
+	if err := row.Scan(&user.Username, &user.FirstSeen, &avatar, &ageConfirmed); err != nil {
+		return User{}, err
+	}
+	user.Avatar = avatar.String
+	if ageConfirmed.Valid {
+		user.AgeConfirmed = &ageConfirmed.Time
+	}
+	return user, nil
+}
+
+func scanAnnouncement(row scanner) (Announcement, error) {
+	var (
+		a           Announcement
+		rooms, tags []byte
+		lastRun     sql.NullTime
+	)
+	err := row.Scan(&a.ID, &rooms, &tags, &a.Schedule, &a.Timezone, &a.From, &a.Content, &a.CreatedBy,
+		&a.CreatedAt, &a.UpdatedAt, &lastRun)
+	if err != nil {
+		return Announcement{}, err
+	}
+	a.LastRun = lastRun.Time
+	if err := unmarshalColumn(rooms, &a.Rooms); err != nil {
+		return Announcement{}, fmt.Errorf("announcement %s: %w", a.ID, err)
+	}
+	if err := unmarshalColumn(tags, &a.Tags); err != nil {
+		return Announcement{}, fmt.Errorf("announcement %s: %w", a.ID, err)
+	}
+	if len(a.Tags) == 0 {
+		a.Tags = nil
+	}
+	return a, nil
+}
+
+func scanPack(row scanner) (StickerPack, error) {
+	var (
+		pack            StickerPack
+		rooms, stickers []byte
+	)
+	err := row.Scan(&pack.ID, &pack.Name, &rooms, &stickers, &pack.CreatedBy, &pack.CreatedAt, &pack.UpdatedAt)
+	if err != nil {
+		return StickerPack{}, err
+	}
+	if err := unmarshalColumn(rooms, &pack.Rooms); err != nil {
+		return StickerPack{}, fmt.Errorf("sticker pack %s: %w", pack.ID, err)
+	}
+	if err := unmarshalColumn(stickers, &pack.Stickers); err != nil {
+		return StickerPack{}, fmt.Errorf("sticker pack %s: %w", pack.ID, err)
+	}
+	if len(pack.Rooms) == 0 {
+		pack.Rooms = nil
+	}
+	return pack, nil
+}
+
+func scanStickerImage(row scanner) (StickerImage, error) {
+	var img StickerImage
+	err := row.Scan(&img.Pack, &img.Sticker, &img.ContentType, &img.Data)
+	return img, err
+}
+
+func scanAudio(row scanner) (AudioClip, error) {
+	var clip AudioClip
+	err := row.Scan(&clip.ID, &clip.Room, &clip.Username, &clip.ContentType, &clip.Codec, &clip.DurationMs,
+		&clip.Size, &clip.Posted, &clip.CreatedAt, &clip.Data)
+	return clip, err
+}
+
+func scanUpload(row scanner) (Upload, error) {
+	var u Upload
+	err := row.Scan(&u.ID, &u.Room, &u.Username, &u.Name, &u.ContentType, &u.Size, &u.Key, &u.Posted, &u.CreatedAt)
+	return u, err
+}
+
+func scanAvatar(row scanner) (AvatarImage, error) {
+	var img AvatarImage
+	err := row.Scan(&img.ID, &img.Size, &img.Data, &img.CreatedAt)
+	return img, err
+}
+
+func scanUsage(row scanner) (DailyUsage, error) {
+	var u DailyUsage
+	err := row.Scan(&u.Username, &u.Day, &u.Messages, &u.Uploads, &u.Bytes)
+	return u, err
+}
+
+func scanMeterRecord(row scanner) (MeterRecord, error) {
+	var r MeterRecord
+	err := row.Scan(&r.Room, &r.Username, &r.Hour, &r.Messages, &r.Bytes, &r.ConnectionSeconds, &r.PeakConnections)
+	r.Hour = r.Hour.UTC()
+	return r, err
+}
+
+func scanTenant(row scanner) (Tenant, error) {
+	var t Tenant
+	err := row.Scan(&t.ID, &t.Name, &t.KeyHash, &t.Limits.MaxConnections, &t.Limits.MaxRooms, &t.Limits.MessageRate,
+		&t.Limits.MessageBurst, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+func scanSyncEntry(row scanner) (SyncEntry, error) {
+	var e SyncEntry
+	err := row.Scan(&e.Username, &e.Key, &e.Value, &e.UpdatedAt)
+	return e, err
+}
+
+func scanNotificationPrefs(row scanner) (NotificationPrefs, error) {
+	var (
+		prefs                         NotificationPrefs
+		rooms, muted, dnd, highlights []byte
+	)
+	if err := row.Scan(&prefs.Username, &prefs.Default, &rooms, &muted, &dnd, &highlights, &prefs.UpdatedAt); err != nil {
+		return NotificationPrefs{}, err
+	}
+	columns := []struct {
+		data []byte
+		v    any
+	}{{rooms, &prefs.Rooms}, {muted, &prefs.Muted}, {dnd, &prefs.DND}, {highlights, &prefs.Highlights}}
+	for _, c := range columns {
+		if err := unmarshalColumn(c.data, c.v); err != nil {
+			return NotificationPrefs{}, fmt.Errorf("notification preferences of %s: %w", prefs.Username, err)
+		}
+	}
+	return prefs, nil
+}
+
+func scanDirect(row scanner) (DirectMessage, error) {
+	var dm DirectMessage
+	err := row.Scan(&dm.ID, &dm.From, &dm.To, &dm.Origin, &dm.Content, &dm.CreatedAt)
+	return dm, err
+}
+
+func scanRoomKey(row scanner) (RoomKey, error) {
+	var k RoomKey
+	err := row.Scan(&k.Room, &k.Version, &k.MasterKey, &k.Wrapped, &k.CreatedAt)
+	return k, err
+}
+
+// jsonColumn encodes v for a nullable JSONB column, or NULL when null is set
+func jsonColumn(v any, null bool) (any, error) {
+	if null {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// unmarshalColumn decodes a nullable JSONB column, leaving v alone for NULL
+func unmarshalColumn(data []byte, v any) error {
+	if data == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// nullString stores "" as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// textArray is s for a NOT NULL TEXT[] column, which nil would violate
+func textArray(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// execer is a *sql.DB or *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertMessage(ctx context.Context, db execer, msg Message) error {
+	sticker, err := jsonColumn(msg.Sticker, msg.Sticker == nil)
+	if err != nil {
+		return err
+	}
+	quote, err := jsonColumn(msg.Quote, msg.Quote == nil)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO messages (`+messageColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (id) DO UPDATE SET
+			room = EXCLUDED.room, username = EXCLUDED.username, type = EXCLUDED.type,
+			content = EXCLUDED.content, seq = EXCLUDED.seq, qos = EXCLUDED.qos,
+			created_at = EXCLUDED.created_at, sticker = EXCLUDED.sticker, audio = EXCLUDED.audio,
+			attachment = EXCLUDED.attachment, quote = EXCLUDED.quote, hidden = EXCLUDED.hidden`,
+		msg.ID, msg.Room, msg.Username, msg.Type, msg.Content, msg.Seq, msg.QoS, msg.CreatedAt,
+		sticker, nullString(msg.Audio), nullString(msg.Attachment), quote, msg.Hidden)
+	return err
+}
+
+// SaveMessage implements Store
+func (p *Postgres) SaveMessage(ctx context.Context, msg Message) error {
+	if err := insertMessage(ctx, p.db, msg); err != nil {
+		return fmt.Errorf("save message: %w", err)
+	}
+	return nil
+}
+
+// GetMessage implements Store
+func (p *Postgres) GetMessage(ctx context.Context, id string) (Message, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT `+messageColumns+` FROM messages WHERE id = $1`, id)
+	msg, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, ErrNotFound
+	}
+	return msg, err
+}
+
+// DeleteMessage implements Store
+func (p *Postgres) DeleteMessage(ctx context.Context, id string) error {
+	res, err := p.db.ExecContext(ctx, `DELETE FROM messages WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete message: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LastSeq implements Store
+func (p *Postgres) LastSeq(ctx context.Context, room string) (uint64, error) {
+	var last uint64
+	err := p.db.QueryRowContext(ctx, `
+		SELECT GREATEST(
+			(SELECT COALESCE(MAX(seq), 0) FROM messages WHERE room = $1),
+			(SELECT COALESCE(MAX(seq), 0) FROM room_events WHERE room = $1))`, room).Scan(&last)
+	return last, err
+}
+
+// MessagePage implements Store
+// Served by messages_room_seq_idx, newest first
+func (p *Postgres) MessagePage(ctx context.Context, room string, before uint64, limit int) ([]Message, error) {
+	if before == 0 || before > math.MaxInt64 {
+		before = math.MaxInt64
+	}
+	return queryAll(ctx, p.db, scanMessage, `
+		SELECT `+messageColumns+` FROM messages
+		WHERE room = $1 AND seq > 0 AND seq < $2 AND NOT hidden ORDER BY seq DESC LIMIT $3`, room, before, limit)
+}
+
+// SetMessageHidden implements Store
+func (p *Postgres) SetMessageHidden(ctx context.Context, id string, hidden bool) error {
+	res, err := p.db.ExecContext(ctx, `UPDATE messages SET hidden = $2 WHERE id = $1`, id, hidden)
+	if err != nil {
+		return fmt.Errorf("set message hidden: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// HideMessages implements Store
+func (p *Postgres) HideMessages(ctx context.Context, room string, purge Purge) (int, error) {
+	res, err := p.db.ExecContext(ctx, `
+		UPDATE messages SET hidden = true
+		WHERE room = $1 AND NOT hidden AND ($2 = '' OR username = $2) AND created_at >= $3 AND created_at <= $4`,
+		room, purge.Username, purge.Since, purge.Until)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// MessagesBefore implements Store
+func (p *Postgres) MessagesBefore(ctx context.Context, room string, t time.Time) ([]Message, error) {
+	return queryAll(ctx, p.db, scanMessage, `
+		SELECT `+messageColumns+` FROM messages
+		WHERE room = $1 AND created_at < $2 ORDER BY seq`, room, t)
+}
+
+// execRows runs a statement, returning how many rows it changed
+func (p *Postgres) execRows(ctx context.Context, query string, args ...any) (int, error) {
+	res, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// DeleteMessagesBefore implements Store
+// Offline queues lose the messages with them (ON DELETE CASCADE)
+func (p *Postgres) DeleteMessagesBefore(ctx context.Context, room string, t time.Time) (int, error) {
+	return p.execRows(ctx, `DELETE FROM messages WHERE room = $1 AND created_at < $2`, room, t)
+}
+
+// TrimMessages implements Store
+func (p *Postgres) TrimMessages(ctx context.Context, room string, keep int) (int, error) {
+	return p.execRows(ctx, `
+		DELETE FROM messages WHERE room = $1 AND id NOT IN (
+			SELECT id FROM messages WHERE room = $1 ORDER BY seq DESC LIMIT $2)`, room, keep)
+}
+
+// PurgeMessages implements Store
+func (p *Postgres) PurgeMessages(ctx context.Context, room string, purge Purge) (int, error) {
+	return p.execRows(ctx, `
+		DELETE FROM messages
+		WHERE room = $1 AND ($2 = '' OR username = $2) AND created_at >= $3 AND created_at <= $4`,
+		room, purge.Username, purge.Since, purge.Until)
+}
+
+// AddMember implements Store
+func (p *Postgres) AddMember(ctx context.Context, room, username string) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO room_members (room, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`, room, username)
+	if err != nil {
+		return fmt.Errorf("add member: %w", err)
+	}
+	return nil
+}
+
+// Members implements Store
+func (p *Postgres) Members(ctx context.Context, room string) ([]string, error) {
+	return queryAll(ctx, p.db, func(row scanner) (string, error) {
+		var username string
+		err := row.Scan(&username)
+		return username, err
+	}, `SELECT username FROM room_members WHERE room = $1 ORDER BY username`, room)
+}
+
+// queueOffline holds messageID for username in room, unless the message
+// is already gone, which TakeOffline would skip anyway
+func queueOffline(ctx context.Context, db execer, username, room, messageID string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO offline_queue (username, room, message_id)
+		SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM messages WHERE id = $3)`, username, room, messageID)
+	return err
+}
+
+// QueueOffline implements Store
+func (p *Postgres) QueueOffline(ctx context.Context, username, room, messageID string) error {
+	if err := queueOffline(ctx, p.db, username, room, messageID); err != nil {
+		return fmt.Errorf("queue offline: %w", err)
+	}
+	return nil
+}
+
+// TakeOffline implements Store
+// Taking is one statement, so two connections of the user can't both get
+// the same message
+func (p *Postgres) TakeOffline(ctx context.Context, username, room string) ([]Message, error) {
+	return queryAll(ctx, p.db, scanMessage, `
+		WITH taken AS (
+			DELETE FROM offline_queue WHERE username = $1 AND room = $2
+			RETURNING id AS position, message_id
+		)
+		SELECT `+messageColumns+` FROM taken JOIN messages ON messages.id = taken.message_id
+		ORDER BY taken.position`, username, room)
+}
+
+func insertSettings(ctx context.Context, db execer, s RoomSettings) error {
+	policies := []any{s.Links, s.Joins, s.Onboarding, s.Emoji, s.Permissions, s.Invites, s.Federation, s.Metadata}
+	encoded := make([]any, len(policies))
+	for i, v := range policies {
+		var err error
+		if encoded[i], err = jsonColumn(v, false); err != nil {
+			return err
+		}
+	}
+	breakout, err := jsonColumn(s.Breakout, s.Breakout == nil)
+	if err != nil {
+		return err
+	}
+	var (
+		archivedAt sql.NullTime
+		archivedBy sql.NullString
+	)
+	if s.Archived != nil {
+		archivedAt, archivedBy = sql.NullTime{Time: s.Archived.At, Valid: true}, nullString(s.Archived.By)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO room_settings (`+settingsColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT (room) DO UPDATE SET
+			retention_policy = EXCLUDED.retention_policy, retention_days = EXCLUDED.retention_days,
+			retention_messages = EXCLUDED.retention_messages, links = EXCLUDED.links, joins = EXCLUDED.joins,
+			onboarding = EXCLUDED.onboarding, emoji = EXCLUDED.emoji, permissions = EXCLUDED.permissions,
+			invites = EXCLUDED.invites, federation = EXCLUDED.federation, tags = EXCLUDED.tags,
+			metadata = EXCLUDED.metadata, archived_at = EXCLUDED.archived_at, archived_by = EXCLUDED.archived_by,
+			breakout = EXCLUDED.breakout, updated_at = EXCLUDED.updated_at`,
+		s.Room, s.Retention.Policy, s.Retention.Days, s.Retention.Messages, encoded[0], encoded[1], encoded[2],
+		encoded[3], encoded[4], encoded[5], encoded[6], textArray(s.Tags), encoded[7], archivedAt, archivedBy,
+		breakout, s.UpdatedAt)
+	return err
+}
+
+// GetRoomSettings implements Store
+func (p *Postgres) GetRoomSettings(ctx context.Context, room string) (RoomSettings, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT `+selectSettings+` FROM room_settings WHERE room = $1`, room)
+	s, err := scanSettings(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RoomSettings{}, ErrNotFound
+	}
+	return s, err
+}
+
+// SaveRoomSettings implements Store
+func (p *Postgres) SaveRoomSettings(ctx context.Context, settings RoomSettings) error {
+	if err := insertSettings(ctx, p.db, settings); err != nil {
+		return fmt.Errorf("save room settings: %w", err)
+	}
+	return nil
+}
+
+// ListRoomSettings implements Store
+func (p *Postgres) ListRoomSettings(ctx context.Context) ([]RoomSettings, error) {
+	return queryAll(ctx, p.db, scanSettings, `SELECT `+selectSettings+` FROM room_settings ORDER BY room`)
+}
+
+func insertReviewItem(ctx context.Context, db execer, item ReviewItem) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO review_queue (`+reviewColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (id) DO UPDATE SET
+			room = EXCLUDED.room, message_id = EXCLUDED.message_id, username = EXCLUDED.username,
+			content = EXCLUDED.content, sources = EXCLUDED.sources, reasons = EXCLUDED.reasons,
+			reporters = EXCLUDED.reporters, score = EXCLUDED.score, hidden = EXCLUDED.hidden,
+			created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at`,
+		item.ID, item.Room, item.MessageID, item.Username, item.Content, textArray(item.Sources),
+		textArray(item.Reasons), textArray(item.Reporters), item.Score, item.Hidden, item.CreatedAt, item.UpdatedAt)
+	return err
+}
+
+// SaveReviewItem implements Store
+func (p *Postgres) SaveReviewItem(ctx context.Context, item ReviewItem) error {
+	if err := insertReviewItem(ctx, p.db, item); err != nil {
+		return fmt.Errorf("save review item: %w", err)
+	}
+	return nil
+}
+
+// GetReviewItem implements Store
+func (p *Postgres) GetReviewItem(ctx context.Context, id string) (ReviewItem, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT `+selectReview+` FROM review_queue WHERE id = $1`, id)
+	item, err := scanReviewItem(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ReviewItem{}, ErrNotFound
+	}
+	return item, err
+}
+
+// ReviewItems implements Store
+func (p *Postgres) ReviewItems(ctx context.Context, limit int) ([]ReviewItem, error) {
+	return queryAll(ctx, p.db, scanReviewItem, `
+		SELECT `+selectReview+` FROM review_queue ORDER BY created_at, id LIMIT $1`, limit)
+}
+
+// DeleteReviewItem implements Store
+func (p *Postgres) DeleteReviewItem(ctx context.Context, id string) error {
+	n, err := p.execRows(ctx, `DELETE FROM review_queue WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete review item: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func insertBan(ctx context.Context, db execer, ban Ban) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO room_bans (`+banColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (room, username) DO UPDATE SET
+			reason = EXCLUDED.reason, banned_by = EXCLUDED.banned_by,
+			created_at = EXCLUDED.created_at, until = EXCLUDED.until`,
+		ban.Room, ban.Username, ban.Reason, ban.By, ban.CreatedAt, nullTime(ban.Until))
+	return err
+}
+
+// SaveBan implements Store
+func (p *Postgres) SaveBan(ctx context.Context, ban Ban) error {
+	if err := insertBan(ctx, p.db, ban); err != nil {
+		return fmt.Errorf("save ban: %w", err)
+	}
+	return nil
+}
+
+// GetBan implements Store
+func (p *Postgres) GetBan(ctx context.Context, room, username string) (Ban, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT `+banColumns+` FROM room_bans WHERE room = $1 AND username = $2`,
+		room, username)
+	ban, err := scanBan(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Ban{}, ErrNotFound
+	}
+	return ban, err
+}
+
+// Bans implements Store
+func (p *Postgres) Bans(ctx context.Context, room string) ([]Ban, error) {
+	return queryAll(ctx, p.db, scanBan, `SELECT `+banColumns+` FROM room_bans WHERE room = $1 ORDER BY username`, room)
+}
+
+// DeleteBan implements Store
+func (p *Postgres) DeleteBan(ctx context.Context, room, username string) error {
+	n, err := p.execRows(ctx, `DELETE FROM room_bans WHERE room = $1 AND username = $2`, room, username)
+	if err != nil {
+		return fmt.Errorf("delete ban: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func insertAlert(ctx context.Context, db execer, alert KeywordAlert) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO keyword_alerts (room, moderator, keywords, patterns, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (room, moderator) DO UPDATE SET
+			keywords = EXCLUDED.keywords, patterns = EXCLUDED.patterns, updated_at = EXCLUDED.updated_at`,
+		alert.Room, alert.Moderator, textArray(alert.Keywords), textArray(alert.Patterns), alert.UpdatedAt)
+	return err
+}
+
+// SaveKeywordAlert implements Store
+func (p *Postgres) SaveKeywordAlert(ctx context.Context, alert KeywordAlert) error {
+	if err := insertAlert(ctx, p.db, alert); err != nil {
+		return fmt.Errorf("save keyword alert: %w", err)
+	}
+	return nil
+}
+
+// KeywordAlerts implements Store
+func (p *Postgres) KeywordAlerts(ctx context.Context, room string) ([]KeywordAlert, error) {
+	return queryAll(ctx, p.db, scanAlert, `
+		SELECT `+selectAlert+` FROM keyword_alerts WHERE room = $1 ORDER BY moderator`, room)
+}
+
+// DeleteKeywordAlert implements Store
+func (p *Postgres) DeleteKeywordAlert(ctx context.Context, room, moderator string) error {
+	n, err := p.execRows(ctx, `DELETE FROM keyword_alerts WHERE room = $1 AND moderator = $2`, room, moderator)
+	if err != nil {
+		return fmt.Errorf("delete keyword alert: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FirstSeen implements Store
+func (p *Postgres) FirstSeen(ctx context.Context, username string, now time.Time) (time.Time, error) {
+	// The no-op update makes RETURNING give the stored time on a conflict
+	var first time.Time
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO users (username, first_seen) VALUES ($1, $2)
+		ON CONFLICT (username) DO UPDATE SET first_seen = users.first_seen
+		RETURNING first_seen`, username, now).Scan(&first)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("first seen: %w", err)
+	}
+	return first, nil
+}
+
+// GetUser implements Store
+func (p *Postgres) GetUser(ctx context.Context, username string) (User, error) {
+	user, err := scanUser(p.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE username = $1`, username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	return user, err
+}
+
+// SetAvatar implements Store
+func (p *Postgres) SetAvatar(ctx context.Context, username, id string, now time.Time) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO users (username, first_seen, avatar) VALUES ($1, $2, $3)
+		ON CONFLICT (username) DO UPDATE SET avatar = EXCLUDED.avatar`, username, now, nullString(id))
+	if err != nil {
+		return fmt.Errorf("set avatar: %w", err)
+	}
+	return nil
+}
+
+// SetAgeConfirmed implements Store
+func (p *Postgres) SetAgeConfirmed(ctx context.Context, username string, confirmed bool, now time.Time) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO users (username, first_seen, age_confirmed) VALUES ($1, $2, $3)
+		ON CONFLICT (username) DO UPDATE SET age_confirmed = EXCLUDED.age_confirmed`,
+		username, now, sql.NullTime{Time: now, Valid: confirmed})
+	if err != nil {
+		return fmt.Errorf("set age confirmed: %w", err)
+	}
+	return nil
+}
+
+func insertUser(ctx context.Context, db execer, user User) error {
+	var ageConfirmed sql.NullTime
+	if user.AgeConfirmed != nil {
+		ageConfirmed = sql.NullTime{Time: *user.AgeConfirmed, Valid: true}
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username) DO UPDATE SET
+			first_seen = EXCLUDED.first_seen, avatar = EXCLUDED.avatar, age_confirmed = EXCLUDED.age_confirmed`,
+		user.Username, user.FirstSeen, nullString(user.Avatar), ageConfirmed)
+	return err
+}
+
+func insertOnboarded(ctx context.Context, db execer, room, username string, at time.Time) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO room_onboarded (room, username, onboarded_at) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, room, username, at)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// MarkOnboarded implements Store
+// Of two nodes marking the same user at once, only one gets true
+func (p *Postgres) MarkOnboarded(ctx context.Context, room, username string, now time.Time) (bool, error) {
+	marked, err := insertOnboarded(ctx, p.db, room, username, now)
+	if err != nil {
+		return false, fmt.Errorf("mark onboarded: %w", err)
+	}
+	return marked, nil
+}
+
+func insertAnnouncement(ctx context.Context, db execer, a Announcement) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO announcements (`+announcementColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO UPDATE SET
+			rooms = EXCLUDED.rooms, tags = EXCLUDED.tags, schedule = EXCLUDED.schedule,
+			timezone = EXCLUDED.timezone, sender = EXCLUDED.sender, content = EXCLUDED.content,
+			created_by = EXCLUDED.created_by, created_at = EXCLUDED.created_at,
+			updated_at = EXCLUDED.updated_at, last_run = EXCLUDED.last_run`,
+		a.ID, textArray(a.Rooms), textArray(a.Tags), a.Schedule, a.Timezone, a.From, a.Content, a.CreatedBy,
+		a.CreatedAt, a.UpdatedAt, nullTime(a.LastRun))
+	return err
+}
+
+// SaveAnnouncement implements Store
+func (p *Postgres) SaveAnnouncement(ctx context.Context, a Announcement) error {
+	if err := insertAnnouncement(ctx, p.db, a); err != nil {
+		return fmt.Errorf("save announcement: %w", err)
+	}
+	return nil
+}
+
+// GetAnnouncement implements Store
+func (p *Postgres) GetAnnouncement(ctx context.Context, id string) (Announcement, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT `+selectAnnouncement+` FROM announcements WHERE id = $1`, id)
+	a, err := scanAnnouncement(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Announcement{}, ErrNotFound
+	}
+	return a, err
+}
+
+// Announcements implements Store
+func (p *Postgres) Announcements(ctx context.Context) ([]Announcement, error) {
+	return queryAll(ctx, p.db, scanAnnouncement, `
+		SELECT `+selectAnnouncement+` FROM announcements ORDER BY created_at, id`)
+}
+
+// MarkAnnouncementRun implements Store
+func (p *Postgres) MarkAnnouncementRun(ctx context.Context, id string, last, at time.Time) error {
+	n, err := p.execRows(ctx, `
+		UPDATE announcements SET last_run = $3
+		WHERE id = $1 AND last_run IS NOT DISTINCT FROM $2`, id, nullTime(last), at)
+	if err != nil {
+		return fmt.Errorf("mark announcement run: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteAnnouncement implements Store
+func (p *Postgres) DeleteAnnouncement(ctx context.Context, id string) error {
+	n, err := p.execRows(ctx, `DELETE FROM announcements WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete announcement: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func insertPack(ctx context.Context, db execer, pack StickerPack) error {
+	stickers, err := jsonColumn(pack.Stickers, false)
+	if err != nil {
+		return err
+	}
+	if pack.Stickers == nil {
+		stickers = "[]"
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO sticker_packs (id, name, rooms, stickers, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name, rooms = EXCLUDED.rooms, stickers = EXCLUDED.stickers,
+			created_by = EXCLUDED.created_by, created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at`,
+		pack.ID, pack.Name, textArray(pack.Rooms), stickers, pack.CreatedBy, pack.CreatedAt, pack.UpdatedAt)
+	return err
+}
+
+// SaveStickerPack implements Store
+func (p *Postgres) SaveStickerPack(ctx context.Context, pack StickerPack) error {
+	if err := insertPack(ctx, p.db, pack); err != nil {
+		return fmt.Errorf("save sticker pack: %w", err)
+	}
+	return nil
+}
+
+// GetStickerPack implements Store
+func (p *Postgres) GetStickerPack(ctx context.Context, id string) (StickerPack, error) {
+	pack, err := scanPack(p.db.QueryRowContext(ctx, `SELECT `+selectPack+` FROM sticker_packs WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return StickerPack{}, ErrNotFound
+	}
+	return pack, err
+}
+
+// StickerPacks implements Store
+func (p *Postgres) StickerPacks(ctx context.Context) ([]StickerPack, error) {
+	return queryAll(ctx, p.db, scanPack, `SELECT `+selectPack+` FROM sticker_packs ORDER BY created_at, id`)
+}
+
+// DeleteStickerPack implements Store
+// Its images go with it (ON DELETE CASCADE)
+func (p *Postgres) DeleteStickerPack(ctx context.Context, id string) error {
+	n, err := p.execRows(ctx, `DELETE FROM sticker_packs WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete sticker pack: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func insertStickerImage(ctx context.Context, db execer, img StickerImage) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO sticker_images (pack, sticker, content_type, data) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (pack, sticker) DO UPDATE SET content_type = EXCLUDED.content_type, data = EXCLUDED.data`,
+		img.Pack, img.Sticker, img.ContentType, img.Data)
+	return err
+}
+
+// SaveStickerImage implements Store
+// The pack must have been saved first
+func (p *Postgres) SaveStickerImage(ctx context.Context, img StickerImage) error {
+	if err := insertStickerImage(ctx, p.db, img); err != nil {
+		return fmt.Errorf("save sticker image: %w", err)
+	}
+	return nil
+}
+
+// GetStickerImage implements Store
+func (p *Postgres) GetStickerImage(ctx context.Context, pack, sticker string) (StickerImage, error) {
+	row := p.db.QueryRowContext(ctx, `
+		SELECT pack, sticker, content_type, data FROM sticker_images WHERE pack = $1 AND sticker = $2`, pack, sticker)
+	img, err := scanStickerImage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StickerImage{}, ErrNotFound
+	}
+	return img, err
+}
+
+// DeleteStickerImage implements Store
+func (p *Postgres) DeleteStickerImage(ctx context.Context, pack, sticker string) error {
+	if _, err := p.execRows(ctx, `DELETE FROM sticker_images WHERE pack = $1 AND sticker = $2`, pack, sticker); err != nil {
+		return fmt.Errorf("delete sticker image: %w", err)
+	}
+	return nil
+}
+
+func insertAudio(ctx context.Context, db execer, clip AudioClip) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO audio_clips (`+audioColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET
+			room = EXCLUDED.room, username = EXCLUDED.username, content_type = EXCLUDED.content_type,
+			codec = EXCLUDED.codec, duration_ms = EXCLUDED.duration_ms, size = EXCLUDED.size,
+			posted = EXCLUDED.posted, created_at = EXCLUDED.created_at, data = EXCLUDED.data`,
+		clip.ID, clip.Room, clip.Username, clip.ContentType, clip.Codec, clip.DurationMs, clip.Size, clip.Posted,
+		clip.CreatedAt, clip.Data)
+	return err
+}
+
+// SaveAudio implements Store
+func (p *Postgres) SaveAudio(ctx context.Context, clip AudioClip) error {
+	if err := insertAudio(ctx, p.db, clip); err != nil {
+		return fmt.Errorf("save audio: %w", err)
+	}
+	return nil
+}
+
+// GetAudio implements Store
+func (p *Postgres) GetAudio(ctx context.Context, id string) (AudioClip, error) {
+	clip, err := scanAudio(p.db.QueryRowContext(ctx, `SELECT `+audioColumns+` FROM audio_clips WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AudioClip{}, ErrNotFound
+	}
+	return clip, err
+}
+
+// MarkAudioPosted implements Store
+func (p *Postgres) MarkAudioPosted(ctx context.Context, id string) error {
+	n, err := p.execRows(ctx, `UPDATE audio_clips SET posted = true WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("mark audio posted: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteAudioBefore implements Store
+func (p *Postgres) DeleteAudioBefore(ctx context.Context, room string, t time.Time) (int, error) {
+	return p.execRows(ctx, `DELETE FROM audio_clips WHERE posted AND room = $1 AND created_at < $2`, room, t)
+}
+
+// DeleteUnpostedAudio implements Store
+func (p *Postgres) DeleteUnpostedAudio(ctx context.Context, t time.Time) (int, error) {
+	return p.execRows(ctx, `DELETE FROM audio_clips WHERE NOT posted AND created_at < $1`, t)
+}
+
+func insertUpload(ctx context.Context, db execer, u Upload) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO uploads (`+uploadColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO UPDATE SET
+			room = EXCLUDED.room, username = EXCLUDED.username, name = EXCLUDED.name,
+			content_type = EXCLUDED.content_type, size = EXCLUDED.size, key = EXCLUDED.key,
+			posted = EXCLUDED.posted, created_at = EXCLUDED.created_at`,
+		u.ID, u.Room, u.Username, u.Name, u.ContentType, u.Size, u.Key, u.Posted, u.CreatedAt)
+	return err
+}
+
+// SaveUpload implements Store
+func (p *Postgres) SaveUpload(ctx context.Context, u Upload) error {
+	if err := insertUpload(ctx, p.db, u); err != nil {
+		return fmt.Errorf("save upload: %w", err)
+	}
+	return nil
+}
+
+// GetUpload implements Store
+func (p *Postgres) GetUpload(ctx context.Context, id string) (Upload, error) {
+	u, err := scanUpload(p.db.QueryRowContext(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Upload{}, ErrNotFound
+	}
+	return u, err
+}
+
+// MarkUploadPosted implements Store
+func (p *Postgres) MarkUploadPosted(ctx context.Context, id string) error {
+	n, err := p.execRows(ctx, `UPDATE uploads SET posted = true WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("mark upload posted: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UnpostedUploads implements Store
+func (p *Postgres) UnpostedUploads(ctx context.Context, t time.Time) ([]Upload, error) {
+	return queryAll(ctx, p.db, scanUpload, `
+		SELECT `+uploadColumns+` FROM uploads WHERE NOT posted AND created_at < $1 ORDER BY created_at, id`, t)
+}
+
+// DeleteUpload implements Store
+func (p *Postgres) DeleteUpload(ctx context.Context, id string) error {
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM uploads WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("delete upload: %w", err)
+	}
+	return nil
+}
+
+// insertAvatar stores a rendition unless it is already stored
+func insertAvatar(ctx context.Context, db execer, img AvatarImage) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO avatar_images (id, size, data, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id, size) DO NOTHING`,
+		img.ID, img.Size, img.Data, img.CreatedAt)
+	return err
+}
+
+// SaveAvatar implements Store
+func (p *Postgres) SaveAvatar(ctx context.Context, images []AvatarImage) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("save avatar: %w", err)
+	}
+	defer tx.Rollback()
+	for _, img := range images {
+		if err := insertAvatar(ctx, tx, img); err != nil {
+			return fmt.Errorf("save avatar: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("save avatar: %w", err)
+	}
+	return nil
+}
+
+// GetAvatar implements Store
+func (p *Postgres) GetAvatar(ctx context.Context, id string, size int) (AvatarImage, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT id, size, data, created_at FROM avatar_images WHERE id = $1 AND size = $2`, id, size)
+	img, err := scanAvatar(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AvatarImage{}, ErrNotFound
+	}
+	return img, err
+}
+
+func insertUsage(ctx context.Context, db execer, u DailyUsage) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO daily_usage (username, day, messages, uploads, bytes) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (username, day) DO UPDATE SET
+			messages = EXCLUDED.messages, uploads = EXCLUDED.uploads, bytes = EXCLUDED.bytes`,
+		u.Username, u.Day.UTC(), u.Messages, u.Uploads, u.Bytes)
+	return err
+}
+
+// AddUsage implements Store
+// The limits are checked in the upsert, so concurrent additions from
+// several nodes can't take a user past them together
+func (p *Postgres) AddUsage(ctx context.Context, username string, day time.Time, delta, limits Usage) (Usage, bool, error) {
+	if !delta.Fits(limits) {
+		used, err := p.GetUsage(ctx, username, day)
+		return used, false, err // No total including delta can fit either
+	}
+	var used Usage
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO daily_usage (username, day, messages, uploads, bytes) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (username, day) DO UPDATE SET
+			messages = daily_usage.messages + EXCLUDED.messages,
+			uploads = daily_usage.uploads + EXCLUDED.uploads,
+			bytes = daily_usage.bytes + EXCLUDED.bytes
+		WHERE ($6::bigint <= 0 OR daily_usage.messages + EXCLUDED.messages <= $6::bigint)
+			AND ($7::bigint <= 0 OR daily_usage.uploads + EXCLUDED.uploads <= $7::bigint)
+			AND ($8::bigint <= 0 OR daily_usage.bytes + EXCLUDED.bytes <= $8::bigint)
+		RETURNING messages, uploads, bytes`,
+		username, day.UTC(), delta.Messages, delta.Uploads, delta.Bytes, limits.Messages, limits.Uploads, limits.Bytes,
+	).Scan(&used.Messages, &used.Uploads, &used.Bytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		used, err = p.GetUsage(ctx, username, day) // Over the limits; nothing changed
+		return used, false, err
+	}
+	if err != nil {
+		return Usage{}, false, fmt.Errorf("add usage: %w", err)
+	}
+	return used, true, nil
+}
+
+// GetUsage implements Store
+func (p *Postgres) GetUsage(ctx context.Context, username string, day time.Time) (Usage, error) {
+	var used Usage
+	err := p.db.QueryRowContext(ctx, `
+		SELECT messages, uploads, bytes FROM daily_usage WHERE username = $1 AND day = $2`, username, day.UTC(),
+	).Scan(&used.Messages, &used.Uploads, &used.Bytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Usage{}, nil
+	}
+	if err != nil {
+		return Usage{}, fmt.Errorf("get usage: %w", err)
+	}
+	return used, nil
+}
+
+// DeleteUsageBefore implements Store
+func (p *Postgres) DeleteUsageBefore(ctx context.Context, day time.Time) (int, error) {
+	return p.execRows(ctx, `DELETE FROM daily_usage WHERE day < $1`, day.UTC())
+}
+
+// addMeterRecord adds a record's counters to those stored for its room,
+// user and hour, keeping the larger peak
+func addMeterRecord(ctx context.Context, db execer, r MeterRecord) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO meter_records (`+meterColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (room, username, hour) DO UPDATE SET
+			messages = meter_records.messages + EXCLUDED.messages,
+			bytes = meter_records.bytes + EXCLUDED.bytes,
+			connection_seconds = meter_records.connection_seconds + EXCLUDED.connection_seconds,
+			peak_connections = GREATEST(meter_records.peak_connections, EXCLUDED.peak_connections)`,
+		r.Room, r.Username, r.Hour.UTC(), r.Messages, r.Bytes, r.ConnectionSeconds, r.PeakConnections)
+	return err
+}
+
+// AddMeterRecords implements Store
+func (p *Postgres) AddMeterRecords(ctx context.Context, records []MeterRecord) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("add meter records: %w", err)
+	}
+	defer tx.Rollback()
+	for _, r := range records {
+		if err := addMeterRecord(ctx, tx, r); err != nil {
+			return fmt.Errorf("add meter records: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("add meter records: %w", err)
+	}
+	return nil
+}
+
+// MeterRecords implements Store
+func (p *Postgres) MeterRecords(ctx context.Context, from, to time.Time) ([]MeterRecord, error) {
+	return queryAll(ctx, p.db, scanMeterRecord, `
+		SELECT `+meterColumns+` FROM meter_records WHERE hour >= $1 AND hour < $2
+		ORDER BY hour, room, username`, from, to)
+}
+
+// RoomMeterRecords implements Store
+func (p *Postgres) RoomMeterRecords(ctx context.Context, room string, from, to time.Time) ([]MeterRecord, error) {
+	return queryAll(ctx, p.db, scanMeterRecord, `
+		SELECT `+meterColumns+` FROM meter_records WHERE room = $1 AND hour >= $2 AND hour < $3
+		ORDER BY hour, username`, room, from, to)
+}
+
+// DeleteMeterRecordsBefore implements Store
+func (p *Postgres) DeleteMeterRecordsBefore(ctx context.Context, t time.Time) (int, error) {
+	return p.execRows(ctx, `DELETE FROM meter_records WHERE hour < $1`, t)
+}
+
+func insertTenant(ctx context.Context, db execer, t Tenant) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO tenants (`+tenantColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name, key_hash = EXCLUDED.key_hash, max_connections = EXCLUDED.max_connections,
+			max_rooms = EXCLUDED.max_rooms, message_rate = EXCLUDED.message_rate,
+			message_burst = EXCLUDED.message_burst, created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at`,
+		t.ID, t.Name, t.KeyHash, t.Limits.MaxConnections, t.Limits.MaxRooms, t.Limits.MessageRate,
+		t.Limits.MessageBurst, t.CreatedAt, t.UpdatedAt)
+	return err
+}
+
+// SaveTenant implements Store
+func (p *Postgres) SaveTenant(ctx context.Context, t Tenant) error {
+	if err := insertTenant(ctx, p.db, t); err != nil {
+		return fmt.Errorf("save tenant: %w", err)
+	}
+	return nil
+}
+
+// GetTenant implements Store
+func (p *Postgres) GetTenant(ctx context.Context, id string) (Tenant, error) {
+	t, err := scanTenant(p.db.QueryRowContext(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Tenant{}, ErrNotFound
+	}
+	return t, err
+}
+
+// TenantByKey implements Store
+func (p *Postgres) TenantByKey(ctx context.Context, keyHash string) (Tenant, error) {
+	t, err := scanTenant(p.db.QueryRowContext(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE key_hash = $1`, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Tenant{}, ErrNotFound
+	}
+	return t, err
+}
+
+// Tenants implements Store
+func (p *Postgres) Tenants(ctx context.Context) ([]Tenant, error) {
+	return queryAll(ctx, p.db, scanTenant, `SELECT `+tenantColumns+` FROM tenants ORDER BY created_at, id`)
+}
+
+// DeleteTenant implements Store
+func (p *Postgres) DeleteTenant(ctx context.Context, id string) error {
+	n, err := p.execRows(ctx, `DELETE FROM tenants WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete tenant: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func insertSyncEntry(ctx context.Context, db execer, e SyncEntry) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO sync_entries (username, key, value, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username, key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`,
+		e.Username, e.Key, e.Value, e.UpdatedAt)
+	return err
+}
+
+// SyncEntries implements Store
+func (p *Postgres) SyncEntries(ctx context.Context, username string) ([]SyncEntry, error) {
+	return queryAll(ctx, p.db, scanSyncEntry, `
+		SELECT username, key, value, updated_at FROM sync_entries WHERE username = $1 ORDER BY key`, username)
+}
+
+// SaveSyncEntry implements Store
+// A new key is only inserted while the user has room for it; devices
+// saving new keys at the same moment may briefly pass MaxSyncKeys
+func (p *Postgres) SaveSyncEntry(ctx context.Context, e SyncEntry) error {
+	n, err := p.execRows(ctx, `
+		INSERT INTO sync_entries (username, key, value, updated_at)
+		SELECT $1::text, $2::text, $3::text, $4::timestamptz
+		WHERE (SELECT count(*) FROM sync_entries WHERE username = $1) < $5::bigint
+			OR EXISTS (SELECT 1 FROM sync_entries WHERE username = $1 AND key = $2)
+		ON CONFLICT (username, key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`,
+		e.Username, e.Key, e.Value, e.UpdatedAt, MaxSyncKeys)
+	if err != nil {
+		return fmt.Errorf("save sync entry: %w", err)
+	}
+	if n == 0 {
+		return ErrSyncFull
+	}
+	return nil
+}
+
+// DeleteSyncEntry implements Store
+func (p *Postgres) DeleteSyncEntry(ctx context.Context, username, key string) error {
+	n, err := p.execRows(ctx, `DELETE FROM sync_entries WHERE username = $1 AND key = $2`, username, key)
+	if err != nil {
+		return fmt.Errorf("delete sync entry: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// insertNotificationPrefs stores nil rooms, mutes and highlights as JSON
+// null, so they load as nil again
+func insertNotificationPrefs(ctx context.Context, db execer, prefs NotificationPrefs) error {
+	var encoded [3]any
+	for i, v := range []any{prefs.Rooms, prefs.Muted, prefs.Highlights} {
+		var err error
+		if encoded[i], err = jsonColumn(v, false); err != nil {
+			return err
+		}
+	}
+	dnd, err := jsonColumn(prefs.DND, prefs.DND == nil)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO notification_prefs (`+notifyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (username) DO UPDATE SET
+			default_level = EXCLUDED.default_level, rooms = EXCLUDED.rooms, muted = EXCLUDED.muted,
+			dnd = EXCLUDED.dnd, highlights = EXCLUDED.highlights, updated_at = EXCLUDED.updated_at`,
+		prefs.Username, prefs.Default, encoded[0], encoded[1], dnd, encoded[2], prefs.UpdatedAt)
+	return err
+}
+
+// GetNotificationPrefs implements Store
+func (p *Postgres) GetNotificationPrefs(ctx context.Context, username string) (NotificationPrefs, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT `+notifyColumns+` FROM notification_prefs WHERE username = $1`, username)
+	prefs, err := scanNotificationPrefs(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NotificationPrefs{}, ErrNotFound
+	}
+	return prefs, err
+}
+
+// SaveNotificationPrefs implements Store
+func (p *Postgres) SaveNotificationPrefs(ctx context.Context, prefs NotificationPrefs) error {
+	if err := insertNotificationPrefs(ctx, p.db, prefs); err != nil {
+		return fmt.Errorf("save notification preferences: %w", err)
+	}
+	return nil
+}
+
+func insertDirect(ctx context.Context, db execer, dm DirectMessage) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO direct_queue (`+directColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		dm.ID, dm.From, dm.To, dm.Origin, dm.Content, dm.CreatedAt)
+	return err
+}
+
+// QueueDirect implements Store
+// The message is only inserted while its recipient has room for it;
+// messages queued for one user at the same moment may briefly pass
+// MaxQueuedDirect
+func (p *Postgres) QueueDirect(ctx context.Context, dm DirectMessage) error {
+	n, err := p.execRows(ctx, `
+		INSERT INTO direct_queue (`+directColumns+`)
+		SELECT $1::text, $2::text, $3::text, $4::text, $5::text, $6::timestamptz
+		WHERE (SELECT count(*) FROM direct_queue WHERE recipient = $3) < $7::bigint`,
+		dm.ID, dm.From, dm.To, dm.Origin, dm.Content, dm.CreatedAt, MaxQueuedDirect)
+	if err != nil {
+		return fmt.Errorf("queue direct message: %w", err)
+	}
+	if n == 0 {
+		return ErrDirectFull
+	}
+	return nil
+}
+
+// TakeDirect implements Store
+func (p *Postgres) TakeDirect(ctx context.Context, username string) ([]DirectMessage, error) {
+	return queryAll(ctx, p.db, scanDirect, `
+		WITH taken AS (DELETE FROM direct_queue WHERE recipient = $1 RETURNING `+directColumns+`)
+		SELECT `+directColumns+` FROM taken ORDER BY created_at, id`, username)
+}
+
+func insertEvent(ctx context.Context, db execer, ev Event) error {
+	data, err := jsonColumn(ev.Data, ev.Data == nil)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO room_events (`+eventColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		ev.Room, ev.Offset, ev.Type, ev.Username, ev.MessageID, ev.Seq, ev.Content, data, ev.CreatedAt)
+	return err
+}
+
+// AppendEvent implements Store
+func (p *Postgres) AppendEvent(ctx context.Context, ev Event) (Event, error) {
+	data, err := jsonColumn(ev.Data, ev.Data == nil)
+	if err != nil {
+		return Event{}, err
+	}
+	err = p.db.QueryRowContext(ctx, `
+		WITH next AS (
+			INSERT INTO room_event_offsets (room, last_offset) VALUES ($1, 1)
+			ON CONFLICT (room) DO UPDATE SET last_offset = room_event_offsets.last_offset + 1
+			RETURNING last_offset
+		)
+		INSERT INTO room_events (`+eventColumns+`)
+		SELECT $1, last_offset, $2, $3, $4, $5, $6, $7, $8 FROM next
+		RETURNING "offset"`,
+		ev.Room, ev.Type, ev.Username, ev.MessageID, ev.Seq, ev.Content, data, ev.CreatedAt).Scan(&ev.Offset)
+	if err != nil {
+		return Event{}, fmt.Errorf("append event: %w", err)
+	}
+	return ev, nil
+}
+
+// Events implements Store
+func (p *Postgres) Events(ctx context.Context, room string, after uint64, limit int) ([]Event, error) {
+	return queryAll(ctx, p.db, scanEvent, `
+		SELECT `+eventColumns+` FROM room_events
+		WHERE room = $1 AND "offset" > $2 ORDER BY "offset" LIMIT $3`, room, after, limit)
+}
+
+// DeleteEventsBefore implements Store
+func (p *Postgres) DeleteEventsBefore(ctx context.Context, room string, t time.Time) (int, error) {
+	return p.execRows(ctx, `DELETE FROM room_events WHERE room = $1 AND created_at < $2`, room, t)
+}
+
+func insertRoomKey(ctx context.Context, db execer, k RoomKey) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO room_keys (room, version, master_key, wrapped, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (room, version) DO UPDATE SET
+			master_key = EXCLUDED.master_key, wrapped = EXCLUDED.wrapped, created_at = EXCLUDED.created_at`,
+		k.Room, k.Version, k.MasterKey, k.Wrapped, k.CreatedAt)
+	return err
+}
+
+// SaveRoomKey implements Store
+func (p *Postgres) SaveRoomKey(ctx context.Context, k RoomKey) error {
+	if err := insertRoomKey(ctx, p.db, k); err != nil {
+		return fmt.Errorf("save room key: %w", err)
+	}
+	return nil
+}
+
+// RoomKeys implements Store
+func (p *Postgres) RoomKeys(ctx context.Context, room string) ([]RoomKey, error) {
+	return p.roomKeys(ctx, `WHERE room = $1`, room)
+}
+
+// AllRoomKeys implements Store
+func (p *Postgres) AllRoomKeys(ctx context.Context) ([]RoomKey, error) {
+	return p.roomKeys(ctx, ``)
+}
+
+func (p *Postgres) roomKeys(ctx context.Context, where string, args ...any) ([]RoomKey, error) {
+	return queryAll(ctx, p.db, scanRoomKey, `
+		SELECT room, version, master_key, wrapped, created_at FROM room_keys `+where+`
+		ORDER BY room, version`, args...)
+}
+
+// Snapshot implements Store
+func (p *Postgres) Snapshot(ctx context.Context) (Snapshot, error) {
+	snap := newSnapshot()
+	sections := []func() error{
+		func() (err error) {
+			snap.Rooms, err = queryAll(ctx, p.db, scanSettings, `SELECT `+selectSettings+` FROM room_settings ORDER BY room`)
+			return err
+		},
+		func() (err error) {
+			snap.Members, err = queryAll(ctx, p.db, func(row scanner) (Membership, error) {
+				var m Membership
+				err := row.Scan(&m.Room, &m.Username)
+				return m, err
+			}, `SELECT room, username FROM room_members ORDER BY room, username`)
+			return err
+		},
+		func() (err error) {
+			snap.Messages, err = queryAll(ctx, p.db, scanMessage, `SELECT `+messageColumns+` FROM messages ORDER BY room, seq`)
+			return err
+		},
+		func() (err error) {
+			snap.Offline, err = p.offlineQueues(ctx)
+			return err
+		},
+		func() (err error) {
+			snap.Events, err = queryAll(ctx, p.db, scanEvent, `SELECT `+eventColumns+` FROM room_events ORDER BY room, "offset"`)
+			return err
+		},
+		func() (err error) {
+			snap.Reviews, err = queryAll(ctx, p.db, scanReviewItem, `SELECT `+selectReview+` FROM review_queue ORDER BY created_at, id`)
+			return err
+		},
+		func() (err error) {
+			snap.Bans, err = queryAll(ctx, p.db, scanBan, `SELECT `+banColumns+` FROM room_bans ORDER BY room, username`)
+			return err
+		},
+		func() (err error) {
+			snap.Alerts, err = queryAll(ctx, p.db, scanAlert, `SELECT `+selectAlert+` FROM keyword_alerts ORDER BY room, moderator`)
+			return err
+		},
+		func() (err error) {
+			snap.Users, err = queryAll(ctx, p.db, scanUser, `SELECT `+userColumns+` FROM users ORDER BY username`)
+			return err
+		},
+		func() (err error) {
+			snap.Onboarded, err = queryAll(ctx, p.db, func(row scanner) (Onboarded, error) {
+				var o Onboarded
+				err := row.Scan(&o.Room, &o.Username, &o.At)
+				return o, err
+			}, `SELECT room, username, onboarded_at FROM room_onboarded ORDER BY room, username`)
+			return err
+		},
+		func() (err error) {
+			snap.Announcements, err = queryAll(ctx, p.db, scanAnnouncement,
+				`SELECT `+selectAnnouncement+` FROM announcements ORDER BY created_at, id`)
+			return err
+		},
+		func() (err error) {
+			snap.StickerPacks, err = queryAll(ctx, p.db, scanPack, `SELECT `+selectPack+` FROM sticker_packs ORDER BY created_at, id`)
+			return err
+		},
+		func() (err error) {
+			snap.StickerImages, err = queryAll(ctx, p.db, scanStickerImage,
+				`SELECT pack, sticker, content_type, data FROM sticker_images ORDER BY pack, sticker`)
+			return err
+		},
+		func() (err error) {
+			snap.Audio, err = queryAll(ctx, p.db, scanAudio, `SELECT `+audioColumns+` FROM audio_clips ORDER BY created_at, id`)
+			return err
+		},
+		func() (err error) {
+			snap.Uploads, err = queryAll(ctx, p.db, scanUpload, `SELECT `+uploadColumns+` FROM uploads ORDER BY created_at, id`)
+			return err
+		},
+		func() (err error) {
+			snap.Avatars, err = queryAll(ctx, p.db, scanAvatar, `SELECT id, size, data, created_at FROM avatar_images ORDER BY id, size`)
+			return err
+		},
+		func() (err error) {
+			snap.Usage, err = queryAll(ctx, p.db, scanUsage, `
+				SELECT username, day, messages, uploads, bytes FROM daily_usage ORDER BY day, username`)
+			return err
+		},
+		func() (err error) {
+			snap.Metering, err = queryAll(ctx, p.db, scanMeterRecord, `
+				SELECT `+meterColumns+` FROM meter_records ORDER BY hour, room, username`)
+			return err
+		},
+		func() (err error) {
+			snap.Tenants, err = p.Tenants(ctx)
+			return err
+		},
+		func() (err error) {
+			snap.Sync, err = queryAll(ctx, p.db, scanSyncEntry, `
+				SELECT username, key, value, updated_at FROM sync_entries ORDER BY username, key`)
+			return err
+		},
+		func() (err error) {
+			snap.Notifications, err = queryAll(ctx, p.db, scanNotificationPrefs, `
+				SELECT `+notifyColumns+` FROM notification_prefs ORDER BY username`)
+			return err
+		},
+		func() (err error) {
+			snap.Direct, err = queryAll(ctx, p.db, scanDirect, `
+				SELECT `+directColumns+` FROM direct_queue ORDER BY recipient, created_at, id`)
+			return err
+		},
+		func() (err error) {
+			snap.RoomKeys, err = p.AllRoomKeys(ctx)
+			return err
+		},
+	}
+	for _, section := range sections {
+		if err := section(); err != nil {
+			return Snapshot{}, err
+		}
+	}
+	return snap, nil
+}
+
+// offlineQueues lists every offline queue, by room then user
+func (p *Postgres) offlineQueues(ctx context.Context) ([]OfflineQueue, error) {
+	queued, err := queryAll(ctx, p.db, func(row scanner) (OfflineQueue, error) {
+		var (
+			q  OfflineQueue
+			id string
+		)
+		err := row.Scan(&q.Username, &q.Room, &id)
+		q.MessageIDs = []string{id}
+		return q, err
+	}, `SELECT username, room, message_id FROM offline_queue ORDER BY room, username, id`)
+	if err != nil {
+		return nil, err
+	}
+	queues := []OfflineQueue{}
+	for _, q := range queued {
+		if n := len(queues); n > 0 && queues[n-1].Room == q.Room && queues[n-1].Username == q.Username {
+			queues[n-1].MessageIDs = append(queues[n-1].MessageIDs, q.MessageIDs...)
+			continue
+		}
+		queues = append(queues, q)
+	}
+	return queues, nil
+}
+
+// Restore implements Store
+// Every table Postgres keeps must be empty
+func (p *Postgres) Restore(ctx context.Context, snap Snapshot) error {
+	for _, table := range pgTables {
+		var rows bool
+		if err := p.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+table+`)`).Scan(&rows); err != nil {
+			return err
+		}
+		if rows {
+			return ErrNotEmpty
+		}
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, settings := range snap.Rooms {
+		if err := insertSettings(ctx, tx, settings); err != nil {
+			return fmt.Errorf("restore room settings %s: %w", settings.Room, err)
+		}
+	}
+	for _, m := range snap.Members {
+		_, err := tx.ExecContext(ctx, `INSERT INTO room_members (room, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			m.Room, m.Username)
+		if err != nil {
+			return fmt.Errorf("restore member %s/%s: %w", m.Room, m.Username, err)
+		}
+	}
+	for _, msg := range snap.Messages {
+		if err := insertMessage(ctx, tx, msg); err != nil {
+			return fmt.Errorf("restore message %s: %w", msg.ID, err)
+		}
+	}
+	for _, q := range snap.Offline {
+		for _, id := range q.MessageIDs {
+			if err := queueOffline(ctx, tx, q.Username, q.Room, id); err != nil {
+				return fmt.Errorf("restore offline queue %s/%s: %w", q.Room, q.Username, err)
+			}
+		}
+	}
+	for _, ev := range snap.Events {
+		if err := insertEvent(ctx, tx, ev); err != nil {
+			return fmt.Errorf("restore event %s/%d: %w", ev.Room, ev.Offset, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO room_event_offsets (room, last_offset)
+		SELECT room, MAX("offset") FROM room_events GROUP BY room
+		ON CONFLICT (room) DO UPDATE SET last_offset = GREATEST(room_event_offsets.last_offset, EXCLUDED.last_offset)`)
+	if err != nil {
+		return fmt.Errorf("restore event offsets: %w", err)
+	}
+	for _, item := range snap.Reviews {
+		if err := insertReviewItem(ctx, tx, item); err != nil {
+			return fmt.Errorf("restore review item %s: %w", item.ID, err)
+		}
+	}
+	for _, ban := range snap.Bans {
+		if err := insertBan(ctx, tx, ban); err != nil {
+			return fmt.Errorf("restore ban %s/%s: %w", ban.Room, ban.Username, err)
+		}
+	}
+	for _, alert := range snap.Alerts {
+		if err := insertAlert(ctx, tx, alert); err != nil {
+			return fmt.Errorf("restore keyword alert %s/%s: %w", alert.Room, alert.Moderator, err)
+		}
+	}
+	for _, user := range snap.Users {
+		if err := insertUser(ctx, tx, user); err != nil {
+			return fmt.Errorf("restore user %s: %w", user.Username, err)
+		}
+	}
+	for _, o := range snap.Onboarded {
+		if _, err := insertOnboarded(ctx, tx, o.Room, o.Username, o.At); err != nil {
+			return fmt.Errorf("restore onboarded %s/%s: %w", o.Room, o.Username, err)
+		}
+	}
+	for _, a := range snap.Announcements {
+		if err := insertAnnouncement(ctx, tx, a); err != nil {
+			return fmt.Errorf("restore announcement %s: %w", a.ID, err)
+		}
+	}
+	for _, pack := range snap.StickerPacks {
+		if err := insertPack(ctx, tx, pack); err != nil {
+			return fmt.Errorf("restore sticker pack %s: %w", pack.ID, err)
+		}
+	}
+	for _, img := range snap.StickerImages {
+		if err := insertStickerImage(ctx, tx, img); err != nil {
+			return fmt.Errorf("restore sticker image %s/%s: %w", img.Pack, img.Sticker, err)
+		}
+	}
+	for _, clip := range snap.Audio {
+		if err := insertAudio(ctx, tx, clip); err != nil {
+			return fmt.Errorf("restore audio %s: %w", clip.ID, err)
+		}
+	}
+	for _, u := range snap.Uploads {
+		if err := insertUpload(ctx, tx, u); err != nil {
+			return fmt.Errorf("restore upload %s: %w", u.ID, err)
+		}
+	}
+	for _, img := range snap.Avatars {
+		if err := insertAvatar(ctx, tx, img); err != nil {
+			return fmt.Errorf("restore avatar %s: %w", img.ID, err)
+		}
+	}
+	for _, u := range snap.Usage {
+		if err := insertUsage(ctx, tx, u); err != nil {
+			return fmt.Errorf("restore usage of %s: %w", u.Username, err)
+		}
+	}
+	for _, r := range snap.Metering {
+		if err := addMeterRecord(ctx, tx, r); err != nil {
+			return fmt.Errorf("restore meter record of %s: %w", r.Room, err)
+		}
+	}
+	for _, t := range snap.Tenants {
+		if err := insertTenant(ctx, tx, t); err != nil {
+			return fmt.Errorf("restore tenant %s: %w", t.ID, err)
+		}
+	}
+	for _, e := range snap.Sync {
+		if err := insertSyncEntry(ctx, tx, e); err != nil {
+			return fmt.Errorf("restore sync entry of %s: %w", e.Username, err)
+		}
+	}
+	for _, prefs := range snap.Notifications {
+		if err := insertNotificationPrefs(ctx, tx, prefs); err != nil {
+			return fmt.Errorf("restore notification preferences of %s: %w", prefs.Username, err)
+		}
+	}
+	for _, dm := range snap.Direct {
+		if err := insertDirect(ctx, tx, dm); err != nil {
+			return fmt.Errorf("restore direct message %s: %w", dm.ID, err)
+		}
+	}
+	for _, k := range snap.RoomKeys {
+		if err := insertRoomKey(ctx, tx, k); err != nil {
+			return fmt.Errorf("restore room key %s/%d: %w", k.Room, k.Version, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,33 @@
+package storage
+
+import "time"
+
+/*
+Purge Overview:
+--------------
+Moderators clean up raids and spam runs by purging many messages of a
+room at once (see websockets/purge.go). A purge selects messages by
+author and time, both optional:
+
+	{"username": "eve", "since": "2024-06-10T09:00:00Z", "until": "2024-06-10T09:05:00Z"}
+
+A request for a room's last N messages is turned into since, the time
+of the oldest of them. The purge is recorded in the event log as one
+moderation event, so the history view drops the messages it selects
+that came before it, and stored copies are removed with PurgeMessages.
+*/
+
+// Purge selects a room's messages to remove
+type Purge struct {
+	Username string    `json:"username,omitempty"` // Empty for anyone's
+	Since    time.Time `json:"since"`              // Zero for the start of the room
+	Until    time.Time `json:"until"`
+}
+
+// Matches reports whether a message by username, sent at, is selected
+func (p Purge) Matches(username string, at time.Time) bool {
+	if p.Username != "" && p.Username != username {
+		return false
+	}
+	return !at.Before(p.Since) && !at.After(p.Until)
+}
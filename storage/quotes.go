@@ -0,0 +1,66 @@
+package storage
+
+/*
+Quoted Replies Overview:
+-----------------------
+A reply carries a copy of the start of the message it quotes, taken
+by the server when the reply is posted (see websockets/replies.go):
+
+	{"quote": {"id": "9b2f...", "username": "alice", "excerpt": "the deploy is...", "truncated": true}}
+
+The copy is stored with the reply, on the message and in the event
+log, so the quote reads the same after the original is deleted,
+recalled or purged.
+*/
+
+// MaxQuoteExcerpt is how many characters of the quoted message a reply keeps
+const MaxQuoteExcerpt = 200
+
+// Quote is the part of a quoted message a reply keeps
+type Quote struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Excerpt   string `json:"excerpt"`
+	Truncated bool   `json:"truncated,omitempty"` // The message went on past the excerpt
+}
+
+// NewQuote quotes the message id that author posted with content
+func NewQuote(id, author, content string) *Quote {
+	q := &Quote{ID: id, Username: author, Excerpt: content}
+	if runes := []rune(content); len(runes) > MaxQuoteExcerpt {
+		q.Excerpt, q.Truncated = string(runes[:MaxQuoteExcerpt]), true
+	}
+	return q
+}
+
+// Event log data keys for a reply's quote
+const (
+	quoteIDKey        = "quote"
+	quoteAuthorKey    = "quote_author"
+	QuoteExcerptKey   = "quote_excerpt" // Encrypted like content, see keyring
+	quoteTruncatedKey = "quote_truncated"
+)
+
+// AddTo records the quote in a message event's data
+func (q *Quote) AddTo(data map[string]string) {
+	data[quoteIDKey] = q.ID
+	data[quoteAuthorKey] = q.Username
+	data[QuoteExcerptKey] = q.Excerpt
+	if q.Truncated {
+		data[quoteTruncatedKey] = "true"
+	}
+}
+
+// QuoteOf returns the quote a message event recorded, if it was a reply
+func QuoteOf(ev Event) *Quote {
+	id, ok := ev.Data[quoteIDKey]
+	if !ok {
+		return nil
+	}
+	return &Quote{
+		ID:        id,
+		Username:  ev.Data[quoteAuthorKey],
+		Excerpt:   ev.Data[QuoteExcerptKey],
+		Truncated: ev.Data[quoteTruncatedKey] == "true",
+	}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"chat-app/errreport"
+)
+
+/*
+Retention Overview:
+------------------
+Each room decides how much history the server keeps:
+
+	forever   Keep everything (default)
+	days      Keep messages newer than Days days
+	messages  Keep the newest Messages messages
+	none      Keep nothing; history is removed on the next prune
+
+The Pruner applies these policies on an interval. With an Archiver
+configured, messages that fall out of a "days" window are handed to
+it before deletion, and are only deleted once it succeeds. Messages
+still queued for offline members are pruned like any other, so a room
+with a short retention may lose durable messages nobody picked up.
+
+The room event log follows "days" and "none" too; a "messages" room
+keeps its full event log, since events don't map one-to-one onto
+messages. Posted voice notes are treated the same way, and uploads
+never posted are dropped after AudioUploadTTL whatever the room's
+policy.
+*/
+
+// Retention policies
+const (
+	RetainForever  = "forever"
+	RetainDays     = "days"
+	RetainMessages = "messages"
+	RetainNothing  = "none"
+)
+
+// Retention is a room's history retention policy
+type Retention struct {
+	Policy   string `json:"policy"`
+	Days     int    `json:"days,omitempty"`     // For the "days" policy
+	Messages int    `json:"messages,omitempty"` // For the "messages" policy
+}
+
+// Validate checks that the policy is known and has its limit set
+func (r Retention) Validate() error {
+	switch r.Policy {
+	case RetainForever, RetainNothing:
+		return nil
+	case RetainDays:
+		if r.Days <= 0 {
+			return errors.New("days must be positive for the days policy")
+		}
+		return nil
+	case RetainMessages:
+		if r.Messages <= 0 {
+			return errors.New("messages must be positive for the messages policy")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown retention policy %q", r.Policy)
+}
+
+// Archiver keeps a copy of messages before the pruner deletes them
+type Archiver interface {
+	Archive(ctx context.Context, room string, msgs []Message) error
+}
+
+// Pruner periodically deletes history rooms no longer retain
+type Pruner struct {
+	store    Store
+	interval time.Duration
+	archiver Archiver // Optional; nil deletes without archiving
+}
+
+// PrunerOption customizes NewPruner
+type PrunerOption func(*Pruner)
+
+// WithArchiver archives expired messages before they are deleted
+func WithArchiver(a Archiver) PrunerOption {
+	return func(p *Pruner) {
+		p.archiver = a
+	}
+}
+
+// NewPruner creates a pruner that runs every interval
+func NewPruner(store Store, interval time.Duration, opts ...PrunerOption) *Pruner {
+	p := &Pruner{store: store, interval: interval}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Run prunes on every tick until ctx is cancelled
+func (p *Pruner) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if deleted, err := p.Prune(ctx, now); err != nil {
+				log.Printf("Retention prune failed: %v", err)
+				errreport.CaptureError(fmt.Errorf("retention prune: %w", err), errreport.Context{})
+			} else if deleted > 0 {
+				log.Printf("Retention pruned %d messages", deleted)
+			}
+		}
+	}
+}
+
+// Prune applies every room's retention policy once, returning messages deleted
+func (p *Pruner) Prune(ctx context.Context, now time.Time) (int, error) {
+	rooms, err := p.store.ListRoomSettings(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Abandoned uploads go whatever the room, since nothing refers to them
+	var errs []error
+	if _, err := p.store.DeleteUnpostedAudio(ctx, now.Add(-AudioUploadTTL)); err != nil {
+		errs = append(errs, fmt.Errorf("unposted audio: %w", err))
+	}
+
+	// One failing room shouldn't stop the others being pruned
+	// Unconfigured rooms keep everything, so only configured ones are visited
+	total := 0
+	for _, settings := range rooms {
+		deleted, err := p.pruneRoom(ctx, settings.Room, settings.Retention, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("room %s: %w", settings.Room, err))
+		}
+		total += deleted
+	}
+	return total, errors.Join(errs...)
+}
+
+// pruneRoom deletes whatever room's policy no longer retains
+func (p *Pruner) pruneRoom(ctx context.Context, room string, r Retention, now time.Time) (int, error) {
+	switch r.Policy {
+	case RetainDays:
+		cutoff := now.AddDate(0, 0, -r.Days)
+		if _, err := p.store.DeleteEventsBefore(ctx, room, cutoff); err != nil {
+			return 0, fmt.Errorf("events: %w", err)
+		}
+		if _, err := p.store.DeleteAudioBefore(ctx, room, cutoff); err != nil {
+			return 0, fmt.Errorf("audio: %w", err)
+		}
+		if p.archiver != nil {
+			expired, err := p.store.MessagesBefore(ctx, room, cutoff)
+			if err != nil {
+				return 0, err
+			}
+			if len(expired) == 0 {
+				return 0, nil
+			}
+			// Keep the messages if the archive didn't take them
+			if err := p.archiver.Archive(ctx, room, expired); err != nil {
+				return 0, fmt.Errorf("archive: %w", err)
+			}
+		}
+		return p.store.DeleteMessagesBefore(ctx, room, cutoff)
+	case RetainMessages:
+		return p.store.TrimMessages(ctx, room, r.Messages)
+	case RetainNothing:
+		if _, err := p.store.DeleteEventsBefore(ctx, room, now); err != nil {
+			return 0, fmt.Errorf("events: %w", err)
+		}
+		if _, err := p.store.DeleteAudioBefore(ctx, room, now); err != nil {
+			return 0, fmt.Errorf("audio: %w", err)
+		}
+		return p.store.TrimMessages(ctx, room, 0)
+	}
+	return 0, nil
+}
@@ -0,0 +1,56 @@
+package storage
+
+import "time"
+
+/*
+Review Queue Overview:
+---------------------
+Messages that need a moderator's decision wait in the review queue
+until someone approves or deletes them, or bans their author:
+
+	automod    broke an automatic rule (the room's link policy)
+	toxicity   scored high by the moderation API
+	report     reported by other users
+
+A message gets one entry however many times it is raised; later
+reasons and reporters are merged into it. Bans are per room and may
+expire.
+*/
+
+// Review sources
+const (
+	ReviewAutomod  = "automod"
+	ReviewToxicity = "toxicity"
+	ReviewReport   = "report"
+)
+
+// ReviewItem is one entry in the moderation review queue
+type ReviewItem struct {
+	ID        string    `json:"id"` // The message ID when there is one
+	Room      string    `json:"room"`
+	MessageID string    `json:"message_id,omitempty"` // Empty for messages that were never sent
+	Username  string    `json:"username"`             // The author
+	Content   string    `json:"content"`
+	Sources   []string  `json:"sources"`             // ReviewAutomod, ReviewToxicity, ReviewReport
+	Reasons   []string  `json:"reasons,omitempty"`   // Rule hits, score categories, reporters' reasons
+	Reporters []string  `json:"reporters,omitempty"` // Users who reported it
+	Score     float64   `json:"score,omitempty"`     // Highest toxicity score seen
+	Hidden    bool      `json:"hidden,omitempty"`    // Hidden from the room until reviewed
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Ban keeps a user out of a room
+type Ban struct {
+	Room      string    `json:"room"`
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason,omitempty"`
+	By        string    `json:"by,omitempty"` // The moderator
+	CreatedAt time.Time `json:"created_at"`
+	Until     time.Time `json:"until,omitempty"` // Zero for a permanent ban
+}
+
+// Active reports whether the ban is in force at now
+func (b Ban) Active(now time.Time) bool {
+	return b.Until.IsZero() || now.Before(b.Until)
+}
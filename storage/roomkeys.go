@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+Room Keys Overview:
+------------------
+With encryption at rest on (see the keyring package) each room's
+message content is encrypted with a data key of its own. The store
+keeps every version of each room's key, so content written before a
+rotation can still be read, but only wrapped: encrypted by a master
+key that never touches the store. A copy of the store, or a backup,
+is useless without the master key.
+*/
+
+// RoomKey is one version of a room's data key, wrapped by a master key
+type RoomKey struct {
+	Room      string    `json:"room"`
+	Version   int       `json:"version"`    // From 1, newest is in use
+	MasterKey string    `json:"master_key"` // ID of the master key that wrapped it
+	Wrapped   []byte    `json:"wrapped"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type roomKeyID struct {
+	room    string
+	version int
+}
+
+// sortRoomKeys orders keys by room, then version
+func sortRoomKeys(keys []RoomKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		return a.Room < b.Room || (a.Room == b.Room && a.Version < b.Version)
+	})
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+Sticker Overview:
+----------------
+Admins upload sticker images in packs. A pack is offered in the rooms
+it lists, or in every room when it lists none:
+
+	{"id": "cats", "name": "Cats", "rooms": ["lobby"],
+	 "stickers": [{"id": "wave", "alt": "👋", "content_type": "image/png", "size": 18211}]}
+
+Pack and sticker IDs are chosen by the admin and appear in sticker
+messages and image URLs, so they are short slugs. alt is the text
+shown, stored and bridged where the image can't be. The images are
+kept apart from the pack (StickerImage) so listing packs stays cheap.
+*/
+
+// Sticker limits
+const (
+	MaxStickerPacks     = 50
+	MaxStickersPerPack  = 100
+	MaxStickerImageSize = 512 << 10 // Bytes
+	MaxStickerDimension = 1024      // Pixels, either way
+	MaxStickerAlt       = 64        // Bytes
+)
+
+// StickerPack is a named set of stickers
+type StickerPack struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Rooms     []string  `json:"rooms,omitempty"` // Where it is offered; every room when empty
+	Stickers  []Sticker `json:"stickers"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Sticker describes one image in a pack
+type Sticker struct {
+	ID          string    `json:"id"`
+	Alt         string    `json:"alt"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// StickerImage is a sticker's uploaded image
+type StickerImage struct {
+	Pack        string `json:"pack"`
+	Sticker     string `json:"sticker"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// StickerRef names the sticker a stored message showed
+type StickerRef struct {
+	Pack string `json:"pack"`
+	ID   string `json:"id"`
+}
+
+// stickerIDPattern is what pack and sticker IDs may look like
+var stickerIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidStickerID reports whether id can name a pack or sticker
+func ValidStickerID(id string) bool {
+	return stickerIDPattern.MatchString(id)
+}
+
+// OfferedIn reports whether the pack is offered in room
+func (p StickerPack) OfferedIn(room string) bool {
+	if len(p.Rooms) == 0 {
+		return true
+	}
+	for _, r := range p.Rooms {
+		if r == room {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the sticker with id
+func (p StickerPack) Find(id string) (Sticker, bool) {
+	for _, s := range p.Stickers {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return Sticker{}, false
+}
+
+// Validate checks the pack's details; stickers are checked as they are uploaded
+func (p *StickerPack) Validate() error {
+	if !ValidStickerID(p.ID) {
+		return errors.New("pack id must be 1-32 of a-z, 0-9, _ and -, starting with a letter or digit")
+	}
+	if p.Name = strings.TrimSpace(p.Name); p.Name == "" {
+		return errors.New("name is required")
+	}
+	for i, room := range p.Rooms {
+		if p.Rooms[i] = strings.TrimSpace(room); p.Rooms[i] == "" {
+			return errors.New("rooms must not be blank")
+		}
+	}
+	if len(p.Stickers) > MaxStickersPerPack {
+		return fmt.Errorf("at most %d stickers per pack", MaxStickersPerPack)
+	}
+	return nil
+}
+
+// Validate checks the sticker's ID and alt text
+func (s *Sticker) Validate() error {
+	if !ValidStickerID(s.ID) {
+		return errors.New("sticker id must be 1-32 of a-z, 0-9, _ and -, starting with a letter or digit")
+	}
+	if s.Alt = strings.TrimSpace(s.Alt); s.Alt == "" || len(s.Alt) > MaxStickerAlt {
+		return fmt.Errorf("alt must be 1-%d bytes of text", MaxStickerAlt)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+Storage Overview:
+----------------
+The storage layer persists what must survive a connection or a
+restart. The hub talks to it only through the Store interface, so
+backends can be swapped without touching the message path.
+
+Current responsibilities:
+1. Persisting messages sent with an at-least-once or durable QoS
+2. Remembering which users belong to a room
+3. Queueing durable messages for members who are offline
+4. Per-room settings (retention, link and join policies, onboarding,
+   permissions, tags and metadata, archived rooms, breakouts), and pruning history they no longer
+   retain
+5. The append-only room event log (events.go)
+6. The moderation review queue and room bans (review.go)
+7. When each username was first seen, for account age gates (joins.go),
+   whose age was confirmed, for NSFW rooms (metadata.go), and who has
+   been onboarded where (onboarding.go)
+8. Scheduled announcements (announcements.go)
+9. Sticker packs and their images (stickers.go)
+10. Uploaded voice notes (audio.go)
+11. Records of attachments uploaded to object storage (uploads.go)
+12. User avatars (avatars.go)
+13. Daily usage counted against quotas (usage.go)
+14. Hourly usage records for accounting (metering.go)
+15. Tenants, their API key hashes and limits (tenants.go)
+16. Small per-user state synced across devices, like drafts (sync.go)
+17. Users' notification preferences (notifications.go)
+18. Rooms' wrapped data keys for encryption at rest (roomkeys.go)
+19. Snapshots for backup and restore
+
+Memory is the default backend; it is fast and dependency free but
+everything is lost on restart. Postgres (postgres.go) keeps it all in
+PostgreSQL instead.
+*/
+
+// ErrNotFound is returned when a requested record doesn't exist
+var ErrNotFound = errors.New("storage: not found")
+
+// ErrNotEmpty is returned when restoring into a store that already has data
+var ErrNotEmpty = errors.New("storage: store is not empty")
+
+// Message is a persisted chat message
+type Message struct {
+	ID        string    `json:"id"`
+	Room      string    `json:"room"`
+	Username  string    `json:"username"`
+	Type      string    `json:"type"`
+	Content   string    `json:"content"`
+	Seq       uint64    `json:"seq"`
+	QoS       string    `json:"qos,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// The sticker shown, on "sticker" messages; Content is its alt text
+	Sticker *StickerRef `json:"sticker,omitempty"`
+	// The voice note's clip ID, on "audio" messages
+	Audio string `json:"audio,omitempty"`
+	// The upload's ID, on "attachment" messages
+	Attachment string `json:"attachment,omitempty"`
+	// The message replied to, on replies, see quotes.go
+	Quote *Quote `json:"quote,omitempty"`
+	// Hidden by moderation, pending review or with a ban; left out of history
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// RoomSettings holds per-room configuration
+type RoomSettings struct {
+	Room        string           `json:"room"`
+	Retention   Retention        `json:"retention"`
+	Links       LinkPolicy       `json:"links"`
+	Joins       JoinPolicy       `json:"joins"`
+	Onboarding  Onboarding       `json:"onboarding"`
+	Emoji       CustomEmoji      `json:"emoji"`
+	Permissions Permissions      `json:"permissions"`
+	Invites     InvitePolicy     `json:"invites"`
+	Federation  FederationPolicy `json:"federation"`
+	Tags        []string         `json:"tags,omitempty"`     // Labels, see tags.go
+	Metadata    RoomMetadata     `json:"metadata"`           // Language and content flags, see metadata.go
+	Archived    *Archival        `json:"archived,omitempty"` // Read-only since then, see archived.go
+	Breakout    *Breakout        `json:"breakout,omitempty"` // A temporary room under another, see breakouts.go
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// DefaultRoomSettings is used for rooms that were never configured
+func DefaultRoomSettings(room string) RoomSettings {
+	return RoomSettings{Room: room, Retention: Retention{Policy: RetainForever}}
+}
+
+// Store is implemented by every storage backend
+// Implementations must be safe for concurrent use
+type Store interface {
+	// SaveMessage persists a message
+	SaveMessage(ctx context.Context, msg Message) error
+	// GetMessage loads a message by ID, returning ErrNotFound if missing
+	GetMessage(ctx context.Context, id string) (Message, error)
+	// DeleteMessage removes a message by ID, returning ErrNotFound if missing
+	DeleteMessage(ctx context.Context, id string) error
+	// LastSeq returns the highest sequence number stored for room, in its
+	// messages or its event log (0 if none)
+	LastSeq(ctx context.Context, room string) (uint64, error)
+	// MessagePage lists up to limit of a room's numbered messages that
+	// aren't hidden, with seq below before (any seq when before is 0),
+	// newest first
+	MessagePage(ctx context.Context, room string, before uint64, limit int) ([]Message, error)
+	// SetMessageHidden hides a message from history, or shows it again,
+	// returning ErrNotFound if missing
+	SetMessageHidden(ctx context.Context, id string, hidden bool) error
+	// HideMessages hides a room's messages selected by p (purge.go)
+	HideMessages(ctx context.Context, room string, p Purge) (int, error)
+
+	// AddMember records that username has joined room
+	AddMember(ctx context.Context, room, username string) error
+	// Members lists every user who has joined room
+	Members(ctx context.Context, room string) ([]string, error)
+
+	// QueueOffline holds a message for a member who isn't connected
+	QueueOffline(ctx context.Context, username, room, messageID string) error
+	// TakeOffline removes and returns the messages queued for username in room, oldest first
+	TakeOffline(ctx context.Context, username, room string) ([]Message, error)
+	// QueueDirect holds a direct message for a user who isn't connected,
+	// returning ErrDirectFull past MaxQueuedDirect
+	QueueDirect(ctx context.Context, dm DirectMessage) error
+	// TakeDirect removes and returns the direct messages queued for username, oldest first
+	TakeDirect(ctx context.Context, username string) ([]DirectMessage, error)
+
+	// AppendEvent adds ev to its room's event log and returns it with its offset
+	AppendEvent(ctx context.Context, ev Event) (Event, error)
+	// Events lists up to limit of a room's events with offset > after, oldest first
+	Events(ctx context.Context, room string, after uint64, limit int) ([]Event, error)
+	// DeleteEventsBefore removes a room's events created before t
+	DeleteEventsBefore(ctx context.Context, room string, t time.Time) (int, error)
+
+	// GetRoomSettings loads a room's settings, returning ErrNotFound if never saved
+	GetRoomSettings(ctx context.Context, room string) (RoomSettings, error)
+	// SaveRoomSettings creates or replaces a room's settings
+	SaveRoomSettings(ctx context.Context, settings RoomSettings) error
+	// ListRoomSettings returns the settings of every configured room
+	ListRoomSettings(ctx context.Context) ([]RoomSettings, error)
+
+	// MessagesBefore lists a room's messages created before t, oldest first
+	MessagesBefore(ctx context.Context, room string, t time.Time) ([]Message, error)
+	// DeleteMessagesBefore removes a room's messages created before t
+	DeleteMessagesBefore(ctx context.Context, room string, t time.Time) (int, error)
+	// TrimMessages removes all but the newest keep messages of a room
+	TrimMessages(ctx context.Context, room string, keep int) (int, error)
+	// PurgeMessages removes a room's messages selected by p (purge.go)
+	PurgeMessages(ctx context.Context, room string, p Purge) (int, error)
+
+	// SaveReviewItem creates or replaces a review queue entry
+	SaveReviewItem(ctx context.Context, item ReviewItem) error
+	// GetReviewItem loads a review queue entry, returning ErrNotFound if missing
+	GetReviewItem(ctx context.Context, id string) (ReviewItem, error)
+	// ReviewItems lists up to limit review queue entries, oldest first
+	ReviewItems(ctx context.Context, limit int) ([]ReviewItem, error)
+	// DeleteReviewItem removes a review queue entry, returning ErrNotFound if missing
+	DeleteReviewItem(ctx context.Context, id string) error
+
+	// SaveBan creates or replaces a user's ban from a room
+	SaveBan(ctx context.Context, ban Ban) error
+	// GetBan loads a user's ban from a room, returning ErrNotFound if none
+	GetBan(ctx context.Context, room, username string) (Ban, error)
+	// Bans lists a room's bans, including expired ones not yet removed
+	Bans(ctx context.Context, room string) ([]Ban, error)
+	// DeleteBan lifts a ban, returning ErrNotFound if there was none
+	DeleteBan(ctx context.Context, room, username string) error
+
+	// SaveKeywordAlert creates or replaces a moderator's watch list for a room
+	SaveKeywordAlert(ctx context.Context, alert KeywordAlert) error
+	// KeywordAlerts lists a room's watch lists, by moderator
+	KeywordAlerts(ctx context.Context, room string) ([]KeywordAlert, error)
+	// DeleteKeywordAlert removes a watch list, returning ErrNotFound if missing
+	DeleteKeywordAlert(ctx context.Context, room, moderator string) error
+
+	// FirstSeen returns when username was first seen, recording now if never
+	FirstSeen(ctx context.Context, username string, now time.Time) (time.Time, error)
+	// MarkOnboarded records that username was onboarded in room at now
+	// It reports false, changing nothing, if they already were
+	MarkOnboarded(ctx context.Context, room, username string, now time.Time) (bool, error)
+
+	// SaveAnnouncement creates or replaces a scheduled announcement
+	SaveAnnouncement(ctx context.Context, a Announcement) error
+	// GetAnnouncement loads an announcement, returning ErrNotFound if missing
+	GetAnnouncement(ctx context.Context, id string) (Announcement, error)
+	// Announcements lists every announcement, oldest first
+	Announcements(ctx context.Context) ([]Announcement, error)
+	// MarkAnnouncementRun moves an announcement's LastRun from last to at,
+	// leaving edits made meanwhile alone; it returns ErrNotFound if the
+	// announcement is gone or its LastRun is no longer last, because
+	// another node sharing the store recorded the run first
+	MarkAnnouncementRun(ctx context.Context, id string, last, at time.Time) error
+	// DeleteAnnouncement removes an announcement, returning ErrNotFound if missing
+	DeleteAnnouncement(ctx context.Context, id string) error
+
+	// SaveStickerPack creates or replaces a sticker pack
+	SaveStickerPack(ctx context.Context, p StickerPack) error
+	// GetStickerPack loads a pack, returning ErrNotFound if missing
+	GetStickerPack(ctx context.Context, id string) (StickerPack, error)
+	// StickerPacks lists every pack, oldest first
+	StickerPacks(ctx context.Context) ([]StickerPack, error)
+	// DeleteStickerPack removes a pack and its images, returning ErrNotFound if missing
+	DeleteStickerPack(ctx context.Context, id string) error
+	// SaveStickerImage creates or replaces a sticker's image
+	SaveStickerImage(ctx context.Context, img StickerImage) error
+	// GetStickerImage loads a sticker's image, returning ErrNotFound if missing
+	GetStickerImage(ctx context.Context, pack, sticker string) (StickerImage, error)
+	// DeleteStickerImage removes a sticker's image; a missing image is not an error
+	DeleteStickerImage(ctx context.Context, pack, sticker string) error
+
+	// SaveAudio stores an uploaded voice note
+	SaveAudio(ctx context.Context, clip AudioClip) error
+	// GetAudio loads a voice note with its data, returning ErrNotFound if missing
+	GetAudio(ctx context.Context, id string) (AudioClip, error)
+	// MarkAudioPosted records that a voice note was posted, returning ErrNotFound if missing
+	MarkAudioPosted(ctx context.Context, id string) error
+	// DeleteAudioBefore removes a room's posted voice notes uploaded before t
+	DeleteAudioBefore(ctx context.Context, room string, t time.Time) (int, error)
+	// DeleteUnpostedAudio removes voice notes uploaded before t that were never posted
+	DeleteUnpostedAudio(ctx context.Context, t time.Time) (int, error)
+
+	// SaveUpload creates or replaces an upload record
+	SaveUpload(ctx context.Context, u Upload) error
+	// GetUpload loads an upload, returning ErrNotFound if missing
+	GetUpload(ctx context.Context, id string) (Upload, error)
+	// MarkUploadPosted records that an upload was posted, returning ErrNotFound if missing
+	MarkUploadPosted(ctx context.Context, id string) error
+	// UnpostedUploads lists uploads created before t that were never posted
+	UnpostedUploads(ctx context.Context, t time.Time) ([]Upload, error)
+	// DeleteUpload removes an upload record; a missing record is not an error
+	DeleteUpload(ctx context.Context, id string) error
+
+	// GetUser loads what is known about a username, returning ErrNotFound if never seen
+	GetUser(ctx context.Context, username string) (User, error)
+	// SetAvatar points a user's profile at an avatar; an empty id removes it
+	// A user never seen before is recorded as first seen now
+	SetAvatar(ctx context.Context, username, id string, now time.Time) error
+	// SetAgeConfirmed records whether a user's age was confirmed, for NSFW
+	// rooms (metadata.go); a user never seen before is recorded as first seen now
+	SetAgeConfirmed(ctx context.Context, username string, confirmed bool, now time.Time) error
+	// SaveAvatar stores an avatar's renditions; saving an existing ID again is harmless
+	SaveAvatar(ctx context.Context, images []AvatarImage) error
+	// GetAvatar loads one rendition of an avatar, returning ErrNotFound if missing
+	GetAvatar(ctx context.Context, id string, size int) (AvatarImage, error)
+
+	// AddUsage adds delta to username's usage on day unless the total
+	// would exceed limits, whose zero fields are unlimited; it returns the
+	// usage afterwards, or unchanged and false when delta didn't fit
+	AddUsage(ctx context.Context, username string, day time.Time, delta, limits Usage) (Usage, bool, error)
+	// GetUsage returns username's usage on day, zero if nothing was recorded
+	GetUsage(ctx context.Context, username string, day time.Time) (Usage, error)
+	// DeleteUsageBefore removes the usage recorded for days before day
+	DeleteUsageBefore(ctx context.Context, day time.Time) (int, error)
+
+	// AddMeterRecords adds each record's counters to those stored for its
+	// room, user and hour, keeping the larger peak
+	AddMeterRecords(ctx context.Context, records []MeterRecord) error
+	// MeterRecords lists the records for hours in [from, to), oldest first
+	MeterRecords(ctx context.Context, from, to time.Time) ([]MeterRecord, error)
+	// RoomMeterRecords lists room's records for hours in [from, to), oldest first
+	RoomMeterRecords(ctx context.Context, room string, from, to time.Time) ([]MeterRecord, error)
+	// DeleteMeterRecordsBefore removes the records for hours before t
+	DeleteMeterRecordsBefore(ctx context.Context, t time.Time) (int, error)
+
+	// SaveTenant creates or replaces a tenant
+	SaveTenant(ctx context.Context, t Tenant) error
+	// GetTenant loads a tenant, returning ErrNotFound if missing
+	GetTenant(ctx context.Context, id string) (Tenant, error)
+	// TenantByKey loads the tenant whose API key hashes to keyHash,
+	// returning ErrNotFound if there is none
+	TenantByKey(ctx context.Context, keyHash string) (Tenant, error)
+	// Tenants lists every tenant, oldest first
+	Tenants(ctx context.Context) ([]Tenant, error)
+	// DeleteTenant removes a tenant, returning ErrNotFound if missing
+	DeleteTenant(ctx context.Context, id string) error
+
+	// SyncEntries lists a user's synced state, by key
+	SyncEntries(ctx context.Context, username string) ([]SyncEntry, error)
+	// SaveSyncEntry creates or replaces one key of a user's synced state,
+	// returning ErrSyncFull for a new key past MaxSyncKeys
+	SaveSyncEntry(ctx context.Context, e SyncEntry) error
+	// DeleteSyncEntry removes one key, returning ErrNotFound if missing
+	DeleteSyncEntry(ctx context.Context, username, key string) error
+
+	// GetNotificationPrefs loads a user's notification preferences,
+	// returning ErrNotFound if they never set any
+	GetNotificationPrefs(ctx context.Context, username string) (NotificationPrefs, error)
+	// SaveNotificationPrefs creates or replaces a user's notification preferences
+	SaveNotificationPrefs(ctx context.Context, p NotificationPrefs) error
+
+	// SaveRoomKey creates or replaces one version of a room's data key
+	SaveRoomKey(ctx context.Context, k RoomKey) error
+	// RoomKeys lists every version of a room's data key, oldest first
+	RoomKeys(ctx context.Context, room string) ([]RoomKey, error)
+	// AllRoomKeys lists every room's data keys, by room then version
+	AllRoomKeys(ctx context.Context) ([]RoomKey, error)
+
+	// Snapshot exports everything in the store for a backup
+	Snapshot(ctx context.Context) (Snapshot, error)
+	// Restore loads a snapshot, returning ErrNotEmpty unless the store is empty
+	Restore(ctx context.Context, snap Snapshot) error
+
+	// Close releases backend resources
+	Close() error
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+Sync Overview:
+-------------
+Each user has a small key-value store kept for their devices to
+share, such as the unsent draft of a message per room:
+
+	{"key": "draft:lobby", "value": "half a thought", "updated_at": "..."}
+
+Keys are up to MaxSyncKeyLen printable characters and values up to
+MaxSyncValue bytes. A user holds at most MaxSyncKeys entries, so the
+store can't be used for bulk storage. What keys mean is up to
+clients; draft:<room> is the convention for drafts.
+*/
+
+// Limits on a user's sync entries
+const (
+	MaxSyncKeys   = 100
+	MaxSyncKeyLen = 128
+	MaxSyncValue  = 8 << 10
+)
+
+// ErrSyncFull is returned when saving a new key for a user who has MaxSyncKeys
+var ErrSyncFull = errors.New("storage: too many sync keys")
+
+// SyncEntry is one key of a user's synced state
+type SyncEntry struct {
+	Username  string    `json:"username"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the entry's key and value against the limits
+func (e SyncEntry) Validate() error {
+	if e.Key == "" || len(e.Key) > MaxSyncKeyLen {
+		return fmt.Errorf("keys must be 1-%d characters", MaxSyncKeyLen)
+	}
+	if strings.IndexFunc(e.Key, func(r rune) bool { return !unicode.IsPrint(r) || r == ' ' }) >= 0 {
+		return errors.New("keys must be printable, without spaces")
+	}
+	if len(e.Value) > MaxSyncValue {
+		return fmt.Errorf("values must be at most %d bytes", MaxSyncValue)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+Room Tags Overview:
+------------------
+Rooms can be labelled with tags, e.g. a topic area or the team that
+uses them, so they can be found and acted on together:
+
+	{"tags": ["incident", "team-payments"]}
+
+Tags are lowercase letters, digits, - and _, at most MaxTagLength
+long; NormalizeTags lowercases, trims and deduplicates them. Owners
+holding the tag capability set them (see api/tags.go), admins set
+them with the rest of the settings, and the rooms list filters by
+them.
+*/
+
+// Limits on a room's tags
+const (
+	MaxRoomTags  = 20
+	MaxTagLength = 32
+)
+
+// NormalizeTags returns tags lowercased, deduplicated and sorted, or an
+// error naming the first one that isn't a valid tag
+func NormalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !ValidTag(tag) {
+			return nil, fmt.Errorf("tag %q must be 1-%d lowercase letters, digits, - or _", tag, MaxTagLength)
+		}
+		out = append(out, tag)
+	}
+	slices.Sort(out)
+	out = slices.Compact(out)
+	if len(out) > MaxRoomTags {
+		return nil, fmt.Errorf("at most %d tags", MaxRoomTags)
+	}
+	return out, nil
+}
+
+// ValidTag reports whether tag is a valid, normalized tag
+func ValidTag(tag string) bool {
+	if tag == "" || len(tag) > MaxTagLength {
+		return false
+	}
+	for _, r := range tag {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// Tagged reports whether the room has every one of tags
+func (s RoomSettings) Tagged(tags ...string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(s.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// RoomsTagged lists the rooms in s with every one of tags, sorted
+func RoomsTagged(ctx context.Context, s Store, tags ...string) ([]string, error) {
+	all, err := s.ListRoomSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rooms := []string{}
+	for _, settings := range all {
+		if len(settings.Tags) > 0 && settings.Tagged(tags...) {
+			rooms = append(rooms, settings.Room)
+		}
+	}
+	slices.Sort(rooms)
+	return rooms, nil
+}
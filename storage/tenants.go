@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Tenant Overview:
+---------------
+A hosted deployment serves several customers (tenants) from one
+cluster. Each tenant gets an API key and limits on what its clients
+may use at once:
+
+	{"id": "3f9a1c0d2b7e4a61", "name": "acme",
+	 "limits": {"max_connections": 500, "max_rooms": 20,
+	            "message_rate": 50, "message_burst": 100}}
+
+Only a hash of the key is stored (see the tenant package); the key
+itself is shown once, when it is issued. Zero limits are unlimited.
+*/
+
+// Limits on one tenant
+const MaxTenantName = 64
+
+// Tenant is a customer sharing the deployment
+type Tenant struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	KeyHash   string       `json:"key_hash"` // SHA-256 of the API key, hex
+	Limits    TenantLimits `json:"limits"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// TenantLimits caps a tenant's clients; zero fields are unlimited
+type TenantLimits struct {
+	MaxConnections int     `json:"max_connections"` // Open WebSocket connections
+	MaxRooms       int     `json:"max_rooms"`       // Rooms with a connection open
+	MessageRate    float64 `json:"message_rate"`    // Messages per second, all connections
+	MessageBurst   int     `json:"message_burst"`   // Messages allowed at once; defaults to the rate
+}
+
+// Validate checks the name and limits
+func (t *Tenant) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(t.Name) > MaxTenantName {
+		return fmt.Errorf("name must be at most %d characters", MaxTenantName)
+	}
+	l := t.Limits
+	if l.MaxConnections < 0 || l.MaxRooms < 0 || l.MessageRate < 0 || l.MessageBurst < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import "time"
+
+/*
+Upload Overview:
+---------------
+Attachments are uploaded by clients straight to object storage with a
+presigned URL (see the uploads package); only their records live
+here. An Upload is created when the URL is issued and marked posted
+once its attachment message has gone to the room:
+
+	{"id": "7c1e...", "room": "lobby", "username": "alice",
+	 "name": "report.pdf", "content_type": "application/pdf",
+	 "size": 482113, "key": "uploads/7c1e...", "posted": true}
+
+Uploads never posted are swept, object and record, by the uploads
+package once they are old enough.
+*/
+
+// Upload is a file a client was allowed to upload for a room
+type Upload struct {
+	ID          string    `json:"id"`
+	Room        string    `json:"room"`
+	Username    string    `json:"username"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Key         string    `json:"key"` // Object key in the uploads bucket
+	Posted      bool      `json:"posted"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,49 @@
+package storage
+
+import "time"
+
+/*
+Usage Overview:
+--------------
+Daily quotas (see the quota package) count what each user has used
+in the current UTC day:
+
+	{"username": "alice", "day": "2024-06-10T00:00:00Z",
+	 "messages": 120, "uploads": 2, "bytes": 498113}
+
+AddUsage checks and adds in one step, so connections to different
+nodes sharing a store can't both take the last of a quota. Counters
+for past days are kept until DeleteUsageBefore removes them.
+*/
+
+// Usage is what a user has used in a day, or a limit on it
+type Usage struct {
+	Messages int64 `json:"messages"`
+	Uploads  int64 `json:"uploads"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// Fits reports whether u stays within limits; zero limits are unlimited
+func (u Usage) Fits(limits Usage) bool {
+	return (limits.Messages <= 0 || u.Messages <= limits.Messages) &&
+		(limits.Uploads <= 0 || u.Uploads <= limits.Uploads) &&
+		(limits.Bytes <= 0 || u.Bytes <= limits.Bytes)
+}
+
+// Add returns the sum of u and v
+func (u Usage) Add(v Usage) Usage {
+	return Usage{Messages: u.Messages + v.Messages, Uploads: u.Uploads + v.Uploads, Bytes: u.Bytes + v.Bytes}
+}
+
+// DailyUsage is a user's usage on one UTC day, as backed up
+type DailyUsage struct {
+	Username string    `json:"username"`
+	Day      time.Time `json:"day"` // Midnight UTC
+	Usage
+}
+
+// usageKey identifies a user's counters for a day
+type usageKey struct {
+	username string
+	day      time.Time
+}
@@ -0,0 +1,362 @@
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-app/metrics"
+	"chat-app/ratelimit"
+	"chat-app/storage"
+)
+
+/*
+Tenant Overview:
+---------------
+A hosted deployment serves several customers, tenants, from one
+cluster. Each tenant is issued an API key, which its clients send
+with every WebSocket connection and REST request:
+
+	GET /ws/lobby?username=alice&api_key=ck_4f1d...
+	X-API-Key: ck_4f1d...
+
+Keys are random, shown once when issued and stored only as a hash
+(see storage.Tenant), so a leaked backup doesn't leak them. Lookups
+are cached for the registry's TTL, which is how long a revoked key
+or a changed limit takes to reach other nodes.
+
+A Registry also enforces each tenant's limits (see
+storage.TenantLimits) on the connections this node holds:
+
+	connections   open WebSocket connections
+	rooms         distinct rooms those connections are in
+	message_rate  messages per second across them, with a burst
+
+Connect takes a Lease for a connection, or fails with a LimitError;
+the lease checks messages against the rate and is released when the
+connection closes. Limits are counted per node; behind a load
+balancer spreading a tenant over n nodes, it can use up to n times
+as much.
+*/
+
+// ErrUnknownKey is returned for a missing, revoked or mistyped API key
+var ErrUnknownKey = errors.New("invalid API key")
+
+// Limit names, used in errors and metric labels
+const (
+	Connections = "connections"
+	Rooms       = "rooms"
+	MessageRate = "message_rate"
+)
+
+// Where clients send their key
+const (
+	KeyHeader = "X-API-Key"
+	KeyParam  = "api_key" // For browsers, which can't set WebSocket headers
+)
+
+// keyPrefix marks chat-app keys so they stand out in configs and scanners
+const keyPrefix = "ck_"
+
+// LimitError reports which of a tenant's limits was reached
+type LimitError struct {
+	Tenant     string
+	Limit      string        // Connections, Rooms or MessageRate
+	Max        float64       // The limit's value
+	RetryAfter time.Duration // When a message would fit, for MessageRate
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case Connections:
+		return fmt.Sprintf("tenant %s has reached its limit of %d open connections", e.Tenant, int(e.Max))
+	case Rooms:
+		return fmt.Sprintf("tenant %s has reached its limit of %d rooms", e.Tenant, int(e.Max))
+	default:
+		return fmt.Sprintf("tenant %s is over its limit of %g messages per second", e.Tenant, e.Max)
+	}
+}
+
+// Registry authenticates API keys and counts what each tenant uses
+type Registry struct {
+	store storage.Store
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	keys  map[string]cachedKey // By key hash
+	usage map[string]*usage    // By tenant ID
+}
+
+// cachedKey is a key lookup, good until expires
+type cachedKey struct {
+	tenant  storage.Tenant
+	expires time.Time
+}
+
+// usage is what one tenant has open on this node
+type usage struct {
+	name    string
+	limits  storage.TenantLimits
+	checked time.Time // When limits were last loaded
+	conns   int
+	rooms   map[string]int // Connections per room
+	bucket  *ratelimit.Bucket
+}
+
+// New returns a registry backed by store, caching lookups for ttl
+func New(store storage.Store, ttl time.Duration) *Registry {
+	return &Registry{
+		store: store,
+		ttl:   ttl,
+		keys:  make(map[string]cachedKey),
+		usage: make(map[string]*usage),
+	}
+}
+
+// NewKey returns a fresh API key and the hash to store for it
+func NewKey() (key, hash string) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate API key: %v", err)
+	}
+	key = keyPrefix + hex.EncodeToString(b)
+	return key, HashKey(key)
+}
+
+// HashKey returns the hash stored for key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyFromRequest returns the API key sent with r, if any
+func KeyFromRequest(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get(KeyHeader)); key != "" {
+		return key
+	}
+	return r.URL.Query().Get(KeyParam)
+}
+
+// Authenticate returns the tenant key belongs to, or ErrUnknownKey
+func (r *Registry) Authenticate(ctx context.Context, key string, now time.Time) (storage.Tenant, error) {
+	if key == "" {
+		return storage.Tenant{}, ErrUnknownKey
+	}
+	hash := HashKey(key)
+	r.mu.Lock()
+	cached, ok := r.keys[hash]
+	r.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.tenant, nil
+	}
+
+	t, err := r.store.TenantByKey(ctx, hash)
+	if errors.Is(err, storage.ErrNotFound) {
+		r.mu.Lock()
+		delete(r.keys, hash)
+		r.mu.Unlock()
+		return storage.Tenant{}, ErrUnknownKey
+	} else if err != nil {
+		return storage.Tenant{}, err
+	}
+	r.mu.Lock()
+	r.keys[hash] = cachedKey{tenant: t, expires: now.Add(r.ttl)}
+	if u, ok := r.usage[t.ID]; ok {
+		u.refresh(t, now)
+	}
+	r.mu.Unlock()
+	return t, nil
+}
+
+// Forget drops what this node has cached about a tenant, so a changed
+// key or limit applies here at once
+func (r *Registry) Forget(t storage.Tenant) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for hash, cached := range r.keys {
+		if cached.tenant.ID == t.ID {
+			delete(r.keys, hash)
+		}
+	}
+	if u, ok := r.usage[t.ID]; ok {
+		u.refresh(t, time.Now())
+	}
+}
+
+// Connect takes a lease for a connection by tenant t to room
+// It fails with a LimitError when t can't open another connection
+func (r *Registry) Connect(t storage.Tenant, room string) (*Lease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.usage[t.ID]
+	if !ok {
+		u = &usage{rooms: make(map[string]int)}
+		u.refresh(t, time.Now())
+		r.usage[t.ID] = u
+	}
+
+	if limit := u.limits.MaxConnections; limit > 0 && u.conns >= limit {
+		metrics.TenantRejections.WithLabelValues(u.name, Connections).Inc()
+		return nil, &LimitError{Tenant: u.name, Limit: Connections, Max: float64(limit)}
+	}
+	if limit := u.limits.MaxRooms; limit > 0 && u.rooms[room] == 0 && len(u.rooms) >= limit {
+		metrics.TenantRejections.WithLabelValues(u.name, Rooms).Inc()
+		return nil, &LimitError{Tenant: u.name, Limit: Rooms, Max: float64(limit)}
+	}
+	u.conns++
+	u.rooms[room]++
+	metrics.TenantConnections.WithLabelValues(u.name).Set(float64(u.conns))
+	metrics.TenantRooms.WithLabelValues(u.name).Set(float64(len(u.rooms)))
+	return &Lease{registry: r, id: t.ID, name: u.name, room: room}, nil
+}
+
+// Usage is what a tenant has open on this node
+type Usage struct {
+	Connections int      `json:"connections"`
+	Rooms       []string `json:"rooms"`
+}
+
+// Usage reports what tenant id has open on this node
+func (r *Registry) Usage(id string) Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := Usage{Rooms: []string{}}
+	if u, ok := r.usage[id]; ok {
+		out.Connections = u.conns
+		for room := range u.rooms {
+			out.Rooms = append(out.Rooms, room)
+		}
+		slices.Sort(out.Rooms)
+	}
+	return out
+}
+
+// refresh takes t's current name and limits, keeping the bucket unless
+// the rate changed
+func (u *usage) refresh(t storage.Tenant, now time.Time) {
+	if u.limits.MessageRate != t.Limits.MessageRate || u.limits.MessageBurst != t.Limits.MessageBurst {
+		u.bucket = nil
+	}
+	if u.name != "" && u.name != t.Name {
+		// Move the gauges over to the new name
+		metrics.TenantConnections.DeleteLabelValues(u.name)
+		metrics.TenantRooms.DeleteLabelValues(u.name)
+		metrics.TenantConnections.WithLabelValues(t.Name).Set(float64(u.conns))
+		metrics.TenantRooms.WithLabelValues(t.Name).Set(float64(len(u.rooms)))
+	}
+	u.name = t.Name
+	u.limits = t.Limits
+	u.checked = now
+}
+
+// Lease is one connection's share of its tenant's limits
+// A nil Lease is unlimited
+type Lease struct {
+	registry *Registry
+	id       string
+	name     string
+	room     string
+	released bool
+}
+
+// Tenant is the name of the tenant holding the lease
+func (l *Lease) Tenant() string {
+	if l == nil {
+		return ""
+	}
+	return l.name
+}
+
+// Allow counts a message against the tenant's rate
+// It fails with a LimitError, saying when to retry, when over the rate
+func (l *Lease) Allow(ctx context.Context, now time.Time) error {
+	if l == nil {
+		return nil
+	}
+	l.registry.reload(ctx, l.id, now)
+
+	r := l.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.usage[l.id]
+	if !ok {
+		return nil
+	}
+	if rate := u.limits.MessageRate; rate > 0 {
+		if u.bucket == nil {
+			burst := float64(u.limits.MessageBurst)
+			if burst == 0 {
+				burst = rate
+			}
+			u.bucket = ratelimit.NewBucket(rate, burst, now)
+		}
+		if wait, ok := u.bucket.Reserve(now, 0); !ok {
+			metrics.TenantRejections.WithLabelValues(u.name, MessageRate).Inc()
+			return &LimitError{Tenant: u.name, Limit: MessageRate, Max: rate, RetryAfter: wait}
+		}
+	}
+	metrics.TenantMessages.WithLabelValues(u.name).Inc()
+	return nil
+}
+
+// Release returns the lease's connection; releasing twice is harmless
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	r := l.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l.released {
+		return
+	}
+	l.released = true
+	u, ok := r.usage[l.id]
+	if !ok {
+		return
+	}
+	u.conns--
+	if u.rooms[l.room]--; u.rooms[l.room] <= 0 {
+		delete(u.rooms, l.room)
+	}
+	metrics.TenantConnections.WithLabelValues(u.name).Set(float64(u.conns))
+	metrics.TenantRooms.WithLabelValues(u.name).Set(float64(len(u.rooms)))
+	if u.conns == 0 {
+		delete(r.usage, l.id)
+	}
+}
+
+// reload refreshes tenant id's limits once they are older than the TTL,
+// so long-lived connections see limits changed on other nodes
+func (r *Registry) reload(ctx context.Context, id string, now time.Time) {
+	r.mu.Lock()
+	u, ok := r.usage[id]
+	if !ok || now.Sub(u.checked) < r.ttl {
+		r.mu.Unlock()
+		return
+	}
+	u.checked = now // Others keep the old limits while this one loads
+	r.mu.Unlock()
+
+	t, err := r.store.GetTenant(ctx, id)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Tenant %s reload failed: %v", id, err)
+		}
+		return
+	}
+	r.mu.Lock()
+	if u, ok := r.usage[id]; ok {
+		u.refresh(t, now)
+	}
+	r.mu.Unlock()
+}
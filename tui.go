@@ -0,0 +1,633 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"chat-app/client"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+/*
+TUI Overview:
+------------
+`chat-app connect --tui` is a full-screen client for several rooms at
+once:
+
+	chat-app connect --tui ws://localhost:8080/ws/lobby --user alice --rooms dev,ops
+
+	┌ rooms ─┬ #lobby ─────────────────────────┬ online ┐
+	│ lobby  │ 14:02 bob: hi                   │ alice  │
+	│ dev (3)│ 14:03 alice: hello              │ bob    │
+	│ ops    │                                 │        │
+	└────────┴─────────────────────────────────┴────────┘
+	  > message
+
+Each room is its own WebSocket, opened with client.Dial and read by
+its own goroutine, which hands frames to the bubbletea program. When a
+room opens, its recent history is loaded over the REST API
+(client.History), and scrolling past the top loads the page before.
+History and live frames are merged by message ID, so nothing shows
+twice.
+
+Keys: Enter sends, Tab/Shift-Tab (or Ctrl-N/Ctrl-P) switch rooms,
+PgUp/PgDn and the mouse wheel scroll, Ctrl-C quits. Commands:
+/join room, /leave, /quit.
+*/
+
+const (
+	tuiHistoryPage = 50
+	tuiSideWidth   = 16
+	tuiMaxLines    = 5000 // Scrollback kept per room
+)
+
+var (
+	tuiPaneStyle   = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("8"))
+	tuiTitleStyle  = lipgloss.NewStyle().Bold(true)
+	tuiActiveStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	tuiDimStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	tuiErrorStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	tuiNameStyle   = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("6"))
+)
+
+// runTUI runs the full-screen client until the user quits
+func runTUI(base, username string, rooms []string) error {
+	m := newTUIModel(base, username)
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
+	m.send = p.Send
+	for _, room := range rooms {
+		m.open(room)
+	}
+	_, err := p.Run()
+	m.closeAll()
+	return err
+}
+
+// tuiLine is one line of a room's scrollback
+type tuiLine struct {
+	id     string // Message ID, for merging history with live frames
+	at     time.Time
+	user   string // Empty for notices
+	text   string
+	notice bool
+}
+
+// tuiRoom is a room the client has open
+// Only the bubbletea goroutine touches it; the reader goroutine
+// reaches the model through messages
+type tuiRoom struct {
+	name   string
+	conn   *client.Conn  // nil while connecting or after the connection ended
+	quit   chan struct{} // Closed to stop the reader; nil when there is none
+	lines  []tuiLine
+	ids    map[string]bool
+	users  client.Presence
+	unread int
+
+	next    uint64 // History cursor for the page before; 0 when there is none
+	loading bool   // A history page is on its way
+	loaded  bool   // The first history page arrived
+}
+
+// Messages from the reader goroutines and commands
+type (
+	tuiConnected struct {
+		room   string
+		reader chan struct{} // The reader's quit channel, telling readers apart
+		conn   *client.Conn
+	}
+	tuiFrame struct {
+		room   string
+		reader chan struct{}
+		msg    client.Message
+	}
+	tuiClosed struct {
+		room   string
+		reader chan struct{}
+		err    error
+	}
+	tuiHistory struct {
+		room   string
+		before uint64
+		page   client.HistoryPage
+		err    error
+	}
+)
+
+// tuiModel is the bubbletea model; a pointer, because the reader
+// goroutines need p.Send before the program starts
+type tuiModel struct {
+	base     string
+	username string
+	send     func(tea.Msg)
+	clock    *client.Clock // Shared by every room's reader; all rooms are on one server
+
+	rooms  []*tuiRoom
+	active int
+	input  textinput.Model
+	view   viewport.Model
+	status string
+	width  int
+	height int
+}
+
+func newTUIModel(base, username string) *tuiModel {
+	input := textinput.New()
+	input.Placeholder = "Message, or /join room"
+	input.Prompt = "> "
+	input.CharLimit = 500
+	input.Focus()
+
+	view := viewport.New(0, 0)
+	// Arrow keys belong to the input; the view scrolls by page and mouse
+	view.KeyMap = viewport.KeyMap{
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+		PageDown: key.NewBinding(key.WithKeys("pgdown")),
+	}
+	return &tuiModel{base: base, username: username, clock: new(client.Clock), input: input, view: view}
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.layout()
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "tab", "ctrl+n":
+			m.switchTo(m.active + 1)
+			return m, nil
+		case "shift+tab", "ctrl+p":
+			m.switchTo(m.active - 1)
+			return m, nil
+		case "enter":
+			line := m.input.Value()
+			m.input.Reset()
+			return m, m.handleLine(line)
+		case "pgup":
+			if cmd := m.loadOlder(); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		}
+
+	case tea.MouseMsg:
+		if msg.Button == tea.MouseButtonWheelUp {
+			if cmd := m.loadOlder(); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		}
+
+	case tuiConnected:
+		r := m.reading(msg.room, msg.reader)
+		if r == nil {
+			msg.conn.Close() // Left while connecting
+			return m, nil
+		}
+		first := r.conn == nil && !r.loaded
+		r.conn = msg.conn
+		if first {
+			r.loading = true
+			cmds = append(cmds, m.fetchHistory(r.name, 0))
+		}
+
+	case tuiFrame:
+		if r := m.reading(msg.room, msg.reader); r != nil {
+			m.apply(r, msg.msg)
+		}
+
+	case tuiClosed:
+		if r := m.reading(msg.room, msg.reader); r != nil {
+			r.conn, r.quit, r.users = nil, nil, client.Presence{}
+			m.addNotice(r, "! "+msg.err.Error()+"; /join "+r.name+" to reconnect")
+		}
+
+	case tuiHistory:
+		if r := m.room(msg.room); r != nil {
+			m.mergeHistory(r, msg)
+		}
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	cmds = append(cmds, cmd)
+	m.view, cmd = m.view.Update(msg)
+	cmds = append(cmds, cmd)
+	return m, tea.Batch(cmds...)
+}
+
+// handleLine sends what was typed to the active room, or runs a command
+func (m *tuiModel) handleLine(line string) tea.Cmd {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	r := m.current()
+	if !strings.HasPrefix(line, "/") || strings.HasPrefix(line, "//") {
+		if r == nil || r.conn == nil {
+			m.status = "not connected"
+			return nil
+		}
+		frame, err := encodeFrame(map[string]string{"type": "chat", "content": strings.TrimPrefix(line, "/")})
+		if err == nil {
+			err = r.conn.SendJSON(frame)
+		}
+		if err != nil {
+			m.status = err.Error()
+		}
+		return nil
+	}
+
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "/quit", "/exit":
+		return tea.Quit
+	case "/join":
+		if arg == "" {
+			m.status = "usage: /join room"
+			return nil
+		}
+		m.open(arg)
+		m.switchTo(m.index(arg))
+	case "/leave":
+		if r == nil {
+			return nil
+		}
+		m.closeRoom(r)
+		m.rooms = append(m.rooms[:m.active], m.rooms[m.active+1:]...)
+		if len(m.rooms) == 0 {
+			return tea.Quit
+		}
+		m.switchTo(min(m.active, len(m.rooms)-1))
+	default:
+		m.status = "unknown command " + cmd + "; try /join, /leave or /quit"
+	}
+	return nil
+}
+
+// open connects to a room, or reconnects one whose connection ended
+func (m *tuiModel) open(name string) {
+	r := m.room(name)
+	if r == nil {
+		r = &tuiRoom{name: name, ids: make(map[string]bool)}
+		m.rooms = append(m.rooms, r)
+	} else if r.quit != nil {
+		return // Connected or connecting
+	}
+	r.quit = make(chan struct{})
+	go readRoom(m.send, m.clock, m.base, name, m.username, r.quit)
+}
+
+// reading returns the open room a reader's message is for, or nil if
+// the room has since been left or has another reader
+func (m *tuiModel) reading(name string, reader chan struct{}) *tuiRoom {
+	r := m.room(name)
+	if r == nil || r.quit != reader {
+		return nil
+	}
+	return r
+}
+
+// readRoom dials a room and hands its frames to the program until the
+// connection ends or quit is closed, following "reconnect" frames
+func readRoom(send func(tea.Msg), clock *client.Clock, base, room, username string, quit chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectDialTimeout)
+	conn, err := client.Dial(ctx, base, room, username)
+	cancel()
+	if err != nil {
+		send(tuiClosed{room: room, reader: quit, err: err})
+		return
+	}
+	conn.SyncClock()
+	send(tuiConnected{room: room, reader: quit, conn: conn})
+
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			select {
+			case <-quit:
+			default:
+				send(tuiClosed{room: room, reader: quit, err: fmt.Errorf("connection lost: %w", err)})
+			}
+			return
+		}
+		clock.Apply(msg, time.Now())
+		if msg.QoS != "" && msg.ID != "" {
+			conn.Ack(msg.ID)
+		}
+		if msg.Type != "reconnect" {
+			send(tuiFrame{room: room, reader: quit, msg: msg})
+			continue
+		}
+
+		// Move where the server says once the delay has passed
+		if msg.URL != "" {
+			base = msg.URL
+		}
+		select {
+		case <-time.After(time.Duration(msg.RetryAfterMs) * time.Millisecond):
+		case <-quit:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), connectDialTimeout)
+		next, err := client.Dial(ctx, base, room, username)
+		cancel()
+		if err != nil {
+			send(tuiClosed{room: room, reader: quit, err: fmt.Errorf("reconnect: %w", err)})
+			return
+		}
+		next.SyncClock()
+		send(tuiConnected{room: room, reader: quit, conn: next})
+		conn.Close()
+		conn = next
+	}
+}
+
+// closeRoom ends a room's connection
+func (m *tuiModel) closeRoom(r *tuiRoom) {
+	if r.quit != nil {
+		close(r.quit)
+		r.quit = nil
+	}
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// closeAll ends every connection, once the program has exited
+func (m *tuiModel) closeAll() {
+	for _, r := range m.rooms {
+		m.closeRoom(r)
+	}
+}
+
+// fetchHistory loads the page of a room's history before offset before
+func (m *tuiModel) fetchHistory(room string, before uint64) tea.Cmd {
+	base, username := m.base, m.username
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), connectDialTimeout)
+		defer cancel()
+		page, err := client.History(ctx, base, room, username, before, tuiHistoryPage)
+		return tuiHistory{room: room, before: before, page: page, err: err}
+	}
+}
+
+// loadOlder fetches the page before the oldest line once the view is
+// scrolled to the top
+func (m *tuiModel) loadOlder() tea.Cmd {
+	r := m.current()
+	if r == nil || !m.view.AtTop() || r.loading || r.next == 0 {
+		return nil
+	}
+	r.loading = true
+	m.status = "loading older messages…"
+	return m.fetchHistory(r.name, r.next)
+}
+
+// mergeHistory puts a page of history above what the room already shows
+func (m *tuiModel) mergeHistory(r *tuiRoom, msg tuiHistory) {
+	r.loading = false
+	if msg.err != nil {
+		m.addNotice(r, "! "+msg.err.Error())
+		return
+	}
+	r.loaded = true
+	r.next = msg.page.Next
+	if m.current() == r {
+		m.status = ""
+	}
+
+	older := make([]tuiLine, 0, len(msg.page.Messages))
+	for _, e := range msg.page.Messages {
+		if r.ids[e.ID] {
+			continue // Arrived live before the page did
+		}
+		r.ids[e.ID] = true
+		older = append(older, tuiLine{id: e.ID, at: m.clock.Local(e.CreatedAt).Local(), user: e.Username, text: e.Content})
+	}
+	if len(older) == 0 {
+		return
+	}
+
+	// Keep what is on screen in place while the lines above it grow
+	keep := m.current() == r && msg.before != 0
+	before := m.view.TotalLineCount()
+	r.lines = append(older, r.lines...)
+	if m.current() == r {
+		m.render(!keep)
+		if keep {
+			m.view.SetYOffset(m.view.TotalLineCount() - before)
+		}
+	}
+}
+
+// apply updates a room with a frame from its connection
+func (m *tuiModel) apply(r *tuiRoom, msg client.Message) {
+	if body, ok := messageBody(msg, m.base, true); ok {
+		if msg.ID != "" {
+			if r.ids[msg.ID] {
+				return
+			}
+			r.ids[msg.ID] = true
+		}
+		line := tuiLine{id: msg.ID, at: m.clock.MessageTime(msg).Local(), user: msg.Username, text: body}
+		if msg.Type == "announcement" {
+			line = tuiLine{at: m.clock.MessageTime(msg).Local(), text: "** " + body, notice: true}
+		}
+		m.addLine(r, line)
+		if m.current() != r {
+			r.unread++
+		}
+		return
+	}
+
+	switch msg.Type {
+	case "hello":
+		m.username = msg.Username // The server may have renamed us
+	case "online_users", "presence_join", "presence_leave":
+		r.users.Apply(msg)
+	case "moderation":
+		if msg.Code == "deleted" || msg.Code == "hidden" {
+			m.dropLine(r, msg.ID)
+		}
+	default:
+		if notice := messageNotice(msg); notice != "" {
+			m.addNotice(r, notice)
+		}
+	}
+}
+
+func (m *tuiModel) addNotice(r *tuiRoom, text string) {
+	m.addLine(r, tuiLine{at: time.Now(), text: text, notice: true})
+}
+
+// addLine appends to a room's scrollback, following it if the view
+// was at the bottom
+func (m *tuiModel) addLine(r *tuiRoom, line tuiLine) {
+	r.lines = append(r.lines, line)
+	if len(r.lines) > tuiMaxLines {
+		r.lines = r.lines[len(r.lines)-tuiMaxLines:]
+		r.next = 0 // The trimmed lines would come back as history
+	}
+	if m.current() == r {
+		m.render(m.view.AtBottom())
+	}
+}
+
+// dropLine removes a message moderation took down
+func (m *tuiModel) dropLine(r *tuiRoom, id string) {
+	for i, line := range r.lines {
+		if line.id == id {
+			r.lines = append(r.lines[:i], r.lines[i+1:]...)
+			break
+		}
+	}
+	if m.current() == r {
+		m.render(m.view.AtBottom())
+	}
+}
+
+func (m *tuiModel) room(name string) *tuiRoom {
+	if i := m.index(name); i >= 0 {
+		return m.rooms[i]
+	}
+	return nil
+}
+
+func (m *tuiModel) index(name string) int {
+	for i, r := range m.rooms {
+		if r.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *tuiModel) current() *tuiRoom {
+	if m.active < 0 || m.active >= len(m.rooms) {
+		return nil
+	}
+	return m.rooms[m.active]
+}
+
+// switchTo shows room i, wrapping around the list
+func (m *tuiModel) switchTo(i int) {
+	if len(m.rooms) == 0 {
+		return
+	}
+	m.active = (i%len(m.rooms) + len(m.rooms)) % len(m.rooms)
+	m.rooms[m.active].unread = 0
+	m.status = ""
+	m.render(true)
+}
+
+// layout sizes the panes to the terminal
+func (m *tuiModel) layout() {
+	m.view.Width = max(m.width-2*tuiSideWidth-6, 10)
+	m.view.Height = max(m.height-5, 1) // Borders, title, input and status
+	m.input.Width = max(m.width-4, 10)
+	m.render(m.view.AtBottom())
+}
+
+// render fills the view with the active room's scrollback
+func (m *tuiModel) render(bottom bool) {
+	r := m.current()
+	if r == nil || m.view.Width == 0 {
+		m.view.SetContent("")
+		return
+	}
+	wrap := lipgloss.NewStyle().Width(m.view.Width)
+	var b strings.Builder
+	if r.loaded && r.next == 0 {
+		b.WriteString(tuiDimStyle.Render("— start of #"+r.name+" —") + "\n")
+	}
+	for i, line := range r.lines {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		stamp := tuiDimStyle.Render(line.at.Format("15:04"))
+		switch {
+		case line.notice && strings.HasPrefix(line.text, "!"):
+			b.WriteString(wrap.Render(stamp + " " + tuiErrorStyle.Render(line.text)))
+		case line.notice:
+			b.WriteString(wrap.Render(stamp + " " + tuiDimStyle.Render(line.text)))
+		default:
+			b.WriteString(wrap.Render(stamp + " " + tuiNameStyle.Render(line.user) + ": " + line.text))
+		}
+	}
+	m.view.SetContent(b.String())
+	if bottom {
+		m.view.GotoBottom()
+	}
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return "Connecting…"
+	}
+	r := m.current()
+
+	// Room list, with unread counts
+	var rooms []string
+	for i, room := range m.rooms {
+		name := room.name
+		if room.unread > 0 {
+			name += fmt.Sprintf(" (%d)", room.unread)
+		}
+		switch {
+		case i == m.active:
+			rooms = append(rooms, tuiActiveStyle.Render("▸ "+name))
+		case room.conn == nil:
+			rooms = append(rooms, tuiDimStyle.Render("  "+name))
+		default:
+			rooms = append(rooms, "  "+name)
+		}
+	}
+
+	// Who is in the active room
+	var users []string
+	title := "no room"
+	if r != nil {
+		title = "#" + r.name
+		if r.conn == nil {
+			title += tuiDimStyle.Render(" (offline)")
+		}
+		for _, u := range r.users.Users() {
+			switch member, _ := r.users.Member(u); {
+			case u == m.username:
+				u = tuiActiveStyle.Render(u)
+			case member.Status != "":
+				u = tuiDimStyle.Render(u + " (" + member.Status + ")")
+			}
+			users = append(users, u)
+		}
+	}
+
+	side := func(heading string, lines []string) string {
+		body := tuiTitleStyle.Render(heading) + "\n" + strings.Join(lines, "\n")
+		return tuiPaneStyle.Width(tuiSideWidth).Height(m.view.Height + 1).MaxHeight(m.view.Height + 3).Render(body)
+	}
+	main := tuiPaneStyle.Width(m.view.Width).Render(tuiTitleStyle.Render(title) + "\n" + m.view.View())
+	panes := lipgloss.JoinHorizontal(lipgloss.Top, side("Rooms", rooms), main, side("Online", users))
+
+	status := m.status
+	if status == "" {
+		status = "Tab switch rooms · PgUp older messages · /join room · /leave · Ctrl-C quit"
+	}
+	return panes + "\n" + m.input.View() + "\n" + tuiDimStyle.Render(status)
+}
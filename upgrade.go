@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"chat-app/websockets"
+)
+
+/*
+Upgrade Overview:
+----------------
+Sending the server SIGUSR2 replaces its binary without closing its
+ports:
+
+1. The running (old) process starts the binary at its own path with
+   the same arguments and environment, passing its listening sockets
+   as inherited file descriptors
+2. The new process takes over the sockets and reports ready. If it
+   exits or doesn't report within upgradeReadyTimeout, the old process
+   kills it and carries on serving
+3. The old process stops accepting. New connections wait in the
+   kernel's accept queue of the shared sockets, so none are refused
+4. The old process drains: every client gets a reconnect frame with a
+   delay spread over CHAT_UPGRADE_SPREAD, and once they have gone the
+   hub state (room sequence numbers, idempotency acks) is written to
+   CHAT_HUB_STATE_FILE
+5. The old process exits; the new one restores the hub state and
+   starts accepting
+
+Only one process serves the rooms at any time, so ordering holds
+across the switch. Clients that follow the reconnect hint resume
+where they left off: Seq continues, unacked sends can be retried with
+their idempotency key, and durable messages queued meanwhile are
+delivered on rejoin. Without CHAT_HUB_STATE_FILE sequence numbers
+restart, which the old process warns about.
+
+Under systemd the main PID changes with every upgrade; prefer socket
+activation and a plain restart there (see activation.go).
+*/
+
+const (
+	// Environment variable listing inherited sockets by configured address
+	upgradeListenersEnv = "CHAT_UPGRADE_LISTENERS"
+
+	// How long a new process gets to start and report ready
+	upgradeReadyTimeout = 30 * time.Second
+
+	// Extra time allowed for clients to leave after the spread window
+	upgradeDrainSlack = 10 * time.Second
+)
+
+// upgrader hands this process's sockets to a new binary on SIGUSR2
+type upgrader struct {
+	listeners []boundListener
+	hub       *websockets.LocalHub
+	spread    time.Duration
+
+	handoff *os.File      // Write end the new process waits on; closes when we exit
+	done    chan struct{} // Closed once drained and ready to exit
+}
+
+func newUpgrader(listeners []boundListener, hub *websockets.LocalHub, spread time.Duration) *upgrader {
+	return &upgrader{listeners: listeners, hub: hub, spread: spread, done: make(chan struct{})}
+}
+
+// watch upgrades on every upgrade signal until one succeeds
+func (u *upgrader) watch() {
+	if len(upgradeSignals) == 0 {
+		return // Not supported on this platform
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, upgradeSignals...)
+	for range signals {
+		log.Println("Upgrade: starting new process")
+		if err := u.upgrade(); err != nil {
+			log.Printf("Upgrade failed, still serving: %v", err)
+			continue
+		}
+		signal.Stop(signals)
+		return
+	}
+}
+
+// upgrade runs the hand-off; it returns an error only while rollback is possible
+func (u *upgrader) upgrade() error {
+	if !u.hub.HasStateFile() {
+		log.Println("Upgrade: CHAT_HUB_STATE_FILE is not set, room sequence numbers will restart")
+	}
+
+	// Step 1: Start the new process with our sockets
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var files []*os.File
+	var addrs []string
+	defer func() {
+		for _, f := range files {
+			f.Close() // The child has its own copies
+		}
+	}()
+	for _, bl := range u.listeners {
+		f, err := listenerFile(bl.listener)
+		if err != nil {
+			return fmt.Errorf("%s: %w", bl.addr, err)
+		}
+		files = append(files, f)
+		addrs = append(addrs, bl.addr)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	handoffR, handoffW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), upgradeListenersEnv+"="+strings.Join(addrs, ","))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), readyW, handoffR)
+	err = cmd.Start()
+	readyW.Close()
+	handoffR.Close()
+	if err != nil {
+		handoffW.Close()
+		return fmt.Errorf("start %s: %w", exe, err)
+	}
+
+	// Step 2: Wait for it to report ready
+	ready := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(readyR).ReadString('\n')
+		if err == nil && line != "ready\n" {
+			err = fmt.Errorf("unexpected message %q", line)
+		}
+		ready <- err
+	}()
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	select {
+	case err = <-ready:
+	case err = <-exited:
+		err = fmt.Errorf("new process exited: %v", err)
+	case <-time.After(upgradeReadyTimeout):
+		err = errors.New("new process did not report ready")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		handoffW.Close()
+		return err
+	}
+	u.handoff = handoffW // Held open until we exit
+	log.Printf("Upgrade: new process %d is ready, draining", cmd.Process.Pid)
+
+	// Step 3: Stop accepting; the new process will pick up the queue
+	for _, bl := range u.listeners {
+		if ul, ok := bl.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false) // The socket file now belongs to the new process
+		}
+		bl.listener.Close()
+	}
+
+	// Step 4: Move clients over and save what they need to resume
+	u.hub.Drain(nil, u.spread)
+	deadline := time.Now().Add(u.spread + upgradeDrainSlack)
+	for time.Now().Before(deadline) {
+		if clients, _ := u.hub.Counts(); clients == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := u.hub.SaveState(); err != nil {
+		log.Printf("Upgrade: failed to save hub state: %v", err)
+	}
+	close(u.done)
+	return nil
+}
+
+// listenerFile duplicates a listener's socket for passing to a child
+func listenerFile(l net.Listener) (*os.File, error) {
+	filer, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("cannot pass %T to a new process", l)
+	}
+	return filer.File()
+}
+
+// inheritedListeners takes over the sockets of the process we are replacing
+// It returns nil when this process was not started by an upgrade
+func inheritedListeners() (map[string][]net.Listener, error) {
+	list := os.Getenv(upgradeListenersEnv)
+	if list == "" {
+		return nil, nil
+	}
+	os.Unsetenv(upgradeListenersEnv) // Our own upgrades set it afresh
+
+	listeners := make(map[string][]net.Listener)
+	for i, addr := range strings.Split(list, ",") {
+		f := os.NewFile(uintptr(listenFDsStart+i), addr)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %s: %w", addr, err)
+		}
+		listeners[addr] = append(listeners[addr], l)
+	}
+	return listeners, nil
+}
+
+// awaitHandoff tells the old process we're ready, then waits for it to exit
+// Call it only when inheritedListeners returned sockets
+func awaitHandoff(inherited map[string][]net.Listener) error {
+	count := 0
+	for _, ls := range inherited {
+		count += len(ls)
+	}
+	ready := os.NewFile(uintptr(listenFDsStart+count), "upgrade-ready")
+	handoff := os.NewFile(uintptr(listenFDsStart+count+1), "upgrade-handoff")
+	defer handoff.Close()
+
+	if _, err := ready.WriteString("ready\n"); err != nil {
+		ready.Close()
+		return fmt.Errorf("report ready: %w", err)
+	}
+	ready.Close()
+
+	log.Println("Upgrade: waiting for the old process to drain")
+	_, err := io.Copy(io.Discard, handoff) // EOF once the old process is gone
+	return err
+}
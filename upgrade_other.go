@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// upgradeSignals is empty: passing sockets to a new process needs Unix
+var upgradeSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals trigger a binary upgrade (see upgrade.go)
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,95 @@
+package uploads
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"chat-app/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 is an Objects backed by an S3-compatible bucket
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+var _ Objects = (*S3)(nil)
+
+// NewS3 connects to the bucket in cfg, creating it if it doesn't exist
+// Browsers upload to it directly, so it needs a CORS rule allowing PUT
+// from the chat's origin; creds replaces cfg's static keys when not nil
+func NewS3(ctx context.Context, cfg config.ArchiveConfig, creds *credentials.Credentials) (*S3, error) {
+	if creds == nil {
+		creds = credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, "")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create S3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("check bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("create bucket %s: %w", cfg.Bucket, err)
+		}
+	}
+
+	return &S3{client: client, bucket: cfg.Bucket}, nil
+}
+
+// PresignPut implements Objects
+// Content-Type and Content-Length are signed, so S3 refuses an upload
+// of any other type or size
+func (s *S3) PresignPut(ctx context.Context, key string, size int64, contentType string, ttl time.Duration) (string, http.Header, error) {
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Length", strconv.FormatInt(size, 10))
+	u, err := s.client.PresignHeader(ctx, http.MethodPut, s.bucket, key, ttl, nil, headers)
+	if err != nil {
+		return "", nil, err
+	}
+	return u.String(), headers, nil
+}
+
+// PresignGet implements Objects
+func (s *S3) PresignGet(ctx context.Context, key, name string, ttl time.Duration) (string, error) {
+	params := url.Values{}
+	params.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, ttl, params)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Stat implements Objects
+func (s *S3) Stat(ctx context.Context, key string) (int64, string, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return 0, "", ErrNotFound
+		}
+		return 0, "", err
+	}
+	return info.Size, info.ContentType, nil
+}
+
+// Remove implements Objects
+func (s *S3) Remove(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,314 @@
+package uploads
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-app/storage"
+)
+
+/*
+Uploads Overview:
+----------------
+Attachments never pass through the chat server. A client asks for an
+upload with the file's name, size and type; the server checks them
+against its limits and returns a presigned PUT URL for the uploads
+bucket, with the size and type signed in:
+
+	POST /api/rooms/lobby/uploads?username=alice
+	{"name": "report.pdf", "size": 482113, "content_type": "application/pdf"}
+	-> {"id": "7c1e...", "method": "PUT", "url": "https://...",
+	    "headers": {"Content-Type": "application/pdf", "Content-Length": "482113"},
+	    "expires_at": "..."}
+
+The client PUTs the file there with exactly those headers, so the
+bucket refuses anything else, then posts it to the room:
+
+	{"type": "attachment", "attachment": {"id": "7c1e..."}}
+
+Posting checks the object really is there, with the promised size
+and type, before the room sees it. Downloads go through the server,
+which redirects to a short-lived presigned GET URL.
+
+Sweep removes uploads nobody posted within PendingTTL, object and
+record alike.
+*/
+
+// Errors returned by Service
+var (
+	ErrNotFound   = errors.New("no such upload")
+	ErrIncomplete = errors.New("upload has not finished or doesn't match what was announced")
+	ErrInvalid    = errors.New("invalid upload request")
+	ErrTooLarge   = errors.New("file too large")
+	ErrType       = errors.New("file type not accepted")
+)
+
+// PendingTTL is how long an upload may wait to be posted
+const PendingTTL = 24 * time.Hour
+
+// maxNameLength bounds file names, in bytes
+const maxNameLength = 255
+
+// Objects is the object storage the uploads bucket lives in
+type Objects interface {
+	// PresignPut returns a URL, and the headers that must accompany it,
+	// for uploading exactly size bytes of contentType to key
+	PresignPut(ctx context.Context, key string, size int64, contentType string, ttl time.Duration) (string, http.Header, error)
+	// PresignGet returns a URL for downloading key as a file named name
+	PresignGet(ctx context.Context, key, name string, ttl time.Duration) (string, error)
+	// Stat returns an object's size and type, or ErrNotFound
+	Stat(ctx context.Context, key string) (int64, string, error)
+	// Remove deletes an object; a missing object is not an error
+	Remove(ctx context.Context, key string) error
+}
+
+// Options limits what may be uploaded
+type Options struct {
+	MaxBytes int64         // Largest file accepted
+	Types    []string      // Accepted media types, e.g. image/*; empty accepts any
+	URLTTL   time.Duration // How long presigned URLs stay valid
+}
+
+// Service issues upload URLs and checks finished uploads
+type Service struct {
+	objects Objects
+	store   storage.Store
+	opts    Options
+}
+
+// New creates a Service storing files in objects and records in store
+func New(objects Objects, store storage.Store, opts Options) *Service {
+	return &Service{objects: objects, store: store, opts: opts}
+}
+
+// Request describes a file a client wants to upload
+type Request struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// Ticket tells a client where and how to upload a file
+type Ticket struct {
+	ID        string            `json:"id"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// RequestError is a request Start refuses; its message is safe to show
+// It wraps ErrInvalid, ErrTooLarge or ErrType
+type RequestError struct {
+	Err    error
+	Reason string
+}
+
+func (e *RequestError) Error() string {
+	return e.Reason
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Start checks req and issues a presigned URL to upload it with
+func (s *Service) Start(ctx context.Context, room, username string, req Request) (Ticket, error) {
+	name, contentType, err := s.check(req)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	u := storage.Upload{
+		ID:          newUploadID(),
+		Room:        room,
+		Username:    username,
+		Name:        name,
+		ContentType: contentType,
+		Size:        req.Size,
+		CreatedAt:   time.Now().UTC(),
+	}
+	u.Key = "uploads/" + u.ID
+	url, headers, err := s.objects.PresignPut(ctx, u.Key, u.Size, u.ContentType, s.opts.URLTTL)
+	if err != nil {
+		return Ticket{}, fmt.Errorf("presign upload: %w", err)
+	}
+	if err := s.store.SaveUpload(ctx, u); err != nil {
+		return Ticket{}, fmt.Errorf("save upload: %w", err)
+	}
+
+	ticket := Ticket{ID: u.ID, Method: http.MethodPut, URL: url, Headers: map[string]string{}, ExpiresAt: u.CreatedAt.Add(s.opts.URLTTL)}
+	for name := range headers {
+		ticket.Headers[name] = headers.Get(name)
+	}
+	return ticket, nil
+}
+
+// check validates req, returning the cleaned name and the media type
+func (s *Service) check(req Request) (string, string, error) {
+	name := cleanName(req.Name)
+	switch {
+	case name == "":
+		return "", "", &RequestError{ErrInvalid, "name is required"}
+	case len(name) > maxNameLength:
+		return "", "", &RequestError{ErrInvalid, fmt.Sprintf("names are limited to %d bytes", maxNameLength)}
+	case req.Size <= 0:
+		return "", "", &RequestError{ErrInvalid, "size must be positive"}
+	case req.Size > s.opts.MaxBytes:
+		return "", "", &RequestError{ErrTooLarge, fmt.Sprintf("files are limited to %d bytes", s.opts.MaxBytes)}
+	}
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", "", &RequestError{ErrInvalid, "content_type is not a valid media type"}
+	}
+	if !Accepts(s.opts.Types, mediaType) {
+		return "", "", &RequestError{ErrType, mediaType + " files are not accepted"}
+	}
+	return name, contentType, nil
+}
+
+// Accepts reports whether mediaType matches one of types, which may end in /*
+// An empty list accepts everything
+func Accepts(types []string, mediaType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete checks that room's upload id by username has arrived as
+// announced, and marks it posted
+// Posting the same upload again, e.g. on a retry, is allowed
+func (s *Service) Complete(ctx context.Context, room, username, id string) (storage.Upload, error) {
+	u, err := s.store.GetUpload(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && (u.Room != room || u.Username != username)) {
+		return storage.Upload{}, ErrNotFound
+	} else if err != nil {
+		return storage.Upload{}, err
+	}
+	if u.Posted {
+		return u, nil
+	}
+
+	size, contentType, err := s.objects.Stat(ctx, u.Key)
+	if errors.Is(err, ErrNotFound) {
+		return storage.Upload{}, ErrIncomplete
+	} else if err != nil {
+		return storage.Upload{}, fmt.Errorf("stat upload: %w", err)
+	}
+	if size != u.Size || contentType != u.ContentType {
+		return storage.Upload{}, ErrIncomplete
+	}
+
+	if err := s.store.MarkUploadPosted(ctx, u.ID); err != nil {
+		return storage.Upload{}, err
+	}
+	u.Posted = true
+	return u, nil
+}
+
+// DownloadURL returns a short-lived URL for a posted upload
+func (s *Service) DownloadURL(ctx context.Context, id string) (string, error) {
+	u, err := s.store.GetUpload(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && !u.Posted) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return s.objects.PresignGet(ctx, u.Key, u.Name, s.opts.URLTTL)
+}
+
+// Sweep removes uploads never posted within PendingTTL, returning how many
+func (s *Service) Sweep(ctx context.Context, now time.Time) (int, error) {
+	stale, err := s.store.UnpostedUploads(ctx, now.Add(-PendingTTL))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var errs []error
+	for _, u := range stale {
+		// The record goes last, so a failed removal is retried next time
+		if err := s.objects.Remove(ctx, u.Key); err != nil {
+			errs = append(errs, fmt.Errorf("remove %s: %w", u.Key, err))
+			continue
+		}
+		if err := s.store.DeleteUpload(ctx, u.ID); err != nil {
+			errs = append(errs, fmt.Errorf("delete upload %s: %w", u.ID, err))
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(errs...)
+}
+
+// Run sweeps on every tick until ctx is cancelled
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if removed, err := s.Sweep(ctx, now); err != nil {
+				log.Printf("Upload sweep failed: %v", err)
+			} else if removed > 0 {
+				log.Printf("Swept %d abandoned uploads", removed)
+			}
+		}
+	}
+}
+
+// cleanName reduces a client's file name to a safe display name: no
+// directories, control or invisible characters, or surrounding space
+func cleanName(name string) string {
+	if !utf8.ValidString(name) {
+		name = strings.ToValidUTF8(name, "")
+	}
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// newUploadID returns a random 32-character hex identifier; download
+// links carry it, so it is as hard to guess as a voice note's
+func newUploadID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Static Files Overview:
+---------------------
+Serve puts a frontend on the same port as the chat server, from a
+directory (os.DirFS) or a file system compiled in with go:embed. Files
+are served for any path no route claims, so the API always wins:
+
+	GET /                 -> index.html
+	GET /assets/app.js    -> assets/app.js
+	GET /rooms/lobby      -> index.html, with Fallback (history-mode SPAs)
+	GET /api/nope         -> 404, never index.html (see Options.Exclude)
+
+The fallback only answers page navigations, GETs that accept
+text/html, so a mistyped script or API URL still gets a 404 rather
+than a page of HTML.
+
+Cache headers follow what bundlers emit:
+
+	index.html                   no-cache (always revalidated, so deploys show at once)
+	app.3f9a2c1e.js, index-BfX8k2aQ.css
+	                             a year, immutable (the name changes with the content)
+	everything else              Options.MaxAge; no-cache when zero
+
+Dotfiles (.env, .git/...) are never served.
+*/
+
+// Options controls how Serve answers
+type Options struct {
+	Index    string        // Page for / and the fallback; index.html when empty
+	Fallback bool          // Answer unknown page navigations with Index
+	MaxAge   time.Duration // Cache lifetime of unhashed assets; 0 revalidates every time
+	Exclude  []string      // Path prefixes never answered with Index; DefaultExclude when nil
+}
+
+// DefaultExclude are the server's own path prefixes, kept out of the fallback
+var DefaultExclude = []string{"/api/", "/ws/", "/audio/", "/attachments/", "/stickers/", "/debug/", "/metrics", "/health"}
+
+// hashedName matches file names carrying a content hash, e.g. app.3f9a2c1e.js
+// or index-BfX8k2aQ.css: a segment of 8 or more letters, digits, _ or -,
+// with at least one digit, between the base name and the extension
+var hashedName = regexp.MustCompile(`[.-]([A-Za-z0-9_-]*[0-9][A-Za-z0-9_-]*)\.[A-Za-z0-9]+$`)
+
+// immutable is the Cache-Control of content-hashed assets
+const immutable = "public, max-age=31536000, immutable"
+
+// Serve answers requests no route matched with files from fsys
+func Serve(r *gin.Engine, fsys fs.FS, opts Options) {
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	if opts.Exclude == nil {
+		opts.Exclude = DefaultExclude
+	}
+	r.NoRoute(func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			return // gin answers 404
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+c.Request.URL.Path), "/")
+		if name == "" {
+			name = opts.Index
+		}
+		if !hidden(name) && serveFile(c, fsys, name, cacheControl(name, opts)) {
+			return
+		}
+		if opts.Fallback && wantsPage(c.Request) && !excluded(c.Request.URL.Path, opts.Exclude) {
+			serveFile(c, fsys, opts.Index, "no-cache")
+		}
+	})
+}
+
+// cacheControl picks the Cache-Control for a file
+func cacheControl(name string, opts Options) string {
+	switch {
+	case name == opts.Index:
+		return "no-cache"
+	case isHashed(path.Base(name)):
+		return immutable
+	case opts.MaxAge <= 0:
+		return "no-cache"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(opts.MaxAge/time.Second))
+}
+
+// isHashed reports whether a file name carries a content hash
+func isHashed(base string) bool {
+	m := hashedName.FindStringSubmatch(base)
+	return m != nil && len(m[1]) >= 8
+}
+
+// serveFile sends a regular file from fsys, reporting false if there is none
+func serveFile(c *gin.Context, fsys fs.FS, name, cache string) bool {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return false
+		}
+		content = bytes.NewReader(data)
+	}
+	c.Header("Cache-Control", cache)
+	c.Header("X-Content-Type-Options", "nosniff")
+	// ServeContent picks the Content-Type from the name and handles
+	// ranges and If-Modified-Since
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), content)
+	return true
+}
+
+// hidden reports whether any part of name is a dotfile
+func hidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsPage reports whether r is a browser navigating to a page
+func wantsPage(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// excluded reports whether p is under one of prefixes
+func excluded(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,290 @@
+// Minimal chat client for the bundled demo page, see web/web.go.
+// User-supplied strings only ever go into textContent or attributes
+// the browser treats as URLs, never innerHTML.
+"use strict";
+
+const maxFrameBytes = 512; // The server closes connections sending more
+const $ = (id) => document.getElementById(id);
+
+let socket = null;
+let session = null; // {room, username} while joined
+let retries = 0;
+let reconnectTimer = null;
+const members = new Map(); // Username -> {username, avatar, status}, from presence frames
+const rendered = new Map(); // Message ID -> list item, for moderation updates
+
+// Step 1: Join from the form, remembering the last room and name
+const joinForm = $("join");
+joinForm.room.value = decodeURIComponent(location.hash.slice(1)) || localStorage.getItem("room") || "lobby";
+joinForm.username.value = localStorage.getItem("username") || "";
+
+joinForm.addEventListener("submit", (event) => {
+  event.preventDefault();
+  session = { room: joinForm.room.value.trim(), username: joinForm.username.value.trim() };
+  if (!session.room || !session.username) return;
+  localStorage.setItem("room", session.room);
+  localStorage.setItem("username", session.username);
+  location.hash = encodeURIComponent(session.room);
+  $("room").textContent = "#" + session.room;
+  $("messages").replaceChildren();
+  joinForm.hidden = true;
+  $("chat").hidden = false;
+  connect();
+});
+
+$("leave").addEventListener("click", () => {
+  session = null;
+  clearTimeout(reconnectTimer);
+  if (socket) socket.close(1000);
+  $("chat").hidden = true;
+  joinForm.hidden = false;
+});
+
+// Step 2: Connect, and reconnect with backoff if the connection drops
+function connect(url) {
+  const base = url || (location.protocol === "https:" ? "wss://" : "ws://") + location.host;
+  const params = new URLSearchParams({ username: session.username });
+  socket = new WebSocket(base + "/ws/" + encodeURIComponent(session.room) + "?" + params);
+  setStatus("connecting…");
+
+  socket.onopen = () => {
+    retries = 0;
+    setStatus("connected");
+  };
+  socket.onmessage = (event) => {
+    let msg;
+    try {
+      msg = JSON.parse(event.data);
+    } catch {
+      return;
+    }
+    handle(msg);
+  };
+  socket.onclose = (event) => {
+    if (!session) return;
+    const delay = Math.min(30000, 500 * 2 ** retries++) * (0.5 + Math.random());
+    setStatus(`disconnected (${event.code}), retrying in ${Math.round(delay / 1000)}s`);
+    reconnectTimer = setTimeout(() => connect(), delay);
+  };
+}
+
+function send(frame) {
+  if (!socket || socket.readyState !== WebSocket.OPEN) return false;
+  socket.send(JSON.stringify(frame));
+  return true;
+}
+
+function setStatus(text) {
+  $("status").textContent = text;
+}
+
+// Step 3: Handle each frame type the page knows; ignore the rest
+function handle(msg) {
+  // Messages sent with a delivery guarantee must be acknowledged
+  if (msg.qos && msg.id) send({ type: "ack", id: msg.id });
+
+  switch (msg.type) {
+    case "hello":
+      session.username = msg.username; // The server may have renamed us
+      setStatus(`connected as ${msg.username}`);
+      break;
+    case "chat":
+    case "sticker":
+    case "audio":
+    case "attachment":
+      if (!rendered.has(msg.id)) addMessage(msg);
+      break;
+    case "announcement":
+      addLine("announcement", null, content(msg));
+      break;
+    case "user_joined":
+    case "user_left":
+      addLine("system", null, text(msg.content));
+      break;
+    case "online_users": // The full list: on joining, and after load spikes
+      members.clear();
+      for (const m of msg.users || []) members.set(m.username, m);
+      showUsers();
+      break;
+    case "presence_join":
+      members.set(msg.username, { username: msg.username, avatar: msg.avatar, status: msg.status });
+      showUsers();
+      break;
+    case "presence_leave":
+      members.delete(msg.username);
+      showUsers();
+      break;
+    case "rtt": // Sent with CHAT_RTT_REPORTS after each ping
+      setStatus(`connected as ${session.username} · ${Math.round(msg.rtt_ms)} ms`);
+      break;
+    case "heartbeat":
+      send({ type: "heartbeat" }); // Still here; browsers answer pings even for frozen tabs
+      break;
+    case "moderation":
+      moderate(msg);
+      break;
+    case "error":
+      addLine("error", null, text(`${msg.content} (${msg.code || "error"})`));
+      break;
+    case "reconnect":
+      // Draining or rebalancing: go where the server says, after the delay
+      setStatus("server asked us to reconnect…");
+      setTimeout(() => {
+        if (!session) return;
+        socket.onclose = null;
+        socket.close(1000);
+        connect(msg.url);
+      }, msg.retry_after_ms || 0);
+      break;
+  }
+}
+
+// Step 4: Rendering
+function text(s) {
+  return document.createTextNode(s || "");
+}
+
+// content renders Markdown segments when the server sent them, else plain text
+function content(msg) {
+  if (!msg.formatted) return text(msg.content);
+  const span = document.createElement("span");
+  for (const seg of msg.formatted) {
+    let node = text(seg.text);
+    if (seg.code) node = wrap("code", node);
+    if (seg.bold) node = wrap("strong", node);
+    if (seg.italic) node = wrap("em", node);
+    if (seg.link) {
+      const a = wrap("a", node);
+      a.href = seg.link; // The server only allows http, https and mailto
+      a.rel = "noopener noreferrer";
+      a.target = "_blank";
+      node = a;
+    }
+    span.append(node);
+  }
+  return span;
+}
+
+function wrap(tag, child) {
+  const el = document.createElement(tag);
+  el.append(child);
+  return el;
+}
+
+function avatarFor(username) {
+  const url = members.get(username)?.avatar;
+  if (url) {
+    const img = document.createElement("img");
+    img.className = "avatar";
+    img.src = url + "?size=32";
+    img.alt = "";
+    return img;
+  }
+  const initial = document.createElement("span");
+  initial.className = "avatar";
+  initial.textContent = (username || "?").slice(0, 1).toUpperCase();
+  return initial;
+}
+
+function addMessage(msg) {
+  const body = document.createElement("div");
+  body.className = "body";
+  const name = document.createElement("span");
+  name.className = "name";
+  name.textContent = msg.username;
+  body.append(name);
+
+  if (msg.type === "sticker" && msg.sticker && msg.sticker.url) {
+    const img = document.createElement("img");
+    img.className = "sticker";
+    img.src = msg.sticker.url;
+    img.alt = msg.sticker.alt || msg.content;
+    body.append(img);
+  } else if (msg.type === "audio" && msg.audio && msg.audio.url) {
+    const audio = document.createElement("audio");
+    audio.controls = true;
+    audio.preload = "none";
+    audio.src = msg.audio.url;
+    body.append(text(msg.content), audio);
+  } else if (msg.type === "attachment" && msg.attachment) {
+    const a = document.createElement("a");
+    a.href = msg.attachment.url;
+    a.textContent = msg.attachment.name || msg.content;
+    a.rel = "noopener noreferrer";
+    body.append(a, text(` (${formatSize(msg.attachment.size)})`));
+  } else {
+    body.append(content(msg));
+  }
+
+  const li = addLine("", avatarFor(msg.username), body);
+  if (msg.id) rendered.set(msg.id, li);
+}
+
+function addLine(className, avatar, child) {
+  const list = $("messages");
+  const stick = list.scrollTop + list.clientHeight >= list.scrollHeight - 20;
+  const li = document.createElement("li");
+  if (className) li.className = className;
+  if (avatar) li.append(avatar);
+  li.append(child);
+  list.append(li);
+  if (stick) list.scrollTop = list.scrollHeight;
+  return li;
+}
+
+function showUsers() {
+  const users = [...members.keys()].sort();
+  $("users").replaceChildren(...users.map((user) => {
+    const li = document.createElement("li");
+    li.append(avatarFor(user), text(user));
+    if (members.get(user).status === "away") li.className = "away";
+    return li;
+  }));
+}
+
+function moderate(msg) {
+  const li = rendered.get(msg.id);
+  if (!li) return;
+  if (msg.code === "deleted") {
+    li.remove();
+    rendered.delete(msg.id);
+  } else {
+    li.classList.toggle("hidden", msg.code === "hidden");
+  }
+}
+
+function formatSize(bytes) {
+  if (!bytes) return "?";
+  const units = ["B", "KB", "MB", "GB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return `${bytes.toFixed(i ? 1 : 0)} ${units[i]}`;
+}
+
+// Step 5: Sending
+$("compose").addEventListener("submit", (event) => {
+  event.preventDefault();
+  const input = $("text");
+  const value = input.value.trim();
+  if (!value) return;
+  const frame = { type: "chat", content: value };
+  if (new TextEncoder().encode(JSON.stringify(frame)).length > maxFrameBytes) {
+    addLine("error", null, text("That message is too long."));
+    return;
+  }
+  if (send(frame)) input.value = "";
+});
+
+$("avatar").addEventListener("change", async (event) => {
+  const file = event.target.files[0];
+  event.target.value = "";
+  if (!file || !session) return;
+  const res = await fetch(`/api/users/${encodeURIComponent(session.username)}/avatar`, { method: "PUT", body: file });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    addLine("error", null, text(`Avatar upload failed: ${body.error || res.status}`));
+  }
+});
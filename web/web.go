@@ -0,0 +1,38 @@
+package web
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Web Client Overview:
+-------------------
+A minimal browser client is compiled into the binary, so `go run .`
+is a working demo with nothing else to install or serve:
+
+	GET /           the page (static/index.html)
+	GET /app.js     the client, plain JavaScript with no build step
+	GET /style.css
+
+It joins a room over the WebSocket, shows the room's members with
+their avatars, and renders chat, stickers, voice notes, attachments
+and announcements. Every user-supplied string is inserted as text,
+never as HTML. Embedders with their own frontend turn it off with
+CHAT_WEB_CLIENT=false, or serve theirs in its place (see Serve).
+*/
+
+//go:embed static
+var static embed.FS
+
+// Register serves the bundled web client at /
+func Register(r *gin.Engine) {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // The directory is embedded above, so this can't happen
+	}
+	// Asset names don't change with the binary, so browsers revalidate them
+	Serve(r, assets, Options{})
+}
@@ -0,0 +1,136 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"chat-app/metrics"
+)
+
+/*
+Access Log Overview:
+-------------------
+For audits, each WebSocket session ends with exactly one record in
+the access log, a stream of its own kept apart from the server's log
+(see CHAT_ACCESS_LOG). Records are JSON, one per line:
+
+	{"time": "...", "conn": "c1f0...", "username": "alice", "room": "lobby",
+	 "ip": "203.0.113.7", "connected_at": "...", "duration_ms": 93512,
+	 "messages_in": 14, "bytes_in": 1022, "messages_out": 310,
+	 "bytes_out": 48213, "close_reason": "client_close"}
+
+A connection only ever joins one room, so each record names one; a
+user who moves between rooms leaves one record per session. Messages
+count WebSocket frames, pings and pongs aside. Frames still queued
+when the hub removes the session aren't counted as sent.
+
+The hub hands records over without blocking and a goroutine writes
+them, so a slow disk never stalls delivery. If accessLogBuffer
+records are already waiting the new one is dropped and counted in
+chat_access_log_dropped_total, which an audit should alert on.
+*/
+
+// Records waiting to be written before new ones are dropped
+const accessLogBuffer = 10000
+
+// SessionRecord is the access log entry for one WebSocket session
+type SessionRecord struct {
+	Time        time.Time `json:"time"`
+	Conn        string    `json:"conn"`
+	Username    string    `json:"username"`
+	Room        string    `json:"room"`
+	IP          string    `json:"ip,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Country     string    `json:"country,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	DurationMs  int64     `json:"duration_ms"`
+	MessagesIn  int64     `json:"messages_in"`
+	BytesIn     int64     `json:"bytes_in"`
+	MessagesOut int64     `json:"messages_out"`
+	BytesOut    int64     `json:"bytes_out"`
+	CloseReason string    `json:"close_reason"`
+}
+
+// AccessLog writes a SessionRecord per closed session
+type AccessLog struct {
+	w       io.Writer
+	records chan SessionRecord
+}
+
+// NewAccessLog returns an access log writing JSON lines to w
+func NewAccessLog(w io.Writer) *AccessLog {
+	return &AccessLog{w: w, records: make(chan SessionRecord, accessLogBuffer)}
+}
+
+// Run writes records as sessions close until ctx is done
+func (a *AccessLog) Run(ctx context.Context) {
+	enc := json.NewEncoder(a.w)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rec := <-a.records:
+			if err := enc.Encode(rec); err != nil {
+				log.Printf("Access log: writing record for conn=%s failed: %v", rec.Conn, err)
+			}
+		}
+	}
+}
+
+// record queues rec without blocking, dropping it when the buffer is full
+func (a *AccessLog) record(rec SessionRecord) {
+	select {
+	case a.records <- rec:
+	default:
+		metrics.AccessLogDropped.Inc()
+	}
+}
+
+// traffic counts one direction of a connection's frames
+type traffic struct {
+	messages atomic.Int64
+	bytes    atomic.Int64
+}
+
+// add counts a frame of n bytes
+func (t *traffic) add(n int) {
+	t.messages.Add(1)
+	t.bytes.Add(int64(n))
+}
+
+// WithAccessLog writes a record per closed session to a
+func WithAccessLog(a *AccessLog) HubOption {
+	return func(h *LocalHub) {
+		h.accessLog = a
+	}
+}
+
+// logSession writes client's access log record as it is removed
+func (h *LocalHub) logSession(client *Client, reason string) {
+	if h.accessLog == nil {
+		return
+	}
+	now := time.Now()
+	h.accessLog.record(SessionRecord{
+		Time:        now,
+		Conn:        client.id,
+		Username:    client.username,
+		Room:        client.room,
+		IP:          client.meta.ip,
+		UserAgent:   client.meta.userAgent,
+		Country:     client.meta.location.Country,
+		Tenant:      client.tenant.Tenant(),
+		ConnectedAt: client.connectedAt,
+		DurationMs:  now.Sub(client.connectedAt).Milliseconds(),
+		MessagesIn:  client.received.messages.Load(),
+		BytesIn:     client.received.bytes.Load(),
+		MessagesOut: client.sent.messages.Load(),
+		BytesOut:    client.sent.bytes.Load(),
+		CloseReason: reason,
+	})
+}
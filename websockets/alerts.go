@@ -0,0 +1,142 @@
+package websockets
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Keyword Alert Overview:
+----------------------
+Moderators can watch rooms for keywords and regular expressions (see
+storage.KeywordAlert, set through /api/mod). When a chat message
+matches, each watching moderator gets a private frame on every
+connection they have open on the node, whatever room it is in:
+
+	{"type": "keyword_alert", "room": "lobby", "content": "giveaway",
+	 "alert": {"message": {...}, "context": [{...}, {...}]}}
+
+content is the keyword or pattern that matched; context holds up to
+alertContext earlier messages from the room, oldest first.
+
+Matching runs on the room's owner after the message is delivered.
+Watch lists are cached per room for keywordAlertTTL, so changes take
+effect within that time. In a cluster, only moderators connected to
+the room's owner are alerted.
+*/
+
+// keywordAlertTTL is how long a room's watch lists are cached
+const keywordAlertTTL = 10 * time.Second
+
+// alertContext is how many earlier messages a keyword_alert carries
+const alertContext = 3
+
+// Alert is the payload of a keyword_alert frame
+type Alert struct {
+	Message Message   `json:"message"`
+	Context []Message `json:"context"` // Earlier messages in the room, oldest first
+}
+
+// alertTerm is one compiled keyword or pattern
+type alertTerm struct {
+	label string // As the moderator wrote it
+	re    *regexp.Regexp
+}
+
+// keywordWatch is one moderator's compiled watch list for a room
+type keywordWatch struct {
+	moderator string
+	terms     []alertTerm
+}
+
+// roomWatches caches a room's watch lists
+type roomWatches struct {
+	watches []keywordWatch
+	expires time.Time
+}
+
+// compileWatch turns a stored watch list into matchers
+// Keywords match as whole words, ignoring case
+func compileWatch(alert storage.KeywordAlert) keywordWatch {
+	w := keywordWatch{moderator: alert.Moderator}
+	for _, kw := range alert.Keywords {
+		w.terms = append(w.terms, alertTerm{kw, regexp.MustCompile(`(?i)` + wordBoundary(kw[0]) + regexp.QuoteMeta(kw) + wordBoundary(kw[len(kw)-1]))})
+	}
+	for _, p := range alert.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			continue // Validated when saved; skip anything that slipped through
+		}
+		w.terms = append(w.terms, alertTerm{p, re})
+	}
+	return w
+}
+
+// wordBoundary anchors a keyword edge at a word boundary, when it is a word character
+func wordBoundary(edge byte) string {
+	if edge == '_' || 'a' <= edge && edge <= 'z' || 'A' <= edge && edge <= 'Z' || '0' <= edge && edge <= '9' {
+		return `\b`
+	}
+	return ""
+}
+
+// watches returns room's watch lists, loading them if the cache is stale
+// If loading fails the last known lists stay in force
+func (h *LocalHub) watches(room string, now time.Time) []keywordWatch {
+	cached, ok := h.alerts[room]
+	if ok && now.Before(cached.expires) {
+		return cached.watches
+	}
+
+	ctx, cancel := storageContext()
+	defer cancel()
+	alerts, err := h.store.KeywordAlerts(ctx, room)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		reportStorageError("load keyword alerts", err, errreport.Context{Room: room})
+		return cached.watches
+	}
+
+	watches := make([]keywordWatch, 0, len(alerts))
+	for _, alert := range alerts {
+		watches = append(watches, compileWatch(alert))
+	}
+	h.alerts[room] = roomWatches{watches: watches, expires: now.Add(keywordAlertTTL)}
+	return watches
+}
+
+// sweepWatches drops cached watch lists for rooms gone quiet
+func (h *LocalHub) sweepWatches(now time.Time) {
+	for room, cached := range h.alerts {
+		if now.After(cached.expires) {
+			delete(h.alerts, room)
+		}
+	}
+}
+
+// alertModerators sends keyword_alert frames for a chat message just delivered
+func (h *LocalHub) alertModerators(msg Message, now time.Time) {
+	var alert *Alert
+	for _, w := range h.watches(msg.RoomName, now) {
+		if w.moderator == msg.Username {
+			continue // Nobody needs alerting about their own message
+		}
+		for _, term := range w.terms {
+			if !term.re.MatchString(msg.Content) {
+				continue
+			}
+			if alert == nil {
+				alert = &Alert{Message: msg, Context: h.recent.before(msg.RoomName, msg.ID, alertContext)}
+			}
+			for client := range h.clients {
+				if client.username == w.moderator {
+					h.sendTo(client, Message{Type: "keyword_alert", Content: term.label, RoomName: msg.RoomName, Alert: alert})
+				}
+			}
+			break // One alert per moderator per message
+		}
+	}
+}
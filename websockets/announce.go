@@ -0,0 +1,38 @@
+package websockets
+
+import "chat-app/markdown"
+
+/*
+Announcement Overview:
+---------------------
+Announce posts a message from the server to everyone in a room, e.g.
+for the scheduled announcements in the schedule package:
+
+	{"type": "announcement", "room": "team", "username": "system",
+	 "content": "Standup in 5 minutes!"}
+
+Like chat messages, they may use Markdown (see the markdown package).
+Announcements are control frames: they carry no Seq and aren't kept
+in history. In a cluster they are forwarded to the room's owner like
+any other broadcast. Archived rooms get none.
+*/
+
+// announcementSender names announcements that don't set a sender
+const announcementSender = "system"
+
+// Announce posts content to room as from ("system" when empty)
+func (h *LocalHub) Announce(room, from, content string) {
+	if from == "" {
+		from = announcementSender
+	}
+	if _, archived := h.Archived(room); archived {
+		return // Archived rooms are read-only, see archived.go
+	}
+	h.Broadcast(Message{
+		Type:      "announcement",
+		Content:   content,
+		RoomName:  room,
+		Username:  from,
+		Formatted: markdown.Format(content),
+	})
+}
@@ -0,0 +1,424 @@
+package websockets
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"chat-app/metrics"
+)
+
+/*
+Anomaly Detection Overview:
+--------------------------
+Abusive clients tend to look alike: they reconnect over and over, hop
+between rooms, or send frames the server can't parse. WithAnomalyDetector
+counts three signals per client IP and per username over a sliding
+window:
+
+	churn          connections opened
+	room_hopping   distinct rooms joined
+	errors         bad_frame and unknown_type errors caused
+
+When a count reaches its threshold the subject is flagged until the
+cooldown ends:
+
+  - An IP gets a cooldown: new connections from it are refused with
+    429 and Retry-After. Its open connections are left alone, since
+    many users can share an address
+  - A user gets a cooldown too, or with Reauth set must re-authenticate:
+    either way their open connections get an error frame (code
+    "cooldown" or "reauth_required") and are closed. A user flagged for
+    re-auth may come back as soon as the WithAuth hook approves them
+    again; without a hook they wait out the cooldown
+
+Every flag is logged, counted in chat_anomalies_total and kept in a
+short history for GET /api/admin/anomalies, where admins can also
+lift flags early. Counts are per node: in a cluster a client spread
+over several nodes is judged on each separately.
+*/
+
+// Signals that can flag a subject
+const (
+	SignalChurn       = "churn"
+	SignalRoomHopping = "room_hopping"
+	SignalErrors      = "errors"
+)
+
+// Actions taken against a flagged subject
+const (
+	ActionCooldown = "cooldown"
+	ActionReauth   = "reauth"
+)
+
+// Subjects the detector tracks
+const (
+	SubjectIP   = "ip"
+	SubjectUser = "user"
+)
+
+// Error codes sent to flagged users before they are disconnected
+const (
+	errCodeCooldown = "cooldown"
+	errCodeReauth   = "reauth_required"
+)
+
+// anomalyHistory is how many flags the detector remembers for admins
+const anomalyHistory = 100
+
+// anomalySweepInterval is how often subjects with nothing to remember are dropped
+const anomalySweepInterval = time.Minute
+
+// anomalyKickGrace lets the error frame reach a flagged client before it is closed
+const anomalyKickGrace = time.Second
+
+// AnomalyConfig sets the thresholds; a zero threshold ignores that signal
+type AnomalyConfig struct {
+	Window   time.Duration // Signals are counted over this sliding window
+	Connects int           // Connections per IP or user within Window
+	Rooms    int           // Distinct rooms per IP or user within Window
+	Errors   int           // Client-caused errors per IP or user within Window
+	Cooldown time.Duration // How long a flag lasts
+	Reauth   bool          // Flagged users must re-authenticate instead of waiting
+}
+
+// AnomalyEvent records one subject being flagged
+type AnomalyEvent struct {
+	Time      time.Time `json:"time"`
+	Subject   string    `json:"subject"` // SubjectIP or SubjectUser
+	Key       string    `json:"key"`     // The IP or username
+	Signal    string    `json:"signal"`
+	Count     int       `json:"count"` // Observed within the window
+	Threshold int       `json:"threshold"`
+	Action    string    `json:"action"`
+	Until     time.Time `json:"until"`
+}
+
+// AnomalyFlag is an active restriction on a subject
+type AnomalyFlag struct {
+	Subject string    `json:"subject"`
+	Key     string    `json:"key"`
+	Signal  string    `json:"signal"`
+	Action  string    `json:"action"`
+	Until   time.Time `json:"until"`
+}
+
+// AnomalyReport is what admins see: active flags and recent history, newest first
+type AnomalyReport struct {
+	Flags  []AnomalyFlag  `json:"flags"`
+	Events []AnomalyEvent `json:"events"`
+}
+
+// subjectKey identifies an IP or a user
+type subjectKey struct {
+	subject string
+	key     string
+}
+
+// behavior is what the detector knows about one subject
+type behavior struct {
+	connects []time.Time
+	rooms    map[string]time.Time // Room -> last joined
+	errors   []time.Time
+	flag     *AnomalyFlag // Set while restricted
+	lastSeen time.Time
+}
+
+// AnomalyDetector tracks connection behavior; safe for concurrent use
+type AnomalyDetector struct {
+	cfg AnomalyConfig
+
+	mu        sync.Mutex
+	subjects  map[subjectKey]*behavior
+	history   []AnomalyEvent // Oldest first, at most anomalyHistory
+	lastSweep time.Time
+}
+
+// NewAnomalyDetector builds a detector; it returns nil if cfg has no thresholds
+func NewAnomalyDetector(cfg AnomalyConfig) *AnomalyDetector {
+	if cfg.Connects <= 0 && cfg.Rooms <= 0 && cfg.Errors <= 0 {
+		return nil
+	}
+	return &AnomalyDetector{
+		cfg:       cfg,
+		subjects:  make(map[subjectKey]*behavior),
+		lastSweep: time.Now(),
+	}
+}
+
+// WithAnomalyDetector flags clients that misbehave (see anomaly.go)
+func WithAnomalyDetector(d *AnomalyDetector) HubOption {
+	return func(h *LocalHub) {
+		h.anomalies = d
+	}
+}
+
+// Connected records a connection to room and returns any flags it raised
+func (d *AnomalyDetector) Connected(ip, username, room string, now time.Time) []AnomalyEvent {
+	return d.observe(ip, username, now, func(b *behavior) (string, int, int) {
+		b.connects = append(d.recent(b.connects, now), now)
+		b.rooms[room] = now
+		for r, at := range b.rooms {
+			if now.Sub(at) > d.cfg.Window {
+				delete(b.rooms, r)
+			}
+		}
+		switch {
+		case d.cfg.Connects > 0 && len(b.connects) >= d.cfg.Connects:
+			return SignalChurn, len(b.connects), d.cfg.Connects
+		case d.cfg.Rooms > 0 && len(b.rooms) >= d.cfg.Rooms:
+			return SignalRoomHopping, len(b.rooms), d.cfg.Rooms
+		}
+		return "", 0, 0
+	})
+}
+
+// Errored records an error caused by a client and returns any flags it raised
+func (d *AnomalyDetector) Errored(ip, username string, now time.Time) []AnomalyEvent {
+	return d.observe(ip, username, now, func(b *behavior) (string, int, int) {
+		b.errors = append(d.recent(b.errors, now), now)
+		if d.cfg.Errors > 0 && len(b.errors) >= d.cfg.Errors {
+			return SignalErrors, len(b.errors), d.cfg.Errors
+		}
+		return "", 0, 0
+	})
+}
+
+// observe applies record to the IP and the user, flagging any that trip
+// record returns the signal tripped with its count and threshold, or ""
+func (d *AnomalyDetector) observe(ip, username string, now time.Time, record func(*behavior) (string, int, int)) []AnomalyEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+
+	var events []AnomalyEvent
+	for _, sk := range []subjectKey{{SubjectIP, ip}, {SubjectUser, username}} {
+		if sk.key == "" {
+			continue
+		}
+		b := d.behavior(sk, now)
+		signal, count, threshold := record(b)
+		if signal == "" || b.active(now) {
+			continue
+		}
+		events = append(events, d.flag(sk, b, signal, count, threshold, now))
+	}
+	return events
+}
+
+// flag restricts a subject and starts its counts afresh
+func (d *AnomalyDetector) flag(sk subjectKey, b *behavior, signal string, count, threshold int, now time.Time) AnomalyEvent {
+	action := ActionCooldown
+	if sk.subject == SubjectUser && d.cfg.Reauth {
+		action = ActionReauth
+	}
+	until := now.Add(d.cfg.Cooldown)
+	b.flag = &AnomalyFlag{Subject: sk.subject, Key: sk.key, Signal: signal, Action: action, Until: until}
+	b.connects, b.errors = nil, nil
+	clear(b.rooms)
+
+	event := AnomalyEvent{
+		Time:      now,
+		Subject:   sk.subject,
+		Key:       sk.key,
+		Signal:    signal,
+		Count:     count,
+		Threshold: threshold,
+		Action:    action,
+		Until:     until,
+	}
+	if len(d.history) >= anomalyHistory {
+		d.history = d.history[1:]
+	}
+	d.history = append(d.history, event)
+	metrics.Anomalies.WithLabelValues(signal, sk.subject, action).Inc()
+	log.Printf("Anomaly: %s=%s %s %d/%d within %s, %s until %s",
+		sk.subject, sk.key, signal, count, threshold, d.cfg.Window, action, until.Format(time.RFC3339))
+	return event
+}
+
+// Check returns the active flag on a subject, if any
+func (d *AnomalyDetector) Check(subject, key string, now time.Time) (AnomalyFlag, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.subjects[subjectKey{subject, key}]
+	if !ok || !b.active(now) {
+		return AnomalyFlag{}, false
+	}
+	return *b.flag, true
+}
+
+// Lift removes the flag on a subject, reporting whether there was one
+func (d *AnomalyDetector) Lift(subject, key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.subjects[subjectKey{subject, key}]
+	if !ok || b.flag == nil {
+		return false
+	}
+	b.flag = nil
+	return true
+}
+
+// Report lists active flags and recent events, newest first
+func (d *AnomalyDetector) Report(now time.Time) AnomalyReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	report := AnomalyReport{Flags: []AnomalyFlag{}, Events: append([]AnomalyEvent{}, d.history...)}
+	slices.Reverse(report.Events)
+	for _, b := range d.subjects {
+		if b.active(now) {
+			report.Flags = append(report.Flags, *b.flag)
+		}
+	}
+	slices.SortFunc(report.Flags, func(a, b AnomalyFlag) int {
+		return b.Until.Compare(a.Until)
+	})
+	return report
+}
+
+// behavior returns the record for a subject, creating it if needed
+func (d *AnomalyDetector) behavior(sk subjectKey, now time.Time) *behavior {
+	b, ok := d.subjects[sk]
+	if !ok {
+		b = &behavior{rooms: make(map[string]time.Time)}
+		d.subjects[sk] = b
+	}
+	b.lastSeen = now
+	return b
+}
+
+// recent drops the times that have left the window
+func (d *AnomalyDetector) recent(times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > d.cfg.Window {
+		i++
+	}
+	return times[i:]
+}
+
+// sweep forgets subjects that are neither restricted nor recently seen
+func (d *AnomalyDetector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < anomalySweepInterval {
+		return
+	}
+	for sk, b := range d.subjects {
+		if !b.active(now) && now.Sub(b.lastSeen) > d.cfg.Window {
+			delete(d.subjects, sk)
+		}
+	}
+	d.lastSweep = now
+}
+
+// active reports whether the subject is restricted at now
+func (b *behavior) active(now time.Time) bool {
+	return b.flag != nil && now.Before(b.flag.Until)
+}
+
+// clientFault reports whether an error code means the client misbehaved
+func clientFault(code string) bool {
+	return code == errCodeBadFrame || code == errCodeUnknownType
+}
+
+// observeConnect feeds a new registration to the detector
+func (h *LocalHub) observeConnect(client *Client) {
+	if h.anomalies == nil {
+		return
+	}
+	h.enforce(h.anomalies.Connected(client.meta.ip, client.username, client.room, time.Now()))
+}
+
+// observeError feeds an error frame sent to client to the detector
+func (h *LocalHub) observeError(client *Client, code string) {
+	if h.anomalies == nil || !clientFault(code) {
+		return
+	}
+	h.enforce(h.anomalies.Errored(client.meta.ip, client.username, time.Now()))
+}
+
+// enforce disconnects the open connections of flagged users
+// Flagged IPs are only refused new connections, at the handler
+func (h *LocalHub) enforce(events []AnomalyEvent) {
+	for _, event := range events {
+		if event.Subject != SubjectUser {
+			continue
+		}
+		code, text := errCodeCooldown, "too much unusual activity, try again later"
+		if event.Action == ActionReauth {
+			code, text = errCodeReauth, "please sign in again"
+		}
+		for client := range h.clients {
+			if client.username == event.Key {
+				h.kick(client, code, text)
+			}
+		}
+	}
+}
+
+// kick tells a client why it is being removed, then closes it shortly after
+func (h *LocalHub) kick(client *Client, code, text string) {
+	h.sendTo(client, Message{Type: "error", Code: code, Content: text, RoomName: client.room})
+	time.AfterFunc(anomalyKickGrace, func() {
+		h.query(func() {
+			if h.clients[client] {
+				h.disconnect(client, closeReasonKicked)
+			}
+		})
+	})
+}
+
+// ScreenIP reports how long connections from ip are refused, if they are
+func (h *LocalHub) ScreenIP(ip string) (time.Duration, bool) {
+	if h.anomalies == nil {
+		return 0, true
+	}
+	now := time.Now()
+	flag, flagged := h.anomalies.Check(SubjectIP, ip, now)
+	if !flagged {
+		return 0, true
+	}
+	return flag.Until.Sub(now), false
+}
+
+// ScreenUser reports whether username may connect
+// authenticated is true when the auth hook or an access token has just approved them,
+// which clears a re-auth flag; otherwise it returns how long to wait
+// and whether the user must re-authenticate
+func (h *LocalHub) ScreenUser(username string, authenticated bool) (time.Duration, bool, bool) {
+	if h.anomalies == nil {
+		return 0, false, true
+	}
+	now := time.Now()
+	flag, flagged := h.anomalies.Check(SubjectUser, username, now)
+	if !flagged {
+		return 0, false, true
+	}
+	if flag.Action == ActionReauth {
+		if authenticated {
+			h.anomalies.Lift(SubjectUser, username)
+			return 0, false, true
+		}
+		return flag.Until.Sub(now), true, false
+	}
+	return flag.Until.Sub(now), false, false
+}
+
+// Anomalies returns the detector's report; empty when detection is off
+func (h *LocalHub) Anomalies() AnomalyReport {
+	if h.anomalies == nil {
+		return AnomalyReport{Flags: []AnomalyFlag{}, Events: []AnomalyEvent{}}
+	}
+	return h.anomalies.Report(time.Now())
+}
+
+// LiftAnomaly clears the flag on an IP or user, reporting whether there was one
+func (h *LocalHub) LiftAnomaly(subject, key string) bool {
+	return h.anomalies != nil && h.anomalies.Lift(subject, key)
+}
+
+// screener is implemented by hubs that refuse connections from flagged clients
+type screener interface {
+	ScreenIP(ip string) (time.Duration, bool)
+	ScreenUser(username string, authenticated bool) (time.Duration, bool, bool)
+}
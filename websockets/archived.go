@@ -0,0 +1,136 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Archived Room Overview:
+----------------------
+An archived room is read-only (see storage.Archival). Moderators
+archive and unarchive rooms through the moderation API, admins
+through the admin API. While archived:
+
+1. Only members (users who joined before) can join, to read along;
+   anyone else is refused with 403
+2. Joiners get a room_archived frame right after the hello
+3. Chat, sticker, voice note and attachment messages get an error:
+
+	{"type": "error", "code": "room_archived",
+	 "content": "this room is archived and read-only"}
+
+4. Scheduled announcements to the room are skipped
+
+When a room is archived or unarchived, the clients in it on the node
+that made the change are told at once with a room_archived or
+room_unarchived frame; other nodes pick the change up with the room
+settings, within roomSettingsTTL.
+*/
+
+// archiveChecker is implemented by hubs that keep archived rooms read-only
+type archiveChecker interface {
+	Archived(room string) (storage.Archival, bool)
+	Member(room, username string) bool
+}
+
+// Archived returns when and by whom room was archived, if it is
+// Safe to call from any goroutine
+func (h *LocalHub) Archived(room string) (storage.Archival, bool) {
+	archived := h.settings.get(room).Archived
+	if archived == nil {
+		return storage.Archival{}, false
+	}
+	return *archived, true
+}
+
+// Member reports whether username has ever joined room
+// Safe to call from any goroutine
+func (h *LocalHub) Member(room, username string) bool {
+	ctx, cancel := storageContext()
+	defer cancel()
+	members, err := h.store.Members(ctx, room)
+	if err != nil {
+		reportStorageError("load members", err, errreport.Context{Room: room, Username: username})
+		return false
+	}
+	return slices.Contains(members, username)
+}
+
+// ArchiveRoom makes room read-only; by names who did it
+// Archiving an archived room changes nothing
+func (h *LocalHub) ArchiveRoom(ctx context.Context, room, by string) (storage.RoomSettings, error) {
+	return h.setArchived(ctx, room, by, &storage.Archival{At: time.Now().UTC(), By: by})
+}
+
+// UnarchiveRoom lets room be posted to again; by names who did it
+// Unarchiving a room that isn't archived changes nothing
+func (h *LocalHub) UnarchiveRoom(ctx context.Context, room, by string) (storage.RoomSettings, error) {
+	return h.setArchived(ctx, room, by, nil)
+}
+
+// setArchived saves room's archived state, telling the room here
+func (h *LocalHub) setArchived(ctx context.Context, room, by string, archived *storage.Archival) (storage.RoomSettings, error) {
+	settings, err := h.store.GetRoomSettings(ctx, room)
+	if errors.Is(err, storage.ErrNotFound) {
+		settings = storage.DefaultRoomSettings(room)
+	} else if err != nil {
+		return settings, err
+	}
+	if (settings.Archived != nil) == (archived != nil) {
+		return settings, nil
+	}
+
+	settings.Archived = archived
+	settings.UpdatedAt = time.Now().UTC()
+	if err := h.store.SaveRoomSettings(ctx, settings); err != nil {
+		return settings, err
+	}
+	h.settings.forget(room)
+
+	action, content := "unarchive", "this room was unarchived"
+	if archived != nil {
+		action, content = "archive", "this room was archived and is now read-only"
+	}
+	h.query(func() {
+		h.recordModeration(ruleArchive, room, "", "", action, map[string]string{"by": by})
+		for client := range h.rooms[room] {
+			h.sendTo(client, archivedMessage(room, archived, content))
+		}
+	})
+	return settings, nil
+}
+
+// sendArchived tells a joiner that the room is read-only
+func (h *LocalHub) sendArchived(client *Client) {
+	if archived, ok := h.Archived(client.room); ok {
+		h.sendTo(client, archivedMessage(client.room, &archived, "this room is archived and read-only"))
+	}
+}
+
+// archivedMessage is the room_archived (or, with nil, room_unarchived) frame
+func archivedMessage(room string, archived *storage.Archival, content string) Message {
+	if archived == nil {
+		return Message{Type: "room_unarchived", Content: content, RoomName: room}
+	}
+	return Message{Type: "room_archived", Content: content, RoomName: room, Username: archived.By}
+}
+
+// roomArchived tells the client and returns true when its room is
+// archived, so nothing can be posted
+func (c *Client) roomArchived() bool {
+	archives, ok := c.hub.(archiveChecker)
+	if !ok {
+		return false
+	}
+	if _, archived := archives.Archived(c.room); !archived {
+		return false
+	}
+	c.hub.Broadcast(errorMessage(c, errCodeRoomArchived, "this room is archived and read-only"))
+	return true
+}
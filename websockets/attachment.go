@@ -0,0 +1,141 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"chat-app/storage"
+	"chat-app/uploads"
+)
+
+/*
+Attachment Overview:
+-------------------
+Attachments are uploaded straight to S3 with a presigned URL (see
+api.RegisterUploads), then posted by their upload ID:
+
+	{"type": "attachment", "attachment": {"id": "7c1e..."}}
+
+Before the room sees it, the client's connection checks that the file
+really arrived with the size and type it was announced with; the
+check is a round trip to S3, so it happens on the connection's own
+goroutine rather than the hub's. The room gets a message like a chat
+message, whose content is the file name:
+
+	{"type": "attachment", "id": "...", "content": "report.pdf", "seq": 44,
+	 "attachment": {"id": "7c1e...", "name": "report.pdf", "content_type": "application/pdf",
+	                "size": 482113, "url": "/attachments/7c1e..."}}
+
+Only the user who uploaded a file can post it, and only to the room it
+was uploaded for; anything else gets an unknown_attachment error, and
+a file that hasn't finished uploading gets upload_incomplete.
+*/
+
+// attachmentTimeout bounds the check that an upload has arrived
+const attachmentTimeout = 10 * time.Second
+
+// Attachment describes an uploaded file on attachment frames
+type Attachment struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// AttachmentURL is the path a posted file is downloaded from, see api.RegisterUploads
+func AttachmentURL(id string) string {
+	return "/attachments/" + url.PathEscape(id)
+}
+
+// WithUploads lets clients post attachments uploaded through service
+// Without it, attachment frames are refused
+func WithUploads(service *uploads.Service) Option {
+	return func(o *handlerOptions) {
+		o.uploads = service
+	}
+}
+
+// postAttachment checks an uploaded file has arrived and posts it to the room
+func (c *Client) postAttachment(ctx context.Context, frame inboundFrame) {
+	if c.uploads == nil {
+		c.hub.Broadcast(errorMessage(c, errCodeUnknownType, "attachments are not enabled"))
+		return
+	}
+	if frame.Attachment == nil || frame.Attachment.ID == "" {
+		c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "attachment frames need the id of an upload"))
+		return
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, attachmentTimeout)
+	defer cancel()
+	upload, err := c.uploads.Complete(checkCtx, c.room, c.username, frame.Attachment.ID)
+	switch {
+	case errors.Is(err, uploads.ErrNotFound):
+		c.hub.Broadcast(errorMessage(c, errCodeUnknownAttachment, "no upload with that id to post here"))
+		return
+	case errors.Is(err, uploads.ErrIncomplete):
+		c.hub.Broadcast(errorMessage(c, errCodeUploadIncomplete, "the file hasn't finished uploading, or doesn't match what was announced"))
+		return
+	case err != nil:
+		reportStorageError("complete upload", err, c.reportContext())
+		c.hub.Broadcast(errorMessage(c, errCodeStorage, "attachment could not be posted, try again"))
+		return
+	}
+	if c.roomArchived() || !c.authorize(ctx, storage.CapPost) || !c.authorize(ctx, storage.CapUpload) || !c.allowTenant(ctx) || !c.takeQuota(ctx, 0) { // The file's bytes counted when its upload started
+		return
+	}
+
+	msg := Message{
+		Type:           "attachment",
+		ID:             newID(),
+		Content:        upload.Name,
+		Attachment:     uploadAttachment(upload),
+		RoomName:       c.room,
+		Username:       c.username,
+		IdempotencyKey: frame.IdempotencyKey,
+		QoS:            frame.QoS,
+		ctx:            ctx,
+		sender:         c,
+	}
+	if msg.QoS == QoSFireAndForget {
+		msg.QoS = ""
+	}
+	c.hub.Broadcast(msg)
+}
+
+// uploadAttachment describes a stored upload to clients
+func uploadAttachment(u storage.Upload) *Attachment {
+	return &Attachment{
+		ID:          u.ID,
+		Name:        u.Name,
+		ContentType: u.ContentType,
+		Size:        u.Size,
+		URL:         AttachmentURL(u.ID),
+	}
+}
+
+// storedAttachment rebuilds a stored message's attachment
+func (h *LocalHub) storedAttachment(stored storage.Message) *Attachment {
+	if stored.Attachment == "" {
+		return nil
+	}
+	ctx, cancel := storageContext()
+	defer cancel()
+	u, err := h.store.GetUpload(ctx, stored.Attachment)
+	if err != nil {
+		// Unreadable; the content still names the file
+		return &Attachment{ID: stored.Attachment, Name: stored.Content, URL: AttachmentURL(stored.Attachment)}
+	}
+	return uploadAttachment(u)
+}
+
+// attachmentRef is what is stored of a message's attachment
+func attachmentRef(a *Attachment) string {
+	if a == nil {
+		return ""
+	}
+	return a.ID
+}
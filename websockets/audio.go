@@ -0,0 +1,124 @@
+package websockets
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Voice Note Overview:
+-------------------
+A voice note is uploaded first (see api.RegisterAudio), which checks
+the recording and returns its clip ID, then posted by that ID:
+
+	{"type": "audio", "audio": {"id": "9f2c..."}}
+
+The room sees it like a chat message, with a Seq, a text fallback for
+clients and bridges that can't play audio, and what a player needs to
+show the clip before loading it:
+
+	{"type": "audio", "id": "...", "content": "Voice note (0:04)", "seq": 43,
+	 "audio": {"id": "9f2c...", "url": "/audio/9f2c...", "content_type": "audio/ogg",
+	           "duration_ms": 4210, "size": 16923}}
+
+Only the user who uploaded a clip can post it, only to the room it
+was uploaded for, and only once; anything else gets an unknown_audio
+error.
+*/
+
+// Audio describes a voice note on audio frames
+type Audio struct {
+	ID          string `json:"id"`
+	URL         string `json:"url,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	DurationMs  int64  `json:"duration_ms,omitempty"`
+	Size        int    `json:"size,omitempty"`
+}
+
+// AudioURL is the path a clip is served at, see api.RegisterAudio
+func AudioURL(id string) string {
+	return "/audio/" + url.PathEscape(id)
+}
+
+// resolveAudio claims a posted clip and fills in its metadata
+// It reports false, after telling the sender, if the clip can't be posted
+func (h *LocalHub) resolveAudio(msg *Message) bool {
+	if msg.Audio != nil {
+		ctx, cancel := storageContext()
+		defer cancel()
+		clip, err := h.store.GetAudio(ctx, msg.Audio.ID)
+		if err == nil && !clip.Posted && clip.Room == msg.RoomName && clip.Username == msg.Username {
+			err = h.store.MarkAudioPosted(ctx, clip.ID)
+		} else if err == nil {
+			err = storage.ErrNotFound
+		}
+		switch {
+		case err == nil:
+			msg.Audio = clipAudio(clip)
+			msg.Content = audioContent(clip.DurationMs)
+			return true
+		case !errors.Is(err, storage.ErrNotFound):
+			reportStorageError("claim audio", err, errreport.Context{Room: msg.RoomName, Username: msg.Username})
+			h.reply(*msg, Message{
+				Type:     "error",
+				Code:     errCodeStorage,
+				Content:  "voice note could not be posted, try again",
+				RoomName: msg.RoomName,
+			})
+			return false
+		}
+	}
+	h.reply(*msg, Message{
+		Type:     "error",
+		Code:     errCodeUnknownAudio,
+		Content:  "no voice note with that id to post here",
+		RoomName: msg.RoomName,
+	})
+	return false
+}
+
+// clipAudio describes a stored clip to clients
+func clipAudio(clip storage.AudioClip) *Audio {
+	return &Audio{
+		ID:          clip.ID,
+		URL:         AudioURL(clip.ID),
+		ContentType: clip.ContentType,
+		DurationMs:  clip.DurationMs,
+		Size:        clip.Size,
+	}
+}
+
+// audioContent is the text shown where a voice note can't be played
+func audioContent(durationMs int64) string {
+	d := time.Duration(durationMs) * time.Millisecond
+	secs := int(d.Round(time.Second) / time.Second)
+	return fmt.Sprintf("Voice note (%d:%02d)", secs/60, secs%60)
+}
+
+// storedAudio rebuilds a stored message's voice note
+func (h *LocalHub) storedAudio(stored storage.Message) *Audio {
+	if stored.Audio == "" {
+		return nil
+	}
+	ctx, cancel := storageContext()
+	defer cancel()
+	clip, err := h.store.GetAudio(ctx, stored.Audio)
+	if err != nil {
+		// Pruned or unreadable; the content still says what it was
+		return &Audio{ID: stored.Audio, URL: AudioURL(stored.Audio)}
+	}
+	return clipAudio(clip)
+}
+
+// audioRef is what is stored of a message's voice note
+func audioRef(a *Audio) string {
+	if a == nil {
+		return ""
+	}
+	return a.ID
+}
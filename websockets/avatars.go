@@ -0,0 +1,62 @@
+package websockets
+
+import (
+	"errors"
+	"net/url"
+
+	"chat-app/storage"
+)
+
+/*
+Avatar Presence Overview:
+------------------------
+Presence frames carry the avatar of each member who has one, as the
+URL of its image (see api.RegisterAvatars):
+
+	{"type": "online_users", "users": [{"username": "alice",
+	 "avatar": "/api/avatars/5d41402abc4b2a76..."}, {"username": "bob"}]}
+	{"type": "presence_join", "username": "alice", "avatar": "/api/avatars/5d41..."}
+
+A connection's avatar is looked up in the background when it joins,
+and its room is told once it is found. Changing an avatar
+(SetAvatar) updates the user's connections on this node and sends
+their rooms a presence_join with the new one; connections on other
+nodes pick it up when they next join.
+*/
+
+// AvatarURL is the path an avatar is served at, see api.RegisterAvatars
+func AvatarURL(id string) string {
+	return "/api/avatars/" + url.PathEscape(id)
+}
+
+// lookupAvatar loads the avatar ID of a joining client's user; called
+// off the hub goroutine (see loadArrival)
+func (h *LocalHub) lookupAvatar(client *Client) string {
+	ctx, cancel := storageContext()
+	defer cancel()
+	user, err := h.store.GetUser(ctx, client.username)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			reportStorageError("get user", err, client.reportContext())
+		}
+		return ""
+	}
+	return user.Avatar
+}
+
+// SetAvatar shows a user's new avatar, or none when id is empty, to
+// the rooms their connections on this node are in
+func (h *LocalHub) SetAvatar(username, id string) {
+	h.query(func() {
+		rooms := make(map[string]bool)
+		for client := range h.clients {
+			if client.username == username && client.avatar != id {
+				client.avatar = id
+				rooms[client.room] = true
+			}
+		}
+		for room := range rooms {
+			h.broadcastRoomUsers(room)
+		}
+	})
+}
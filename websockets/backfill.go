@@ -0,0 +1,169 @@
+package websockets
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/eventlog"
+	"chat-app/metrics"
+	"chat-app/storage"
+)
+
+/*
+Federation Backfill Overview:
+----------------------------
+Frames queued for a peer are lost if its queue fills during a long
+outage, or if this server restarts, which would leave a shared room's
+history different on each server. So whenever the link to a peer
+comes up (see the federation package), this server compares notes
+with it on each room they share:
+
+1. It sends the peer a sync frame per room, with the highest seq of
+   the peer's messages in the room's event log here; each federated
+   message is recorded with its origin and the seq it had there
+2. The peer's owner of the room replays its event log and sends its
+   own chat messages past that seq, oldest first and with the time
+   they were posted, at most maxBackfill at a time
+3. If there were more, it ends with a sync_more frame, answered with
+   another sync from where that page stopped
+
+The peer's link coming up does the same the other way. A link can
+also lose frames without a sync following, when messages sent after
+the loss arrive before the sync is compared. So each message sent
+live carries prev, the seq of the one its server sent before it in
+the room; when prev is past the highest seq received from that
+server, the gap is asked for at once with a sync from there.
+
+Backfilled messages are posted like live ones, so they take the next
+seq here and come late in the room's order, but no message is missing
+for good. Messages deleted, recalled or purged on their server aren't
+backfilled, nor are those older than federatedBackfillWindow, so a
+room newly shared doesn't pull in the peer's whole history. A message
+that arrives twice within federatedSeenTTL is dropped as usual, as
+happens when several nodes of a cluster ask at once. Without an event
+log there's nothing to compare, and nothing is backfilled.
+*/
+
+const (
+	// Most messages backfilled per sync
+	maxBackfill = 500
+
+	// How far back backfill goes
+	federatedBackfillWindow = 24 * time.Hour
+
+	// Bound on comparing or backfilling one peer's rooms
+	reconcileTimeout = time.Minute
+)
+
+// federatedCursor finds the highest seq of server's messages in a room's log
+type federatedCursor struct {
+	server string
+	seq    uint64
+}
+
+// Apply implements eventlog.Projection
+func (c *federatedCursor) Apply(ev storage.Event) {
+	if ev.Type != storage.EventMessage || ev.Data["origin"] != c.server {
+		return
+	}
+	if seq, err := strconv.ParseUint(ev.Data["origin_seq"], 10, 64); err == nil && seq > c.seq {
+		c.seq = seq
+	}
+}
+
+// ownChat collects a room's chat messages posted on this server with a
+// seq past after, since a time
+type ownChat struct {
+	after  uint64
+	since  time.Time
+	events []storage.Event
+}
+
+// Apply implements eventlog.Projection
+func (o *ownChat) Apply(ev storage.Event) {
+	if ev.Type != storage.EventMessage || ev.Seq <= o.after || ev.CreatedAt.Before(o.since) || ev.Data["origin"] != "" {
+		return
+	}
+	// Only chat is federated
+	if ev.Data["sticker"] != "" || ev.Data["audio"] != "" || ev.Data["attachment"] != "" {
+		return
+	}
+	o.events = append(o.events, ev)
+}
+
+// checkFederatedSeq asks frame's server to backfill when its message
+// shows one sent before it never arrived, and remembers its seq
+func (h *LocalHub) checkFederatedSeq(frame federationFrame) {
+	if h.federatedSeqs[frame.Room] == nil {
+		h.federatedSeqs[frame.Room] = make(map[string]uint64)
+	}
+	last := h.federatedSeqs[frame.Room][frame.from]
+	if last != 0 && frame.Prev > last {
+		h.sendFederated(frame.from, federationFrame{Kind: fedSync, Room: frame.Room, Origin: h.federation.Name(), Seq: last})
+	}
+	h.federatedSeqs[frame.Room][frame.from] = max(last, frame.Seq)
+}
+
+// reconcileFederated asks server for what this server missed in each
+// room they share
+// Runs on a goroutine of its own when the link to server comes up
+func (h *LocalHub) reconcileFederated(server string) {
+	if h.events == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	rooms, err := h.store.ListRoomSettings(ctx)
+	if err != nil {
+		reportStorageError("list room settings", err, errreport.Context{})
+		return
+	}
+	for _, settings := range rooms {
+		if !settings.Federation.Allows(server) {
+			continue
+		}
+		cursor := &federatedCursor{server: server}
+		if _, err := eventlog.Replay(ctx, h.store, settings.Room, 0, cursor); err != nil {
+			reportStorageError("replay events", err, errreport.Context{Room: settings.Room})
+			continue
+		}
+		h.sendFederated(server, federationFrame{Kind: fedSync, Room: settings.Room, Origin: h.federation.Name(), Seq: cursor.seq})
+	}
+}
+
+// backfill sends server this server's chat messages in room with a seq
+// past after
+// Runs on a goroutine of its own
+func (h *LocalHub) backfill(server, room string, after uint64) {
+	if h.events == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	removals := eventlog.NewRemovals()
+	own := &ownChat{after: after, since: time.Now().Add(-federatedBackfillWindow)}
+	if _, err := eventlog.Replay(ctx, h.store, room, 0, removals, own); err != nil {
+		reportStorageError("replay events", err, errreport.Context{Room: room})
+		return
+	}
+
+	sent := 0
+	for _, ev := range own.events {
+		if removals.Removed(ev) {
+			continue
+		}
+		if sent == maxBackfill {
+			h.sendFederated(server, federationFrame{Kind: fedSyncMore, Room: room, Origin: h.federation.Name(), Seq: after})
+			break
+		}
+		msg := Message{Type: "chat", ID: ev.MessageID, Content: ev.Content, Username: ev.Username, Seq: ev.Seq, ServerTime: ev.CreatedAt.UnixMilli()}
+		h.sendFederated(server, federationFrame{Kind: fedMessage, Room: room, Origin: h.federation.Name(), Message: &msg})
+		after = ev.Seq
+		sent++
+	}
+	metrics.FederationFrames.WithLabelValues("backfilled").Add(float64(sent))
+}
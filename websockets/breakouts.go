@@ -0,0 +1,422 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/markdown"
+	"chat-app/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+/*
+Breakout Room Overview:
+----------------------
+Holders of a room's breakout capability can open short-lived rooms
+under it, e.g. a war room for an incident (see api/breakouts.go):
+
+	POST /api/rooms/incident-123/breakouts?username=alice  {"name": "warroom", "ttl": "30m"}
+
+The parent room is told, so clients can offer to join:
+
+	{"type": "breakout_opened", "room": "incident-123", "username": "alice",
+	 "breakout": {"room": "incident-123/warroom", "expires_at": "...", ...}}
+
+and anyone who may join the parent (bans and invites of the parent
+apply) joins the breakout at /ws/incident-123/warroom. A breakout
+closes when:
+
+1. Its last participant has been gone for breakoutEmptyGrace, so a
+   reconnect doesn't close it
+2. Its time runs out
+3. Someone holding the capability closes it early
+
+Whoever is still in it is disconnected with close code 4004, and the
+parent gets a chat message from "system" summing it up, with its
+last maxBreakoutTranscript lines:
+
+	{"type": "chat", "room": "incident-123", "username": "system",
+	 "content": "Breakout room **incident-123/warroom** closed after 42m0s ...",
+	 "breakout": {..., "closed": {"reason": "empty", "messages": 37, "participants": ["alice", "bob"]}}}
+
+Like any message it stays in the parent's history. Timers and counts
+are kept by the hub handling the breakout's messages, and checked
+every housekeepingInterval, so a breakout may close up to a minute
+late. In a cluster, members on other nodes are disconnected once their
+node sees the breakout closed, and only the first node to close it
+posts a summary.
+*/
+
+// Close frame sent to members of a closing breakout; also recorded as the close reason
+const (
+	closeReasonBreakout = "breakout_closed"
+	closeCodeBreakout   = 4004
+)
+
+// Summaries of closed breakouts
+const (
+	breakoutSender        = "system" // Who posts them to the parent
+	maxBreakoutTranscript = 20       // Last lines quoted
+	breakoutLineExcerpt   = 140      // Characters kept of each line
+	breakoutEmptyGrace    = time.Minute
+)
+
+// Errors from opening and closing breakouts
+var (
+	ErrInvalidBreakout  = errors.New("invalid breakout")
+	ErrBreakoutOpen     = errors.New("a breakout of that name is already open")
+	ErrTooManyBreakouts = fmt.Errorf("a room can have at most %d breakouts open", storage.MaxBreakouts)
+	ErrNoBreakout       = errors.New("no such open breakout")
+)
+
+// breakout is what the hub keeps of an open breakout it handles
+type breakout struct {
+	info         storage.Breakout
+	joined       bool      // Someone has joined; until then it doesn't close for being empty
+	emptySince   time.Time // When the last participant left, zero while it has any
+	messages     int
+	participants []string
+	transcript   []transcriptLine // The last maxBreakoutTranscript messages
+}
+
+// transcriptLine is one message of a breakout, as quoted in its summary
+type transcriptLine struct {
+	username string
+	text     string
+}
+
+// breakoutChecker is implemented by hubs that host breakout rooms
+type breakoutChecker interface {
+	Breakout(room string) (storage.Breakout, bool)
+}
+
+// Breakout returns the breakout room is, if it is one and still open
+// Safe to call from any goroutine
+func (h *LocalHub) Breakout(room string) (storage.Breakout, bool) {
+	b := h.settings.get(room).Breakout
+	if !b.Open(time.Now()) {
+		return storage.Breakout{}, false
+	}
+	return *b, true
+}
+
+// Breakouts lists parent's breakouts, open and closed, oldest first
+// Safe to call from any goroutine
+func (h *LocalHub) Breakouts(ctx context.Context, parent string) ([]storage.Breakout, error) {
+	all, err := h.store.ListRoomSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	breakouts := []storage.Breakout{}
+	for _, settings := range all {
+		if b := settings.Breakout; b != nil && b.Parent == parent {
+			breakouts = append(breakouts, *b)
+		}
+	}
+	slices.SortFunc(breakouts, func(a, b storage.Breakout) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return breakouts, nil
+}
+
+// OpenBreakout opens parent's breakout called name for ttl (0 for
+// storage.DefaultBreakoutTTL); by names who opened it
+// Safe to call from any goroutine
+func (h *LocalHub) OpenBreakout(ctx context.Context, parent, name, by string, ttl time.Duration) (storage.Breakout, error) {
+	room, err := storage.BreakoutRoom(parent, name)
+	if err != nil {
+		return storage.Breakout{}, fmt.Errorf("%w: %v", ErrInvalidBreakout, err)
+	}
+	if ttl == 0 {
+		ttl = storage.DefaultBreakoutTTL
+	}
+	if ttl < storage.MinBreakoutTTL || ttl > storage.MaxBreakoutTTL {
+		return storage.Breakout{}, fmt.Errorf("%w: ttl must be between %s and %s", ErrInvalidBreakout, storage.MinBreakoutTTL, storage.MaxBreakoutTTL)
+	}
+	if _, archived := h.Archived(parent); archived {
+		return storage.Breakout{}, fmt.Errorf("%w: %s is archived", ErrInvalidBreakout, parent)
+	}
+
+	h.breakoutsMu.Lock()
+	defer h.breakoutsMu.Unlock()
+	now := time.Now().UTC()
+	existing, err := h.Breakouts(ctx, parent)
+	if err != nil {
+		return storage.Breakout{}, err
+	}
+	open := 0
+	for _, b := range existing {
+		if b.Open(now) {
+			open++
+		}
+		if b.Room == room && b.Open(now) {
+			return storage.Breakout{}, ErrBreakoutOpen
+		}
+	}
+	if open >= storage.MaxBreakouts {
+		return storage.Breakout{}, ErrTooManyBreakouts
+	}
+
+	settings, err := h.store.GetRoomSettings(ctx, room)
+	if errors.Is(err, storage.ErrNotFound) {
+		settings = storage.DefaultRoomSettings(room)
+	} else if err != nil {
+		return storage.Breakout{}, err
+	}
+	b := storage.Breakout{Room: room, Parent: parent, CreatedBy: by, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	settings.Breakout = &b
+	settings.UpdatedAt = now
+	if err := h.store.SaveRoomSettings(ctx, settings); err != nil {
+		return storage.Breakout{}, err
+	}
+	h.settings.forget(room)
+
+	// Start its timer here, whether or not anyone joins
+	h.query(func() {
+		h.breakouts[room] = &breakout{info: b}
+	})
+	h.Broadcast(Message{
+		Type:     "breakout_opened",
+		Content:  by + " opened breakout room " + room,
+		RoomName: parent,
+		Username: by,
+		Breakout: &b,
+	})
+	return b, nil
+}
+
+// CloseBreakout closes parent's open breakout called name now; by names
+// who closed it
+// Safe to call from any goroutine
+func (h *LocalHub) CloseBreakout(ctx context.Context, parent, name, by string) error {
+	room, err := storage.BreakoutRoom(parent, name)
+	if err != nil {
+		return ErrNoBreakout
+	}
+	settings, err := h.store.GetRoomSettings(ctx, room)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if !settings.Breakout.Open(time.Now()) {
+		return ErrNoBreakout
+	}
+	info := *settings.Breakout
+	h.query(func() {
+		b, ok := h.breakouts[room]
+		if !ok || !b.info.CreatedAt.Equal(info.CreatedAt) {
+			b = &breakout{info: info} // Nothing seen here; the summary says so
+		}
+		h.closeBreakout(b, storage.BreakoutClosed, by, time.Now())
+	})
+	return nil
+}
+
+// breakoutState returns what the hub keeps of room, starting to keep it
+// if room is an open breakout; nil otherwise
+func (h *LocalHub) breakoutState(room string) *breakout {
+	if b, ok := h.breakouts[room]; ok {
+		return b
+	}
+	if !storage.IsBreakout(room) {
+		return nil
+	}
+	info := h.settings.get(room).Breakout
+	if !info.Open(time.Now()) {
+		return nil
+	}
+	b := &breakout{info: *info}
+	h.breakouts[room] = b
+	return b
+}
+
+// joinBreakout counts a joiner of a breakout as a participant
+func (h *LocalHub) joinBreakout(client *Client) {
+	b := h.breakoutState(client.room)
+	if b == nil {
+		return
+	}
+	b.joined, b.emptySince = true, time.Time{}
+	b.participate(client.username)
+}
+
+// noteBreakout adds a message posted in a breakout to its transcript
+func (h *LocalHub) noteBreakout(msg Message) {
+	b := h.breakoutState(msg.RoomName)
+	if b == nil {
+		return
+	}
+	text := strings.Join(strings.Fields(msg.Content), " ")
+	if text == "" {
+		text = "[" + msg.Type + "]" // A sticker, voice note or attachment
+	}
+	if runes := []rune(text); len(runes) > breakoutLineExcerpt {
+		text = string(runes[:breakoutLineExcerpt]) + "…"
+	}
+	b.messages++
+	b.participate(msg.Username)
+	b.transcript = append(b.transcript, transcriptLine{username: msg.Username, text: text})
+	if len(b.transcript) > maxBreakoutTranscript {
+		b.transcript = b.transcript[len(b.transcript)-maxBreakoutTranscript:]
+	}
+}
+
+// participate adds username to the breakout's participants
+func (b *breakout) participate(username string) {
+	if !slices.Contains(b.participants, username) {
+		b.participants = append(b.participants, username)
+	}
+}
+
+// breakoutEmptied starts the grace period of a breakout nobody is left in
+func (h *LocalHub) breakoutEmptied(room string, now time.Time) {
+	if b, ok := h.breakouts[room]; ok && b.joined && b.emptySince.IsZero() {
+		b.emptySince = now
+	}
+}
+
+// expireBreakouts closes the breakouts that are out of time or were
+// left empty, and lets go of those closed elsewhere
+func (h *LocalHub) expireBreakouts(now time.Time) {
+	for room, b := range h.breakouts {
+		switch {
+		case !now.Before(b.info.ExpiresAt):
+			h.closeBreakout(b, storage.BreakoutExpired, "", now)
+		case !b.emptySince.IsZero() && now.Sub(b.emptySince) >= breakoutEmptyGrace && !h.roomActive(room):
+			h.closeBreakout(b, storage.BreakoutEmpty, "", now)
+		default:
+			if info := h.settings.get(room).Breakout; info == nil || !info.CreatedAt.Equal(b.info.CreatedAt) || info.Closed != nil {
+				delete(h.breakouts, room) // Closed, or closed and opened again, on another node
+				h.evictBreakout(room)
+			}
+		}
+	}
+}
+
+// closeBreakout disconnects a breakout's members here and has its
+// summary saved and posted to the parent
+func (h *LocalHub) closeBreakout(b *breakout, reason, by string, now time.Time) {
+	delete(h.breakouts, b.info.Room)
+	h.evictBreakout(b.info.Room)
+	summary := storage.BreakoutSummary{
+		Reason:       reason,
+		ClosedBy:     by,
+		ClosedAt:     now.UTC(),
+		Messages:     b.messages,
+		Participants: slices.Clone(b.participants),
+	}
+	if summary.Participants == nil {
+		summary.Participants = []string{}
+	}
+	go h.finishBreakout(b.info, summary, slices.Clone(b.transcript))
+}
+
+// evictBreakout disconnects whoever is in a closed breakout here
+func (h *LocalHub) evictBreakout(room string) {
+	for client := range h.rooms[room] {
+		client.closeFrame = websocket.FormatCloseMessage(closeCodeBreakout, closeReasonBreakout)
+		h.disconnect(client, closeReasonBreakout)
+	}
+}
+
+// finishBreakout saves a closed breakout's summary and posts it to the
+// parent, unless another node closed it first
+func (h *LocalHub) finishBreakout(info storage.Breakout, summary storage.BreakoutSummary, transcript []transcriptLine) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	h.breakoutsMu.Lock()
+	settings, err := h.store.GetRoomSettings(ctx, info.Room)
+	if err != nil {
+		h.breakoutsMu.Unlock()
+		reportStorageError("load breakout", err, errreport.Context{Room: info.Room})
+		return
+	}
+	if b := settings.Breakout; b == nil || !b.CreatedAt.Equal(info.CreatedAt) || b.Closed != nil {
+		h.breakoutsMu.Unlock()
+		return
+	}
+	closed := *settings.Breakout
+	closed.Closed = &summary
+	settings.Breakout = &closed
+	settings.UpdatedAt = summary.ClosedAt
+	err = h.store.SaveRoomSettings(ctx, settings)
+	h.breakoutsMu.Unlock()
+	if err != nil {
+		reportStorageError("save breakout", err, errreport.Context{Room: info.Room})
+		return
+	}
+	h.settings.forget(info.Room)
+
+	content := breakoutSummary(closed, transcript)
+	h.Broadcast(Message{
+		Type:      "chat",
+		Content:   content,
+		RoomName:  closed.Parent,
+		Username:  breakoutSender,
+		Formatted: markdown.Format(content),
+		Breakout:  &closed,
+	})
+}
+
+// breakoutSummary is the Markdown posted to a closed breakout's parent
+func breakoutSummary(b storage.Breakout, transcript []transcriptLine) string {
+	s := b.Closed
+	var why string
+	switch s.Reason {
+	case storage.BreakoutEmpty:
+		why = "everyone left"
+	case storage.BreakoutExpired:
+		why = "its time ran out"
+	default:
+		why = "closed by " + s.ClosedBy
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "Breakout room **%s** closed after %s (%s): ", b.Room, s.ClosedAt.Sub(b.CreatedAt).Round(time.Second), why)
+	switch {
+	case s.Messages == 0:
+		out.WriteString("no messages were posted.")
+	case s.Messages == 1:
+		fmt.Fprintf(&out, "1 message from %s.", strings.Join(s.Participants, ", "))
+	default:
+		fmt.Fprintf(&out, "%d messages from %s.", s.Messages, strings.Join(s.Participants, ", "))
+	}
+	if len(transcript) < s.Messages && len(transcript) > 0 {
+		fmt.Fprintf(&out, " The last %d:", len(transcript))
+	}
+	for _, line := range transcript {
+		fmt.Fprintf(&out, "\n> **%s:** %s", line.username, line.text)
+	}
+	return out.String()
+}
+
+// admitBreakout checks a connection to a breakout room, answering and
+// returning false when it has closed or the user may not join its parent
+func admitBreakout(c *gin.Context, h Hub, options handlerOptions, room, username string) bool {
+	breakouts, ok := h.(breakoutChecker)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "breakout rooms aren't supported"})
+		return false
+	}
+	info, open := breakouts.Breakout(room)
+	if !open {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such breakout room, or it has closed"})
+		return false
+	}
+	if bans, ok := h.(banChecker); ok {
+		if _, banned := bans.Banned(info.Parent, username); banned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you are banned from " + info.Parent})
+			return false
+		}
+	}
+	if invites, ok := h.(inviteChecker); ok && !ownsInvites(c.Request.Context(), options.permissions, info.Parent, username) &&
+		!invites.Invited(info.Parent, username, "") {
+		c.JSON(http.StatusForbidden, gin.H{"error": info.Parent + " is invite-only", "code": errCodeNotInvited})
+		return false
+	}
+	return true
+}
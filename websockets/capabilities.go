@@ -0,0 +1,109 @@
+package websockets
+
+import (
+	"errors"
+	"slices"
+)
+
+/*
+Capabilities Overview:
+---------------------
+The server's hello frame lists the optional features it can send
+frames for. A client may answer with a hello of its own listing the
+ones it handles; the server acknowledges with the features both
+sides share:
+
+	<- {"type": "hello", "protocol": 2, "features": ["audio", "highlights", "stickers", ...], ...}
+	-> {"type": "hello", "features": ["stickers", "threads", "msgpack"]}
+	<- {"type": "hello_ack", "features": ["stickers"]}
+
+From then on the connection is only sent the frame types of features
+it accepted, plus everything that isn't optional (chat, presence,
+acks, errors, moderation, ...). Features the server doesn't have,
+like threads above, are left out of the ack. A client that never
+says hello is sent everything, as before, and a later hello replaces
+the earlier one.
+*/
+
+// Limits on a client's hello, so it can't be used to bloat a connection
+const (
+	maxClientFeatures = 64
+	maxFeatureLen     = 64
+)
+
+// features maps each optional feature to the frame types that are
+// only sent to connections that accept it
+var features = map[string][]string{
+	"announcements":  {"announcement"},
+	"attachments":    {"attachment"},
+	"audio":          {"audio"},
+	"direct":         {"dm", "dm_status"},
+	"highlights":     {"highlight"},
+	"keyword_alerts": {"keyword_alert"},
+	"mod_queue":      {"mod_queue"},
+	"onboarding":     {"onboarding"},
+	"rtt":            {"rtt"},
+	"stickers":       {"sticker", "sticker_packs"},
+	"sync":           {"sync"},
+	"transfers":      {"transfer_offer", "transfer_accept", "transfer_decline", "transfer_cancel", "transfer_sent", "transfer_signal"},
+}
+
+// featureOf maps each optional frame type to its feature
+var featureOf = func() map[string]string {
+	m := make(map[string]string)
+	for feature, types := range features {
+		for _, t := range types {
+			m[t] = feature
+		}
+	}
+	return m
+}()
+
+// SupportedFeatures lists the optional features this server offers, sorted
+func SupportedFeatures() []string {
+	list := make([]string, 0, len(features))
+	for feature := range features {
+		list = append(list, feature)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// validateFeatures checks a client's hello is within bounds
+func validateFeatures(list []string) error {
+	if len(list) > maxClientFeatures {
+		return errors.New("too many features")
+	}
+	for _, feature := range list {
+		if len(feature) > maxFeatureLen {
+			return errors.New("feature name too long")
+		}
+	}
+	return nil
+}
+
+// accepts reports whether client is sent frames of msgType
+// Must be called on the hub goroutine
+func (c *Client) accepts(msgType string) bool {
+	feature, optional := featureOf[msgType]
+	return !optional || c.features == nil || c.features[feature]
+}
+
+// handleHello records the features client accepts and acknowledges the
+// ones this server offers
+func (h *LocalHub) handleHello(client *Client, offered []string) {
+	accepted := make(map[string]bool)
+	for _, feature := range offered {
+		if _, ok := features[feature]; ok {
+			accepted[feature] = true
+		}
+	}
+	client.features = accepted
+
+	list := make([]string, 0, len(accepted))
+	for feature := range accepted {
+		list = append(list, feature)
+	}
+	slices.Sort(list)
+	h.sendTo(client, Message{Type: "hello_ack", RoomName: client.room, Username: client.username, Features: list})
+}
@@ -0,0 +1,120 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/eventlog"
+	"chat-app/storage"
+)
+
+/*
+Ban Cascade Overview:
+--------------------
+A ban can take the banned user's recent messages with it
+(Resolution.Cascade), as far back as Resolution.Lookback:
+
+	delete   the messages are removed, as a purge removes them
+	hide     they stay in the log but are hidden from everyone
+
+The messages are found in the event log before the ban, off the hub
+goroutine. The hub then saves the ban and records it as one
+moderation event carrying the cascade (data.cascade, with since,
+until and count, see eventlog.ParsePurge and ParseHidden), so the
+audit log and history see the ban and what it took as one
+operation. The room gets one messages_purged frame whose code is
+deleted or hidden; on hidden, clients hide the messages it selects
+rather than drop them.
+
+Once the ban is in place, stored copies of deleted messages are
+removed, hidden ones are marked so history leaves them out, and their
+review queue entries are resolved.
+*/
+
+// Ban cascades
+const (
+	CascadeDelete = eventlog.CascadeDelete
+	CascadeHide   = eventlog.CascadeHide
+)
+
+// banCascade is the messages a ban takes with it
+type banCascade struct {
+	room    string
+	mode    string
+	purge   storage.Purge
+	matched []eventlog.HistoryEntry
+}
+
+// prepareCascade finds the messages a ban on queue entry id takes
+func (h *LocalHub) prepareCascade(ctx context.Context, id string, res Resolution) (*banCascade, error) {
+	item, err := h.store.GetReviewItem(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			err = ErrNotQueued
+		}
+		return nil, err
+	}
+	now := time.Now()
+	p := storage.Purge{Username: item.Username, Until: now}
+	if res.Lookback > 0 {
+		p.Since = now.Add(-res.Lookback)
+	}
+	matched, err := eventlog.Matching(ctx, h.store, item.Room, p, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &banCascade{room: item.Room, mode: res.Cascade, purge: p, matched: matched}, nil
+}
+
+// describe adds the cascade to the data of the ban's moderation event
+func (bc *banCascade) describe(data map[string]string) {
+	for k, v := range eventlog.PurgeData(bc.purge) {
+		data[k] = v
+	}
+	data["cascade"] = bc.mode
+	data["count"] = strconv.Itoa(len(bc.matched))
+}
+
+// announce tells the room what the ban took; run on the hub goroutine
+func (h *LocalHub) announce(bc *banCascade, by string) {
+	if len(bc.matched) == 0 {
+		return
+	}
+	code := ModerationDeleted
+	if bc.mode == CascadeHide {
+		code = ModerationHidden
+	}
+	h.handleBroadcast(purgedMessage(bc.room, by, bc.purge, len(bc.matched), code))
+}
+
+// finishCascade removes the stored copies of the messages a ban
+// deleted, or hides those it hid, and resolves their review queue entries
+// Called off the hub goroutine once the ban is in place
+func (h *LocalHub) finishCascade(ctx context.Context, bc *banCascade) {
+	switch bc.mode {
+	case CascadeDelete:
+		if _, err := h.store.PurgeMessages(ctx, bc.room, bc.purge); err != nil {
+			reportStorageError("purge messages", err, errreport.Context{Room: bc.room, Username: bc.purge.Username})
+		}
+	case CascadeHide:
+		if _, err := h.store.HideMessages(ctx, bc.room, bc.purge); err != nil {
+			reportStorageError("hide messages", err, errreport.Context{Room: bc.room, Username: bc.purge.Username})
+		}
+	}
+	ids := make(map[string]bool, len(bc.matched))
+	for _, m := range bc.matched {
+		ids[m.ID] = true
+	}
+	resolved := h.purgeReviews(ctx, bc.room, ids)
+	if len(resolved) == 0 {
+		return
+	}
+	h.query(func() {
+		for _, item := range resolved {
+			h.notifyModerators(ModQueueResolved, DecisionBan, item)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package websockets
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"chat-app/chaos"
+)
+
+/*
+Fault Injection Overview:
+------------------------
+With WithChaos each connection's Conn is wrapped so the injector (see
+the chaos package) can delay and drop its frames and cut it short.
+Only text frames are dropped: pings, pongs and close frames get
+through, so drops show up as missing messages rather than timeouts.
+A cut closes the socket without a close frame; the client sees an
+abnormal closure and the session ends with reason error, as it
+would on a real network failure.
+*/
+
+// WithChaos injects faults into every connection; for testing only
+func WithChaos(inj *chaos.Injector) Option {
+	return func(o *handlerOptions) {
+		o.chaos = inj
+	}
+}
+
+// faultyConn is a Conn with faults injected
+type faultyConn struct {
+	Conn
+	chaos *chaos.Injector
+	cut   *time.Timer // Closes the connection at the end of its lifetime; nil if it has none
+}
+
+// injectFaults wraps conn with inj's faults, or returns it as is when inj is nil
+func injectFaults(conn Conn, inj *chaos.Injector, connID string) Conn {
+	if inj == nil {
+		return conn
+	}
+	fc := &faultyConn{Conn: conn, chaos: inj}
+	if d := inj.Lifetime(); d > 0 {
+		fc.cut = time.AfterFunc(d, func() {
+			log.Printf("Chaos: cutting conn=%s after %v", connID, d.Round(time.Millisecond))
+			conn.Close()
+		})
+	}
+	return fc
+}
+
+// ReadMessage skips the frames the injector drops
+func (c *faultyConn) ReadMessage() (int, []byte, error) {
+	for {
+		messageType, p, err := c.Conn.ReadMessage()
+		if err != nil || !c.chaos.Drop() {
+			return messageType, p, err
+		}
+	}
+}
+
+// NextWriter delays each frame, and hands dropped ones a writer to nowhere
+func (c *faultyConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	c.chaos.Delay()
+	if c.chaos.Drop() {
+		return discard{}, nil
+	}
+	return c.Conn.NextWriter(messageType)
+}
+
+// Close stops the pending cut along with the connection
+func (c *faultyConn) Close() error {
+	if c.cut != nil {
+		c.cut.Stop()
+	}
+	return c.Conn.Close()
+}
+
+// discard swallows a dropped frame
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+func (discard) Close() error                { return nil }
@@ -1,10 +1,31 @@
 package websockets
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"chat-app/errreport"
+	"chat-app/jwtauth"
+	"chat-app/markdown"
+	"chat-app/permission"
+	"chat-app/quota"
+	"chat-app/ratelimit"
+	"chat-app/sanitize"
+	"chat-app/storage"
+	"chat-app/tenant"
+	"chat-app/tracing"
+	"chat-app/uploads"
+
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
@@ -40,15 +61,155 @@ const (
 
 	// Maximum message size allowed from peer
 	maxMessageSize = 512
+
+	// Capacity of the control lane; it only carries small, infrequent frames
+	controlBufferSize = 64
 )
 
+// Disconnect reasons, recorded in metrics when a client leaves the hub
+const (
+	closeReasonClient   = "client_close"    // Peer sent a close frame
+	closeReasonAbnormal = "abnormal_close"  // TCP connection dropped without a close frame
+	closeReasonTimeout  = "timeout"         // Missed pong or read deadline
+	closeReasonKicked   = "kicked"          // Removed by the server or a moderator
+	closeReasonOverflow = "buffer_overflow" // Couldn't keep up with outbound messages
+	closeReasonRedirect = "redirected"      // Told to reconnect elsewhere and didn't leave
+	closeReasonError    = "error"           // Any other read failure
+)
+
+// Conn is the subset of *websocket.Conn a Client needs
+// Tests can drive the pumps with a fake, and other transports can
+// reuse Client by adapting to this interface
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	NextWriter(messageType int) (io.WriteCloser, error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+// *websocket.Conn is the production Conn
+var _ Conn = (*websocket.Conn)(nil)
+
 // Client represents a connected websocket user
 type Client struct {
-	hub      *Hub            // Reference to central hub for broadcasting
-	conn     *websocket.Conn // Underlying WebSocket connection
-	send     chan []byte     // Buffered channel for outbound messages
-	room     string         // Current room name
-	username string         // User's display name
+	hub      Hub         // Reference to central hub for broadcasting
+	conn     Conn        // Underlying WebSocket connection
+	send     chan []byte // Buffered channel for outbound chat messages
+	control  chan []byte // Priority lane for presence, acks and errors
+	room     string      // Current room name
+	username string      // User's display name
+	id       string      // Unique connection ID for correlating reports
+
+	connectedAt time.Time              // When the connection was upgraded
+	meta        connMeta               // Where and how it connected, see metadata.go
+	links       *LinkFilter            // Room link policies; nil when not filtering
+	emoji       *EmojiExpander         // Shortcode expansion; nil when disabled
+	clean       sanitize.Sanitizer     // How message content is cleaned
+	uploads     *uploads.Service       // Checks posted attachments; nil when disabled
+	quotas      *quota.Tracker         // Daily quotas, see quotas.go; nil when unlimited
+	tenant      *tenant.Lease          // The tenant's limits, see tenants.go; nil without tenants
+	permissions *permission.Authorizer // Room capabilities, see permissions.go; nil allows everything
+	avatar      string                 // The user's avatar ID; owned by the hub goroutine
+	closeReason string                 // Why the connection ended, set before unregistering
+	redirected  bool                   // Sent a reconnect frame; owned by the hub goroutine
+	lastBeat    time.Time              // Last heartbeat answered, zero if none; owned by the hub goroutine
+	away        bool                   // Missed heartbeats, see heartbeat.go; owned by the hub goroutine
+	lastActive  time.Time              // Last frame that wasn't a keepalive, see idle.go; owned by the hub goroutine
+	idle        bool                   // Shown away for doing nothing, see idle.go; owned by the hub goroutine
+	rtt         atomic.Int64           // Last round-trip time in nanoseconds, 0 until measured, see rtt.go
+	reportRTT   bool                   // Send the client its round-trip times
+	features    map[string]bool        // Optional features accepted, nil for all, see capabilities.go; owned by the hub goroutine
+	closeFrame  []byte                 // Close frame payload sent when the hub closes send; set before closing it
+	replaced    []string               // IDs of the connections this one took over, see logins.go; owned by the hub goroutine
+	resume      string                 // Resume token the client connected with, see lifetime.go
+	identity    *jwtauth.Identity      // Who its access token proved it is, see tokens.go; nil without token auth
+	resumeToken string                 // Token issued for its own successor, see lifetime.go; owned by the hub goroutine
+	syncs       *ratelimit.Bucket      // Paces sync frames, see sync.go; owned by the read pump
+	received    traffic                // Frames read, for the access log
+	sent        traffic                // Frames written, for the access log
+}
+
+// NewClient creates a client for an established connection
+// Call Start once it has been registered with the hub
+func NewClient(hub Hub, conn Conn, room, username string) *Client {
+	return newClient(hub, conn, room, username, newConnID())
+}
+
+func newClient(hub Hub, conn Conn, room, username, id string) *Client {
+	return &Client{
+		hub:         hub,
+		conn:        conn,
+		send:        make(chan []byte, 256), // Buffer size affects memory usage
+		control:     make(chan []byte, controlBufferSize),
+		room:        room,
+		username:    username,
+		id:          id,
+		connectedAt: time.Now(),
+		syncs:       ratelimit.NewBucket(syncRate, syncBurst, time.Now()),
+	}
+}
+
+// Start launches the read and write pumps
+func (c *Client) Start() {
+	go c.writePump() // Handles sending messages to the client
+	go c.readPump()  // Handles receiving messages from the client
+}
+
+// Room returns the room this client joined
+func (c *Client) Room() string {
+	return c.room
+}
+
+// Username returns the client's display name
+func (c *Client) Username() string {
+	return c.username
+}
+
+// Identity returns who the client's access token says it is, or nil
+// when connections aren't token authenticated (see tokens.go)
+func (c *Client) Identity() *jwtauth.Identity {
+	return c.identity
+}
+
+// ID returns the unique connection ID
+func (c *Client) ID() string {
+	return c.id
+}
+
+// info describes the connection for admin views
+func (c *Client) info() ConnectionInfo {
+	return ConnectionInfo{
+		ID:          c.id,
+		Room:        c.room,
+		Username:    c.username,
+		ConnectedAt: c.connectedAt,
+		UserAgent:   c.meta.userAgent,
+		IP:          c.meta.ip,
+		Subprotocol: c.meta.subprotocol,
+		Device:      c.meta.device,
+		Country:     c.meta.location.Country,
+		Region:      c.meta.location.Region,
+		Away:        c.away,
+		RTTMs:       rttMillis(time.Duration(c.rtt.Load())),
+		Tenant:      c.tenant.Tenant(),
+		Verified:    c.identity != nil,
+	}
+}
+
+// logf logs a line tagged with the connection, so one session can be
+// followed across logs, error reports and the event log
+func (c *Client) logf(format string, args ...any) {
+	log.Printf("conn=%s room=%s user=%s: %s", c.id, c.room, c.username, fmt.Sprintf(format, args...))
+}
+
+// reportContext describes this connection for error reports
+func (c *Client) reportContext() errreport.Context {
+	return errreport.Context{Room: c.room, Username: c.username, ConnID: c.id}
 }
 
 // readPump handles incoming messages from the WebSocket connection
@@ -58,17 +219,25 @@ func (c *Client) readPump() {
 	// This ensures resources are freed when connection ends
 	defer func() {
 		// Notify hub that client is disconnecting
-		c.hub.unregister <- c
+		c.hub.Unregister(c)
+		// Give the connection back to the tenant's limit
+		c.tenant.Release()
 		// Close the physical connection
 		c.conn.Close()
 	}()
+	// Report panics without taking down the whole server
+	defer errreport.Recover(c.reportContext())
 
-	// Configure connection constraints
-	c.conn.SetReadLimit(maxMessageSize)
+	// Configure connection constraints; WebRTC signals and synced state
+	// are the only frames allowed past maxMessageSize (see transfer.go
+	// and sync.go)
+	c.conn.SetReadLimit(maxSignalSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
+	c.conn.SetPongHandler(func(appData string) error {
 		// Reset deadline when pong is received
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		now := time.Now()
+		c.conn.SetReadDeadline(now.Add(pongWait))
+		c.observePong(appData, now)
 		return nil
 	})
 
@@ -78,25 +247,306 @@ func (c *Client) readPump() {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			// Check if it's an expected closure
-			if websocket.IsUnexpectedCloseError(err, 
-				websocket.CloseGoingAway, 
+			if websocket.IsUnexpectedCloseError(err,
+				websocket.CloseGoingAway,
 				websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				c.logf("read error: %v", err)
 			}
+			c.closeReason = classifyClose(err)
 			break // Exit loop on any error
 		}
+		c.received.add(len(message))
+
+		// Start a trace for this message; hub spans become its children
+		ctx, span := tracing.Tracer().Start(context.Background(), "ws.read",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("chat.room", c.room),
+				attribute.String("chat.username", c.username),
+				attribute.String("chat.conn_id", c.id),
+				attribute.Int("chat.message_size", len(message)),
+			))
 
-		// Create message with metadata
-		msg := Message{
-			Type:     "chat",
-			Content:  string(message),  // Change: Convert bytes to string
-			RoomName: c.room,
-			Username: c.username,
+		frame, err := parseFrame(message)
+		if len(message) > maxMessageSize && (err != nil || (frame.Type != "transfer_signal" && frame.Type != "sync")) {
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(writeWait))
+			c.closeReason = closeReasonError
+			span.End()
+			break
 		}
+		if err != nil {
+			c.hub.Broadcast(errorMessage(c, errCodeBadFrame, err.Error()))
+			span.End()
+			continue
+		}
+
+		switch frame.Type {
+		case "chat", "reply":
+			// A reply names the message it quotes; the hub fills in the quote
+			if frame.Type == "reply" && frame.ReplyTo == "" {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "reply frames need the reply_to message id"))
+				span.End()
+				continue
+			}
+
+			// Expand shortcodes, then clean the text, so nothing is stored or
+			// sent unsafe (custom emoji included) and invisible characters
+			// can't split a link past the link policy
+			if c.emoji != nil {
+				frame.Content = c.emoji.Expand(c.room, frame.Content)
+			}
+			cleaned := c.clean.Clean(frame.Content)
+			if strings.TrimSpace(cleaned) == "" && strings.TrimSpace(frame.Content) != "" {
+				c.hub.Broadcast(errorMessage(c, errCodeEmptyMessage, "message has no visible content"))
+				span.End()
+				continue
+			}
+			frame.Content = cleaned
 
-		// Forward message to hub for broadcasting
-		c.hub.broadcast <- msg
+			// Apply the room's link policy before anyone sees the message
+			// The ID is chosen here so a review entry can name the message
+			id := newID()
+			if c.links != nil {
+				content, host, ok := c.links.check(c, id, frame.Content)
+				if !ok {
+					c.hub.Broadcast(errorMessage(c, errCodeLinkBlocked, "links to "+host+" are not allowed in this room"))
+					span.End()
+					continue
+				}
+				frame.Content = content
+			}
+			if c.roomArchived() || !c.authorizeChat(ctx, frame.Content) || !c.allowTenant(ctx) || !c.takeQuota(ctx, len(frame.Content)) {
+				span.End()
+				continue
+			}
+
+			// Create message with metadata
+			// Markdown is parsed here, off the hub goroutine, after any defanging
+			msg := Message{
+				Type:           "chat",
+				ID:             id,
+				Content:        frame.Content,
+				Formatted:      markdown.Format(frame.Content),
+				RoomName:       c.room,
+				Username:       c.username,
+				IdempotencyKey: frame.IdempotencyKey,
+				QoS:            frame.QoS,
+				ctx:            ctx,
+				sender:         c,
+			}
+			if msg.QoS == QoSFireAndForget {
+				msg.QoS = "" // The default needs no tracking or field on the wire
+			}
+			if frame.Type == "reply" {
+				msg.Quote = &storage.Quote{ID: frame.ReplyTo}
+			}
+
+			// Forward message to hub for broadcasting
+			c.hub.Broadcast(msg)
+		case "sticker":
+			// The hub looks the sticker up and fills in its alt text and URL
+			if frame.Sticker == nil || frame.Sticker.Pack == "" || frame.Sticker.ID == "" {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "sticker frames need a sticker pack and id"))
+				break
+			}
+			if c.roomArchived() || !c.authorize(ctx, storage.CapPost) || !c.allowTenant(ctx) || !c.takeQuota(ctx, 0) {
+				break
+			}
+			msg := Message{
+				Type:           "sticker",
+				ID:             newID(),
+				Sticker:        &Sticker{Pack: frame.Sticker.Pack, ID: frame.Sticker.ID},
+				RoomName:       c.room,
+				Username:       c.username,
+				IdempotencyKey: frame.IdempotencyKey,
+				QoS:            frame.QoS,
+				ctx:            ctx,
+				sender:         c,
+			}
+			if msg.QoS == QoSFireAndForget {
+				msg.QoS = ""
+			}
+			c.hub.Broadcast(msg)
+		case "audio":
+			// The hub claims the uploaded clip and fills in its details
+			if frame.Audio == nil || frame.Audio.ID == "" {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "audio frames need the id of an uploaded voice note"))
+				break
+			}
+			if c.roomArchived() || !c.authorize(ctx, storage.CapPost) || !c.authorize(ctx, storage.CapUpload) || !c.allowTenant(ctx) || !c.takeQuota(ctx, 0) { // The clip's bytes counted when it was uploaded
+				break
+			}
+			msg := Message{
+				Type:           "audio",
+				ID:             newID(),
+				Audio:          &Audio{ID: frame.Audio.ID},
+				RoomName:       c.room,
+				Username:       c.username,
+				IdempotencyKey: frame.IdempotencyKey,
+				QoS:            frame.QoS,
+				ctx:            ctx,
+				sender:         c,
+			}
+			if msg.QoS == QoSFireAndForget {
+				msg.QoS = ""
+			}
+			c.hub.Broadcast(msg)
+		case "attachment":
+			// Checked here, off the hub, since it asks S3 whether the file arrived
+			c.postAttachment(ctx, frame)
+		case "transfer_offer":
+			// The hub checks the offer and finds the recipient's connections
+			if frame.Transfer == nil {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "transfer offers need a transfer with to, name and size"))
+				break
+			}
+			offer := &Transfer{To: frame.Transfer.To, Name: c.clean.Clean(frame.Transfer.Name), Size: frame.Transfer.Size, MIME: frame.Transfer.MIME}
+			c.hub.Broadcast(Message{Type: frame.Type, Transfer: offer, RoomName: c.room, Username: c.username, ctx: ctx, sender: c})
+		case "transfer_accept", "transfer_decline", "transfer_cancel", "transfer_signal":
+			if frame.ID == "" {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, frame.Type+" frames need the transfer id"))
+				break
+			}
+			if frame.Type == "transfer_signal" && !validSignal(frame.Signal) {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "signal must be a JSON object"))
+				break
+			}
+			c.hub.Broadcast(Message{Type: frame.Type, ID: frame.ID, Signal: frame.Signal, RoomName: c.room, Username: c.username, ctx: ctx, sender: c})
+		case "pin", "unpin":
+			// The owner checks the message is the room's, see permissions.go
+			if frame.ID == "" {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, frame.Type+" frames need the message id"))
+				break
+			}
+			if c.roomArchived() || !c.authorize(ctx, storage.CapPin) {
+				break
+			}
+			c.hub.Broadcast(Message{Type: frame.Type, ID: frame.ID, RoomName: c.room, Username: c.username, ctx: ctx, sender: c})
+		case "recall":
+			// The owner checks the message is the sender's, see recall.go
+			if frame.ID == "" {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "recall frames need the message id"))
+				break
+			}
+			if c.roomArchived() {
+				break
+			}
+			c.hub.Broadcast(Message{Type: "recall", ID: frame.ID, RoomName: c.room, Username: c.username, ctx: ctx, sender: c})
+		case "dm":
+			// The hub delivers it or passes it to the recipient's server, see direct.go
+			if frame.To == "" {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "dm frames need to, a username or user@server"))
+				break
+			}
+			content := c.clean.Clean(frame.Content)
+			if strings.TrimSpace(content) == "" {
+				c.hub.Broadcast(errorMessage(c, errCodeEmptyMessage, "message has no visible content"))
+				break
+			}
+			if !c.allowTenant(ctx) || !c.takeQuota(ctx, len(content)) {
+				break
+			}
+			c.hub.Broadcast(Message{
+				Type:      "dm",
+				ID:        newID(),
+				Content:   content,
+				Formatted: markdown.Format(content),
+				RoomName:  c.room,
+				Username:  c.username,
+				To:        frame.To,
+				ctx:       ctx,
+				sender:    c,
+			})
+		case "sync":
+			// The hub saves it and tells the user's other connections, see sync.go
+			if frame.Sync == nil {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "sync frames need a sync with a key"))
+				break
+			}
+			if err := (storage.SyncEntry{Key: frame.Sync.Key, Value: frame.Sync.Value}).Validate(); err != nil {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, err.Error()))
+				break
+			}
+			if wait, ok := c.syncs.Reserve(time.Now(), 0); !ok {
+				msg := errorMessage(c, errCodeSyncRateLimited, "too many sync frames, slow down")
+				msg.RetryAfterMs = wait.Milliseconds()
+				c.hub.Broadcast(msg)
+				break
+			}
+			c.hub.Broadcast(Message{Type: "sync", Sync: &Sync{Key: frame.Sync.Key, Value: frame.Sync.Value}, RoomName: c.room, Username: c.username, sender: c})
+		case "set_preferences":
+			// The hub merges and saves them, see notifications.go
+			if frame.Preferences == nil {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "set_preferences frames need preferences"))
+				break
+			}
+			if err := frame.Preferences.Validate(); err != nil {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, err.Error()))
+				break
+			}
+			c.hub.Broadcast(Message{Type: "set_preferences", Preferences: frame.Preferences, RoomName: c.room, Username: c.username, sender: c})
+		case "mute":
+			// Saved like any other preference change
+			var mute storage.Mute
+			if frame.For != "" {
+				d, err := time.ParseDuration(frame.For)
+				if err != nil || d <= 0 {
+					c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "for must be a positive duration, like 8h"))
+					break
+				}
+				until := time.Now().Add(d)
+				mute.Until = &until
+			}
+			change := storage.NotificationPrefs{Muted: map[string]storage.Mute{c.room: mute}}
+			c.hub.Broadcast(Message{Type: "set_preferences", Preferences: &change, RoomName: c.room, Username: c.username, sender: c})
+		case "unmute":
+			c.hub.Broadcast(Message{Type: "unmute", RoomName: c.room, Username: c.username, sender: c})
+		case "invite_only", "invite", "uninvite", "invite_token", "revoke_invite":
+			// Owners change who may join, see invites.go
+			c.manageInvites(ctx, frame)
+		case "time":
+			// The client is estimating its clock offset, see clock.go
+			c.answerTime(frame, time.Now())
+		case "hello":
+			// The hub records what the client handles, see capabilities.go
+			if err := validateFeatures(frame.Features); err != nil {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, err.Error()))
+				break
+			}
+			c.hub.Broadcast(Message{Type: "hello", Features: frame.Features, RoomName: c.room, Username: c.username, sender: c})
+		case "heartbeat":
+			// The client is still running, see heartbeat.go
+			c.hub.Broadcast(Message{Type: "heartbeat", RoomName: c.room, Username: c.username, sender: c})
+		case "ack":
+			// Recipient confirms a reliable message
+			c.hub.Broadcast(Message{Type: "ack", ID: frame.ID, RoomName: c.room, Username: c.username, sender: c})
+		case "report":
+			// Flag a message in the room for moderators; content is the reason
+			c.hub.Broadcast(Message{Type: "report", ID: frame.ID, Content: c.clean.Clean(frame.Content), RoomName: c.room, Username: c.username, sender: c})
+		default:
+			c.hub.Broadcast(errorMessage(c, errCodeUnknownType, "unsupported message type: "+frame.Type))
+		}
+		span.End()
+	}
+}
+
+// classifyClose maps a read error to a disconnect reason
+func classifyClose(err error) string {
+	if websocket.IsCloseError(err,
+		websocket.CloseNormalClosure,
+		websocket.CloseGoingAway,
+		websocket.CloseNoStatusReceived) {
+		return closeReasonClient
+	}
+	if websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+		return closeReasonAbnormal
 	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return closeReasonTimeout
+	}
+	return closeReasonError
 }
 
 // writePump handles sending messages to the WebSocket connection
@@ -109,36 +559,80 @@ func (c *Client) writePump() {
 		ticker.Stop()
 		c.conn.Close()
 	}()
+	defer errreport.Recover(c.reportContext())
+
+	// Measure the round-trip time straight away rather than a period in
+	if !c.writePing() {
+		return
+	}
 
 	for {
+		// Control traffic and pings go first so a flooded room
+		// can't starve the frames that keep the connection alive
+		select {
+		case <-ticker.C:
+			if !c.writePing() {
+				return
+			}
+			continue
+		case message := <-c.control:
+			if !c.writeFrame(message) {
+				return
+			}
+			continue
+		default:
+		}
+
 		select {
 		case message, ok := <-c.send:
-			// Set write deadline for each message
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// Channel closed by hub
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeFrame)
 				return
 			}
-
-			// Get the next writer for the connection
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if !c.writeFrame(message) {
 				return
 			}
 
-			// Write the message
-			w.Write(message)
-
-			// Close the writer
-			w.Close()
+		case message := <-c.control:
+			if !c.writeFrame(message) {
+				return
+			}
 
 		case <-ticker.C:
-			// Send periodic ping
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if !c.writePing() {
 				return
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// writeFrame sends one text frame, reporting whether the connection is still usable
+func (c *Client) writeFrame(message []byte) bool {
+	// Set write deadline for each message
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+	// Get the next writer for the connection
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return false
+	}
+
+	// Write the message
+	w.Write(message)
+
+	// Close the writer
+	if w.Close() != nil {
+		return false
+	}
+	c.sent.add(len(message))
+	return true
+}
+
+// writePing sends a periodic ping, stamped with the time for rtt.go
+func (c *Client) writePing() bool {
+	now := time.Now()
+	c.conn.SetWriteDeadline(now.Add(writeWait))
+	return c.conn.WriteMessage(websocket.PingMessage, pingPayload(now)) == nil
+}
@@ -0,0 +1,45 @@
+package websockets
+
+import (
+	"time"
+)
+
+/*
+Clock Sync Overview:
+-------------------
+Clients render message times on their own clocks, which can be off by
+minutes. The server's clock is the one every member shares, so frames
+carry it as server_time, in Unix milliseconds:
+
+	hello, heartbeat   the server's clock when the frame was sent
+	chat, sticker,     when the hub posted the message; the same
+	audio, attachment  instant as created_at in the room's history
+
+A client can estimate its offset from the server's clock the way NTP
+does. It sends its own clock and the server echoes it back with the
+server's:
+
+	client: {"type": "time", "client_time": 1718000000000}
+	server: {"type": "time", "client_time": 1718000000000, "server_time": 1718000012345}
+
+With t0 = client_time, t1 = server_time and t2 the client's clock on
+receipt, the server's clock ran t1 when the client's ran about
+(t0 + t2) / 2, so offset = t1 - (t0 + t2) / 2, give or take half of
+the round trip t2 - t0. Keeping the sample with the shortest round
+trip gives the tightest bound. The server_time on hello and
+heartbeat frames is good for a first guess, off by the one-way latency.
+
+Then a message posted at server_time was posted at server_time -
+offset on the client's clock.
+*/
+
+// answerTime echoes a client's clock with the server's, stamped on receipt
+func (c *Client) answerTime(frame inboundFrame, received time.Time) {
+	c.hub.Broadcast(Message{
+		Type:       "time",
+		RoomName:   c.room,
+		ClientTime: frame.ClientTime,
+		ServerTime: received.UnixMilli(),
+		to:         c,
+	})
+}
@@ -0,0 +1,211 @@
+package websockets
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/markdown"
+	"chat-app/metrics"
+	"chat-app/storage"
+)
+
+/*
+Direct Message Overview:
+-----------------------
+Any connection can send a user a direct message, whatever room either
+of them is in. A user on this server is addressed by username, one on
+a federated server as user@server:
+
+	{"type": "dm", "to": "bob@b.example", "content": "hi"}
+
+The recipient gets it on every connection they have open, with the
+sender qualified by their server when it isn't this one:
+
+	{"type": "dm", "id": "...", "username": "alice@a.example", "to": "bob",
+	 "origin": "a.example", "content": "hi", "server_time": 1718000000000}
+
+The sender is told what became of it, first with the message's ID:
+
+	{"type": "dm_status", "id": "...", "to": "bob@b.example", "status": "sent"}
+
+then, for messages to another server, again when that server answers.
+status is one of:
+
+	sent       Passed to the recipient's server; another status follows
+	delivered  Sent to at least one of the recipient's connections
+	queued     The recipient isn't connected; it waits in their queue
+	failed     The recipient's queue is full, or it couldn't be saved
+
+Queued messages (see storage.DirectMessage) are delivered when the
+recipient next joins any room, and the sender is sent delivered then,
+over the federation link if they're on another server. Between
+servers direct messages travel as frames of their own (see
+federation.go), sent and retried like room traffic; a server only
+accepts one for its own users. In a cluster, a message reaches the
+recipient's connections on the node that handles it and in rooms that
+node owns; if there are none it is queued.
+
+Direct messages are an optional feature, "direct" (see
+capabilities.go): they aren't delivered to a connection that said
+hello without it, nor is its queue handed over.
+*/
+
+// Statuses on dm_status frames
+const (
+	dmSent      = "sent"
+	dmDelivered = "delivered"
+	dmQueued    = "queued"
+	dmFailed    = "failed"
+)
+
+// splitAddress splits a direct message address into its user and
+// server; server is empty for a user on this server
+func (h *LocalHub) splitAddress(address string) (user, server string) {
+	user, server, _ = strings.Cut(address, "@")
+	if h.federation != nil && server == h.federation.Name() {
+		server = ""
+	}
+	return user, server
+}
+
+// handleDirect delivers a client's direct message, or passes it to the
+// recipient's server, and tells the client
+func (h *LocalHub) handleDirect(client *Client, msg Message, now time.Time) {
+	user, server := h.splitAddress(msg.To)
+	if !validRemoteUser(user) {
+		h.sendTo(client, errorMessage(client, errCodeBadFrame, "to must be a username, or user@server"))
+		return
+	}
+	status := Message{Type: "dm_status", ID: msg.ID, To: msg.To, RoomName: client.room}
+
+	if server == "" {
+		status.Status = h.deliverDirect(Message{
+			Type:       "dm",
+			ID:         msg.ID,
+			Content:    msg.Content,
+			Formatted:  msg.Formatted,
+			Username:   client.username,
+			To:         user,
+			ServerTime: now.UnixMilli(),
+		})
+		h.sendTo(client, status)
+		return
+	}
+
+	if h.federation == nil || !h.federation.Known(server) {
+		h.sendTo(client, errorMessage(client, errCodeUnknownRecipient, "this server doesn't federate with "+server))
+		return
+	}
+	out := Message{ID: msg.ID, Content: msg.Content, Username: client.username, To: user}
+	h.sendFederated(server, federationFrame{Kind: fedDirect, Origin: h.federation.Name(), Message: &out})
+	metrics.DirectMessages.WithLabelValues(dmSent).Inc()
+	status.Status = dmSent
+	h.sendTo(client, status)
+}
+
+// deliverDirect sends dm to its recipient's connections, or queues it
+// if there are none, returning its status
+func (h *LocalHub) deliverDirect(dm Message) string {
+	if h.pushUser(dm.To, dm, nil) {
+		metrics.DirectMessages.WithLabelValues(dmDelivered).Inc()
+		return dmDelivered
+	}
+
+	ctx, cancel := storageContext()
+	defer cancel()
+	err := h.store.QueueDirect(ctx, storage.DirectMessage{
+		ID:        dm.ID,
+		From:      dm.Username,
+		To:        dm.To,
+		Origin:    dm.Origin,
+		Content:   dm.Content,
+		CreatedAt: time.UnixMilli(dm.ServerTime).UTC(),
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrDirectFull) {
+			reportStorageError("queue direct message", err, errreport.Context{Username: dm.To})
+		}
+		metrics.DirectMessages.WithLabelValues(dmFailed).Inc()
+		return dmFailed
+	}
+	metrics.DirectMessages.WithLabelValues(dmQueued).Inc()
+	return dmQueued
+}
+
+// receiveDirect delivers a direct message from another server and
+// answers with its status
+func (h *LocalHub) receiveDirect(frame federationFrame) {
+	dm := *frame.Message
+	dm.ServerTime = time.Now().UnixMilli()
+	status := h.deliverDirect(dm)
+	h.ackDirect(frame.from, strings.TrimSuffix(dm.Username, "@"+frame.from), dm.To, dm.ID, status)
+}
+
+// ackDirect tells the sender of direct message id to recipient what
+// became of it, on this server when origin is empty
+func (h *LocalHub) ackDirect(origin, sender, recipient, id, status string) {
+	if origin == "" {
+		h.pushUser(sender, Message{Type: "dm_status", ID: id, To: recipient, Status: status}, nil)
+		return
+	}
+	if h.federation == nil || !h.federation.Known(origin) {
+		return
+	}
+	ack := Message{ID: id, Username: sender, To: recipient, Status: status}
+	h.sendFederated(origin, federationFrame{Kind: fedDirectAck, Origin: h.federation.Name(), Message: &ack})
+}
+
+// handleDirectAck passes another server's status for a direct message
+// to its sender
+func (h *LocalHub) handleDirectAck(frame federationFrame) {
+	ack := frame.Message
+	h.pushUser(ack.Username, Message{Type: "dm_status", ID: ack.ID, To: ack.To + "@" + frame.from, Status: ack.Status}, nil)
+}
+
+// takeDirect takes the direct messages that waited for a joining
+// client's user; called off the hub goroutine (see loadArrival)
+func (h *LocalHub) takeDirect(client *Client) []storage.DirectMessage {
+	ctx, cancel := storageContext()
+	defer cancel()
+	queued, err := h.store.TakeDirect(ctx, client.username)
+	if err != nil {
+		reportStorageError("take direct messages", err, client.reportContext())
+		return nil
+	}
+	return queued
+}
+
+// requeueDirect queues a taken direct message again; called off the
+// hub goroutine
+func (h *LocalHub) requeueDirect(dm storage.DirectMessage) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := h.store.QueueDirect(ctx, dm); err != nil {
+		reportStorageError("queue direct message", err, errreport.Context{Username: dm.To})
+	}
+}
+
+// deliverQueuedDirect hands a joined client the direct messages that
+// waited for its user, and tells their senders
+func (h *LocalHub) deliverQueuedDirect(client *Client, queued []storage.DirectMessage) {
+	for _, dm := range queued {
+		h.sendTo(client, Message{
+			Type:        "dm",
+			ID:          dm.ID,
+			Content:     dm.Content,
+			Formatted:   markdown.Format(dm.Content),
+			Username:    dm.From,
+			To:          dm.To,
+			Origin:      dm.Origin,
+			ServerTime:  dm.CreatedAt.UnixMilli(),
+			Redelivered: true,
+		})
+		sender := dm.From
+		if dm.Origin != "" {
+			sender = strings.TrimSuffix(sender, "@"+dm.Origin)
+		}
+		h.ackDirect(dm.Origin, sender, dm.To, dm.ID, dmDelivered)
+	}
+}
@@ -0,0 +1,45 @@
+package websockets
+
+import (
+	"chat-app/emoji"
+	"chat-app/storage"
+)
+
+/*
+Emoji Overview:
+--------------
+WithEmoji expands :shortcodes: in chat messages to Unicode as they are
+read, so history, search and bridged platforms all see the same text
+whatever the sending client supports:
+
+	{"type": "chat", "content": "ship it :rocket:"}
+	->  {"type": "chat", "content": "ship it 🚀", ...}
+
+Built-in names come from the emoji package; a room's custom emoji
+(see storage.CustomEmoji) are added to them and win on a clash. They
+are read from the store and cached per room for roomSettingsTTL, like
+link policies.
+*/
+
+// EmojiExpander expands shortcodes using each room's custom emoji;
+// safe for concurrent use
+type EmojiExpander struct {
+	settings *settingsCache
+}
+
+// NewEmojiExpander reads custom emoji from store
+func NewEmojiExpander(store storage.Store) *EmojiExpander {
+	return &EmojiExpander{settings: newSettingsCache(store)}
+}
+
+// WithEmoji expands shortcodes in chat messages
+func WithEmoji(e *EmojiExpander) Option {
+	return func(o *handlerOptions) {
+		o.emoji = e
+	}
+}
+
+// Expand replaces the shortcodes in content posted to room
+func (e *EmojiExpander) Expand(room, content string) string {
+	return emoji.Expand(content, e.settings.get(room).Emoji)
+}
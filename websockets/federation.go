@@ -0,0 +1,418 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"chat-app/markdown"
+	"chat-app/metrics"
+	"chat-app/sanitize"
+)
+
+/*
+Federation Overview:
+-------------------
+WithFederation shares rooms with other chat-app servers (see the
+federation package for the signed transport). A room is shared with
+the servers its settings list (see storage.FederationPolicy), and
+both servers must list each other:
+
+	"federation": {"servers": ["b.example"]}
+
+The room's hub, its owner in a cluster:
+
+1. Sends each chat message posted here to the room's servers
+2. Sends who is in the room here whenever that changes, and again
+   every housekeeping round while anyone is
+3. Posts chat messages from those servers to the room as from
+   user@server, with origin naming the server:
+
+	{"type": "chat", "id": "...", "room": "lobby", "username": "alice@b.example",
+	 "origin": "b.example", "content": "hi", "seq": 12}
+
+4. Lists their members in the room's presence as user@server, until
+   they leave or their server stops refreshing them for
+   federatedPresenceTTL
+
+Loops are prevented by construction: a server only sends what its own
+users did and never passes on a frame it received, so a room shared
+by three servers needs each pair to list the other. Frames whose
+origin isn't the server that sent them, or is this server, are
+dropped, as are messages already seen within federatedSeenTTL, so a
+retried or replayed batch isn't posted twice. Frames for rooms that
+don't list their sender are dropped too. Remote content is cleaned
+like local content (see the sanitize package).
+
+Each message carries the seq and time it was posted with on its
+server. When a link comes back up, servers compare what they have of
+each other's messages and send what was missed (see backfill.go).
+
+Direct messages to user@server travel between servers the same way,
+as direct frames answered by direct_ack ones (see direct.go); they
+belong to no room, so need no room to be shared.
+
+While federation is on, usernames containing @ are refused at
+connect, so nobody here can pass as a remote user.
+*/
+
+// Federation connects the hub to other chat servers
+// *federation.Federation implements it
+type Federation interface {
+	// Name is this server's federation name
+	Name() string
+	// Known reports whether server is a configured peer
+	Known(server string) bool
+	// Send queues a frame for server without blocking
+	Send(server string, frame []byte)
+	// OnFrame sets the handler for frames from peers
+	OnFrame(fn func(from string, frame []byte))
+	// OnLinkUp sets the handler for a link to a peer coming up
+	OnLinkUp(fn func(server string))
+}
+
+// Federation frame kinds
+const (
+	fedMessage   = "message"    // A chat message posted on the origin
+	fedMembers   = "members"    // Who is in the room on the origin
+	fedDirect    = "direct"     // A direct message for a user here, see direct.go
+	fedDirectAck = "direct_ack" // What became of a direct message sent from here
+	fedSync      = "sync"       // Which of the room's messages the origin has, see backfill.go
+	fedSyncMore  = "sync_more"  // A backfill stopped short; ask again from Seq
+)
+
+const (
+	// How long federated members stay listed without a refresh
+	federatedPresenceTTL = 3 * housekeepingInterval
+
+	// How long federated message IDs are remembered, to drop repeats
+	federatedSeenTTL = 10 * time.Minute
+
+	// Most members accepted from one server for one room
+	maxFederatedMembers = 1000
+
+	// Longest username accepted from another server
+	maxFederatedUsername = 64
+)
+
+// federationFrame is the unit of traffic between servers
+type federationFrame struct {
+	Kind    string   `json:"kind"`
+	Room    string   `json:"room,omitempty"`    // Empty for direct messages
+	Origin  string   `json:"origin"`            // Server the frame describes; always its sender
+	Message *Message `json:"message,omitempty"` // For fedMessage, fedDirect and fedDirectAck
+	Users   []Member `json:"users,omitempty"`   // For fedMembers; empty when everyone left
+	Ask     bool     `json:"ask,omitempty"`     // For fedMembers: answer with yours
+	Seq     uint64   `json:"seq,omitempty"`     // For fedSync and fedSyncMore; on fedMessage, the origin's seq is the message's
+	Prev    uint64   `json:"prev,omitempty"`    // For fedMessage: the seq of the one sent before it, if known
+
+	from string // Server the frame arrived from
+}
+
+// federatedMembers is who one server last said is in a room
+type federatedMembers struct {
+	users   []Member // Usernames already qualified with the server
+	expires time.Time
+}
+
+// WithFederation shares rooms with other servers; clean is applied to
+// their messages' content
+func WithFederation(f Federation, clean sanitize.Sanitizer) HubOption {
+	return func(h *LocalHub) {
+		h.federation = f
+		h.federationClean = clean
+		f.OnFrame(h.receiveFederated)
+		f.OnLinkUp(h.reconcileFederated)
+	}
+}
+
+// federatedHub is implemented by hubs that may share rooms with other
+// servers
+type federatedHub interface {
+	Federates() bool
+}
+
+// Federates reports whether rooms may be shared with other servers
+func (h *LocalHub) Federates() bool {
+	return h.federation != nil
+}
+
+// receiveFederated checks and decodes a frame from another server, then
+// hands it to the hub goroutine
+// Runs on the request's goroutine, so content is cleaned and parsed here
+func (h *LocalHub) receiveFederated(from string, data []byte) {
+	var frame federationFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		log.Printf("Federation: bad frame from %s: %v", from, err)
+		return
+	}
+	frame.from = from
+
+	// Servers only speak for themselves, and room frames need a room
+	direct := frame.Kind == fedDirect || frame.Kind == fedDirectAck
+	if frame.Origin != from || from == h.federation.Name() || (frame.Room == "") != direct {
+		metrics.FederationFrames.WithLabelValues("dropped").Inc()
+		return
+	}
+
+	switch frame.Kind {
+	case fedMessage, fedDirect:
+		m := frame.Message
+		if m == nil || m.ID == "" || !validRemoteUser(m.Username) || len(m.Content) > maxMessageSize ||
+			(frame.Kind == fedDirect && !validRemoteUser(m.To)) {
+			metrics.FederationFrames.WithLabelValues("dropped").Inc()
+			return
+		}
+		content := h.federationClean.Clean(m.Content)
+		posted := m.ServerTime
+		if now := time.Now().UnixMilli(); posted <= 0 || posted > now {
+			posted = now // Not trusted to be in the future
+		}
+		frame.Seq = m.Seq
+		frame.Message = &Message{
+			Type:       "chat",
+			ID:         m.ID,
+			Content:    content,
+			Formatted:  markdown.Format(content),
+			RoomName:   frame.Room,
+			Username:   m.Username + "@" + from,
+			Origin:     from,
+			ServerTime: posted,
+		}
+		if frame.Kind == fedDirect {
+			frame.Message.Type = "dm"
+			frame.Message.To = m.To
+		}
+	case fedDirectAck:
+		m := frame.Message
+		if m == nil || m.ID == "" || !validRemoteUser(m.Username) || !validRemoteUser(m.To) ||
+			(m.Status != dmDelivered && m.Status != dmQueued && m.Status != dmFailed) {
+			metrics.FederationFrames.WithLabelValues("dropped").Inc()
+			return
+		}
+		frame.Message = &Message{ID: m.ID, Username: m.Username, To: m.To, Status: m.Status}
+	case fedMembers:
+		if len(frame.Users) > maxFederatedMembers {
+			frame.Users = frame.Users[:maxFederatedMembers]
+		}
+		users := make([]Member, 0, len(frame.Users))
+		for _, m := range frame.Users {
+			if validRemoteUser(m.Username) {
+				users = append(users, Member{Username: m.Username + "@" + from, Status: m.Status})
+			}
+		}
+		frame.Users = users
+	case fedSync, fedSyncMore:
+	default:
+		log.Printf("Federation: unknown frame kind %q from %s", frame.Kind, from)
+		return
+	}
+	h.federated <- frame
+}
+
+// validRemoteUser reports whether a username from another server can be
+// shown here as user@server
+func validRemoteUser(username string) bool {
+	return username != "" && len(username) <= maxFederatedUsername && !strings.ContainsAny(username, "@,")
+}
+
+// federatedServers lists the peers room is shared with
+func (h *LocalHub) federatedServers(room string) []string {
+	if h.federation == nil {
+		return nil
+	}
+	var servers []string
+	for _, server := range h.settings.get(room).Federation.Servers {
+		if h.federation.Known(server) && server != h.federation.Name() {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// sendFederated encodes frame and queues it for server
+func (h *LocalHub) sendFederated(server string, frame federationFrame) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("Federation: error marshaling frame: %v", err)
+		return
+	}
+	h.federation.Send(server, data)
+}
+
+// handleFederated applies a frame from another server on the room's
+// owner, passing it there if that is another node
+func (h *LocalHub) handleFederated(frame federationFrame) {
+	// Direct messages belong to no room, so are handled wherever they arrive
+	switch frame.Kind {
+	case fedDirect:
+		if h.seenFederated(frame) {
+			return
+		}
+		h.receiveDirect(frame)
+		return
+	case fedDirectAck:
+		h.handleDirectAck(frame)
+		return
+	}
+
+	if !h.settings.get(frame.Room).Federation.Allows(frame.from) {
+		metrics.FederationFrames.WithLabelValues("denied").Inc()
+		return
+	}
+	if owner, ok := h.remoteOwner(frame.Room); ok {
+		h.sendFrame(nil, owner, relayFrame{Kind: frameFederated, Room: frame.Room, Federated: &frame, Server: frame.from})
+		return
+	}
+
+	switch frame.Kind {
+	case fedMessage:
+		if h.seenFederated(frame) {
+			return
+		}
+		h.checkFederatedSeq(frame) // Even if refused below, so it isn't asked for again
+		if !h.settings.get(frame.Room).Permissions.MayPost(frame.Message.Username) {
+			metrics.FederationFrames.WithLabelValues("denied").Inc()
+			return // A broadcast room the remote user can't post in
+		}
+		msg := *frame.Message
+		msg.originSeq = frame.Seq
+		h.handleBroadcast(msg)
+	case fedMembers:
+		h.setFederatedMembers(frame)
+	case fedSync:
+		go h.backfill(frame.from, frame.Room, frame.Seq)
+	case fedSyncMore:
+		h.sendFederated(frame.from, federationFrame{Kind: fedSync, Room: frame.Room, Origin: h.federation.Name(), Seq: frame.Seq})
+	}
+}
+
+// seenFederated reports whether frame's message was already received,
+// remembering it if not
+func (h *LocalHub) seenFederated(frame federationFrame) bool {
+	key := frame.from + "/" + frame.Message.ID
+	if _, seen := h.federatedSeen[key]; seen {
+		metrics.FederationFrames.WithLabelValues("duplicate").Inc()
+		return true
+	}
+	h.federatedSeen[key] = time.Now().Add(federatedSeenTTL)
+	return false
+}
+
+// setFederatedMembers records who a server says is in a room, and
+// answers with who is here if it asked
+func (h *LocalHub) setFederatedMembers(frame federationFrame) {
+	room := frame.Room
+	if len(frame.Users) == 0 {
+		delete(h.federatedUsers[room], frame.from)
+		if len(h.federatedUsers[room]) == 0 {
+			delete(h.federatedUsers, room)
+		}
+	} else {
+		if h.federatedUsers[room] == nil {
+			h.federatedUsers[room] = make(map[string]federatedMembers)
+		}
+		h.federatedUsers[room][frame.from] = federatedMembers{users: frame.Users, expires: time.Now().Add(federatedPresenceTTL)}
+	}
+
+	if _, known := h.federatedSent[room]; known && frame.Ask {
+		h.federatedSent[room] = "" // Sends ours even if unchanged
+	}
+	h.broadcastRoomUsers(room)
+}
+
+// federate sends a chat message posted here to the servers its room is
+// shared with; messages from other servers are never passed on
+func (h *LocalHub) federate(msg Message) {
+	if msg.Origin != "" {
+		return
+	}
+	servers := h.federatedServers(msg.RoomName)
+	if len(servers) == 0 {
+		return
+	}
+	out := Message{Type: msg.Type, ID: msg.ID, Content: msg.Content, Username: msg.Username, Seq: msg.Seq, ServerTime: msg.ServerTime}
+	prev := h.federatedPrev[msg.RoomName]
+	h.federatedPrev[msg.RoomName] = msg.Seq
+	for _, server := range servers {
+		h.sendFederated(server, federationFrame{Kind: fedMessage, Room: msg.RoomName, Origin: h.federation.Name(), Message: &out, Prev: prev})
+	}
+}
+
+// federatePresence tells the servers room is shared with who is in it
+// here, if that changed since they were last told
+// members must not include federated members
+func (h *LocalHub) federatePresence(room string, members map[string]Member) {
+	servers := h.federatedServers(room)
+	if len(servers) == 0 {
+		return
+	}
+	users := sortedUsers(members)
+	key := make([]string, len(users))
+	list := make([]Member, len(users))
+	for i, user := range users {
+		key[i] = user + "/" + members[user].Status
+		list[i] = Member{Username: user, Status: members[user].Status}
+	}
+	sent, known := h.federatedSent[room]
+	if !known && len(users) == 0 || known && sent == strings.Join(key, ",") {
+		return
+	}
+
+	if len(users) == 0 {
+		delete(h.federatedSent, room)
+	} else {
+		h.federatedSent[room] = strings.Join(key, ",")
+	}
+	for _, server := range servers {
+		// A room new to federation here asks for the others' members
+		h.sendFederated(server, federationFrame{Kind: fedMembers, Room: room, Origin: h.federation.Name(), Users: list, Ask: !known})
+	}
+}
+
+// addFederatedMembers adds the members other servers reported for room
+func (h *LocalHub) addFederatedMembers(room string, members map[string]Member) {
+	for _, fm := range h.federatedUsers[room] {
+		for _, m := range fm.users {
+			members[m.Username] = m
+		}
+	}
+}
+
+// refreshFederation re-sends this server's members of shared rooms, so
+// peers don't expire them, and forgets what expired here
+func (h *LocalHub) refreshFederation(now time.Time) {
+	for key, expires := range h.federatedSeen {
+		if now.After(expires) {
+			delete(h.federatedSeen, key)
+		}
+	}
+
+	changed := make(map[string]bool)
+	for room, servers := range h.federatedUsers {
+		for server, fm := range servers {
+			if now.After(fm.expires) {
+				delete(servers, server)
+				changed[room] = true
+			}
+		}
+		if len(servers) == 0 {
+			delete(h.federatedUsers, room)
+		}
+	}
+	for room := range h.federatedSent {
+		h.federatedSent[room] = "" // Forces a resend
+		changed[room] = true
+	}
+
+	rooms := make([]string, 0, len(changed))
+	for room := range changed {
+		rooms = append(rooms, room)
+	}
+	slices.Sort(rooms)
+	for _, room := range rooms {
+		h.broadcastRoomUsers(room)
+	}
+}
@@ -0,0 +1,116 @@
+package websockets
+
+import (
+	"time"
+
+	"chat-app/metrics"
+	"chat-app/ratelimit"
+)
+
+/*
+Throughput Guard Overview:
+-------------------------
+Under extreme load the hub can fall behind faster than it recovers:
+every message queued makes the next one later. WithThroughputGuard
+caps room broadcasts server-wide and decides what to drop first:
+
+	presence   presence_join, presence_leave,        shed at half capacity
+	           online_users, user_joined, user_left
+	chat       chat messages                         shed when none is left
+	private    acks, errors, hello, reconnect        never shed
+
+The guard is one token bucket refilled at rate broadcasts per second
+with a second's worth of burst. Presence only goes out while at least
+half the burst remains, so chat keeps flowing after presence has
+stopped. A shed chat message gets a server_busy error so the sender
+can retry; it is not sequenced or stored.
+
+Rooms whose presence was shed are marked stale and get a fresh
+online_users once there is headroom again, so member lists converge
+after the spike instead of staying wrong.
+
+Each broadcast costs one token whatever the room size; size the rate
+for the busiest rooms you expect.
+*/
+
+// presenceFloor is the share of the burst kept back for chat
+const presenceFloor = 0.5
+
+// throughputGuard ranks broadcasts on a shared budget; owned by the hub goroutine
+type throughputGuard struct {
+	bucket *ratelimit.Bucket
+	stale  map[string]bool // Rooms whose presence was shed
+}
+
+// WithThroughputGuard caps room broadcasts at rate per second, shedding
+// presence before chat; rate <= 0 disables it
+func WithThroughputGuard(rate float64) HubOption {
+	return func(h *LocalHub) {
+		if rate <= 0 {
+			return
+		}
+		h.guard = &throughputGuard{
+			bucket: ratelimit.NewBucket(rate, rate, time.Now()),
+			stale:  make(map[string]bool),
+		}
+	}
+}
+
+// isPresence reports whether a message type only refreshes presence
+func isPresence(msgType string) bool {
+	switch msgType {
+	case "presence_join", "presence_leave", "online_users", "user_joined", "user_left":
+		return true
+	}
+	return false
+}
+
+// admitBroadcast spends the guard's budget on a room broadcast
+// It reports false, after telling the sender if there is one, when
+// msg is shed
+func (h *LocalHub) admitBroadcast(msg Message, now time.Time) bool {
+	g := h.guard
+	if g == nil {
+		return true
+	}
+
+	switch {
+	case isPresence(msg.Type):
+		if g.bucket.Take(now, g.bucket.Burst()*presenceFloor) {
+			if msg.Type == "online_users" {
+				delete(g.stale, msg.RoomName)
+			}
+			return true
+		}
+		g.stale[msg.RoomName] = true
+	case !isControl(msg.Type):
+		if g.bucket.Take(now, 0) {
+			return true
+		}
+		h.reply(msg, Message{
+			Type:     "error",
+			Code:     errCodeBusy,
+			Content:  "server is busy, please retry",
+			RoomName: msg.RoomName,
+		})
+	default:
+		return true
+	}
+	metrics.BroadcastShed.WithLabelValues(msg.Type).Inc()
+	return false
+}
+
+// refreshStalePresence re-sends member lists shed under load
+// Rooms stay stale until a refresh gets through
+func (h *LocalHub) refreshStalePresence() {
+	if h.guard == nil {
+		return
+	}
+	for room := range h.guard.stale {
+		if !h.roomActive(room) {
+			delete(h.guard.stale, room)
+			continue
+		}
+		h.resyncPresence(room)
+	}
+}
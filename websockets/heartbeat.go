@@ -0,0 +1,104 @@
+package websockets
+
+import (
+	"time"
+)
+
+/*
+Heartbeat Overview:
+------------------
+Protocol pings prove the TCP connection is alive, but browsers answer
+them on their own, so a tab the OS has frozen or a phone that slept
+looks connected for as long as its socket survives. With
+WithHeartbeat the hub also sends an application heartbeat, which only
+a running client can answer:
+
+	server: {"type": "heartbeat", "server_time": 1718000000000, "ttl_ms": 60000}
+	client: {"type": "heartbeat"}
+
+server_time is the server's clock in Unix milliseconds, for clients
+that want to correct their own. ttl_ms is how long the client may go
+without answering before it is shown as away.
+
+Step 1: Every interval, each connection gets a heartbeat
+Step 2: Connections that answered one are held to the TTL from then on
+Step 3: A connection silent for the TTL is marked away; when all of a
+        user's connections in a room are away, the room gets a
+        presence_join with "status": "away"
+Step 4: Silent for twice the TTL, it is disconnected
+Step 5: Any answer before that brings it back, with another presence_join
+
+Connections that never answer, like wscat, are left to the protocol
+pings, so clients opt in just by replying.
+*/
+
+// StatusAway is a member's status once all their connections to the
+// room have missed heartbeats; members without a status are online
+const StatusAway = "away"
+
+// closeReasonHeartbeat is recorded for connections dropped after going silent
+const closeReasonHeartbeat = "missed_heartbeat"
+
+// heartbeatConfig is how often heartbeats go out and how long silence is tolerated
+type heartbeatConfig struct {
+	interval time.Duration
+	ttl      time.Duration
+}
+
+// WithHeartbeat sends application heartbeats every interval; clients
+// that answer one are shown as away after ttl without answering, and
+// disconnected after twice that. interval <= 0 disables heartbeats and
+// ttl <= 0 means three intervals
+func WithHeartbeat(interval, ttl time.Duration) HubOption {
+	return func(h *LocalHub) {
+		if interval <= 0 {
+			return
+		}
+		if ttl <= 0 {
+			ttl = 3 * interval
+		}
+		h.heartbeat = &heartbeatConfig{interval: interval, ttl: ttl}
+	}
+}
+
+// sendHeartbeats sends every connection a heartbeat, then marks away or
+// drops those that stopped answering
+func (h *LocalHub) sendHeartbeats(now time.Time) {
+	beat := Message{
+		Type:       "heartbeat",
+		ServerTime: now.UnixMilli(),
+		TTLMs:      h.heartbeat.ttl.Milliseconds(),
+	}
+	changed := make(map[string]bool)
+	for client := range h.clients {
+		if client.lastBeat.IsZero() {
+			h.sendTo(client, beat) // Not opted in; the pings watch it
+			continue
+		}
+		switch silent := now.Sub(client.lastBeat); {
+		case silent >= 2*h.heartbeat.ttl:
+			client.logf("no heartbeat for %s, disconnecting", silent.Round(time.Second))
+			h.disconnect(client, closeReasonHeartbeat)
+			continue
+		case silent >= h.heartbeat.ttl && !client.away:
+			client.away = true
+			changed[client.room] = true
+		}
+		h.sendTo(client, beat)
+	}
+	for room := range changed {
+		h.broadcastRoomUsers(room)
+	}
+}
+
+// handleHeartbeat records a client's answer, bringing it back if it was away
+func (h *LocalHub) handleHeartbeat(client *Client, now time.Time) {
+	if !h.clients[client] {
+		return
+	}
+	client.lastBeat = now
+	if client.away {
+		client.away = false
+		h.broadcastRoomUsers(client.room)
+	}
+}
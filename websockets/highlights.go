@@ -0,0 +1,118 @@
+package websockets
+
+import (
+	"errors"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/notify"
+	"chat-app/storage"
+)
+
+/*
+Highlights Overview:
+-------------------
+Users can register highlight words in their notification preferences
+(see storage.NotificationPrefs), IRC style. When a chat message in a
+room they're connected to holds one as a word of its own, ignoring
+case, the user gets a private frame on every connection they have open
+on the node, whatever room it is in:
+
+	{"type": "highlight", "room": "lobby", "content": "deploy",
+	 "alert": {"message": {...}, "context": [{...}, {...}]}}
+
+content is the word that matched; alert is shaped as on keyword_alert
+frames (see alerts.go). For notifications the notify package counts a
+highlight like a mention.
+
+Matching runs on the room's owner after the message is delivered,
+against words cached on the hub: they are loaded in the background
+when the user connects, and again once highlightTTL has passed, so
+the hub never waits on the store for them. Changes over REST take
+effect within that time; set_preferences frames take effect at once. In a cluster, only users connected to the room's owner are
+highlighted.
+*/
+
+// highlightTTL is how long a user's highlight words are cached
+const highlightTTL = 10 * time.Second
+
+// userHighlights caches one user's highlight words
+type userHighlights struct {
+	words   []string
+	expires time.Time
+	loading bool // A load is in flight
+}
+
+// highlightWords returns username's highlight words as last loaded,
+// loading them again in the background once they are stale
+func (h *LocalHub) highlightWords(username string, now time.Time) []string {
+	h.loadHighlights(username, now)
+	return h.highlights[username].words
+}
+
+// loadHighlights reads username's highlight words off the hub goroutine
+// and caches them once read, unless they are fresh or a load is
+// already in flight
+func (h *LocalHub) loadHighlights(username string, now time.Time) {
+	cached := h.highlights[username]
+	if cached.loading || now.Before(cached.expires) {
+		return
+	}
+	cached.loading = true
+	h.highlights[username] = cached
+	go func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		prefs, err := h.store.GetNotificationPrefs(ctx, username)
+		h.queries <- func() { h.loadedHighlights(username, prefs.Highlights, err) }
+	}()
+}
+
+// loadedHighlights caches words loaded for username
+// If loading failed the last known words stay in force; words saved
+// over set_preferences meanwhile win over the load
+func (h *LocalHub) loadedHighlights(username string, words []string, err error) {
+	cached, ok := h.highlights[username]
+	if !ok || !cached.loading {
+		return
+	}
+	cached.loading = false
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		reportStorageError("load highlights", err, errreport.Context{Username: username})
+		h.highlights[username] = cached
+		return
+	}
+	h.highlights[username] = userHighlights{words: words, expires: time.Now().Add(highlightTTL)}
+}
+
+// sweepHighlights drops the cached words of users no longer connected
+func (h *LocalHub) sweepHighlights() {
+	for username, cached := range h.highlights {
+		if !cached.loading && len(h.users[username]) == 0 {
+			delete(h.highlights, username)
+		}
+	}
+}
+
+// highlightMembers sends highlight frames for a chat message just
+// delivered to the users in its room whose words it holds
+func (h *LocalHub) highlightMembers(msg Message, now time.Time) {
+	var alert *Alert
+	seen := make(map[string]bool)
+	for member := range h.rooms[msg.RoomName] {
+		if member.username == msg.Username || seen[member.username] {
+			continue
+		}
+		seen[member.username] = true
+		word := notify.Highlighted(msg.Content, h.highlightWords(member.username, now))
+		if word == "" {
+			continue
+		}
+		if alert == nil {
+			alert = &Alert{Message: msg, Context: h.recent.before(msg.RoomName, msg.ID, alertContext)}
+		}
+		for client := range h.users[member.username] {
+			h.sendTo(client, Message{Type: "highlight", Content: word, RoomName: msg.RoomName, Alert: alert})
+		}
+	}
+}
@@ -1,9 +1,29 @@
 package websockets
 
 import (
+	"context"
 	"encoding/json"
 	"log"
-	"strings"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/eventlog"
+	"chat-app/markdown"
+	"chat-app/metering"
+	"chat-app/metrics"
+	"chat-app/moderation"
+	"chat-app/notify"
+	"chat-app/sanitize"
+	"chat-app/storage"
+	"chat-app/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
@@ -22,36 +42,459 @@ Key Features:
 - User join/leave notifications
 - Online user tracking per room
 - Message broadcasting to room members
+
+Ordering Guarantees:
+Every chat message broadcast to a room gets the next value of that room's
+sequence number (Seq). Sequence numbers are assigned only by the hub
+goroutine, so each room has exactly one writer, and messages are
+enqueued on every client's send channel in Seq order. Each client's
+writePump drains its channel in FIFO order, so all members of a room
+observe the same order. A client seeing Seq go backwards has found a
+bug; a gap means it missed messages (e.g. it was dropped for being slow).
+Anything that relays room messages between servers must carry Seq from
+the room's single writer rather than assigning its own; in a cluster
+that is the room's owner node (see relay.go).
+Sequences restart at 1 when an empty room is closed.
+
+Control frames (presence, acks, errors) travel on a separate priority
+lane so a flooded room can't delay them. They may overtake queued chat
+messages and therefore carry no Seq.
 */
 
+// slowFanoutThreshold is the fan-out duration above which a broadcast is logged
+const slowFanoutThreshold = 50 * time.Millisecond
+
+// housekeepingInterval is how often the hub expires cached state
+const housekeepingInterval = time.Minute
+
 // Message defines the structure of all communications in the chat system
 type Message struct {
-	Type     string `json:"type"`     // Message types: chat, user_joined, user_left, online_users
-	Content  string `json:"content"`   // The message content
-	RoomName string `json:"room"`     // The room this message belongs to
-	Username string `json:"username"`  // The sender's username
+	Type     string `json:"type"`          // Message types: hello, chat, user_joined, user_left, online_users, presence_join, presence_leave, heartbeat, rtt, time, ack, error, reconnect, moderation, report, mod_queue, keyword_alert, highlight, hello_ack, session_transferred, onboarding, announcement, sticker, sticker_packs, audio, attachment, transfer_*, dm, dm_status
+	ID       string `json:"id,omitempty"`  // Unique ID of a chat message
+	Content  string `json:"content"`       // The message content
+	RoomName string `json:"room"`          // The room this message belongs to
+	Username string `json:"username"`      // The sender's username
+	Seq      uint64 `json:"seq,omitempty"` // Per-room sequence number, assigned by the hub
+	Code     string `json:"code,omitempty"`
+	QoS      string `json:"qos,omitempty"` // Delivery guarantee, see qos.go
+
+	// Set on re-sent or queued copies; dedupe by ID
+	Redelivered bool `json:"redelivered,omitempty"`
+
+	// Client-chosen key that makes retried sends safe; echoed only in acks
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Reconnect hints, see reconnect.go; URL is also a link on onboarding
+	// frames, and quota_exceeded errors say when to retry (see quotas.go)
+	URL          string `json:"url,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+	// The server a message from another server was posted on, see federation.go
+	Origin string `json:"origin,omitempty"`
+	// The recipient on dm and dm_status frames, see direct.go
+	To string `json:"to,omitempty"`
+	// Given back as ?resume= to take over this connection, see lifetime.go
+	ResumeToken string `json:"resume_token,omitempty"`
+
+	// Set on hello frames, see protocol.go
+	Protocol int    `json:"protocol,omitempty"`
+	Server   string `json:"server,omitempty"`
+	// Optional features offered on hello frames, and accepted on
+	// hello_ack frames, see capabilities.go
+	Features []string `json:"features,omitempty"`
+
+	// The recipient's connection ID, on hello and error frames, to quote to support
+	ConnID string `json:"conn_id,omitempty"`
+
+	// The room's members on online_users frames, see presence.go
+	Users []Member `json:"users,omitempty"`
+	// Username -> device type and avatar URL on protocol 1 online_users frames
+	Devices map[string]string `json:"devices,omitempty"`
+	Avatars map[string]string `json:"avatars,omitempty"`
+	// The member's device type, avatar URL and status on presence_join frames, see presence.go
+	Device string `json:"device,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+	Status string `json:"status,omitempty"`
+
+	// The server clock in Unix milliseconds: when a message was posted, or
+	// when a hello, heartbeat or time frame was sent, see clock.go
+	ServerTime int64 `json:"server_time,omitempty"`
+	// The client's clock echoed on time frames, see clock.go
+	ClientTime int64 `json:"client_time,omitempty"`
+	// The presence TTL on heartbeat frames, see heartbeat.go
+	TTLMs int64 `json:"ttl_ms,omitempty"`
+	// The recipient's round-trip time on rtt frames, see rtt.go
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+
+	// Toxicity score on moderation frames, see moderation.go
+	Score float64 `json:"score,omitempty"`
+
+	// The queue entry on mod_queue frames, see review.go
+	Review *storage.ReviewItem `json:"review,omitempty"`
+
+	// The matching message on keyword_alert frames, see alerts.go
+	Alert *Alert `json:"alert,omitempty"`
+
+	// The messages removed on messages_purged frames, see purge.go
+	Purge *Purged `json:"purge,omitempty"`
+
+	// The changed key on sync frames, see sync.go
+	Sync *Sync `json:"sync,omitempty"`
+
+	// The user's notification preferences on preferences frames, see notifications.go
+	Preferences *storage.NotificationPrefs `json:"preferences,omitempty"`
+	// The room's invite list and tokens, on "invites" messages to its owners
+	Invites *storage.InvitePolicy `json:"invites,omitempty"`
+
+	// Options on role_picker onboarding frames, see onboarding.go
+	Choices []string `json:"choices,omitempty"`
+
+	// Content as styled segments when it uses Markdown, see the markdown package
+	Formatted []markdown.Segment `json:"formatted,omitempty"`
+
+	// The sticker on sticker frames, and the room's packs on sticker_packs frames, see stickers.go
+	Sticker *Sticker      `json:"sticker,omitempty"`
+	Packs   []StickerPack `json:"packs,omitempty"`
+	// The voice note on audio frames, see audio.go
+	Audio *Audio `json:"audio,omitempty"`
+	// The uploaded file on attachment frames, see attachment.go
+	Attachment *Attachment `json:"attachment,omitempty"`
+	// The message replied to, on chat frames that are replies, see replies.go
+	Quote *storage.Quote `json:"quote,omitempty"`
+	// The breakout on breakout_opened frames and closed breakouts' summaries, see breakouts.go
+	Breakout *storage.Breakout `json:"breakout,omitempty"`
+
+	// The file and handshake on transfer_* frames, see transfer.go
+	Transfer   *Transfer       `json:"transfer,omitempty"`
+	Signal     json.RawMessage `json:"signal,omitempty"`
+	ICEServers []ICEServer     `json:"ice_servers,omitempty"`
+
+	ctx    context.Context // Trace context of the read that produced this message
+	sender *Client         // Client that sent the message, if any
+	to     *Client         // When set, deliver only to this client (no Seq)
+	origin *origin         // Set on messages forwarded from another node
+
+	originSeq uint64    // The seq a message from another server had there
+	lookup    *lookedUp // The stored message it refers to, once fetched, see writethrough.go
 }
 
-// Hub maintains the set of active clients and broadcasts messages
-type Hub struct {
-	clients    map[*Client]bool                // All connected clients
-	rooms      map[string]map[*Client]bool     // Room-based client groups
-	broadcast  chan Message                    // Channel for inbound messages
-	register   chan *Client                    // Channel for client registration
-	unregister chan *Client                    // Channel for client disconnection
+// Hub is the contract between connections and the message router
+// LocalHub is the in-process implementation; embedders can wrap or
+// replace it, e.g. with a mock in their own tests
+type Hub interface {
+	// Register adds a client to its room
+	Register(client *Client)
+	// Unregister removes a client and notifies its room
+	Unregister(client *Client)
+	// Broadcast routes a message to every client in msg.RoomName
+	Broadcast(msg Message)
+	// RoomSnapshot describes the current members of a room
+	RoomSnapshot(room string) RoomSnapshot
 }
 
-func NewHub() *Hub {
-	return &Hub{
+// RoomSnapshot is a point-in-time view of a room's members
+type RoomSnapshot struct {
+	Room        string           `json:"room"`
+	Users       []string         `json:"users"`
+	Connections []ConnectionInfo `json:"connections"` // This node's connections only
+	Pinned      []string         `json:"pinned"`      // Message IDs, oldest first, if the room is owned here
+}
+
+// ConnectionInfo describes one client connection
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	Room        string    `json:"room"`
+	Username    string    `json:"username"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// How the client connected, see metadata.go
+	UserAgent   string `json:"user_agent,omitempty"`
+	IP          string `json:"ip,omitempty"`
+	Subprotocol string `json:"subprotocol,omitempty"`
+	Device      string `json:"device,omitempty"`
+	Country     string `json:"country,omitempty"`
+	Region      string `json:"region,omitempty"`
+
+	// Missed heartbeats, see heartbeat.go
+	Away bool `json:"away,omitempty"`
+	// Last round-trip time, see rtt.go
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+	// The tenant whose API key it used, see tenants.go
+	Tenant string `json:"tenant,omitempty"`
+	// Its username came from an access token, see tokens.go
+	Verified bool `json:"verified,omitempty"`
+}
+
+// LocalHub maintains the set of active clients and broadcasts messages
+// All state is owned by the goroutine running Run
+type LocalHub struct {
+	clients    map[*Client]bool                        // All connected clients
+	rooms      map[string]map[*Client]bool             // Room-based client groups
+	users      map[string]map[*Client]bool             // Each user's connections, in any room
+	broadcast  chan Message                            // Channel for inbound messages
+	register   chan *Client                            // Channel for client registration
+	unregister chan *Client                            // Channel for client disconnection
+	queries    chan func()                             // Channel for reads of hub state
+	stats      map[string]*roomStats                   // Per-room activity counters
+	seqs       map[string]uint64                       // Last sequence number issued per room
+	acks       *idempotencyCache                       // Recent acks for idempotent retries
+	store      storage.Store                           // Where messages are stored before broadcast
+	writers    []chan func()                           // Storage work waiting to be done, see writethrough.go
+	unnumbered map[string][]publishing                 // Messages waiting for their room's seq to be read
+	unsaved    map[string]int                          // Messages of each room being stored
+	storing    map[idempotencyKey]bool                 // Retry keys of messages being stored
+	pending    map[*Client]map[string]*pendingDelivery // Unacked reliable deliveries
+	events     *eventlog.Recorder                      // Room event log; nil disables it
+	meter      *metering.Meter                         // Usage accounting; nil disables it
+	accessLog  *AccessLog                              // One record per closed session, see accesslog.go; nil disables it
+	conns      map[string]*Client                      // Clients by connection ID, for relayed replies
+	resumes    map[string]*Client                      // Clients by resume token, see lifetime.go
+
+	peers       Peers                          // Other cluster nodes; nil when running alone
+	remote      chan relayFrame                // Frames arriving from other nodes
+	ringChanged chan struct{}                  // Signalled when room owners may have moved
+	remoteUsers map[string]map[string][]string // Room -> node -> members, for rooms owned here
+
+	// Room -> node -> member -> device, kept with remoteUsers when presenceDevices is set
+	remoteDevices map[string]map[string]map[string]string
+	// Room -> node -> member -> avatar URL, kept with remoteUsers
+	remoteAvatars map[string]map[string]map[string]string
+	// Room -> node -> member -> status, kept with remoteUsers for away members
+	remoteStatuses  map[string]map[string]map[string]string
+	presenceDevices bool // Include device types in presence frames
+
+	federation      Federation                             // Other servers rooms are shared with; nil disables it
+	federationClean sanitize.Sanitizer                     // Cleans their messages' content
+	federated       chan federationFrame                   // Frames arriving from other servers
+	federatedUsers  map[string]map[string]federatedMembers // Room -> server -> members, see federation.go
+	federatedSent   map[string]string                      // Room -> members last sent to other servers
+	federatedSeen   map[string]time.Time                   // Server/message ID -> when to forget it
+	federatedPrev   map[string]uint64                      // Room -> seq of the last message sent to other servers
+	federatedSeqs   map[string]map[string]uint64           // Room -> server -> highest seq received from it
+
+	presence       map[string]map[string]Member // Room -> what its members were last told, see presence.go
+	legacyPresence bool                         // Broadcast full online_users lists instead of deltas
+
+	guard     *throughputGuard // Server-wide broadcast budget; nil disables it
+	heartbeat *heartbeatConfig // Application heartbeats; nil disables them
+	idle      *idleConfig      // Idle connection handling, see idle.go; nil disables it
+	lifetime  *lifetimeConfig  // Maximum connection age, see lifetime.go; nil disables it
+	anomalies *AnomalyDetector // Flags misbehaving clients; nil disables it
+
+	moderator       *moderation.Moderator     // Scores chat messages; nil disables it
+	notifier        *notify.Dispatcher        // Notifies away members, see notifications.go; nil disables it
+	moderators      map[string]bool           // Usernames sent mod_queue frames, see review.go
+	recent          *recentMessages           // Recent chat messages users may report
+	recallWindow    time.Duration             // How long senders may recall a message, see recall.go
+	duplicateLogins string                    // What a second login to a room does, see logins.go
+	alerts          map[string]roomWatches    // Moderators' keyword watch lists by room, see alerts.go
+	highlights      map[string]userHighlights // Users' highlight words, see highlights.go
+	settings        *settingsCache            // Room settings, for onboarding
+	stickers        *stickerCatalog           // Sticker packs, see stickers.go
+	transfers       map[string]*transfer      // File transfer handshakes brokered here, see transfer.go
+	pins            map[string][]string       // Pinned message IDs by room, oldest first, see permissions.go
+	breakouts       map[string]*breakout      // Open breakout rooms handled here, see breakouts.go
+	iceServers      []ICEServer               // Given to transfer peers
+
+	statePath     string        // Hub state snapshot file; empty disables snapshots
+	stateInterval time.Duration // How often the snapshot is written
+	stateSaving   atomic.Bool   // Set while a snapshot write is in flight
+
+	draining atomic.Bool // Set while new connections are refused
+
+	invitesMu   sync.Mutex // Held while a room's invites change, see invites.go
+	tagsMu      sync.Mutex // Held while a room's tags or metadata change, see tags.go
+	breakoutsMu sync.Mutex // Held while a room's breakouts open or close, see breakouts.go
+}
+
+// HubOption customizes NewHub
+type HubOption func(*LocalHub)
+
+// WithStore sets the storage backend (default: in-memory)
+func WithStore(store storage.Store) HubOption {
+	return func(h *LocalHub) {
+		h.store = store
+	}
+}
+
+// WithEventLog records room events (messages, joins, leaves) to rec
+func WithEventLog(rec *eventlog.Recorder) HubOption {
+	return func(h *LocalHub) {
+		h.events = rec
+	}
+}
+
+// WithMeter counts messages and connection time for accounting
+func WithMeter(m *metering.Meter) HubOption {
+	return func(h *LocalHub) {
+		h.meter = m
+	}
+}
+
+// NewHub creates a LocalHub; call Run in its own goroutine before use
+func NewHub(opts ...HubOption) *LocalHub {
+	h := &LocalHub{
 		clients:    make(map[*Client]bool),
 		rooms:      make(map[string]map[*Client]bool),
+		users:      make(map[string]map[*Client]bool),
 		broadcast:  make(chan Message),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		queries:    make(chan func()),
+		stats:      make(map[string]*roomStats),
+		seqs:       make(map[string]uint64),
+		acks:       newIdempotencyCache(),
+		writers:    newWriters(),
+		unnumbered: make(map[string][]publishing),
+		unsaved:    make(map[string]int),
+		storing:    make(map[idempotencyKey]bool),
+		store:      storage.NewMemory(),
+		pending:    make(map[*Client]map[string]*pendingDelivery),
+		conns:      make(map[string]*Client),
+		resumes:    make(map[string]*Client),
+		transfers:  make(map[string]*transfer),
+		pins:       make(map[string][]string),
+		breakouts:  make(map[string]*breakout),
+		moderators: make(map[string]bool),
+		recent:     newRecentMessages(),
+		alerts:     make(map[string]roomWatches),
+		highlights: make(map[string]userHighlights),
+
+		remote:      make(chan relayFrame),
+		federated:   make(chan federationFrame),
+		ringChanged: make(chan struct{}, 1),
+		remoteUsers: make(map[string]map[string][]string),
+
+		federatedUsers: make(map[string]map[string]federatedMembers),
+		federatedSent:  make(map[string]string),
+		federatedSeen:  make(map[string]time.Time),
+		federatedPrev:  make(map[string]uint64),
+		federatedSeqs:  make(map[string]map[string]uint64),
+
+		remoteDevices:  make(map[string]map[string]map[string]string),
+		remoteAvatars:  make(map[string]map[string]map[string]string),
+		remoteStatuses: make(map[string]map[string]map[string]string),
+		presence:       make(map[string]map[string]Member),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.settings = newSettingsCache(h.store)
+	h.stickers = newStickerCatalog(h.store)
+	return h
+}
+
+// query runs fn on the hub goroutine and waits for it to finish
+// This lets HTTP handlers read hub state without locks
+func (h *LocalHub) query(fn func()) {
+	done := make(chan struct{})
+	h.queries <- func() {
+		fn()
+		close(done)
 	}
+	<-done
 }
 
-func (h *Hub) Run() {
+// Register implements Hub
+func (h *LocalHub) Register(client *Client) {
+	h.register <- client
+}
+
+// Unregister implements Hub
+func (h *LocalHub) Unregister(client *Client) {
+	h.unregister <- client
+}
+
+// Broadcast implements Hub
+func (h *LocalHub) Broadcast(msg Message) {
+	h.broadcast <- msg
+}
+
+// RoomSnapshot implements Hub
+func (h *LocalHub) RoomSnapshot(room string) RoomSnapshot {
+	snapshot := RoomSnapshot{Room: room, Users: []string{}, Connections: []ConnectionInfo{}}
+	h.query(func() {
+		snapshot.Pinned = append([]string{}, h.pins[room]...)
+		for client := range h.rooms[room] {
+			snapshot.Users = append(snapshot.Users, client.username)
+			snapshot.Connections = append(snapshot.Connections, client.info())
+		}
+	})
+	return snapshot
+}
+
+// Connections lists this node's connections, oldest first, up to limit
+func (h *LocalHub) Connections(limit int) []ConnectionInfo {
+	conns := []ConnectionInfo{}
+	h.query(func() {
+		for client := range h.clients {
+			conns = append(conns, client.info())
+		}
+	})
+	slices.SortFunc(conns, func(a, b ConnectionInfo) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+	return conns[:min(limit, len(conns))]
+}
+
+// Connection looks up a connected client by connection ID
+func (h *LocalHub) Connection(id string) (ConnectionInfo, bool) {
+	var info ConnectionInfo
+	var ok bool
+	h.query(func() {
+		var client *Client
+		if client, ok = h.conns[id]; ok {
+			info = client.info()
+		}
+	})
+	return info, ok
+}
+
+// Run processes hub events until the process exits
+func (h *LocalHub) Run() {
+	// A panic here leaves every room broken, so report it and crash loudly
+	defer errreport.Repanic(errreport.Context{})
+
+	housekeeping := time.NewTicker(housekeepingInterval)
+	defer housekeeping.Stop()
+	retries := time.NewTicker(time.Second)
+	defer retries.Stop()
+
+	var heartbeats <-chan time.Time
+	if h.heartbeat != nil {
+		ticker := time.NewTicker(h.heartbeat.interval)
+		defer ticker.Stop()
+		heartbeats = ticker.C
+	}
+
+	var lifetimeChecks <-chan time.Time
+	if h.lifetime != nil {
+		ticker := time.NewTicker(h.lifetime.checkInterval())
+		defer ticker.Stop()
+		lifetimeChecks = ticker.C
+	}
+
+	var idleChecks <-chan time.Time
+	if h.idle != nil {
+		ticker := time.NewTicker(h.idle.checkInterval())
+		defer ticker.Stop()
+		idleChecks = ticker.C
+	}
+
+	// Pick up where the last process left off before serving anyone
+	var snapshots <-chan time.Time
+	if h.statePath != "" {
+		h.loadState()
+		ticker := time.NewTicker(h.stateInterval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
+
+	// Room messages and offline queues are stored off this goroutine
+	// (see writethrough.go)
+	for _, queue := range h.writers {
+		go h.runWriter(queue)
+	}
+
 	for {
 		select {
 		case client := <-h.register:
@@ -60,32 +503,222 @@ func (h *Hub) Run() {
 			h.handleUnregister(client)
 		case message := <-h.broadcast:
 			h.handleBroadcast(message)
+		case frame := <-h.remote:
+			h.handleFrame(frame)
+		case frame := <-h.federated:
+			h.handleFederated(frame)
+		case <-h.ringChanged:
+			h.handleRingChange()
+		case fn := <-h.queries:
+			fn()
+		case now := <-housekeeping.C:
+			h.acks.expire(now)
+			h.expireTransfers(now)
+			h.sweepWatches(now)
+			h.sweepHighlights()
+			h.refreshFederation(now)
+			h.expireBreakouts(now)
+			h.forgetSeqs()
+		case now := <-retries.C:
+			h.retryDeliveries(now)
+			h.refreshStalePresence()
+		case now := <-heartbeats:
+			h.sendHeartbeats(now)
+		case now := <-idleChecks:
+			h.checkIdle(now)
+		case now := <-lifetimeChecks:
+			h.checkLifetimes(now)
+		case now := <-snapshots:
+			h.saveState(now)
 		}
 	}
 }
 
-func (h *Hub) handleRegister(client *Client) {
+func (h *LocalHub) handleRegister(client *Client) {
+	// Apply the duplicate login policy (see logins.go)
+	if !h.admitLogin(client) {
+		return
+	}
+
 	// Create room if needed
 	if _, exists := h.rooms[client.room]; !exists {
 		h.rooms[client.room] = make(map[*Client]bool)
+		metrics.ActiveRooms.Inc()
+		h.resumeSeq(client.room)
 	}
-	
+
 	// Add client to room and global list
+	client.lastActive = client.connectedAt
 	h.rooms[client.room][client] = true
+	h.joinBreakout(client)
 	h.clients[client] = true
+	if h.users[client.username] == nil {
+		h.users[client.username] = make(map[*Client]bool)
+	}
+	h.users[client.username][client] = true
+	h.conns[client.id] = client
+	metrics.ConnectionsOpened.Inc()
+	metrics.ActiveConnections.Inc()
+	metrics.Recent.Inc(metrics.EventConnect)
+	metrics.Recent.Observe(len(h.clients), len(h.rooms))
+	metrics.RoomActiveUsers.WithLabelValues(h.roomStats(client.room).label).Inc()
+	h.meter.Connected(client.id, client.room, client.username, client.connectedAt)
+
+	// Greet first so clients can check the protocol before anything else
+	h.sendTo(client, helloMessage(client, h.Protocol()))
+	h.sendArchived(client)
 
-	// Send online users list
+	h.recordEvent(storage.Event{
+		Room:     client.room,
+		Type:     storage.EventJoin,
+		Username: client.username,
+		Data:     map[string]string{"conn": client.id},
+	})
+
+	// Send the joiner the member list, then tell the room
+	h.sendPresenceSnapshot(client)
 	h.broadcastRoomUsers(client.room)
+
+	// Reconnect storms and room hopping from one client get it flagged
+	h.observeConnect(client)
+
+	// Hand over whatever the connections this one replaced hadn't acked
+	h.finishTakeover(client, time.Now())
+
+	// Have the user's highlight words ready for the room's messages
+	h.loadHighlights(client.username, time.Now())
+
+	// Read the user's avatar, queued messages and welcome off this
+	// goroutine; they follow once read (see arrived)
+	h.loadArrival(client)
+
+	// Offer the room's sticker packs
+	h.sendStickerPacks(client)
+}
+
+// arrival is what waited in the store for a joining client's user
+type arrival struct {
+	avatar     string
+	offline    []Message               // Durable messages queued while away
+	direct     []storage.DirectMessage // Direct messages queued while away
+	onboarding []Message               // The room's welcome, the first time
+}
+
+// loadArrival reads what waits for a joining client's user off the
+// hub goroutine and hands it to arrived
+func (h *LocalHub) loadArrival(client *Client) {
+	dms := client.accepts("dm")
+	go func() {
+		a := arrival{
+			avatar:     h.lookupAvatar(client),
+			offline:    h.takeOffline(client),
+			onboarding: h.onboarding(client),
+		}
+		if dms {
+			a.direct = h.takeDirect(client)
+		}
+		h.queries <- func() { h.arrived(client, a, time.Now()) }
+	}()
+}
+
+// arrived shows a joined client's avatar to its room and hands it what
+// waited for its user; if it has left meanwhile, the queued messages
+// are queued again
+func (h *LocalHub) arrived(client *Client, a arrival, now time.Time) {
+	if !h.clients[client] {
+		h.requeueArrival(client, a)
+		return
+	}
+	if a.avatar != "" && client.avatar == "" { // SetAvatar may have set a newer one
+		client.avatar = a.avatar
+		h.broadcastRoomUsers(client.room)
+	}
+	h.deliverOffline(client, a.offline, now)
+	h.deliverQueuedDirect(client, a.direct)
+	for _, msg := range a.onboarding {
+		h.sendTo(client, msg)
+	}
+}
+
+// requeueArrival puts back the messages taken for a client that left
+// before they could be handed over
+func (h *LocalHub) requeueArrival(client *Client, a arrival) {
+	if len(a.offline) == 0 && len(a.direct) == 0 {
+		return
+	}
+	username, room := client.username, client.room
+	h.storeInOrder(room, func() {
+		for _, msg := range a.offline {
+			h.queueOffline(username, room, msg.ID)
+		}
+		for _, dm := range a.direct {
+			h.requeueDirect(dm)
+		}
+	})
+}
+
+// senderData names the connection a message was sent from, for the event log
+func senderData(msg Message) map[string]string {
+	switch {
+	case msg.sender != nil:
+		return map[string]string{"conn": msg.sender.id}
+	case msg.origin != nil && msg.origin.conn != "":
+		return map[string]string{"conn": msg.origin.conn, "node": msg.origin.node}
+	}
+	return nil
+}
+
+// messageData is the event log data for a posted message
+func messageData(msg Message) map[string]string {
+	data := senderData(msg)
+	if msg.Sticker != nil {
+		if data == nil {
+			data = make(map[string]string)
+		}
+		data["sticker"] = msg.Sticker.Pack + "/" + msg.Sticker.ID
+	}
+	if msg.Audio != nil {
+		if data == nil {
+			data = make(map[string]string)
+		}
+		data["audio"] = msg.Audio.ID
+	}
+	if msg.Attachment != nil {
+		if data == nil {
+			data = make(map[string]string)
+		}
+		data["attachment"] = msg.Attachment.ID
+	}
+	if msg.Quote != nil {
+		if data == nil {
+			data = make(map[string]string)
+		}
+		msg.Quote.AddTo(data)
+	}
+	if msg.Origin != "" {
+		if data == nil {
+			data = make(map[string]string)
+		}
+		data["origin"] = msg.Origin // What backfill.go compares
+		data["origin_seq"] = strconv.FormatUint(msg.originSeq, 10)
+	}
+	return data
 }
 
-func (h *Hub) handleUnregister(client *Client) {
+// recordEvent appends to the room event log when one is configured
+func (h *LocalHub) recordEvent(ev storage.Event) {
+	if h.events != nil {
+		h.events.Record(ev)
+	}
+}
+
+func (h *LocalHub) handleUnregister(client *Client) {
 	if _, exists := h.clients[client]; !exists {
 		return
 	}
 
 	// Remove client
-	delete(h.clients, client)
-	delete(h.rooms[client.room], client)
+	h.removeClient(client, client.closeReason)
 
 	// Notify room and update user list
 	h.handleBroadcast(Message{
@@ -97,46 +730,374 @@ func (h *Hub) handleUnregister(client *Client) {
 	h.broadcastRoomUsers(client.room)
 
 	// Clean up empty room
-	if len(h.rooms[client.room]) == 0 {
-		delete(h.rooms, client.room)
+	h.closeRoomIfEmpty(client.room)
+}
+
+// removeClient drops a client from the global list and its room
+// reason is recorded in the disconnect metrics
+func (h *LocalHub) removeClient(client *Client, reason string) {
+	delete(h.clients, client)
+	delete(h.rooms[client.room], client)
+	if delete(h.users[client.username], client); len(h.users[client.username]) == 0 {
+		delete(h.users, client.username)
+	}
+	delete(h.conns, client.id)
+	delete(h.resumes, client.resumeToken)
+	h.dropPending(client)
+	h.dropTransfers(client)
+	h.recordEvent(storage.Event{
+		Room:     client.room,
+		Type:     storage.EventLeave,
+		Username: client.username,
+		Data:     map[string]string{"reason": reason, "conn": client.id},
+	})
+	metrics.RoomActiveUsers.WithLabelValues(h.roomStats(client.room).label).Dec()
+	h.meter.Disconnected(client.id, time.Now())
+
+	if reason == "" {
+		reason = closeReasonError
 	}
+	metrics.ActiveConnections.Dec()
+	metrics.ConnectionsClosed.WithLabelValues(reason).Inc()
+	metrics.ConnectionDuration.Observe(time.Since(client.connectedAt).Seconds())
+	metrics.Recent.Inc(metrics.EventDisconnect)
+	h.logSession(client, reason)
 }
 
-func (h *Hub) broadcastRoomUsers(room string) {
-	users := []string{}
-	if roomClients, exists := h.rooms[room]; exists {
-		for client := range roomClients {
-			users = append(users, client.username)
+// closeRoomIfEmpty deletes a room and its stats once the last client leaves
+// The owner of a clustered room keeps its sequence while other nodes have
+// members, and a draining hub keeps every sequence for the state snapshot
+func (h *LocalHub) closeRoomIfEmpty(room string) {
+	if clients, exists := h.rooms[room]; exists && len(clients) == 0 {
+		delete(h.rooms, room)
+		metrics.ActiveRooms.Dec()
+	}
+	if !h.roomActive(room) {
+		// Clients leaving a draining hub resume elsewhere, so keep their
+		// place, as does a message still being stored
+		if !h.draining.Load() && h.unsaved[room] == 0 {
+			delete(h.seqs, room)
+		}
+		h.dropRoomStats(room)
+		delete(h.presence, room)
+		if !h.draining.Load() {
+			h.breakoutEmptied(room, time.Now())
 		}
 	}
+}
 
-	h.handleBroadcast(Message{
-		Type:     "online_users",
-		Content:  strings.Join(users, ","),
-		RoomName: room,
-	})
+func (h *LocalHub) handleBroadcast(msg Message) {
+	// Fan-out latency is measured from the moment the hub picks the message up
+	received := time.Now()
+
+	ctx := msg.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "hub.dispatch", trace.WithAttributes(
+		attribute.String("chat.room", msg.RoomName),
+		attribute.String("chat.message_type", msg.Type),
+	))
+	defer span.End()
+
+	// Commands from clients are handled, not broadcast
+	if msg.sender != nil && msg.Type == "ack" {
+		h.handleDeliveryAck(msg.sender, msg.ID)
+		return
+	}
+
+	// A message back from a storage lookup was seen the first time round
+	if msg.sender != nil && msg.lookup == nil {
+		h.observeActivity(msg.sender, msg.Type, received)
+	}
+
+	// Neither a takeover nor a refused login is a join as far as the
+	// room is concerned (see logins.go)
+	if msg.sender != nil && msg.Type == "user_joined" && (len(msg.sender.replaced) > 0 || !h.clients[msg.sender]) {
+		return
+	}
+
+	if msg.sender != nil && msg.Type == "hello" {
+		h.handleHello(msg.sender, msg.Features)
+		return
+	}
+
+	if msg.sender != nil && msg.Type == "heartbeat" {
+		h.handleHeartbeat(msg.sender, received)
+		return
+	}
+
+	if msg.sender != nil && msg.Type == "sync" {
+		h.handleSync(msg.sender, *msg.Sync, received)
+		return
+	}
+
+	if msg.sender != nil && msg.Type == "set_preferences" {
+		h.handleSetPreferences(msg.sender, *msg.Preferences)
+		return
+	}
+
+	if msg.sender != nil && msg.Type == "unmute" {
+		h.handleUnmute(msg.sender)
+		return
+	}
+
+	// Direct messages aren't room traffic, so no owner is involved
+	if msg.sender != nil && msg.Type == "dm" {
+		h.handleDirect(msg.sender, msg, received)
+		return
+	}
+
+	// Private messages (acks, errors) skip the room entirely
+	if msg.to != nil {
+		h.sendTo(msg.to, msg)
+		return
+	}
+
+	// In a cluster only the room's owner broadcasts; a message already
+	// forwarded is handled here even if ownership has moved since
+	if msg.origin == nil && h.forward(msg) {
+		span.SetAttributes(attribute.Bool("chat.forwarded", true))
+		return
+	}
+
+	// Reports go to the owner, which remembers the room's recent messages
+	if msg.Type == "report" {
+		h.handleReport(msg)
+		return
+	}
+
+	// Pins are checked there too, against the recent messages
+	if isPin(msg.Type) && (msg.sender != nil || msg.origin != nil) && !h.resolvePin(&msg) {
+		return
+	}
+
+	// Recalls as well, which also need to know who sent the message
+	if msg.Type == "recall" && (msg.sender != nil || msg.origin != nil) && !h.resolveRecall(&msg) {
+		return
+	}
+
+	// File transfer handshakes too, which keeps each one on a single hub
+	if isTransfer(msg.Type) && (msg.sender != nil || msg.origin != nil) {
+		h.handleTransfer(msg, received)
+		return
+	}
+
+	// The owner forgets purged messages as their frame passes through
+	if msg.Type == "messages_purged" {
+		h.forgetPurged(msg)
+	}
+
+	// A retried send replays the original ack instead of a duplicate broadcast
+	var ackKey idempotencyKey
+	if msg.IdempotencyKey != "" && (msg.sender != nil || msg.origin != nil) {
+		ackKey = idempotencyKey{room: msg.RoomName, username: msg.Username, key: msg.IdempotencyKey}
+		if ack, ok := h.acks.get(ackKey, received); ok {
+			span.SetAttributes(attribute.Bool("chat.duplicate", true))
+			h.reply(msg, ack)
+			return
+		}
+		if h.storing[ackKey] {
+			span.SetAttributes(attribute.Bool("chat.duplicate", true))
+			return // The original's ack follows once it is stored
+		}
+	}
+	msg.IdempotencyKey = ""
+
+	// Stickers are looked up by the owner, which then sends them on complete
+	if msg.Type == "sticker" && (msg.sender != nil || msg.origin != nil) && !h.resolveSticker(&msg) {
+		return
+	}
+	// Voice notes are claimed the same way, so each clip is posted once
+	if msg.Type == "audio" && (msg.sender != nil || msg.origin != nil) && !h.resolveAudio(&msg) {
+		return
+	}
+	// And replies quote what the owner finds, so every node quotes alike
+	if msg.Quote != nil && (msg.sender != nil || msg.origin != nil) && !h.resolveQuote(&msg) {
+		return
+	}
+
+	// Under overload, presence goes before chat
+	if !h.admitBroadcast(msg, received) {
+		span.SetAttributes(attribute.Bool("chat.shed", true))
+		return
+	}
+
+	control := isControl(msg.Type)
+	if !control {
+		if msg.ID == "" {
+			msg.ID = newID()
+		}
+		if msg.ServerTime == 0 {
+			msg.ServerTime = received.UnixMilli() // As the event log's CreatedAt
+		}
+		st := h.roomStats(msg.RoomName)
+		st.messages++
+		st.rate.add(time.Now())
+		metrics.RoomMessages.WithLabelValues(st.label).Inc()
+		metrics.Recent.Inc(metrics.EventMessage)
+	}
+
+	h.publish(publishing{ctx: ctx, msg: msg, ackKey: ackKey, received: received})
 }
 
-func (h *Hub) handleBroadcast(msg Message) {
+// deliver sends a message that passed every check, and was stored if it
+// had to be, to its room, then does what follows from that
+func (h *LocalHub) deliver(p publishing) {
+	msg, ackKey, received := p.msg, p.ackKey, p.received
+	control := isControl(msg.Type)
+	ctx, span := tracing.Tracer().Start(p.ctx, "hub.deliver")
+	defer span.End()
+
 	jsonMsg, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		errreport.CaptureError(err, errreport.Context{Room: msg.RoomName, Username: msg.Username})
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "marshal failed")
+		return
+	}
+
+	h.fanout(ctx, msg.RoomName, msg.Type, jsonMsg, control, received)
+	h.relay(ctx, msg)
+
+	if !control {
+		h.meter.Message(msg.RoomName, msg.Username, len(jsonMsg), received)
+		h.recordEvent(storage.Event{
+			Room:      msg.RoomName,
+			Type:      storage.EventMessage,
+			Username:  msg.Username,
+			MessageID: msg.ID,
+			Seq:       msg.Seq,
+			Content:   msg.Content,
+			Data:      messageData(msg),
+			CreatedAt: received,
+		})
+	}
+	if reliable(msg.QoS) {
+		h.trackDeliveries(msg, received)
+	}
+	if msg.QoS == QoSDurable {
+		h.queueForOfflineMembers(msg)
+	}
+	if !control {
+		h.recent.add(msg)
+		h.noteBreakout(msg)
+	}
+	if msg.Type == "chat" {
+		h.alertModerators(msg, received)
+		h.highlightMembers(msg, received)
+		h.submitForModeration(msg)
+		h.submitForNotification(msg, received)
+		h.federate(msg)
+	}
+
+	// Acknowledge to the sender and remember the ack for retries
+	if ackKey.key != "" {
+		ack := Message{
+			Type:           "ack",
+			ID:             msg.ID,
+			RoomName:       msg.RoomName,
+			Username:       msg.Username,
+			Seq:            msg.Seq,
+			IdempotencyKey: ackKey.key,
+		}
+		h.acks.put(ackKey, ack, received)
+		h.reply(msg, ack)
+	}
+}
+
+// sendTo delivers a message to a single client if it is still connected
+func (h *LocalHub) sendTo(client *Client, msg Message) {
+	if !h.clients[client] || !client.accepts(msg.Type) {
+		return
+	}
+	msg.to, msg.sender = nil, nil
+	if msg.Type == "error" || msg.Type == "hello" {
+		msg.ConnID = client.id
+	}
+	if msg.Type == "error" {
+		defer h.observeError(client, msg.Code)
+	}
+
+	payload, err := json.Marshal(msg)
 	if err != nil {
 		log.Printf("Error marshaling message: %v", err)
 		return
 	}
 
+	if !h.enqueue(client, payload, isControl(msg.Type)) {
+		close(client.send)
+		h.removeClient(client, closeReasonOverflow)
+		h.closeRoomIfEmpty(client.room)
+	}
+}
+
+// enqueue queues payload on the client's chat or control lane without blocking
+// It returns false if the lane is full
+func (h *LocalHub) enqueue(client *Client, payload []byte, control bool) bool {
+	lane := client.send
+	if control {
+		lane = client.control
+	}
+	select {
+	case lane <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+// isControl reports whether a message type travels on the priority lane
+// Everything but what members post to the room is control traffic
+func isControl(msgType string) bool {
+	return msgType != "chat" && msgType != "sticker" && msgType != "audio" && msgType != "attachment"
+}
+
+// fanout delivers an encoded message to every client in the room that
+// accepts its type; control selects the priority lane; received is when
+// the hub picked up the message, used for latency tracking
+func (h *LocalHub) fanout(ctx context.Context, room, msgType string, payload []byte, control bool, received time.Time) {
+	_, span := tracing.Tracer().Start(ctx, "hub.fanout")
+	defer span.End()
+
+	delivered, dropped := 0, 0
+
 	// Send to all clients in the room
-	if roomClients, exists := h.rooms[msg.RoomName]; exists {
+	if roomClients, exists := h.rooms[room]; exists {
 		for client := range roomClients {
-			select {
-			case client.send <- jsonMsg:
+			if !client.accepts(msgType) {
+				continue
+			}
+			if h.enqueue(client, payload, control) {
 				// Message sent successfully
-			default:
+				delivered++
+			} else {
 				// Client's buffer is full, remove them
 				close(client.send)
-				delete(h.clients, client)
-				delete(h.rooms[msg.RoomName], client)
+				h.removeClient(client, closeReasonOverflow)
+				dropped++
 			}
 		}
 	}
-}
 
+	if dropped > 0 {
+		st := h.roomStats(room)
+		st.dropped += uint64(dropped)
+		metrics.RoomDroppedSends.WithLabelValues(st.label).Add(float64(dropped))
+		h.closeRoomIfEmpty(room)
+	}
+
+	// Time until the last recipient's send channel was written
+	elapsed := time.Since(received)
+	recipients := delivered + dropped
+	metrics.FanoutDuration.WithLabelValues(metrics.RoomSizeClass(recipients)).Observe(elapsed.Seconds())
+	if elapsed > slowFanoutThreshold {
+		log.Printf("Slow fan-out: room=%s recipients=%d dropped=%d took=%v", room, recipients, dropped, elapsed)
+	}
+
+	span.SetAttributes(
+		attribute.Int("chat.recipients", delivered),
+		attribute.Int("chat.dropped", dropped),
+	)
+}
@@ -0,0 +1,131 @@
+package websockets
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+Hub Benchmark Overview:
+----------------------
+Throughput benchmarks for the broadcast path over a matrix of client
+counts, room counts and message sizes:
+
+	go test ./websockets -run '^$' -bench Broadcast > old.txt
+
+Clients are in-memory stubs: they have a real send channel drained by
+a goroutine but no network connection, so the numbers reflect hub
+work (marshal, fan-out, channel sends) rather than socket I/O. Each
+op is one chat message broadcast to one room, cycling through the
+rooms round-robin.
+*/
+
+// benchDrainTimeout bounds how long we wait for stubs to receive everything
+const benchDrainTimeout = 10 * time.Second
+
+func BenchmarkBroadcast(b *testing.B) {
+	for _, clients := range []int{10, 100, 1000} {
+		for _, rooms := range []int{1, 10} {
+			for _, size := range []int{64, 512} {
+				name := fmt.Sprintf("clients=%d/rooms=%d/size=%d", clients, rooms, size)
+				b.Run(name, func(b *testing.B) {
+					benchmarkBroadcast(b, clients, rooms, size)
+				})
+			}
+		}
+	}
+}
+
+// benchmarkBroadcast broadcasts b.N messages of size bytes through a
+// fresh hub with clients stub clients spread over rooms
+func benchmarkBroadcast(b *testing.B, clients, rooms, size int) {
+	h := NewHub()
+	go h.Run()
+
+	// Step 1: Register stub clients, each drained by its own goroutine
+	var delivered atomic.Int64
+	stubs := make([]*Client, clients)
+	for i := range stubs {
+		c := newClient(h, nil, fmt.Sprintf("bench-%d", i%rooms), fmt.Sprintf("user-%d", i), newID())
+		stubs[i] = c
+		go func() {
+			for {
+				select {
+				case _, ok := <-c.send:
+					if !ok {
+						return
+					}
+					delivered.Add(1)
+				case <-c.control:
+					// Presence traffic isn't part of the measurement
+				}
+			}
+		}()
+		h.register <- c
+	}
+	waitForDrain(stubs)
+
+	content := strings.Repeat("x", size)
+
+	// Step 2: Broadcast b.N messages
+	delivered.Store(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.broadcast <- Message{
+			Type:     "chat",
+			Content:  content,
+			RoomName: fmt.Sprintf("bench-%d", i%rooms),
+			Username: "bench",
+		}
+	}
+
+	// Step 3: Count time until every stub has consumed its copies
+	waitForDrain(stubs)
+	b.StopTimer()
+
+	// Stubs that couldn't keep up were dropped by the hub
+	dropped := 0
+	h.query(func() {
+		for _, c := range stubs {
+			if !h.clients[c] {
+				dropped++
+			}
+		}
+	})
+	b.ReportMetric(float64(delivered.Load())/b.Elapsed().Seconds(), "deliveries/s")
+	b.ReportMetric(float64(dropped), "dropped_clients")
+
+	// Step 4: Tear down so stubs don't pile up across runs
+	// Stubs dropped for overflow already had their channel closed by the hub
+	for _, c := range stubs {
+		var registered bool
+		h.query(func() { registered = h.clients[c] })
+		if registered {
+			h.unregister <- c
+			close(c.send)
+		}
+	}
+}
+
+// waitForDrain blocks until every stub has emptied its send buffer
+func waitForDrain(clients []*Client) {
+	deadline := time.Now().Add(benchDrainTimeout)
+	for time.Now().Before(deadline) {
+		pending := false
+		for _, c := range clients {
+			if len(c.send) > 0 || len(c.control) > 0 {
+				pending = true
+				break
+			}
+		}
+		if !pending {
+			return
+		}
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,31 @@
+package websockets_test
+
+import (
+	"testing"
+	"time"
+
+	"chat-app/wstest"
+)
+
+func TestJoinLeave(t *testing.T) {
+	srv := wstest.NewServer(t)
+	alice := srv.Connect(t, "lobby", "alice")
+	bob := srv.Connect(t, "lobby", "bob")
+	alice.ExpectJoin("bob")
+
+	bob.Close()
+	alice.ExpectLeave("bob")
+}
+
+func TestBroadcast(t *testing.T) {
+	srv := wstest.NewServer(t)
+	alice := srv.Connect(t, "lobby", "alice")
+	bob := srv.Connect(t, "lobby", "bob")
+	carol := srv.Connect(t, "other", "carol")
+	alice.ExpectJoin("bob")
+
+	bob.Send("hi")
+	alice.ExpectChat("bob", "hi")
+	bob.ExpectChat("bob", "hi")
+	carol.ExpectSilence(200 * time.Millisecond) // Other rooms don't hear it
+}
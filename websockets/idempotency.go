@@ -0,0 +1,82 @@
+package websockets
+
+import "time"
+
+/*
+Idempotency Overview:
+--------------------
+A client that sends a message and loses its connection before the
+ack arrives can't tell whether the message went through. Sending a
+frame with an idempotency key lets it safely retry after reconnecting:
+if the hub has already accepted that key from the same user in the
+same room, it replays the original ack instead of broadcasting (and
+storing) the message a second time.
+
+Acks are remembered for idempotencyTTL, which should comfortably
+exceed any client's reconnect-and-retry window.
+*/
+
+const (
+	// How long an ack is kept for replay
+	idempotencyTTL = 10 * time.Minute
+
+	// Upper bound on remembered acks; oldest entries are evicted first
+	maxIdempotencyEntries = 100000
+)
+
+// idempotencyKey scopes a client key to the sender and room
+type idempotencyKey struct {
+	room     string
+	username string
+	key      string
+}
+
+type idempotencyEntry struct {
+	ack     Message
+	expires time.Time
+}
+
+// idempotencyCache remembers recent acks; owned by the hub goroutine
+type idempotencyCache struct {
+	entries map[idempotencyKey]idempotencyEntry
+	order   []idempotencyKey // Insertion order for expiry and eviction
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[idempotencyKey]idempotencyEntry)}
+}
+
+// get returns the stored ack for k if it hasn't expired
+func (c *idempotencyCache) get(k idempotencyKey, now time.Time) (Message, bool) {
+	e, ok := c.entries[k]
+	if !ok || now.After(e.expires) {
+		return Message{}, false
+	}
+	return e.ack, true
+}
+
+// put remembers the ack for k
+func (c *idempotencyCache) put(k idempotencyKey, ack Message, now time.Time) {
+	if _, exists := c.entries[k]; !exists {
+		c.order = append(c.order, k)
+	}
+	c.entries[k] = idempotencyEntry{ack: ack, expires: now.Add(idempotencyTTL)}
+
+	for len(c.order) > maxIdempotencyEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// expire drops entries past their TTL
+func (c *idempotencyCache) expire(now time.Time) {
+	i := 0
+	for ; i < len(c.order); i++ {
+		e, ok := c.entries[c.order[i]]
+		if ok && now.Before(e.expires) {
+			break // Entries are in insertion order, so the rest are newer
+		}
+		delete(c.entries, c.order[i])
+	}
+	c.order = c.order[i:]
+}
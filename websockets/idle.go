@@ -0,0 +1,107 @@
+package websockets
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Idle Overview:
+-------------
+Pings and heartbeats prove a client is running, not that anyone is
+using it: a forgotten tab holds a slot on a busy public server
+forever. With WithIdleTimeout the hub tracks when each connection last
+did something, meaning any frame but heartbeats, acks and hellos, and
+acts on those idle for longer than the timeout:
+
+	away        The member shows as away, as with missed heartbeats
+	            (see heartbeat.go), until the connection does something
+	disconnect  The connection is closed with code 4003 and reason
+	            idle_timeout
+
+Connections are checked every idleCheckFraction of the timeout, so
+they may stay up to that much longer.
+*/
+
+// Idle actions
+const (
+	IdleAway       = "away"
+	IdleDisconnect = "disconnect"
+)
+
+// Close frame sent to idle connections; also recorded as the close reason
+const (
+	closeReasonIdle = "idle_timeout"
+	closeCodeIdle   = 4003
+)
+
+// idleCheckFraction of the timeout is how often connections are checked
+const idleCheckFraction = 10
+
+// idleConfig is how long a connection may do nothing, and what then
+type idleConfig struct {
+	timeout time.Duration
+	action  string
+}
+
+// WithIdleTimeout marks away, or disconnects, connections that send
+// nothing but keepalives for timeout; action is IdleAway or
+// IdleDisconnect, and timeout <= 0 disables it
+func WithIdleTimeout(timeout time.Duration, action string) HubOption {
+	return func(h *LocalHub) {
+		if timeout <= 0 {
+			return
+		}
+		h.idle = &idleConfig{timeout: timeout, action: action}
+	}
+}
+
+// checkInterval is how often the hub looks for idle connections
+func (c *idleConfig) checkInterval() time.Duration {
+	return max(c.timeout/idleCheckFraction, time.Second)
+}
+
+// isActivity reports whether a frame of msgType, sent by a client, means
+// someone is using it
+func isActivity(msgType string) bool {
+	switch msgType {
+	case "heartbeat", "ack", "hello", "user_joined":
+		return false
+	}
+	return true
+}
+
+// observeActivity records that client did something, bringing it back
+// if it was idle
+func (h *LocalHub) observeActivity(client *Client, msgType string, now time.Time) {
+	if !isActivity(msgType) || !h.clients[client] {
+		return
+	}
+	client.lastActive = now
+	if client.idle {
+		client.idle = false
+		h.broadcastRoomUsers(client.room)
+	}
+}
+
+// checkIdle marks away or drops connections idle past the timeout
+func (h *LocalHub) checkIdle(now time.Time) {
+	changed := make(map[string]bool)
+	for client := range h.clients {
+		if client.idle || now.Sub(client.lastActive) < h.idle.timeout {
+			continue
+		}
+		if h.idle.action == IdleDisconnect {
+			client.logf("idle for %s, disconnecting", now.Sub(client.lastActive).Round(time.Second))
+			client.closeFrame = websocket.FormatCloseMessage(closeCodeIdle, closeReasonIdle)
+			h.disconnect(client, closeReasonIdle)
+			continue
+		}
+		client.idle = true
+		changed[client.room] = true
+	}
+	for room := range changed {
+		h.broadcastRoomUsers(room)
+	}
+}
@@ -0,0 +1,280 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/permission"
+	"chat-app/storage"
+)
+
+/*
+Invite-Only Room Overview:
+-------------------------
+An invite-only room (see storage.InvitePolicy) can only be joined by
+the users on its list, by anyone with one of its invite tokens, and by
+its owners, who hold the invite capability (see permissions.go).
+Everyone else is refused with 403 before the upgrade:
+
+	{"error": "this room is invite-only", "code": "not_invited"}
+
+A token is given as ?invite=<token> on the WebSocket URL; joining
+with one puts the user on the list for good. Owners manage the room
+over REST (see api/invites.go) or from a connection to it:
+
+	{"type": "invite_only", "content": "on"}   ("off" to open it again)
+	{"type": "invite", "content": "bob"}
+	{"type": "uninvite", "content": "bob"}
+	{"type": "invite_token", "for": "24h"}     (no for: until revoked)
+	{"type": "revoke_invite", "id": "<token>"}
+
+Each is answered with the room's invites; after invite_token, content
+is the new token:
+
+	{"type": "invites", "room": "lobby", "content": "9f2c...", "invites": {"only": true, "users": [...], "tokens": [...]}}
+
+Being taken off the list doesn't disconnect anyone; it stops them
+joining again. Other nodes see changes with the room settings, within
+roomSettingsTTL.
+*/
+
+// Error codes for invites
+const errCodeUnknownInvite = "unknown_invite"
+
+// ErrInvalidInvites is returned, wrapped, for changes that would leave a
+// room's invites out of bounds
+var ErrInvalidInvites = errors.New("invalid invites")
+
+// inviteChecker is implemented by hubs that keep invite-only rooms to
+// those invited
+type inviteChecker interface {
+	Invited(room, username, token string) bool
+}
+
+// inviteManager is implemented by hubs whose rooms' owners can change
+// who is invited
+type inviteManager interface {
+	SetInviteOnly(ctx context.Context, room string, only bool) (storage.InvitePolicy, error)
+	Invite(ctx context.Context, room, username string) (storage.InvitePolicy, error)
+	Uninvite(ctx context.Context, room, username string) (storage.InvitePolicy, error)
+	CreateInvite(ctx context.Context, room, by string, ttl time.Duration) (storage.InviteToken, storage.InvitePolicy, error)
+	RevokeInvite(ctx context.Context, room, token string) (storage.InvitePolicy, error)
+}
+
+// ownsInvites reports whether username holds the invite capability in
+// room, and so may always join it
+func ownsInvites(ctx context.Context, a *permission.Authorizer, room, username string) bool {
+	return a != nil && a.Authorize(ctx, room, username, storage.CapInvite) == nil
+}
+
+// Invited reports whether username may join room: it isn't invite-only,
+// they're on its list, or token is one of its invites, which puts them
+// on the list
+// Safe to call from any goroutine
+func (h *LocalHub) Invited(room, username, token string) bool {
+	now := time.Now()
+	policy := h.settings.get(room).Invites
+	if !policy.Only || policy.Invited(username) {
+		return true
+	}
+	if !policy.Valid(token, now) {
+		return false
+	}
+
+	ctx, cancel := storageContext()
+	defer cancel()
+	_, err := h.editInvites(ctx, room, func(p *storage.InvitePolicy) error {
+		if !p.Valid(token, now) {
+			return storage.ErrNotFound // Revoked since it was cached
+		}
+		p.Add(username)
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		// The token is good; they just won't be remembered
+		reportStorageError("redeem invite", err, errreport.Context{Room: room, Username: username})
+	}
+	return true
+}
+
+// Invites returns room's invite policy
+// Safe to call from any goroutine
+func (h *LocalHub) Invites(ctx context.Context, room string) (storage.InvitePolicy, error) {
+	settings, err := h.store.GetRoomSettings(ctx, room)
+	if errors.Is(err, storage.ErrNotFound) {
+		return storage.InvitePolicy{}, nil
+	}
+	return settings.Invites, err
+}
+
+// SetInviteOnly makes room invite-only, or opens it again
+// Safe to call from any goroutine
+func (h *LocalHub) SetInviteOnly(ctx context.Context, room string, only bool) (storage.InvitePolicy, error) {
+	return h.editInvites(ctx, room, func(p *storage.InvitePolicy) error {
+		p.Only = only
+		return nil
+	})
+}
+
+// Invite puts username on room's list; inviting them twice changes nothing
+// Safe to call from any goroutine
+func (h *LocalHub) Invite(ctx context.Context, room, username string) (storage.InvitePolicy, error) {
+	return h.editInvites(ctx, room, func(p *storage.InvitePolicy) error {
+		p.Add(username)
+		return nil
+	})
+}
+
+// Uninvite takes username off room's list; they stay connected if they are
+// Safe to call from any goroutine
+func (h *LocalHub) Uninvite(ctx context.Context, room, username string) (storage.InvitePolicy, error) {
+	return h.editInvites(ctx, room, func(p *storage.InvitePolicy) error {
+		p.Remove(username)
+		return nil
+	})
+}
+
+// CreateInvite makes an invite token for room, good for ttl or, with
+// ttl <= 0, until revoked; by names the owner who made it
+// Safe to call from any goroutine
+func (h *LocalHub) CreateInvite(ctx context.Context, room, by string, ttl time.Duration) (storage.InviteToken, storage.InvitePolicy, error) {
+	token := storage.InviteToken{Token: newID() + newID(), By: by}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl).UTC()
+		token.Expires = &expires
+	}
+	policy, err := h.editInvites(ctx, room, func(p *storage.InvitePolicy) error {
+		p.Tokens = append(p.Tokens, token)
+		return nil
+	})
+	return token, policy, err
+}
+
+// RevokeInvite drops one of room's invite tokens, returning
+// storage.ErrNotFound if there is no such token
+// Safe to call from any goroutine
+func (h *LocalHub) RevokeInvite(ctx context.Context, room, token string) (storage.InvitePolicy, error) {
+	return h.editInvites(ctx, room, func(p *storage.InvitePolicy) error {
+		if !p.Revoke(token) {
+			return storage.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// editInvites applies change to room's invite policy, dropping expired
+// tokens, and saves it; a change that fails validation returns
+// ErrInvalidInvites
+// Changes on this node are made one at a time so none is lost
+func (h *LocalHub) editInvites(ctx context.Context, room string, change func(*storage.InvitePolicy) error) (storage.InvitePolicy, error) {
+	h.invitesMu.Lock()
+	defer h.invitesMu.Unlock()
+
+	settings, err := h.store.GetRoomSettings(ctx, room)
+	if errors.Is(err, storage.ErrNotFound) {
+		settings = storage.DefaultRoomSettings(room)
+	} else if err != nil {
+		return storage.InvitePolicy{}, err
+	}
+
+	policy := settings.Invites
+	policy.Users = append([]string(nil), policy.Users...)
+	policy.Tokens = append([]storage.InviteToken(nil), policy.Tokens...)
+	policy.Prune(time.Now())
+	if err := change(&policy); err != nil {
+		return settings.Invites, err
+	}
+	if err := policy.Validate(); err != nil {
+		return settings.Invites, fmt.Errorf("%w: %v", ErrInvalidInvites, err)
+	}
+
+	settings.Invites = policy
+	settings.UpdatedAt = time.Now().UTC()
+	if err := h.store.SaveRoomSettings(ctx, settings); err != nil {
+		return settings.Invites, err
+	}
+	h.settings.forget(room)
+	return policy, nil
+}
+
+// manageInvites carries out an owner's invite frame and answers with
+// the room's invites
+func (c *Client) manageInvites(ctx context.Context, frame inboundFrame) {
+	invites, ok := c.hub.(inviteManager)
+	if !ok {
+		c.hub.Broadcast(errorMessage(c, errCodeUnknownType, "invites are not enabled"))
+		return
+	}
+
+	var (
+		policy storage.InvitePolicy
+		token  storage.InviteToken
+		err    error
+	)
+	switch frame.Type {
+	case "invite_only":
+		if frame.Content != "on" && frame.Content != "off" {
+			c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "content must be on or off"))
+			return
+		}
+		if !c.authorize(ctx, storage.CapInvite) {
+			return
+		}
+		policy, err = invites.SetInviteOnly(ctx, c.room, frame.Content == "on")
+	case "invite", "uninvite":
+		if frame.Content == "" {
+			c.hub.Broadcast(errorMessage(c, errCodeBadFrame, frame.Type+" frames need a username as content"))
+			return
+		}
+		if !c.authorize(ctx, storage.CapInvite) {
+			return
+		}
+		if frame.Type == "invite" {
+			policy, err = invites.Invite(ctx, c.room, frame.Content)
+		} else {
+			policy, err = invites.Uninvite(ctx, c.room, frame.Content)
+		}
+	case "invite_token":
+		var ttl time.Duration
+		if frame.For != "" {
+			ttl, err = time.ParseDuration(frame.For)
+			if err != nil || ttl <= 0 {
+				c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "for must be a positive duration, like 24h"))
+				return
+			}
+		}
+		if !c.authorize(ctx, storage.CapInvite) {
+			return
+		}
+		token, policy, err = invites.CreateInvite(ctx, c.room, c.username, ttl)
+	case "revoke_invite":
+		if frame.ID == "" {
+			c.hub.Broadcast(errorMessage(c, errCodeBadFrame, "revoke_invite frames need the token as id"))
+			return
+		}
+		if !c.authorize(ctx, storage.CapInvite) {
+			return
+		}
+		policy, err = invites.RevokeInvite(ctx, c.room, frame.ID)
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.hub.Broadcast(errorMessage(c, errCodeUnknownInvite, "no such invite token in this room"))
+		return
+	case errors.Is(err, ErrInvalidInvites):
+		c.hub.Broadcast(errorMessage(c, errCodeBadFrame, err.Error()))
+		return
+	case err != nil:
+		reportStorageError("save invites", err, c.reportContext())
+		c.hub.Broadcast(errorMessage(c, errCodeStorage, "invites could not be saved, try again"))
+		return
+	}
+	c.hub.Broadcast(Message{Type: "invites", Content: token.Token, RoomName: c.room, Username: c.username, Invites: &policy, to: c})
+}
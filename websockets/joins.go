@@ -0,0 +1,178 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/metrics"
+	"chat-app/ratelimit"
+	"chat-app/storage"
+)
+
+/*
+Join Gate Overview:
+------------------
+WithJoinGate enforces each room's join policy (see storage.JoinPolicy)
+before the upgrade, after auth and bans:
+
+1. Content: rooms marked NSFW (see storage.RoomMetadata) refuse users
+   whose age hasn't been confirmed with 403
+2. Account age: users known to the server for less than
+   min_account_age get 403, with Retry-After set to when they will
+   be old enough
+3. Join rate: a room accepts per_minute joins a minute. Over the
+   rate, joins are refused with 429 and Retry-After, or with the
+   queue action wait their turn if it comes within max_wait
+
+Account age comes from the embedder's AccountCreatedFunc when there
+is one, otherwise from when the server first saw the username; every
+connection attempt records it, so the clock starts the first time
+someone connects anywhere. Policies are cached like the link policy
+(see settings.go).
+*/
+
+// AccountCreatedFunc returns when username's account was created
+// ok is false for unknown users, who are treated as brand new
+type AccountCreatedFunc func(username string) (created time.Time, ok bool)
+
+// Join gate outcomes, used as metric labels
+const (
+	joinUnconfirmed = "age_unconfirmed"
+	joinTooNew      = "too_new"
+	joinRejected    = "rejected"
+	joinQueued      = "queued"
+)
+
+// JoinGate enforces room join policies; safe for concurrent use
+type JoinGate struct {
+	store    storage.Store
+	settings *settingsCache
+	created  AccountCreatedFunc // Nil to use first-seen times
+	rates    *ratelimit.Keyed   // Join buckets by room and rate
+}
+
+// NewJoinGate reads policies from store
+// created supplies account creation times; nil uses first-seen times
+func NewJoinGate(store storage.Store, created AccountCreatedFunc) *JoinGate {
+	return &JoinGate{
+		store:    store,
+		settings: newSettingsCache(store),
+		created:  created,
+		rates:    ratelimit.NewKeyed(),
+	}
+}
+
+// WithJoinGate applies room join policies to new connections
+func WithJoinGate(g *JoinGate) Option {
+	return func(o *handlerOptions) {
+		o.joins = g
+	}
+}
+
+// joinRefusal is why a join was turned away, as an HTTP response
+type joinRefusal struct {
+	status  int
+	retry   time.Duration
+	message string
+}
+
+// admit applies room's join policy to username, waiting if it queues
+// It returns nil once the caller may proceed; a client that hangs up
+// while queued is refused with a zero status
+func (g *JoinGate) admit(ctx context.Context, room, username string) *joinRefusal {
+	now := time.Now()
+	created := g.accountCreated(username, now)
+
+	// Step 1: Keep minors out of NSFW rooms
+	if g.AgeGated(room, username) {
+		metrics.JoinsGated.WithLabelValues(joinUnconfirmed).Inc()
+		return &joinRefusal{
+			status:  http.StatusForbidden,
+			message: "this room is marked NSFW; only accounts whose age is confirmed may join",
+		}
+	}
+
+	policy := g.settings.get(room).Joins
+	if !policy.Enabled() {
+		return nil
+	}
+
+	// Step 2: Keep out accounts made for the raid
+	if age := policy.MinAge(); age > 0 && now.Sub(created) < age {
+		metrics.JoinsGated.WithLabelValues(joinTooNew).Inc()
+		return &joinRefusal{
+			status:  http.StatusForbidden,
+			retry:   created.Add(age).Sub(now),
+			message: "your account must be at least " + age.String() + " old to join this room",
+		}
+	}
+	if policy.PerMinute <= 0 {
+		return nil
+	}
+
+	// Step 3: Pace joins; the rate is part of the key so changes apply at once
+	perMinute := float64(policy.PerMinute)
+	key := room + "\x00" + strconv.Itoa(policy.PerMinute)
+	wait, ok := g.rates.Reserve(key, perMinute/60, perMinute, now, policy.Wait())
+	if !ok {
+		metrics.JoinsGated.WithLabelValues(joinRejected).Inc()
+		return &joinRefusal{
+			status:  http.StatusTooManyRequests,
+			retry:   wait - policy.Wait(),
+			message: "this room is taking too many joins, retry later",
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+
+	metrics.JoinsGated.WithLabelValues(joinQueued).Inc()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return &joinRefusal{} // Client gave up; its slot goes unused
+	}
+}
+
+// AgeGated reports whether room is marked NSFW and username's age
+// hasn't been confirmed, so they may neither join it nor read it
+func (g *JoinGate) AgeGated(room, username string) bool {
+	return g.settings.get(room).Metadata.NSFW && !g.ageConfirmed(username)
+}
+
+// accountCreated returns when username's account was created, or first seen
+func (g *JoinGate) accountCreated(username string, now time.Time) time.Time {
+	if g.created != nil {
+		if created, ok := g.created(username); ok {
+			return created
+		}
+		return now
+	}
+
+	ctx, cancel := storageContext()
+	defer cancel()
+	first, err := g.store.FirstSeen(ctx, username, now)
+	if err != nil {
+		reportStorageError("record first seen", err, errreport.Context{Username: username})
+		return now // Fail closed: unknown users count as new
+	}
+	return first
+}
+
+// ageConfirmed reports whether username's age has been confirmed
+func (g *JoinGate) ageConfirmed(username string) bool {
+	ctx, cancel := storageContext()
+	defer cancel()
+	user, err := g.store.GetUser(ctx, username)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		reportStorageError("load user", err, errreport.Context{Username: username})
+	}
+	return err == nil && user.AgeConfirmed != nil // Fail closed
+}
@@ -0,0 +1,87 @@
+package websockets
+
+import (
+	"time"
+)
+
+/*
+Connection Lifetime Overview:
+----------------------------
+Connections can stay up for days, outliving the credentials they were
+opened with and pinning clients to whichever node they first reached.
+With WithMaxConnectionAge the hub asks each connection to reconnect
+once it is older than the limit, the same way a drain does (see
+reconnect.go), at a time drawn at random from the spread window:
+
+	{"type": "reconnect", "code": "max_age", "retry_after_ms": 41873,
+	 "resume_token": "9c1e...", "content": "please reconnect"}
+
+A client that opens its new connection with ?resume=<token> before
+closing the old one takes over from it, as with the replace duplicate
+login policy (see logins.go) and whatever the policy is: the room sees
+no leave or join, unacked deliveries move across, and the old
+connection is closed normally with reason resumed. The token only
+works on this node and for the same user and room, and lapses when
+the old connection closes; after that the client just joins again.
+
+Connections are checked every tenth of the age, and at most every
+housekeepingInterval.
+*/
+
+// closeReasonResumed is recorded for connections taken over with a resume token
+const closeReasonResumed = "resumed"
+
+// lifetimeConfig is how old connections may get and how their reconnects are spread
+type lifetimeConfig struct {
+	maxAge time.Duration
+	spread time.Duration
+}
+
+// WithMaxConnectionAge asks connections older than maxAge to reconnect,
+// spreading them over spread; maxAge <= 0 disables it
+func WithMaxConnectionAge(maxAge, spread time.Duration) HubOption {
+	return func(h *LocalHub) {
+		if maxAge <= 0 {
+			return
+		}
+		h.lifetime = &lifetimeConfig{maxAge: maxAge, spread: spread}
+	}
+}
+
+// checkInterval is how often the hub looks for old connections
+func (c *lifetimeConfig) checkInterval() time.Duration {
+	return min(max(c.maxAge/10, time.Second), housekeepingInterval)
+}
+
+// checkLifetimes asks connections past the maximum age to reconnect
+func (h *LocalHub) checkLifetimes(now time.Time) {
+	for client := range h.clients {
+		if client.redirected || now.Sub(client.connectedAt) < h.lifetime.maxAge {
+			continue
+		}
+		h.redirect(client, Redirect{Spread: h.lifetime.spread, Reason: ReconnectMaxAge, Resume: true})
+	}
+}
+
+// issueResume returns a token a new connection can take over client with
+func (h *LocalHub) issueResume(client *Client) string {
+	if client.resumeToken != "" {
+		return client.resumeToken
+	}
+	client.resumeToken = newID() + newID()
+	h.resumes[client.resumeToken] = client
+	return client.resumeToken
+}
+
+// resuming returns the connection token lets username take over in
+// room, or nil
+func (h *LocalHub) resuming(room, username, token string) *Client {
+	if token == "" {
+		return nil
+	}
+	old := h.resumes[token]
+	if old == nil || old.room != room || old.username != username {
+		return nil
+	}
+	return old
+}
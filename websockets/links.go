@@ -0,0 +1,140 @@
+package websockets
+
+import (
+	"fmt"
+	"time"
+
+	"chat-app/eventlog"
+	"chat-app/links"
+	"chat-app/metrics"
+	"chat-app/storage"
+)
+
+/*
+Link Filter Overview:
+--------------------
+WithLinkFilter applies each room's link policy (see storage.LinkPolicy)
+to chat messages as they are read, before they reach the hub, so
+slow shortener lookups only hold up the sender:
+
+	block    the message is dropped and the sender gets a link_blocked
+	         error naming the offending host
+	defang   the message goes out with offending links rewritten,
+	         e.g. hxxps://evil[.]example/path
+
+The message is queued for moderator review (see review.go), and every
+violation is recorded in the room's event log as a moderation event
+for the auto-moderation consumers reading it:
+
+	{"type": "moderation", "username": "mallory", "content": "https://evil.example/x",
+	 "data": {"rule": "link_policy", "action": "block", "host": "evil.example", "conn": "..."}}
+
+Policies are read from the store and cached per room for
+roomSettingsTTL, so a change made through the settings API takes
+effect within that time on every node.
+*/
+
+// LinkFilter enforces room link policies; safe for concurrent use
+type LinkFilter struct {
+	settings *settingsCache
+	events   *eventlog.Recorder // Where violations are reported; may be nil
+	expander *links.Expander
+}
+
+// NewLinkFilter reads policies from store and reports violations to events
+func NewLinkFilter(store storage.Store, events *eventlog.Recorder) *LinkFilter {
+	return &LinkFilter{
+		settings: newSettingsCache(store),
+		events:   events,
+		expander: links.NewExpander(),
+	}
+}
+
+// WithLinkFilter applies room link policies to chat messages
+func WithLinkFilter(f *LinkFilter) Option {
+	return func(o *handlerOptions) {
+		o.links = f
+	}
+}
+
+// check applies room's policy to a chat message from c, whose ID will be id
+// It returns the content to send, or false if the message is blocked
+// along with the host to name in the error
+func (f *LinkFilter) check(c *Client, id, content string) (string, string, bool) {
+	policy := f.settings.get(c.room).Links
+	if !policy.Enabled() {
+		return content, "", true
+	}
+
+	result := links.Apply(policy, content, f.expander)
+	if len(result.Violations) == 0 {
+		return content, "", true
+	}
+	action := storage.LinkDefang
+	if result.Blocked {
+		action = storage.LinkBlock
+	}
+	for _, v := range result.Violations {
+		f.report(c, v, action)
+	}
+	f.queueForReview(c, id, content, result, action)
+	if result.Blocked {
+		return "", result.Violations[0].Host, false
+	}
+	return result.Content, "", true
+}
+
+// report records a violation for auto-moderation and counts it
+func (f *LinkFilter) report(c *Client, v links.Violation, action string) {
+	metrics.LinkViolations.WithLabelValues(action).Inc()
+	c.logf("link policy %s: %s", action, v.Host)
+	if f.events == nil {
+		return
+	}
+	data := map[string]string{
+		"rule":   "link_policy",
+		"action": action,
+		"host":   v.Host,
+		"conn":   c.id,
+	}
+	if v.Destination != "" {
+		data["destination"] = v.Destination
+	}
+	f.events.Record(storage.Event{
+		Room:      c.room,
+		Type:      storage.EventModeration,
+		Username:  c.username,
+		Content:   v.Link,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+}
+
+// reviewQueuer is implemented by hubs with a moderation review queue
+type reviewQueuer interface {
+	QueueForReview(item storage.ReviewItem)
+}
+
+// queueForReview puts a message that broke the policy in front of moderators
+// Blocked messages were never sent, so their entry names no message
+func (f *LinkFilter) queueForReview(c *Client, id, content string, result links.Result, action string) {
+	queue, ok := c.hub.(reviewQueuer)
+	if !ok {
+		return
+	}
+	item := storage.ReviewItem{
+		ID:       id,
+		Room:     c.room,
+		Username: c.username,
+		Content:  content,
+		Sources:  []string{storage.ReviewAutomod},
+	}
+	if !result.Blocked {
+		item.MessageID = id
+		item.Content = result.Content
+	}
+	for _, v := range result.Violations {
+		item.Reasons = append(item.Reasons, fmt.Sprintf("link_policy: %s (%s)", v.Host, action))
+	}
+	queue.QueueForReview(item)
+}
@@ -0,0 +1,159 @@
+package websockets
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Duplicate Login Overview:
+------------------------
+A user may connect to the same room more than once, from two tabs or
+a laptop and a phone. WithDuplicateLogins decides what happens then:
+
+	allow    Every connection stays (the default)
+	replace  The new connection takes over from the older ones, which
+	         are closed with code 4001 and reason
+	         replaced_by_new_login, so clients know not to reconnect
+	reject   The new one is refused with 409 before the upgrade
+
+A takeover is a device switch, not a leave and a join: it happens in
+one step on the hub goroutine, the room sees no user_left or
+user_joined, and the new connection inherits the older ones' unacked
+deliveries (see qos.go). After its hello and presence snapshot it is
+told which connections it replaced, then sent those deliveries again:
+
+	{"type": "session_transferred", "room": "lobby", "username": "bob", "content": "<old conn IDs, comma-separated>"}
+
+The room stays the same, since only logins to the same room are
+duplicates; file transfer handshakes in progress on the old
+connection are cancelled (see transfer.go).
+
+Only connections on the same node count; in a cluster, route a room's
+clients to its owner (see reconnect.go) to apply the policy to all of
+them. Two connections racing through the upgrade under reject are
+caught on registration, the later one closed with code 4002 and
+reason duplicate_login.
+*/
+
+// Duplicate login policies
+const (
+	DuplicateAllow   = "allow"
+	DuplicateReplace = "replace"
+	DuplicateReject  = "reject"
+)
+
+// Close frames sent to duplicate logins; also recorded as close reasons
+const (
+	closeReasonReplaced  = "replaced_by_new_login"
+	closeReasonDuplicate = "duplicate_login"
+
+	closeCodeReplaced  = 4001
+	closeCodeDuplicate = 4002
+)
+
+// WithDuplicateLogins sets what happens when a user connects to a room
+// they're already connected to
+func WithDuplicateLogins(policy string) HubOption {
+	return func(h *LocalHub) {
+		h.duplicateLogins = policy
+	}
+}
+
+// loginChecker is implemented by hubs that may refuse a second login
+type loginChecker interface {
+	RefusesLogin(room, username, resume string) bool
+}
+
+// RefusesLogin reports whether username joining room now, with the
+// resume token given if any, would be turned away as a duplicate login
+// Safe to call from any goroutine
+func (h *LocalHub) RefusesLogin(room, username, resume string) bool {
+	if h.duplicateLogins != DuplicateReject {
+		return false
+	}
+	var refused bool
+	h.query(func() {
+		refused = h.resuming(room, username, resume) == nil && len(h.duplicates(room, username)) > 0
+	})
+	return refused
+}
+
+// duplicates returns the connections username already has to room
+func (h *LocalHub) duplicates(room, username string) []*Client {
+	var found []*Client
+	for client := range h.rooms[room] {
+		if client.username == username {
+			found = append(found, client)
+		}
+	}
+	return found
+}
+
+// admitLogin applies the duplicate login policy to a client about to be
+// registered, reporting false when it must not be
+func (h *LocalHub) admitLogin(client *Client) bool {
+	// A client coming back with a resume token takes over whatever the
+	// policy (see lifetime.go)
+	if old := h.resuming(client.room, client.username, client.resume); old != nil {
+		h.takeOver(old, client, closeReasonResumed, websocket.CloseNormalClosure)
+	}
+
+	dups := h.duplicates(client.room, client.username)
+	if len(dups) == 0 {
+		return true
+	}
+	switch h.duplicateLogins {
+	case DuplicateReplace:
+		for _, old := range dups {
+			h.takeOver(old, client, closeReasonReplaced, closeCodeReplaced)
+		}
+	case DuplicateReject:
+		client.closeFrame = websocket.FormatCloseMessage(closeCodeDuplicate, closeReasonDuplicate)
+		close(client.send)
+		return false
+	}
+	return true
+}
+
+// takeOver closes old in favour of client, moving its unacked deliveries
+// across; the room isn't told, since the user never left. old gets a
+// close frame with code and reason
+func (h *LocalHub) takeOver(old, client *Client, reason string, code int) {
+	for id, d := range h.pending[old] {
+		if h.pending[client] == nil {
+			h.pending[client] = make(map[string]*pendingDelivery)
+		}
+		d.attempts = 0 // A fresh connection gets every try
+		h.pending[client][id] = d
+	}
+	delete(h.pending, old) // So they aren't queued offline instead
+	client.replaced = append(client.replaced, old.id)
+
+	old.closeFrame = websocket.FormatCloseMessage(code, reason)
+	close(old.send)
+	h.removeClient(old, reason)
+}
+
+// finishTakeover tells a client that took over other connections which
+// ones, then re-sends what they hadn't acked
+func (h *LocalHub) finishTakeover(client *Client, now time.Time) {
+	if len(client.replaced) == 0 {
+		return
+	}
+	h.sendTo(client, Message{
+		Type:     "session_transferred",
+		Content:  strings.Join(client.replaced, ","),
+		RoomName: client.room,
+		Username: client.username,
+	})
+	for _, d := range h.pending[client] {
+		d.attempts++
+		d.next = now.Add(redeliveryInterval)
+		redelivery := d.msg
+		redelivery.Redelivered = true
+		h.sendTo(client, redelivery)
+	}
+}
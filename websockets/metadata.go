@@ -0,0 +1,140 @@
+package websockets
+
+import (
+	"sort"
+	"strings"
+
+	"chat-app/geoip"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Connection Metadata Overview:
+----------------------------
+Each client records where it connected from, for the admin views
+(GET /api/admin/rooms/:room and /connections/:id):
+
+	user_agent    User-Agent of the upgrade request
+	ip            Client IP as gin resolves it (see gin's trusted proxies)
+	subprotocol   Subprotocol negotiated during the upgrade, if any
+	device        mobile, tablet, desktop, bot or unknown, from user_agent
+	country       ISO country code, with WithGeoIP (see chat-app/geoip)
+	region        ISO subdivision code, e.g. "US-CA", with WithGeoIP
+
+Presence never exposes the user agent or IP. With WithPresenceDevices,
+presence frames carry just the device type of each user:
+
+	{"type": "online_users", "users": [{"username": "alice", "device": "mobile"},
+	 {"username": "bob", "device": "desktop"}]}
+	{"type": "presence_join", "username": "alice", "device": "mobile"}
+*/
+
+// Device types derived from the user agent
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+// connMeta describes how a client connected
+type connMeta struct {
+	userAgent   string
+	ip          string
+	subprotocol string
+	device      string
+	location    geoip.Location
+}
+
+// requestMeta captures the metadata of an upgrade request
+// The subprotocol is filled in once the upgrade has negotiated it
+func requestMeta(c *gin.Context, geo GeoResolver) connMeta {
+	ua := c.Request.UserAgent()
+	meta := connMeta{
+		userAgent: ua,
+		ip:        c.ClientIP(),
+		device:    deviceType(ua),
+	}
+	if geo != nil {
+		meta.location = geo.Lookup(meta.ip)
+	}
+	return meta
+}
+
+// deviceType roughly classifies a user agent
+// It only needs to be good enough for presence icons, not analytics
+func deviceType(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case ua == "":
+		return DeviceUnknown
+	case containsAny(ua, "bot", "crawler", "spider", "curl/", "wget/"):
+		return DeviceBot
+	case containsAny(ua, "ipad", "tablet") || (strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return DeviceTablet
+	case containsAny(ua, "mobile", "iphone", "android"):
+		return DeviceMobile
+	case containsAny(ua, "windows", "macintosh", "x11", "linux", "cros"):
+		return DeviceDesktop
+	}
+	return DeviceUnknown
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPresenceDevices adds each user's device type to presence frames
+func WithPresenceDevices() HubOption {
+	return func(h *LocalHub) {
+		h.presenceDevices = true
+	}
+}
+
+// GeoCount is how many of this node's connections come from one country
+type GeoCount struct {
+	Country     string         `json:"country"` // "" when unresolved
+	Connections int            `json:"connections"`
+	Regions     map[string]int `json:"regions,omitempty"` // Connections by ISO subdivision
+}
+
+// GeoCounts breaks current connections down by location, busiest first
+func (h *LocalHub) GeoCounts() []GeoCount {
+	byCountry := make(map[string]*GeoCount)
+	h.query(func() {
+		for client := range h.clients {
+			loc := client.meta.location
+			gc, ok := byCountry[loc.Country]
+			if !ok {
+				gc = &GeoCount{Country: loc.Country}
+				byCountry[loc.Country] = gc
+			}
+			gc.Connections++
+			if loc.Region != "" {
+				if gc.Regions == nil {
+					gc.Regions = make(map[string]int)
+				}
+				gc.Regions[loc.Region]++
+			}
+		}
+	})
+
+	counts := make([]GeoCount, 0, len(byCountry))
+	for _, gc := range byCountry {
+		counts = append(counts, *gc)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Connections != counts[j].Connections {
+			return counts[i].Connections > counts[j].Connections
+		}
+		return counts[i].Country < counts[j].Country
+	})
+	return counts
+}
@@ -0,0 +1,168 @@
+package websockets
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/moderation"
+	"chat-app/storage"
+)
+
+/*
+Message Moderation Overview:
+---------------------------
+With WithModeration, every chat message the hub broadcasts is also
+submitted for toxicity scoring (see chat-app/moderation). Verdicts
+come back asynchronously, usually well under a second later, and are
+applied here on the hub goroutine:
+
+	flag     queued for review (see review.go), still visible
+	hide     clients are told to hide the message; it is queued for
+	         review and stays hidden until a moderator approves it
+	delete   the stored copy is removed and clients are told to drop it
+
+Clients learn about hides and deletes from moderation frames:
+
+	{"type": "moderation", "code": "hidden", "id": "<message id>", "score": 0.91}
+
+code is hidden, deleted or restored. Every verdict and review decision
+is recorded as a moderation event with the score, so the event log's
+history view drops deleted messages and marks hidden ones.
+*/
+
+// Codes on moderation frames
+const (
+	ModerationHidden   = "hidden"
+	ModerationDeleted  = "deleted"
+	ModerationRestored = "restored"
+)
+
+// Rules named in moderation events
+const (
+	ruleToxicity = "toxicity"
+	ruleReview   = "review"
+	ruleArchive  = "archive" // Archiving and unarchiving, see archived.go
+)
+
+// topCategories is how many score categories are kept as review reasons
+const topCategories = 3
+
+// WithModeration submits chat messages to m for scoring
+// The caller runs m with ApplyVerdict as its callback
+func WithModeration(m *moderation.Moderator) HubOption {
+	return func(h *LocalHub) {
+		h.moderator = m
+	}
+}
+
+// submitForModeration queues a broadcast chat message for scoring
+func (h *LocalHub) submitForModeration(msg Message) {
+	if h.moderator == nil {
+		return
+	}
+	h.moderator.Submit(moderation.Job{
+		Room:      msg.RoomName,
+		MessageID: msg.ID,
+		Username:  msg.Username,
+		Content:   msg.Content,
+	})
+}
+
+// ApplyVerdict acts on a moderation verdict; safe to call from any goroutine
+func (h *LocalHub) ApplyVerdict(v moderation.Verdict) {
+	// History leaves a hidden message out until it is approved
+	if v.Action == moderation.ActionHide {
+		ctx, cancel := storageContext()
+		err := h.store.SetMessageHidden(ctx, v.MessageID, true)
+		cancel()
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			reportStorageError("hide message", err, errreport.Context{Room: v.Room, Username: v.Username})
+		}
+	}
+	h.query(func() {
+		h.recordModeration(ruleToxicity, v.Room, v.MessageID, v.Username, v.Action, map[string]string{
+			"score": strconv.FormatFloat(v.Score.Value, 'f', 3, 64),
+		})
+		switch v.Action {
+		case moderation.ActionFlag, moderation.ActionHide:
+			hide := v.Action == moderation.ActionHide
+			h.queueForReview(storage.ReviewItem{
+				ID:        v.MessageID,
+				Room:      v.Room,
+				MessageID: v.MessageID,
+				Username:  v.Username,
+				Content:   v.Content,
+				Sources:   []string{storage.ReviewToxicity},
+				Reasons:   scoreReasons(v.Score),
+				Score:     v.Score.Value,
+				Hidden:    hide,
+			})
+			if hide {
+				h.handleBroadcast(moderationMessage(v.Room, v.MessageID, ModerationHidden, v.Score.Value))
+			}
+		case moderation.ActionDelete:
+			h.deleteMessage(v.Room, v.MessageID, v.Score.Value)
+		}
+	})
+}
+
+// scoreReasons describes the highest scoring categories, e.g. "toxicity: insult 0.91"
+func scoreReasons(score moderation.Score) []string {
+	type category struct {
+		name  string
+		value float64
+	}
+	cats := make([]category, 0, len(score.Categories))
+	for name, value := range score.Categories {
+		cats = append(cats, category{name, value})
+	}
+	sort.Slice(cats, func(i, j int) bool { return cats[i].value > cats[j].value })
+
+	reasons := []string{}
+	for _, c := range cats[:min(topCategories, len(cats))] {
+		reasons = append(reasons, fmt.Sprintf("toxicity: %s %.2f", strings.ToLower(c.name), c.value))
+	}
+	return reasons
+}
+
+// deleteMessage removes a message from storage, redelivery, the review
+// queue and clients
+func (h *LocalHub) deleteMessage(room, id string, score float64) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := h.store.DeleteMessage(ctx, id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		reportStorageError("delete message", err, errreport.Context{Room: room})
+	}
+	for _, deliveries := range h.pending {
+		delete(deliveries, id)
+	}
+	h.dropFromReview(id, moderation.ActionDelete)
+	h.handleBroadcast(moderationMessage(room, id, ModerationDeleted, score))
+}
+
+// recordModeration adds a moderation event to the room's log
+func (h *LocalHub) recordModeration(rule, room, id, username, action string, data map[string]string) {
+	if data == nil {
+		data = make(map[string]string)
+	}
+	data["rule"] = rule
+	data["action"] = action
+	h.recordEvent(storage.Event{
+		Room:      room,
+		Type:      storage.EventModeration,
+		Username:  username,
+		MessageID: id,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+}
+
+// moderationMessage tells a room what happened to one of its messages
+func moderationMessage(room, id, code string, score float64) Message {
+	return Message{Type: "moderation", Code: code, ID: id, RoomName: room, Score: score}
+}
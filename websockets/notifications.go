@@ -0,0 +1,106 @@
+package websockets
+
+import (
+	"errors"
+	"time"
+
+	"chat-app/notify"
+	"chat-app/storage"
+)
+
+/*
+Notifications Overview:
+----------------------
+WithNotifier hands every chat message to the notify package, with
+who was in the room, so members who are away can get a push or email
+notification as their preferences allow. Users set those preferences
+over REST (see api/notifications.go) or from any connection:
+
+	{"type": "set_preferences", "preferences": {"default": "mentions", "rooms": {"lobby": "all"}}}
+
+Only the levels given change; a room set to "" falls back to the
+default, a dnd window holds notifications for a summary, and
+highlight words (see highlights.go) replace the user's list. A user can also mute the room they're connected to, for a
+while or until they unmute it; messages still arrive live, but the
+room sends no notifications:
+
+	{"type": "mute", "for": "8h"}
+	{"type": "unmute"}
+
+The sender gets the whole result back:
+
+	{"type": "preferences", "preferences": {"username": "alice", "default": "mentions", "rooms": {...}, "muted": {...}}}
+*/
+
+// WithNotifier notifies members away from a room of its chat messages
+func WithNotifier(d *notify.Dispatcher) HubOption {
+	return func(h *LocalHub) {
+		h.notifier = d
+	}
+}
+
+// submitForNotification queues a broadcast chat message for the room's
+// away members
+func (h *LocalHub) submitForNotification(msg Message, received time.Time) {
+	if h.notifier == nil {
+		return
+	}
+	h.notifier.Submit(notify.Job{
+		Room:      msg.RoomName,
+		From:      msg.Username,
+		MessageID: msg.ID,
+		Content:   msg.Content,
+		Online:    h.onlineIn(msg.RoomName),
+		SentAt:    received,
+	})
+}
+
+// handleSetPreferences saves a change to client's user's notification
+// preferences off the hub goroutine, a user's changes on one writer so
+// they apply in the order sent, and sends back the result
+func (h *LocalHub) handleSetPreferences(client *Client, change storage.NotificationPrefs) {
+	username := client.username
+	h.storeInOrder(username, func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		prefs, err := notify.UpdatePreferences(ctx, h.store, username, change)
+		h.queries <- func() { h.setPreferences(client, prefs, err) }
+	})
+}
+
+// setPreferences answers a set_preferences frame once it is saved
+func (h *LocalHub) setPreferences(client *Client, prefs storage.NotificationPrefs, err error) {
+	if errors.Is(err, notify.ErrInvalid) {
+		h.sendTo(client, errorMessage(client, errCodeBadFrame, err.Error()))
+		return
+	}
+	if err != nil {
+		reportStorageError("save notification preferences", err, client.reportContext())
+		h.sendTo(client, errorMessage(client, errCodeStorage, "preferences could not be saved, try again"))
+		return
+	}
+	h.highlights[client.username] = userHighlights{words: prefs.Highlights, expires: time.Now().Add(highlightTTL)}
+	h.sendTo(client, Message{Type: "preferences", RoomName: client.room, Username: client.username, Preferences: &prefs})
+}
+
+// handleUnmute lets client's room notify its user again, saving it off
+// the hub goroutine like handleSetPreferences, and sends back the result
+func (h *LocalHub) handleUnmute(client *Client) {
+	username, room := client.username, client.room
+	h.storeInOrder(username, func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		prefs, err := notify.Unmute(ctx, h.store, username, room)
+		h.queries <- func() { h.unmuted(client, prefs, err) }
+	})
+}
+
+// unmuted answers an unmute frame once it is saved
+func (h *LocalHub) unmuted(client *Client, prefs storage.NotificationPrefs, err error) {
+	if err != nil {
+		reportStorageError("save notification preferences", err, client.reportContext())
+		h.sendTo(client, errorMessage(client, errCodeStorage, "preferences could not be saved, try again"))
+		return
+	}
+	h.sendTo(client, Message{Type: "preferences", RoomName: client.room, Username: client.username, Preferences: &prefs})
+}
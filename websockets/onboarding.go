@@ -0,0 +1,68 @@
+package websockets
+
+import (
+	"strings"
+	"time"
+)
+
+/*
+Onboarding Overview:
+-------------------
+Rooms with an onboarding flow (see storage.Onboarding, set through the
+room settings API) greet each user the first time they join, right
+after the hello and any queued messages, with one private frame per
+step:
+
+	{"type": "onboarding", "code": "rules", "username": "welcome-bot",
+	 "content": "Welcome alice! Be kind. No spam."}
+	{"type": "onboarding", "code": "link", "content": "Read the FAQ", "url": "https://..."}
+	{"type": "onboarding", "code": "role_picker", "content": "What brings you here?",
+	 "choices": ["player", "modder"]}
+
+code is the step type. Clients render role pickers however suits
+them; picking is up to the embedder. Who has been onboarded is kept
+in the store, so returning members, on any node and after restarts,
+aren't greeted again.
+*/
+
+// onboardingSender names onboarding frames from rooms that don't set one
+const onboardingSender = "system"
+
+// onboarding returns the room's welcome flow for a first-time joiner,
+// marking them onboarded; called off the hub goroutine (see loadArrival)
+func (h *LocalHub) onboarding(client *Client) []Message {
+	flow := h.settings.get(client.room).Onboarding
+	if !flow.Enabled() {
+		return nil
+	}
+
+	ctx, cancel := storageContext()
+	defer cancel()
+	first, err := h.store.MarkOnboarded(ctx, client.room, client.username, time.Now())
+	if err != nil {
+		reportStorageError("mark onboarded", err, client.reportContext())
+		return nil // Better to skip the welcome than repeat it
+	}
+	if !first {
+		return nil
+	}
+
+	from := flow.From
+	if from == "" {
+		from = onboardingSender
+	}
+	fill := strings.NewReplacer("{username}", client.username, "{room}", client.room)
+	steps := make([]Message, 0, len(flow.Steps))
+	for _, step := range flow.Steps {
+		steps = append(steps, Message{
+			Type:     "onboarding",
+			Code:     step.Type,
+			Content:  fill.Replace(step.Content),
+			RoomName: client.room,
+			Username: from,
+			URL:      step.URL,
+			Choices:  step.Choices,
+		})
+	}
+	return steps
+}
@@ -0,0 +1,136 @@
+package websockets
+
+import (
+	"net/http"
+
+	"chat-app/chaos"
+	"chat-app/geoip"
+	"chat-app/permission"
+	"chat-app/quota"
+	"chat-app/recording"
+	"chat-app/sanitize"
+	"chat-app/tenant"
+	"chat-app/uploads"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+/*
+Handler Options Overview:
+------------------------
+HandleWebSocket takes functional options so embedders can tune
+connection setup without touching package globals:
+
+	websockets.HandleWebSocket(hub,
+		websockets.WithBufferSizes(4096, 4096),
+		websockets.WithOriginChecker(allowMyDomain),
+		websockets.WithAuth(checkSession),
+	)
+
+With no options the handler behaves as it always has.
+*/
+
+// AuthFunc runs before the upgrade with the requested room and username
+// It returns the username to use (it may replace the requested one with
+// a verified identity) or an error to reject the connection with 401
+type AuthFunc func(c *gin.Context, room, username string) (string, error)
+
+// Option customizes HandleWebSocket
+type Option func(*handlerOptions)
+
+// handlerOptions collects everything an Option can change
+type handlerOptions struct {
+	upgrader    websocket.Upgrader
+	auth        AuthFunc
+	tokens      TokenValidator // Access tokens required, see tokens.go; nil trusts the username parameter
+	geo         GeoResolver
+	limits      *geoip.Limits
+	throttle    *ConnectThrottle
+	links       *LinkFilter
+	joins       *JoinGate
+	emoji       *EmojiExpander
+	clean       sanitize.Sanitizer
+	uploads     *uploads.Service
+	quotas      *quota.Tracker
+	tenants     *tenant.Registry
+	permissions *permission.Authorizer
+	chaos       *chaos.Injector     // Faults injected into connections, see chaos.go; nil for none
+	recorder    *recording.Recorder // Frames recorded for debugging, see recording.go; nil for none
+
+	rttReports bool // Tell clients their round-trip times, see rtt.go
+}
+
+func defaultHandlerOptions() handlerOptions {
+	return handlerOptions{
+		upgrader: websocket.Upgrader{
+			// Buffer sizes affect memory usage and performance
+			ReadBufferSize:  1024, // Adjust based on expected message sizes
+			WriteBufferSize: 1024,
+
+			// CheckOrigin prevents unauthorized cross-origin requests
+			// WARNING: Default allows all origins - use WithOriginChecker in production
+			CheckOrigin: func(r *http.Request) bool {
+				return true // Development only - accepts all origins
+			},
+		},
+	}
+}
+
+// WithBufferSizes sets the upgrader's read and write buffer sizes in bytes
+func WithBufferSizes(read, write int) Option {
+	return func(o *handlerOptions) {
+		o.upgrader.ReadBufferSize = read
+		o.upgrader.WriteBufferSize = write
+	}
+}
+
+// WithOriginChecker replaces the allow-all origin check
+func WithOriginChecker(check func(r *http.Request) bool) Option {
+	return func(o *handlerOptions) {
+		o.upgrader.CheckOrigin = check
+	}
+}
+
+// WithSubprotocols sets the subprotocols the server is willing to negotiate
+// With token auth, the token subprotocol is offered as well
+func WithSubprotocols(protocols ...string) Option {
+	return func(o *handlerOptions) {
+		o.upgrader.Subprotocols = protocols
+	}
+}
+
+// GeoResolver locates client IPs; *geoip.Resolver implements it
+type GeoResolver interface {
+	Lookup(ip string) geoip.Location
+}
+
+// WithGeoIP tags connections with their country and region
+func WithGeoIP(geo GeoResolver) Option {
+	return func(o *handlerOptions) {
+		o.geo = geo
+	}
+}
+
+// WithConnectionLimits rate-limits new connections by location
+// It needs WithGeoIP; unresolved clients are never limited by country
+func WithConnectionLimits(limits *geoip.Limits) Option {
+	return func(o *handlerOptions) {
+		o.limits = limits
+	}
+}
+
+// WithAuth installs a hook that authorizes connections before upgrade
+func WithAuth(auth AuthFunc) Option {
+	return func(o *handlerOptions) {
+		o.auth = auth
+	}
+}
+
+// WithSanitizer sets how message content is cleaned before anyone sees it
+// Without it, HTML is stripped (see the sanitize package)
+func WithSanitizer(s sanitize.Sanitizer) Option {
+	return func(o *handlerOptions) {
+		o.clean = s
+	}
+}
@@ -0,0 +1,62 @@
+package websockets_test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"chat-app/client"
+	"chat-app/wstest"
+)
+
+// Each room's messages reach every member in one order, seq rising,
+// however many members post to it at once
+func TestConcurrentPublishersOrdered(t *testing.T) {
+	const (
+		publishers = 4  // Per room
+		messages   = 50 // Per publisher
+	)
+	srv := wstest.NewServer(t)
+	rooms := []string{"lobby", "games"}
+
+	var listeners, senders []*wstest.Client
+	for _, room := range rooms {
+		listeners = append(listeners, srv.Connect(t, room, "listener-"+room))
+		for p := 0; p < publishers; p++ {
+			senders = append(senders, srv.Connect(t, room, fmt.Sprintf("%s-%d", room, p)))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				s.Send(strconv.Itoa(i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, l := range listeners {
+		// Every message arrives, each sender's in the order it sent them
+		next := make(map[string]int)
+		for received := 0; received < publishers*messages; received++ {
+			msg := l.Expect(func(m client.Message) bool { return m.Type == "chat" })
+			if !strings.HasPrefix(msg.Username, rooms[i]+"-") {
+				t.Fatalf("%s got %q from %s, who isn't in the room", l.Username, msg.Content, msg.Username)
+			}
+			if want := strconv.Itoa(next[msg.Username]); msg.Content != want {
+				t.Fatalf("%s got %q from %s, want %q", l.Username, msg.Content, msg.Username, want)
+			}
+			next[msg.Username]++
+		}
+		l.AssertOrdered()
+	}
+	for _, s := range senders {
+		s.AssertOrdered() // Publishers hear their rooms in order too
+	}
+}
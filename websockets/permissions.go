@@ -0,0 +1,136 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"time"
+
+	"chat-app/permission"
+	"chat-app/storage"
+)
+
+/*
+Permissions Overview:
+--------------------
+WithPermissions checks what members post against the capabilities
+their roles hold in the room (see the permission package). In a
+broadcast room everything posted needs post, so members without it
+can only read; messages from federated servers need it too, going by
+the roles the room gives user@server. Chat messages mentioning
+@everyone need mention_everyone, slash commands (messages starting
+with / for the room's bots) need command, and voice notes and
+attachments need upload, which the API also checks before taking the
+file. Pinning needs pin:
+
+	{"type": "pin", "id": "..."}
+	{"type": "unpin", "id": "..."}
+
+The room's owner checks the message is one of the room's, records a
+pin event in the event log (data.action is pin or unpin, data.author
+who wrote the message) and tells the room:
+
+	{"type": "pin", "id": "...", "username": "alice", "content": "the pinned message"}
+
+username is who pinned it. Clients rebuild the pinned list from the
+event log; the owner also keeps the room's pinned IDs, which the admin
+room view shows and hub state snapshots keep. Refused actions get an error, like other checks made on
+the connection's own goroutine:
+
+	{"type": "error", "code": "permission_denied",
+	 "content": "you don't have the pin permission in lobby"}
+*/
+
+// Error codes for permissions and pins
+const errCodePermissionDenied = "permission_denied"
+
+// everyoneMention matches @everyone as a word of its own
+var everyoneMention = regexp.MustCompile(`(^|[^\w@])@everyone\b`)
+
+// slashCommand matches content that is a command, like /roll 2d6
+var slashCommand = regexp.MustCompile(`^\s*/[A-Za-z]`)
+
+// WithPermissions gates pins, uploads, mentions and commands on room permissions
+func WithPermissions(a *permission.Authorizer) Option {
+	return func(o *handlerOptions) {
+		o.permissions = a
+	}
+}
+
+// authorize checks c's user holds capability in its room, telling the
+// client and returning false when not
+func (c *Client) authorize(ctx context.Context, capability string) bool {
+	err := c.permissions.Authorize(ctx, c.room, c.username, capability)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, permission.ErrDenied):
+		c.hub.Broadcast(errorMessage(c, errCodePermissionDenied, err.Error()))
+	default:
+		reportStorageError("load permissions", err, c.reportContext())
+		c.hub.Broadcast(errorMessage(c, errCodeStorage, "permissions could not be checked, try again"))
+	}
+	return false
+}
+
+// authorizeChat checks the capabilities chat content needs
+func (c *Client) authorizeChat(ctx context.Context, content string) bool {
+	if !c.authorize(ctx, storage.CapPost) {
+		return false
+	}
+	if slashCommand.MatchString(content) && !c.authorize(ctx, storage.CapCommand) {
+		return false
+	}
+	return !everyoneMention.MatchString(content) || c.authorize(ctx, storage.CapMentionEveryone)
+}
+
+// isPin reports whether msgType pins or unpins a message
+func isPin(msgType string) bool {
+	return msgType == "pin" || msgType == "unpin"
+}
+
+// resolvePin checks a pinned message belongs to the room and records
+// the pin, filling in the message's content
+// It reports false, after telling the sender, if there is no such message,
+// and while the message is fetched from storage
+func (h *LocalHub) resolvePin(msg *Message) bool {
+	pinned, ok, pending := h.findMessage(*msg, msg.ID)
+	if pending {
+		return false // Back once it is fetched
+	}
+	if !ok {
+		h.reply(*msg, Message{
+			Type:     "error",
+			Code:     errCodeUnknownMessage,
+			Content:  "no such message in this room to " + msg.Type,
+			RoomName: msg.RoomName,
+		})
+		return false
+	}
+	msg.Content = pinned.content
+	h.recordEvent(storage.Event{
+		Room:      msg.RoomName,
+		Type:      storage.EventPin,
+		Username:  msg.Username,
+		MessageID: msg.ID,
+		Content:   pinned.content,
+		Data:      map[string]string{"action": msg.Type, "author": pinned.author},
+		CreatedAt: time.Now(),
+	})
+	h.trackPin(msg.RoomName, msg.ID, msg.Type == "pin")
+	return true
+}
+
+// trackPin adds id to room's pinned IDs, or removes it
+func (h *LocalHub) trackPin(room, id string, pinned bool) {
+	pins := slices.DeleteFunc(h.pins[room], func(p string) bool { return p == id })
+	if pinned {
+		pins = append(pins, id)
+	}
+	if len(pins) == 0 {
+		delete(h.pins, room)
+		return
+	}
+	h.pins[room] = pins
+}
@@ -0,0 +1,256 @@
+package websockets
+
+import (
+	"slices"
+	"strings"
+)
+
+/*
+Presence Overview:
+-----------------
+Members learn who else is in their room from three frames:
+
+	online_users     the full list, sent to each joiner on its own
+	presence_join    someone arrived, or changed device, avatar or status
+	presence_leave   someone's last connection left
+
+	{"type": "online_users", "room": "lobby",
+	 "users": [{"username": "alice"}, {"username": "bob", "device": "mobile"}]}
+	{"type": "presence_join", "room": "lobby", "username": "bob",
+	 "device": "mobile", "avatar": "/api/avatars/5d41..."}
+	{"type": "presence_leave", "room": "lobby", "username": "bob"}
+
+Re-sending the whole list to the whole room on every join and leave
+costs O(members) frames of O(members) bytes each, so a room filling
+up costs O(members²). Instead the hub keeps what it last announced
+for each room and broadcasts only the difference from the room's
+current members. A user connected twice is one member; only their
+last connection leaving sends presence_leave.
+
+Step 1: A joiner gets an online_users snapshot, addressed only to it
+Step 2: The room, joiner included, gets presence_join for the joiner
+Step 3: Later changes go out as deltas until the joiner leaves
+
+The room gets a full online_users again only when deltas were shed
+under load (see guard.go), so lists converge after the spike.
+
+In a cluster the owner diffs and broadcasts, as it did the full list.
+Subscribers mirror the presence frames they relay, so they can send
+their own joiners a snapshot, and a node newly subscribed to a room
+gets one snapshot from the owner to start from.
+
+WithLegacyPresence serves protocol 1 clients, which don't understand
+the deltas or the users list: online_users goes to the whole room on
+every change, and also lists members the old way, comma-joined in
+content with devices and avatars in maps keyed by username. Usernames
+containing commas break that list, so it is only sent in this mode.
+*/
+
+// LegacyProtocol is the protocol served with WithLegacyPresence
+const LegacyProtocol = 1
+
+// Member is what a room is told about one of its users
+type Member struct {
+	Username string `json:"username"`
+	Device   string `json:"device,omitempty"` // Only with WithPresenceDevices
+	Avatar   string `json:"avatar,omitempty"` // Image URL; empty without an avatar
+	Status   string `json:"status,omitempty"` // StatusAway, or empty while online
+}
+
+// WithLegacyPresence broadcasts the full online_users list to the room
+// on every change instead of presence_join and presence_leave, in the
+// protocol 1 format
+func WithLegacyPresence() HubOption {
+	return func(h *LocalHub) {
+		h.legacyPresence = true
+	}
+}
+
+// localMembers is who is in room on this node
+// Members are away only while every one of their connections is,
+// through missed heartbeats or idleness
+func (h *LocalHub) localMembers(room string) map[string]Member {
+	members := make(map[string]Member)
+	for client := range h.rooms[room] {
+		m, seen := members[client.username]
+		if !seen {
+			m = Member{Username: client.username, Status: StatusAway}
+		}
+		if h.presenceDevices {
+			m.Device = client.meta.device
+		}
+		if client.avatar != "" {
+			m.Avatar = AvatarURL(client.avatar)
+		}
+		if !client.away && !client.idle {
+			m.Status = ""
+		}
+		members[client.username] = m
+	}
+	return members
+}
+
+// addRemoteMembers adds the members subscribers reported for a room
+// owned here
+func (h *LocalHub) addRemoteMembers(room string, members map[string]Member) {
+	for node, users := range h.remoteUsers[room] {
+		for _, user := range users {
+			m := Member{Username: user}
+			if h.presenceDevices {
+				m.Device = h.remoteDevices[room][node][user]
+			}
+			m.Avatar = h.remoteAvatars[room][node][user]
+			m.Status = h.remoteStatuses[room][node][user]
+			if prev, ok := members[user]; ok && prev.Status == "" {
+				m.Status = "" // Online on another node
+			}
+			members[user] = m
+		}
+	}
+}
+
+// broadcastRoomUsers tells room that its members may have changed
+func (h *LocalHub) broadcastRoomUsers(room string) {
+	members := h.localMembers(room)
+
+	// In a cluster the owner announces everyone, wherever they connected
+	if h.reportMembers(room, members) {
+		return
+	}
+	h.addRemoteMembers(room, members)
+
+	// Other servers sharing the room hear about this server's members,
+	// and this server about theirs (see federation.go)
+	h.federatePresence(room, members)
+	h.addFederatedMembers(room, members)
+
+	if h.legacyPresence {
+		h.handleBroadcast(h.presenceSnapshot(room, members))
+		return
+	}
+	h.announcePresence(room, members)
+}
+
+// announcePresence broadcasts how members differ from what room was last told
+func (h *LocalHub) announcePresence(room string, members map[string]Member) {
+	announced := h.presence[room]
+	for _, user := range sortedUsers(members) {
+		m := members[user]
+		if old, ok := announced[user]; ok && old == m {
+			continue
+		}
+		h.handleBroadcast(Message{
+			Type:     "presence_join",
+			RoomName: room,
+			Username: user,
+			Device:   m.Device,
+			Avatar:   m.Avatar,
+			Status:   m.Status,
+		})
+	}
+	for _, user := range sortedUsers(announced) {
+		if _, ok := members[user]; !ok {
+			h.handleBroadcast(Message{Type: "presence_leave", RoomName: room, Username: user})
+		}
+	}
+
+	if len(members) == 0 {
+		delete(h.presence, room)
+	} else {
+		h.presence[room] = members
+	}
+}
+
+// sendPresenceSnapshot gives a joiner the room's members to apply deltas to
+func (h *LocalHub) sendPresenceSnapshot(client *Client) {
+	if h.legacyPresence {
+		return // The room's next broadcast includes it
+	}
+	members := make(map[string]Member)
+	for user, m := range h.presence[client.room] {
+		members[user] = m
+	}
+	for user, m := range h.localMembers(client.room) {
+		members[user] = m // Fresher than the owner's last word
+	}
+	h.sendTo(client, h.presenceSnapshot(client.room, members))
+}
+
+// resyncPresence re-sends room's full member list after deltas were shed
+func (h *LocalHub) resyncPresence(room string) {
+	if _, remote := h.remoteOwner(room); remote || h.legacyPresence {
+		h.broadcastRoomUsers(room)
+		return
+	}
+	h.handleBroadcast(h.presenceSnapshot(room, h.presence[room]))
+}
+
+// mirrorPresence keeps a subscriber's copy of what the owner announced
+func (h *LocalHub) mirrorPresence(msg Message) {
+	room := msg.RoomName
+	if _, local := h.rooms[room]; !local {
+		return
+	}
+	switch msg.Type {
+	case "online_users":
+		members := make(map[string]Member)
+		for _, m := range msg.Users {
+			members[m.Username] = m
+		}
+		h.presence[room] = members
+	case "presence_join":
+		if h.presence[room] == nil {
+			h.presence[room] = make(map[string]Member)
+		}
+		h.presence[room][msg.Username] = Member{Username: msg.Username, Device: msg.Device, Avatar: msg.Avatar, Status: msg.Status}
+	case "presence_leave":
+		delete(h.presence[room], msg.Username)
+	}
+}
+
+// presenceSnapshot is an online_users frame listing members
+func (h *LocalHub) presenceSnapshot(room string, members map[string]Member) Message {
+	msg := Message{Type: "online_users", RoomName: room}
+	users := sortedUsers(members)
+	for _, user := range users {
+		msg.Users = append(msg.Users, members[user])
+	}
+	if !h.legacyPresence {
+		return msg
+	}
+
+	// Protocol 1 clients read the list from content and the maps
+	msg.Content = strings.Join(users, ",")
+	for _, m := range msg.Users {
+		if m.Device != "" {
+			if msg.Devices == nil {
+				msg.Devices = make(map[string]string)
+			}
+			msg.Devices[m.Username] = m.Device
+		}
+		if m.Avatar != "" {
+			if msg.Avatars == nil {
+				msg.Avatars = make(map[string]string)
+			}
+			msg.Avatars[m.Username] = m.Avatar
+		}
+	}
+	return msg
+}
+
+// Protocol is the protocol version this hub speaks, see protocol.go
+func (h *LocalHub) Protocol() int {
+	if h.legacyPresence {
+		return LegacyProtocol
+	}
+	return ProtocolVersion
+}
+
+func sortedUsers(members map[string]Member) []string {
+	users := make([]string, 0, len(members))
+	for user := range members {
+		users = append(users, user)
+	}
+	slices.Sort(users)
+	return users
+}
@@ -0,0 +1,186 @@
+package websockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"chat-app/buildinfo"
+	"chat-app/storage"
+)
+
+/*
+Protocol Overview:
+-----------------
+Every connection opens with a hello frame from the server naming the
+protocol version it speaks and the server build:
+
+	{"type": "hello", "protocol": 2, "server": "v1.4.0", "room": "lobby", ...}
+
+Clients should refuse, or fall back, when the protocol is newer than
+any they understand. New fields and frame types don't bump the
+version, so clients must ignore what they don't recognise. The hello
+also lists optional features, which a client may narrow down with a
+hello of its own (see capabilities.go).
+
+	1   online_users on every change, listing members comma-joined in content
+	2   presence_join and presence_leave deltas after an online_users
+	    snapshot listing members in users (see presence.go)
+
+A hub serves one version; WithLegacyPresence keeps it on 1.
+
+Clients may send either plain text, which is treated as a chat
+message (this keeps wscat and other simple clients working), or a
+JSON frame:
+
+	{"type": "chat", "content": "hello", "idempotency_key": "k-123"}
+
+Chat frames may set "qos" (see qos.go); recipients acknowledge
+messages that carry a qos with {"type": "ack", "id": "..."}. Any
+message can be reported to moderators with
+{"type": "report", "id": "...", "content": "reason"} (see review.go),
+pinned with {"type": "pin", "id": "..."} (see permissions.go),
+replied to with {"type": "reply", "reply_to": "...", "content": "..."}
+(see replies.go), and taken back by its sender with
+{"type": "recall", "id": "..."} (see recall.go).
+Stickers are posted with
+{"type": "sticker", "sticker": {"pack": "cats", "id": "wave"}} (see
+stickers.go), and uploaded voice notes with
+{"type": "audio", "audio": {"id": "..."}} (see audio.go). Files are
+sent peer to peer after a transfer_* handshake (see transfer.go), or
+uploaded to S3 and posted with
+{"type": "attachment", "attachment": {"id": "..."}} (see attachment.go).
+
+Devices of one user share small state, like drafts, with
+{"type": "sync", "sync": {"key": "draft:lobby", "value": "..."}} (see
+sync.go), and notification preferences with
+{"type": "set_preferences", "preferences": {...}}, or mute the room's
+notifications with {"type": "mute", "for": "8h"} (see
+notifications.go).
+
+Chat content is cleaned of HTML and invisible characters (see the
+sanitize package); messages using Markdown carry a formatted segment
+list (see the markdown package).
+
+Frames with an idempotency key are acknowledged to the sender:
+
+	{"type": "ack", "id": "...", "seq": 42, "idempotency_key": "k-123", ...}
+
+Problems with a frame are reported to the sender only:
+
+	{"type": "error", "code": "unknown_type", "content": "..."}
+*/
+
+// ProtocolVersion is the newest wire protocol, announced in the hello frame
+// Bump it only for changes old clients would misread
+const ProtocolVersion = 2
+
+// SupportedProtocols lists every protocol version this server can serve
+var SupportedProtocols = []int{LegacyProtocol, ProtocolVersion}
+
+// helloMessage builds the first frame sent on a new connection
+func helloMessage(to *Client, protocol int) Message {
+	return Message{
+		Type:       "hello",
+		Content:    "welcome to " + to.room,
+		RoomName:   to.room,
+		Username:   to.username,
+		Protocol:   protocol,
+		Server:     buildinfo.Get().Version,
+		Features:   SupportedFeatures(),
+		ServerTime: time.Now().UnixMilli(),
+	}
+}
+
+// inboundFrame is a structured message from a client
+type inboundFrame struct {
+	Type           string `json:"type"`
+	ID             string `json:"id"`
+	Content        string `json:"content"`
+	IdempotencyKey string `json:"idempotency_key"`
+	QoS            string `json:"qos"`
+
+	// The sticker to post, on sticker frames
+	Sticker *Sticker `json:"sticker"`
+	// The uploaded clip to post, on audio frames
+	Audio *Audio `json:"audio"`
+	// The key to set, on sync frames
+	Sync *Sync `json:"sync"`
+	// The levels to change, on set_preferences frames
+	Preferences *storage.NotificationPrefs `json:"preferences"`
+	// How long to mute the room, on mute frames, e.g. "8h"; empty until
+	// unmuted. How long an invite lasts, on invite_token frames
+	For string `json:"for"`
+	// The uploaded file to post, on attachment frames
+	Attachment *Attachment `json:"attachment"`
+	// The recipient, username or user@server, on dm frames
+	To string `json:"to"`
+	// The message quoted, on reply frames
+	ReplyTo string `json:"reply_to"`
+	// The file offered, on transfer_offer frames, and the opaque WebRTC
+	// signal on transfer_signal frames
+	Transfer *Transfer       `json:"transfer"`
+	Signal   json.RawMessage `json:"signal"`
+	// The client's clock in Unix milliseconds, on time frames
+	ClientTime int64 `json:"client_time"`
+	// The optional features the client handles, on hello frames
+	Features []string `json:"features"`
+}
+
+// maxIdempotencyKeyLen bounds keys so they can't be used to bloat the cache
+const maxIdempotencyKeyLen = 128
+
+// Error codes sent in error frames
+const (
+	errCodeBadFrame          = "bad_frame"
+	errCodeUnknownType       = "unknown_type"
+	errCodeStorage           = "storage_unavailable"
+	errCodeBusy              = "server_busy"
+	errCodeLinkBlocked       = "link_blocked"
+	errCodeEmptyMessage      = "empty_message"
+	errCodeUnknownSticker    = "unknown_sticker"
+	errCodeUnknownAudio      = "unknown_audio"
+	errCodeUnknownTransfer   = "unknown_transfer"
+	errCodeUnknownRecipient  = "unknown_recipient"
+	errCodeUnknownAttachment = "unknown_attachment"
+	errCodeUploadIncomplete  = "upload_incomplete"
+	errCodeQuotaExceeded     = "quota_exceeded"
+	errCodeTenantRateLimited = "tenant_rate_limited"
+	errCodeRoomArchived      = "room_archived"
+	errCodeNotInvited        = "not_invited"
+)
+
+// parseFrame decodes raw client input; plain text becomes a chat frame
+func parseFrame(data []byte) (inboundFrame, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return inboundFrame{Type: "chat", Content: string(data)}, nil
+	}
+
+	var frame inboundFrame
+	if err := json.Unmarshal(trimmed, &frame); err != nil {
+		return frame, errors.New("invalid JSON frame")
+	}
+	if frame.Type == "" {
+		frame.Type = "chat"
+	}
+	if len(frame.IdempotencyKey) > maxIdempotencyKeyLen {
+		return frame, errors.New("idempotency_key too long")
+	}
+	if !validQoS(frame.QoS) {
+		return frame, errors.New("unknown qos: " + frame.QoS)
+	}
+	return frame, nil
+}
+
+// errorMessage builds an error frame addressed to a single client
+func errorMessage(to *Client, code, text string) Message {
+	return Message{
+		Type:     "error",
+		Code:     code,
+		Content:  text,
+		RoomName: to.room,
+		to:       to,
+	}
+}
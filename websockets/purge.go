@@ -0,0 +1,182 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/eventlog"
+	"chat-app/storage"
+)
+
+/*
+Purge Overview:
+--------------
+Moderators remove a raid or spam run in one action (see
+api/purge.go): a room's last N messages, optionally only one user's,
+or everything a user sent in a time range. The messages are found
+in the event log, then:
+
+1. Their stored copies are removed
+2. Their review queue entries are resolved
+3. One moderation event records the purge (see storage.Purge), so
+   history leaves them out
+4. The room is told with one compact frame rather than a moderation
+   frame per message:
+
+	{"type": "messages_purged", "code": "deleted", "room": "lobby", "username": "sam",
+	 "content": "120 messages were removed",
+	 "purge": {"username": "eve", "since": 1718010000000, "until": 1718010300000, "count": 120}}
+
+Clients drop the messages from purge.username (anyone's when absent)
+whose server_time is within since and until, inclusive. username on
+the frame is the moderator. Bans hiding messages send the same frame
+with code hidden (see cascade.go).
+*/
+
+// MaxPurgeLast bounds how many of a room's last messages one purge takes
+const MaxPurgeLast = 10000
+
+// Moderation rule and review decision naming purges
+const rulePurge = "purge"
+
+// Purged describes the messages removed on messages_purged frames
+type Purged struct {
+	Username string `json:"username,omitempty"`
+	Since    int64  `json:"since,omitempty"` // Unix milliseconds; 0 from the start
+	Until    int64  `json:"until"`           // Unix milliseconds
+	Count    int    `json:"count"`
+}
+
+// PurgeMessages removes the messages of room p selects, or with last
+// above zero the newest last of them, telling the room; by names the
+// moderator. It returns the purge as applied, with since set when last
+// chose it, and how many messages it removed; none is not an error
+// Safe to call from any goroutine; the log is read off the hub goroutine
+func (h *LocalHub) PurgeMessages(ctx context.Context, room, by string, p storage.Purge, last int) (storage.Purge, int, error) {
+	// Messages the hub takes from here on are left alone
+	now := time.Now()
+	if p.Until.IsZero() || p.Until.After(now) {
+		p.Until = now
+	}
+	matched, err := eventlog.Matching(ctx, h.store, room, p, last)
+	if err != nil {
+		return p, 0, err
+	}
+	if len(matched) == 0 {
+		return p, 0, nil
+	}
+	if last > 0 {
+		p.Since = matched[0].CreatedAt
+	}
+
+	// Step 1: Stored copies, for messages sent with a reliable QoS
+	if _, err := h.store.PurgeMessages(ctx, room, p); err != nil {
+		return p, 0, err
+	}
+
+	// Step 2: Review queue entries for the purged messages
+	ids := make(map[string]bool, len(matched))
+	for _, m := range matched {
+		ids[m.ID] = true
+	}
+	resolved := h.purgeReviews(ctx, room, ids)
+
+	// Steps 3 and 4: One event and one frame for all of them
+	count := len(matched)
+	h.query(func() {
+		data := eventlog.PurgeData(p)
+		data["by"] = by
+		data["count"] = strconv.Itoa(count)
+		h.recordModeration(rulePurge, room, "", p.Username, eventlog.ActionPurge, data)
+		for _, item := range resolved {
+			h.notifyModerators(ModQueueResolved, rulePurge, item)
+		}
+		h.handleBroadcast(purgedMessage(room, by, p, count, ModerationDeleted))
+	})
+	return p, count, nil
+}
+
+// purgeReviews removes the review queue entries for room's messages in
+// ids, returning them
+func (h *LocalHub) purgeReviews(ctx context.Context, room string, ids map[string]bool) []storage.ReviewItem {
+	items, err := h.store.ReviewItems(ctx, math.MaxInt32)
+	if err != nil {
+		reportStorageError("load review items", err, errreport.Context{Room: room})
+		return nil
+	}
+	var resolved []storage.ReviewItem
+	for _, item := range items {
+		if item.Room != room || !ids[item.MessageID] {
+			continue
+		}
+		if err := h.store.DeleteReviewItem(ctx, item.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			reportStorageError("delete review item", err, errreport.Context{Room: room})
+			continue
+		}
+		resolved = append(resolved, item)
+	}
+	return resolved
+}
+
+// forgetPurged stops redelivering and remembering the messages a purge
+// removed; run by the room's owner as the frame passes through
+func (h *LocalHub) forgetPurged(msg Message) {
+	room, purge := msg.RoomName, msg.Purge
+	if purge == nil || msg.Code == ModerationHidden {
+		return
+	}
+	p := purge.selection()
+	for _, deliveries := range h.pending {
+		for id, d := range deliveries {
+			if d.msg.RoomName == room && p.Matches(d.msg.Username, time.UnixMilli(d.msg.ServerTime)) {
+				delete(deliveries, id)
+			}
+		}
+	}
+	h.recent.purge(room, p)
+}
+
+// purge forgets room's recent messages p selects
+func (r *recentMessages) purge(room string, p storage.Purge) {
+	kept := r.order[:0]
+	for _, id := range r.order {
+		msg := r.byID[id]
+		if msg.RoomName == room && p.Matches(msg.Username, time.UnixMilli(msg.ServerTime)) {
+			delete(r.byID, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	r.order = kept
+}
+
+// selection is the purge the frame describes, at millisecond precision
+func (p *Purged) selection() storage.Purge {
+	s := storage.Purge{Username: p.Username, Until: time.UnixMilli(p.Until)}
+	if p.Since > 0 {
+		s.Since = time.UnixMilli(p.Since)
+	}
+	return s
+}
+
+// purgedMessage is the messages_purged frame for p; code is
+// ModerationDeleted or ModerationHidden
+func purgedMessage(room, by string, p storage.Purge, count int, code string) Message {
+	purged := &Purged{Username: p.Username, Until: p.Until.UnixMilli(), Count: count}
+	if !p.Since.IsZero() {
+		purged.Since = p.Since.UnixMilli()
+	}
+	verb := "removed"
+	if code == ModerationHidden {
+		verb = "hidden"
+	}
+	content := strconv.Itoa(count) + " messages were " + verb
+	if count == 1 {
+		content = "1 message was " + verb
+	}
+	return Message{Type: "messages_purged", Code: code, Content: content, RoomName: room, Username: by, Purge: purged}
+}
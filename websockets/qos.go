@@ -0,0 +1,267 @@
+package websockets
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/markdown"
+	"chat-app/storage"
+)
+
+/*
+QoS Overview:
+------------
+Senders choose a delivery guarantee per message with the "qos" field:
+
+	fire_and_forget (default)  Delivered to whoever is online, once.
+	at_least_once              Re-sent to each online recipient until they
+	                           ack it.
+	durable                    Like at_least_once, and also queued for room
+	                           members who are offline; they get it on rejoin.
+
+Recipients acknowledge messages that carry a qos with:
+
+	{"type": "ack", "id": "<message id>"}
+
+Re-sent and queued copies have "redelivered": true; clients should
+dedupe them by ID and not use them for ordering checks. Whatever the
+qos, room messages are stored before they are broadcast (see
+writethrough.go).
+*/
+
+// QoS levels accepted in the "qos" field
+const (
+	QoSFireAndForget = "fire_and_forget"
+	QoSAtLeastOnce   = "at_least_once"
+	QoSDurable       = "durable"
+)
+
+const (
+	// How long to wait for a recipient's ack before re-sending
+	redeliveryInterval = 5 * time.Second
+
+	// Deliveries given up after this many unacked attempts
+	maxDeliveryAttempts = 5
+
+	// Bound on unacked messages tracked per client
+	maxPendingPerClient = 1000
+
+	// Bound on any single storage call made from the hub
+	storageTimeout = 5 * time.Second
+)
+
+// validQoS reports whether q is an accepted QoS level ("" means default)
+func validQoS(q string) bool {
+	switch q {
+	case "", QoSFireAndForget, QoSAtLeastOnce, QoSDurable:
+		return true
+	}
+	return false
+}
+
+// reliable reports whether a QoS level requires persistence and acks
+func reliable(q string) bool {
+	return q == QoSAtLeastOnce || q == QoSDurable
+}
+
+// pendingDelivery is a message a recipient hasn't acked yet
+type pendingDelivery struct {
+	msg      Message
+	attempts int
+	next     time.Time
+}
+
+// storageContext bounds a storage call made from the hub goroutine
+func storageContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), storageTimeout)
+}
+
+// reportStorageError logs and reports a failed storage call
+func reportStorageError(op string, err error, ctx errreport.Context) {
+	log.Printf("Storage error (%s) room=%s user=%s conn=%s: %v", op, ctx.Room, ctx.Username, ctx.ConnID, err)
+	errreport.CaptureError(fmt.Errorf("%s: %w", op, err), ctx)
+}
+
+// persist saves a message before it is broadcast; called by the writers
+func (h *LocalHub) persist(msg Message) error {
+	ctx, cancel := storageContext()
+	defer cancel()
+	return h.store.SaveMessage(ctx, storage.Message{
+		ID:         msg.ID,
+		Room:       msg.RoomName,
+		Username:   msg.Username,
+		Type:       msg.Type,
+		Content:    msg.Content,
+		Seq:        msg.Seq,
+		QoS:        msg.QoS,
+		CreatedAt:  time.UnixMilli(msg.ServerTime),
+		Sticker:    stickerRef(msg.Sticker),
+		Audio:      audioRef(msg.Audio),
+		Attachment: attachmentRef(msg.Attachment),
+		Quote:      msg.Quote,
+	})
+}
+
+// trackDeliveries starts waiting for acks from everyone the message went to
+func (h *LocalHub) trackDeliveries(msg Message, now time.Time) {
+	for client := range h.rooms[msg.RoomName] {
+		if client == msg.sender {
+			continue // The sender already has the server's ack
+		}
+		h.trackDelivery(client, msg, now)
+	}
+}
+
+func (h *LocalHub) trackDelivery(client *Client, msg Message, now time.Time) {
+	if h.pending[client] == nil {
+		h.pending[client] = make(map[string]*pendingDelivery)
+	}
+	if len(h.pending[client]) >= maxPendingPerClient {
+		return // Client isn't acking; don't let it grow the hub without bound
+	}
+	msg.sender, msg.to, msg.ctx, msg.lookup = nil, nil, nil, nil
+	h.pending[client][msg.ID] = &pendingDelivery{msg: msg, attempts: 1, next: now.Add(redeliveryInterval)}
+}
+
+// handleDeliveryAck clears a recipient's pending delivery
+func (h *LocalHub) handleDeliveryAck(client *Client, id string) {
+	delete(h.pending[client], id)
+}
+
+// retryDeliveries re-sends unacked messages whose retry time has come
+func (h *LocalHub) retryDeliveries(now time.Time) {
+	for client, deliveries := range h.pending {
+		for id, d := range deliveries {
+			if now.Before(d.next) {
+				continue
+			}
+			if d.attempts >= maxDeliveryAttempts {
+				delete(deliveries, id)
+				continue
+			}
+			d.attempts++
+			d.next = now.Add(redeliveryInterval)
+
+			redelivery := d.msg
+			redelivery.Redelivered = true
+			h.sendTo(client, redelivery)
+		}
+	}
+}
+
+// queueForOfflineMembers holds a durable message for members who aren't
+// connected now; the store is written off the hub goroutine
+func (h *LocalHub) queueForOfflineMembers(msg Message) {
+	room, online := msg.RoomName, h.onlineIn(msg.RoomName)
+	h.storeInOrder(room, func() {
+		ctx, cancel := storageContext()
+		members, err := h.store.Members(ctx, room)
+		cancel()
+		if err != nil {
+			reportStorageError("members", err, errreport.Context{Room: room})
+			return
+		}
+		for _, user := range members {
+			if !online[user] {
+				h.queueOffline(user, room, msg.ID)
+			}
+		}
+	})
+}
+
+// queueOffline holds message id for username's return to room; called
+// off the hub goroutine
+func (h *LocalHub) queueOffline(username, room, id string) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := h.store.QueueOffline(ctx, username, room, id); err != nil {
+		reportStorageError("queue offline", err, errreport.Context{Room: room, Username: username})
+	}
+}
+
+// onlineIn returns who is connected to room, here or on other nodes
+func (h *LocalHub) onlineIn(room string) map[string]bool {
+	online := make(map[string]bool)
+	for client := range h.rooms[room] {
+		online[client.username] = true
+	}
+	for _, users := range h.remoteUsers[room] {
+		for _, user := range users {
+			online[user] = true
+		}
+	}
+	return online
+}
+
+// takeOffline makes a joining client's user a member of its room and
+// takes everything queued for them there while away; called off the
+// hub goroutine (see loadArrival)
+func (h *LocalHub) takeOffline(client *Client) []Message {
+	ctx, cancel := storageContext()
+	defer cancel()
+
+	if err := h.store.AddMember(ctx, client.room, client.username); err != nil {
+		reportStorageError("add member", err, client.reportContext())
+	}
+
+	queued, err := h.store.TakeOffline(ctx, client.username, client.room)
+	if err != nil {
+		reportStorageError("take offline", err, client.reportContext())
+		return nil
+	}
+	messages := make([]Message, 0, len(queued))
+	for _, stored := range queued {
+		messages = append(messages, Message{
+			Type:        stored.Type,
+			ID:          stored.ID,
+			Content:     stored.Content,
+			Formatted:   markdown.Format(stored.Content),
+			Sticker:     storedSticker(stored),
+			Audio:       h.storedAudio(stored),
+			Attachment:  h.storedAttachment(stored),
+			Quote:       stored.Quote,
+			RoomName:    stored.Room,
+			Username:    stored.Username,
+			Seq:         stored.Seq,
+			QoS:         stored.QoS,
+			ServerTime:  stored.CreatedAt.UnixMilli(),
+			Redelivered: true,
+		})
+	}
+	return messages
+}
+
+// deliverOffline sends a joined client the messages queued for it
+// while away
+func (h *LocalHub) deliverOffline(client *Client, queued []Message, now time.Time) {
+	for _, msg := range queued {
+		h.sendTo(client, msg)
+		h.trackDelivery(client, msg, now)
+	}
+}
+
+// dropPending forgets a departing client's unacked deliveries,
+// re-queueing durable ones so the user still gets them on rejoin
+func (h *LocalHub) dropPending(client *Client) {
+	deliveries := h.pending[client]
+	delete(h.pending, client)
+	var durable []string
+	for id, d := range deliveries {
+		if d.msg.QoS == QoSDurable {
+			durable = append(durable, id)
+		}
+	}
+	if len(durable) == 0 {
+		return
+	}
+
+	username, room := client.username, client.room
+	h.storeInOrder(room, func() {
+		for _, id := range durable {
+			h.queueOffline(username, room, id)
+		}
+	})
+}
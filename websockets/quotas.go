@@ -0,0 +1,66 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"chat-app/metrics"
+	"chat-app/quota"
+	"chat-app/storage"
+)
+
+/*
+Quota Overview:
+--------------
+WithQuotas holds each user to daily limits (see the quota package).
+Messages are counted as they are read, once cleaned and past the
+link policy, so what is refused never reaches the hub; the count
+is a round trip to the store, so it runs on the connection's own
+goroutine. A chat message counts its content's bytes. Voice notes
+and attachments count their bytes when they are uploaded (see
+api.RegisterAudio and api.RegisterUploads), so posting one counts
+only the message.
+
+Once a quota is used up, messages get an error until it resets:
+
+	{"type": "error", "code": "quota_exceeded", "retry_after_ms": 3600000,
+	 "content": "daily messages quota of 500 reached, it resets at 00:00 UTC"}
+
+If the store fails, messages go through uncounted; a quota is an
+abuse ceiling, not a reason to stop a room talking.
+*/
+
+// WithQuotas counts messages against users' daily quotas
+func WithQuotas(t *quota.Tracker) Option {
+	return func(o *handlerOptions) {
+		if t.Enabled() {
+			o.quotas = t
+		}
+	}
+}
+
+// takeQuota counts a message with bytes of content against c's quota,
+// telling the client and returning false when it doesn't fit
+func (c *Client) takeQuota(ctx context.Context, bytes int) bool {
+	if c.quotas == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, storageTimeout)
+	defer cancel()
+
+	now := time.Now()
+	_, err := c.quotas.Take(ctx, c.username, storage.Usage{Messages: 1, Bytes: int64(bytes)}, now)
+	var exceeded *quota.ExceededError
+	switch {
+	case errors.As(err, &exceeded):
+		metrics.QuotaRejections.WithLabelValues(exceeded.Counter).Inc()
+		msg := errorMessage(c, errCodeQuotaExceeded, exceeded.Error())
+		msg.RetryAfterMs = exceeded.ResetsAt.Sub(now).Milliseconds()
+		c.hub.Broadcast(msg)
+		return false
+	case err != nil:
+		reportStorageError("take quota", err, c.reportContext())
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package websockets
+
+import (
+	"errors"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Recall Overview:
+---------------
+WithRecallWindow lets senders take a message back ("undo send") for a
+short while after posting it:
+
+	{"type": "recall", "id": "..."}
+
+The room's owner checks the message is the sender's and still within
+the window, then:
+
+1. Removes its stored copy and stops redelivering it
+2. Resolves any review queue entry for it
+3. Records a recall event in the event log, so history leaves it out
+   and the audit trail tells it apart from moderators' deletions
+4. Tells the room:
+
+	{"type": "recall", "id": "...", "room": "lobby", "username": "alice"}
+
+Clients drop the message. Recalls of someone else's or an unknown
+message get unknown_message, late ones recall_expired, and any when
+the window is 0, recall_disabled.
+*/
+
+// Error codes for recalls
+const (
+	errCodeRecallExpired  = "recall_expired"
+	errCodeRecallDisabled = "recall_disabled"
+)
+
+// WithRecallWindow lets senders recall their messages for d after sending
+// them; 0, the default, turns recalls off
+func WithRecallWindow(d time.Duration) HubOption {
+	return func(h *LocalHub) {
+		h.recallWindow = d
+	}
+}
+
+// resolveRecall checks a recall and removes the message, filling in the
+// frame sent to the room
+// It reports false, after telling the sender, if the recall is refused,
+// and while the message is fetched from storage
+func (h *LocalHub) resolveRecall(msg *Message) bool {
+	refuse := func(code, content string) bool {
+		h.reply(*msg, Message{Type: "error", Code: code, ID: msg.ID, Content: content, RoomName: msg.RoomName})
+		return false
+	}
+	if h.recallWindow <= 0 {
+		return refuse(errCodeRecallDisabled, "messages can't be recalled on this server")
+	}
+	recalled, ok, pending := h.findMessage(*msg, msg.ID)
+	if pending {
+		return false // Back once it is fetched
+	}
+	if !ok || recalled.author != msg.Username {
+		return refuse(errCodeUnknownMessage, "no message of yours to recall")
+	}
+	now := time.Now()
+	if now.Sub(recalled.sent) > h.recallWindow {
+		return refuse(errCodeRecallExpired, "messages can only be recalled within "+h.recallWindow.String())
+	}
+
+	// Step 1: The stored copy and any redeliveries
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := h.store.DeleteMessage(ctx, msg.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		reportStorageError("delete message", err, errreport.Context{Room: msg.RoomName, Username: msg.Username})
+	}
+	for _, deliveries := range h.pending {
+		delete(deliveries, msg.ID)
+	}
+	h.recent.remove(msg.ID)
+
+	// Steps 2 and 3: The review queue and the event log
+	h.dropFromReview(msg.ID, "recall")
+	h.recordEvent(storage.Event{
+		Room:      msg.RoomName,
+		Type:      storage.EventRecall,
+		Username:  msg.Username,
+		MessageID: msg.ID,
+		CreatedAt: now,
+	})
+	msg.Content, msg.IdempotencyKey = "", ""
+	return true
+}
+
+// remove forgets a recent message
+func (r *recentMessages) remove(id string) {
+	if _, ok := r.byID[id]; !ok {
+		return
+	}
+	delete(r.byID, id)
+	for i, recentID := range r.order {
+		if recentID == id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package websockets
+
+import (
+	"math/rand/v2"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+Reconnect Overview:
+------------------
+To move connections off a node (draining it for a deploy, or
+rebalancing a hot node) the hub tells clients where and when to
+come back instead of just dropping them:
+
+	{"type": "reconnect", "code": "draining", "url": "ws://10.0.0.6:8080",
+	 "retry_after_ms": 7421, "content": "server is draining"}
+
+url is a server base URL; clients join their room there the same way
+they joined here. Without url, clients reconnect to the address they
+used before (normally a load balancer). retry_after_ms is drawn at
+random from the spread window so thousands of clients don't all
+reconnect in the same instant.
+
+Clients should close and reconnect once retry_after_ms has passed.
+The server closes any connection still open reconnectGrace after its
+deadline.
+
+While the hub is draining, /ws rejects new connections with 503 and
+Retry-After, and /health reports 503 so load balancers stop routing
+here.
+*/
+
+// Reconnect reasons sent in the frame's code
+const (
+	ReconnectDraining  = "draining"
+	ReconnectRebalance = "rebalance"
+	ReconnectMaxAge    = "max_age" // See lifetime.go
+)
+
+// reconnectGrace is how long past its deadline a redirected client may linger
+const reconnectGrace = 5 * time.Second
+
+// Redirect selects connections to move and says where to send them
+type Redirect struct {
+	Room   string                   // Only this room; empty for every room
+	Limit  int                      // At most this many connections; 0 for all
+	Target func(room string) string // Server base URL for a client in room; nil or "" for no hint
+	Spread time.Duration            // Reconnects are spread at random over this window
+	Reason string                   // ReconnectDraining, ReconnectRebalance or ReconnectMaxAge
+	Resume bool                     // Hand each client a resume token, see lifetime.go
+}
+
+// Redirect asks the selected clients to reconnect, returning how many were asked
+func (h *LocalHub) Redirect(r Redirect) int {
+	asked := 0
+	h.query(func() {
+		for client := range h.clients {
+			if r.Limit > 0 && asked >= r.Limit {
+				break
+			}
+			if (r.Room != "" && client.room != r.Room) || client.redirected {
+				continue
+			}
+			h.redirect(client, r)
+			asked++
+		}
+	})
+	return asked
+}
+
+// redirect sends one client its reconnect frame and schedules its disconnect
+func (h *LocalHub) redirect(client *Client, r Redirect) {
+	var delay time.Duration
+	if r.Spread > 0 {
+		delay = rand.N(r.Spread)
+	}
+	msg := Message{
+		Type:         "reconnect",
+		Code:         r.Reason,
+		Content:      "please reconnect",
+		RoomName:     client.room,
+		RetryAfterMs: delay.Milliseconds(),
+	}
+	if r.Reason == ReconnectDraining {
+		msg.Content = "server is draining"
+	}
+	if r.Target != nil {
+		msg.URL = websocketURL(r.Target(client.room))
+	}
+	if r.Resume {
+		msg.ResumeToken = h.issueResume(client)
+	}
+	client.redirected = true
+	h.sendTo(client, msg)
+
+	// Close stragglers that ignored the hint
+	time.AfterFunc(delay+reconnectGrace, func() {
+		h.query(func() {
+			if h.clients[client] {
+				h.disconnect(client, closeReasonRedirect)
+			}
+		})
+	})
+}
+
+// disconnect closes a client from the server side and tells its room
+func (h *LocalHub) disconnect(client *Client, reason string) {
+	close(client.send)
+	h.removeClient(client, reason)
+	h.handleBroadcast(Message{
+		Type:     "user_left",
+		Content:  client.username + " left the room",
+		RoomName: client.room,
+		Username: client.username,
+	})
+	h.broadcastRoomUsers(client.room)
+	h.closeRoomIfEmpty(client.room)
+}
+
+// Drain stops accepting connections and redirects everyone already connected
+func (h *LocalHub) Drain(target func(room string) string, spread time.Duration) int {
+	h.draining.Store(true)
+	return h.Redirect(Redirect{Target: target, Spread: spread, Reason: ReconnectDraining})
+}
+
+// Resume accepts connections again after Drain
+// Clients already redirected still leave
+func (h *LocalHub) Resume() {
+	h.draining.Store(false)
+}
+
+// Draining reports whether the hub is refusing new connections
+func (h *LocalHub) Draining() bool {
+	return h.draining.Load()
+}
+
+// drainer is implemented by hubs that can refuse new connections
+type drainer interface {
+	Draining() bool
+}
+
+// websocketURL converts an http(s) base URL to ws(s); others pass through
+func websocketURL(base string) string {
+	u, err := url.Parse(strings.TrimRight(base, "/"))
+	if err != nil || base == "" {
+		return base
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	return u.String()
+}
@@ -0,0 +1,95 @@
+package websockets
+
+import (
+	"bytes"
+	"io"
+	"log"
+
+	"chat-app/recording"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Session Recording Overview:
+--------------------------
+With WithRecorder, connections the recorder picks (see the recording
+package) get a Conn that copies every text frame read or written into
+the recording. It wraps the connection beneath any injected faults,
+so it records what crossed the wire: a frame chaos drops on the way
+out was never sent, and one it drops on the way in was still sent.
+*/
+
+// WithRecorder records the frames of the connections r picks
+func WithRecorder(r *recording.Recorder) Option {
+	return func(o *handlerOptions) {
+		o.recorder = r
+	}
+}
+
+// recordedConn is a Conn copying its text frames into a recording
+type recordedConn struct {
+	Conn
+	session *recording.Session
+}
+
+// recordSession wraps conn to record it when r records username
+func recordSession(conn Conn, r *recording.Recorder, h recording.Header) Conn {
+	if !r.Records(h.Username) {
+		return conn
+	}
+	session, err := r.Start(h)
+	if err != nil {
+		log.Printf("Recording: can't record conn=%s: %v", h.Conn, err)
+		return conn
+	}
+	return &recordedConn{Conn: conn, session: session}
+}
+
+// ReadMessage records each text frame read
+func (c *recordedConn) ReadMessage() (int, []byte, error) {
+	messageType, p, err := c.Conn.ReadMessage()
+	if err == nil && messageType == websocket.TextMessage {
+		c.session.Record(recording.In, p)
+	}
+	return messageType, p, err
+}
+
+// NextWriter records text frames as they are finished
+func (c *recordedConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	w, err := c.Conn.NextWriter(messageType)
+	if err != nil || messageType != websocket.TextMessage {
+		return w, err
+	}
+	return &recordedWriter{WriteCloser: w, session: c.session}, nil
+}
+
+// Close ends the recording along with the connection
+func (c *recordedConn) Close() error {
+	err := c.Conn.Close()
+	if serr := c.session.Close(); serr != nil {
+		log.Printf("Recording: %v", serr)
+	}
+	return err
+}
+
+// recordedWriter keeps a copy of the frame being written
+type recordedWriter struct {
+	io.WriteCloser
+	session *recording.Session
+	frame   bytes.Buffer
+}
+
+func (w *recordedWriter) Write(p []byte) (int, error) {
+	w.frame.Write(p)
+	return w.WriteCloser.Write(p)
+}
+
+// Close sends the frame, recording it once it is sent
+func (w *recordedWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	w.session.Record(recording.Out, w.frame.Bytes())
+	return nil
+}
@@ -0,0 +1,383 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"chat-app/tracing"
+)
+
+/*
+Relay Overview:
+--------------
+In a cluster every room has one owner node, chosen by consistent
+hashing (cluster/ring.go). The owner's hub is the room's single
+writer: it assigns Seq, persists reliable messages and keeps the
+idempotency cache. Other nodes with members in the room subscribe
+to it:
+
+1. A subscriber forwards its clients' messages to the owner instead
+   of broadcasting them itself
+2. The owner fans the message out to its own clients, then relays the
+   finished message (with ID and Seq) to each subscriber, which fans
+   it out to its clients
+3. Acks and errors for a forwarded send go back to the node and
+   connection it came from, and other private frames to the nodes
+   the recipients are connected to
+4. Subscribers report their members of the room to the owner, which
+   merges them into the room's presence and counts them as online
+   when queueing durable messages
+
+A node subscribes by reporting a non-empty member list and leaves by
+reporting an empty one, so a room only generates traffic between the
+nodes that have members in it rather than every node hearing every
+message.
+
+Ownership moves when nodes join or leave. Every node then re-reports
+its rooms to their current owners, including the last Seq it saw,
+and a new owner continues each room's sequence from the highest
+reported value. Frames in flight during a handover can be lost; a
+sender that needs certainty should use an idempotency key and retry
+until acked. An owner accepts forwarded messages even if it has
+already handed the room on, so a message is never bounced between
+nodes that briefly disagree.
+*/
+
+// Peers connects the hub to the other nodes of a cluster
+// *cluster.Cluster implements it
+type Peers interface {
+	// Name is this node's name
+	Name() string
+	// Owner returns the node owning room, or "" if none can
+	Owner(room string) string
+	// Alive reports whether node is currently healthy
+	Alive(node string) bool
+	// Send queues a frame for node without blocking
+	Send(node string, frame []byte)
+	// OnFrame sets the handler for frames from other nodes
+	OnFrame(fn func(from string, frame []byte))
+	// OnChange registers fn to run after membership changes
+	OnChange(fn func())
+}
+
+// Relay frame kinds
+const (
+	frameForward = "forward" // Subscriber to owner: a client's message
+	frameDeliver = "deliver" // Owner to subscriber: a finished message to fan out
+	frameReply   = "reply"   // Owner to subscriber: an ack or error for one connection
+	frameMembers = "members" // Subscriber to owner: who is in the room here
+	frameUser    = "user"    // Owner to subscriber: a private frame for some users' connections
+
+	frameFederated = "federated" // Any node to owner: a frame from another server, see federation.go
+)
+
+// relayFrame is the unit of traffic between hubs on different nodes
+type relayFrame struct {
+	Kind      string            `json:"kind"`
+	Room      string            `json:"room"`
+	Message   *Message          `json:"message,omitempty"`
+	Conn      string            `json:"conn,omitempty"`      // Connection a forwarded message came from
+	Users     []string          `json:"users,omitempty"`     // Members, for frameMembers; recipients, for frameUser
+	LastSeq   uint64            `json:"last_seq,omitempty"`  // Last Seq the subscriber saw, for frameMembers
+	Devices   map[string]string `json:"devices,omitempty"`   // Member device types, for frameMembers
+	Avatars   map[string]string `json:"avatars,omitempty"`   // Member avatar URLs, for frameMembers
+	Statuses  map[string]string `json:"statuses,omitempty"`  // Away members, for frameMembers
+	Federated *federationFrame  `json:"federated,omitempty"` // For frameFederated
+	Server    string            `json:"server,omitempty"`    // The server it came from, for frameFederated
+	Trace     http.Header       `json:"trace,omitempty"`
+
+	from string // Node the frame arrived from
+}
+
+// origin identifies where a forwarded message was sent from
+type origin struct {
+	node string
+	conn string // Connection ID; empty for hub-generated messages
+}
+
+// WithPeers shards rooms across the nodes of a cluster
+func WithPeers(peers Peers) HubOption {
+	return func(h *LocalHub) {
+		h.peers = peers
+		peers.OnFrame(h.receiveFrame)
+		peers.OnChange(func() {
+			// Coalesce bursts of changes into one re-sync
+			select {
+			case h.ringChanged <- struct{}{}:
+			default:
+			}
+		})
+	}
+}
+
+// receiveFrame decodes a frame from a peer and hands it to the hub goroutine
+func (h *LocalHub) receiveFrame(from string, data []byte) {
+	var frame relayFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		log.Printf("Relay: bad frame from %s: %v", from, err)
+		return
+	}
+	frame.from = from
+	h.remote <- frame
+}
+
+// remoteOwner returns room's owner when it is another node
+func (h *LocalHub) remoteOwner(room string) (string, bool) {
+	if h.peers == nil {
+		return "", false
+	}
+	owner := h.peers.Owner(room)
+	if owner == "" || owner == h.peers.Name() {
+		return "", false
+	}
+	return owner, true
+}
+
+// sendFrame encodes frame and queues it for node
+func (h *LocalHub) sendFrame(ctx context.Context, node string, frame relayFrame) {
+	if ctx != nil {
+		frame.Trace = http.Header{}
+		tracing.Inject(ctx, frame.Trace)
+	}
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("Relay: error marshaling frame: %v", err)
+		return
+	}
+	h.peers.Send(node, data)
+}
+
+// forward sends msg to its room's owner if that is another node
+// It reports whether the owner will handle the message
+func (h *LocalHub) forward(msg Message) bool {
+	owner, ok := h.remoteOwner(msg.RoomName)
+	if !ok {
+		return false
+	}
+	frame := relayFrame{Kind: frameForward, Room: msg.RoomName, Message: &msg}
+	if msg.sender != nil {
+		frame.Conn = msg.sender.id
+	}
+	h.sendFrame(msg.ctx, owner, frame)
+	return true
+}
+
+// reply answers whoever sent msg, on this node or the one it was forwarded from
+func (h *LocalHub) reply(msg Message, reply Message) {
+	switch {
+	case msg.sender != nil:
+		h.sendTo(msg.sender, reply)
+	case msg.origin != nil && msg.origin.conn != "":
+		h.sendFrame(nil, msg.origin.node, relayFrame{
+			Kind:    frameReply,
+			Room:    msg.RoomName,
+			Message: &reply,
+			Conn:    msg.origin.conn,
+		})
+	}
+}
+
+// relay passes a finished message on to every subscribed node
+func (h *LocalHub) relay(ctx context.Context, msg Message) {
+	if h.peers == nil {
+		return
+	}
+	for node := range h.remoteUsers[msg.RoomName] {
+		frame := relayFrame{Kind: frameDeliver, Room: msg.RoomName, Message: &msg}
+		if msg.origin != nil {
+			frame.Conn = msg.origin.conn // Lets the sender's node skip its delivery tracking
+		}
+		h.sendFrame(ctx, node, frame)
+	}
+}
+
+// reportMembers tells room's owner who is in the room on this node
+// It reports false if this node owns the room
+func (h *LocalHub) reportMembers(room string, members map[string]Member) bool {
+	owner, ok := h.remoteOwner(room)
+	if !ok {
+		return false
+	}
+	frame := relayFrame{Kind: frameMembers, Room: room, LastSeq: h.seqs[room]}
+	for _, user := range sortedUsers(members) {
+		m := members[user]
+		frame.Users = append(frame.Users, user)
+		if m.Device != "" {
+			if frame.Devices == nil {
+				frame.Devices = make(map[string]string)
+			}
+			frame.Devices[user] = m.Device
+		}
+		if m.Avatar != "" {
+			if frame.Avatars == nil {
+				frame.Avatars = make(map[string]string)
+			}
+			frame.Avatars[user] = m.Avatar
+		}
+		if m.Status != "" {
+			if frame.Statuses == nil {
+				frame.Statuses = make(map[string]string)
+			}
+			frame.Statuses[user] = m.Status
+		}
+	}
+	h.sendFrame(nil, owner, frame)
+	return true
+}
+
+// handleFrame applies a frame from another node
+func (h *LocalHub) handleFrame(frame relayFrame) {
+	if frame.Kind != frameMembers && frame.Kind != frameFederated && frame.Message == nil {
+		log.Printf("Relay: %s frame from %s has no message", frame.Kind, frame.from)
+		return
+	}
+	ctx := context.Background()
+	if frame.Trace != nil {
+		ctx = tracing.Extract(ctx, frame.Trace)
+	}
+
+	switch frame.Kind {
+	case frameForward:
+		msg := *frame.Message
+		msg.ctx = ctx
+		msg.origin = &origin{node: frame.from, conn: frame.Conn}
+		h.handleBroadcast(msg)
+	case frameDeliver:
+		h.deliverRelayed(ctx, frame)
+	case frameReply:
+		if client, ok := h.conns[frame.Conn]; ok {
+			h.sendTo(client, *frame.Message)
+		}
+	case frameUser:
+		for client := range h.rooms[frame.Room] {
+			if slices.Contains(frame.Users, client.username) {
+				h.sendTo(client, *frame.Message)
+			}
+		}
+	case frameMembers:
+		h.handleMembers(frame)
+	case frameFederated:
+		if frame.Federated != nil {
+			fed := *frame.Federated
+			fed.from = frame.Server
+			h.handleFederated(fed)
+		}
+	default:
+		log.Printf("Relay: unknown frame kind %q from %s", frame.Kind, frame.from)
+	}
+}
+
+// deliverRelayed fans a message finished by the owner out to local clients
+func (h *LocalHub) deliverRelayed(ctx context.Context, frame relayFrame) {
+	received := time.Now()
+	msg := *frame.Message
+
+	// Remember the owner's Seq in case this node has to take over the room
+	if _, exists := h.rooms[msg.RoomName]; exists && msg.Seq > h.seqs[msg.RoomName] {
+		h.seqs[msg.RoomName] = msg.Seq
+	}
+
+	// Keep the owner's presence, to give joiners here a snapshot
+	h.mirrorPresence(msg)
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+	h.fanout(ctx, msg.RoomName, msg.Type, payload, isControl(msg.Type), received)
+
+	if reliable(msg.QoS) {
+		msg.sender = h.conns[frame.Conn] // Already acked by the owner
+		h.trackDeliveries(msg, received)
+	}
+}
+
+// handleMembers records a subscriber's members of a room this node owns
+func (h *LocalHub) handleMembers(frame relayFrame) {
+	room := frame.Room
+	if !h.roomActive(room) {
+		if len(frame.Users) == 0 {
+			return // Nothing to forget
+		}
+		h.resumeSeq(room)
+	}
+	h.seqs[room] = max(h.seqs[room], frame.LastSeq)
+	_, subscribed := h.remoteUsers[room][frame.from]
+
+	if len(frame.Users) == 0 {
+		h.forgetRemote(room, frame.from)
+	} else {
+		if h.remoteUsers[room] == nil {
+			h.remoteUsers[room] = make(map[string][]string)
+			h.remoteDevices[room] = make(map[string]map[string]string)
+			h.remoteAvatars[room] = make(map[string]map[string]string)
+			h.remoteStatuses[room] = make(map[string]map[string]string)
+		}
+		h.remoteUsers[room][frame.from] = frame.Users
+		h.remoteDevices[room][frame.from] = frame.Devices
+		h.remoteAvatars[room][frame.from] = frame.Avatars
+		h.remoteStatuses[room][frame.from] = frame.Statuses
+	}
+
+	h.broadcastRoomUsers(room)
+
+	// A node new to the room starts from a snapshot, deltas after that
+	if !subscribed && len(frame.Users) > 0 && !h.legacyPresence {
+		snapshot := h.presenceSnapshot(room, h.presence[room])
+		h.sendFrame(nil, frame.from, relayFrame{Kind: frameDeliver, Room: room, Message: &snapshot})
+	}
+	h.closeRoomIfEmpty(room)
+}
+
+// handleRingChange re-syncs rooms after cluster membership changes
+func (h *LocalHub) handleRingChange() {
+	self := h.peers.Name()
+
+	// Step 1: Forget members of rooms moved away or on nodes that are gone
+	for room, nodes := range h.remoteUsers {
+		for node := range nodes {
+			if h.peers.Owner(room) != self || !h.peers.Alive(node) {
+				h.forgetRemote(room, node)
+			}
+		}
+		if _, ok := h.remoteUsers[room]; !ok {
+			h.closeRoomIfEmpty(room)
+		}
+	}
+
+	// Step 2: Report local rooms to their owners, refreshing presence
+	// for rooms owned here
+	for room := range h.rooms {
+		h.broadcastRoomUsers(room)
+	}
+	for room := range h.remoteUsers {
+		if _, local := h.rooms[room]; !local {
+			h.broadcastRoomUsers(room)
+		}
+	}
+}
+
+// forgetRemote drops a node's members of room
+func (h *LocalHub) forgetRemote(room, node string) {
+	delete(h.remoteUsers[room], node)
+	delete(h.remoteDevices[room], node)
+	delete(h.remoteAvatars[room], node)
+	delete(h.remoteStatuses[room], node)
+	if len(h.remoteUsers[room]) == 0 {
+		delete(h.remoteUsers, room)
+		delete(h.remoteDevices, room)
+		delete(h.remoteAvatars, room)
+		delete(h.remoteStatuses, room)
+	}
+}
+
+// roomActive reports whether room has members on this or, for its owner, any node
+func (h *LocalHub) roomActive(room string) bool {
+	_, local := h.rooms[room]
+	return local || len(h.remoteUsers[room]) > 0
+}
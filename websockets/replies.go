@@ -0,0 +1,49 @@
+package websockets
+
+import "chat-app/storage"
+
+/*
+Replies Overview:
+----------------
+A reply is a chat message that quotes an earlier message of the room:
+
+	{"type": "reply", "reply_to": "9b2f...", "content": "agreed, rolling back"}
+
+The room's hub finds the quoted message among the room's recent
+messages or in storage, the way pins are checked (see permissions.go),
+and the room gets a chat message carrying a copy of its start:
+
+	{"type": "chat", "id": "...", "content": "agreed, rolling back", "seq": 45,
+	 "quote": {"id": "9b2f...", "username": "alice", "excerpt": "the deploy is...", "truncated": true}}
+
+The excerpt is the first storage.MaxQuoteExcerpt characters, taken by
+the server rather than the client, so everyone sees the same quote, and
+kept with the reply (see storage/quotes.go), so it still reads the same
+after the original is deleted or recalled. Clients that don't render
+quotes show the reply as plain chat. A message that isn't the room's,
+or is no longer known, gets an unknown_message error.
+
+Replies reach federated servers as plain chat: their quotes can't be
+checked there.
+*/
+
+// resolveQuote fills in the quote of a reply from the message it names
+// It reports false, after telling the sender, if there is no such message,
+// and while the message is fetched from storage
+func (h *LocalHub) resolveQuote(msg *Message) bool {
+	quoted, ok, pending := h.findMessage(*msg, msg.Quote.ID)
+	if pending {
+		return false // Back once it is fetched
+	}
+	if !ok {
+		h.reply(*msg, Message{
+			Type:     "error",
+			Code:     errCodeUnknownMessage,
+			Content:  "no such message in this room to reply to",
+			RoomName: msg.RoomName,
+		})
+		return false
+	}
+	msg.Quote = storage.NewQuote(msg.Quote.ID, quoted.author, quoted.content)
+	return true
+}
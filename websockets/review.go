@@ -0,0 +1,376 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Review Queue Overview:
+---------------------
+Messages that need a human land in the persisted review queue (see
+storage.ReviewItem), from three sources:
+
+	automod    the room's link policy caught the message (links.go)
+	toxicity   the moderation API flagged or hid it (moderation.go)
+	report     a user reported it:
+	           {"type": "report", "id": "<message id>", "content": "spam"}
+
+Reports may only name messages the hub still remembers (the last
+recentMessageCap broadcast on this node). The reporter gets
+{"type": "report", "code": "received", "id": ...} back.
+
+Moderators (WithModerators) decide through /api/mod:
+
+	approve   drop the entry; a hidden message is shown again
+	delete    delete the message everywhere
+	ban       delete it and ban the author from the room, optionally
+	          for a limited time; their connections there are closed,
+	          and their recent messages may go too (cascade.go)
+
+Moderators connected to the node get the queue in real time:
+
+	{"type": "mod_queue", "code": "added", "room": "lobby", "review": {...}}
+
+code is added, updated (a new report or score merged in) or resolved
+(content holds the decision). In a cluster, frames reach moderators
+on the node that raised or resolved the entry; the REST queue is
+complete.
+*/
+
+// Codes on mod_queue frames
+const (
+	ModQueueAdded    = "added"
+	ModQueueUpdated  = "updated"
+	ModQueueResolved = "resolved"
+)
+
+// Review decisions
+const (
+	DecisionApprove = "approve"
+	DecisionDelete  = "delete"
+	DecisionBan     = "ban"
+)
+
+// recentMessageCap bounds the messages remembered for reports
+const recentMessageCap = 5000
+
+// Error codes for reports and bans
+const (
+	errCodeUnknownMessage = "unknown_message"
+	errCodeBanned         = "banned"
+)
+
+// ErrNotQueued is returned when resolving an entry that isn't in the review queue
+var ErrNotQueued = errors.New("not in the review queue")
+
+// WithModerators names the users who receive mod_queue frames
+func WithModerators(usernames ...string) HubOption {
+	return func(h *LocalHub) {
+		for _, name := range usernames {
+			h.moderators[name] = true
+		}
+	}
+}
+
+// recentMessages remembers the last chat messages broadcast, for reports
+// Owned by the hub goroutine
+type recentMessages struct {
+	byID  map[string]Message
+	order []string // Oldest first
+}
+
+func newRecentMessages() *recentMessages {
+	return &recentMessages{byID: make(map[string]Message)}
+}
+
+func (r *recentMessages) add(msg Message) {
+	if len(r.order) >= recentMessageCap {
+		delete(r.byID, r.order[0])
+		r.order = r.order[1:]
+	}
+	msg.ctx, msg.sender, msg.to, msg.origin, msg.lookup = nil, nil, nil, nil, nil
+	r.byID[msg.ID] = msg
+	r.order = append(r.order, msg.ID)
+}
+
+func (r *recentMessages) get(room, id string) (Message, bool) {
+	msg, ok := r.byID[id]
+	return msg, ok && msg.RoomName == room
+}
+
+// before returns up to n of room's messages preceding id, oldest first
+func (r *recentMessages) before(room, id string, n int) []Message {
+	msgs := []Message{}
+	for i := len(r.order) - 1; i >= 0 && len(msgs) < n; i-- {
+		if msg := r.byID[r.order[i]]; msg.ID != id && msg.RoomName == room {
+			msgs = append(msgs, msg)
+		}
+	}
+	slices.Reverse(msgs)
+	return msgs
+}
+
+// handleReport queues a reported message for review
+func (h *LocalHub) handleReport(msg Message) {
+	reported, ok := h.recent.get(msg.RoomName, msg.ID)
+	if !ok {
+		h.reply(msg, Message{
+			Type:     "error",
+			Code:     errCodeUnknownMessage,
+			Content:  "that message can no longer be reported",
+			RoomName: msg.RoomName,
+		})
+		return
+	}
+
+	item := storage.ReviewItem{
+		ID:        reported.ID,
+		Room:      reported.RoomName,
+		MessageID: reported.ID,
+		Username:  reported.Username,
+		Content:   reported.Content,
+		Sources:   []string{storage.ReviewReport},
+		Reporters: []string{msg.Username},
+	}
+	if reason := strings.TrimSpace(msg.Content); reason != "" {
+		item.Reasons = []string{"report: " + reason}
+	}
+	h.queueForReview(item)
+	h.reply(msg, Message{Type: "report", Code: "received", ID: msg.ID, RoomName: msg.RoomName})
+}
+
+// QueueForReview adds an entry to the review queue; safe from any goroutine
+func (h *LocalHub) QueueForReview(item storage.ReviewItem) {
+	h.query(func() {
+		h.queueForReview(item)
+	})
+}
+
+// queueForReview saves an entry, merging it into any existing one for
+// the same message, and tells moderators
+func (h *LocalHub) queueForReview(item storage.ReviewItem) {
+	ctx, cancel := storageContext()
+	defer cancel()
+
+	now := time.Now()
+	code := ModQueueAdded
+	if item.ID == "" {
+		item.ID = newID()
+	}
+	existing, err := h.store.GetReviewItem(ctx, item.ID)
+	switch {
+	case err == nil:
+		item = mergeReview(existing, item)
+		code = ModQueueUpdated
+	case errors.Is(err, storage.ErrNotFound):
+		item.CreatedAt = now
+	default:
+		reportStorageError("load review item", err, errreport.Context{Room: item.Room})
+		return
+	}
+	item.UpdatedAt = now
+
+	if err := h.store.SaveReviewItem(ctx, item); err != nil {
+		reportStorageError("save review item", err, errreport.Context{Room: item.Room})
+		return
+	}
+	h.notifyModerators(code, "", item)
+}
+
+// mergeReview folds a new reason to review a message into its entry
+func mergeReview(existing, next storage.ReviewItem) storage.ReviewItem {
+	for _, list := range []struct {
+		dst *[]string
+		src []string
+	}{
+		{&existing.Sources, next.Sources},
+		{&existing.Reasons, next.Reasons},
+		{&existing.Reporters, next.Reporters},
+	} {
+		for _, v := range list.src {
+			if !slices.Contains(*list.dst, v) {
+				*list.dst = append(*list.dst, v)
+			}
+		}
+	}
+	existing.Score = max(existing.Score, next.Score)
+	existing.Hidden = existing.Hidden || next.Hidden
+	return existing
+}
+
+// dropFromReview removes a message's entry, if it has one, once it is settled
+func (h *LocalHub) dropFromReview(id, decision string) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	item, err := h.store.GetReviewItem(ctx, id)
+	if err != nil {
+		return
+	}
+	if err := h.store.DeleteReviewItem(ctx, id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		reportStorageError("delete review item", err, errreport.Context{Room: item.Room})
+		return
+	}
+	h.notifyModerators(ModQueueResolved, decision, item)
+}
+
+// notifyModerators sends a mod_queue frame to every moderator connected here
+func (h *LocalHub) notifyModerators(code, decision string, item storage.ReviewItem) {
+	if len(h.moderators) == 0 {
+		return
+	}
+	for client := range h.clients {
+		if h.moderators[client.username] {
+			h.sendTo(client, Message{Type: "mod_queue", Code: code, Content: decision, RoomName: item.Room, Review: &item})
+		}
+	}
+}
+
+// ReviewQueue lists up to limit queued entries, oldest first
+func (h *LocalHub) ReviewQueue(limit int) ([]storage.ReviewItem, error) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	return h.store.ReviewItems(ctx, limit)
+}
+
+// Resolution is a moderator's decision on a queued entry
+type Resolution struct {
+	Decision  string        // DecisionApprove, DecisionDelete or DecisionBan
+	Moderator string        // Who decided, for the event log
+	BanFor    time.Duration // How long a ban lasts; 0 is permanent
+	Cascade   string        // Optional on a ban: CascadeDelete or CascadeHide the author's messages
+	Lookback  time.Duration // How far back a cascade reaches; 0 is the whole log
+}
+
+// Resolve applies a moderator's decision to a queued entry
+// It returns ErrNotQueued if the entry isn't in the queue
+func (h *LocalHub) Resolve(ctx context.Context, id string, res Resolution) (storage.ReviewItem, error) {
+	// A ban's cascade finds the author's messages first, off the hub goroutine
+	var cascade *banCascade
+	if res.Decision == DecisionBan && res.Cascade != "" {
+		var err error
+		if cascade, err = h.prepareCascade(ctx, id, res); err != nil {
+			return storage.ReviewItem{}, err
+		}
+	}
+
+	var item storage.ReviewItem
+	var err error
+	h.query(func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		if item, err = h.store.GetReviewItem(ctx, id); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				err = ErrNotQueued
+			}
+			return
+		}
+
+		// A ban that can't be saved leaves nothing else done, cascade included
+		if res.Decision == DecisionBan {
+			if err = h.ban(item, res); err != nil {
+				return
+			}
+		}
+		data := map[string]string{"by": res.Moderator}
+		if cascade != nil {
+			cascade.describe(data)
+		}
+		h.recordModeration(ruleReview, item.Room, item.MessageID, item.Username, res.Decision, data)
+		h.dropFromReview(id, res.Decision)
+		if cascade != nil {
+			h.announce(cascade, res.Moderator)
+		}
+		if item.MessageID == "" {
+			return // Blocked before it was ever sent
+		}
+		if res.Decision == DecisionApprove {
+			if item.Hidden {
+				h.handleBroadcast(moderationMessage(item.Room, item.MessageID, ModerationRestored, 0))
+			}
+			return
+		}
+		h.deleteMessage(item.Room, item.MessageID, item.Score)
+	})
+	if err == nil && cascade != nil {
+		h.finishCascade(ctx, cascade)
+	}
+	if err == nil && res.Decision == DecisionApprove && item.Hidden && item.MessageID != "" {
+		if err := h.store.SetMessageHidden(ctx, item.MessageID, false); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			reportStorageError("show message", err, errreport.Context{Room: item.Room})
+		}
+	}
+	return item, err
+}
+
+// ban keeps an entry's author out of its room and closes their connections there
+func (h *LocalHub) ban(item storage.ReviewItem, res Resolution) error {
+	now := time.Now()
+	ban := storage.Ban{
+		Room:      item.Room,
+		Username:  item.Username,
+		Reason:    strings.Join(item.Reasons, "; "),
+		By:        res.Moderator,
+		CreatedAt: now,
+	}
+	if res.BanFor > 0 {
+		ban.Until = now.Add(res.BanFor)
+	}
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := h.store.SaveBan(ctx, ban); err != nil {
+		return err
+	}
+	for client := range h.rooms[item.Room] {
+		if client.username == item.Username {
+			h.kick(client, errCodeBanned, "you have been banned from this room")
+		}
+	}
+	return nil
+}
+
+// Banned returns the ban in force on username in room, if any
+// Safe to call from any goroutine
+func (h *LocalHub) Banned(room, username string) (storage.Ban, bool) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	ban, err := h.store.GetBan(ctx, room, username)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			reportStorageError("load ban", err, errreport.Context{Room: room, Username: username})
+		}
+		return storage.Ban{}, false
+	}
+	return ban, ban.Active(time.Now())
+}
+
+// Bans lists a room's bans
+func (h *LocalHub) Bans(room string) ([]storage.Ban, error) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	return h.store.Bans(ctx, room)
+}
+
+// Unban lifts a ban, returning storage.ErrNotFound if there was none
+func (h *LocalHub) Unban(room, username, moderator string) error {
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := h.store.DeleteBan(ctx, room, username); err != nil {
+		return err
+	}
+	h.query(func() {
+		h.recordModeration(ruleReview, room, "", username, "unban", map[string]string{"by": moderator})
+	})
+	return nil
+}
+
+// banChecker is implemented by hubs that keep banned users out of rooms
+type banChecker interface {
+	Banned(room, username string) (storage.Ban, bool)
+}
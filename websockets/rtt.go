@@ -0,0 +1,68 @@
+package websockets
+
+import (
+	"strconv"
+	"time"
+
+	"chat-app/metrics"
+)
+
+/*
+Round-Trip Time Overview:
+------------------------
+Every protocol ping carries the time it was sent, and the peer echoes
+it back in its pong, so the read pump learns the connection's
+round-trip time without any extra frames:
+
+	ping  "1718000000123456789"   (Unix nanoseconds, from writePing)
+	pong  "1718000000123456789"   -> RTT = now - that
+
+Step 1: The write pump pings right after connecting, then every pingPeriod
+Step 2: Each pong records the latest RTT on the client
+Step 3: It is observed in chat_client_rtt_seconds and shown in the
+        admin connection list as rtt_ms
+Step 4: With WithRTTReports the client is told too, for a
+        connection-quality indicator:
+
+	{"type": "rtt", "rtt_ms": 42.7}
+
+The RTT includes the time the pong waited behind other frames the
+client sent, so it is what the user actually experiences rather than
+the bare network latency.
+*/
+
+// WithRTTReports sends each client an rtt frame whenever its
+// round-trip time is measured
+func WithRTTReports() Option {
+	return func(o *handlerOptions) {
+		o.rttReports = true
+	}
+}
+
+// pingPayload is the application data of a ping: when it was sent
+func pingPayload(now time.Time) []byte {
+	return strconv.AppendInt(nil, now.UnixNano(), 10)
+}
+
+// observePong records the round-trip time of the ping a pong answers
+func (c *Client) observePong(appData string, now time.Time) {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return // Unsolicited, or from a ping that wasn't ours
+	}
+	rtt := now.Sub(time.Unix(0, sent))
+	if rtt < 0 || rtt > pongWait {
+		return
+	}
+	c.rtt.Store(int64(rtt))
+	metrics.ClientRTT.Observe(rtt.Seconds())
+
+	if c.reportRTT {
+		c.hub.Broadcast(Message{Type: "rtt", RTTMs: rttMillis(rtt), RoomName: c.room, to: c})
+	}
+}
+
+// rttMillis is rtt in milliseconds, to a microsecond
+func rttMillis(rtt time.Duration) float64 {
+	return float64(rtt.Microseconds()) / 1000
+}
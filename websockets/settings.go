@@ -0,0 +1,71 @@
+package websockets
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+// roomSettingsTTL is how long a room's settings are cached
+const roomSettingsTTL = 10 * time.Second
+
+// cachedSettings is a room's settings as last loaded
+type cachedSettings struct {
+	settings storage.RoomSettings
+	expires  time.Time
+}
+
+// settingsCache reads room settings for the connection path, so a change
+// made through the settings API takes effect within roomSettingsTTL on
+// every node; safe for concurrent use
+type settingsCache struct {
+	store storage.Store
+
+	mu    sync.Mutex
+	rooms map[string]cachedSettings
+}
+
+func newSettingsCache(store storage.Store) *settingsCache {
+	return &settingsCache{store: store, rooms: make(map[string]cachedSettings)}
+}
+
+// get returns room's settings, loading them if the cached copy is stale
+// If loading fails the last known settings stay in force
+func (s *settingsCache) get(room string) storage.RoomSettings {
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.rooms[room]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.settings
+	}
+
+	ctx, cancel := storageContext()
+	defer cancel()
+	settings, err := s.store.GetRoomSettings(ctx, room)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		reportStorageError("load room settings", err, errreport.Context{Room: room})
+		return cached.settings // Keep enforcing the last known settings
+	}
+
+	s.mu.Lock()
+	for r, c := range s.rooms {
+		if now.After(c.expires) {
+			delete(s.rooms, r)
+		}
+	}
+	s.rooms[room] = cachedSettings{settings: settings, expires: now.Add(roomSettingsTTL)}
+	s.mu.Unlock()
+	return settings
+}
+
+// forget drops room's cached settings, so a change made on this node
+// applies here at once
+func (s *settingsCache) forget(room string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rooms, room)
+}
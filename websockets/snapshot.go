@@ -0,0 +1,311 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Hub State Snapshot Overview:
+---------------------------
+Some hub state only lives in memory and isn't derivable from
+storage: sequence numbers of rooms without stored history, the
+idempotency acks used to dedupe retried sends and the IDs pinned in
+rooms owned here. Losing it on a restart means clients retrying a
+send get a duplicate and rooms restart their sequence.
+
+Moderation state kept in the store is lost too when the store is
+Memory, so each room's snapshot also carries its settings (metadata
+and tags, join rate, permissions, archival) and the notification
+mutes of members connected here. They are read from the store off
+the hub goroutine, and written back at startup only where the store
+has nothing for the room or user: a store that kept them, like
+Postgres, stays authoritative, so changes made since the snapshot
+aren't undone.
+
+With a state file configured the hub writes a JSON snapshot of that
+state every interval and loads it in Run, before it serves anyone.
+A restart loses at most one interval of changes. New volatile room
+state belongs in roomState so it survives restarts the same way.
+*/
+
+// hubStateVersion is the snapshot format written by this build
+const hubStateVersion = 1
+
+// restoreTimeout bounds the store calls made reading or restoring a
+// snapshot, for every room together
+const restoreTimeout = 30 * time.Second
+
+// hubState is the persisted form of the hub's volatile state
+type hubState struct {
+	Version int         `json:"version"`
+	SavedAt time.Time   `json:"saved_at"`
+	Rooms   []roomState `json:"rooms"`
+	Acks    []ackState  `json:"acks"`
+}
+
+// roomState is the volatile state of one active room
+type roomState struct {
+	Room     string                  `json:"room"`
+	LastSeq  uint64                  `json:"last_seq"`
+	Pins     []string                `json:"pins,omitempty"`     // Pinned message IDs, oldest first
+	Settings *storage.RoomSettings   `json:"settings,omitempty"` // As stored; nil if never configured
+	Mutes    map[string]storage.Mute `json:"mutes,omitempty"`    // Connected members' mutes of the room
+
+	online []string // Members connected here, whose mutes are looked up
+}
+
+// ackState is one remembered idempotency ack
+type ackState struct {
+	Room     string    `json:"room"`
+	Username string    `json:"username"`
+	Key      string    `json:"key"`
+	Ack      Message   `json:"ack"`
+	Expires  time.Time `json:"expires"`
+}
+
+// WithStateFile snapshots volatile hub state to path every interval
+// and restores it when Run starts
+func WithStateFile(path string, interval time.Duration) HubOption {
+	return func(h *LocalHub) {
+		h.statePath = path
+		h.stateInterval = interval
+	}
+}
+
+// captureState copies the volatile state; must run on the hub goroutine
+// What the store keeps of each room is added by addStored
+func (h *LocalHub) captureState(now time.Time) hubState {
+	state := hubState{Version: hubStateVersion, SavedAt: now, Rooms: []roomState{}, Acks: []ackState{}}
+	rooms := make(map[string]bool)
+	for room := range h.seqs {
+		rooms[room] = true
+	}
+	for room := range h.pins {
+		rooms[room] = true
+	}
+	for room := range h.rooms {
+		rooms[room] = true
+	}
+	for room := range rooms {
+		r := roomState{Room: room, LastSeq: h.seqs[room], Pins: slices.Clone(h.pins[room])}
+		for client := range h.rooms[room] {
+			if !slices.Contains(r.online, client.username) {
+				r.online = append(r.online, client.username)
+			}
+		}
+		state.Rooms = append(state.Rooms, r)
+	}
+	for _, k := range h.acks.order {
+		e := h.acks.entries[k]
+		if now.After(e.expires) {
+			continue
+		}
+		state.Acks = append(state.Acks, ackState{Room: k.room, Username: k.username, Key: k.key, Ack: e.ack, Expires: e.expires})
+	}
+	return state
+}
+
+// addStored adds each room's settings and its connected members' mutes
+// to state, from the store; it must not run on the hub goroutine
+func (h *LocalHub) addStored(ctx context.Context, state *hubState) {
+	for i := range state.Rooms {
+		r := &state.Rooms[i]
+		settings, err := h.store.GetRoomSettings(ctx, r.Room)
+		if err == nil {
+			r.Settings = &settings
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			reportStorageError("snapshot room settings", err, errreport.Context{Room: r.Room})
+		}
+		for _, username := range r.online {
+			prefs, err := h.store.GetNotificationPrefs(ctx, username)
+			if err != nil {
+				if !errors.Is(err, storage.ErrNotFound) {
+					reportStorageError("snapshot mutes", err, errreport.Context{Room: r.Room, Username: username})
+				}
+				continue
+			}
+			if mute, ok := prefs.Muted[r.Room]; ok && (mute.Until == nil || mute.Until.After(state.SavedAt)) {
+				if r.Mutes == nil {
+					r.Mutes = make(map[string]storage.Mute)
+				}
+				r.Mutes[username] = mute
+			}
+		}
+	}
+}
+
+// restoreStored writes a snapshot's room settings and mutes back to a
+// store that has lost them, leaving any the store still has alone
+func (h *LocalHub) restoreStored(ctx context.Context, state hubState, now time.Time) {
+	mutes := make(map[string]map[string]storage.Mute) // User -> room -> mute
+	for _, r := range state.Rooms {
+		if r.Settings != nil {
+			_, err := h.store.GetRoomSettings(ctx, r.Room)
+			if errors.Is(err, storage.ErrNotFound) {
+				err = h.store.SaveRoomSettings(ctx, *r.Settings)
+			}
+			if err != nil {
+				reportStorageError("restore room settings", err, errreport.Context{Room: r.Room})
+			}
+		}
+		for username, mute := range r.Mutes {
+			if mute.Until != nil && !mute.Until.After(now) {
+				continue // Ran out while the server was down
+			}
+			if mutes[username] == nil {
+				mutes[username] = make(map[string]storage.Mute)
+			}
+			mutes[username][r.Room] = mute
+		}
+	}
+	for username, muted := range mutes {
+		_, err := h.store.GetNotificationPrefs(ctx, username)
+		if errors.Is(err, storage.ErrNotFound) {
+			err = h.store.SaveNotificationPrefs(ctx, storage.NotificationPrefs{
+				Username:  username,
+				Default:   storage.DefaultNotify,
+				Muted:     muted,
+				UpdatedAt: now,
+			})
+		}
+		if err != nil {
+			reportStorageError("restore mutes", err, errreport.Context{Username: username})
+		}
+	}
+}
+
+// applyState loads a snapshot into an idle hub; must run on the hub goroutine
+func (h *LocalHub) applyState(state hubState, now time.Time) {
+	for _, r := range state.Rooms {
+		h.seqs[r.Room] = max(h.seqs[r.Room], r.LastSeq)
+		if len(r.Pins) > 0 {
+			h.pins[r.Room] = slices.Clone(r.Pins)
+		}
+	}
+	for _, a := range state.Acks {
+		if now.After(a.Expires) {
+			continue
+		}
+		k := idempotencyKey{room: a.Room, username: a.Username, key: a.Key}
+		h.acks.order = append(h.acks.order, k)
+		h.acks.entries[k] = idempotencyEntry{ack: a.Ack, expires: a.Expires}
+	}
+}
+
+// loadState restores the last snapshot, if there is one
+func (h *LocalHub) loadState() {
+	data, err := os.ReadFile(h.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return // First start
+	}
+	if err == nil {
+		var state hubState
+		if err = json.Unmarshal(data, &state); err == nil && state.Version > hubStateVersion {
+			err = fmt.Errorf("unsupported version %d", state.Version)
+		}
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+			h.restoreStored(ctx, state, time.Now())
+			cancel()
+			h.applyState(state, time.Now())
+			log.Printf("Restored hub state from %s (saved %s, %d rooms, %d acks)",
+				h.statePath, state.SavedAt.Format(time.RFC3339), len(state.Rooms), len(state.Acks))
+			return
+		}
+	}
+	// A bad snapshot shouldn't stop the server; it just starts fresh
+	log.Printf("Failed to load hub state from %s: %v", h.statePath, err)
+	errreport.CaptureError(fmt.Errorf("load hub state: %w", err), errreport.Context{})
+}
+
+// saveState snapshots the hub and writes it without blocking the hub goroutine
+func (h *LocalHub) saveState(now time.Time) {
+	if !h.stateSaving.CompareAndSwap(false, true) {
+		return // Previous write still in progress
+	}
+	state := h.captureState(now)
+
+	go func() {
+		defer h.stateSaving.Store(false)
+		data, err := h.encodeState(state)
+		if err != nil {
+			log.Printf("Failed to encode hub state: %v", err)
+			return
+		}
+		if err := writeFileAtomic(h.statePath, data); err != nil {
+			log.Printf("Failed to save hub state to %s: %v", h.statePath, err)
+			errreport.CaptureError(fmt.Errorf("save hub state: %w", err), errreport.Context{})
+		}
+	}()
+}
+
+// HasStateFile reports whether hub state snapshots are enabled
+func (h *LocalHub) HasStateFile() bool {
+	return h.statePath != ""
+}
+
+// SaveState writes a snapshot now and waits for it, e.g. before handing
+// over to a new process; it is a no-op without a state file
+func (h *LocalHub) SaveState() error {
+	if h.statePath == "" {
+		return nil
+	}
+	var state hubState
+	h.query(func() {
+		state = h.captureState(time.Now())
+	})
+	data, err := h.encodeState(state)
+	if err != nil {
+		return err
+	}
+
+	// Let a periodic write finish first so it can't replace this newer state
+	for !h.stateSaving.CompareAndSwap(false, true) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer h.stateSaving.Store(false)
+	return writeFileAtomic(h.statePath, data)
+}
+
+// encodeState adds what the store keeps of each room to state and
+// encodes it; it must not run on the hub goroutine
+func (h *LocalHub) encodeState(state hubState) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+	defer cancel()
+	h.addStored(ctx, &state)
+	return json.Marshal(state)
+}
+
+// writeFileAtomic replaces path so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
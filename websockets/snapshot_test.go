@@ -0,0 +1,97 @@
+package websockets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"chat-app/storage"
+)
+
+func TestStateRestore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	until := now.Add(time.Hour).UTC().Truncate(time.Second)
+
+	// The hub before the restart: a numbered room with pins, settings
+	// and a connected member who muted it
+	before := storage.NewMemory()
+	settings := storage.DefaultRoomSettings("lobby")
+	settings.Metadata = storage.RoomMetadata{Language: "de", NSFW: true}
+	settings.Tags = []string{"games"}
+	settings.Joins = storage.JoinPolicy{PerMinute: 5}
+	if err := before.SaveRoomSettings(ctx, settings); err != nil {
+		t.Fatal(err)
+	}
+	prefs := storage.NotificationPrefs{
+		Username: "alice",
+		Default:  storage.DefaultNotify,
+		Muted:    map[string]storage.Mute{"lobby": {Until: &until}},
+	}
+	if err := before.SaveNotificationPrefs(ctx, prefs); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHub(WithStore(before))
+	h.seqs["lobby"] = 7
+	h.trackPin("lobby", "m1", true)
+	h.trackPin("lobby", "m2", true)
+	h.trackPin("lobby", "m3", true)
+	h.trackPin("lobby", "m1", false)
+	h.rooms["lobby"] = map[*Client]bool{{room: "lobby", username: "alice"}: true}
+
+	data, err := h.encodeState(h.captureState(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "hub-state.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh hub on a store that lost everything gets it all back
+	after := storage.NewMemory()
+	restored := NewHub(WithStore(after), WithStateFile(path, time.Minute))
+	restored.loadState()
+
+	if got := restored.seqs["lobby"]; got != 7 {
+		t.Errorf("last seq = %d, want 7", got)
+	}
+	if got, want := restored.pins["lobby"], []string{"m2", "m3"}; !slices.Equal(got, want) {
+		t.Errorf("pins = %v, want %v", got, want)
+	}
+	got, err := after.GetRoomSettings(ctx, "lobby")
+	if err != nil {
+		t.Fatalf("settings not restored: %v", err)
+	}
+	if got.Metadata != settings.Metadata || !slices.Equal(got.Tags, settings.Tags) || got.Joins != settings.Joins {
+		t.Errorf("settings = %+v, want %+v", got, settings)
+	}
+	gotPrefs, err := after.GetNotificationPrefs(ctx, "alice")
+	if err != nil {
+		t.Fatalf("mutes not restored: %v", err)
+	}
+	if mute, ok := gotPrefs.Muted["lobby"]; !ok || mute.Until == nil || !mute.Until.Equal(until) {
+		t.Errorf("lobby mute = %+v, %v, want until %v", mute, ok, until)
+	}
+
+	// A store that kept its own state wins over the snapshot
+	kept := storage.NewMemory()
+	changed := storage.DefaultRoomSettings("lobby")
+	if err := kept.SaveRoomSettings(ctx, changed); err != nil {
+		t.Fatal(err)
+	}
+	unmuted := storage.NotificationPrefs{Username: "alice", Default: storage.DefaultNotify}
+	if err := kept.SaveNotificationPrefs(ctx, unmuted); err != nil {
+		t.Fatal(err)
+	}
+	NewHub(WithStore(kept), WithStateFile(path, time.Minute)).loadState()
+	if got, _ := kept.GetRoomSettings(ctx, "lobby"); got.Metadata.NSFW {
+		t.Error("snapshot replaced settings the store still had")
+	}
+	if got, _ := kept.GetNotificationPrefs(ctx, "alice"); len(got.Muted) != 0 {
+		t.Error("snapshot muted a room the user unmuted since")
+	}
+}
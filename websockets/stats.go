@@ -0,0 +1,140 @@
+package websockets
+
+import (
+	"sort"
+	"time"
+
+	"chat-app/metrics"
+)
+
+/*
+Room Stats Overview:
+-------------------
+The hub keeps lightweight counters for every active room so
+operators can see which rooms are hot:
+- messages per second over a sliding one-minute window
+- active users
+- sends dropped because a client couldn't keep up
+
+All stats are owned by the hub goroutine; readers go through
+LocalHub.TopRooms which runs on that goroutine.
+*/
+
+// rateWindowSize is the number of one-second buckets in a rate window
+const rateWindowSize = 60
+
+// rateWindow counts events in one-second buckets over the last minute
+type rateWindow struct {
+	buckets [rateWindowSize]uint64
+	stamps  [rateWindowSize]int64 // Unix second each bucket belongs to
+}
+
+func (w *rateWindow) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % rateWindowSize
+	if w.stamps[i] != sec {
+		w.stamps[i] = sec
+		w.buckets[i] = 0
+	}
+	w.buckets[i]++
+}
+
+// perSecond returns the average event rate over the window
+func (w *rateWindow) perSecond(now time.Time) float64 {
+	sec := now.Unix()
+	var total uint64
+	for i := range w.buckets {
+		if sec-w.stamps[i] < rateWindowSize {
+			total += w.buckets[i]
+		}
+	}
+	return float64(total) / rateWindowSize
+}
+
+// roomStats accumulates activity for a single room
+type roomStats struct {
+	label    string // Metrics label, fixed when the room is created
+	messages uint64
+	dropped  uint64
+	rate     rateWindow
+}
+
+func newRoomStats(room string) *roomStats {
+	return &roomStats{label: metrics.RoomLabel(room)}
+}
+
+// RoomStats is a point-in-time view of a room's activity
+type RoomStats struct {
+	Room              string  `json:"room"`
+	ActiveUsers       int     `json:"active_users"`
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	Messages          uint64  `json:"messages_total"`
+	DroppedSends      uint64  `json:"dropped_sends_total"`
+}
+
+// Sort orders accepted by TopRooms
+const (
+	SortByRate    = "rate"
+	SortByUsers   = "users"
+	SortByDropped = "dropped"
+)
+
+// TopRooms returns up to limit rooms ordered by the given key
+func (h *LocalHub) TopRooms(limit int, by string) []RoomStats {
+	result := []RoomStats{}
+	h.query(func() {
+		now := time.Now()
+		for room, clients := range h.rooms {
+			st := h.roomStats(room)
+			result = append(result, RoomStats{
+				Room:              room,
+				ActiveUsers:       len(clients),
+				MessagesPerSecond: st.rate.perSecond(now),
+				Messages:          st.messages,
+				DroppedSends:      st.dropped,
+			})
+		}
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		switch by {
+		case SortByUsers:
+			return result[i].ActiveUsers > result[j].ActiveUsers
+		case SortByDropped:
+			return result[i].DroppedSends > result[j].DroppedSends
+		default:
+			return result[i].MessagesPerSecond > result[j].MessagesPerSecond
+		}
+	})
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// Counts returns the number of connected clients and active rooms
+func (h *LocalHub) Counts() (clients, rooms int) {
+	h.query(func() {
+		clients, rooms = len(h.clients), len(h.rooms)
+	})
+	return clients, rooms
+}
+
+// roomStats returns the stats for room, creating them if needed
+func (h *LocalHub) roomStats(room string) *roomStats {
+	st, ok := h.stats[room]
+	if !ok {
+		st = newRoomStats(room)
+		h.stats[room] = st
+	}
+	return st
+}
+
+// dropRoomStats releases a closed room's stats and metric series
+func (h *LocalHub) dropRoomStats(room string) {
+	if st, ok := h.stats[room]; ok && st.label == room {
+		metrics.ForgetRoom(room)
+	}
+	delete(h.stats, room)
+}
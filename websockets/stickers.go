@@ -0,0 +1,173 @@
+package websockets
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Sticker Overview:
+----------------
+Admins manage sticker packs through the admin API (see
+storage.StickerPack). Right after the hello, and any onboarding, each
+joiner is sent the packs offered in the room:
+
+	{"type": "sticker_packs", "room": "lobby", "packs": [
+	    {"id": "cats", "name": "Cats", "stickers": [
+	        {"pack": "cats", "id": "wave", "alt": "👋", "url": "/stickers/cats/wave"}]}]}
+
+Rooms with no packs get no listing. A client posts a sticker by ID:
+
+	{"type": "sticker", "sticker": {"pack": "cats", "id": "wave"}}
+
+and the room sees it like a chat message, with a Seq, the sticker's
+alt text as content for clients and bridges that can't show images,
+and the image URL (relative to the server):
+
+	{"type": "sticker", "id": "...", "content": "👋", "seq": 42,
+	 "sticker": {"pack": "cats", "id": "wave", "alt": "👋", "url": "/stickers/cats/wave"}}
+
+Stickers that don't exist, or whose pack isn't offered in the room,
+get an unknown_sticker error. Packs are read from the store and cached
+for stickerCatalogTTL, so edits take effect within that time on every
+node.
+*/
+
+// stickerCatalogTTL is how long the list of packs is cached
+const stickerCatalogTTL = 10 * time.Second
+
+// Sticker names a sticker on sticker frames and in pack listings
+type Sticker struct {
+	Pack string `json:"pack"`
+	ID   string `json:"id"`
+	Alt  string `json:"alt,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// StickerPack is a pack as listed to clients
+type StickerPack struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Stickers []Sticker `json:"stickers"`
+}
+
+// StickerURL is the path an image is served at, see api.RegisterStickers
+func StickerURL(pack, id string) string {
+	return "/stickers/" + url.PathEscape(pack) + "/" + url.PathEscape(id)
+}
+
+// stickerCatalog caches every sticker pack; safe for concurrent use
+type stickerCatalog struct {
+	store storage.Store
+
+	mu      sync.Mutex
+	packs   []storage.StickerPack
+	expires time.Time
+}
+
+func newStickerCatalog(store storage.Store) *stickerCatalog {
+	return &stickerCatalog{store: store}
+}
+
+// all returns every pack, loading them if the cached copy is stale
+// If loading fails the last known packs are kept
+func (c *stickerCatalog) all() []storage.StickerPack {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Before(c.expires) {
+		return c.packs
+	}
+
+	ctx, cancel := storageContext()
+	defer cancel()
+	packs, err := c.store.StickerPacks(ctx)
+	if err != nil {
+		reportStorageError("load sticker packs", err, errreport.Context{})
+		return c.packs
+	}
+	c.packs, c.expires = packs, now.Add(stickerCatalogTTL)
+	return packs
+}
+
+// find looks up a sticker offered in room
+func (c *stickerCatalog) find(room, pack, id string) (Sticker, bool) {
+	for _, p := range c.all() {
+		if p.ID != pack || !p.OfferedIn(room) {
+			continue
+		}
+		if s, ok := p.Find(id); ok {
+			return newSticker(p.ID, s.ID, s.Alt), true
+		}
+	}
+	return Sticker{}, false
+}
+
+// room lists the packs offered in room
+func (c *stickerCatalog) room(room string) []StickerPack {
+	var listed []StickerPack
+	for _, p := range c.all() {
+		if !p.OfferedIn(room) || len(p.Stickers) == 0 {
+			continue
+		}
+		pack := StickerPack{ID: p.ID, Name: p.Name, Stickers: make([]Sticker, 0, len(p.Stickers))}
+		for _, s := range p.Stickers {
+			pack.Stickers = append(pack.Stickers, newSticker(p.ID, s.ID, s.Alt))
+		}
+		listed = append(listed, pack)
+	}
+	return listed
+}
+
+func newSticker(pack, id, alt string) Sticker {
+	return Sticker{Pack: pack, ID: id, Alt: alt, URL: StickerURL(pack, id)}
+}
+
+// sendStickerPacks lists the room's packs to a joiner
+func (h *LocalHub) sendStickerPacks(client *Client) {
+	packs := h.stickers.room(client.room)
+	if len(packs) == 0 {
+		return
+	}
+	h.sendTo(client, Message{Type: "sticker_packs", RoomName: client.room, Packs: packs})
+}
+
+// resolveSticker fills in a posted sticker's alt text and URL
+// It reports false, after telling the sender, if the room doesn't offer it
+func (h *LocalHub) resolveSticker(msg *Message) bool {
+	if msg.Sticker != nil {
+		if s, ok := h.stickers.find(msg.RoomName, msg.Sticker.Pack, msg.Sticker.ID); ok {
+			msg.Sticker = &s
+			msg.Content = s.Alt
+			return true
+		}
+	}
+	h.reply(*msg, Message{
+		Type:     "error",
+		Code:     errCodeUnknownSticker,
+		Content:  "no such sticker in this room",
+		RoomName: msg.RoomName,
+	})
+	return false
+}
+
+// stickerRef is what is stored of a message's sticker
+func stickerRef(s *Sticker) *storage.StickerRef {
+	if s == nil {
+		return nil
+	}
+	return &storage.StickerRef{Pack: s.Pack, ID: s.ID}
+}
+
+// storedSticker rebuilds a stored message's sticker, whose alt text is its content
+func storedSticker(stored storage.Message) *Sticker {
+	if stored.Sticker == nil {
+		return nil
+	}
+	s := newSticker(stored.Sticker.Pack, stored.Sticker.ID, stored.Content)
+	return &s
+}
@@ -0,0 +1,150 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Sync Overview:
+-------------
+A user's devices share small state through the server (see
+storage.SyncEntry), such as the unsent draft for each room, so
+switching devices doesn't lose a half-written message. Any connection
+sets a key; an empty value deletes it:
+
+	{"type": "sync", "sync": {"key": "draft:lobby", "value": "half a thou"}}
+
+The hub saves it and pushes it to the user's other connections, in
+any room:
+
+	{"type": "sync", "username": "alice",
+	 "sync": {"key": "draft:lobby", "value": "half a thou", "updated_at": 1718000000000}}
+
+A device loads everything when it starts with GET /api/users/:username/sync
+(see api/sync.go). In a cluster, pushes reach the user's connections
+on this node and in rooms owned here; others catch up on their next
+load. Saving past storage.MaxSyncKeys gets a sync_full error.
+
+Keys are saved off the hub goroutine, a user's on one writer (see
+writethrough.go) so they are saved in the order sent, and pushed once
+saved. Each connection may send syncRate sync frames a second, in
+bursts of syncBurst; past that, frames are refused with a
+sync_rate_limited error whose retry_after_ms says when to try again.
+*/
+
+// Error codes for sync frames
+const (
+	errCodeSyncFull        = "sync_full"         // The user has no room for another key
+	errCodeSyncRateLimited = "sync_rate_limited" // The connection sent too many
+)
+
+// Pace of sync frames per connection
+const (
+	syncRate  = 5 // A second
+	syncBurst = 20
+)
+
+// Sync is one changed key on sync frames
+type Sync struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	UpdatedAt int64  `json:"updated_at,omitempty"` // Unix milliseconds, set by the server
+}
+
+// handleSync saves a key one of client's connections set, off the hub
+// goroutine, and then pushes it to the user's other connections
+func (h *LocalHub) handleSync(client *Client, s Sync, now time.Time) {
+	username := client.username
+	h.storeInOrder(username, func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		err := h.saveSync(ctx, username, s, now)
+		h.queries <- func() { h.savedSync(client, s, now, err) }
+	})
+}
+
+// savedSync answers a sync frame once its key is saved
+func (h *LocalHub) savedSync(client *Client, s Sync, now time.Time, err error) {
+	switch {
+	case errors.Is(err, storage.ErrSyncFull):
+		h.sendTo(client, errorMessage(client, errCodeSyncFull, storage.ErrSyncFull.Error()))
+		return
+	case err != nil:
+		reportStorageError("save sync entry", err, client.reportContext())
+		h.sendTo(client, errorMessage(client, errCodeStorage, "sync could not be saved, try again"))
+		return
+	}
+	s.UpdatedAt = now.UnixMilli()
+	h.pushSync(client.username, s, client)
+}
+
+// PutSync sets one key of username's synced state, an empty value
+// deleting it, and pushes it to the user's connections
+// Safe to call from any goroutine
+func (h *LocalHub) PutSync(ctx context.Context, username string, s Sync) error {
+	now := time.Now()
+	if err := h.saveSync(ctx, username, s, now); err != nil {
+		return err
+	}
+	s.UpdatedAt = now.UnixMilli()
+	h.query(func() {
+		h.pushSync(username, s, nil)
+	})
+	return nil
+}
+
+// saveSync stores s, or deletes its key when the value is empty
+func (h *LocalHub) saveSync(ctx context.Context, username string, s Sync, now time.Time) error {
+	if s.Value == "" {
+		err := h.store.DeleteSyncEntry(ctx, username, s.Key)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return h.store.SaveSyncEntry(ctx, storage.SyncEntry{Username: username, Key: s.Key, Value: s.Value, UpdatedAt: now})
+}
+
+// pushSync sends a changed key to username's connections but except
+func (h *LocalHub) pushSync(username string, s Sync, except *Client) {
+	h.pushUser(username, Message{Type: "sync", Username: username, Sync: &s}, except)
+}
+
+// pushUser sends msg to username's connections but except, in any room,
+// here and on nodes with members in rooms owned here; it reports whether
+// any connection was sent it
+func (h *LocalHub) pushUser(username string, msg Message, except *Client) bool {
+	sent := false
+	for client := range h.clients {
+		if client.username == username && client != except && client.accepts(msg.Type) {
+			h.sendTo(client, msg)
+			sent = true
+		}
+	}
+	for room, nodes := range h.remoteUsers {
+		for node, users := range nodes {
+			if slices.Contains(users, username) {
+				msg.RoomName = room
+				h.sendFrame(nil, node, relayFrame{Kind: frameUser, Room: room, Message: &msg, Users: []string{username}})
+				sent = true
+			}
+		}
+	}
+	return sent
+}
+
+// SyncState lists username's synced state
+// Safe to call from any goroutine
+func (h *LocalHub) SyncState(ctx context.Context, username string) ([]storage.SyncEntry, error) {
+	entries, err := h.store.SyncEntries(ctx, username)
+	if err != nil {
+		reportStorageError("load sync entries", err, errreport.Context{Username: username})
+	}
+	return entries, err
+}
@@ -0,0 +1,83 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"chat-app/storage"
+)
+
+// ErrInvalidTags is returned, wrapped, for tags that aren't valid (see
+// storage.NormalizeTags)
+var ErrInvalidTags = errors.New("invalid tags")
+
+// SetRoomTags replaces room's tags, returning them normalized
+// Safe to call from any goroutine
+func (h *LocalHub) SetRoomTags(ctx context.Context, room string, tags []string) ([]string, error) {
+	tags, err := storage.NormalizeTags(tags)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTags, err)
+	}
+
+	h.tagsMu.Lock()
+	defer h.tagsMu.Unlock()
+	settings, err := h.store.GetRoomSettings(ctx, room)
+	if errors.Is(err, storage.ErrNotFound) {
+		settings = storage.DefaultRoomSettings(room)
+	} else if err != nil {
+		return nil, err
+	}
+	settings.Tags = tags
+	settings.UpdatedAt = time.Now().UTC()
+	if err := h.store.SaveRoomSettings(ctx, settings); err != nil {
+		return nil, err
+	}
+	h.settings.forget(room)
+	return tags, nil
+}
+
+// ErrInvalidMetadata is returned, wrapped, for room metadata that isn't
+// valid (see storage.RoomMetadata)
+var ErrInvalidMetadata = errors.New("invalid room metadata")
+
+// SetRoomMetadata replaces room's language and content flags, returning
+// them normalized
+// Safe to call from any goroutine
+func (h *LocalHub) SetRoomMetadata(ctx context.Context, room string, meta storage.RoomMetadata) (storage.RoomMetadata, error) {
+	meta, err := meta.Normalize()
+	if err != nil {
+		return meta, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+
+	h.tagsMu.Lock()
+	defer h.tagsMu.Unlock()
+	settings, err := h.store.GetRoomSettings(ctx, room)
+	if errors.Is(err, storage.ErrNotFound) {
+		settings = storage.DefaultRoomSettings(room)
+	} else if err != nil {
+		return meta, err
+	}
+	settings.Metadata = meta
+	settings.UpdatedAt = time.Now().UTC()
+	if err := h.store.SaveRoomSettings(ctx, settings); err != nil {
+		return meta, err
+	}
+	h.settings.forget(room)
+	return meta, nil
+}
+
+// AnnounceTagged posts content as from to every room tagged tag,
+// returning the rooms
+// Safe to call from any goroutine
+func (h *LocalHub) AnnounceTagged(ctx context.Context, tag, from, content string) ([]string, error) {
+	rooms, err := storage.RoomsTagged(ctx, h.store, tag)
+	if err != nil {
+		return nil, err
+	}
+	for _, room := range rooms {
+		h.Announce(room, from, content)
+	}
+	return rooms, nil
+}
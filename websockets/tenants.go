@@ -0,0 +1,92 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+	"chat-app/tenant"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Tenant Overview:
+---------------
+WithTenants makes every connection present a tenant's API key (see
+the tenant package), in the X-API-Key header or, from browsers, the
+api_key query parameter:
+
+	GET /ws/lobby?username=alice&api_key=ck_4f1d...
+
+Connections without a valid key are refused with 401. A tenant at
+its connection or room limit is refused with 429, naming the limit:
+
+	{"error": "tenant acme has reached its limit of 500 open connections",
+	 "limit": "connections"}
+
+Messages over the tenant's rate get an error frame instead; like
+quotas, the check runs on the connection's own goroutine:
+
+	{"type": "error", "code": "tenant_rate_limited", "retry_after_ms": 120,
+	 "content": "tenant acme is over its limit of 50 messages per second"}
+*/
+
+// WithTenants requires a tenant API key and enforces tenants' limits
+func WithTenants(r *tenant.Registry) Option {
+	return func(o *handlerOptions) {
+		o.tenants = r
+	}
+}
+
+// admitTenant checks the request's API key, answering 401 (or 503 when
+// it can't be looked up) and returning false when it can't be used
+func admitTenant(c *gin.Context, r *tenant.Registry) (storage.Tenant, bool) {
+	key := tenant.KeyFromRequest(c.Request)
+	if key == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "an API key is required, in the " + tenant.KeyHeader + " header or the " + tenant.KeyParam + " parameter"})
+		return storage.Tenant{}, false
+	}
+	t, err := r.Authenticate(c.Request.Context(), key, time.Now())
+	switch {
+	case errors.Is(err, tenant.ErrUnknownKey):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return storage.Tenant{}, false
+	case err != nil:
+		reportStorageError("authenticate tenant", err, errreport.Context{})
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "API key could not be checked, retry shortly"})
+		return storage.Tenant{}, false
+	}
+	return t, true
+}
+
+// leaseTenant takes the connection's share of t's limits, answering 429
+// and returning false when t is at a limit
+func leaseTenant(c *gin.Context, r *tenant.Registry, t storage.Tenant, room string) (*tenant.Lease, bool) {
+	lease, err := r.Connect(t, room)
+	if err != nil {
+		var limit *tenant.LimitError
+		errors.As(err, &limit)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": limit.Error(), "limit": limit.Limit})
+		return nil, false
+	}
+	return lease, true
+}
+
+// allowTenant counts a message against c's tenant's rate, telling the
+// client and returning false when it is over
+func (c *Client) allowTenant(ctx context.Context) bool {
+	err := c.tenant.Allow(ctx, time.Now())
+	var limit *tenant.LimitError
+	if errors.As(err, &limit) {
+		msg := errorMessage(c, errCodeTenantRateLimited, limit.Error())
+		msg.RetryAfterMs = max(limit.RetryAfter.Milliseconds(), 1)
+		c.hub.Broadcast(msg)
+		return false
+	}
+	return true
+}
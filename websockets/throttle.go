@@ -0,0 +1,137 @@
+package websockets
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"chat-app/metrics"
+	"chat-app/ratelimit"
+)
+
+/*
+Connection Throttle Overview:
+----------------------------
+After an outage every client reconnects at once. Registering them all
+in the same second floods the hub's register path and starves the
+rooms already being served, so /ws can pace new connections:
+
+1. Per IP: each address may open IPRate connections per second
+   (bursts of IPBurst). Anything beyond is refused straight away
+2. Globally: the whole server accepts Rate connections per second
+   (bursts of Burst). Requests over the rate wait in a queue, each for
+   its turn, as long as their turn comes within QueueWait and fewer
+   than Queue requests are already waiting. Others are refused
+
+Refused requests get 429 with Retry-After set to when a slot is
+expected, so well-behaved clients spread themselves out instead of
+hammering the endpoint. Waiting happens before the upgrade, so it
+costs a goroutine and an idle TCP connection, not a WebSocket.
+*/
+
+// ThrottleConfig sets the connection rates; zero rates disable a limit
+type ThrottleConfig struct {
+	Rate      float64       // New connections per second, server-wide
+	Burst     int           // Connections allowed at once above Rate; defaults to Rate
+	Queue     int           // Requests that may wait for a global slot
+	QueueWait time.Duration // Longest a request waits for a global slot
+	IPRate    float64       // New connections per second from one IP
+	IPBurst   int           // Connections allowed at once from one IP; defaults to IPRate
+}
+
+// ConnectThrottle paces new connections per IP and server-wide
+type ConnectThrottle struct {
+	cfg     ThrottleConfig
+	global  *ratelimit.Limiter // Nil without a global rate
+	perIP   *ratelimit.Keyed   // Nil without a per-IP rate
+	waiting atomic.Int64       // Requests queued for a global slot
+}
+
+// Throttle results, used as metric labels
+const (
+	throttleIP        = "ip"
+	throttleGlobal    = "global"
+	throttleQueueFull = "queue_full"
+)
+
+// NewConnectThrottle builds a throttle; it returns nil if cfg limits nothing
+func NewConnectThrottle(cfg ThrottleConfig) *ConnectThrottle {
+	if cfg.Rate <= 0 && cfg.IPRate <= 0 {
+		return nil
+	}
+	t := &ConnectThrottle{cfg: cfg}
+	if cfg.Rate > 0 {
+		t.global = ratelimit.NewLimiter(cfg.Rate, burstOr(cfg.Burst, cfg.Rate))
+	}
+	if cfg.IPRate > 0 {
+		t.perIP = ratelimit.NewKeyed()
+	}
+	return t
+}
+
+// burstOr returns burst, or one second's worth of rate when it is unset
+func burstOr(burst int, rate float64) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Ceil(rate)
+}
+
+// admit waits for a connection slot for ip
+// It returns how long the client should wait before retrying when it
+// refuses, or 0 once the caller may proceed
+func (t *ConnectThrottle) admit(ctx context.Context, ip string) (time.Duration, bool) {
+	// Step 1: One address can't take everyone's slots
+	if t.perIP != nil {
+		if retry, ok := t.perIP.Reserve(ip, t.cfg.IPRate, burstOr(t.cfg.IPBurst, t.cfg.IPRate), time.Now(), 0); !ok {
+			metrics.ConnectThrottled.WithLabelValues(throttleIP).Inc()
+			return retry, false
+		}
+	}
+	if t.global == nil {
+		return 0, true
+	}
+
+	// Step 2: Queue for a server-wide slot
+	if t.waiting.Load() >= int64(t.cfg.Queue) {
+		metrics.ConnectThrottled.WithLabelValues(throttleQueueFull).Inc()
+		return t.cfg.QueueWait, false // Roughly when the queue will have moved on
+	}
+	wait, ok := t.global.Reserve(time.Now(), t.cfg.QueueWait)
+	if !ok {
+		metrics.ConnectThrottled.WithLabelValues(throttleGlobal).Inc()
+		return wait - t.cfg.QueueWait, false
+	}
+	if wait == 0 {
+		return 0, true
+	}
+
+	t.waiting.Add(1)
+	metrics.ConnectQueue.Inc()
+	defer func() {
+		t.waiting.Add(-1)
+		metrics.ConnectQueue.Dec()
+	}()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return 0, true
+	case <-ctx.Done():
+		return 0, false // Client gave up; its slot goes unused
+	}
+}
+
+// retryAfter formats a Retry-After value in whole seconds, at least 1
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
+
+// WithConnectThrottle paces new connections; nil disables throttling
+func WithConnectThrottle(t *ConnectThrottle) Option {
+	return func(o *handlerOptions) {
+		o.throttle = t
+	}
+}
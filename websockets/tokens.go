@@ -0,0 +1,124 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"chat-app/jwtauth"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Token Auth Overview:
+-------------------
+WithTokenAuth makes every connection present a token, a JWT unless
+the embedder plugs in its own TokenValidator, and takes the username
+from it instead of trusting the username parameter:
+
+	GET /ws/lobby?access_token=eyJhbGciOiJIUzI1NiIs...
+
+Browsers can't set headers on a WebSocket, and query strings end up in
+access logs, so the token may instead ride in Sec-WebSocket-Protocol,
+offered after the access_token protocol:
+
+	new WebSocket(url, ["access_token", token])
+
+The server picks access_token back, so the browser accepts the upgrade.
+A username parameter may still be sent but must match the token's.
+Missing, invalid or expired tokens are refused with 401 before anything
+else looks at the user. The token is checked when connecting only: a
+connection outlives its token's expiry, up to the server's maximum
+connection age (see lifetime.go).
+
+An auth hook (WithAuth) still runs afterwards, with the verified name.
+TokenAuth adapts a validator to the REST API's auth hooks, reading the
+Authorization: Bearer header or the access_token parameter.
+*/
+
+// Where tokens are looked for
+const (
+	tokenParam    = "access_token"
+	tokenProtocol = "access_token" // Offered before the token in Sec-WebSocket-Protocol
+)
+
+// errTokenMismatch is returned when the username parameter isn't the token's
+var errTokenMismatch = errors.New("username does not match the access token")
+
+// TokenValidator checks an access token and says who it belongs to
+// *jwtauth.Validator implements it
+type TokenValidator interface {
+	Validate(ctx context.Context, token string) (jwtauth.Identity, error)
+}
+
+// WithTokenAuth requires a valid access token on every connection and
+// takes the username from it
+func WithTokenAuth(v TokenValidator) Option {
+	return func(o *handlerOptions) {
+		o.tokens = v // HandleWebSocket offers tokenProtocol
+	}
+}
+
+// tokenFromRequest returns the access token sent with r, if any
+func tokenFromRequest(r *http.Request) string {
+	if token := r.URL.Query().Get(tokenParam); token != "" {
+		return token
+	}
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+	for i, p := range protocols {
+		if p == tokenProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// admitToken checks the request's access token, answering 401 and
+// returning false when there is none, it isn't valid or it names
+// someone other than username
+func admitToken(c *gin.Context, v TokenValidator, username string) (jwtauth.Identity, bool) {
+	token := tokenFromRequest(c.Request)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "an access token is required, in the " + tokenParam + " parameter or the Sec-WebSocket-Protocol header"})
+		return jwtauth.Identity{}, false
+	}
+	identity, err := v.Validate(c.Request.Context(), token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return jwtauth.Identity{}, false
+	}
+	if username != "" && username != identity.Username {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errTokenMismatch.Error()})
+		return jwtauth.Identity{}, false
+	}
+	return identity, true
+}
+
+// TokenAuth returns an auth hook for the REST API that requires a valid
+// bearer token and uses its username
+func TokenAuth(v TokenValidator) AuthFunc {
+	return func(c *gin.Context, room, username string) (string, error) {
+		token := c.Query(tokenParam)
+		if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			token = strings.TrimSpace(bearer)
+		}
+		if token == "" {
+			return "", errors.New("an access token is required, as a bearer token or the " + tokenParam + " parameter")
+		}
+		identity, err := v.Validate(c.Request.Context(), token)
+		if err != nil {
+			return "", err
+		}
+		if username != "" && username != identity.Username {
+			return "", errTokenMismatch
+		}
+		return identity.Username, nil
+	}
+}
@@ -0,0 +1,340 @@
+package websockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"slices"
+	"time"
+)
+
+/*
+File Transfer Overview:
+----------------------
+Files too big to send through the server go straight from one client
+to another over a WebRTC data channel. The server only brokers the
+handshake: it never sees the file, and it keeps no transfer state
+beyond the handshake.
+
+1. The sender offers a file to a member of the room:
+   {"type": "transfer_offer",
+    "transfer": {"to": "bob", "name": "photos.zip", "size": 73400320, "mime": "application/zip"}}
+   The sender gets the transfer's ID back in a transfer_sent frame,
+   and each of bob's connections gets the offer, both with the
+   STUN servers to use (see WithICEServers):
+   {"type": "transfer_offer", "username": "alice", "transfer": {"id": "...", ...},
+    "ice_servers": [{"urls": ["stun:stun.example.com:3478"]}]}
+2. One of bob's connections answers with
+   {"type": "transfer_accept", "id": "..."} or transfer_decline, and
+   the sender is told which
+3. Once accepted, both sides exchange SDP and ICE candidates with
+   {"type": "transfer_signal", "id": "...", "signal": {...}}; the
+   signal is any JSON object, passed to the other side untouched
+4. Either side can stop with {"type": "transfer_cancel", "id": "..."};
+   the other side gets a transfer_cancel whose code says why
+   (cancelled, expired or disconnected)
+
+Offers expire after transferOfferTTL unanswered, and signaling after
+transferSignalTTL, by when the data channel is open or has failed.
+Only the two connections in a transfer can act on it; anything else
+gets an unknown_transfer error. In a cluster the room's owner brokers
+every transfer, and transfers in flight are lost if ownership moves.
+*/
+
+// Transfer brokering limits
+const (
+	transferOfferTTL    = 2 * time.Minute // An offer waits this long for an answer
+	transferSignalTTL   = 5 * time.Minute // Signaling may go on this long after accept
+	maxTransfersPerConn = 5               // Open transfers a connection may have offered
+	maxTransferSignals  = 200             // Signals relayed per transfer
+	maxTransferName     = 255             // Bytes
+	maxTransferMIME     = 127             // Bytes
+	maxSignalSize       = 16 << 10        // Bytes; SDP runs to a few KB
+)
+
+// Transfer describes a file offered on transfer frames
+type Transfer struct {
+	ID   string `json:"id,omitempty"`
+	To   string `json:"to,omitempty"` // Recipient's username
+	Name string `json:"name,omitempty"`
+	Size int64  `json:"size,omitempty"` // Bytes, as claimed by the sender
+	MIME string `json:"mime,omitempty"`
+}
+
+// ICEServer is a WebRTC ICE server, shaped like RTCIceServer
+type ICEServer struct {
+	URLs []string `json:"urls"`
+}
+
+// WithICEServers gives transfer peers STUN servers to find each other with
+func WithICEServers(urls []string) HubOption {
+	return func(h *LocalHub) {
+		if len(urls) > 0 {
+			h.iceServers = []ICEServer{{URLs: urls}}
+		}
+	}
+}
+
+// isTransfer reports whether msgType is a transfer command from a client
+func isTransfer(msgType string) bool {
+	switch msgType {
+	case "transfer_offer", "transfer_accept", "transfer_decline", "transfer_cancel", "transfer_signal":
+		return true
+	}
+	return false
+}
+
+// endpoint is one connection in a transfer, on this node or another
+type endpoint struct {
+	node string // Empty for this node
+	conn string
+}
+
+// transfer is a handshake being brokered by the room's owner
+type transfer struct {
+	Transfer
+	room     string
+	from     string    // Sender's username
+	offerer  endpoint  // The sender's connection
+	answerer *endpoint // The recipient's connection that accepted; nil until then
+	expires  time.Time
+	signals  int
+}
+
+// peer returns the other side of the transfer from ep, if ep is in it
+func (t *transfer) peer(ep endpoint) (endpoint, bool) {
+	switch {
+	case ep == t.offerer && t.answerer != nil:
+		return *t.answerer, true
+	case t.answerer != nil && ep == *t.answerer:
+		return t.offerer, true
+	}
+	return endpoint{}, false
+}
+
+// senderEndpoint is the connection msg came from
+func senderEndpoint(msg Message) endpoint {
+	if msg.sender != nil {
+		return endpoint{conn: msg.sender.id}
+	}
+	return endpoint{node: msg.origin.node, conn: msg.origin.conn}
+}
+
+// handleTransfer brokers one step of a transfer handshake
+func (h *LocalHub) handleTransfer(msg Message, now time.Time) {
+	if msg.Type == "transfer_offer" {
+		h.offerTransfer(msg, now)
+		return
+	}
+
+	ep := senderEndpoint(msg)
+	t, ok := h.transfers[msg.ID]
+	if ok && now.After(t.expires) {
+		h.expireTransfer(t)
+		ok = false
+	}
+	if !ok || t.room != msg.RoomName {
+		h.transferError(msg, errCodeUnknownTransfer, "no such transfer")
+		return
+	}
+
+	switch msg.Type {
+	case "transfer_accept", "transfer_decline":
+		if t.answerer != nil || msg.Username != t.To {
+			h.transferError(msg, errCodeUnknownTransfer, "no such transfer offered to you")
+			return
+		}
+		if msg.Type == "transfer_decline" {
+			delete(h.transfers, t.ID)
+		} else {
+			t.answerer = &ep
+			t.expires = now.Add(transferSignalTTL)
+		}
+		h.sendToEndpoint(t.offerer, h.transferFrame(msg.Type, t, msg.Username, ""))
+	case "transfer_cancel":
+		// The sender can withdraw an offer nobody has answered yet
+		if ep == t.offerer && t.answerer == nil {
+			delete(h.transfers, t.ID)
+			h.sendToUser(t.room, t.To, h.transferFrame("transfer_cancel", t, t.from, transferCancelled))
+			return
+		}
+		peer, ok := t.peer(ep)
+		if !ok {
+			h.transferError(msg, errCodeUnknownTransfer, "no such transfer")
+			return
+		}
+		delete(h.transfers, t.ID)
+		h.sendToEndpoint(peer, h.transferFrame("transfer_cancel", t, msg.Username, transferCancelled))
+	case "transfer_signal":
+		peer, ok := t.peer(ep)
+		if !ok {
+			h.transferError(msg, errCodeUnknownTransfer, "no accepted transfer with that id")
+			return
+		}
+		if t.signals >= maxTransferSignals {
+			h.transferError(msg, errCodeBadFrame, "too many signals for one transfer")
+			return
+		}
+		t.signals++
+		frame := h.transferFrame("transfer_signal", t, msg.Username, "")
+		frame.Signal = msg.Signal
+		h.sendToEndpoint(peer, frame)
+	}
+}
+
+// offerTransfer registers a new transfer and offers it to the recipient
+func (h *LocalHub) offerTransfer(msg Message, now time.Time) {
+	offer := msg.Transfer
+	switch {
+	case offer == nil || offer.Name == "" || offer.Size <= 0:
+		h.transferError(msg, errCodeBadFrame, "transfer offers need a file name and size")
+		return
+	case len(offer.Name) > maxTransferName:
+		h.transferError(msg, errCodeBadFrame, fmt.Sprintf("file names are limited to %d bytes", maxTransferName))
+		return
+	case offer.MIME != "" && !validMIME(offer.MIME):
+		h.transferError(msg, errCodeBadFrame, "mime must be a media type like application/zip")
+		return
+	case offer.To == msg.Username || !h.inRoom(msg.RoomName, offer.To):
+		h.transferError(msg, errCodeUnknownRecipient, "the recipient must be someone else in this room")
+		return
+	}
+
+	ep := senderEndpoint(msg)
+	open := 0
+	for _, t := range h.transfers {
+		if t.offerer == ep && !now.After(t.expires) {
+			open++
+		}
+	}
+	if open >= maxTransfersPerConn {
+		h.transferError(msg, errCodeBadFrame, fmt.Sprintf("at most %d open transfers per connection", maxTransfersPerConn))
+		return
+	}
+
+	t := &transfer{
+		Transfer: Transfer{ID: newID(), To: offer.To, Name: offer.Name, Size: offer.Size, MIME: offer.MIME},
+		room:     msg.RoomName,
+		from:     msg.Username,
+		offerer:  ep,
+		expires:  now.Add(transferOfferTTL),
+	}
+	h.transfers[t.ID] = t
+	h.sendToEndpoint(ep, h.transferFrame("transfer_sent", t, msg.Username, ""))
+	h.sendToUser(t.room, t.To, h.transferFrame("transfer_offer", t, msg.Username, ""))
+}
+
+// Codes on transfer_cancel frames
+const (
+	transferCancelled    = "cancelled"
+	transferExpired      = "expired"
+	transferDisconnected = "disconnected"
+)
+
+// transferFrame builds a frame about t, from username
+// Frames that start or continue a handshake carry the ICE servers
+func (h *LocalHub) transferFrame(msgType string, t *transfer, username, code string) Message {
+	frame := Message{Type: msgType, Code: code, RoomName: t.room, Username: username, Transfer: &Transfer{ID: t.ID}}
+	switch msgType {
+	case "transfer_sent", "transfer_offer":
+		described := t.Transfer
+		frame.Transfer = &described
+		frame.ICEServers = h.iceServers
+	case "transfer_accept":
+		frame.ICEServers = h.iceServers
+	}
+	return frame
+}
+
+// transferError tells the sender of msg what was wrong with it
+func (h *LocalHub) transferError(msg Message, code, text string) {
+	h.reply(msg, Message{Type: "error", Code: code, Content: text, RoomName: msg.RoomName})
+}
+
+// expireTransfer drops t, telling whoever is waiting on it
+func (h *LocalHub) expireTransfer(t *transfer) {
+	delete(h.transfers, t.ID)
+	h.sendToEndpoint(t.offerer, h.transferFrame("transfer_cancel", t, t.To, transferExpired))
+	if t.answerer != nil {
+		h.sendToEndpoint(*t.answerer, h.transferFrame("transfer_cancel", t, t.from, transferExpired))
+	}
+}
+
+// expireTransfers drops transfers past their deadline
+func (h *LocalHub) expireTransfers(now time.Time) {
+	for _, t := range h.transfers {
+		if now.After(t.expires) {
+			h.expireTransfer(t)
+		}
+	}
+}
+
+// dropTransfers ends the transfers a departing local connection was in
+func (h *LocalHub) dropTransfers(client *Client) {
+	ep := endpoint{conn: client.id}
+	for _, t := range h.transfers {
+		switch {
+		case t.offerer == ep && t.answerer == nil:
+			delete(h.transfers, t.ID)
+			h.sendToUser(t.room, t.To, h.transferFrame("transfer_cancel", t, t.from, transferDisconnected))
+		case t.offerer == ep || (t.answerer != nil && *t.answerer == ep):
+			delete(h.transfers, t.ID)
+			peer, _ := t.peer(ep)
+			h.sendToEndpoint(peer, h.transferFrame("transfer_cancel", t, client.username, transferDisconnected))
+		}
+	}
+}
+
+// inRoom reports whether username has a connection in room on any node
+func (h *LocalHub) inRoom(room, username string) bool {
+	for client := range h.rooms[room] {
+		if client.username == username {
+			return true
+		}
+	}
+	for _, users := range h.remoteUsers[room] {
+		if slices.Contains(users, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendToEndpoint delivers a private frame to one connection, wherever it is
+func (h *LocalHub) sendToEndpoint(ep endpoint, msg Message) {
+	if ep.node == "" {
+		if client, ok := h.conns[ep.conn]; ok {
+			h.sendTo(client, msg)
+		}
+		return
+	}
+	h.sendFrame(nil, ep.node, relayFrame{Kind: frameReply, Room: msg.RoomName, Message: &msg, Conn: ep.conn})
+}
+
+// sendToUser delivers a private frame to each of a user's connections in room
+func (h *LocalHub) sendToUser(room, username string, msg Message) {
+	for client := range h.rooms[room] {
+		if client.username == username {
+			h.sendTo(client, msg)
+		}
+	}
+	for node, users := range h.remoteUsers[room] {
+		if slices.Contains(users, username) {
+			h.sendFrame(nil, node, relayFrame{Kind: frameUser, Room: room, Message: &msg, Users: []string{username}})
+		}
+	}
+}
+
+// validSignal reports whether a signal is a JSON object small enough to relay
+func validSignal(signal json.RawMessage) bool {
+	return len(signal) > 0 && len(signal) <= maxSignalSize && signal[0] == '{'
+}
+
+// validMIME reports whether s is a plain media type
+func validMIME(s string) bool {
+	if len(s) > maxTransferMIME {
+		return false
+	}
+	_, _, err := mime.ParseMediaType(s)
+	return err == nil
+}
@@ -1,11 +1,27 @@
 package websockets
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/geoip"
+	"chat-app/jwtauth"
+	"chat-app/metrics"
+	"chat-app/recording"
+	"chat-app/storage"
+	"chat-app/tenant"
+	"chat-app/tracing"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
@@ -19,59 +35,239 @@ Main responsibilities:
 4. Register clients with the hub
 
 Connection Flow:
-1. Client connects to /ws/:room?username=xxx
+1. Client connects to /ws/:room?username=xxx (or with an access token, see tokens.go),
+   or to /ws/:room/:breakout for a breakout room (see breakouts.go)
 2. Validate room name and username
 3. Upgrade to WebSocket connection
 4. Create new client
 5. Start message handling
 */
 
-// upgrader converts HTTP connections to WebSocket connections
-var upgrader = websocket.Upgrader{
-	// Buffer sizes affect memory usage and performance
-	ReadBufferSize:  1024,  // Adjust based on expected message sizes
-	WriteBufferSize: 1024,
-
-	// CheckOrigin prevents unauthorized cross-origin requests
-	// WARNING: Current implementation allows all origins - NOT SAFE FOR PRODUCTION
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Development only - accepts all origins
-	},
-}
-
 // HandleWebSocket creates a WebSocket handler function for Gin
 // This is where new WebSocket connections are established
-func HandleWebSocket(h *Hub) gin.HandlerFunc {
+// Options customize the upgrader and add an auth hook (see options.go)
+func HandleWebSocket(h Hub, opts ...Option) gin.HandlerFunc {
+	options := defaultHandlerOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	upgrader := options.upgrader
+	// Added here, so WithSubprotocols can't drop it whatever the order
+	if options.tokens != nil && !slices.Contains(upgrader.Subprotocols, tokenProtocol) {
+		upgrader.Subprotocols = append(slices.Clip(upgrader.Subprotocols), tokenProtocol)
+	}
+
 	return func(c *gin.Context) {
 		// Step 1: Extract and validate connection parameters
 		room := c.Param("room")
 		username := c.Query("username")
 
-		// Validate required fields
-		if room == "" || username == "" {
+		// Validate required fields; with token auth the token names the user
+		if room == "" || (username == "" && options.tokens == nil) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "room and username are required"})
 			return
 		}
 
+		// Breakout rooms are served under their parent (see breakouts.go)
+		if name := c.Param("breakout"); name != "" {
+			breakout, err := storage.BreakoutRoom(room, name)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			room = breakout
+		}
+
+		// A draining server sends new clients elsewhere
+		if d, ok := h.(drainer); ok && d.Draining() {
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server is draining"})
+			return
+		}
+
+		// Pace reconnect storms before doing any real work
+		if options.throttle != nil {
+			if retry, ok := options.throttle.admit(c.Request.Context(), c.ClientIP()); !ok {
+				if c.Request.Context().Err() != nil {
+					return // The client hung up while queued
+				}
+				c.Header("Retry-After", retryAfter(retry))
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many connection attempts, retry later"})
+				return
+			}
+		}
+
+		// Hosted deployments know every connection's tenant (see tenants.go)
+		var customer storage.Tenant
+		if options.tenants != nil {
+			t, ok := admitTenant(c, options.tenants)
+			if !ok {
+				return
+			}
+			customer = t
+		}
+
+		// Shed bursts from one place
+		meta := requestMeta(c, options.geo)
+		if !allowConnection(options, meta.location) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many connections from your region, retry shortly"})
+			return
+		}
+
+		// Addresses flagged by the anomaly detector wait out their cooldown
+		screen, screening := h.(screener)
+		if screening {
+			if retry, ok := screen.ScreenIP(meta.ip); !ok {
+				c.Header("Retry-After", retryAfter(retry))
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "too much unusual activity, retry later"})
+				return
+			}
+		}
+
+		// With token auth the user is whoever the token says (see tokens.go)
+		var identity *jwtauth.Identity
+		if options.tokens != nil {
+			verified, ok := admitToken(c, options.tokens, username)
+			if !ok {
+				return
+			}
+			identity, username = &verified, verified.Username
+		}
+
+		// Let the embedder's auth hook approve (and possibly rename) the user
+		if options.auth != nil {
+			verified, err := options.auth(c, room, username)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			username = verified
+		}
+
+		// Remote users are shown as user@server, so local names can't look like one
+		if fed, ok := h.(federatedHub); ok && fed.Federates() && strings.Contains(username, "@") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "usernames can't contain @ on a federated server"})
+			return
+		}
+
+		// Flagged users wait, or sign in again if that's what was asked
+		if screening {
+			if retry, reauth, ok := screen.ScreenUser(username, options.auth != nil || options.tokens != nil); !ok {
+				c.Header("Retry-After", retryAfter(retry))
+				if reauth {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "re-authentication required"})
+				} else {
+					c.JSON(http.StatusTooManyRequests, gin.H{"error": "too much unusual activity, retry later"})
+				}
+				return
+			}
+		}
+
+		// Moderators can ban a user from a room (see review.go)
+		if bans, ok := h.(banChecker); ok {
+			if _, banned := bans.Banned(room, username); banned {
+				c.JSON(http.StatusForbidden, gin.H{"error": "you are banned from this room"})
+				return
+			}
+		}
+
+		// Breakouts last as long as they're open, for whoever may join their parent
+		if storage.IsBreakout(room) && !admitBreakout(c, h, options, room, username) {
+			return
+		}
+
+		// A second login to the room may be turned away (see logins.go)
+		if logins, ok := h.(loginChecker); ok && logins.RefusesLogin(room, username, c.Query("resume")) {
+			c.JSON(http.StatusConflict, gin.H{"error": "you are already connected to this room"})
+			return
+		}
+
+		// Archived rooms are read-only, and only for those who were in them
+		if archives, ok := h.(archiveChecker); ok {
+			if _, archived := archives.Archived(room); archived && !archives.Member(room, username) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "this room is archived"})
+				return
+			}
+		}
+
+		// Invite-only rooms let in their owners and those invited (see invites.go)
+		if invites, ok := h.(inviteChecker); ok && !ownsInvites(c.Request.Context(), options.permissions, room, username) &&
+			!invites.Invited(room, username, c.Query("invite")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this room is invite-only", "code": errCodeNotInvited})
+			return
+		}
+
+		// Rooms under a raid can turn away new accounts and pace joins
+		if options.joins != nil {
+			if refusal := options.joins.admit(c.Request.Context(), room, username); refusal != nil {
+				if refusal.status == 0 {
+					return // The client hung up while queued
+				}
+				if refusal.retry > 0 {
+					c.Header("Retry-After", retryAfter(refusal.retry))
+				}
+				c.JSON(refusal.status, gin.H{"error": refusal.message})
+				return
+			}
+		}
+
+		// Count the connection against its tenant's limits
+		var lease *tenant.Lease
+		if options.tenants != nil {
+			l, ok := leaseTenant(c, options.tenants, customer, room)
+			if !ok {
+				return
+			}
+			lease = l
+		}
+
+		// Identify this connection in logs, error reports, the event log
+		// and admin views; the client sees it in the response header
+		connID := newConnID()
+
+		// Continue any trace started by the caller (e.g. a load balancer)
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+		_, span := tracing.Tracer().Start(ctx, "ws.upgrade",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("chat.room", room),
+				attribute.String("chat.username", username),
+				attribute.String("chat.conn_id", connID),
+			))
+		defer span.End()
+
 		// Step 2: Upgrade HTTP connection to WebSocket
-		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, http.Header{connIDHeader: {connID}})
 		if err != nil {
-			log.Printf("Failed to upgrade connection: %v", err)
+			log.Printf("Failed to upgrade connection conn=%s room=%s user=%s: %v", connID, room, username, err)
+			errreport.CaptureError(err, errreport.Context{Room: room, Username: username, ConnID: connID})
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "upgrade failed")
+			lease.Release()
 			return
 		}
 
 		// Step 3: Create new client instance
-		client := &Client{
-			hub:      h,
-			conn:     conn,
-			send:     make(chan []byte, 256), // Buffer size affects memory usage
-			room:     room,
-			username: username,
-		}
+		// Recording sees what crossed the wire, before any injected faults
+		recorded := recordSession(conn, options.recorder, recording.Header{Conn: connID, Room: room, Username: username, Started: time.Now()})
+		client := newClient(h, injectFaults(recorded, options.chaos, connID), room, username, connID)
+		meta.subprotocol = conn.Subprotocol()
+		client.meta = meta
+		client.links = options.links
+		client.emoji = options.emoji
+		client.clean = options.clean
+		client.uploads = options.uploads
+		client.quotas = options.quotas
+		client.tenant = lease
+		client.permissions = options.permissions
+		client.reportRTT = options.rttReports
+		client.resume = c.Query("resume")
+		client.identity = identity
 
 		// Step 4: Register client with hub
 		// This also triggers the "user joined" notification
-		h.register <- client
+		h.Register(client)
 
 		// Create and broadcast join message
 		joinMessage := Message{
@@ -79,12 +275,46 @@ func HandleWebSocket(h *Hub) gin.HandlerFunc {
 			Content:  username + " joined the room",
 			RoomName: room,
 			Username: username,
+			sender:   client, // Lets the hub keep quiet about takeovers and refusals
 		}
-		h.broadcast <- joinMessage
+		h.Broadcast(joinMessage)
 
 		// Step 5: Start client read/write pumps
 		// These goroutines handle the ongoing communication
-		go client.writePump() // Handles sending messages to the client
-		go client.readPump()  // Handles receiving messages from the client
+		client.Start()
+	}
+}
+
+// allowConnection applies the location rate limits, counting the attempt
+func allowConnection(options handlerOptions, loc geoip.Location) bool {
+	if options.geo == nil {
+		return true
+	}
+	country := loc.Country
+	if country == "" {
+		country = "unknown"
+	}
+	if options.limits != nil && !options.limits.Allow(loc, time.Now()) {
+		metrics.GeoConnections.WithLabelValues(country, "rate_limited").Inc()
+		return false
 	}
-}
\ No newline at end of file
+	metrics.GeoConnections.WithLabelValues(country, "accepted").Inc()
+	return true
+}
+
+// connIDHeader carries the connection ID in the upgrade response
+const connIDHeader = "X-Connection-Id"
+
+// newConnID returns a random identifier for a single connection
+func newConnID() string {
+	return newID()
+}
+
+// newID returns a random 16-character hex identifier
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
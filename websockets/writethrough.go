@@ -0,0 +1,271 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"time"
+
+	"chat-app/errreport"
+	"chat-app/storage"
+)
+
+/*
+Write-Through Overview:
+----------------------
+Every message posted to a room is stored before anyone sees it, so
+the history API (served from the store) has everything the room saw,
+and a message the store refuses is refused to its sender rather than
+silently missing from history later:
+
+1. The hub numbers the message in its room's order (seq)
+2. One of persistWriters writers stores it, off the hub goroutine
+3. Back on the hub, the message is sent to the room, relayed, logged
+   and acked; if it couldn't be stored, only its sender hears, with a
+   storage_unavailable error, and its seq is given back when no later
+   message has taken the next one
+
+Each room's messages go to the same writer, so they are stored and
+delivered in seq order, while rooms on other writers don't wait for
+them. A writer with persistQueue messages waiting refuses the next
+one the same way rather than stall the hub. A retry of a message still
+being stored is dropped; the original's ack answers it.
+
+Durable messages are queued for offline members on the room's writer
+too, behind the messages before them (storeInOrder), as are the
+unacked durable deliveries of a client that left. Users' synced keys
+and notification preferences are saved the same way, on a writer
+chosen by username.
+
+The hub reads the store off its goroutine too. When a room reopens,
+or a message is posted to a room nobody is in, the room's last seq is
+read in the background and its messages wait for it (resumeSeq).
+Pins, quotes and recalls of a message that is no longer among the
+recent ones send the hub the message once it is fetched, and pick up
+where they left off (findMessage). What waits in the store for a
+joining user (their avatar, queued messages and the room's welcome)
+follows the join once read (loadArrival).
+*/
+
+const (
+	// Goroutines storing room messages; each room is stored by one
+	persistWriters = 8
+
+	// Jobs waiting for each writer before new messages are refused
+	persistQueue = 256
+)
+
+// publishing is a message on its way to its room, past every check
+type publishing struct {
+	ctx      context.Context
+	msg      Message
+	ackKey   idempotencyKey // Zero unless the sender asked for an idempotent send
+	received time.Time
+}
+
+// newWriters makes the writers' queues; Run starts the writers
+func newWriters() []chan func() {
+	writers := make([]chan func(), persistWriters)
+	for i := range writers {
+		writers[i] = make(chan func(), persistQueue)
+	}
+	return writers
+}
+
+// runWriter does the storage work queued for it, one job at a time
+func (h *LocalHub) runWriter(queue <-chan func()) {
+	for job := range queue {
+		job()
+	}
+}
+
+// writerFor picks the writer a room's messages are stored by
+func (h *LocalHub) writerFor(room string) chan func() {
+	hash := fnv.New32a()
+	hash.Write([]byte(room))
+	return h.writers[hash.Sum32()%uint32(len(h.writers))]
+}
+
+// save stores a message on its writer and hands it back to the hub
+func (h *LocalHub) save(p publishing) {
+	err := h.persist(p.msg)
+	h.queries <- func() { h.written(p, err) }
+}
+
+// storeInOrder queues storage work on the writer key picks (a room, or
+// a username), after the work already queued for that key; it runs on
+// its own instead when the writer is full, so the hub never waits for it
+func (h *LocalHub) storeInOrder(key string, job func()) {
+	select {
+	case h.writerFor(key) <- job:
+	default:
+		go job()
+	}
+}
+
+// publish numbers a message and stores it, or sends it straight on if
+// it is control traffic nobody asked to have delivered reliably
+func (h *LocalHub) publish(p publishing) {
+	control := isControl(p.msg.Type)
+	if !control && h.awaitSeq(p) {
+		return
+	}
+
+	// The hub goroutine is the room's single writer: order is fixed here
+	if !control {
+		h.seqs[p.msg.RoomName]++
+		p.msg.Seq = h.seqs[p.msg.RoomName]
+	}
+	if control && !reliable(p.msg.QoS) {
+		h.deliver(p)
+		return
+	}
+
+	select {
+	case h.writerFor(p.msg.RoomName) <- func() { h.save(p) }:
+		h.unsaved[p.msg.RoomName]++
+		if p.ackKey.key != "" {
+			h.storing[p.ackKey] = true
+		}
+	default:
+		h.refuse(p) // The store is too far behind
+	}
+}
+
+// written finishes publishing a message once a writer has stored it
+func (h *LocalHub) written(p publishing, err error) {
+	room := p.msg.RoomName
+	if h.unsaved[room]--; h.unsaved[room] <= 0 {
+		delete(h.unsaved, room)
+	}
+	delete(h.storing, p.ackKey)
+	if err != nil {
+		reportStorageError("save message", err, errreport.Context{Room: room, Username: p.msg.Username})
+		h.refuse(p)
+		return
+	}
+	h.deliver(p)
+}
+
+// refuse tells a message's sender it wasn't stored, so nobody saw it
+func (h *LocalHub) refuse(p publishing) {
+	room := p.msg.RoomName
+	if p.msg.Seq != 0 && h.seqs[room] == p.msg.Seq {
+		h.seqs[room]-- // Nobody saw this number; don't leave a gap
+	}
+	h.reply(p.msg, Message{
+		Type:     "error",
+		Code:     errCodeStorage,
+		Content:  "message could not be stored, please retry",
+		RoomName: room,
+	})
+}
+
+// awaitSeq holds a message while its room's seq is read from storage,
+// starting that if the hub doesn't know the room's seq; it reports
+// whether the message has to wait
+func (h *LocalHub) awaitSeq(p publishing) bool {
+	room := p.msg.RoomName
+	_, known := h.seqs[room]
+	if _, waiting := h.unnumbered[room]; known && !waiting {
+		return false
+	}
+	h.resumeSeq(room)
+	h.unnumbered[room] = append(h.unnumbered[room], p)
+	return true
+}
+
+// resumeSeq continues a reopened room's sequence after its stored history,
+// so seq stays unique across restarts, restores and empty-room cleanup
+// The stored seq is read off the hub goroutine; the room's messages wait
+// for it meanwhile
+func (h *LocalHub) resumeSeq(room string) {
+	if _, waiting := h.unnumbered[room]; waiting {
+		return
+	}
+	h.unnumbered[room] = nil
+	go func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		last, err := h.store.LastSeq(ctx, room)
+		h.queries <- func() { h.resumedSeq(room, last, err) }
+	}()
+}
+
+// resumedSeq takes up a room's sequence from its stored seq, then
+// publishes the messages that waited for it
+func (h *LocalHub) resumedSeq(room string, last uint64, err error) {
+	waiting := h.unnumbered[room]
+	delete(h.unnumbered, room)
+	if err != nil {
+		reportStorageError("last seq", err, errreport.Context{Room: room})
+		for _, p := range waiting {
+			h.refuse(p) // Numbering them now could repeat a stored seq
+		}
+		return
+	}
+	// A restored snapshot may be ahead of storage for unpersisted messages
+	h.seqs[room] = max(h.seqs[room], last)
+	for _, p := range waiting {
+		h.publish(p)
+	}
+}
+
+// forgetSeqs drops the seqs of rooms nobody is in, left by messages
+// posted to them, once nothing of theirs is being stored; the next
+// message resumes the room's seq from storage
+func (h *LocalHub) forgetSeqs() {
+	if h.draining.Load() {
+		return // Kept for the state snapshot, see closeRoomIfEmpty
+	}
+	for room := range h.seqs {
+		if _, waiting := h.unnumbered[room]; !waiting && !h.roomActive(room) && h.unsaved[room] == 0 {
+			delete(h.seqs, room)
+		}
+	}
+}
+
+// found is what pins, quotes and recalls need of the message they name
+type found struct {
+	author  string
+	content string
+	sent    time.Time
+}
+
+// lookedUp is a stored message fetched for a message naming it
+type lookedUp struct {
+	id    string
+	found found
+	ok    bool // Whether it exists in the room
+}
+
+// findMessage finds the message of msg's room named id, among the recent
+// messages or, once fetched, in storage
+// If it has to be fetched, findMessage reports pending and the caller
+// drops msg: it comes back through the hub when the fetch is done
+func (h *LocalHub) findMessage(msg Message, id string) (f found, ok, pending bool) {
+	if recent, ok := h.recent.get(msg.RoomName, id); ok {
+		return found{author: recent.Username, content: recent.Content, sent: time.UnixMilli(recent.ServerTime)}, true, false
+	}
+	if msg.lookup != nil && msg.lookup.id == id {
+		return msg.lookup.found, msg.lookup.ok, false
+	}
+	go h.lookUp(msg, id)
+	return found{}, false, true
+}
+
+// lookUp fetches the message msg names and hands msg back to the hub
+func (h *LocalHub) lookUp(msg Message, id string) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	stored, err := h.store.GetMessage(ctx, id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		reportStorageError("load message", err, errreport.Context{Room: msg.RoomName})
+	}
+	msg.lookup = &lookedUp{
+		id:    id,
+		found: found{author: stored.Username, content: stored.Content, sent: stored.CreatedAt},
+		ok:    err == nil && stored.Room == msg.RoomName,
+	}
+	h.queries <- func() { h.handleBroadcast(msg) }
+}
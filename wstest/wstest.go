@@ -0,0 +1,282 @@
+package wstest
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-app/client"
+	"chat-app/recording"
+	"chat-app/websockets"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+WebSocket Test Harness Overview:
+-------------------------------
+Helpers for end-to-end tests of the chat server. NewServer starts a
+real hub and WebSocket handler on an httptest server; Connect dials
+it with scripted clients that send messages and assert on what
+arrives:
+
+	srv := wstest.NewServer(t)
+	alice := srv.Connect(t, "room1", "alice")
+	bob := srv.Connect(t, "room1", "bob")
+	alice.ExpectJoin("bob")
+
+	bob.Send("hi")
+	alice.ExpectChat("bob", "hi")
+
+	bob.Close()
+	alice.ExpectLeave("bob")
+	alice.AssertOrdered()
+
+Replay drives a connection from a recording instead, to reproduce
+what a user did (see the recording package).
+
+Everything is closed automatically through t.Cleanup.
+*/
+
+// DefaultTimeout is how long Expect helpers wait for a message
+const DefaultTimeout = 2 * time.Second
+
+// T is the subset of testing.TB the helpers need
+type T interface {
+	Helper()
+	Fatalf(format string, args ...any)
+	Cleanup(func())
+}
+
+// Server is a chat server running on a local port
+type Server struct {
+	Hub *websockets.LocalHub
+	URL string // Base URL for clients, e.g. ws://127.0.0.1:54321
+
+	http *httptest.Server
+}
+
+// NewServer starts a hub and handler; opts are passed to HandleWebSocket
+func NewServer(t T, opts ...websockets.Option) *Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	hub := websockets.NewHub()
+	go hub.Run()
+
+	r := gin.New()
+	ws := websockets.HandleWebSocket(hub, opts...)
+	r.GET("/ws/:room", ws)
+	r.GET("/ws/:room/:breakout", ws)
+
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+
+	return &Server{
+		Hub:  hub,
+		URL:  "ws" + strings.TrimPrefix(ts.URL, "http"),
+		http: ts,
+	}
+}
+
+// Client is a scripted connection to the test server
+type Client struct {
+	Username string
+
+	t        T
+	conn     *client.Conn
+	timeout  time.Duration
+	messages chan client.Message // Fed by a background reader
+
+	mu       sync.Mutex
+	lastSeq  uint64
+	reorders []string // Descriptions of out-of-order deliveries
+}
+
+// Connect joins room as username, failing the test if the dial fails
+// It consumes the client's own join notifications so tests start clean
+func (s *Server) Connect(t T, room, username string) *Client {
+	t.Helper()
+
+	conn, err := client.Dial(context.Background(), s.URL, room, username)
+	if err != nil {
+		t.Fatalf("wstest: connect %s to %s: %v", username, room, err)
+	}
+
+	c := &Client{
+		Username: username,
+		t:        t,
+		conn:     conn,
+		timeout:  DefaultTimeout,
+		messages: make(chan client.Message, 256),
+	}
+	go c.read()
+	t.Cleanup(func() { c.conn.Close() })
+
+	c.ExpectJoin(username)
+	return c
+}
+
+// WithTimeout changes how long this client's Expect helpers wait
+func (c *Client) WithTimeout(d time.Duration) *Client {
+	c.timeout = d
+	return c
+}
+
+// Send posts a chat message
+func (c *Client) Send(text string) {
+	c.t.Helper()
+	if err := c.conn.Send(text); err != nil {
+		c.t.Fatalf("wstest: %s send: %v", c.Username, err)
+	}
+}
+
+// Close disconnects the client
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+// read forwards incoming messages until the connection closes
+func (c *Client) read() {
+	defer close(c.messages)
+	for {
+		msg, err := c.conn.Receive()
+		if err != nil {
+			return
+		}
+		c.checkOrder(msg)
+		c.messages <- msg
+	}
+}
+
+// checkOrder records any message whose room sequence didn't increase
+func (c *Client) checkOrder(msg client.Message) {
+	if msg.Seq == 0 || msg.Redelivered {
+		return // Control frames are not sequenced; redeliveries are late by design
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastSeq != 0 && msg.Seq <= c.lastSeq {
+		c.reorders = append(c.reorders,
+			fmt.Sprintf("seq %d (%s %q) after seq %d", msg.Seq, msg.Type, msg.Content, c.lastSeq))
+	}
+	c.lastSeq = msg.Seq
+}
+
+// AssertOrdered fails the test if any message so far arrived out of order
+func (c *Client) AssertOrdered() {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.reorders) > 0 {
+		c.t.Fatalf("wstest: %s received %d messages out of order: %v", c.Username, len(c.reorders), c.reorders)
+	}
+}
+
+// Next returns the next message, failing the test on timeout
+func (c *Client) Next() client.Message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatalf("wstest: %s connection closed while waiting for a message", c.Username)
+		}
+		return msg
+	case <-time.After(c.timeout):
+		c.t.Fatalf("wstest: %s timed out waiting for a message", c.Username)
+	}
+	return client.Message{}
+}
+
+// Expect skips messages until one matches, failing the test on timeout
+func (c *Client) Expect(match func(client.Message) bool) client.Message {
+	c.t.Helper()
+	timeout := time.After(c.timeout)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("wstest: %s connection closed before the expected message", c.Username)
+				return client.Message{}
+			}
+			if match(msg) {
+				return msg
+			}
+		case <-timeout:
+			c.t.Fatalf("wstest: %s never got the expected message", c.Username)
+			return client.Message{}
+		}
+	}
+}
+
+// ExpectType waits for the next message of the given type
+func (c *Client) ExpectType(msgType string) client.Message {
+	c.t.Helper()
+	return c.Expect(func(m client.Message) bool { return m.Type == msgType })
+}
+
+// ExpectChat waits for a chat message from a user with the given content
+func (c *Client) ExpectChat(from, content string) client.Message {
+	c.t.Helper()
+	return c.Expect(func(m client.Message) bool {
+		return m.Type == "chat" && m.Username == from && m.Content == content
+	})
+}
+
+// ExpectJoin waits for the join notification of username
+func (c *Client) ExpectJoin(username string) client.Message {
+	c.t.Helper()
+	return c.Expect(func(m client.Message) bool {
+		return m.Type == "user_joined" && m.Username == username
+	})
+}
+
+// ExpectLeave waits for the leave notification of username
+func (c *Client) ExpectLeave(username string) client.Message {
+	c.t.Helper()
+	return c.Expect(func(m client.Message) bool {
+		return m.Type == "user_left" && m.Username == username
+	})
+}
+
+// ExpectSilence fails the test if any chat message arrives within d
+func (c *Client) ExpectSilence(d time.Duration) {
+	c.t.Helper()
+	timeout := time.After(d)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				return
+			}
+			if msg.Type == "chat" {
+				c.t.Fatalf("wstest: %s expected silence, got %q from %s", c.Username, msg.Content, msg.Username)
+				return
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Replay connects as a recording's user to its room and sends the
+// frames it recorded the client sending, as fast as possible (see the
+// recording package); the returned client then asserts on what arrives
+func (s *Server) Replay(t T, path string) *Client {
+	t.Helper()
+	h, frames, err := recording.Load(path)
+	if err != nil {
+		t.Fatalf("wstest: load recording %s: %v", path, err)
+	}
+	c := s.Connect(t, h.Room, h.Username)
+	err = recording.Replay(context.Background(), frames, 0, func(data []byte) error {
+		return c.conn.Send(string(data))
+	})
+	if err != nil {
+		t.Fatalf("wstest: replay %s: %v", path, err)
+	}
+	return c
+}